@@ -0,0 +1,140 @@
+// Command acl manages the ACL rules of a haraqa server started with -acl.
+//
+//	acl [flags] list
+//	acl [flags] export > acl.json
+//	acl [flags] import acl.json
+//	acl [flags] put [-id id] principal topic permission,...
+//	acl [flags] delete id
+//
+// Rules grant a principal the consume, produce and/or admin permissions on a topic, a topic ending in "*"
+// matches every topic starting with the rest of it. Changes are only made if the rules are still at the
+// version given with -version, use the version printed by list or export to avoid overwriting concurrent
+// changes.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/haraqa"
+)
+
+func main() {
+	var (
+		url      string
+		user     string
+		password string
+		token    string
+		version  int64
+	)
+	flag.StringVar(&url, "url", "http://127.0.0.1:4353", "Url of the haraqa server")
+	flag.StringVar(&user, "user", "", "User to authenticate as with a server started with -users")
+	flag.StringVar(&password, "password", os.Getenv("HARAQA_PASSWORD"), "Password of the user")
+	flag.StringVar(&token, "token", os.Getenv("HARAQA_TOKEN"), "OIDC access token to authenticate with a server started with -oidc-issuer")
+	flag.Int64Var(&version, "version", 0, "Only change the rules if they are at this version, 0 to change them unconditionally")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: acl [flags] list|export|import file|put [-id id] principal topic permissions|delete id")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := []haraqa.Option{haraqa.WithURL(url)}
+	if user != "" {
+		opts = append(opts, haraqa.WithBasicAuth(user, password))
+	}
+	if token != "" {
+		opts = append(opts, haraqa.WithTokenSource(func() (string, error) { return token, nil }))
+	}
+	client, err := haraqa.NewClient(opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "list":
+		acl, err := client.ACL()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("version", acl.Version)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tPRINCIPAL\tTOPIC\tPERMISSIONS")
+		for _, rule := range acl.Rules {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", rule.ID, rule.Principal, rule.Topic, strings.Join(rule.Permissions, ","))
+		}
+		_ = w.Flush()
+	case "export":
+		acl, err := client.ACL()
+		if err != nil {
+			log.Fatal(err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(acl); err != nil {
+			log.Fatal(err)
+		}
+	case "import":
+		if len(args) != 1 {
+			log.Fatal("import requires the file to import, - for stdin")
+		}
+		var b []byte
+		if args[0] == "-" {
+			b, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			b, err = ioutil.ReadFile(args[0])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		var acl headers.ACL
+		if err = json.Unmarshal(b, &acl); err != nil {
+			log.Fatal(err)
+		}
+		imported, err := client.ImportACL(acl, version)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("imported", len(imported.Rules), "rules, version", imported.Version)
+	case "put":
+		put := flag.NewFlagSet("put", flag.ExitOnError)
+		id := put.String("id", "", "Id of the rule to replace, a new rule is added if empty")
+		_ = put.Parse(args)
+		if put.NArg() != 3 {
+			log.Fatal("put requires a principal, topic and comma separated permissions")
+		}
+		rule, newVersion, err := client.PutACLRule(headers.ACLRule{
+			ID:          *id,
+			Principal:   put.Arg(0),
+			Topic:       put.Arg(1),
+			Permissions: strings.Split(put.Arg(2), ","),
+		}, version)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("put rule", rule.ID+", version", newVersion)
+	case "delete":
+		if len(args) != 1 {
+			log.Fatal("delete requires the id of the rule")
+		}
+		newVersion, err := client.DeleteACLRule(args[0], version)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("deleted rule", args[0]+", version", newVersion)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,190 @@
+// Command connect runs connectors which copy messages between a haraqa server and external systems.
+//
+// Connectors are read from a json configuration file:
+//
+//	{
+//	  "offsets": "offsets.json",
+//	  "pollInterval": "1s",
+//	  "connectors": [
+//	    {"name": "archive", "type": "s3", "topics": ["orders"], "config": {"bucket": "archive", "gzip": true}},
+//	    {"name": "orders", "type": "postgres", "topics": ["orders"], "config": {"url": "postgres://127.0.0.1/db", "table": "orders"}}
+//	  ]
+//	}
+//
+// The s3 and postgres sink types are built in, other types can be added by building a copy of this command
+// which registers them with connect.RegisterSink or connect.RegisterSource.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/haraqa/haraqa/pkg/connect"
+	"github.com/haraqa/haraqa/pkg/haraqa"
+	"github.com/haraqa/haraqa/pkg/postgres"
+	"github.com/haraqa/haraqa/pkg/s3"
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+type config struct {
+	Offsets      string                    `json:"offsets"`
+	PollInterval string                    `json:"pollInterval"`
+	BatchSize    int64                     `json:"batchSize"`
+	Connectors   []connect.ConnectorConfig `json:"connectors"`
+}
+
+type s3Config struct {
+	Bucket   string `json:"bucket"`
+	Endpoint string `json:"endpoint"`
+	Region   string `json:"region"`
+	Prefix   string `json:"prefix"`
+	Gzip     bool   `json:"gzip"`
+	MaxSize  int64  `json:"maxSize"`
+	MaxAge   string `json:"maxAge"`
+}
+
+type postgresConfig struct {
+	URL             string   `json:"url"`
+	Table           string   `json:"table"`
+	ConflictColumns []string `json:"conflictColumns"`
+	OffsetsTable    string   `json:"offsetsTable"`
+}
+
+var logger server.Logger
+
+func main() {
+	var (
+		url        string
+		configFile string
+		logLevel   string
+	)
+	flag.StringVar(&url, "url", "http://127.0.0.1:4353", "Url of the haraqa server")
+	flag.StringVar(&configFile, "config", "connect.json", "Json file listing the connectors to run")
+	flag.StringVar(&logLevel, "log", "info", "Log level, one of debug, info, warn or error")
+	flag.Parse()
+
+	level, err := server.ParseLogLevel(logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger = server.NewLogger(os.Stderr, level)
+
+	b, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var cfg config
+	if err = json.Unmarshal(b, &cfg); err != nil {
+		log.Fatal(errors.Wrap(err, "invalid config"))
+	}
+
+	client, err := haraqa.NewClient(haraqa.WithURL(url))
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := []connect.Option{connect.WithLogger(logger)}
+	if cfg.Offsets != "" {
+		store, err := connect.NewFileOffsetStore(cfg.Offsets)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, connect.WithOffsetStore(store))
+	}
+	if cfg.PollInterval != "" {
+		interval, err := time.ParseDuration(cfg.PollInterval)
+		if err != nil {
+			log.Fatal(errors.Wrap(err, "invalid poll interval"))
+		}
+		opts = append(opts, connect.WithPollInterval(interval))
+	}
+	if cfg.BatchSize > 0 {
+		opts = append(opts, connect.WithBatchSize(cfg.BatchSize))
+	}
+	runner, err := connect.NewRunner(connect.NewClientQueue(client), opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	connect.RegisterSink("s3", newS3Sink)
+	connect.RegisterSink("postgres", newPostgresSink)
+	for _, c := range cfg.Connectors {
+		if err = runner.AddConnector(c); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// stop on interrupt so buffered messages are written
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		if err := runner.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	log.Println("Running", len(cfg.Connectors), "connectors against", url)
+	if err = runner.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func newS3Sink(raw json.RawMessage) (connect.Sink, error) {
+	var cfg s3Config
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, errors.Wrap(err, "invalid s3 config")
+	}
+	opts := []s3.Option{
+		s3.WithLogger(logger),
+		s3.WithCredentials(os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")),
+		s3.WithPrefix(cfg.Prefix),
+	}
+	if cfg.Endpoint != "" {
+		opts = append(opts, s3.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Region == "" {
+		cfg.Region = os.Getenv("AWS_REGION")
+	}
+	if cfg.Region != "" {
+		opts = append(opts, s3.WithRegion(cfg.Region))
+	}
+	if cfg.Gzip {
+		opts = append(opts, s3.WithGzip())
+	}
+	if cfg.MaxSize > 0 || cfg.MaxAge != "" {
+		maxSize, maxAge := int64(64<<20), 5*time.Minute
+		if cfg.MaxSize > 0 {
+			maxSize = cfg.MaxSize
+		}
+		if cfg.MaxAge != "" {
+			var err error
+			if maxAge, err = time.ParseDuration(cfg.MaxAge); err != nil {
+				return nil, errors.Wrap(err, "invalid s3 max age")
+			}
+		}
+		opts = append(opts, s3.WithRolling(maxSize, maxAge))
+	}
+	return s3.NewSink(cfg.Bucket, opts...)
+}
+
+func newPostgresSink(raw json.RawMessage) (connect.Sink, error) {
+	var cfg postgresConfig
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, errors.Wrap(err, "invalid postgres config")
+	}
+	opts := []postgres.Option{postgres.WithLogger(logger)}
+	if len(cfg.ConflictColumns) > 0 {
+		opts = append(opts, postgres.WithConflictColumns(cfg.ConflictColumns...))
+	}
+	if cfg.OffsetsTable != "" {
+		opts = append(opts, postgres.WithOffsetsTable(cfg.OffsetsTable))
+	}
+	return postgres.NewSink(cfg.URL, cfg.Table, opts...)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+// adminHandler returns a handler serving the runtime profiling endpoints under /debug/pprof/ and the
+// queue introspection endpoint at /debug/queue and the graphql endpoint at /graphql, if enabled.
+// If auth is given in the form user:password requests must use matching basic auth credentials
+func adminHandler(auth string, pprofEnabled bool, debugQueue, graphql http.HandlerFunc) http.Handler {
+	mux := http.NewServeMux()
+	if pprofEnabled {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	if debugQueue != nil {
+		mux.HandleFunc("/debug/queue", debugQueue)
+	}
+	if graphql != nil {
+		mux.HandleFunc("/graphql", graphql)
+	}
+	if auth == "" {
+		return mux
+	}
+	return basicAuth(auth, mux)
+}
+
+// basicAuth rejects requests which do not have the given user:password basic auth credentials
+func basicAuth(auth string, next http.Handler) http.Handler {
+	wantUser, wantPass := auth, ""
+	if i := strings.IndexByte(auth, ':'); i >= 0 {
+		wantUser, wantPass = auth[:i], auth[i+1:]
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(wantUser)) == 1
+		passMatch := subtle.ConstantTimeCompare([]byte(pass), []byte(wantPass)) == 1
+		if !ok || !userMatch || !passMatch {
+			w.Header().Set("WWW-Authenticate", `Basic realm="haraqa"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ballast is a large allocation which is never touched, raising the heap size at which the garbage
+// collector runs without using physical memory. It is kept in a package variable so it is never collected
+var ballast []byte
+
+// cgroupMemoryFiles hold the memory limit of the container under cgroup v2 and v1
+var cgroupMemoryFiles = []string{
+	"/sys/fs/cgroup/memory.max",
+	"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+}
+
+// defaultBallastSize is used when the memory available to the server is unknown
+const defaultBallastSize = 1 << 30
+
+// autoBallastSize returns the size of the ballast for the memory available to the server. If GOMEMLIMIT
+// is set the runtime already bounds the heap and a ballast would only count against the limit, so none
+// is used. Otherwise the ballast is a quarter of the container's memory limit, or 1GiB without a limit
+func autoBallastSize() int64 {
+	if os.Getenv("GOMEMLIMIT") != "" {
+		return 0
+	}
+	if limit := cgroupMemoryLimit(); limit > 0 {
+		return limit / 4
+	}
+	return defaultBallastSize
+}
+
+// cgroupMemoryLimit returns the memory limit of the container in bytes, 0 if there is no limit
+func cgroupMemoryLimit() int64 {
+	for _, file := range cgroupMemoryFiles {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+		// cgroup v2 reports no limit as "max" and v1 as a number close to the largest int64
+		if err != nil || limit <= 0 || limit >= 1<<62 {
+			return 0
+		}
+		return limit
+	}
+	return 0
+}
@@ -5,4 +5,5 @@ go 1.14
 require (
 	github.com/golang/mock v1.4.3
 	github.com/pkg/errors v0.9.1
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
 )
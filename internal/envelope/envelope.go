@@ -0,0 +1,165 @@
+// Package envelope encodes batches of messages as a single protobuf message, an alternative to the sizes
+// header format of the http api for clients generated from envelope.proto.
+//
+// Messages with a key or headers are stored as a record, the protobuf encoded Message holding the data,
+// key, headers and content type, with the content type RecordContentType. Consumes in either format
+// return the record, which the envelope format and the go client decode back into the message.
+package envelope
+
+import (
+	"sort"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/protowire"
+)
+
+// Media types of the envelope format
+const (
+	// ContentType is the media type of a batch envelope
+	ContentType = "application/x-haraqa-batch+protobuf"
+	// RecordContentType is the content type of messages stored as a record
+	RecordContentType = "application/x-haraqa-record+protobuf"
+)
+
+// ErrMalformed is returned for batches and records which are not valid protobuf
+var ErrMalformed = protowire.ErrMalformed
+
+// Message is a message of a batch along with its metadata. The timestamp, id, topic and offset are set
+// by the server on consume and ignored on produce
+type Message struct {
+	Data        []byte
+	Key         []byte
+	Headers     map[string]string
+	ContentType string
+	Timestamp   time.Time
+	ID          string
+	Topic       string
+	Offset      int64
+}
+
+// IsRecord returns true if the message has a key or headers, which are only kept by storing it as a record
+func (m *Message) IsRecord() bool {
+	return len(m.Key) > 0 || len(m.Headers) > 0
+}
+
+// Marshal encodes the messages as a Batch
+func Marshal(msgs []Message) []byte {
+	var b, msg []byte
+	for i := range msgs {
+		msg = msgs[i].appendTo(msg[:0])
+		b = append(protowire.AppendVarint(protowire.AppendTag(b, 1, protowire.Bytes), uint64(len(msg))), msg...)
+	}
+	return b
+}
+
+// Unmarshal decodes the messages of a Batch. The data, keys and headers of the messages share the
+// memory of b
+func Unmarshal(b []byte) ([]Message, error) {
+	var msgs []Message
+	d := protowire.NewDecoder(b)
+	for field, ok := d.Next(); ok; field, ok = d.Next() {
+		if field != 1 {
+			d.Skip()
+			continue
+		}
+		var msg Message
+		if err := msg.unmarshal(d.Bytes()); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, d.Err()
+}
+
+// Record returns the record of the message, holding its data, key, headers and content type
+func Record(m *Message) []byte {
+	return (&Message{Data: m.Data, Key: m.Key, Headers: m.Headers, ContentType: m.ContentType}).appendTo(nil)
+}
+
+// FromRecord returns the message stored in a record
+func FromRecord(b []byte) (Message, error) {
+	var m Message
+	err := m.unmarshal(b)
+	return m, err
+}
+
+// appendTo appends the protobuf encoding of the message, headers are sorted by name
+func (m *Message) appendTo(b []byte) []byte {
+	b = protowire.AppendBytes(b, 1, m.Data)
+	if len(m.Key) > 0 {
+		b = protowire.AppendBytes(b, 2, m.Key)
+	}
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := m.Headers[name]
+		entry := protowire.AppendString(protowire.AppendString(nil, 1, name), 2, value)
+		b = protowire.AppendBytes(b, 3, entry)
+	}
+	b = protowire.AppendString(b, 4, m.ContentType)
+	if !m.Timestamp.IsZero() {
+		b = protowire.AppendVarint(protowire.AppendTag(b, 5, protowire.Varint), uint64(m.Timestamp.UnixNano()))
+	}
+	b = protowire.AppendString(b, 6, m.ID)
+	b = protowire.AppendString(b, 7, m.Topic)
+	return protowire.AppendInt(b, 8, m.Offset)
+}
+
+func (m *Message) unmarshal(b []byte) error {
+	d := protowire.NewDecoder(b)
+	for field, ok := d.Next(); ok; field, ok = d.Next() {
+		switch field {
+		case 1:
+			m.Data = d.Bytes()
+		case 2:
+			m.Key = d.Bytes()
+		case 3:
+			name, value := readEntry(d)
+			if d.Err() == nil {
+				if m.Headers == nil {
+					m.Headers = make(map[string]string)
+				}
+				m.Headers[name] = value
+			}
+		case 4:
+			m.ContentType = string(d.Bytes())
+		case 5:
+			m.Timestamp = time.Unix(0, int64(d.Varint()))
+		case 6:
+			m.ID = string(d.Bytes())
+		case 7:
+			m.Topic = string(d.Bytes())
+		case 8:
+			m.Offset = int64(d.Varint())
+		default:
+			d.Skip()
+		}
+	}
+	if m.Data == nil && d.Err() == nil {
+		m.Data = []byte{}
+	}
+	return d.Err()
+}
+
+// readEntry reads the name and value of a map<string, string> entry
+func readEntry(d *protowire.Decoder) (string, string) {
+	e := protowire.NewDecoder(d.Bytes())
+	var name, value string
+	for field, ok := e.Next(); ok; field, ok = e.Next() {
+		switch field {
+		case 1:
+			name = string(e.Bytes())
+		case 2:
+			value = string(e.Bytes())
+		default:
+			e.Skip()
+		}
+	}
+	if e.Err() != nil {
+		d.Fail()
+	}
+	return name, value
+}
@@ -0,0 +1,48 @@
+package envelope
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMarshal(t *testing.T) {
+	now := time.Unix(0, time.Now().UnixNano())
+	msgs := []Message{
+		{Data: []byte("hello"), ContentType: "text/plain", Timestamp: now, ID: "id", Topic: "topic", Offset: 7},
+		{Data: []byte{}, Key: []byte("key"), Headers: map[string]string{"b": "2", "a": ""}},
+	}
+	b := Marshal(msgs)
+	got, err := Unmarshal(b)
+	if err != nil || !reflect.DeepEqual(got, msgs) {
+		t.Fatal(got, err)
+	}
+	if got, err = Unmarshal(nil); err != nil || len(got) != 0 {
+		t.Fatal(got, err)
+	}
+	for _, invalid := range [][]byte{b[:len(b)-1], {0x0a, 0x02, 0x0a}, {0x08}, {0x0a, 0x02, 0x1a, 0x01}} {
+		if _, err = Unmarshal(invalid); err != ErrMalformed {
+			t.Errorf("expected malformed error for %x, got %v", invalid, err)
+		}
+	}
+
+	// unknown fields are skipped
+	b = append(Marshal(msgs[:1]), 0x10, 0x01, 0x1d, 0, 0, 0, 0)
+	if got, err = Unmarshal(b); err != nil || !reflect.DeepEqual(got, msgs[:1]) {
+		t.Fatal(got, err)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	msg := &Message{Data: []byte("hello"), Key: []byte("key"), Headers: map[string]string{"a": "1"}, ContentType: "text/plain", ID: "ignored"}
+	if !msg.IsRecord() || (&Message{Data: []byte("hello")}).IsRecord() {
+		t.Fatal("unexpected IsRecord")
+	}
+	got, err := FromRecord(Record(msg))
+	if err != nil || string(got.Data) != "hello" || string(got.Key) != "key" || got.Headers["a"] != "1" || got.ContentType != "text/plain" || got.ID != "" {
+		t.Fatal(got, err)
+	}
+	if _, err = FromRecord([]byte{0xff}); err != ErrMalformed {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,102 @@
+package filequeue
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultBufferSize is the size of the buffers allocated by the buffer pool
+	defaultBufferSize = 32 << 10
+	// defaultMaxBufferSize is the largest buffer retained by the buffer pool
+	defaultMaxBufferSize = 1 << 20
+)
+
+// defaultBuffers is the buffer pool used by file sets and writers created outside of a queue
+var defaultBuffers = newBufferPool(defaultBufferSize, defaultMaxBufferSize, true)
+
+// bufferPool reuses the buffers used to write messages. Buffers larger than max are allocated for a single
+// write and released to the garbage collector, so that one large batch does not leave every pooled buffer
+// at its size. With size classes, buffers are pooled by powers of two from size up to max so that small
+// writes do not hold on to large buffers
+type bufferPool struct {
+	size    int
+	max     int
+	classes []sync.Pool
+}
+
+// newBufferPool creates a pool of buffers of at least size bytes, retaining buffers of up to max bytes
+func newBufferPool(size, max int, sizeClasses bool) *bufferPool {
+	p := &bufferPool{size: size, max: max}
+	n := 1
+	if sizeClasses {
+		for s := size; s < max; s *= 2 {
+			n++
+		}
+	}
+	p.classes = make([]sync.Pool, n)
+	return p
+}
+
+// classSize returns the smallest size of the buffers in the class
+func (p *bufferPool) classSize(i int) int {
+	if size := p.size << uint(i); size < p.max {
+		return size
+	}
+	return p.max
+}
+
+// get returns a buffer of length n
+func (p *bufferPool) get(n int) []byte {
+	if n > p.max {
+		return make([]byte, n)
+	}
+	i := 0
+	for p.classSize(i) < n && i < len(p.classes)-1 {
+		i++
+	}
+	if b, ok := p.classes[i].Get().(*[]byte); ok && cap(*b) >= n {
+		return (*b)[:n]
+	}
+	size := p.classSize(i)
+	if size < n {
+		size = n
+	}
+	return make([]byte, n, size)
+}
+
+// put returns a buffer to the largest class it fills, unless it is larger than the largest buffer retained
+func (p *bufferPool) put(b []byte) {
+	if cap(b) > p.max || cap(b) < p.size {
+		return
+	}
+	i := len(p.classes) - 1
+	for p.classSize(i) > cap(b) {
+		i--
+	}
+	b = b[:0]
+	p.classes[i].Put(&b)
+}
+
+// SetBufferPool sets the size of the buffers used to write messages and the largest buffer kept for
+// reuse, buffers needed for larger batches are released after the write. With size classes buffers are
+// pooled separately by powers of two between size and max
+func (q *FileQueue) SetBufferPool(size, max int, sizeClasses bool) error {
+	if size <= 0 {
+		return errors.New("invalid buffer size, value must be greater than 0")
+	}
+	if max < size {
+		return errors.New("invalid max buffer size, value must be at least the buffer size")
+	}
+	q.buffers.Store(newBufferPool(size, max, sizeClasses))
+	return nil
+}
+
+// bufferPool returns the buffer pool of the queue
+func (q *FileQueue) bufferPool() *bufferPool {
+	if p, ok := q.buffers.Load().(*bufferPool); ok {
+		return p
+	}
+	return defaultBuffers
+}
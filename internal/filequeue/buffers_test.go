@@ -0,0 +1,64 @@
+package filequeue
+
+import (
+	"os"
+	"testing"
+)
+
+func TestBufferPool(t *testing.T) {
+	p := newBufferPool(16, 100, true)
+	if len(p.classes) != 4 {
+		t.Fatal(len(p.classes))
+	}
+	for _, tc := range []struct{ n, cap int }{{1, 16}, {16, 16}, {17, 32}, {64, 64}, {65, 100}, {100, 100}, {101, 101}} {
+		b := p.get(tc.n)
+		if len(b) != tc.n || cap(b) != tc.cap {
+			t.Error(tc.n, len(b), cap(b))
+		}
+		p.put(b)
+	}
+
+	// buffers are only returned from classes they fill
+	p.put(make([]byte, 0, 40))
+	if b := p.get(64); len(b) != 64 || cap(b) < 64 {
+		t.Fatal(len(b), cap(b))
+	}
+
+	// without size classes every buffer up to the max shares a pool
+	p = newBufferPool(16, 100, false)
+	if len(p.classes) != 1 {
+		t.Fatal(len(p.classes))
+	}
+	if b := p.get(50); len(b) != 50 || cap(b) != 50 {
+		t.Fatal(len(b), cap(b))
+	}
+	if b := p.get(1); len(b) != 1 || cap(b) != 16 {
+		t.Fatal(len(b), cap(b))
+	}
+}
+
+func TestFileQueue_SetBufferPool(t *testing.T) {
+	dir := ".haraqa-buffers"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	q, err := New(true, 5000, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if q.bufferPool() != defaultBuffers {
+		t.Fatal("expected the default buffer pool")
+	}
+	if err = q.SetBufferPool(0, 10, true); err == nil {
+		t.Error("expected invalid buffer size error")
+	}
+	if err = q.SetBufferPool(10, 5, true); err == nil {
+		t.Error("expected invalid max buffer size error")
+	}
+	if err = q.SetBufferPool(1024, 4096, false); err != nil {
+		t.Fatal(err)
+	}
+	if p := q.bufferPool(); p.size != 1024 || p.max != 4096 || len(p.classes) != 1 {
+		t.Fatal(p)
+	}
+}
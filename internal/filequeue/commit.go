@@ -0,0 +1,131 @@
+package filequeue
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// produceRequest is a produce waiting to be written and synced by a group commit
+type produceRequest struct {
+	ctx       context.Context
+	msgSizes  []int64
+	ids       []headers.MessageID
+	types     []string
+	timestamp uint64
+	r         io.Reader
+	done      bool
+	err       error
+}
+
+// commitGroup holds the produces to a topic waiting for the next group commit
+type commitGroup struct {
+	mux     sync.Mutex
+	pending []*produceRequest
+}
+
+// SetSync enables syncing produced messages to disk before a produce returns. Produces to a topic which
+// arrive while a sync is in progress are written together and share a single sync once it completes, so
+// the cost of each sync is spread across all of the concurrent producers
+func (q *FileQueue) SetSync(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&q.sync, v)
+}
+
+// commitGroup returns the group commit of the topic
+func (q *FileQueue) commitGroup(topic string) *commitGroup {
+	gc, ok := q.commits.Load(topic)
+	if !ok {
+		gc, _ = q.commits.LoadOrStore(topic, &commitGroup{})
+	}
+	return gc.(*commitGroup)
+}
+
+// produceSync queues the produce for the topic's next group commit. The first producer to take the
+// produce lock writes and syncs every queued produce, later producers find their produce already done
+func (q *FileQueue) produceSync(ctx context.Context, topic string, req *produceRequest) error {
+	gc := q.commitGroup(topic)
+	gc.mux.Lock()
+	gc.pending = append(gc.pending, req)
+	gc.mux.Unlock()
+
+	mux := q.produceLock(topic)
+	mux.Lock()
+	defer mux.Unlock()
+	if req.done {
+		return req.err
+	}
+
+	gc.mux.Lock()
+	batch := gc.pending
+	gc.pending = nil
+	gc.mux.Unlock()
+
+	q.commit(topic, batch)
+	return req.err
+}
+
+// commit writes the batch of produces and syncs the file sets written to, the produce lock of the topic
+// must be held
+func (q *FileQueue) commit(topic string, batch []*produceRequest) {
+	var files []*ProduceFile
+	var written []*produceRequest
+	flush := func() {
+		var err error
+		for _, pf := range files {
+			if e := pf.Sync(); e != nil {
+				err = diskFullError(errors.Wrap(e, "sync producer file error"))
+			}
+			q.releaseProduceFile(topic, pf)
+		}
+		if len(files) > 0 {
+			atomic.AddInt64(&q.stats.syncs, 1)
+		}
+		for _, req := range written {
+			req.err = err
+		}
+		files, written = nil, nil
+	}
+
+	for _, req := range batch {
+		req.done = true
+
+		// the request may have been abandoned while waiting for other produces to the topic
+		if req.err = req.ctx.Err(); req.err != nil {
+			continue
+		}
+
+		// a full file set is closed by the next write to the topic, so it is synced first
+		if len(files) > 0 && q.rollDue(files[len(files)-1], int64(req.timestamp)) {
+			flush()
+		}
+
+		pf, err := q.writeProduceFile(topic, req.msgSizes, req.ids, req.types, req.timestamp, req.r)
+		if err != nil {
+			req.err = err
+			continue
+		}
+		written = append(written, req)
+		if len(files) == 0 || files[len(files)-1] != pf {
+			files = append(files, pf)
+		}
+	}
+	flush()
+}
+
+// Sync flushes the logs, ids, types and dats of the file set to disk
+func (pf *ProduceFile) Sync() error {
+	for _, mw := range []MultiWriteAtCloser{pf.Logs, pf.IDs, pf.Types, pf.Dats} {
+		if err := mw.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package filequeue
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileQueue_GroupCommit(t *testing.T) {
+	dir := ".haraqa-commit"
+	topic := "commit"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	q, err := New(true, 2, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+	q.SetSync(true)
+
+	// hold the produce lock so that every produce joins the same group commit
+	mux := q.produceLock(topic)
+	mux.Lock()
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	var wg sync.WaitGroup
+	errs := make([]error, 6)
+	for i := range errs {
+		ctx := context.Background()
+		if i == 5 {
+			ctx = cancelled
+		}
+		wg.Add(1)
+		go func(ctx context.Context, i int) {
+			defer wg.Done()
+			errs[i] = q.Produce(ctx, topic, []int64{1}, uint64(time.Now().UnixNano()), bytes.NewBufferString(strconv.Itoa(i)))
+		}(ctx, i)
+	}
+	gc := q.commitGroup(topic)
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		gc.mux.Lock()
+		n := len(gc.pending)
+		gc.mux.Unlock()
+		if n == len(errs) {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("timed out")
+		}
+	}
+	mux.Unlock()
+	wg.Wait()
+
+	for i, err := range errs {
+		if i == 5 {
+			if err != context.Canceled {
+				t.Error(err)
+			}
+		} else if err != nil {
+			t.Error(err)
+		}
+	}
+
+	// the five messages span three file sets, each synced once
+	if n := q.CacheStats().Syncs; n != 3 {
+		t.Fatal(n)
+	}
+	w := httptest.NewRecorder()
+	if n, err := q.Consume(context.Background(), topic, 0, 5, w); err != nil || n != 5 {
+		t.Fatal(n, err)
+	}
+	got := []byte(w.Body.String())
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+	if string(got) != "01234" {
+		t.Fatal(string(got))
+	}
+
+	// a single produce is synced on its own
+	if err = q.Produce(context.Background(), topic, []int64{1}, uint64(time.Now().UnixNano()), bytes.NewBufferString("5")); err != nil {
+		t.Fatal(err)
+	}
+	if n := q.CacheStats().Syncs; n != 4 {
+		t.Fatal(n)
+	}
+
+	// produces are not synced once syncing is disabled
+	q.SetSync(false)
+	if err = q.Produce(context.Background(), topic, []int64{1}, uint64(time.Now().UnixNano()), bytes.NewBufferString("6")); err != nil {
+		t.Fatal(err)
+	}
+	if n := q.CacheStats().Syncs; n != 4 {
+		t.Fatal(n)
+	}
+}
+
+func TestProduceFile_Sync(t *testing.T) {
+	dir := ".haraqa-commit-sync"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(dir + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf := &ProduceFile{Dats: MultiWriteAtCloser{f}, Logs: MultiWriteAtCloser{f}}
+	if err = pf.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	if err = pf.Sync(); err == nil {
+		t.Fatal("expected closed file error")
+	}
+}
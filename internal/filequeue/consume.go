@@ -1,89 +1,168 @@
 package filequeue
 
 import (
+	"context"
 	"encoding/binary"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/haraqa/haraqa/internal/headers"
 	"github.com/pkg/errors"
 )
 
-// Consume copies messages from a log to the writer
-func (q *FileQueue) Consume(topic string, id int64, limit int64, w http.ResponseWriter) (int, error) {
-	datName, err := getConsumeDat(q.consumeNameCache, filepath.Join(q.rootDirNames[len(q.rootDirNames)-1], topic), topic, id)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return 0, headers.ErrTopicDoesNotExist
-		}
-		return 0, errors.Wrap(err, "unable to get consume dat filename")
+// Consume copies messages from a log to the writer. Nothing is read if the context is done before the
+// files are opened
+func (q *FileQueue) Consume(ctx context.Context, topic string, id int64, limit int64, w http.ResponseWriter) (int, error) {
+	if isTrash(topic) {
+		return 0, headers.ErrInvalidTopic
 	}
-	path := filepath.Join(q.rootDirNames[len(q.rootDirNames)-1], topic, datName)
-	dat, err := os.Open(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return 0, nil
-		}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	dat, log, err := q.openConsumeFiles(topic, id)
+	if err != nil || dat == nil {
 		return 0, err
 	}
-	defer dat.Close()
+	defer func() {
+		_ = dat.Close()
+		_ = log.Close()
+		q.releaseFiles(2)
+	}()
 
 	stat, err := dat.Stat()
 	if err != nil {
 		return 0, err
 	}
 
-	// check if id was less than 0
+	// the dat is named after the offset of its first entry, which need not be a multiple of the
+	// maximum entries once a file set is rolled early or a topic is purged
+	base, err := strconv.ParseInt(stat.Name(), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	entries := stat.Size() / datEntryLength
+	index := id - base
 	if id < 0 {
-		id = stat.Size()/datEntryLength - 1
-		if id < 0 {
-			return 0, nil
-		}
+		index = entries - 1
 	}
-
-	if id > stat.Size()/datEntryLength-1 {
-		base, err := strconv.ParseInt(stat.Name(), 10, 64)
-		if err != nil {
-			return 0, err
-		}
-		id = id - base
-		if id > stat.Size()/datEntryLength-1 {
-			return 0, nil
-		}
+	if index < 0 || index > entries-1 {
+		return 0, nil
 	}
 
 	if limit < 0 {
-		limit = (stat.Size() - id*datEntryLength) / datEntryLength
+		limit = entries - index
+	}
+
+	// read the following file sets in parallel if the limit extends past the end of this one
+	if limit > entries-index {
+		if following := q.openSegments(topic, base+entries, limit-(entries-index)); len(following) > 0 {
+			return q.consumeSegments(w, dat, log, index, entries-index, following)
+		}
 	}
 
 	data := make([]byte, limit*datEntryLength)
-	length, err := dat.ReadAt(data, id*datEntryLength)
+	length, err := dat.ReadAt(data, index*datEntryLength)
 	if err != nil && length == 0 {
 		return 0, err
 	}
 	limit = int64(length) / datEntryLength
 
-	return q.consumeResponse(w, data, limit, path+".log")
+	ids, err := readIDs(dat.Name()+".ids", index, limit)
+	if err != nil {
+		return 0, err
+	}
+	types, err := readTypes(dat.Name()+".types", data[:limit*datEntryLength], index, limit)
+	if err != nil {
+		return 0, err
+	}
+	n, err := q.consumeResponse(w, data, ids, types, limit, log)
+	if err == nil && n > 0 {
+		q.prefetch(topic, dat.Name(), index, int64(n), entries)
+	}
+	return n, err
+}
+
+// readIDs reads the ids of limit messages starting from the entry at index, nil is returned if the
+// messages in the file set were produced without ids
+func readIDs(path string, index, limit int64) ([]headers.MessageID, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	// entries past the end of the file were produced without an id and are left as zero
+	b := make([]byte, limit*idEntryLength)
+	if _, err = f.ReadAt(b, index*idEntryLength); err != nil && err != io.EOF {
+		return nil, err
+	}
+	ids := make([]headers.MessageID, limit)
+	for i := range ids {
+		copy(ids[i][:], b[i*idEntryLength:])
+	}
+	return ids, nil
+}
+
+// openConsumeFiles opens the dat file containing the id and its log. The topic is read locked so that
+// both files are opened before a truncation can remove them, nil files are returned if there is no dat
+func (q *FileQueue) openConsumeFiles(topic string, id int64) (*os.File, *os.File, error) {
+	mux := q.topicLock(topic)
+	mux.RLock()
+	defer mux.RUnlock()
+
+	datName, err := q.getConsumeDat(filepath.Join(q.rootDirNames[len(q.rootDirNames)-1], topic), topic, id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, headers.ErrTopicDoesNotExist
+		}
+		return nil, nil, errors.Wrap(err, "unable to get consume dat filename")
+	}
+	path := filepath.Join(q.rootDirNames[len(q.rootDirNames)-1], topic, datName)
+	dat, err := q.openFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	// the log may not exist yet if a produce is creating a new file set
+	log, err := q.openFile(path+".log", os.O_RDONLY, 0)
+	if err != nil {
+		_ = dat.Close()
+		q.releaseFiles(1)
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	return dat, log, nil
 }
 
-func getConsumeDat(consumeNameCache *sync.Map, path string, topic string, id int64) (string, error) {
+func (q *FileQueue) getConsumeDat(path string, topic string, id int64) (string, error) {
 	exact := formatName(id)
-	if consumeNameCache != nil {
-		value, ok := consumeNameCache.Load(topic)
+	if q.consumeNameCache != nil {
+		value, ok := q.consumeNameCache.Load(topic)
 		if ok {
 			names := value.([]string)
 			for i := range names {
 				if len(names[i]) == len(exact) && names[i] <= exact {
+					atomic.AddInt64(&q.stats.consumeHits, 1)
 					return names[i], nil
 				}
 			}
 		}
 	}
+	atomic.AddInt64(&q.stats.consumeMisses, 1)
 
 	dir, err := os.Open(path)
 	if err != nil {
@@ -96,8 +175,8 @@ func getConsumeDat(consumeNameCache *sync.Map, path string, topic string, id int
 		return "", err
 	}
 	sort.Sort(sortableDirNames(names))
-	if consumeNameCache != nil {
-		consumeNameCache.Store(topic, names)
+	if q.consumeNameCache != nil {
+		q.consumeNameCache.Store(topic, names)
 	}
 	if id < 0 && len(names) > 0 && len(names[0]) == len(exact) {
 		return names[0], nil
@@ -117,36 +196,33 @@ var reqPool = sync.Pool{
 	},
 }
 
-func (q *FileQueue) consumeResponse(w http.ResponseWriter, data []byte, limit int64, filename string) (int, error) {
+func (q *FileQueue) consumeResponse(w http.ResponseWriter, data []byte, ids []headers.MessageID, types []string, limit int64, f *os.File) (int, error) {
+	if err := checkEntries(data); err != nil {
+		return 0, err
+	}
 	sizes := make([]int64, limit)
-	startTime := time.Unix(int64(binary.LittleEndian.Uint64(data[8:])), 0)
-	endTime := startTime
+	timestamps := make([]time.Time, limit)
 	startAt := binary.LittleEndian.Uint64(data[16:])
 	endAt := startAt
 	for i := range sizes {
-		size := binary.LittleEndian.Uint64(data[i*datEntryLength+24:])
+		size := entrySize(data[i*datEntryLength:])
 		sizes[i] = int64(size)
+		timestamps[i] = entryTime(binary.LittleEndian.Uint64(data[i*datEntryLength+8:]))
 		endAt += size
-		if i == len(sizes)-1 {
-			endTime = time.Unix(int64(binary.LittleEndian.Uint64(data[i*datEntryLength+8:])), 0)
-		}
 	}
 	endAt--
+	endTime := timestamps[len(timestamps)-1]
 
-	f, err := os.Open(filename)
-	if err != nil {
-		return 0, err
-	}
-	defer f.Close()
-
+	filename := f.Name()
 	wHeader := w.Header()
-	wHeader[headers.HeaderStartTime] = []string{startTime.Format(time.ANSIC)}
-	wHeader[headers.HeaderEndTime] = []string{endTime.Format(time.ANSIC)}
-	wHeader[headers.HeaderFileName] = []string{filename}
-	wHeader[headers.ContentType] = []string{"application/octet-stream"}
-	headers.SetSizes(sizes, wHeader)
-	rangeHeader := "bytes=" + strconv.FormatUint(startAt, 10) + "-" + strconv.FormatUint(endAt, 10)
-	wHeader["Range"] = []string{rangeHeader}
+	single := setConsumeHeaders(wHeader, filename, sizes, timestamps, ids, types, 1)
+	b := make([]byte, 0, 48)
+	b = append(b, "bytes="...)
+	b = strconv.AppendUint(b, startAt, 10)
+	b = append(b, '-')
+	b = strconv.AppendUint(b, endAt, 10)
+	single[0] = string(b)
+	wHeader["Range"] = single[0:1:1]
 
 	req := reqPool.Get().(*http.Request)
 	req.Header = wHeader
@@ -154,3 +230,39 @@ func (q *FileQueue) consumeResponse(w http.ResponseWriter, data []byte, limit in
 	reqPool.Put(req)
 	return len(sizes), nil
 }
+
+// setConsumeHeaders sets the metadata headers of a consume response. The single valued headers share one
+// slice, which is extended by extra values for the caller to set
+func setConsumeHeaders(h http.Header, filename string, sizes []int64, timestamps []time.Time, ids []headers.MessageID, types []string, extra int) []string {
+	single := make([]string, 4+extra)
+	single[0] = timestamps[0].Format(time.ANSIC)
+	single[1] = timestamps[len(timestamps)-1].Format(time.ANSIC)
+	single[2] = filename
+	single[3] = "application/octet-stream"
+	h[headers.HeaderStartTime] = single[0:1:1]
+	h[headers.HeaderEndTime] = single[1:2:2]
+	h[headers.HeaderFileName] = single[2:3:3]
+	h[headers.ContentType] = single[3:4:4]
+	headers.SetSizes(sizes, h)
+	headers.SetTimestamps(timestamps, h)
+	if ids != nil {
+		headers.SetMessageIDs(ids, h)
+	}
+	if types != nil {
+		headers.SetContentTypes(types, h)
+	}
+	return single[4:]
+}
+
+// maxUnixSeconds is the largest timestamp written in seconds by earlier versions, later versions write
+// nanoseconds which are always larger for any time after 1970-01-01T00:18:19Z
+const maxUnixSeconds = 1 << 40
+
+// entryTime returns the time a dat entry was produced, accepting both unix nanoseconds and the
+// unix seconds written by earlier versions
+func entryTime(timestamp uint64) time.Time {
+	if timestamp < maxUnixSeconds {
+		return time.Unix(int64(timestamp), 0)
+	}
+	return time.Unix(0, int64(timestamp))
+}
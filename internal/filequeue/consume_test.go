@@ -2,6 +2,7 @@ package filequeue
 
 import (
 	"bytes"
+	"context"
 	"io/ioutil"
 	"net/http/httptest"
 	"os"
@@ -28,7 +29,7 @@ func TestFileQueue_Consume(t *testing.T) {
 	}()
 
 	// topic doesn't exist
-	_, err = q.Consume(topic, 0, -1, nil)
+	_, err = q.Consume(context.Background(), topic, 0, -1, nil)
 	if !errors.Is(err, headers.ErrTopicDoesNotExist) {
 		t.Error(err)
 	}
@@ -52,13 +53,13 @@ func TestFileQueue_Consume(t *testing.T) {
 	if err = q.CreateTopic(topic); err != nil {
 		t.Error(err)
 	}
-	if err = q.Produce(topic, msgSizes, uint64(time.Now().Unix()), r); err != nil {
+	if err = q.Produce(context.Background(), topic, msgSizes, uint64(time.Now().Unix()), r); err != nil {
 		t.Error(err)
 	}
 	// consume
 	{
 		w := httptest.NewRecorder()
-		n, err := q.Consume(topic, 0, -1, w)
+		n, err := q.Consume(context.Background(), topic, 0, -1, w)
 		if err != nil {
 			t.Error(err)
 		}
@@ -89,7 +90,7 @@ func TestFileQueue_Consume(t *testing.T) {
 	// consume again w/cache
 	{
 		w := httptest.NewRecorder()
-		n, err := q.Consume(topic, 0, 2, w)
+		n, err := q.Consume(context.Background(), topic, 0, 2, w)
 		if err != nil {
 			t.Error(err)
 		}
@@ -119,7 +120,7 @@ func TestFileQueue_Consume(t *testing.T) {
 	// consume again w/offset
 	{
 		w := httptest.NewRecorder()
-		n, err := q.Consume(topic, 2, -1, w)
+		n, err := q.Consume(context.Background(), topic, 2, -1, w)
 		if err != nil {
 			t.Error(err)
 		}
@@ -149,7 +150,7 @@ func TestFileQueue_Consume(t *testing.T) {
 	// consume just the last
 	{
 		w := httptest.NewRecorder()
-		n, err := q.Consume(topic, -1, -1, w)
+		n, err := q.Consume(context.Background(), topic, -1, -1, w)
 		if err != nil {
 			t.Error(err)
 		}
@@ -182,12 +183,12 @@ func TestFileQueue_Consume(t *testing.T) {
 		if _, err = r.Write(newInput); err != nil {
 			t.Error(err)
 		}
-		err = q.Produce(topic, []int64{int64(len(newInput))}, 0, r)
+		err = q.Produce(context.Background(), topic, []int64{int64(len(newInput))}, 0, r)
 		if err != nil {
 			t.Error(err)
 		}
 		w := httptest.NewRecorder()
-		n, err := q.Consume(topic, int64(len(inputs)), -1, w)
+		n, err := q.Consume(context.Background(), topic, int64(len(inputs)), -1, w)
 		if err != nil {
 			t.Error(err)
 		}
@@ -214,3 +215,43 @@ func TestFileQueue_Consume(t *testing.T) {
 		}
 	}
 }
+
+func TestFileQueue_ConsumeTimestamps(t *testing.T) {
+	topic := "timestamps"
+	dir := ".haraqa-timestamps"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	q, err := New(true, 5000, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+
+	// timestamps in seconds were written by earlier versions
+	legacy := time.Unix(1600000000, 0)
+	now := time.Now()
+	if err = q.Produce(context.Background(), topic, []int64{1}, uint64(legacy.Unix()), bytes.NewBufferString("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Produce(context.Background(), topic, []int64{1, 1}, uint64(now.UnixNano()), bytes.NewBufferString("bc")); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	if _, err = q.Consume(context.Background(), topic, 0, -1, w); err != nil {
+		t.Fatal(err)
+	}
+	timestamps, err := headers.ReadTimestamps(w.Header())
+	if err != nil || len(timestamps) != 3 {
+		t.Fatal(timestamps, err)
+	}
+	if !timestamps[0].Equal(legacy) || !timestamps[1].Equal(now) || !timestamps[2].Equal(now) {
+		t.Fatal(timestamps)
+	}
+	if w.Header().Get(headers.HeaderStartTime) != legacy.Format(time.ANSIC) || w.Header().Get(headers.HeaderEndTime) != now.Format(time.ANSIC) {
+		t.Fatal(w.Header())
+	}
+}
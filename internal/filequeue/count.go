@@ -0,0 +1,55 @@
+package filequeue
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// CountMessages returns the number of messages of the topic with offsets from from up to but excluding to,
+// counted from the entries of the dat files so that file sets removed from the middle of the topic are not
+// counted. Deleted messages keep their entries and are counted as the empty messages they are consumed as
+func (q *FileQueue) CountMessages(topic string, from, to int64) (int64, error) {
+	if isTrash(topic) {
+		return 0, headers.ErrInvalidTopic
+	}
+	topicPath := filepath.Join(q.rootDirNames[len(q.rootDirNames)-1], topic)
+	dir, err := osOpen(topicPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, headers.ErrTopicDoesNotExist
+		}
+		return 0, errors.Wrapf(err, "unable to open topic %q", topic)
+	}
+	defer dir.Close()
+	infos, err := dir.Readdir(-1)
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to read topic %q", topic)
+	}
+
+	var count int64
+	for _, info := range infos {
+		if info.IsDir() || strings.ContainsRune(info.Name(), '.') {
+			continue
+		}
+		base, err := strconv.ParseInt(info.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		start, end := base, base+info.Size()/datEntryLength
+		if start < from {
+			start = from
+		}
+		if end > to {
+			end = to
+		}
+		if end > start {
+			count += end - start
+		}
+	}
+	return count, nil
+}
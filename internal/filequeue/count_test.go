@@ -0,0 +1,51 @@
+package filequeue
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestFileQueue_CountMessages(t *testing.T) {
+	dir := ".haraqa-count"
+	topic := "counted"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	q, err := New(true, 3, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if _, err = q.CountMessages(topic, 0, 10); err != headers.ErrTopicDoesNotExist {
+		t.Fatal(err)
+	}
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		if err = q.Produce(ctx, topic, []int64{5}, uint64(time.Now().UnixNano()), bytes.NewBufferString("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, r := range [][3]int64{{0, 8, 8}, {0, 100, 8}, {2, 7, 5}, {4, 5, 1}, {5, 5, 0}, {6, 2, 0}, {8, 20, 0}} {
+		if n, err := q.CountMessages(topic, r[0], r[1]); err != nil || n != r[2] {
+			t.Fatal(r, n, err)
+		}
+	}
+
+	// messages of removed file sets are not counted
+	if err = os.Remove(filepath.Join(dir, topic, formatName(3))); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := q.CountMessages(topic, 0, 8); err != nil || n != 5 {
+		t.Fatal(n, err)
+	}
+}
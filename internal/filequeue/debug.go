@@ -0,0 +1,60 @@
+package filequeue
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+// DebugInfo returns a snapshot of the open files, cached producer write offsets and cached consume file names
+func (q *FileQueue) DebugInfo() headers.QueueDebug {
+	info := headers.QueueDebug{
+		Stats:        q.CacheStats(),
+		OpenFiles:    []string{},
+		Producers:    make(map[string]headers.ProducerDebug),
+		ConsumeNames: make(map[string][]string),
+	}
+	if q.produceCache != nil {
+		q.produceCache.Range(func(key, value interface{}) bool {
+			topic, _ := key.(string)
+			pf, ok := value.(*ProduceFile)
+			if !ok {
+				return true
+			}
+			if lock, ok := q.produceLocks.Load(key); ok {
+				lock.(*sync.Mutex).Lock()
+				defer lock.(*sync.Mutex).Unlock()
+			}
+			producer := headers.ProducerDebug{
+				NextID:    pf.NextID,
+				DatOffset: pf.CurrentDatOffset,
+				LogOffset: pf.CurrentLogOffset,
+			}
+			for _, files := range []MultiWriteAtCloser{pf.Dats, pf.Logs, pf.IDs, pf.Types} {
+				for _, f := range files {
+					if named, ok := f.(interface{ Name() string }); ok {
+						info.OpenFiles = append(info.OpenFiles, named.Name())
+					}
+				}
+			}
+			if len(pf.Dats) > 0 {
+				if named, ok := pf.Dats[len(pf.Dats)-1].(interface{ Name() string }); ok {
+					producer.File = named.Name()
+				}
+			}
+			info.Producers[topic] = producer
+			return true
+		})
+	}
+	if q.consumeNameCache != nil {
+		q.consumeNameCache.Range(func(key, value interface{}) bool {
+			topic, _ := key.(string)
+			names, _ := value.([]string)
+			info.ConsumeNames[topic] = append([]string(nil), names...)
+			return true
+		})
+	}
+	sort.Strings(info.OpenFiles)
+	return info
+}
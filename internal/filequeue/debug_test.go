@@ -0,0 +1,64 @@
+package filequeue
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestFileQueue_DebugInfo(t *testing.T) {
+	dir := ".haraqa-debug"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	q, err := New(true, 5000, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if err = q.CreateTopic("debug"); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Produce(context.Background(), "debug", []int64{1, 2}, 0, bytes.NewBufferString("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = q.Consume(context.Background(), "debug", 0, 1, httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+
+	info := q.DebugInfo()
+	dat := filepath.Join(dir, "debug", formatName(0))
+	if !reflect.DeepEqual(info.OpenFiles, []string{dat, dat + ".log"}) {
+		t.Error(info.OpenFiles)
+	}
+	expected := headers.ProducerDebug{File: dat, NextID: 2, DatOffset: 2 * datEntryLength, LogOffset: 3}
+	if info.Producers["debug"] != expected {
+		t.Errorf("%+v", info.Producers)
+	}
+	if !reflect.DeepEqual(info.ConsumeNames["debug"], []string{formatName(0), formatName(0) + ".log"}) {
+		t.Error(info.ConsumeNames)
+	}
+	if info.Stats.OpenFiles != 2 {
+		t.Errorf("%+v", info.Stats)
+	}
+
+	// without caching nothing is held open
+	if err = q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	q, err = New(false, 5000, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	info = q.DebugInfo()
+	if len(info.OpenFiles) != 0 || len(info.Producers) != 0 || len(info.ConsumeNames) != 0 {
+		t.Errorf("%+v", info)
+	}
+}
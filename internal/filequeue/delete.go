@@ -0,0 +1,166 @@
+package filequeue
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// deleteRange removes the messages with offsets in the range from the topic's logs. Offsets are not
+// reused or shifted, the entries of deleted messages are kept with a size of 0 and without an id or
+// content type so that they are consumed as empty messages. The caller must hold the topic's produce and
+// topic locks
+func (q *FileQueue) deleteRange(topic string, r headers.OffsetRange) error {
+	if r.From < 0 || r.To < r.From {
+		return headers.ErrInvalidMessageID
+	}
+	topicPath := filepath.Join(q.rootDirNames[len(q.rootDirNames)-1], topic)
+	dir, err := osOpen(topicPath)
+	if err != nil {
+		return errors.Wrapf(err, "unable to open topic %q", topic)
+	}
+	infos, err := dir.Readdir(-1)
+	_ = dir.Close()
+	if err != nil {
+		return errors.Wrapf(err, "unable to read topic %q", topic)
+	}
+	for _, info := range infos {
+		if info.IsDir() || strings.ContainsRune(info.Name(), '.') {
+			continue
+		}
+		base, err := strconv.ParseInt(info.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		// skip file sets entirely outside of the range
+		if base > r.To || base+info.Size()/datEntryLength <= r.From {
+			continue
+		}
+		for _, root := range q.rootDirNames {
+			if err = compactFileSet(filepath.Join(root, topic, info.Name()), r); err != nil {
+				return errors.Wrapf(err, "unable to delete messages from %s", info.Name())
+			}
+		}
+	}
+	return nil
+}
+
+// compactFileSet rewrites the dat file at path and its log, ids and types without the messages in the
+// range. The new files are written alongside the old ones and renamed over them, the dat last
+func compactFileSet(path string, r headers.OffsetRange) error {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dat = dat[:len(dat)-len(dat)%datEntryLength]
+	log, err := osOpen(path + ".log")
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+	newLog, err := osOpenFile(path+".log.tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path + ".log.tmp")
+
+	var offset uint64
+	var deleted []int64
+	for i := 0; i < len(dat); i += datEntryLength {
+		entry := dat[i : i+datEntryLength]
+		id := int64(binary.LittleEndian.Uint64(entry[0:8]))
+		start, size := binary.LittleEndian.Uint64(entry[16:24]), entrySize(entry)
+		if id >= r.From && id <= r.To {
+			size = 0
+			deleted = append(deleted, int64(i/datEntryLength))
+		} else if _, err = io.CopyN(newLog, io.NewSectionReader(log, int64(start), int64(size)), int64(size)); err != nil {
+			_ = newLog.Close()
+			return err
+		}
+		binary.LittleEndian.PutUint64(entry[16:24], offset)
+		setEntrySize(entry, size)
+		offset += size
+	}
+	if err = newLog.Sync(); err != nil {
+		_ = newLog.Close()
+		return err
+	}
+	if err = newLog.Close(); err != nil {
+		return err
+	}
+	if len(deleted) == 0 {
+		return nil
+	}
+	if err = clearIDs(path+".ids", deleted); err != nil {
+		return err
+	}
+	if err = clearTypes(path+".types", len(dat)/datEntryLength, deleted); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(path+".tmp", dat, 0666); err != nil {
+		return err
+	}
+	defer os.Remove(path + ".tmp")
+	if err = os.Rename(path+".log.tmp", path+".log"); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// clearIDs rewrites the ids file at path with the ids of the messages at the indexes zeroed, so that they
+// are consumed as messages produced without an id
+func clearIDs(path string, indexes []int64) error {
+	ids, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, index := range indexes {
+		if (index+1)*idEntryLength <= int64(len(ids)) {
+			copy(ids[index*idEntryLength:(index+1)*idEntryLength], make([]byte, idEntryLength))
+		}
+	}
+	return replaceFile(path, ids)
+}
+
+// clearTypes rewrites the types file at path without the content types of the messages at the indexes
+func clearTypes(path string, entries int, indexes []int64) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	types := make([]string, entries)
+	err = parseTypeRecords(b, func(start, count int64, contentType string) {
+		for i := start; i < start+count && i < int64(entries); i++ {
+			types[i] = contentType
+		}
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to read %s", path)
+	}
+	for _, index := range indexes {
+		types[index] = ""
+	}
+	return replaceFile(path, appendTypeRecords(nil, 0, types))
+}
+
+// replaceFile writes b alongside the file at path and renames it over the file
+func replaceFile(path string, b []byte) error {
+	if err := ioutil.WriteFile(path+".tmp", b, 0666); err != nil {
+		return err
+	}
+	defer os.Remove(path + ".tmp")
+	return os.Rename(path+".tmp", path)
+}
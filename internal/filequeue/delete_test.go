@@ -0,0 +1,103 @@
+package filequeue
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestFileQueue_DeleteRange(t *testing.T) {
+	dirs := []string{".haraqa-delete-1", ".haraqa-delete-2"}
+	topic := "delete-topic"
+	for _, dir := range dirs {
+		_ = os.RemoveAll(dir)
+		defer os.RemoveAll(dir)
+	}
+
+	q, err := New(true, 3, dirs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]headers.MessageID, 5)
+	for i := range ids {
+		ids[i] = headers.MessageID{byte(i + 1)}
+	}
+	types := []string{"text/plain", "application/json", "application/json", "text/csv", "text/plain"}
+	if err = q.ProduceWithTypes(context.Background(), topic, []int64{1, 2, 3}, ids[:3], types[:3], uint64(time.Now().UnixNano()), bytes.NewBufferString("abbccc")); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.ProduceWithTypes(context.Background(), topic, []int64{4, 5}, ids[3:], types[3:], uint64(time.Now().UnixNano()), bytes.NewBufferString("ddddeeeee")); err != nil {
+		t.Fatal(err)
+	}
+
+	consume := func(id int64) ([]int64, []headers.MessageID, []string, string) {
+		w := httptest.NewRecorder()
+		if _, err := q.Consume(context.Background(), topic, id, -1, w); err != nil {
+			t.Fatal(err)
+		}
+		sizes, _ := headers.ReadSizes(w.Header())
+		ids, _ := headers.ReadMessageIDs(w.Header())
+		types, _ := headers.ReadContentTypes(w.Header())
+		return sizes, ids, types, w.Body.String()
+	}
+
+	// delete across the boundary of the first and second file sets
+	info, err := q.ModifyTopic(topic, headers.ModifyRequest{Delete: &headers.OffsetRange{From: 1, To: 3}})
+	if err != nil || info == nil || info.MinOffset != 0 || info.MaxOffset != 4 {
+		t.Fatal(info, err)
+	}
+	// deleted messages are consumed without their ids and content types
+	sizes, consumedIDs, consumedTypes, body := consume(0)
+	if len(sizes) != 3 || sizes[0] != 1 || sizes[1] != 0 || sizes[2] != 0 || body != "a" {
+		t.Fatal(sizes, body)
+	}
+	if consumedIDs[0] != ids[0] || !consumedIDs[1].IsZero() || !consumedIDs[2].IsZero() {
+		t.Fatal(consumedIDs)
+	}
+	if len(consumedTypes) != 3 || consumedTypes[0] != "text/plain" || consumedTypes[1] != "" || consumedTypes[2] != "" {
+		t.Fatal(consumedTypes)
+	}
+	sizes, consumedIDs, consumedTypes, body = consume(3)
+	if len(sizes) != 2 || sizes[0] != 0 || sizes[1] != 5 || body != "eeeee" {
+		t.Fatal(sizes, body)
+	}
+	if !consumedIDs[0].IsZero() || consumedIDs[1] != ids[4] || len(consumedTypes) != 2 || consumedTypes[0] != "" || consumedTypes[1] != "text/plain" {
+		t.Fatal(consumedIDs, consumedTypes)
+	}
+
+	// produces continue after the compacted file set
+	if err = q.Produce(context.Background(), topic, []int64{1}, uint64(time.Now().UnixNano()), bytes.NewBufferString("f")); err != nil {
+		t.Fatal(err)
+	}
+	sizes, _, _, body = consume(3)
+	if len(sizes) != 3 || sizes[2] != 1 || body != "eeeeef" {
+		t.Fatal(sizes, body)
+	}
+
+	// every queue directory is compacted
+	for _, dir := range dirs {
+		stat, err := os.Stat(dir + "/" + topic + "/" + formatName(3) + ".log")
+		if err != nil || stat.Size() != 6 {
+			t.Fatal(dir, stat, err)
+		}
+	}
+
+	// deleting already deleted messages changes nothing
+	if _, err = q.ModifyTopic(topic, headers.ModifyRequest{Delete: &headers.OffsetRange{From: 2, To: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []headers.OffsetRange{{From: -1, To: 2}, {From: 3, To: 2}} {
+		if _, err = q.ModifyTopic(topic, headers.ModifyRequest{Delete: &r}); err != headers.ErrInvalidMessageID {
+			t.Fatal(r, err)
+		}
+	}
+}
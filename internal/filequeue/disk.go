@@ -0,0 +1,61 @@
+package filequeue
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// DiskUsage returns the filesystem usage of each queue directory and the number of bytes
+// used by each topic in the last directory
+func (q *FileQueue) DiskUsage() (*headers.DiskUsage, error) {
+	usage := &headers.DiskUsage{
+		Dirs:   make([]headers.DirUsage, 0, len(q.rootDirNames)),
+		Topics: make(map[string]int64),
+	}
+	for _, dir := range q.rootDirNames {
+		total, free, err := diskSpace(dir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to get disk usage of %q", dir)
+		}
+		usage.Dirs = append(usage.Dirs, headers.DirUsage{Path: dir, Total: total, Free: free})
+	}
+
+	rootDir := q.rootDirNames[len(q.rootDirNames)-1]
+	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			if path == filepath.Join(rootDir, trashDirName) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		topic, err := filepath.Rel(rootDir, filepath.Dir(path))
+		if err != nil || topic == "." {
+			return nil
+		}
+		usage.Topics[filepath.ToSlash(topic)] += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get topic disk usage")
+	}
+	return usage, nil
+}
+
+// diskFullError replaces errors caused by a filesystem running out of space with headers.ErrDiskFull,
+// keeping the original message
+func diskFullError(err error) error {
+	if err != nil && errors.Is(err, syscall.ENOSPC) {
+		return errors.Wrap(headers.ErrDiskFull, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package filequeue
+
+import "github.com/pkg/errors"
+
+// diskSpace is not supported on this platform
+func diskSpace(path string) (total, free int64, err error) {
+	return 0, 0, errors.New("disk usage is not supported on this platform")
+}
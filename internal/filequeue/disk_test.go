@@ -0,0 +1,93 @@
+package filequeue
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestFileQueue_DiskUsage(t *testing.T) {
+	dirs := []string{".haraqa-disk1", ".haraqa-disk2"}
+	for _, dir := range dirs {
+		_ = os.RemoveAll(dir)
+		defer os.RemoveAll(dir)
+	}
+	q, err := New(true, 5000, dirs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if err = q.CreateTopic("disk"); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Produce(context.Background(), "disk", []int64{5, 6}, 0, bytes.NewBufferString("helloworld!")); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.CreateTopic("disk/nested"); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := q.DiskUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage.Dirs) != 2 || usage.Dirs[0].Path != dirs[0] || usage.Dirs[1].Path != dirs[1] {
+		t.Fatal(usage.Dirs)
+	}
+	for _, dir := range usage.Dirs {
+		if dir.Total <= 0 || dir.Free < 0 || dir.Free > dir.Total {
+			t.Error(dir)
+		}
+		if f := dir.UsedFraction(); f < 0 || f > 1 {
+			t.Error(f)
+		}
+	}
+	if usage.Topics["disk"] != 11+2*datEntryLength {
+		t.Error(usage.Topics)
+	}
+	if _, ok := usage.Topics["disk/nested"]; ok {
+		t.Error(usage.Topics)
+	}
+}
+
+func TestFileQueue_DiskFull(t *testing.T) {
+	dir := ".haraqa-diskfull"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	q, err := New(false, 5000, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	errNoSpace := &os.PathError{Op: "write", Path: dir, Err: syscall.ENOSPC}
+	osMkdir = func(name string, perm os.FileMode) error { return errNoSpace }
+	err = q.CreateTopic("full")
+	osMkdir = os.Mkdir
+	if errors.Cause(err) != headers.ErrDiskFull || !strings.Contains(err.Error(), "no space left on device") {
+		t.Fatal(err)
+	}
+
+	if err = q.CreateTopic("full"); err != nil {
+		t.Fatal(err)
+	}
+	osOpenFile = func(name string, flag int, perm os.FileMode) (*os.File, error) { return nil, errNoSpace }
+	err = q.Produce(context.Background(), "full", []int64{5}, 0, bytes.NewBufferString("hello"))
+	osOpenFile = os.OpenFile
+	if errors.Cause(err) != headers.ErrDiskFull {
+		t.Fatal(err)
+	}
+
+	// other errors are unchanged
+	errTest := errors.New("test error")
+	if diskFullError(errTest) != errTest || diskFullError(nil) != nil {
+		t.Fatal("expected errors to be unchanged")
+	}
+}
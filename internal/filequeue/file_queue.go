@@ -1,12 +1,15 @@
 package filequeue
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/haraqa/haraqa/internal/headers"
 	"github.com/pkg/errors"
@@ -14,11 +17,22 @@ import (
 
 // FileQueue implements the haraqa queue by storing messages in log files, under topic based directories
 type FileQueue struct {
+	stats            cacheStats
 	rootDirNames     []string
 	max              int64
+	rollInterval     int64
+	rollSize         int64
 	produceLocks     *sync.Map
+	topicLocks       *sync.Map
 	produceCache     *sync.Map
+	commits          *sync.Map
 	consumeNameCache *sync.Map
+	topics           topicIndex
+	files            fileBudget
+	readAhead        readAhead
+	buffers          atomic.Value
+	sync             int32
+	locks            []*os.File
 }
 
 // New creates a new FileQueue
@@ -48,10 +62,28 @@ func New(cacheFiles bool, maxEntries int64, dirs ...string) (*FileQueue, error)
 		dirNames = append(dirNames, dir)
 	}
 
+	// lock the directories so a second server cannot write to the same files
+	locks := make([]*os.File, 0, len(dirNames))
+	for i, dir := range dirNames {
+		if containsString(dirNames[:i], dir) {
+			continue
+		}
+		lock, err := lockDir(dir)
+		if err != nil {
+			unlockDirs(locks)
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+
 	q := &FileQueue{
 		rootDirNames: dirNames,
 		max:          maxEntries,
 		produceLocks: &sync.Map{},
+		topicLocks:   &sync.Map{},
+		commits:      &sync.Map{},
+		locks:        locks,
+		readAhead:    readAhead{running: make(chan struct{}, maxReadAheads)},
 	}
 	if cacheFiles {
 		q.produceCache = &sync.Map{}
@@ -62,6 +94,7 @@ func New(cacheFiles bool, maxEntries int64, dirs ...string) (*FileQueue, error)
 
 // Close closes the queue cached files
 func (q *FileQueue) Close() error {
+	q.readAhead.wg.Wait()
 	if q.produceCache != nil {
 		q.produceCache.Range(func(key, value interface{}) bool {
 			lock, _ := q.produceLocks.Load(key)
@@ -69,18 +102,14 @@ func (q *FileQueue) Close() error {
 				l.Lock()
 				defer l.Unlock()
 			}
-			v, ok := value.(*ProduceFile)
-			if ok {
-				for _, f := range v.Logs {
-					_ = f.Close()
-				}
-				for _, f := range v.Dats {
-					_ = f.Close()
-				}
+			if v, ok := value.(*ProduceFile); ok {
+				q.closeProduceFile(v)
 			}
 			return true
 		})
 	}
+	unlockDirs(q.locks)
+	q.locks = nil
 	return nil
 }
 
@@ -89,44 +118,31 @@ func (q *FileQueue) RootDir() string {
 	return q.rootDirNames[len(q.rootDirNames)-1]
 }
 
-// ListTopics returns all of the topic names in the queue
-func (q *FileQueue) ListTopics(prefix, suffix, regex string) ([]string, error) {
-	var names []string
-	rootDir := q.rootDirNames[len(q.rootDirNames)-1]
-	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
-		if !info.IsDir() {
-			return nil
-		}
-		if path == rootDir {
-			return nil
-		}
-		path = filepath.ToSlash(strings.TrimPrefix(path, rootDir+string(filepath.Separator)))
-
-		if prefix != "" && !strings.HasPrefix(path, prefix) {
-			return nil
-		}
-		if suffix != "" && !strings.HasSuffix(path, suffix) {
-			return nil
-		}
-		if regex != "" && regex != ".*" {
-			rx, err := regexp.Compile(regex)
-			if err != nil {
-				return errors.Wrap(err, "invalid regex")
-			}
-			if !rx.MatchString(path) {
-				return nil
-			}
+// ListTopics returns the topic names in the queue which start with the prefix, end with the suffix and
+// match the regex. Topics are listed from an index of the queue's topics, loaded on the first call
+func (q *FileQueue) ListTopics(ctx context.Context, prefix, suffix, regex string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var rx *regexp.Regexp
+	if regex != "" && regex != ".*" {
+		var err error
+		if rx, err = regexp.Compile(regex); err != nil {
+			return nil, errors.Wrap(err, "invalid regex")
 		}
-		names = append(names, path)
-		return nil
+	}
+	return q.topics.list(ctx, q.RootDir(), prefix, func(name string) bool {
+		return strings.HasSuffix(name, suffix) && (rx == nil || rx.MatchString(name))
 	})
-	return names, err
 }
 
 // CreateTopic creates a new topic if it does not already exist
 func (q *FileQueue) CreateTopic(topic string) error {
 	topic = strings.TrimSpace(topic)
 	topic = strings.TrimSuffix(topic, "/")
+	if isTrash(topic) {
+		return headers.ErrInvalidTopic
+	}
 	splitTopic := strings.Split(topic, "/")
 	for _, name := range q.rootDirNames {
 		var err error
@@ -135,7 +151,7 @@ func (q *FileQueue) CreateTopic(topic string) error {
 		} else {
 			err = osMkdirAll(filepath.Join(name, filepath.Join(splitTopic[:len(splitTopic)-1]...)), os.ModePerm)
 			if err != nil {
-				return err
+				return diskFullError(err)
 			}
 			err = osMkdir(filepath.Join(name, filepath.Join(splitTopic...)), os.ModePerm)
 		}
@@ -143,23 +159,92 @@ func (q *FileQueue) CreateTopic(topic string) error {
 			return headers.ErrTopicAlreadyExists
 		}
 		if err != nil {
-			return err
+			return diskFullError(err)
 		}
 	}
+	q.topics.add(topic)
 	return nil
 }
 
-// Delete topic deletes the topic and any nested topic within
+// DeleteTopic moves the topic and any nested topic within to the trash, where it can be restored with
+// RestoreTopic until it is removed by PurgeTopics. Deleting a topic which does not exist is not an error
 func (q *FileQueue) DeleteTopic(topic string) error {
-	for _, name := range q.rootDirNames {
-		os.RemoveAll(filepath.Join(name, topic))
+	if isTrash(topic) {
+		return headers.ErrInvalidTopic
 	}
-	if q.consumeNameCache != nil {
-		q.consumeNameCache.Delete(topic)
+
+	// block produces and consumes while the files are moved
+	produceLock := q.produceLock(topic)
+	produceLock.Lock()
+	defer produceLock.Unlock()
+	topicLock := q.topicLock(topic)
+	topicLock.Lock()
+	defer topicLock.Unlock()
+
+	name := trashName(topic, time.Now())
+	for _, dir := range q.rootDirNames {
+		if _, err := os.Stat(filepath.Join(dir, topic)); os.IsNotExist(err) {
+			continue
+		}
+		if err := osMkdirAll(filepath.Join(dir, trashDirName), os.ModePerm); err != nil {
+			return errors.Wrapf(err, "unable to create trash directory in %q", dir)
+		}
+		if err := os.Rename(filepath.Join(dir, topic), filepath.Join(dir, trashDirName, name)); err != nil {
+			return errors.Wrapf(err, "unable to move topic %q to the trash", topic)
+		}
+	}
+	q.topics.remove(topic)
+	q.evictConsumeName(topic)
+	if q.produceCache != nil {
+		if v, ok := q.produceCache.Load(topic); ok {
+			q.produceCache.Delete(topic)
+			q.closeProduceFile(v.(*ProduceFile))
+			atomic.AddInt64(&q.stats.produceEvictions, 1)
+		}
 	}
 	return nil
 }
 
+// evictProduceFile closes and removes the topic's files from the produce cache
+func (q *FileQueue) evictProduceFile(topic string) {
+	if q.produceCache == nil {
+		return
+	}
+	mux, ok := q.produceLocks.Load(topic)
+	if !ok {
+		return
+	}
+	mux.(*sync.Mutex).Lock()
+	defer mux.(*sync.Mutex).Unlock()
+	if v, ok := q.produceCache.Load(topic); ok {
+		q.produceCache.Delete(topic)
+		q.closeProduceFile(v.(*ProduceFile))
+		atomic.AddInt64(&q.stats.produceEvictions, 1)
+	}
+}
+
+// closeProduceFile closes all of the dat and log files of the produce file
+func (q *FileQueue) closeProduceFile(pf *ProduceFile) {
+	n := len(pf.Dats) + len(pf.Logs) + len(pf.IDs) + len(pf.Types)
+	if len(pf.Dats) > 0 {
+		_ = pf.Dats.Close()
+		pf.Dats = nil
+	}
+	if len(pf.Logs) > 0 {
+		_ = pf.Logs.Close()
+		pf.Logs = nil
+	}
+	if len(pf.IDs) > 0 {
+		_ = pf.IDs.Close()
+		pf.IDs = nil
+	}
+	if len(pf.Types) > 0 {
+		_ = pf.Types.Close()
+		pf.Types = nil
+	}
+	q.releaseFiles(int64(n))
+}
+
 func formatName(baseID int64) string {
 	const defaultName = "0000000000000000"
 
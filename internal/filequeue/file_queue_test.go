@@ -1,10 +1,14 @@
 package filequeue
 
 import (
+	"bytes"
+	"context"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/haraqa/haraqa/internal/headers"
 	"github.com/pkg/errors"
@@ -108,7 +112,7 @@ func TestFileQueue_Topics(t *testing.T) {
 
 	// list topics
 	{
-		names, err := q.ListTopics("new", "topic", `[a-z\\]*`)
+		names, err := q.ListTopics(context.Background(), "new", "topic", `[a-z\\]*`)
 		if err != nil {
 			t.Error(err)
 		}
@@ -116,19 +120,19 @@ func TestFileQueue_Topics(t *testing.T) {
 			t.Error(names)
 		}
 
-		names, err = q.ListTopics("invalid", "", "")
+		names, err = q.ListTopics(context.Background(), "invalid", "", "")
 		if err != nil || len(names) != 0 {
 			t.Error(err, names)
 		}
-		names, err = q.ListTopics("", "invalid", "")
+		names, err = q.ListTopics(context.Background(), "", "invalid", "")
 		if err != nil || len(names) != 0 {
 			t.Error(err, names)
 		}
-		names, err = q.ListTopics("", "", "[0-9]")
+		names, err = q.ListTopics(context.Background(), "", "", "[0-9]")
 		if err != nil || len(names) != 0 {
 			t.Error(err, names)
 		}
-		names, err = q.ListTopics("", "", "[")
+		names, err = q.ListTopics(context.Background(), "", "", "[")
 		if err == nil || err.Error() != "invalid regex: error parsing regexp: missing closing ]: `[`" {
 			t.Errorf("%q", err)
 		}
@@ -148,7 +152,7 @@ func TestFileQueue_Topics(t *testing.T) {
 
 	// list topics
 	{
-		names, err := q.ListTopics("", "", "")
+		names, err := q.ListTopics(context.Background(), "", "", "")
 		if err != nil {
 			t.Error(err)
 		}
@@ -162,3 +166,34 @@ func TestFileQueue_Topics(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestFileQueue_Canceled(t *testing.T) {
+	dir, topic := ".haraqa-canceled", "canceled-topic"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	q, err := New(true, 5000, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+
+	// abandoned requests do not touch the topic
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = q.Produce(ctx, topic, []int64{5}, uint64(time.Now().UnixNano()), bytes.NewBufferString("hello")); err != context.Canceled {
+		t.Fatal(err)
+	}
+	if _, err = q.Consume(ctx, topic, 0, -1, httptest.NewRecorder()); err != context.Canceled {
+		t.Fatal(err)
+	}
+	if _, err = q.ListTopics(ctx, "", "", ""); err != context.Canceled {
+		t.Fatal(err)
+	}
+	info, err := q.InspectTopic(topic)
+	if err != nil || info.MaxOffset != -1 {
+		t.Fatal(info, err)
+	}
+}
@@ -0,0 +1,124 @@
+package filequeue
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// fileWait is how long an open waits for another to close a file when the queue is at its file limit
+var fileWait = time.Second
+
+// fileBudget caps the number of produce and consume files the queue holds open at once
+type fileBudget struct {
+	max      int64
+	mux      sync.Mutex
+	released chan struct{}
+}
+
+// SetMaxOpenFiles caps the number of files the queue holds open to produce and consume messages, 0 for
+// no limit. At the limit the least recently used cached producer files are closed, and an open that
+// still cannot be made within a short wait fails with headers.ErrTooManyOpenFiles
+func (q *FileQueue) SetMaxOpenFiles(n int64) {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreInt64(&q.files.max, n)
+}
+
+// openFile opens the named file once it fits within the file limit
+func (q *FileQueue) openFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	if err := q.acquireFile(); err != nil {
+		return nil, err
+	}
+	f, err := osOpenFile(name, flag, perm)
+	if err != nil {
+		q.releaseFiles(1)
+		if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) {
+			return nil, errors.Wrap(headers.ErrTooManyOpenFiles, err.Error())
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// acquireFile counts a file against the limit, evicting idle producers and then waiting for files to be
+// closed if the limit has been reached
+func (q *FileQueue) acquireFile() error {
+	var timer *time.Timer
+	for evicted := false; ; {
+		max := atomic.LoadInt64(&q.files.max)
+		n := atomic.LoadInt64(&q.stats.openFiles)
+		if max <= 0 || n < max {
+			if atomic.CompareAndSwapInt64(&q.stats.openFiles, n, n+1) {
+				if timer != nil {
+					timer.Stop()
+				}
+				return nil
+			}
+			continue
+		}
+
+		q.files.mux.Lock()
+		if q.files.released == nil {
+			q.files.released = make(chan struct{})
+		}
+		released := q.files.released
+		q.files.mux.Unlock()
+
+		// close the least recently used producer once, before waiting on other requests
+		if !evicted {
+			evicted = true
+			if q.evictIdleProducer() {
+				continue
+			}
+		}
+		if timer == nil {
+			timer = time.NewTimer(fileWait)
+		}
+		select {
+		case <-released:
+		case <-timer.C:
+			atomic.AddInt64(&q.stats.fileRejections, 1)
+			return headers.ErrTooManyOpenFiles
+		}
+	}
+}
+
+// releaseFiles removes closed files from the count, waking any opens waiting on the limit
+func (q *FileQueue) releaseFiles(n int64) {
+	atomic.AddInt64(&q.stats.openFiles, -n)
+	q.files.mux.Lock()
+	if q.files.released != nil {
+		close(q.files.released)
+		q.files.released = nil
+	}
+	q.files.mux.Unlock()
+}
+
+// evictIdleProducer closes the cached producer files of the least recently written topic in the
+// background, as the topic may be locked by the caller. It returns false if there was nothing to evict
+func (q *FileQueue) evictIdleProducer() bool {
+	if q.produceCache == nil {
+		return false
+	}
+	var topic string
+	var lastUsed int64
+	q.produceCache.Range(func(key, value interface{}) bool {
+		pf := value.(*ProduceFile)
+		if used := atomic.LoadInt64(&pf.lastUsed); topic == "" || used < lastUsed {
+			topic, lastUsed = key.(string), used
+		}
+		return true
+	})
+	if topic == "" {
+		return false
+	}
+	go q.evictProduceFile(topic)
+	return true
+}
@@ -0,0 +1,69 @@
+package filequeue
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestFileQueue_MaxOpenFiles(t *testing.T) {
+	dir := ".haraqa-max-open-files"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	q, err := New(true, 5000, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	q.SetMaxOpenFiles(2)
+
+	// producing to a second topic closes the files of the first
+	for _, topic := range []string{"first", "second"} {
+		if err = q.CreateTopic(topic); err != nil {
+			t.Fatal(err)
+		}
+		if err = q.Produce(context.Background(), topic, []int64{5}, uint64(time.Now().UnixNano()), bytes.NewBufferString("hello")); err != nil {
+			t.Fatal(topic, err)
+		}
+	}
+	stats := q.CacheStats()
+	if stats.OpenFiles != 2 || stats.ProduceEvictions != 1 {
+		t.Fatal(stats)
+	}
+
+	// opens wait for files to be closed
+	if err = q.acquireFile(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.releaseFiles(1)
+	}()
+	if _, err = q.Consume(context.Background(), "first", 0, -1, httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+
+	// and are rejected if no files are closed in time, after evicting the cached producer
+	defer func(d time.Duration) { fileWait = d }(fileWait)
+	fileWait = 10 * time.Millisecond
+	q.SetMaxOpenFiles(1)
+	if _, err = q.Consume(context.Background(), "first", 0, -1, httptest.NewRecorder()); errors.Cause(err) != headers.ErrTooManyOpenFiles {
+		t.Fatal(err)
+	}
+	stats = q.CacheStats()
+	if stats.OpenFiles != 0 || stats.ProduceEvictions != 2 || stats.FileRejections != 1 {
+		t.Fatal(stats)
+	}
+
+	// a limit of 0 removes the limit
+	q.SetMaxOpenFiles(-1)
+	if _, err = q.Consume(context.Background(), "first", 0, -1, httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,56 @@
+package filequeue
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// The size field of a dat entry holds the message size in its low 48 bits, followed by a byte of
+// feature flags and a byte of format version. Entries written before versioning are version 0 with no
+// flags, so files written by earlier versions are read unchanged
+const (
+	// entryVersion is the latest format version of dat entries
+	entryVersion = 1
+	// maxEntrySize is the largest message size a dat entry can hold
+	maxEntrySize = 1<<48 - 1
+)
+
+// Feature flags of a dat entry
+const (
+	// entryFlagID is set on entries whose message has an id in the ids file
+	entryFlagID = 1 << iota
+	// entryFlagType is set on entries whose message has a content type in the types file
+	entryFlagType
+)
+
+// putEntrySize sets the size, flags and latest version in the size field of a dat entry
+func putEntrySize(entry []byte, size uint64, flags byte) {
+	binary.LittleEndian.PutUint64(entry[24:], size|uint64(flags)<<48|uint64(entryVersion)<<56)
+}
+
+// entrySize returns the message size of a dat entry
+func entrySize(entry []byte) uint64 {
+	return binary.LittleEndian.Uint64(entry[24:]) & maxEntrySize
+}
+
+// setEntrySize replaces the message size of a dat entry, keeping its version and flags
+func setEntrySize(entry []byte, size uint64) {
+	binary.LittleEndian.PutUint64(entry[24:], binary.LittleEndian.Uint64(entry[24:])&^maxEntrySize|size)
+}
+
+// entryFormat returns the format version and feature flags of a dat entry
+func entryFormat(entry []byte) (version, flags byte) {
+	return entry[31], entry[30]
+}
+
+// checkEntries returns an error if any of the dat entries were written in a newer format than this
+// version can read, rather than misreading them
+func checkEntries(data []byte) error {
+	for i := 0; i+datEntryLength <= len(data); i += datEntryLength {
+		if version, _ := entryFormat(data[i:]); version > entryVersion {
+			return errors.Errorf("unsupported dat entry format version %d", version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package filequeue
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestEntryFormat(t *testing.T) {
+	entry := make([]byte, datEntryLength)
+	putEntrySize(entry, 12345, entryFlagID)
+	if entrySize(entry) != 12345 {
+		t.Fatal(entrySize(entry))
+	}
+	if version, flags := entryFormat(entry); version != entryVersion || flags != entryFlagID {
+		t.Fatal(version, flags)
+	}
+	setEntrySize(entry, maxEntrySize)
+	if version, flags := entryFormat(entry); entrySize(entry) != maxEntrySize || version != entryVersion || flags != entryFlagID {
+		t.Fatal(entrySize(entry), version, flags)
+	}
+
+	// entries written before versioning hold only the size
+	binary.LittleEndian.PutUint64(entry[24:], 678)
+	if version, flags := entryFormat(entry); entrySize(entry) != 678 || version != 0 || flags != 0 {
+		t.Fatal(entrySize(entry), version, flags)
+	}
+	if err := checkEntries(entry); err != nil {
+		t.Fatal(err)
+	}
+	entry[31] = entryVersion + 1
+	if err := checkEntries(append(make([]byte, datEntryLength), entry...)); err == nil {
+		t.Fatal("expected unsupported version error")
+	}
+}
+
+func TestFileQueue_EntryVersions(t *testing.T) {
+	dir := ".haraqa-format"
+	topic := "format"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	q, err := New(false, 5000, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+	id, err := headers.NewMessageID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Produce(context.Background(), topic, []int64{3}, uint64(time.Now().UnixNano()), bytes.NewBufferString("old")); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.ProduceWithIDs(context.Background(), topic, []int64{3}, []headers.MessageID{id}, uint64(time.Now().UnixNano()), bytes.NewBufferString("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Produce(context.Background(), topic, []int64{6}, uint64(time.Now().UnixNano()), bytes.NewBufferString("future")); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, topic, formatName(0))
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, flags := entryFormat(dat[datEntryLength:]); version != entryVersion || flags != entryFlagID {
+		t.Fatal(version, flags)
+	}
+	if _, flags := entryFormat(dat); flags != 0 {
+		t.Fatal(flags)
+	}
+
+	// rewrite the first entry as an earlier version and the last as a later version
+	binary.LittleEndian.PutUint64(dat[24:], 3)
+	dat[2*datEntryLength+31] = entryVersion + 1
+	if err = ioutil.WriteFile(path, dat, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	if n, err := q.Consume(context.Background(), topic, 0, 2, w); err != nil || n != 2 || w.Body.String() != "oldnew" {
+		t.Fatal(n, err, w.Body.String())
+	}
+	if _, err := q.Consume(context.Background(), topic, 0, 3, httptest.NewRecorder()); err == nil {
+		t.Fatal("expected unsupported version error")
+	}
+}
@@ -0,0 +1,68 @@
+package filequeue
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// InspectTopic returns the offset info and size in bytes of the topic. An empty topic has a MaxOffset of
+// MinOffset-1
+func (q *FileQueue) InspectTopic(topic string) (*headers.TopicInfo, error) {
+	if isTrash(topic) {
+		return nil, headers.ErrInvalidTopic
+	}
+	topicPath := filepath.Join(q.rootDirNames[len(q.rootDirNames)-1], topic)
+	dir, err := osOpen(topicPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, headers.ErrTopicDoesNotExist
+		}
+		return nil, errors.Wrapf(err, "unable to open topic %q", topic)
+	}
+	defer dir.Close()
+	infos, err := dir.Readdir(-1)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read topic %q", topic)
+	}
+
+	minBase, maxBase := int64(-1), int64(-1)
+	var size int64
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		size += info.Size()
+		name := info.Name()
+		if strings.ContainsRune(name, '.') {
+			continue
+		}
+		base, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		if minBase < 0 || base < minBase {
+			minBase = base
+		}
+		if base > maxBase {
+			maxBase = base
+		}
+	}
+	if maxBase < 0 {
+		return &headers.TopicInfo{MinOffset: 0, MaxOffset: -1, Size: size}, nil
+	}
+
+	stat, err := os.Stat(filepath.Join(topicPath, formatName(maxBase)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to stat latest dat file for %q", topic)
+	}
+	return &headers.TopicInfo{
+		MinOffset: minBase,
+		MaxOffset: maxBase + stat.Size()/datEntryLength - 1,
+		Size:      size,
+	}, nil
+}
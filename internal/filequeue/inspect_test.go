@@ -0,0 +1,64 @@
+package filequeue
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestFileQueue_InspectTopic(t *testing.T) {
+	dir := ".haraqa-inspect"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	q, err := New(true, 2, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	// missing topic
+	if _, err = q.InspectTopic("inspect"); err != headers.ErrTopicDoesNotExist {
+		t.Fatal(err)
+	}
+
+	// empty topic
+	if err = q.CreateTopic("inspect"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := q.InspectTopic("inspect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.MinOffset != 0 || info.MaxOffset != -1 || info.Size != 0 {
+		t.Fatal(info)
+	}
+
+	// spanning multiple files
+	for i := 0; i < 3; i++ {
+		if err = q.Produce(context.Background(), "inspect", []int64{1}, 0, bytes.NewBufferString("a")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err = q.InspectTopic("inspect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.MinOffset != 0 || info.MaxOffset != 2 || info.Size != 3*datEntryLength+3 {
+		t.Fatal(info)
+	}
+
+	// truncated
+	if _, err = q.ModifyTopic("inspect", headers.ModifyRequest{Truncate: -1}); err != nil {
+		t.Fatal(err)
+	}
+	info, err = q.InspectTopic("inspect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.MinOffset != 2 || info.MaxOffset != 2 {
+		t.Fatal(info)
+	}
+}
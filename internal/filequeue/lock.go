@@ -0,0 +1,61 @@
+package filequeue
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// lockFileName is the name of the file locked in each queue directory
+const lockFileName = ".haraqa.lock"
+
+// lockDir locks the queue directory so that it cannot be used by another process at the same time,
+// returning an error naming the owner if it is already locked. The pid of the owner is written to the
+// lock file for debugging, the lock itself is held until the returned file is closed
+func lockDir(dir string) (*os.File, error) {
+	f, err := osOpenFile(filepath.Join(dir, lockFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open lock file of queue directory %q", dir)
+	}
+	locked, err := lockFile(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.Wrapf(err, "unable to lock queue directory %q", dir)
+	}
+	if !locked {
+		owner, _ := ioutil.ReadAll(f)
+		_ = f.Close()
+		if pid := string(bytes.TrimSpace(owner)); pid != "" {
+			return nil, errors.Errorf("queue directory %q is locked by another process (pid %s)", dir, pid)
+		}
+		return nil, errors.Errorf("queue directory %q is locked by another process", dir)
+	}
+	if err = f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.Wrapf(err, "unable to write lock file of queue directory %q", dir)
+	}
+	return f, nil
+}
+
+// unlockDirs releases the directory locks
+func unlockDirs(locks []*os.File) {
+	for _, lock := range locks {
+		_ = lock.Close()
+	}
+}
+
+func containsString(s []string, v string) bool {
+	for i := range s {
+		if s[i] == v {
+			return true
+		}
+	}
+	return false
+}
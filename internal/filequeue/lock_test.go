@@ -0,0 +1,58 @@
+package filequeue
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFileQueue_Lock(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" && runtime.GOOS != "freebsd" {
+		t.Skip("locking is not supported on " + runtime.GOOS)
+	}
+	dir1, dir2 := ".haraqa-lock1", ".haraqa-lock2"
+	defer os.RemoveAll(dir1)
+	defer os.RemoveAll(dir2)
+
+	q, err := New(false, 5000, dir1, dir1, dir2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir2, lockFileName))
+	if err != nil || string(b) != strconv.Itoa(os.Getpid())+"\n" {
+		t.Fatal(string(b), err)
+	}
+	topics, err := q.ListTopics(context.Background(), "", "", "")
+	if err != nil || len(topics) != 0 {
+		t.Fatal(topics, err)
+	}
+
+	// a locked directory cannot be used, and locks taken before the failure are released
+	_, err = New(false, 5000, ".haraqa-lock3", dir2)
+	if err == nil || !strings.Contains(err.Error(), "locked by another process (pid ") {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(".haraqa-lock3")
+	q3, err := New(false, 5000, ".haraqa-lock3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = q3.Close()
+
+	// closing releases the locks
+	if err = q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	q, err = New(false, 5000, dir1, dir2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
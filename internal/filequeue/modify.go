@@ -3,64 +3,141 @@ package filequeue
 import (
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/haraqa/haraqa/internal/headers"
 	"github.com/pkg/errors"
 )
 
-// ModifyTopic updates the topic to truncate/remove messages and return the topic offset info
+// ModifyTopic updates the topic to truncate/remove messages and return the topic offset info. A range of
+// messages can also be deleted or redacted from anywhere in the topic without changing the offsets of the
+// others, or every message purged.
+// Produces to the topic wait for the modification to finish and consumes never see a partially removed
+// file set, a dat file is always removed together with its log in every queue directory
 func (q *FileQueue) ModifyTopic(topic string, request headers.ModifyRequest) (*headers.TopicInfo, error) {
 	if topic == "" {
 		return nil, nil
 	}
+	if isTrash(topic) {
+		return nil, headers.ErrInvalidTopic
+	}
+
+	// block produces, then wait for consumes to finish opening files
+	produceLock := q.produceLock(topic)
+	produceLock.Lock()
+	defer produceLock.Unlock()
+	topicLock := q.topicLock(topic)
+	topicLock.Lock()
+	defer topicLock.Unlock()
+
 	topicPath := filepath.Join(q.rootDirNames[len(q.rootDirNames)-1], topic)
 	latest, err := getLatestDat(topicPath)
+	if os.IsNotExist(err) {
+		return nil, headers.ErrTopicDoesNotExist
+	}
 	if err != nil {
 		return nil, errors.Wrapf(err, "unable to open latest dat file for %q", topic)
 	}
+	dir, err := osOpen(topicPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open topic %q", topic)
+	}
+	infos, err := dir.Readdir(-1)
+	_ = dir.Close()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read topic %q", topic)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	dats := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		dats[info.Name()] = !info.IsDir()
+	}
 
-	topicInfo := &headers.TopicInfo{}
-	err = filepath.Walk(topicPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			if os.IsNotExist(err) {
-				err = nil
-			}
-			return err
+	// the files are about to change, drop the cached file sets
+	defer q.evictConsumeName(topic)
+	if q.produceCache != nil {
+		if v, ok := q.produceCache.Load(topic); ok {
+			q.produceCache.Delete(topic)
+			q.closeProduceFile(v.(*ProduceFile))
+			atomic.AddInt64(&q.stats.produceEvictions, 1)
 		}
+	}
 
-		// ignore directories
+	// remove every message, keeping the offset of the next message
+	if request.Purge {
+		return q.purge(topic, infos)
+	}
+
+	// delete a range of messages, keeping the offsets of the messages around it
+	if request.Delete != nil {
+		if err = q.deleteRange(topic, *request.Delete); err != nil {
+			return nil, err
+		}
+	}
+
+	// overwrite a range of messages with tombstones, keeping their offsets and sizes
+	if request.Redact != nil {
+		if err = q.redactRange(topic, *request.Redact); err != nil {
+			return nil, err
+		}
+	}
+	if (request.Delete != nil || request.Redact != nil) && request.Truncate == 0 && request.Before.IsZero() {
+		return q.InspectTopic(topic)
+	}
+
+	topicInfo := &headers.TopicInfo{}
+	for _, info := range infos {
+		// ignore nested topics
 		if info.IsDir() {
-			return nil
+			continue
+		}
+		name := info.Name()
+
+		// logs and ids are removed along with their dat file
+		if dat, ok := fileSetDat(name); ok && dats[dat] {
+			continue
 		}
 
 		// remove all but latest if truncate is negative
-		if request.Truncate < 0 && !strings.HasPrefix(info.Name(), latest) {
-			return errors.Wrapf(os.Remove(path), "unable to remove truncated file %s", path)
+		if request.Truncate < 0 && !strings.HasPrefix(name, latest) {
+			if err = q.removeFileSet(topic, name); err != nil {
+				return nil, errors.Wrapf(err, "unable to remove truncated file %s", name)
+			}
+			continue
 		}
 
 		// remove all before modtime
 		if !request.Before.IsZero() && info.ModTime().Before(request.Before) {
-			return errors.Wrapf(os.Remove(path), "unable to remove timed out file %s", path)
+			if err = q.removeFileSet(topic, name); err != nil {
+				return nil, errors.Wrapf(err, "unable to remove timed out file %s", name)
+			}
+			continue
 		}
 
 		// ignore everything but dat files
-		if strings.ContainsRune(info.Name(), '.') {
-			return nil
+		if strings.ContainsRune(name, '.') {
+			continue
 		}
 
-		// remove if file is completely before the truncate point
-		base, err := strconv.ParseInt(info.Name(), 10, 64)
+		// remove if file is completely before the truncate point, the latest file is kept so that the
+		// offsets of later produces continue from it
+		base, err := strconv.ParseInt(name, 10, 64)
 		if err != nil {
-			return errors.Wrapf(os.Remove(path), "unable to remove unparsable file %s", path)
+			if err = q.removeFileSet(topic, name); err != nil {
+				return nil, errors.Wrapf(err, "unable to remove unparsable file %s", name)
+			}
+			continue
 		}
 		datSize := info.Size() / datEntryLength
-		if request.Truncate > 0 && base+datSize < request.Truncate {
-			if err = os.Remove(path); err != nil {
-				return errors.Wrapf(os.Remove(path), "unable to remove file %s", path)
+		if request.Truncate > 0 && base+datSize < request.Truncate && name != latest {
+			if err = q.removeFileSet(topic, name); err != nil {
+				return nil, errors.Wrapf(err, "unable to remove file %s", name)
 			}
-			return errors.Wrapf(os.Remove(path+".log"), "unable to remove file %s", path)
+			continue
 		}
 
 		// check if this is the lowest point
@@ -73,12 +150,89 @@ func (q *FileQueue) ModifyTopic(topic string, request headers.ModifyRequest) (*h
 		if base+datSize > topicInfo.MaxOffset {
 			topicInfo.MaxOffset = base + datSize - 1
 		}
+	}
+
+	return topicInfo, nil
+}
 
-		return nil
-	})
+// purge removes every file set of the topic and starts an empty file set at the offset after the last
+// message, so that the offsets of later produces continue from it. Nested topics are kept
+func (q *FileQueue) purge(topic string, infos []os.FileInfo) (*headers.TopicInfo, error) {
+	info, err := q.InspectTopic(topic)
 	if err != nil {
-		return nil, errors.Wrapf(err, "unable to modify topic %q", topic)
+		return nil, err
 	}
+	next := formatName(info.MaxOffset + 1)
+	for _, root := range q.rootDirNames {
+		path := filepath.Join(root, topic, next)
+		for _, name := range []string{path, path + ".log"} {
+			f, err := osOpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to create file %s", name)
+			}
+			_ = f.Close()
+		}
+	}
+	for _, fileInfo := range infos {
+		name := fileInfo.Name()
+		if fileInfo.IsDir() || strings.ContainsRune(name, '.') || name == next {
+			continue
+		}
+		if err = q.removeFileSet(topic, name); err != nil {
+			return nil, errors.Wrapf(err, "unable to remove purged file %s", name)
+		}
+	}
+	return &headers.TopicInfo{MinOffset: info.MaxOffset + 1, MaxOffset: info.MaxOffset}, nil
+}
 
-	return topicInfo, nil
+// fileSetSuffixes are the suffixes of the files stored alongside each dat file
+var fileSetSuffixes = []string{".log", ".ids", ".types"}
+
+// fileSetDat returns the name of the dat file the named log or ids file belongs to
+func fileSetDat(name string) (string, bool) {
+	for _, suffix := range fileSetSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix), true
+		}
+	}
+	return "", false
+}
+
+// removeFileSet removes the file, its log and ids from every queue directory. The dat file is removed
+// first so that a log is never left without the dat file indexing it
+func (q *FileQueue) removeFileSet(topic, name string) error {
+	for _, dir := range q.rootDirNames {
+		path := filepath.Join(dir, topic, name)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if strings.ContainsRune(name, '.') {
+			continue
+		}
+		for _, suffix := range fileSetSuffixes {
+			if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// produceLock returns the lock held while writing to the topic
+func (q *FileQueue) produceLock(topic string) *sync.Mutex {
+	mux, ok := q.produceLocks.Load(topic)
+	if !ok {
+		mux, _ = q.produceLocks.LoadOrStore(topic, &sync.Mutex{})
+	}
+	return mux.(*sync.Mutex)
+}
+
+// topicLock returns the lock guarding the topic's files against removal. Consumes hold the read lock
+// while opening files, once open the files remain readable even if they are removed
+func (q *FileQueue) topicLock(topic string) *sync.RWMutex {
+	mux, ok := q.topicLocks.Load(topic)
+	if !ok {
+		mux, _ = q.topicLocks.LoadOrStore(topic, &sync.RWMutex{})
+	}
+	return mux.(*sync.RWMutex)
 }
@@ -2,8 +2,13 @@ package filequeue
 
 import (
 	"bytes"
+	"context"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -25,13 +30,13 @@ func TestFileQueue_ModifyTopic(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = q.Produce(topic, []int64{5, 5}, uint64(time.Now().Unix()), bytes.NewBuffer([]byte("helloworld"))); err != nil {
+	if err = q.Produce(context.Background(), topic, []int64{5, 5}, uint64(time.Now().Unix()), bytes.NewBuffer([]byte("helloworld"))); err != nil {
 		t.Error(err)
 	}
-	if err = q.Produce(topic, []int64{5, 5}, uint64(time.Now().Unix()), bytes.NewBuffer([]byte("hellothere"))); err != nil {
+	if err = q.Produce(context.Background(), topic, []int64{5, 5}, uint64(time.Now().Unix()), bytes.NewBuffer([]byte("hellothere"))); err != nil {
 		t.Error(err)
 	}
-	if err = q.Produce(topic, []int64{5, 5}, uint64(time.Now().Unix()), bytes.NewBuffer([]byte("helloagain"))); err != nil {
+	if err = q.Produce(context.Background(), topic, []int64{5, 5}, uint64(time.Now().Unix()), bytes.NewBuffer([]byte("helloagain"))); err != nil {
 		t.Error(err)
 	}
 	if tmp, err := os.Create(filepath.Join(dir, topic, "invalid-file")); err != nil {
@@ -56,6 +61,17 @@ func TestFileQueue_ModifyTopic(t *testing.T) {
 		t.Error(info)
 	}
 
+	// truncating past the end keeps the latest file
+	info, err = q.ModifyTopic(topic, headers.ModifyRequest{
+		Truncate: 100,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if info == nil || info.MinOffset != 4 || info.MaxOffset != 5 {
+		t.Error(info)
+	}
+
 	info, err = q.ModifyTopic(topic, headers.ModifyRequest{
 		Truncate: -1,
 	})
@@ -76,3 +92,231 @@ func TestFileQueue_ModifyTopic(t *testing.T) {
 		t.Error(info)
 	}
 }
+
+func TestFileQueue_ModifyTopicFileSets(t *testing.T) {
+	dir1, dir2 := ".haraqa-modify1", ".haraqa-modify2"
+	topic := "modify-sets"
+	defer os.RemoveAll(dir1)
+	defer os.RemoveAll(dir2)
+
+	q, err := New(true, 2, dir1, dir2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	for _, name := range []string{topic, topic + "/nested"} {
+		if err = q.CreateTopic(name); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			if err = q.Produce(context.Background(), name, []int64{5, 5}, uint64(time.Now().Unix()), bytes.NewBufferString("helloworld")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// truncated file sets are removed from every directory, nested topics are untouched
+	if _, err = q.ModifyTopic(topic, headers.ModifyRequest{Truncate: 3}); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{dir1, dir2} {
+		for _, name := range []string{formatName(0), formatName(0) + ".log"} {
+			if _, err = os.Stat(filepath.Join(dir, topic, name)); !os.IsNotExist(err) {
+				t.Error(dir, name, err)
+			}
+			if _, err = os.Stat(filepath.Join(dir, topic, "nested", name)); err != nil {
+				t.Error(dir, name, err)
+			}
+		}
+	}
+
+	// removing the cached file set does not lose later produces
+	if _, err = q.ModifyTopic(topic, headers.ModifyRequest{Before: time.Now().Add(time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Produce(context.Background(), topic, []int64{5}, uint64(time.Now().Unix()), bytes.NewBufferString("again")); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	n, err := q.Consume(context.Background(), topic, 0, -1, w)
+	if err != nil || n != 1 || w.Body.String() != "again" {
+		t.Fatal(n, err, w.Body.String())
+	}
+}
+
+func TestFileQueue_ModifyTopicConcurrent(t *testing.T) {
+	dir := ".haraqa-modify-concurrent"
+	topic := "concurrent"
+	defer os.RemoveAll(dir)
+
+	q, err := New(true, 4, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			if err := q.Produce(context.Background(), topic, []int64{5, 5}, uint64(time.Now().Unix()), bytes.NewBufferString("helloworld")); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			w := httptest.NewRecorder()
+			n, err := q.Consume(context.Background(), topic, 0, -1, w)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if n > 0 && w.Body.Len() != n*5 {
+				t.Errorf("consumed %d messages in %d bytes", n, w.Body.Len())
+				return
+			}
+		}
+	}()
+
+	for i := int64(1); i < 100; i++ {
+		if _, err = q.ModifyTopic(topic, headers.ModifyRequest{Truncate: i * 4}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	// every produced batch was written after the last truncation point
+	info, err := q.InspectTopic(topic)
+	if err != nil || info.MaxOffset != 399 {
+		t.Fatal(info, err)
+	}
+}
+
+func TestFileQueue_Purge(t *testing.T) {
+	dir := ".haraqa-purge"
+	topic := "purge-topic"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	for _, cache := range []bool{true, false} {
+		_ = os.RemoveAll(dir)
+		q, err := New(cache, 2, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = q.CreateTopic(topic); err != nil {
+			t.Fatal(err)
+		}
+		if err = q.CreateTopic(topic + "/nested"); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			if err = q.Produce(context.Background(), topic, []int64{1}, uint64(time.Now().UnixNano()), bytes.NewBufferString("a")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		info, err := q.ModifyTopic(topic, headers.ModifyRequest{Purge: true})
+		if err != nil || info == nil || info.MinOffset != 3 || info.MaxOffset != 2 {
+			t.Fatal(info, err)
+		}
+		if info, err = q.InspectTopic(topic); err != nil || info.MinOffset != 3 || info.MaxOffset != 2 {
+			t.Fatal(info, err)
+		}
+		if _, err = os.Stat(filepath.Join(dir, topic, "nested")); err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		if n, err := q.Consume(context.Background(), topic, 0, -1, w); err != nil || n != 0 {
+			t.Fatal(n, err)
+		}
+
+		// purging an empty topic keeps its offsets
+		if info, err = q.ModifyTopic(topic, headers.ModifyRequest{Purge: true}); err != nil || info.MinOffset != 3 {
+			t.Fatal(info, err)
+		}
+
+		// later messages continue from the purged offsets
+		if err = q.Produce(context.Background(), topic, []int64{1, 1}, uint64(time.Now().UnixNano()), bytes.NewBufferString("bc")); err != nil {
+			t.Fatal(err)
+		}
+		w = httptest.NewRecorder()
+		if n, err := q.Consume(context.Background(), topic, 4, -1, w); err != nil || n != 1 || w.Body.String() != "c" {
+			t.Fatal(n, err, w.Body.String())
+		}
+		if info, err = q.InspectTopic(topic); err != nil || info.MinOffset != 3 || info.MaxOffset != 4 {
+			t.Fatal(info, err)
+		}
+		if err = q.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileQueue_PurgeConsume(t *testing.T) {
+	dir := ".haraqa-purge-consume"
+	topic := "purge-topic"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	q, err := New(false, 100, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Produce(context.Background(), topic, []int64{2, 2, 2}, uint64(time.Now().UnixNano()), bytes.NewBufferString("a0a1a2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = q.ModifyTopic(topic, headers.ModifyRequest{Purge: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file set started by the purge is not aligned to the maximum entries, offsets are read from its base
+	var expected []string
+	for i := 3; i < 9; i++ {
+		b := "b" + strconv.Itoa(i)
+		expected = append(expected, b)
+		if err = q.Produce(context.Background(), topic, []int64{2}, uint64(time.Now().UnixNano()), bytes.NewBufferString(b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, b := range expected {
+		w := httptest.NewRecorder()
+		if n, err := q.Consume(context.Background(), topic, int64(i+3), 1, w); err != nil || n != 1 || w.Body.String() != b {
+			t.Fatal(i+3, n, err, w.Body.String())
+		}
+	}
+	w := httptest.NewRecorder()
+	if n, err := q.Consume(context.Background(), topic, 5, -1, w); err != nil || n != 4 || w.Body.String() != strings.Join(expected[2:], "") {
+		t.Fatal(n, err, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	if n, err := q.Consume(context.Background(), topic, -1, -1, w); err != nil || n != 1 || w.Body.String() != "b8" {
+		t.Fatal(n, err, w.Body.String())
+	}
+
+	// purged offsets are not read from the new file set
+	for _, id := range []int64{0, 2, 9} {
+		w = httptest.NewRecorder()
+		if n, err := q.Consume(context.Background(), topic, id, -1, w); err != nil || n != 0 {
+			t.Fatal(id, n, err, w.Body.String())
+		}
+	}
+}
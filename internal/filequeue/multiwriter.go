@@ -25,6 +25,18 @@ func (mw MultiWriteAtCloser) Close() error {
 	return err
 }
 
+// Sync flushes each writer which supports syncing to disk
+func (mw MultiWriteAtCloser) Sync() error {
+	for _, w := range mw {
+		if s, ok := w.(interface{ Sync() error }); ok {
+			if err := s.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (mw MultiWriteAtCloser) WriteAt(p []byte, off int64) error {
 	for _, w := range mw {
 		n, err := w.WriteAt(p, off)
@@ -39,14 +51,14 @@ func (mw MultiWriteAtCloser) WriteAt(p []byte, off int64) error {
 }
 
 func (mw MultiWriteAtCloser) CopyNAt(r io.Reader, N, off int64) error {
+	return mw.copyNAt(defaultBuffers, r, N, off)
+}
+
+// copyNAt is CopyNAt, reading through a buffer from the pool
+func (mw MultiWriteAtCloser) copyNAt(buffers *bufferPool, r io.Reader, N, off int64) error {
 	// get log buffer
-	buf := bufPool.Get().([]byte)
-	if N > int64(cap(buf)) {
-		buf = make([]byte, N)
-	} else {
-		buf = buf[:N]
-	}
-	defer bufPool.Put(buf)
+	buf := buffers.get(int(N))
+	defer buffers.put(buf)
 
 	// read to buffer
 	_, err := io.ReadAtLeast(r, buf, len(buf))
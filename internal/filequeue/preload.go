@@ -0,0 +1,89 @@
+package filequeue
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Preload warms the caches of the topics written to since the given time, returning the topics which were
+// preloaded. The file names of each topic are indexed for consumes, the producer files are opened if
+// caching is enabled and the latest dat file is read so that the first requests after a restart do not
+// wait on a cold disk cache
+func (q *FileQueue) Preload(ctx context.Context, since time.Time) ([]string, error) {
+	topics, err := q.ListTopics(ctx, "", "", "")
+	if err != nil {
+		return nil, err
+	}
+	var preloaded []string
+	for _, topic := range topics {
+		if err = ctx.Err(); err != nil {
+			return preloaded, err
+		}
+		ok, err := q.preloadTopic(topic, since)
+		if err != nil {
+			return preloaded, errors.Wrapf(err, "unable to preload topic %q", topic)
+		}
+		if ok {
+			preloaded = append(preloaded, topic)
+		}
+	}
+	return preloaded, nil
+}
+
+// preloadTopic warms the caches of the topic if its latest dat file was modified since the given time
+func (q *FileQueue) preloadTopic(topic string, since time.Time) (bool, error) {
+	topicPath := filepath.Join(q.rootDirNames[len(q.rootDirNames)-1], topic)
+	latest, err := getLatestDat(topicPath)
+	if err != nil {
+		return false, err
+	}
+	dat, err := osOpen(filepath.Join(topicPath, latest))
+	if os.IsNotExist(err) {
+		// a topic without messages has nothing to preload
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer dat.Close()
+	stat, err := dat.Stat()
+	if err != nil {
+		return false, err
+	}
+	if stat.ModTime().Before(since) {
+		return false, nil
+	}
+	if _, err = io.Copy(ioutil.Discard, dat); err != nil {
+		return false, err
+	}
+
+	if q.consumeNameCache != nil {
+		mux := q.topicLock(topic)
+		mux.RLock()
+		_, err = q.getConsumeDat(topicPath, topic, 0)
+		mux.RUnlock()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if q.produceCache != nil {
+		mux := q.produceLock(topic)
+		mux.Lock()
+		defer mux.Unlock()
+		if _, ok := q.produceCache.Load(topic); !ok {
+			pf, err := q.openProduceFile(topic, 0)
+			if err != nil {
+				return false, err
+			}
+			q.produceCache.Store(topic, pf)
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,69 @@
+package filequeue
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileQueue_Preload(t *testing.T) {
+	dir := ".haraqa-preload"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	q, err := New(true, 5000, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, topic := range []string{"active", "idle", "empty"} {
+		if err = q.CreateTopic(topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, topic := range []string{"active", "idle"} {
+		if err = q.Produce(ctx, topic, []int64{5}, uint64(time.Now().UnixNano()), bytes.NewBufferString("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err = os.Chtimes(filepath.Join(dir, "idle", formatName(0)), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// after a restart only the recently written topic is preloaded
+	q, err = New(true, 5000, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	preloaded, err := q.Preload(ctx, time.Now().Add(-time.Hour))
+	if err != nil || !reflect.DeepEqual(preloaded, []string{"active"}) {
+		t.Fatal(preloaded, err)
+	}
+	debug := q.DebugInfo()
+	if _, ok := debug.Producers["active"]; !ok || len(debug.Producers) != 1 || len(debug.ConsumeNames["active"]) == 0 {
+		t.Fatal(debug)
+	}
+
+	// the first produce and consume are served from the caches
+	if err = q.Produce(ctx, "active", []int64{5}, uint64(time.Now().UnixNano()), bytes.NewBufferString("world")); err != nil {
+		t.Fatal(err)
+	}
+	if stats := q.CacheStats(); stats.ProduceHits != 1 {
+		t.Fatal(stats)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err = q.Preload(canceled, time.Time{}); err != context.Canceled {
+		t.Fatal(err)
+	}
+}
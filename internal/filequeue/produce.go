@@ -1,72 +1,166 @@
 package filequeue
 
 import (
+	"context"
 	"encoding/binary"
 	"io"
 	"os"
 	"path/filepath"
 	"sort"
-	"strings"
-	"sync"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/haraqa/haraqa/internal/headers"
 	"github.com/pkg/errors"
 )
 
-const datEntryLength = 32
+const (
+	datEntryLength = 32
+	idEntryLength  = 16
+	maxContentType = 255
+)
+
+// Produce copies messages from the reader into the queue log, stamping each with the timestamp given in
+// unix nanoseconds. If the context is done before the messages are written nothing is written
+func (q *FileQueue) Produce(ctx context.Context, topic string, msgSizes []int64, timestamp uint64, r io.Reader) error {
+	return q.ProduceWithIDs(ctx, topic, msgSizes, nil, timestamp, r)
+}
+
+// ProduceWithIDs is Produce, storing the given id of each message alongside it. The ids are returned
+// when the messages are consumed
+func (q *FileQueue) ProduceWithIDs(ctx context.Context, topic string, msgSizes []int64, ids []headers.MessageID, timestamp uint64, r io.Reader) error {
+	return q.ProduceWithTypes(ctx, topic, msgSizes, ids, nil, timestamp, r)
+}
 
-// Produce copies messages from the reader into the queue log
-func (q *FileQueue) Produce(topic string, msgSizes []int64, timestamp uint64, r io.Reader) error {
+// ProduceWithTypes is ProduceWithIDs, storing the given content type of each message alongside it. The
+// content types are returned when the messages are consumed, messages with an empty content type have none
+func (q *FileQueue) ProduceWithTypes(ctx context.Context, topic string, msgSizes []int64, ids []headers.MessageID, types []string, timestamp uint64, r io.Reader) error {
+	if isTrash(topic) {
+		return headers.ErrInvalidTopic
+	}
 	if len(msgSizes) == 0 {
 		return nil
 	}
+	if ids != nil && len(ids) != len(msgSizes) {
+		return errors.Errorf("invalid message ids, expected %d but got %d", len(msgSizes), len(ids))
+	}
+	if types != nil && len(types) != len(msgSizes) {
+		return errors.Errorf("invalid content types, expected %d but got %d", len(msgSizes), len(types))
+	}
+	typed := false
+	for _, t := range types {
+		if len(t) > maxContentType {
+			return errors.Errorf("invalid content type, longer than %d bytes", maxContentType)
+		}
+		typed = typed || t != ""
+	}
+	if !typed {
+		// no types file is needed for messages without content types
+		types = nil
+	}
 
 	if r == nil {
 		return headers.ErrInvalidBodyMissing
 	}
 
+	if atomic.LoadInt32(&q.sync) == 1 {
+		return q.produceSync(ctx, topic, &produceRequest{ctx: ctx, msgSizes: msgSizes, ids: ids, types: types, timestamp: timestamp, r: r})
+	}
+
 	// lock actions on the topic
-	mux, ok := q.produceLocks.Load(topic)
-	if !ok {
-		mux, _ = q.produceLocks.LoadOrStore(topic, &sync.Mutex{})
+	mux := q.produceLock(topic)
+	mux.Lock()
+	defer mux.Unlock()
+
+	// the request may have been abandoned while waiting for other produces to the topic
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	pf, err := q.writeProduceFile(topic, msgSizes, ids, types, timestamp, r)
+	if err != nil {
+		return err
 	}
-	mux.(*sync.Mutex).Lock()
-	defer mux.(*sync.Mutex).Unlock()
+	q.releaseProduceFile(topic, pf)
+	return nil
+}
 
+// writeProduceFile writes the messages to the topic's current file set, the produce lock of the topic
+// must be held
+func (q *FileQueue) writeProduceFile(topic string, msgSizes []int64, ids []headers.MessageID, types []string, timestamp uint64, r io.Reader) (*ProduceFile, error) {
 	// Open files
-	pf, err := q.openProduceFile(topic)
+	pf, err := q.openProduceFile(topic, int64(timestamp))
 	if err != nil {
 		if os.IsNotExist(errors.Cause(err)) {
 			err = headers.ErrTopicDoesNotExist
 		}
-		return errors.Wrap(err, "open producer file error")
+		return nil, diskFullError(errors.Wrap(err, "open producer file error"))
 	}
 	isNewFile := pf.CurrentDatOffset == 0
 
+	// open the ids of the file set the first time they are needed
+	if ids != nil && pf.IDs == nil {
+		if err = q.openProduceIDs(topic, pf); err != nil {
+			if q.produceCache != nil {
+				q.produceCache.Delete(topic)
+			}
+			q.closeProduceFile(pf)
+			return nil, diskFullError(errors.Wrap(err, "open producer ids file error"))
+		}
+	}
+
+	// open the content types of the file set the first time they are needed
+	if types != nil && pf.Types == nil {
+		if err = q.openProduceTypes(topic, pf); err != nil {
+			if q.produceCache != nil {
+				q.produceCache.Delete(topic)
+			}
+			q.closeProduceFile(pf)
+			return nil, diskFullError(errors.Wrap(err, "open producer types file error"))
+		}
+	}
+
 	// Write logs & dats
-	err = pf.Write(msgSizes, timestamp, r)
+	err = pf.Write(msgSizes, ids, types, timestamp, r)
 	if err != nil {
-		return errors.Wrap(err, "write producer file error")
+		return nil, diskFullError(errors.Wrap(err, "write producer file error"))
+	}
+
+	atomic.StoreInt64(&pf.lastUsed, time.Now().UnixNano())
+	if isNewFile {
+		q.evictConsumeName(topic)
 	}
+	return pf, nil
+}
 
-	// Add back to pool
+// releaseProduceFile adds the produce file back to the cache, or closes it if caching is disabled
+func (q *FileQueue) releaseProduceFile(topic string, pf *ProduceFile) {
 	if q.produceCache != nil {
 		q.produceCache.Store(topic, pf)
+	} else {
+		q.closeProduceFile(pf)
 	}
-	if q.consumeNameCache != nil && isNewFile {
-		q.consumeNameCache.Delete(topic)
-	}
-	return nil
 }
 
 type ProduceFile struct {
-	Dats, Logs       MultiWriteAtCloser
+	lastUsed         int64 // unix nanoseconds of the last write, accessed atomically
+	firstTimestamp   int64 // unix nanoseconds of the file set's first message, 0 while it is empty
+	Name             string
+	Dats, Logs, IDs  MultiWriteAtCloser
+	Types            MultiWriteAtCloser
 	NextID           int64
 	CurrentDatOffset int64
 	CurrentLogOffset int64
+	// CurrentTypesOffset is the end of the records of the types files
+	CurrentTypesOffset int64
+	buffers            *bufferPool
 }
 
-func (q *FileQueue) openProduceFile(topic string) (*ProduceFile, error) {
+// openProduceFile returns the file set of the topic to write to, opening the next file set if the current one is
+// full, reached the roll size or, for a write at now in unix nanoseconds, is older than the roll interval. A now
+// of 0 never rolls by time
+func (q *FileQueue) openProduceFile(topic string, now int64) (*ProduceFile, error) {
 	var pf *ProduceFile
 	var datName string
 	var loaded bool
@@ -76,28 +170,25 @@ func (q *FileQueue) openProduceFile(topic string) (*ProduceFile, error) {
 		if pf == nil {
 			return
 		}
-		if len(pf.Dats) > 0 {
-			_ = pf.Dats.Close()
-			pf.Dats = nil
-		}
-		if len(pf.Logs) > 0 {
-			_ = pf.Logs.Close()
-			pf.Logs = nil
-		}
+		q.closeProduceFile(pf)
 		pf.CurrentDatOffset = 0
 		pf.CurrentLogOffset = 0
+		pf.CurrentTypesOffset = 0
+		pf.firstTimestamp = 0
 	}
 
 	// attempt to load from cache
 	if q.produceCache != nil {
 		if tmp, ok := q.produceCache.Load(topic); ok {
 			if pf, ok = tmp.(*ProduceFile); ok {
-				// if we haven't reached the max cap, return
-				if pf.CurrentDatOffset/datEntryLength < q.max {
+				// if we haven't reached the max cap, roll size or roll interval, return
+				if !q.rollDue(pf, now) {
+					atomic.AddInt64(&q.stats.produceHits, 1)
 					return pf, nil
 				}
 
 				// best effort close, prep to open a new set of files
+				atomic.AddInt64(&q.stats.produceEvictions, 1)
 				closeFiles()
 				datName = formatName(pf.NextID)
 				loaded = true
@@ -107,7 +198,8 @@ func (q *FileQueue) openProduceFile(topic string) (*ProduceFile, error) {
 
 	// find nextID based on filesystem
 	if !loaded {
-		pf = &ProduceFile{}
+		atomic.AddInt64(&q.stats.produceMisses, 1)
+		pf = &ProduceFile{buffers: q.bufferPool()}
 		var err error
 		datName, err = getLatestDat(filepath.Join(q.rootDirNames[len(q.rootDirNames)-1], topic))
 		if err != nil {
@@ -117,20 +209,21 @@ func (q *FileQueue) openProduceFile(topic string) (*ProduceFile, error) {
 
 	// open file set
 OpenFileSet:
+	pf.Name = datName
 	for _, dir := range q.rootDirNames {
 		datPath := filepath.Join(dir, topic, datName)
-		dat, err := osOpenFile(datPath, os.O_RDWR|os.O_CREATE, 0666)
+		dat, err := q.openFile(datPath, os.O_RDWR|os.O_CREATE, 0666)
 		if err != nil {
 			closeFiles()
 			return nil, errors.Wrapf(err, "unable to open/create file %q", datPath)
 		}
+		pf.Dats = append(pf.Dats, dat)
 		logPath := filepath.Join(dir, topic, datName+".log")
-		log, err := osOpenFile(logPath, os.O_RDWR|os.O_CREATE, 0666)
+		log, err := q.openFile(logPath, os.O_RDWR|os.O_CREATE, 0666)
 		if err != nil {
 			closeFiles()
 			return nil, errors.Wrapf(err, "unable to open/create file %q", logPath)
 		}
-		pf.Dats = append(pf.Dats, dat)
 		pf.Logs = append(pf.Logs, log)
 	}
 
@@ -144,6 +237,12 @@ OpenFileSet:
 			return nil, errors.Wrapf(err, "unable to stat dat file %q", dat.Name())
 		}
 
+		// an empty file set continues from the offset in its name
+		if pf.NextID, err = strconv.ParseInt(datName, 10, 64); err != nil {
+			closeFiles()
+			return nil, errors.Wrapf(err, "invalid dat file name %q", datName)
+		}
+
 		// read last data entry
 		size := stat.Size()
 		if size >= datEntryLength {
@@ -155,10 +254,14 @@ OpenFileSet:
 			}
 			pf.NextID = int64(binary.LittleEndian.Uint64(data[0:8])) + 1
 			pf.CurrentDatOffset = datEntryLength * (size / datEntryLength)
-			pf.CurrentLogOffset = int64(binary.LittleEndian.Uint64(data[16:24]) + binary.LittleEndian.Uint64(data[24:32]))
+			pf.CurrentLogOffset = int64(binary.LittleEndian.Uint64(data[16:24]) + entrySize(data[:]))
+			if pf.firstTimestamp, err = firstTimestamp(dat); err != nil {
+				closeFiles()
+				return nil, err
+			}
 
-			// check if this file has been filled
-			if size/datEntryLength >= q.max {
+			// check if this file has been filled or is due to roll
+			if q.rollDue(pf, now) {
 				closeFiles()
 				datName = formatName(pf.NextID)
 				goto OpenFileSet
@@ -169,50 +272,97 @@ OpenFileSet:
 	return pf, nil
 }
 
-var bufPool = sync.Pool{New: func() interface{} {
-	return make([]byte, 32*1024)
-}}
+// openProduceIDs opens the ids files of the producer's file set
+func (q *FileQueue) openProduceIDs(topic string, pf *ProduceFile) error {
+	for _, dir := range q.rootDirNames {
+		path := filepath.Join(dir, topic, pf.Name+".ids")
+		f, err := q.openFile(path, os.O_RDWR|os.O_CREATE, 0666)
+		if err != nil {
+			return errors.Wrapf(err, "unable to open/create file %q", path)
+		}
+		pf.IDs = append(pf.IDs, f)
+	}
+	return nil
+}
 
-func (pf *ProduceFile) Write(msgSizes []int64, timestamp uint64, r io.Reader) error {
+func (pf *ProduceFile) Write(msgSizes []int64, ids []headers.MessageID, types []string, timestamp uint64, r io.Reader) error {
 	var n int
 	offset := pf.CurrentLogOffset
 	nextID := pf.NextID
 
 	// get data buffer
-	data := bufPool.Get().([]byte)
-	if datEntryLength*len(msgSizes) > cap(data) {
-		data = make([]byte, datEntryLength*len(msgSizes))
-	} else {
-		data = data[:datEntryLength*len(msgSizes)]
+	buffers := pf.buffers
+	if buffers == nil {
+		buffers = defaultBuffers
 	}
-	defer bufPool.Put(data)
+	data := buffers.get(datEntryLength * len(msgSizes))
+	defer buffers.put(data)
 
 	// create data entries
-	for _, size := range msgSizes {
+	var flags byte
+	if ids != nil {
+		flags |= entryFlagID
+	}
+	for i, size := range msgSizes {
+		if size < 0 || size > maxEntrySize {
+			return errors.Errorf("invalid message size %d", size)
+		}
 		binary.LittleEndian.PutUint64(data[n:], uint64(nextID))
 		n += 8
 		binary.LittleEndian.PutUint64(data[n:], timestamp)
 		n += 8
 		binary.LittleEndian.PutUint64(data[n:], uint64(offset))
 		n += 8
-		binary.LittleEndian.PutUint64(data[n:], uint64(size))
+		if types != nil && types[i] != "" {
+			putEntrySize(data[n-24:], uint64(size), flags|entryFlagType)
+		} else {
+			putEntrySize(data[n-24:], uint64(size), flags)
+		}
 		n += 8
 		offset += size
 		nextID++
 	}
 
 	// write logs
-	err := pf.Logs.CopyNAt(r, offset-pf.CurrentLogOffset, pf.CurrentLogOffset)
+	err := pf.Logs.copyNAt(buffers, r, offset-pf.CurrentLogOffset, pf.CurrentLogOffset)
 	if err != nil {
 		return errors.Wrap(err, "unable to copy to log file")
 	}
 
+	// write ids, before the dat so that consumers never see an entry without its id
+	if ids != nil {
+		b := make([]byte, 0, len(ids)*idEntryLength)
+		for i := range ids {
+			b = append(b, ids[i][:]...)
+		}
+		err = pf.IDs.WriteAt(b, pf.CurrentDatOffset/datEntryLength*idEntryLength)
+		if err != nil {
+			return errors.Wrap(err, "unable to write to ids file")
+		}
+	}
+
+	// write content types, before the dat so that consumers never see an entry without its type
+	var typeRecords []byte
+	if types != nil {
+		typeRecords = appendTypeRecords(nil, pf.CurrentDatOffset/datEntryLength, types)
+		if len(typeRecords) > 0 {
+			err = pf.Types.WriteAt(typeRecords, pf.CurrentTypesOffset)
+			if err != nil {
+				return errors.Wrap(err, "unable to write to types file")
+			}
+		}
+	}
+
 	// write dat
 	err = pf.Dats.WriteAt(data, pf.CurrentDatOffset)
 	if err != nil {
 		return errors.Wrap(err, "unable to write to dat file")
 	}
 
+	if pf.CurrentDatOffset == 0 {
+		pf.firstTimestamp = int64(timestamp)
+	}
+	pf.CurrentTypesOffset += int64(len(typeRecords))
 	pf.NextID = nextID
 	pf.CurrentDatOffset += int64(len(data))
 	pf.CurrentLogOffset = offset
@@ -231,7 +381,8 @@ func getLatestDat(path string) (string, error) {
 	}
 	sort.Sort(sortableDirNames(names))
 	for i := range names {
-		if !strings.HasSuffix(names[i], ".log") {
+		// skip logs and nested topics
+		if _, err := strconv.ParseUint(names[i], 10, 64); err == nil {
 			return names[i], nil
 		}
 	}
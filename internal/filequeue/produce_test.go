@@ -2,7 +2,10 @@ package filequeue
 
 import (
 	"bytes"
+	"context"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"testing"
@@ -49,19 +52,19 @@ func TestFileQueue_Produce(t *testing.T) {
 	}()
 
 	// no messages
-	err = q.Produce(topic, nil, 0, nil)
+	err = q.Produce(context.Background(), topic, nil, 0, nil)
 	if err != nil {
 		t.Error(err)
 	}
 
 	// no body
-	err = q.Produce(topic, []int64{123}, 0, nil)
+	err = q.Produce(context.Background(), topic, []int64{123}, 0, nil)
 	if !errors.Is(err, headers.ErrInvalidBodyMissing) {
 		t.Error(err)
 	}
 
 	// no topic
-	err = q.Produce(topic, []int64{123}, 0, bytes.NewBuffer(nil))
+	err = q.Produce(context.Background(), topic, []int64{123}, 0, bytes.NewBuffer(nil))
 	if !errors.Is(err, headers.ErrTopicDoesNotExist) {
 		t.Error(err)
 	}
@@ -78,7 +81,7 @@ func TestFileQueue_Produce(t *testing.T) {
 		if _, err = r.Write(input); err != nil {
 			t.Error(err)
 		}
-		err = q.Produce(topic, []int64{5, int64(len(input) - 5)}, uint64(time.Now().Unix()), r)
+		err = q.Produce(context.Background(), topic, []int64{5, int64(len(input) - 5)}, uint64(time.Now().Unix()), r)
 		if err != nil {
 			t.Error(err)
 		}
@@ -89,7 +92,7 @@ func TestFileQueue_Produce(t *testing.T) {
 		if _, err = r.Write(input); err != nil {
 			t.Error(err)
 		}
-		err = q.Produce(topic, []int64{5, int64(len(input) - 5)}, uint64(time.Now().Unix()), r)
+		err = q.Produce(context.Background(), topic, []int64{5, int64(len(input) - 5)}, uint64(time.Now().Unix()), r)
 		if err != nil {
 			t.Error(err)
 		}
@@ -101,7 +104,7 @@ func TestFileQueue_Produce(t *testing.T) {
 		if _, err = r.Write(input); err != nil {
 			t.Error(err)
 		}
-		err = q.Produce(topic, []int64{5, int64(len(input) - 5)}, uint64(time.Now().Unix()), r)
+		err = q.Produce(context.Background(), topic, []int64{5, int64(len(input) - 5)}, uint64(time.Now().Unix()), r)
 		if err != nil {
 			t.Error(err)
 		}
@@ -113,10 +116,91 @@ func TestFileQueue_Produce(t *testing.T) {
 		if _, err = r.Write(input); err != nil {
 			t.Error(err)
 		}
-		err = q.Produce(topic, []int64{5, int64(len(input) - 5)}, uint64(time.Now().Unix()), r)
+		err = q.Produce(context.Background(), topic, []int64{5, int64(len(input) - 5)}, uint64(time.Now().Unix()), r)
 		if err != nil {
 			t.Error(err)
 		}
 	}
 
 }
+
+func TestFileQueue_ProduceWithIDs(t *testing.T) {
+	topic := "ids"
+	dirs := []string{".haraqa-ids1", ".haraqa-ids2"}
+	for _, dir := range dirs {
+		_ = os.RemoveAll(dir)
+		defer os.RemoveAll(dir)
+	}
+	q, err := New(true, 3, dirs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+
+	newIDs := func(n int) []headers.MessageID {
+		ids := make([]headers.MessageID, n)
+		for i := range ids {
+			if ids[i], err = headers.NewMessageID(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return ids
+	}
+	if err = q.ProduceWithIDs(context.Background(), topic, []int64{1, 1}, newIDs(1), 0, bytes.NewBufferString("ab")); err == nil {
+		t.Fatal("expected id count error")
+	}
+
+	// the first message has no id, later messages span two file sets
+	if err = q.Produce(context.Background(), topic, []int64{1}, 0, bytes.NewBufferString("a")); err != nil {
+		t.Fatal(err)
+	}
+	ids := newIDs(4)
+	if err = q.ProduceWithIDs(context.Background(), topic, []int64{1, 1}, ids[:2], 0, bytes.NewBufferString("bc")); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.ProduceWithIDs(context.Background(), topic, []int64{1, 1}, ids[2:], 0, bytes.NewBufferString("de")); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		if _, err = os.Stat(filepath.Join(dir, topic, formatName(3)+".ids")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	consumeIDs := func(id, limit int64) []headers.MessageID {
+		w := httptest.NewRecorder()
+		if _, err := q.Consume(context.Background(), topic, id, limit, w); err != nil {
+			t.Fatal(err)
+		}
+		ids, err := headers.ReadMessageIDs(w.Header())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids
+	}
+	if got := consumeIDs(0, -1); len(got) != 3 || !got[0].IsZero() || got[1] != ids[0] || got[2] != ids[1] {
+		t.Fatal(got)
+	}
+	if got := consumeIDs(2, 1); len(got) != 1 || got[0] != ids[1] {
+		t.Fatal(got)
+	}
+	if got := consumeIDs(3, -1); len(got) != 2 || got[0] != ids[2] || got[1] != ids[3] {
+		t.Fatal(got)
+	}
+
+	// ids are removed along with their file set
+	if _, err = q.ModifyTopic(topic, headers.ModifyRequest{Truncate: 4}); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		if _, err = os.Stat(filepath.Join(dir, topic, formatName(0)+".ids")); !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+	}
+	if got := consumeIDs(3, -1); len(got) != 2 || got[0] != ids[2] {
+		t.Fatal(got)
+	}
+}
@@ -0,0 +1,149 @@
+package filequeue
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// maxReadAheads is the number of prefetches run at once, consumes beyond it are not prefetched
+	maxReadAheads = 4
+	// maxSequential is the number of consume positions tracked to detect sequential consumers
+	maxSequential = 1024
+)
+
+// readAhead prefetches the messages following sequential consumes into the page cache
+type readAhead struct {
+	size    int64
+	mux     sync.Mutex
+	next    map[string]struct{}
+	running chan struct{}
+	wg      sync.WaitGroup
+}
+
+// SetReadAhead enables prefetching for sequential consumers. After a consume which continues from where
+// a previous consume ended, up to size bytes of the following messages are read in the background, and
+// the next file set is opened and read once the consumer nears the end of a full one, so that the next
+// consume is served from the page cache. A size of 0 disables read-ahead
+func (q *FileQueue) SetReadAhead(size int64) {
+	if size < 0 {
+		size = 0
+	}
+	atomic.StoreInt64(&q.readAhead.size, size)
+}
+
+// sequential records the position after a consume of count entries from index of the dat file, returning
+// true if the consume started where an earlier consume ended
+func (r *readAhead) sequential(path string, index, count, entries, max int64) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.next == nil || len(r.next) >= maxSequential {
+		r.next = make(map[string]struct{})
+	}
+	key := path + ":" + strconv.FormatInt(index, 10)
+	_, ok := r.next[key]
+	delete(r.next, key)
+
+	// a consumer reaching the end of a full file set continues from the start of the next one
+	end := index + count
+	if end >= entries && entries >= max {
+		base, err := strconv.ParseInt(filepath.Base(path), 10, 64)
+		if err == nil {
+			r.next[filepath.Join(filepath.Dir(path), formatName(base+entries))+":0"] = struct{}{}
+		}
+	} else {
+		r.next[path+":"+strconv.FormatInt(end, 10)] = struct{}{}
+	}
+	return ok
+}
+
+// prefetch reads ahead of a sequential consume of count entries from index of the dat file in the
+// background, unless read-ahead is disabled or too many prefetches are already running
+func (q *FileQueue) prefetch(topic, path string, index, count, entries int64) {
+	size := atomic.LoadInt64(&q.readAhead.size)
+	if size <= 0 || !q.readAhead.sequential(path, index, count, entries, q.max) {
+		return
+	}
+	select {
+	case q.readAhead.running <- struct{}{}:
+	default:
+		return
+	}
+	q.readAhead.wg.Add(1)
+	go func() {
+		defer func() {
+			<-q.readAhead.running
+			q.readAhead.wg.Done()
+		}()
+		atomic.AddInt64(&q.stats.readAheads, 1)
+
+		// read the entries following the consume, up to the size of the read-ahead
+		end := index + count
+		if end < entries {
+			size -= q.warm(path, end, count, size)
+		}
+		if end+count < entries || entries < q.max || size <= 0 {
+			return
+		}
+
+		// the next consume reaches into the next file set, warm its name and first messages
+		base, err := strconv.ParseInt(filepath.Base(path), 10, 64)
+		if err != nil {
+			return
+		}
+		next := filepath.Join(filepath.Dir(path), formatName(base+entries))
+		if q.consumeNameCache != nil {
+			mux := q.topicLock(topic)
+			mux.RLock()
+			_, _ = q.getConsumeDat(filepath.Dir(path), topic, base+entries)
+			mux.RUnlock()
+		}
+		q.warm(next, 0, count, size)
+	}()
+}
+
+// warm reads the dat entries of count messages from index in the file set and up to size bytes of their
+// messages, returning the number of message bytes read
+func (q *FileQueue) warm(path string, index, count, size int64) int64 {
+	if max := atomic.LoadInt64(&q.files.max); max > 0 && atomic.LoadInt64(&q.stats.openFiles)+2 > max {
+		return 0
+	}
+	dat, err := q.openFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return 0
+	}
+	defer func() {
+		_ = dat.Close()
+		q.releaseFiles(1)
+	}()
+	data := make([]byte, count*datEntryLength)
+	length, err := dat.ReadAt(data, index*datEntryLength)
+	if length < datEntryLength || (err != nil && err != io.EOF) {
+		return 0
+	}
+	start := int64(binary.LittleEndian.Uint64(data[16:]))
+	var total int64
+	for i := 0; i+datEntryLength <= length && total < size; i += datEntryLength {
+		total += int64(entrySize(data[i:]))
+	}
+	if total > size {
+		total = size
+	}
+
+	log, err := q.openFile(path+".log", os.O_RDONLY, 0)
+	if err != nil {
+		return 0
+	}
+	defer func() {
+		_ = log.Close()
+		q.releaseFiles(1)
+	}()
+	n, _ := io.Copy(ioutil.Discard, io.NewSectionReader(log, start, total))
+	return n
+}
@@ -0,0 +1,84 @@
+package filequeue
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadAhead_Sequential(t *testing.T) {
+	var r readAhead
+	path := filepath.Join("root", "topic", formatName(0))
+	if r.sequential(path, 0, 2, 4, 4) {
+		t.Fatal("first consume cannot be sequential")
+	}
+	if !r.sequential(path, 2, 2, 4, 4) {
+		t.Fatal("expected sequential consume")
+	}
+	if r.sequential(path, 2, 2, 4, 4) {
+		t.Fatal("repeated consume cannot be sequential")
+	}
+	// the end of a full file set continues in the next
+	if !r.sequential(filepath.Join("root", "topic", formatName(4)), 0, 1, 1, 4) {
+		t.Fatal("expected sequential consume of the next file set")
+	}
+}
+
+func TestFileQueue_ReadAhead(t *testing.T) {
+	dir := ".haraqa-readahead"
+	topic := "readahead"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	q, err := New(true, 4, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"a", "b", "c", "d", "e", "f"} {
+		if err = q.Produce(context.Background(), topic, []int64{1}, uint64(time.Now().UnixNano()), bytes.NewBufferString(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	consume := func(id, limit int64) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		if n, err := q.Consume(context.Background(), topic, id, limit, w); err != nil || int64(n) != limit {
+			t.Fatal(n, err)
+		}
+		q.readAhead.wg.Wait()
+	}
+
+	// read-ahead is disabled by default
+	consume(0, 2)
+	consume(2, 2)
+	if n := q.CacheStats().ReadAheads; n != 0 {
+		t.Fatal(n)
+	}
+
+	q.SetReadAhead(1 << 20)
+	consume(0, 2)
+	if n := q.CacheStats().ReadAheads; n != 0 {
+		t.Fatal(n)
+	}
+	consume(2, 2)
+	if n := q.CacheStats().ReadAheads; n != 1 {
+		t.Fatal(n)
+	}
+	// the consumer continues into the next file set
+	consume(4, 2)
+	if n := q.CacheStats().ReadAheads; n != 2 {
+		t.Fatal(n)
+	}
+
+	q.SetReadAhead(-1)
+	if q.readAhead.size != 0 {
+		t.Fatal(q.readAhead.size)
+	}
+}
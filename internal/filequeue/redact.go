@@ -0,0 +1,82 @@
+package filequeue
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// redactRange overwrites the payload of the messages with offsets in the range with the redacted tombstone,
+// in place in the topic's logs. The dat and ids files are unchanged, so redacted messages keep their
+// offsets, sizes, timestamps and ids. The caller must hold the topic's produce and topic locks
+func (q *FileQueue) redactRange(topic string, r headers.OffsetRange) error {
+	if r.From < 0 || r.To < r.From {
+		return headers.ErrInvalidMessageID
+	}
+	topicPath := filepath.Join(q.rootDirNames[len(q.rootDirNames)-1], topic)
+	dir, err := osOpen(topicPath)
+	if err != nil {
+		return errors.Wrapf(err, "unable to open topic %q", topic)
+	}
+	infos, err := dir.Readdir(-1)
+	_ = dir.Close()
+	if err != nil {
+		return errors.Wrapf(err, "unable to read topic %q", topic)
+	}
+	for _, info := range infos {
+		if info.IsDir() || strings.ContainsRune(info.Name(), '.') {
+			continue
+		}
+		base, err := strconv.ParseInt(info.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		// skip file sets entirely outside of the range
+		if base > r.To || base+info.Size()/datEntryLength <= r.From {
+			continue
+		}
+		for _, root := range q.rootDirNames {
+			if err = redactFileSet(filepath.Join(root, topic, info.Name()), r); err != nil {
+				return errors.Wrapf(err, "unable to redact messages from %s", info.Name())
+			}
+		}
+	}
+	return nil
+}
+
+// redactFileSet overwrites the messages in the range in the log of the dat file at path, syncing the log
+// before returning
+func redactFileSet(path string, r headers.OffsetRange) error {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dat = dat[:len(dat)-len(dat)%datEntryLength]
+	log, err := osOpenFile(path+".log", os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(dat); i += datEntryLength {
+		entry := dat[i : i+datEntryLength]
+		id := int64(binary.LittleEndian.Uint64(entry[0:8]))
+		start, size := binary.LittleEndian.Uint64(entry[16:24]), entrySize(entry)
+		if id < r.From || id > r.To || size == 0 {
+			continue
+		}
+		if _, err = log.WriteAt(headers.Redacted(int(size)), int64(start)); err != nil {
+			_ = log.Close()
+			return err
+		}
+	}
+	if err = log.Sync(); err != nil {
+		_ = log.Close()
+		return err
+	}
+	return log.Close()
+}
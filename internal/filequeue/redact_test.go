@@ -0,0 +1,83 @@
+package filequeue
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestFileQueue_RedactRange(t *testing.T) {
+	dirs := []string{".haraqa-redact-1", ".haraqa-redact-2"}
+	topic := "redact-topic"
+	for _, dir := range dirs {
+		_ = os.RemoveAll(dir)
+		defer os.RemoveAll(dir)
+	}
+
+	q, err := New(true, 3, dirs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]headers.MessageID, 5)
+	for i := range ids {
+		ids[i] = headers.MessageID{byte(i + 1)}
+	}
+	if err = q.ProduceWithIDs(context.Background(), topic, []int64{1, 2, 3}, ids[:3], uint64(time.Now().UnixNano()), bytes.NewBufferString("abbccc")); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.ProduceWithIDs(context.Background(), topic, []int64{4, 5}, ids[3:], uint64(time.Now().UnixNano()), bytes.NewBufferString("ddddeeeee")); err != nil {
+		t.Fatal(err)
+	}
+
+	// redact across the boundary of the first and second file sets
+	info, err := q.ModifyTopic(topic, headers.ModifyRequest{Redact: &headers.OffsetRange{From: 1, To: 3}})
+	if err != nil || info == nil || info.MinOffset != 0 || info.MaxOffset != 4 {
+		t.Fatal(info, err)
+	}
+	w := httptest.NewRecorder()
+	if _, err = q.Consume(context.Background(), topic, 0, -1, w); err != nil {
+		t.Fatal(err)
+	}
+	sizes, _ := headers.ReadSizes(w.Header())
+	consumedIDs, _ := headers.ReadMessageIDs(w.Header())
+	want := "a" + string(headers.Redacted(2)) + string(headers.Redacted(3))
+	if len(sizes) != 3 || sizes[1] != 2 || sizes[2] != 3 || w.Body.String() != want || consumedIDs[1] != ids[1] {
+		t.Fatal(sizes, consumedIDs, w.Body.String())
+	}
+
+	// every queue directory is redacted in place
+	for _, dir := range dirs {
+		b, err := ioutil.ReadFile(dir + "/" + topic + "/" + formatName(3) + ".log")
+		if err != nil || string(b) != string(headers.Redacted(4))+"eeeee" {
+			t.Fatal(dir, b, err)
+		}
+	}
+
+	// redacting can be combined with deleting
+	if _, err = q.ModifyTopic(topic, headers.ModifyRequest{Delete: &headers.OffsetRange{From: 0, To: 0}, Redact: &headers.OffsetRange{From: 4, To: 4}}); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	if _, err = q.Consume(context.Background(), topic, 3, -1, w); err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != string(headers.Redacted(4))+string(headers.Redacted(5)) {
+		t.Fatal(w.Body.String())
+	}
+
+	for _, r := range []headers.OffsetRange{{From: -1, To: 2}, {From: 3, To: 2}} {
+		if _, err = q.ModifyTopic(topic, headers.ModifyRequest{Redact: &r}); err != headers.ErrInvalidMessageID {
+			t.Fatal(r, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package filequeue
+
+import (
+	"encoding/binary"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SetRollInterval starts a new file set for the next message produced to a topic once the first message
+// of its current file set is older than the interval, even if the file set is not full. Time based
+// retention and tiering can then remove whole file sets of old messages. An interval of 0 only rolls full
+// file sets
+func (q *FileQueue) SetRollInterval(interval time.Duration) {
+	if interval < 0 {
+		interval = 0
+	}
+	atomic.StoreInt64(&q.rollInterval, int64(interval))
+}
+
+// SetRollSize starts a new file set for the next message produced to a topic once the messages of its current
+// file set hold at least size bytes, even if the file set has room for more entries. Topics of large messages
+// then do not write single files of many gigabytes. A size of 0 only rolls file sets by entries
+func (q *FileQueue) SetRollSize(size int64) {
+	if size < 0 {
+		size = 0
+	}
+	atomic.StoreInt64(&q.rollSize, size)
+}
+
+// rollDue returns true if the file set is closed by a write at now in unix nanoseconds, because it holds the
+// maximum number of entries, its messages reach the roll size or its first message is older than the roll
+// interval. A now of 0 never rolls by time
+func (q *FileQueue) rollDue(pf *ProduceFile, now int64) bool {
+	if pf.CurrentDatOffset/datEntryLength >= q.max {
+		return true
+	}
+	if size := atomic.LoadInt64(&q.rollSize); size > 0 && pf.CurrentLogOffset >= size {
+		return true
+	}
+	interval := atomic.LoadInt64(&q.rollInterval)
+	return interval > 0 && now > 0 && pf.firstTimestamp > 0 && now-pf.firstTimestamp >= interval
+}
+
+// firstTimestamp reads the timestamp of the first entry of the dat file
+func firstTimestamp(dat *os.File) (int64, error) {
+	var data [datEntryLength]byte
+	if _, err := dat.ReadAt(data[:], 0); err != nil {
+		return 0, errors.Wrapf(err, "unable to read first entry of dat file %q", dat.Name())
+	}
+	return int64(binary.LittleEndian.Uint64(data[8:16])), nil
+}
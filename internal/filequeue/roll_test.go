@@ -0,0 +1,234 @@
+package filequeue
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileQueue_RollInterval(t *testing.T) {
+	dir := ".haraqa-roll-interval"
+	topic := "rolling"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	q, err := New(true, 5000, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+	q.SetRollInterval(time.Hour)
+	start := time.Now()
+	produce := func(at time.Time) {
+		t.Helper()
+		if err := q.Produce(ctx, topic, []int64{5}, uint64(at.UnixNano()), bytes.NewBufferString("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exists := func(id int64) bool {
+		_, err := os.Stat(filepath.Join(dir, topic, formatName(id)))
+		return err == nil
+	}
+
+	produce(start)
+	produce(start.Add(59 * time.Minute))
+	if exists(2) {
+		t.Fatal("file set rolled before the interval")
+	}
+	produce(start.Add(time.Hour))
+	if !exists(2) {
+		t.Fatal("expected a new file set after the interval")
+	}
+
+	// the interval is measured from the first message of a reopened file set
+	if err = q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	q, err = New(true, 5000, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	q.SetRollInterval(time.Hour)
+	produce(start.Add(90 * time.Minute))
+	if exists(4) {
+		t.Fatal("file set rolled before the interval")
+	}
+	produce(start.Add(2 * time.Hour))
+	if !exists(4) {
+		t.Fatal("expected a new file set after the interval")
+	}
+
+	// a disabled interval only rolls full file sets
+	q.SetRollInterval(-time.Second)
+	produce(start.Add(48 * time.Hour))
+	if exists(5) {
+		t.Fatal("file set rolled with the interval disabled")
+	}
+	msgs := 0
+	for _, id := range []int64{0, 2, 4} {
+		info, err := os.Stat(filepath.Join(dir, topic, formatName(id)+".log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs += int(info.Size() / 5)
+	}
+	if msgs != 6 {
+		t.Fatal(msgs)
+	}
+}
+
+func TestFileQueue_RollIntervalConsume(t *testing.T) {
+	dir := ".haraqa-roll-interval-consume"
+	topic := "rolling"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	q, err := New(true, 5000, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+	q.SetRollInterval(time.Hour)
+	start := time.Now()
+	for i, minutes := range []time.Duration{0, 61, 70, 80, 130, 140} {
+		if err := q.Produce(ctx, topic, []int64{2}, uint64(start.Add(minutes*time.Minute).UnixNano()), bytes.NewBufferString("m"+strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the file sets start at 0, 1 and 4, each offset is read from the file set holding it
+	for _, id := range []int64{1, 4} {
+		if _, err = os.Stat(filepath.Join(dir, topic, formatName(id))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id := int64(0); id < 6; id++ {
+		w := httptest.NewRecorder()
+		if n, err := q.Consume(ctx, topic, id, 1, w); err != nil || n != 1 || w.Body.String() != "m"+strconv.FormatInt(id, 10) {
+			t.Fatal(id, n, err, w.Body.String())
+		}
+	}
+	w := httptest.NewRecorder()
+	if n, err := q.Consume(ctx, topic, 1, 5, w); err != nil || n != 5 || w.Body.String() != "m1m2m3m4m5" {
+		t.Fatal(n, err, w.Body.String())
+	}
+}
+
+func TestFileQueue_RollSize(t *testing.T) {
+	dir := ".haraqa-roll-size"
+	topic := "rolling"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	q, err := New(true, 5000, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+	q.SetRollSize(8)
+	produce := func(msgs ...string) {
+		t.Helper()
+		sizes := make([]int64, len(msgs))
+		for i := range msgs {
+			sizes[i] = int64(len(msgs[i]))
+		}
+		if err := q.Produce(ctx, topic, sizes, uint64(time.Now().UnixNano()), bytes.NewBufferString(strings.Join(msgs, ""))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exists := func(id int64) bool {
+		_, err := os.Stat(filepath.Join(dir, topic, formatName(id)))
+		return err == nil
+	}
+
+	// a batch is never split, the file set rolls once it reaches the size
+	produce("hello")
+	produce("hello", "world")
+	if exists(1) || exists(3) {
+		t.Fatal("file set rolled before reaching the size")
+	}
+	produce("a")
+	if !exists(3) {
+		t.Fatal("expected a new file set after reaching the size")
+	}
+
+	// the size of a reopened file set is kept
+	if err = q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	q, err = New(true, 5000, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	q.SetRollSize(8)
+	produce("bcdefgh")
+	if exists(5) {
+		t.Fatal("file set rolled before reaching the size")
+	}
+	produce("i")
+	if !exists(5) {
+		t.Fatal("expected a new file set after reaching the size")
+	}
+
+	// a disabled size only rolls full file sets
+	q.SetRollSize(-1)
+	produce("jklmnopqrstuvwxyz")
+	if exists(6) {
+		t.Fatal("file set rolled with the size disabled")
+	}
+}
+
+func TestFileQueue_RollSizeConsume(t *testing.T) {
+	dir := ".haraqa-roll-size-consume"
+	topic := "rolling"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	q, err := New(true, 5000, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+	q.SetRollSize(10)
+	expected := []string{"0123456789", "1", "2", "3", "4", "5", "6"}
+	for _, msg := range expected {
+		if err := q.Produce(ctx, topic, []int64{int64(len(msg))}, uint64(time.Now().UnixNano()), bytes.NewBufferString(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first message fills its file set, the rest start a file set at offset 1
+	if _, err = os.Stat(filepath.Join(dir, topic, formatName(1))); err != nil {
+		t.Fatal(err)
+	}
+	for id, msg := range expected {
+		w := httptest.NewRecorder()
+		if n, err := q.Consume(ctx, topic, int64(id), 1, w); err != nil || n != 1 || w.Body.String() != msg {
+			t.Fatal(id, n, err, w.Body.String())
+		}
+	}
+	w := httptest.NewRecorder()
+	if n, err := q.Consume(ctx, topic, 0, 7, w); err != nil || n != 7 || w.Body.String() != strings.Join(expected, "") {
+		t.Fatal(n, err, w.Body.String())
+	}
+}
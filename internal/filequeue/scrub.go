@@ -0,0 +1,259 @@
+package filequeue
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// Scrub verifies the sealed file sets of the topic, every file set but the latest, in each queue directory.
+// A copy is corrupt if it is missing, if its dat entries do not describe its log, or if it differs from
+// the copy held by the most directories. If repair is true corrupt copies are replaced with a good copy.
+// Files are checked under the topic's read lock so produces and consumes continue during a scrub, only
+// the repair of a corrupt file set blocks the topic
+func (q *FileQueue) Scrub(topic string, repair bool) ([]headers.Corruption, error) {
+	if isTrash(topic) {
+		return nil, headers.ErrInvalidTopic
+	}
+	names, err := q.sealedDats(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	var corruptions []headers.Corruption
+	for _, name := range names {
+		found, err := q.scrubFileSet(topic, name, false)
+		if err != nil {
+			return corruptions, err
+		}
+		if len(found) > 0 && repair {
+			// check the file set again, it may have been rewritten before the topic was locked
+			found, err = q.scrubFileSet(topic, name, true)
+			if err != nil {
+				return corruptions, err
+			}
+		}
+		corruptions = append(corruptions, found...)
+	}
+	return corruptions, nil
+}
+
+// sealedDats returns the names of the topic's dat files which are no longer written to
+func (q *FileQueue) sealedDats(topic string) ([]string, error) {
+	topicLock := q.topicLock(topic)
+	topicLock.RLock()
+	defer topicLock.RUnlock()
+
+	dir, err := osOpen(filepath.Join(q.rootDirNames[len(q.rootDirNames)-1], topic))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, headers.ErrTopicDoesNotExist
+		}
+		return nil, errors.Wrapf(err, "unable to open topic %q", topic)
+	}
+	names, err := dir.Readdirnames(-1)
+	_ = dir.Close()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read topic %q", topic)
+	}
+
+	var dats []string
+	for _, name := range names {
+		if _, err := strconv.ParseUint(name, 10, 64); err == nil {
+			dats = append(dats, name)
+		}
+	}
+	if len(dats) == 0 {
+		return nil, nil
+	}
+	sort.Sort(sort.Reverse(sortableDirNames(dats)))
+	return dats[:len(dats)-1], nil
+}
+
+// scrubCopy is a single queue directory's copy of a file set
+type scrubCopy struct {
+	dir    string
+	digest [sha256.Size]byte
+	reason string
+}
+
+// scrubFileSet checks every copy of the file set, holding the topic's write lock and replacing corrupt
+// copies if repair is true
+func (q *FileQueue) scrubFileSet(topic, name string, repair bool) ([]headers.Corruption, error) {
+	topicLock := q.topicLock(topic)
+	if repair {
+		topicLock.Lock()
+		defer topicLock.Unlock()
+	} else {
+		topicLock.RLock()
+		defer topicLock.RUnlock()
+	}
+
+	base, err := strconv.ParseInt(name, 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid dat file name %q", name)
+	}
+	copies := make([]scrubCopy, 0, len(q.rootDirNames))
+	for _, dir := range q.rootDirNames {
+		c := scrubCopy{dir: dir}
+		c.digest, c.reason, err = checkFileSet(filepath.Join(dir, topic, name), base)
+		if err != nil {
+			return nil, err
+		}
+		copies = append(copies, c)
+	}
+
+	// the good copy is the valid copy held by the most directories, preferring the copy consumes read
+	good := -1
+	var goodCount int
+	for i := len(copies) - 1; i >= 0; i-- {
+		if copies[i].reason != "" {
+			continue
+		}
+		var count int
+		for j := range copies {
+			if copies[j].reason == "" && copies[j].digest == copies[i].digest {
+				count++
+			}
+		}
+		if count > goodCount {
+			good, goodCount = i, count
+		}
+	}
+
+	var corruptions []headers.Corruption
+	for i := range copies {
+		if good >= 0 && copies[i].reason == "" && copies[i].digest == copies[good].digest {
+			continue
+		}
+		c := headers.Corruption{Topic: topic, File: name, Dir: copies[i].dir, Reason: copies[i].reason}
+		if c.Reason == "" {
+			c.Reason = "differs from the copy in " + copies[good].dir
+		}
+		if repair && good >= 0 {
+			err = copyFileSet(filepath.Join(copies[good].dir, topic, name), filepath.Join(copies[i].dir, topic, name))
+			if err != nil {
+				return corruptions, errors.Wrapf(err, "unable to repair %s in %s", name, copies[i].dir)
+			}
+			c.Repaired = true
+		}
+		corruptions = append(corruptions, c)
+	}
+	if len(corruptions) > 0 && repair {
+		q.evictConsumeName(topic)
+	}
+	return corruptions, nil
+}
+
+// checkFileSet returns the digest of the file set at path, and the reason it is corrupt if its dat entries
+// do not describe its log. The entries must have consecutive ids starting at base and contiguous offsets
+// ending at the end of the log
+func checkFileSet(path string, base int64) ([sha256.Size]byte, string, error) {
+	var digest [sha256.Size]byte
+	dat, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return digest, "missing dat file", nil
+	}
+	if err != nil {
+		return digest, "", err
+	}
+	if len(dat)%datEntryLength != 0 {
+		return digest, "truncated dat entry", nil
+	}
+
+	var offset uint64
+	for i := 0; i < len(dat); i += datEntryLength {
+		id := int64(binary.LittleEndian.Uint64(dat[i:]))
+		if id != base+int64(i/datEntryLength) {
+			return digest, "unexpected message id " + strconv.FormatInt(id, 10), nil
+		}
+		if start := binary.LittleEndian.Uint64(dat[i+16:]); start != offset {
+			return digest, "unexpected log offset for message " + strconv.FormatInt(id, 10), nil
+		}
+		offset += entrySize(dat[i:])
+	}
+
+	h := sha256.New()
+	_, _ = h.Write(dat)
+	log, err := osOpen(path + ".log")
+	if os.IsNotExist(err) {
+		return digest, "missing log file", nil
+	}
+	if err != nil {
+		return digest, "", err
+	}
+	n, err := io.Copy(h, log)
+	_ = log.Close()
+	if err != nil {
+		return digest, "", err
+	}
+	if uint64(n) != offset {
+		return digest, "log size " + strconv.FormatInt(n, 10) + " does not match its dat entries", nil
+	}
+
+	// ids are optional, but must not describe more messages than the dat
+	ids, err := ioutil.ReadFile(path + ".ids")
+	if err != nil && !os.IsNotExist(err) {
+		return digest, "", err
+	}
+	if len(ids)%idEntryLength != 0 || len(ids)/idEntryLength > len(dat)/datEntryLength {
+		return digest, "ids do not match its dat entries", nil
+	}
+	_, _ = h.Write(ids)
+
+	// content types are optional, but must be whole records of messages in the dat
+	types, err := ioutil.ReadFile(path + ".types")
+	if err != nil && !os.IsNotExist(err) {
+		return digest, "", err
+	}
+	var outside bool
+	err = parseTypeRecords(types, func(index, count int64, _ string) {
+		if index+count > int64(len(dat)/datEntryLength) {
+			outside = true
+		}
+	})
+	if err != nil || outside {
+		return digest, "types do not match its dat entries", nil
+	}
+	_, _ = h.Write(types)
+	copy(digest[:], h.Sum(nil))
+	return digest, "", nil
+}
+
+// copyFileSet replaces the file set at dst with the file set at src. The new files are written alongside
+// the old ones and renamed over them, the dat last
+func copyFileSet(src, dst string) error {
+	for _, suffix := range fileSetSuffixes {
+		if err := copyFile(src+suffix, dst+suffix); err != nil {
+			return err
+		}
+	}
+	return copyFile(src, dst)
+}
+
+// copyFile replaces the file at dst with the file at src, removing dst if src does not exist
+func copyFile(src, dst string) error {
+	b, err := ioutil.ReadFile(src)
+	if os.IsNotExist(err) {
+		if err = os.Remove(dst); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst + ".tmp")
+	if err = ioutil.WriteFile(dst+".tmp", b, 0666); err != nil {
+		return err
+	}
+	return os.Rename(dst+".tmp", dst)
+}
@@ -0,0 +1,116 @@
+package filequeue
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestFileQueue_Scrub(t *testing.T) {
+	dirs := []string{".haraqa-scrub-1", ".haraqa-scrub-2", ".haraqa-scrub-3"}
+	topic := "scrub-topic"
+	for _, dir := range dirs {
+		_ = os.RemoveAll(dir)
+		defer os.RemoveAll(dir)
+	}
+
+	q, err := New(true, 2, dirs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"aabb", "ccdd", "eeff"} {
+		if err = q.Produce(context.Background(), topic, []int64{2, 2}, uint64(time.Now().UnixNano()), bytes.NewBufferString(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// healthy copies
+	corruptions, err := q.Scrub(topic, false)
+	if err != nil || len(corruptions) != 0 {
+		t.Fatal(corruptions, err)
+	}
+	if _, err = q.Scrub("missing", false); err != headers.ErrTopicDoesNotExist {
+		t.Fatal(err)
+	}
+
+	// flip a byte in the last directory's copy, which differs from the other two
+	first := filepath.Join(dirs[2], topic, formatName(0))
+	if err = ioutil.WriteFile(first+".log", []byte("aaXb"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	// truncate the dat of the first directory's second file set
+	second := filepath.Join(dirs[0], topic, formatName(2))
+	if err = os.Truncate(second, datEntryLength+1); err != nil {
+		t.Fatal(err)
+	}
+	// the latest file set is not scrubbed
+	if err = ioutil.WriteFile(filepath.Join(dirs[1], topic, formatName(4)+".log"), []byte("XXXX"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	corruptions, err = q.Scrub(topic, false)
+	if err != nil || len(corruptions) != 2 {
+		t.Fatal(corruptions, err)
+	}
+	if c := corruptions[0]; c.File != formatName(0) || c.Dir != dirs[2] || c.Repaired {
+		t.Fatal(c)
+	}
+	if c := corruptions[1]; c.File != formatName(2) || c.Dir != dirs[0] || c.Reason != "truncated dat entry" || c.Repaired {
+		t.Fatal(c)
+	}
+
+	// repair the corrupt copies from the good ones
+	corruptions, err = q.Scrub(topic, true)
+	if err != nil || len(corruptions) != 2 || !corruptions[0].Repaired || !corruptions[1].Repaired {
+		t.Fatal(corruptions, err)
+	}
+	corruptions, err = q.Scrub(topic, false)
+	if err != nil || len(corruptions) != 0 {
+		t.Fatal(corruptions, err)
+	}
+	w := httptest.NewRecorder()
+	if _, err = q.Consume(context.Background(), topic, 0, -1, w); err != nil || w.Body.String() != "aabb" {
+		t.Fatal(w.Body.String(), err)
+	}
+}
+
+func TestFileQueue_ScrubUnrepairable(t *testing.T) {
+	dir := ".haraqa-scrub-single"
+	topic := "scrub-topic"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	q, err := New(true, 1, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"a", "b"} {
+		if err = q.Produce(context.Background(), topic, []int64{1}, uint64(time.Now().UnixNano()), bytes.NewBufferString(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Remove(filepath.Join(dir, topic, formatName(0)+".log")); err != nil {
+		t.Fatal(err)
+	}
+
+	// without a replica the corruption is only reported
+	corruptions, err := q.Scrub(topic, true)
+	if err != nil || len(corruptions) != 1 || corruptions[0].Reason != "missing log file" || corruptions[0].Repaired {
+		t.Fatal(corruptions, err)
+	}
+}
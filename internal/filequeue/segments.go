@@ -0,0 +1,215 @@
+package filequeue
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// maxSegments is the maximum number of file sets read by a single consume
+var maxSegments = 4
+
+// segmentPrefetch is the number of bytes of the messages of each file set after the first read into the
+// page cache while earlier file sets are written, so that consumes hold no more than a copy buffer
+var segmentPrefetch int64 = 1 << 20
+
+// segment is the part of a file set read by a consume, the entries of each segment are read in parallel
+// and the first messages of each segment after the first are prefetched
+type segment struct {
+	dat, log *os.File
+	index    int64
+	want     int64
+	limit    int64
+	data     []byte
+	ids      []headers.MessageID
+	types    []string
+	err      error
+	entries  chan struct{}
+	done     chan struct{}
+}
+
+// openSegments opens the file sets following the file set starting at next, until they hold limit messages
+// or maxSegments - 1 file sets are open. The topic is read locked so that a truncation cannot remove them
+func (q *FileQueue) openSegments(topic string, next, limit int64) []*segment {
+	mux := q.topicLock(topic)
+	mux.RLock()
+	defer mux.RUnlock()
+
+	var segments []*segment
+	for limit > 0 && len(segments) < maxSegments-1 {
+		path := filepath.Join(q.rootDirNames[len(q.rootDirNames)-1], topic, formatName(next))
+		dat, err := q.openFile(path, os.O_RDONLY, 0)
+		if err != nil {
+			break
+		}
+		log, err := q.openFile(path+".log", os.O_RDONLY, 0)
+		if err != nil {
+			_ = dat.Close()
+			q.releaseFiles(1)
+			break
+		}
+		seg := &segment{dat: dat, log: log}
+		stat, err := dat.Stat()
+		if err != nil || stat.Size() < datEntryLength {
+			seg.close(q)
+			break
+		}
+		entries := stat.Size() / datEntryLength
+		seg.want = entries
+		if seg.want > limit {
+			seg.want = limit
+		}
+		segments = append(segments, seg)
+		limit -= seg.want
+		next += entries
+	}
+	return segments
+}
+
+// close closes the files of a segment after the consume of its first file set
+func (seg *segment) close(q *FileQueue) {
+	_ = seg.dat.Close()
+	_ = seg.log.Close()
+	q.releaseFiles(2)
+}
+
+// read reads the entries, ids and content types of the segment, then prefetches its first messages if
+// prefetch is set
+func (seg *segment) read(prefetch bool) {
+	defer close(seg.done)
+	seg.data = make([]byte, seg.want*datEntryLength)
+	length, err := seg.dat.ReadAt(seg.data, seg.index*datEntryLength)
+	if err != nil && length == 0 {
+		seg.err = err
+		close(seg.entries)
+		return
+	}
+	seg.limit = int64(length) / datEntryLength
+	seg.data = seg.data[:seg.limit*datEntryLength]
+	if seg.err = checkEntries(seg.data); seg.err == nil {
+		seg.ids, seg.err = readIDs(seg.dat.Name()+".ids", seg.index, seg.limit)
+	}
+	if seg.err == nil {
+		seg.types, seg.err = readTypes(seg.dat.Name()+".types", seg.data, seg.index, seg.limit)
+	}
+	close(seg.entries)
+	if seg.err != nil || !prefetch {
+		return
+	}
+
+	// errors are returned when the messages are written
+	start, size := seg.bodyRange()
+	if size > segmentPrefetch {
+		size = segmentPrefetch
+	}
+	_, _ = io.Copy(ioutil.Discard, io.NewSectionReader(seg.log, start, size))
+}
+
+// writeTo copies the messages of the segment from its log to w
+func (seg *segment) writeTo(w io.Writer) error {
+	start, size := seg.bodyRange()
+	n, err := io.Copy(w, io.NewSectionReader(seg.log, start, size))
+	if err == nil && n < size {
+		err = errors.Wrap(io.ErrUnexpectedEOF, "unable to read messages")
+	}
+	return err
+}
+
+// bodyRange returns the offset and length of the segment's messages in its log
+func (seg *segment) bodyRange() (int64, int64) {
+	start := int64(binary.LittleEndian.Uint64(seg.data[16:]))
+	var size int64
+	for i := int64(0); i < seg.limit; i++ {
+		size += int64(entrySize(seg.data[i*datEntryLength:]))
+	}
+	return start, size
+}
+
+// consumeSegments writes the messages of the first file set from index, followed by the messages of the
+// following file sets. The entries of every file set are read in parallel and the messages of each are
+// streamed from its log, prefetched while earlier file sets are written to the response
+func (q *FileQueue) consumeSegments(w http.ResponseWriter, dat, log *os.File, index, limit int64, following []*segment) (int, error) {
+	segments := append([]*segment{{dat: dat, log: log, index: index, want: limit}}, following...)
+	for i, seg := range segments {
+		seg.entries, seg.done = make(chan struct{}), make(chan struct{})
+		go seg.read(i > 0)
+	}
+	defer func() {
+		for _, seg := range following {
+			<-seg.done
+			seg.close(q)
+		}
+	}()
+
+	// stop at the first file set which could not be read, or was not read to its end by a truncation
+	for i, seg := range segments {
+		<-seg.entries
+		if seg.err != nil || seg.limit == 0 {
+			if i == 0 {
+				return 0, seg.err
+			}
+			segments = segments[:i]
+			break
+		}
+		if seg.limit < seg.want {
+			segments = segments[:i+1]
+			break
+		}
+	}
+
+	var sizes []int64
+	var timestamps []time.Time
+	var ids []headers.MessageID
+	var types []string
+	var total int64
+	for _, seg := range segments {
+		if seg.ids != nil && ids == nil {
+			ids = make([]headers.MessageID, len(sizes), len(sizes)+int(seg.limit))
+		}
+		if seg.types != nil && types == nil {
+			types = make([]string, len(sizes), len(sizes)+int(seg.limit))
+		}
+		for j := int64(0); j < seg.limit; j++ {
+			size := int64(entrySize(seg.data[j*datEntryLength:]))
+			sizes = append(sizes, size)
+			timestamps = append(timestamps, entryTime(binary.LittleEndian.Uint64(seg.data[j*datEntryLength+8:])))
+			total += size
+			if ids != nil {
+				var id headers.MessageID
+				if seg.ids != nil {
+					id = seg.ids[j]
+				}
+				ids = append(ids, id)
+			}
+			if types != nil {
+				var contentType string
+				if seg.types != nil {
+					contentType = seg.types[j]
+				}
+				types = append(types, contentType)
+			}
+		}
+	}
+
+	wHeader := w.Header()
+	single := setConsumeHeaders(wHeader, log.Name(), sizes, timestamps, ids, types, 1)
+	single[0] = strconv.FormatInt(total, 10)
+	wHeader["Content-Length"] = single[0:1:1]
+	w.WriteHeader(http.StatusOK)
+
+	for _, seg := range segments {
+		<-seg.done
+		if err := seg.writeTo(w); err != nil {
+			return len(sizes), err
+		}
+	}
+	return len(sizes), nil
+}
@@ -0,0 +1,148 @@
+package filequeue
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"hash"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestFileQueue_ConsumeSegments(t *testing.T) {
+	dir := ".haraqa-segments"
+	topic := "segments"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	q, err := New(true, 2, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+
+	// each file set holds two messages, the second file set's messages have ids
+	inputs := []string{"a", "bb", "ccc", "dddd", "eeeee", "ffffff", "g"}
+	var id headers.MessageID
+	for i, input := range inputs {
+		var ids []headers.MessageID
+		if i == 2 || i == 3 {
+			if id, err = headers.NewMessageID(); err != nil {
+				t.Fatal(err)
+			}
+			ids = []headers.MessageID{id}
+		}
+		if err = q.ProduceWithIDs(context.Background(), topic, []int64{int64(len(input))}, ids, uint64(time.Now().UnixNano()), bytes.NewBufferString(input)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	open := atomic.LoadInt64(&q.stats.openFiles)
+
+	// reads from the middle of the first file set into the following file sets
+	w := httptest.NewRecorder()
+	n, err := q.Consume(context.Background(), topic, 1, 5, w)
+	if err != nil || n != 5 || w.Body.String() != "bbcccddddeeeeeffffff" {
+		t.Fatal(n, err, w.Body.String())
+	}
+	sizes, err := headers.ReadSizes(w.Header())
+	if err != nil || !reflect.DeepEqual(sizes, []int64{2, 3, 4, 5, 6}) {
+		t.Fatal(sizes, err)
+	}
+	timestamps, err := headers.ReadTimestamps(w.Header())
+	if err != nil || len(timestamps) != 5 {
+		t.Fatal(timestamps, err)
+	}
+	ids, err := headers.ReadMessageIDs(w.Header())
+	if err != nil || len(ids) != 5 || !ids[0].IsZero() || ids[1].IsZero() || ids[2] != id || !ids[3].IsZero() {
+		t.Fatal(ids, err)
+	}
+
+	// the number of file sets read at once is limited
+	defer func(max int) { maxSegments = max }(maxSegments)
+	maxSegments = 2
+	w = httptest.NewRecorder()
+	n, err = q.Consume(context.Background(), topic, 0, 100, w)
+	if err != nil || n != 4 || w.Body.String() != "abbcccdddd" {
+		t.Fatal(n, err, w.Body.String())
+	}
+
+	// a limit within the file set reads only the file set
+	w = httptest.NewRecorder()
+	n, err = q.Consume(context.Background(), topic, 6, 100, w)
+	if err != nil || n != 1 || w.Body.String() != "g" {
+		t.Fatal(n, err, w.Body.String())
+	}
+
+	// the files of every file set are closed after the consume
+	if after := atomic.LoadInt64(&q.stats.openFiles); after != open {
+		t.Fatal(after, open)
+	}
+}
+
+// hashWriter is a response writer keeping only a hash of the body
+type hashWriter struct {
+	header http.Header
+	hash   hash.Hash
+	n      int64
+}
+
+func (w *hashWriter) Header() http.Header { return w.header }
+func (w *hashWriter) WriteHeader(int)     {}
+func (w *hashWriter) Write(b []byte) (int, error) {
+	w.n += int64(len(b))
+	return w.hash.Write(b)
+}
+
+func TestFileQueue_ConsumeSegmentsLarge(t *testing.T) {
+	dir := ".haraqa-segments-large"
+	topic := "large"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	q, err := New(true, 2, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+	defer func(size int64) { segmentPrefetch = size }(segmentPrefetch)
+	segmentPrefetch = 64 << 10
+
+	// four file sets of two 1MB messages each
+	expected := sha256.New()
+	msg := make([]byte, 1<<20)
+	for i := 0; i < 8; i++ {
+		for j := range msg {
+			msg[j] = byte(i + j)
+		}
+		_, _ = expected.Write(msg)
+		if err = q.Produce(context.Background(), topic, []int64{int64(len(msg))}, uint64(time.Now().UnixNano()), bytes.NewBuffer(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the following file sets are streamed rather than buffered
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	w := &hashWriter{header: http.Header{}, hash: sha256.New()}
+	n, err := q.Consume(context.Background(), topic, 0, 8, w)
+	runtime.ReadMemStats(&after)
+	if err != nil || n != 8 || w.n != 8<<20 || !bytes.Equal(w.hash.Sum(nil), expected.Sum(nil)) {
+		t.Fatal(n, err, w.n)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Fatal(allocated)
+	}
+}
@@ -0,0 +1,50 @@
+package filequeue
+
+import (
+	"sync/atomic"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+// cacheStats holds the cumulative cache counters of the queue, all fields are updated atomically
+type cacheStats struct {
+	produceHits      int64
+	produceMisses    int64
+	produceEvictions int64
+	consumeHits      int64
+	consumeMisses    int64
+	consumeEvictions int64
+	openFiles        int64
+	fileRejections   int64
+	readAheads       int64
+	syncs            int64
+}
+
+// CacheStats returns the cumulative hit, miss and eviction counts of the produce and consume caches,
+// along with the number of queue files currently open, the number of opens rejected by the file limit and
+// the number of prefetches for sequential consumers and group commit syncs
+func (q *FileQueue) CacheStats() headers.CacheStats {
+	return headers.CacheStats{
+		ProduceHits:      atomic.LoadInt64(&q.stats.produceHits),
+		ProduceMisses:    atomic.LoadInt64(&q.stats.produceMisses),
+		ProduceEvictions: atomic.LoadInt64(&q.stats.produceEvictions),
+		ConsumeHits:      atomic.LoadInt64(&q.stats.consumeHits),
+		ConsumeMisses:    atomic.LoadInt64(&q.stats.consumeMisses),
+		ConsumeEvictions: atomic.LoadInt64(&q.stats.consumeEvictions),
+		OpenFiles:        atomic.LoadInt64(&q.stats.openFiles),
+		FileRejections:   atomic.LoadInt64(&q.stats.fileRejections),
+		ReadAheads:       atomic.LoadInt64(&q.stats.readAheads),
+		Syncs:            atomic.LoadInt64(&q.stats.syncs),
+	}
+}
+
+// evictConsumeName removes the topic from the consume name cache
+func (q *FileQueue) evictConsumeName(topic string) {
+	if q.consumeNameCache == nil {
+		return
+	}
+	if _, ok := q.consumeNameCache.Load(topic); ok {
+		q.consumeNameCache.Delete(topic)
+		atomic.AddInt64(&q.stats.consumeEvictions, 1)
+	}
+}
@@ -0,0 +1,83 @@
+package filequeue
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestFileQueue_CacheStats(t *testing.T) {
+	dir := ".haraqa-stats"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	q, err := New(true, 2, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if err = q.CreateTopic("stats"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = q.Produce(context.Background(), "stats", []int64{1}, 0, bytes.NewBufferString("a")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = q.Consume(context.Background(), "stats", 0, 1, httptest.NewRecorder()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := q.CacheStats()
+	expected := headers.CacheStats{
+		ProduceHits:      1,
+		ProduceMisses:    1,
+		ProduceEvictions: 1,
+		ConsumeHits:      1,
+		ConsumeMisses:    1,
+		ConsumeEvictions: 0,
+		OpenFiles:        2,
+	}
+	if stats != expected {
+		t.Fatalf("%+v", stats)
+	}
+
+	// deleting the topic evicts both caches and closes the open files
+	if err = q.DeleteTopic("stats"); err != nil {
+		t.Fatal(err)
+	}
+	stats = q.CacheStats()
+	if stats.ProduceEvictions != 2 || stats.ConsumeEvictions != 1 || stats.OpenFiles != 0 {
+		t.Fatalf("%+v", stats)
+	}
+}
+
+func TestFileQueue_NoCacheClosesFiles(t *testing.T) {
+	dir := ".haraqa-nocache"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	q, err := New(false, 2, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if err = q.CreateTopic("nocache"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = q.Produce(context.Background(), "nocache", []int64{1}, 0, bytes.NewBufferString("a")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := q.CacheStats()
+	if stats.OpenFiles != 0 || stats.ProduceMisses != 3 || stats.ProduceHits != 0 {
+		t.Fatalf("%+v", stats)
+	}
+}
@@ -0,0 +1,126 @@
+package filequeue
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// topicIndex is a sorted index of the names of the queue's topics. It is loaded from the queue directory
+// the first time topics are listed, rather than when the queue is opened, and is then kept up to date as
+// topics are created, deleted and restored so that listing topics does not walk every file in the queue
+type topicIndex struct {
+	mux    sync.RWMutex
+	loaded bool
+	names  []string
+}
+
+// list returns the topics starting with the prefix which match, loading the index from root if needed
+func (t *topicIndex) list(ctx context.Context, root, prefix string, match func(string) bool) ([]string, error) {
+	if err := t.load(ctx, root); err != nil {
+		return nil, err
+	}
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	var names []string
+	for i := sort.SearchStrings(t.names, prefix); i < len(t.names) && strings.HasPrefix(t.names[i], prefix); i++ {
+		if match(t.names[i]) {
+			names = append(names, t.names[i])
+		}
+	}
+	return names, nil
+}
+
+// load reads the topic directories under root into the index if it has not been loaded
+func (t *topicIndex) load(ctx context.Context, root string) error {
+	t.mux.RLock()
+	loaded := t.loaded
+	t.mux.RUnlock()
+	if loaded {
+		return nil
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.loaded {
+		return nil
+	}
+	var names []string
+	if err := scanTopics(ctx, root, "", &names); err != nil {
+		return err
+	}
+	sort.Strings(names)
+	t.names, t.loaded = names, true
+	return nil
+}
+
+// scanTopics appends the names of the topic directories nested in dir to names, skipping the trash
+func scanTopics(ctx context.Context, root, dir string, names *[]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f, err := osOpen(filepath.Join(root, dir))
+	if err != nil {
+		return err
+	}
+	infos, err := f.Readdir(-1)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if !info.IsDir() || (dir == "" && info.Name() == trashDirName) {
+			continue
+		}
+		name := filepath.Join(dir, info.Name())
+		*names = append(*names, filepath.ToSlash(name))
+		if err = scanTopics(ctx, root, name, names); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add adds the topic and the topics it is nested in to a loaded index
+func (t *topicIndex) add(topic string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if !t.loaded {
+		return
+	}
+	for i := 0; i <= len(topic); i++ {
+		if i < len(topic) && topic[i] != '/' {
+			continue
+		}
+		name := topic[:i]
+		j := sort.SearchStrings(t.names, name)
+		if j < len(t.names) && t.names[j] == name {
+			continue
+		}
+		t.names = append(t.names, "")
+		copy(t.names[j+1:], t.names[j:])
+		t.names[j] = name
+	}
+}
+
+// remove removes the topic and any topic nested in it from the index
+func (t *topicIndex) remove(topic string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	names := t.names[:0]
+	for _, name := range t.names {
+		if name != topic && !strings.HasPrefix(name, topic+"/") {
+			names = append(names, name)
+		}
+	}
+	t.names = names
+}
+
+// reset clears the index so that it is loaded again the next time topics are listed
+func (t *topicIndex) reset() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.names, t.loaded = nil, false
+}
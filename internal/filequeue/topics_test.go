@@ -0,0 +1,62 @@
+package filequeue
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileQueue_TopicIndex(t *testing.T) {
+	dir := ".haraqa-topic-index"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	q, err := New(true, 5000, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	list := func(prefix string, want ...string) {
+		t.Helper()
+		names, err := q.ListTopics(context.Background(), prefix, "", "")
+		if err != nil || !reflect.DeepEqual(names, want) {
+			t.Fatal(names, err)
+		}
+	}
+
+	// topics created before the first list are found when the index is loaded
+	if err = q.CreateTopic("orders/eu"); err != nil {
+		t.Fatal(err)
+	}
+	if q.topics.loaded {
+		t.Fatal("index loaded before topics were listed")
+	}
+	list("", "orders", "orders/eu")
+
+	// topics created and deleted afterwards update the loaded index
+	for _, topic := range []string{"orders/us/east", "payments"} {
+		if err = q.CreateTopic(topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list("", "orders", "orders/eu", "orders/us", "orders/us/east", "payments")
+	list("orders/u", "orders/us", "orders/us/east")
+	if err = q.DeleteTopic("orders/us"); err != nil {
+		t.Fatal(err)
+	}
+	list("", "orders", "orders/eu", "payments")
+
+	// directories created outside the queue are not listed until the index is loaded again
+	if err = os.MkdirAll(filepath.Join(dir, "external"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	list("", "orders", "orders/eu", "payments")
+
+	// restoring a topic reloads the index with its nested topics
+	if err = q.RestoreTopic("orders/us"); err != nil {
+		t.Fatal(err)
+	}
+	list("", "external", "orders", "orders/eu", "orders/us", "orders/us/east", "payments")
+}
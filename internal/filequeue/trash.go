@@ -0,0 +1,141 @@
+package filequeue
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// trashDirName is the directory within each queue directory holding deleted topics until they are purged
+const trashDirName = ".trash"
+
+// isTrash returns true if the topic is the trash directory or within it
+func isTrash(topic string) bool {
+	topic = filepath.ToSlash(filepath.Clean(topic))
+	return topic == trashDirName || strings.HasPrefix(topic, trashDirName+"/")
+}
+
+// trashName returns the name of the topic's directory in the trash, the escaped topic name followed by
+// the time it was deleted
+func trashName(topic string, deleted time.Time) string {
+	return url.PathEscape(topic) + "." + strconv.FormatInt(deleted.UnixNano(), 10)
+}
+
+// parseTrashName returns the topic and deletion time of a directory in the trash
+func parseTrashName(name string) (string, time.Time, bool) {
+	i := strings.LastIndexByte(name, '.')
+	if i <= 0 {
+		return "", time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(name[i+1:], 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	topic, err := url.PathUnescape(name[:i])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return topic, time.Unix(0, nanos), true
+}
+
+// trashNames returns the names of the directories in the trash of the last queue directory
+func (q *FileQueue) trashNames() ([]string, error) {
+	dir, err := osOpen(filepath.Join(q.rootDirNames[len(q.rootDirNames)-1], trashDirName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "unable to open trash")
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read trash")
+	}
+	return names, nil
+}
+
+// RestoreTopic moves the most recently deleted copy of the topic out of the trash. It returns
+// ErrTopicDoesNotExist if the topic is not in the trash and ErrTopicAlreadyExists if it has been recreated
+func (q *FileQueue) RestoreTopic(topic string) error {
+	if isTrash(topic) {
+		return headers.ErrInvalidTopic
+	}
+	produceLock := q.produceLock(topic)
+	produceLock.Lock()
+	defer produceLock.Unlock()
+	topicLock := q.topicLock(topic)
+	topicLock.Lock()
+	defer topicLock.Unlock()
+
+	for _, dir := range q.rootDirNames {
+		if _, err := os.Stat(filepath.Join(dir, topic)); err == nil {
+			return headers.ErrTopicAlreadyExists
+		}
+	}
+	names, err := q.trashNames()
+	if err != nil {
+		return err
+	}
+	var latest string
+	var latestTime time.Time
+	for _, name := range names {
+		t, deleted, ok := parseTrashName(name)
+		if ok && t == topic && deleted.After(latestTime) {
+			latest, latestTime = name, deleted
+		}
+	}
+	if latest == "" {
+		return headers.ErrTopicDoesNotExist
+	}
+
+	for _, dir := range q.rootDirNames {
+		path := filepath.Join(dir, topic)
+		if err = osMkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return errors.Wrapf(err, "unable to restore topic %q", topic)
+		}
+		err = os.Rename(filepath.Join(dir, trashDirName, latest), path)
+		if os.IsNotExist(err) {
+			// the topic was never written to this directory, recreate it empty
+			err = osMkdir(path, os.ModePerm)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "unable to restore topic %q", topic)
+		}
+	}
+	// the restored topic may contain nested topics, which are found when the index is loaded again
+	q.topics.reset()
+	q.evictConsumeName(topic)
+	return nil
+}
+
+// PurgeTopics permanently removes the topics deleted before the given time from the trash, returning
+// the names of the purged topics
+func (q *FileQueue) PurgeTopics(before time.Time) ([]string, error) {
+	names, err := q.trashNames()
+	if err != nil {
+		return nil, err
+	}
+	var purged []string
+	for _, name := range names {
+		topic, deleted, ok := parseTrashName(name)
+		if ok && !deleted.Before(before) {
+			continue
+		}
+		for _, dir := range q.rootDirNames {
+			if err = os.RemoveAll(filepath.Join(dir, trashDirName, name)); err != nil {
+				return purged, errors.Wrapf(err, "unable to purge topic %q", topic)
+			}
+		}
+		if ok {
+			purged = append(purged, topic)
+		}
+	}
+	return purged, nil
+}
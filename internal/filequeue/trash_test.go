@@ -0,0 +1,165 @@
+package filequeue
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestFileQueue_Trash(t *testing.T) {
+	dir1, dir2 := ".haraqa-trash1", ".haraqa-trash2"
+	defer os.RemoveAll(dir1)
+	defer os.RemoveAll(dir2)
+
+	q, err := New(true, 5000, dir1, dir2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	for _, topic := range []string{"orders", "orders/eu"} {
+		if err = q.CreateTopic(topic); err != nil {
+			t.Fatal(err)
+		}
+		if err = q.Produce(context.Background(), topic, []int64{5}, uint64(time.Now().Unix()), bytes.NewBufferString("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, topic := range []string{".trash", ".trash/orders"} {
+		if err = q.CreateTopic(topic); err != headers.ErrInvalidTopic {
+			t.Error(topic, err)
+		}
+		if err = q.DeleteTopic(topic); err != headers.ErrInvalidTopic {
+			t.Error(topic, err)
+		}
+		if err = q.RestoreTopic(topic); err != headers.ErrInvalidTopic {
+			t.Error(topic, err)
+		}
+	}
+
+	// deleted topics are hidden but kept in the trash
+	if err = q.DeleteTopic("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.DeleteTopic("missing"); err != nil {
+		t.Fatal(err)
+	}
+	topics, err := q.ListTopics(context.Background(), "", "", "")
+	if err != nil || len(topics) != 0 {
+		t.Fatal(topics, err)
+	}
+	usage, err := q.DiskUsage()
+	if err != nil || len(usage.Topics) != 0 {
+		t.Fatal(usage, err)
+	}
+	if err = q.Produce(context.Background(), "orders", []int64{5}, uint64(time.Now().Unix()), bytes.NewBufferString("hello")); err == nil {
+		t.Fatal("expected produce to a deleted topic to fail")
+	}
+	for _, dir := range []string{dir1, dir2} {
+		if names, err := filepath.Glob(filepath.Join(dir, trashDirName, "orders.*")); err != nil || len(names) != 1 {
+			t.Fatal(names, err)
+		}
+	}
+
+	// deleted topics cannot be read, written or modified by their path in the trash
+	names, err := filepath.Glob(filepath.Join(dir1, trashDirName, "orders.*"))
+	if err != nil || len(names) != 1 {
+		t.Fatal(names, err)
+	}
+	trashed := trashDirName + "/" + filepath.Base(names[0])
+	if _, err = q.Consume(context.Background(), trashed, 0, -1, httptest.NewRecorder()); err != headers.ErrInvalidTopic {
+		t.Fatal(err)
+	}
+	if _, err = q.InspectTopic(trashed); err != headers.ErrInvalidTopic {
+		t.Fatal(err)
+	}
+	if err = q.Produce(context.Background(), trashed, []int64{5}, uint64(time.Now().Unix()), bytes.NewBufferString("hello")); err != headers.ErrInvalidTopic {
+		t.Fatal(err)
+	}
+	if _, err = q.ModifyTopic(trashed, headers.ModifyRequest{Truncate: 1}); err != headers.ErrInvalidTopic {
+		t.Fatal(err)
+	}
+	if _, err = q.CountMessages(trashed, 0, 1); err != headers.ErrInvalidTopic {
+		t.Fatal(err)
+	}
+	if _, err = q.Scrub(trashed, false); err != headers.ErrInvalidTopic {
+		t.Fatal(err)
+	}
+
+	// restoring brings back nested topics
+	if err = q.RestoreTopic("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.RestoreTopic("missing"); err != headers.ErrTopicDoesNotExist {
+		t.Fatal(err)
+	}
+	topics, err = q.ListTopics(context.Background(), "", "", "")
+	if err != nil || !reflect.DeepEqual(topics, []string{"orders", "orders/eu"}) {
+		t.Fatal(topics, err)
+	}
+	w := httptest.NewRecorder()
+	if n, err := q.Consume(context.Background(), "orders/eu", 0, -1, w); err != nil || n != 1 || w.Body.String() != "hello" {
+		t.Fatal(n, err, w.Body.String())
+	}
+
+	// a recreated topic blocks restoring, the most recent deletion is restored
+	if err = q.DeleteTopic("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.CreateTopic("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.RestoreTopic("orders"); err != headers.ErrTopicAlreadyExists {
+		t.Fatal(err)
+	}
+	if err = q.DeleteTopic("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.RestoreTopic("orders"); err != nil {
+		t.Fatal(err)
+	}
+	topics, err = q.ListTopics(context.Background(), "", "", "")
+	if err != nil || !reflect.DeepEqual(topics, []string{"orders"}) {
+		t.Fatal(topics, err)
+	}
+
+	// only topics deleted before the given time are purged
+	purged, err := q.PurgeTopics(time.Now().Add(-time.Hour))
+	if err != nil || len(purged) != 0 {
+		t.Fatal(purged, err)
+	}
+	purged, err = q.PurgeTopics(time.Now())
+	if err != nil || !reflect.DeepEqual(purged, []string{"orders"}) {
+		t.Fatal(purged, err)
+	}
+	for _, dir := range []string{dir1, dir2} {
+		if names, err := filepath.Glob(filepath.Join(dir, trashDirName, "*")); err != nil || len(names) != 0 {
+			t.Fatal(names, err)
+		}
+	}
+	if err = q.DeleteTopic("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.RestoreTopic("orders/eu"); err != headers.ErrTopicDoesNotExist {
+		t.Fatal(err)
+	}
+}
+
+func TestParseTrashName(t *testing.T) {
+	deleted := time.Unix(0, 1600000000123456789)
+	topic, parsed, ok := parseTrashName(trashName("a/b.c", deleted))
+	if !ok || topic != "a/b.c" || !parsed.Equal(deleted) {
+		t.Fatal(topic, parsed, ok)
+	}
+	for _, name := range []string{"", ".123", "topic", "topic.abc", "%zz.123"} {
+		if _, _, ok = parseTrashName(name); ok {
+			t.Error(name)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package filequeue
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// The types file of a file set records the content types of its messages as runs. Each record holds the
+// index in the file set of the first message of the run, the number of messages in the run and the
+// content type, so messages produced without a content type need no record. Records are appended as
+// messages are produced, a later record of a message replaces an earlier one
+const typeRecordHeader = 10
+
+// appendTypeRecords appends the records of the runs of content types of messages produced from index
+func appendTypeRecords(b []byte, index int64, types []string) []byte {
+	for i := 0; i < len(types); {
+		j := i + 1
+		for j < len(types) && types[j] == types[i] {
+			j++
+		}
+		if types[i] != "" {
+			var header [typeRecordHeader]byte
+			binary.LittleEndian.PutUint32(header[0:], uint32(index+int64(i)))
+			binary.LittleEndian.PutUint32(header[4:], uint32(j-i))
+			binary.LittleEndian.PutUint16(header[8:], uint16(len(types[i])))
+			b = append(append(b, header[:]...), types[i]...)
+		}
+		i = j
+	}
+	return b
+}
+
+// parseTypeRecords calls fn with each record of a types file
+func parseTypeRecords(b []byte, fn func(index, count int64, contentType string)) error {
+	for len(b) > 0 {
+		if len(b) < typeRecordHeader {
+			return errors.New("truncated content type record")
+		}
+		n := typeRecordHeader + int(binary.LittleEndian.Uint16(b[8:]))
+		if len(b) < n {
+			return errors.New("truncated content type record")
+		}
+		fn(int64(binary.LittleEndian.Uint32(b[0:])), int64(binary.LittleEndian.Uint32(b[4:])), string(b[typeRecordHeader:n]))
+		b = b[n:]
+	}
+	return nil
+}
+
+// readTypes reads the content types of limit messages starting from the entry at index, nil is returned
+// if none of the dat entries have a content type
+func readTypes(path string, data []byte, index, limit int64) ([]string, error) {
+	var typed bool
+	for i := 0; i+datEntryLength <= len(data); i += datEntryLength {
+		if _, flags := entryFormat(data[i:]); flags&entryFlagType != 0 {
+			typed = true
+			break
+		}
+	}
+	if !typed {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	types := make([]string, limit)
+	err = parseTypeRecords(b, func(start, count int64, contentType string) {
+		for i := start; i < start+count; i++ {
+			if i >= index && i < index+limit {
+				types[i-index] = contentType
+			}
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read %s", path)
+	}
+	return types, nil
+}
+
+// openProduceTypes opens the types files of the producer's file set, appending to any records already
+// written to them
+func (q *FileQueue) openProduceTypes(topic string, pf *ProduceFile) error {
+	for _, dir := range q.rootDirNames {
+		path := filepath.Join(dir, topic, pf.Name+".types")
+		f, err := q.openFile(path, os.O_RDWR|os.O_CREATE, 0666)
+		if err != nil {
+			return errors.Wrapf(err, "unable to open/create file %q", path)
+		}
+		pf.Types = append(pf.Types, f)
+	}
+	stat, err := pf.Types[len(pf.Types)-1].(*os.File).Stat()
+	if err != nil {
+		return errors.Wrap(err, "unable to stat types file")
+	}
+	pf.CurrentTypesOffset = stat.Size()
+	return nil
+}
@@ -0,0 +1,141 @@
+package filequeue
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestTypeRecords(t *testing.T) {
+	b := appendTypeRecords(nil, 4, []string{"text/plain", "text/plain", "", "application/json"})
+	type record struct {
+		index, count int64
+		contentType  string
+	}
+	var records []record
+	err := parseTypeRecords(b, func(index, count int64, contentType string) {
+		records = append(records, record{index, count, contentType})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, []record{{4, 2, "text/plain"}, {7, 1, "application/json"}}) {
+		t.Fatal(records)
+	}
+	if err = parseTypeRecords(b[:len(b)-1], func(int64, int64, string) {}); err == nil {
+		t.Fatal("expected truncated record error")
+	}
+	if err = parseTypeRecords(b[:5], func(int64, int64, string) {}); err == nil {
+		t.Fatal("expected truncated record error")
+	}
+}
+
+func TestFileQueue_ProduceWithTypes(t *testing.T) {
+	topic := "types"
+	dirs := []string{".haraqa-types1", ".haraqa-types2"}
+	for _, dir := range dirs {
+		_ = os.RemoveAll(dir)
+		defer os.RemoveAll(dir)
+	}
+	q, err := New(true, 3, dirs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.CreateTopic(topic); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = q.ProduceWithTypes(context.Background(), topic, []int64{1, 1}, nil, []string{"text/plain"}, 0, bytes.NewBufferString("ab")); err == nil {
+		t.Fatal("expected content type count error")
+	}
+
+	// the first message has no content type, later messages span two file sets
+	if err = q.Produce(context.Background(), topic, []int64{1}, 0, bytes.NewBufferString("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.ProduceWithTypes(context.Background(), topic, []int64{1, 1}, nil, []string{"text/plain", ""}, 0, bytes.NewBufferString("bc")); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.ProduceWithTypes(context.Background(), topic, []int64{1, 1}, nil, []string{"application/json", "application/json"}, 0, bytes.NewBufferString("de")); err != nil {
+		t.Fatal(err)
+	}
+
+	consumeTypes := func(id, limit int64) []string {
+		w := httptest.NewRecorder()
+		if _, err := q.Consume(context.Background(), topic, id, limit, w); err != nil {
+			t.Fatal(err)
+		}
+		types, err := headers.ReadContentTypes(w.Header())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return types
+	}
+	if got := consumeTypes(0, 3); !reflect.DeepEqual(got, []string{"", "text/plain", ""}) {
+		t.Fatal(got)
+	}
+	if got := consumeTypes(0, 1); got != nil {
+		t.Fatal(got)
+	}
+	// consumes spanning file sets merge the content types
+	if got := consumeTypes(1, 4); !reflect.DeepEqual(got, []string{"text/plain", "", "application/json", "application/json"}) {
+		t.Fatal(got)
+	}
+
+	// reopened file sets append to the records already written
+	if err = q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if q, err = New(true, 3, dirs...); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.ProduceWithTypes(context.Background(), topic, []int64{1}, nil, []string{"text/csv"}, 0, bytes.NewBufferString("f")); err != nil {
+		t.Fatal(err)
+	}
+	if got := consumeTypes(3, -1); !reflect.DeepEqual(got, []string{"application/json", "application/json", "text/csv"}) {
+		t.Fatal(got)
+	}
+
+	// batches without any content type do not create a types file
+	if err = q.ProduceWithTypes(context.Background(), topic, []int64{1, 1}, nil, []string{"", ""}, 0, bytes.NewBufferString("gh")); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		if _, err = os.Stat(filepath.Join(dir, topic, formatName(3)+".types")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = os.Stat(filepath.Join(dir, topic, formatName(6)+".types")); !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+	}
+	if got := consumeTypes(6, -1); got != nil {
+		t.Fatal(got)
+	}
+	corruptions, err := q.Scrub(topic, false)
+	if err != nil || len(corruptions) != 0 {
+		t.Fatal(corruptions, err)
+	}
+
+	// records of messages missing from the dat are reported by a scrub
+	f, err := os.OpenFile(filepath.Join(dirs[1], topic, formatName(3)+".types"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write(appendTypeRecords(nil, 3, []string{"text/plain"}))
+	_ = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	corruptions, err = q.Scrub(topic, false)
+	if err != nil || len(corruptions) != 1 {
+		t.Fatal(corruptions, err)
+	}
+}
@@ -1,8 +1,13 @@
 package headers
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -10,92 +15,295 @@ import (
 
 // Headers using Canonical MIME structure
 const (
-	HeaderErrors    = "X-Errors"
-	HeaderSizes     = "X-Sizes"
-	HeaderStartTime = "X-Start-Time"
-	HeaderEndTime   = "X-End-Time"
-	HeaderFileName  = "X-File-Name"
-	ContentType     = "Content-Type"
+	HeaderErrors            = "X-Errors"
+	HeaderErrorCode         = "X-Error-Code"
+	HeaderSizes             = "X-Sizes"
+	HeaderTimestamps        = "X-Timestamps"
+	HeaderMessageIDs        = "X-Message-Ids"
+	HeaderProducerID        = "X-Producer-Id"
+	HeaderSequence          = "X-Producer-Seq"
+	HeaderDuplicate         = "X-Duplicate"
+	HeaderCreate            = "X-Create-Topic"
+	HeaderTopics            = "X-Topics"
+	HeaderOffsets           = "X-Offsets"
+	HeaderStartTime         = "X-Start-Time"
+	HeaderEndTime           = "X-End-Time"
+	HeaderFileName          = "X-File-Name"
+	HeaderGroup             = "X-Consumer-Group"
+	HeaderMinOffset         = "X-Min-Offset"
+	HeaderMaxOffset         = "X-Max-Offset"
+	HeaderCount             = "X-Message-Count"
+	HeaderTopicSize         = "X-Topic-Size"
+	HeaderEncryptionSubject = "X-Encryption-Subject"
+	HeaderContentTypes      = "X-Content-Types"
+	HeaderInjectedFault     = "X-Injected-Fault"
+	ContentType             = "Content-Type"
 )
 
 const (
-	errTopicDoesNotExist   = "topic does not exist"
-	errTopicAlreadyExists  = "topic already exists"
-	errInvalidHeaderSizes  = "invalid header: " + HeaderSizes
-	errInvalidMessageID    = "invalid message id"
-	errInvalidMessageLimit = "invalid message limit"
-	errInvalidTopic        = "invalid topic"
-	errInvalidBodyMissing  = "invalid body: body cannot be empty"
-	errInvalidBodyJSON     = "invalid body: invalid json entry"
-	errNoContent           = "no content"
+	errTopicDoesNotExist    = "topic does not exist"
+	errTopicAlreadyExists   = "topic already exists"
+	errInvalidHeaderSizes   = "invalid header: " + HeaderSizes
+	errInvalidMessageID     = "invalid message id"
+	errInvalidMessageLimit  = "invalid message limit"
+	errInvalidTopic         = "invalid topic"
+	errInvalidBodyMissing   = "invalid body: body cannot be empty"
+	errInvalidBodyJSON      = "invalid body: invalid json entry"
+	errNoContent            = "no content"
+	errInsufficientStorage  = "insufficient storage"
+	errInvalidMessage       = "invalid message: schema validation failed"
+	errInvalidSchema        = "invalid schema"
+	errSchemaDoesNotExist   = "schema does not exist"
+	errInvalidCloudEvent    = "invalid body: invalid cloudevent"
+	errInvalidBodyLength    = "invalid body: length does not match " + HeaderSizes
+	errTopicLimitReached    = "topic limit reached"
+	errTopicQuotaExceeded   = "topic creation quota exceeded"
+	errDiskFull             = "disk full: writes are disabled"
+	errInvalidSequence      = "invalid header: " + HeaderSequence
+	errUnknownTransform     = "unknown replay transform"
+	errTopicReadOnly        = "topic is read-only"
+	errTooManyOpenFiles     = "too many open files: try again later"
+	errInvalidRange         = "invalid header: Range"
+	errInvalidGroup         = "invalid consumer group"
+	errInvalidBatchVersion  = "invalid header: X-Batch-Version"
+	errUnsupportedFeature   = "unsupported batch feature"
+	errOverloaded           = "server overloaded: try again later"
+	errForbidden            = "forbidden"
+	errACLConflict          = "acl version conflict"
+	errACLRuleDoesNotExist  = "acl rule does not exist"
+	errUnauthorized         = "unauthorized"
+	errUserDoesNotExist     = "user does not exist"
+	errKeyDoesNotExist      = "key does not exist"
+	errInvalidSubject       = "invalid header: " + HeaderEncryptionSubject
+	errInvalidContentType   = "invalid header: " + HeaderContentTypes
+	errInvalidEnvelope      = "invalid body: invalid protobuf batch"
+	errProduceQuota         = "produce quota exceeded: try again later"
+	errQuotaDoesNotExist    = "quota does not exist"
+	errScheduleDoesNotExist = "schedule does not exist"
 )
 
 // Errors returned by the Client/Server
 var (
-	ErrTopicDoesNotExist   = errors.New(errTopicDoesNotExist)
-	ErrTopicAlreadyExists  = errors.New(errTopicAlreadyExists)
-	ErrInvalidHeaderSizes  = errors.New(errInvalidHeaderSizes)
-	ErrInvalidMessageID    = errors.New(errInvalidMessageID)
-	ErrInvalidMessageLimit = errors.New(errInvalidMessageLimit)
-	ErrInvalidTopic        = errors.New(errInvalidTopic)
-	ErrInvalidBodyMissing  = errors.New(errInvalidBodyMissing)
-	ErrInvalidBodyJSON     = errors.New(errInvalidBodyJSON)
-	ErrNoContent           = errors.New(errNoContent)
+	ErrTopicDoesNotExist    = errors.New(errTopicDoesNotExist)
+	ErrTopicAlreadyExists   = errors.New(errTopicAlreadyExists)
+	ErrInvalidHeaderSizes   = errors.New(errInvalidHeaderSizes)
+	ErrInvalidMessageID     = errors.New(errInvalidMessageID)
+	ErrInvalidMessageLimit  = errors.New(errInvalidMessageLimit)
+	ErrInvalidTopic         = errors.New(errInvalidTopic)
+	ErrInvalidBodyMissing   = errors.New(errInvalidBodyMissing)
+	ErrInvalidBodyJSON      = errors.New(errInvalidBodyJSON)
+	ErrNoContent            = errors.New(errNoContent)
+	ErrInsufficientStorage  = errors.New(errInsufficientStorage)
+	ErrInvalidMessage       = errors.New(errInvalidMessage)
+	ErrInvalidSchema        = errors.New(errInvalidSchema)
+	ErrSchemaDoesNotExist   = errors.New(errSchemaDoesNotExist)
+	ErrInvalidCloudEvent    = errors.New(errInvalidCloudEvent)
+	ErrInvalidBodyLength    = errors.New(errInvalidBodyLength)
+	ErrTopicLimitReached    = errors.New(errTopicLimitReached)
+	ErrTopicQuotaExceeded   = errors.New(errTopicQuotaExceeded)
+	ErrDiskFull             = errors.New(errDiskFull)
+	ErrInvalidSequence      = errors.New(errInvalidSequence)
+	ErrUnknownTransform     = errors.New(errUnknownTransform)
+	ErrTopicReadOnly        = errors.New(errTopicReadOnly)
+	ErrTooManyOpenFiles     = errors.New(errTooManyOpenFiles)
+	ErrInvalidRange         = errors.New(errInvalidRange)
+	ErrInvalidGroup         = errors.New(errInvalidGroup)
+	ErrInvalidBatchVersion  = errors.New(errInvalidBatchVersion)
+	ErrUnsupportedFeature   = errors.New(errUnsupportedFeature)
+	ErrOverloaded           = errors.New(errOverloaded)
+	ErrForbidden            = errors.New(errForbidden)
+	ErrACLConflict          = errors.New(errACLConflict)
+	ErrACLRuleDoesNotExist  = errors.New(errACLRuleDoesNotExist)
+	ErrUnauthorized         = errors.New(errUnauthorized)
+	ErrUserDoesNotExist     = errors.New(errUserDoesNotExist)
+	ErrKeyDoesNotExist      = errors.New(errKeyDoesNotExist)
+	ErrInvalidSubject       = errors.New(errInvalidSubject)
+	ErrInvalidContentType   = errors.New(errInvalidContentType)
+	ErrInvalidEnvelope      = errors.New(errInvalidEnvelope)
+	ErrProduceQuota         = errors.New(errProduceQuota)
+	ErrQuotaDoesNotExist    = errors.New(errQuotaDoesNotExist)
+	ErrScheduleDoesNotExist = errors.New(errScheduleDoesNotExist)
 )
 
-// SetError adds the error to the response header and body and sets the status code as needed
+// ErrorCode is a stable, machine readable identifier of an error. Codes are sent in the X-Error-Code
+// header and the body of error responses, unlike error messages they will not change between versions
+type ErrorCode string
+
+// Error codes, each is returned with the http status given by its Status method
+const (
+	CodeTopicDoesNotExist    ErrorCode = "topic_does_not_exist"    // 412 Precondition Failed
+	CodeTopicAlreadyExists   ErrorCode = "topic_already_exists"    // 412 Precondition Failed
+	CodeInvalidHeaderSizes   ErrorCode = "invalid_header_sizes"    // 400 Bad Request
+	CodeInvalidMessageID     ErrorCode = "invalid_message_id"      // 400 Bad Request
+	CodeInvalidMessageLimit  ErrorCode = "invalid_message_limit"   // 400 Bad Request
+	CodeInvalidTopic         ErrorCode = "invalid_topic"           // 400 Bad Request
+	CodeInvalidBodyMissing   ErrorCode = "invalid_body_missing"    // 400 Bad Request
+	CodeInvalidBodyJSON      ErrorCode = "invalid_body_json"       // 400 Bad Request
+	CodeNoContent            ErrorCode = "no_content"              // 204 No Content
+	CodeInsufficientStorage  ErrorCode = "insufficient_storage"    // 507 Insufficient Storage
+	CodeInvalidMessage       ErrorCode = "invalid_message"         // 400 Bad Request
+	CodeInvalidSchema        ErrorCode = "invalid_schema"          // 400 Bad Request
+	CodeSchemaDoesNotExist   ErrorCode = "schema_does_not_exist"   // 404 Not Found
+	CodeInvalidCloudEvent    ErrorCode = "invalid_cloudevent"      // 400 Bad Request
+	CodeInvalidBodyLength    ErrorCode = "invalid_body_length"     // 400 Bad Request
+	CodeTopicLimitReached    ErrorCode = "topic_limit_reached"     // 403 Forbidden
+	CodeTopicQuotaExceeded   ErrorCode = "topic_quota_exceeded"    // 429 Too Many Requests
+	CodeDiskFull             ErrorCode = "disk_full"               // 503 Service Unavailable
+	CodeInvalidSequence      ErrorCode = "invalid_sequence"        // 400 Bad Request
+	CodeUnknownTransform     ErrorCode = "unknown_transform"       // 400 Bad Request
+	CodeTopicReadOnly        ErrorCode = "topic_read_only"         // 403 Forbidden
+	CodeTooManyOpenFiles     ErrorCode = "too_many_open_files"     // 503 Service Unavailable
+	CodeInvalidRange         ErrorCode = "invalid_range"           // 416 Requested Range Not Satisfiable
+	CodeInvalidGroup         ErrorCode = "invalid_group"           // 400 Bad Request
+	CodeInvalidBatchVersion  ErrorCode = "invalid_batch_version"   // 400 Bad Request
+	CodeUnsupportedFeature   ErrorCode = "unsupported_feature"     // 400 Bad Request
+	CodeOverloaded           ErrorCode = "overloaded"              // 429 Too Many Requests
+	CodeForbidden            ErrorCode = "forbidden"               // 403 Forbidden
+	CodeACLConflict          ErrorCode = "acl_conflict"            // 412 Precondition Failed
+	CodeACLRuleDoesNotExist  ErrorCode = "acl_rule_does_not_exist" // 404 Not Found
+	CodeUnauthorized         ErrorCode = "unauthorized"            // 401 Unauthorized
+	CodeUserDoesNotExist     ErrorCode = "user_does_not_exist"     // 404 Not Found
+	CodeKeyDoesNotExist      ErrorCode = "key_does_not_exist"      // 404 Not Found
+	CodeInvalidSubject       ErrorCode = "invalid_subject"         // 400 Bad Request
+	CodeInvalidContentType   ErrorCode = "invalid_content_type"    // 400 Bad Request
+	CodeInvalidEnvelope      ErrorCode = "invalid_envelope"        // 400 Bad Request
+	CodeProduceQuota         ErrorCode = "produce_quota_exceeded"  // 429 Too Many Requests
+	CodeQuotaDoesNotExist    ErrorCode = "quota_does_not_exist"    // 404 Not Found
+	CodeScheduleDoesNotExist ErrorCode = "schedule_does_not_exist" // 404 Not Found
+	CodeInternal             ErrorCode = "internal"                // 500 Internal Server Error
+)
+
+// errorCodes maps each error to its code and http status
+var errorCodes = []struct {
+	err    error
+	code   ErrorCode
+	status int
+}{
+	{ErrTopicDoesNotExist, CodeTopicDoesNotExist, http.StatusPreconditionFailed},
+	{ErrTopicAlreadyExists, CodeTopicAlreadyExists, http.StatusPreconditionFailed},
+	{ErrInvalidHeaderSizes, CodeInvalidHeaderSizes, http.StatusBadRequest},
+	{ErrInvalidMessageID, CodeInvalidMessageID, http.StatusBadRequest},
+	{ErrInvalidMessageLimit, CodeInvalidMessageLimit, http.StatusBadRequest},
+	{ErrInvalidTopic, CodeInvalidTopic, http.StatusBadRequest},
+	{ErrInvalidBodyMissing, CodeInvalidBodyMissing, http.StatusBadRequest},
+	{ErrInvalidBodyJSON, CodeInvalidBodyJSON, http.StatusBadRequest},
+	{ErrNoContent, CodeNoContent, http.StatusNoContent},
+	{ErrInsufficientStorage, CodeInsufficientStorage, http.StatusInsufficientStorage},
+	{ErrInvalidMessage, CodeInvalidMessage, http.StatusBadRequest},
+	{ErrInvalidSchema, CodeInvalidSchema, http.StatusBadRequest},
+	{ErrSchemaDoesNotExist, CodeSchemaDoesNotExist, http.StatusNotFound},
+	{ErrInvalidCloudEvent, CodeInvalidCloudEvent, http.StatusBadRequest},
+	{ErrInvalidBodyLength, CodeInvalidBodyLength, http.StatusBadRequest},
+	{ErrTopicLimitReached, CodeTopicLimitReached, http.StatusForbidden},
+	{ErrTopicQuotaExceeded, CodeTopicQuotaExceeded, http.StatusTooManyRequests},
+	{ErrDiskFull, CodeDiskFull, http.StatusServiceUnavailable},
+	{ErrInvalidSequence, CodeInvalidSequence, http.StatusBadRequest},
+	{ErrUnknownTransform, CodeUnknownTransform, http.StatusBadRequest},
+	{ErrTopicReadOnly, CodeTopicReadOnly, http.StatusForbidden},
+	{ErrTooManyOpenFiles, CodeTooManyOpenFiles, http.StatusServiceUnavailable},
+	{ErrInvalidRange, CodeInvalidRange, http.StatusRequestedRangeNotSatisfiable},
+	{ErrInvalidGroup, CodeInvalidGroup, http.StatusBadRequest},
+	{ErrInvalidBatchVersion, CodeInvalidBatchVersion, http.StatusBadRequest},
+	{ErrUnsupportedFeature, CodeUnsupportedFeature, http.StatusBadRequest},
+	{ErrOverloaded, CodeOverloaded, http.StatusTooManyRequests},
+	{ErrForbidden, CodeForbidden, http.StatusForbidden},
+	{ErrACLConflict, CodeACLConflict, http.StatusPreconditionFailed},
+	{ErrACLRuleDoesNotExist, CodeACLRuleDoesNotExist, http.StatusNotFound},
+	{ErrUnauthorized, CodeUnauthorized, http.StatusUnauthorized},
+	{ErrUserDoesNotExist, CodeUserDoesNotExist, http.StatusNotFound},
+	{ErrKeyDoesNotExist, CodeKeyDoesNotExist, http.StatusNotFound},
+	{ErrInvalidSubject, CodeInvalidSubject, http.StatusBadRequest},
+	{ErrInvalidContentType, CodeInvalidContentType, http.StatusBadRequest},
+	{ErrInvalidEnvelope, CodeInvalidEnvelope, http.StatusBadRequest},
+	{ErrProduceQuota, CodeProduceQuota, http.StatusTooManyRequests},
+	{ErrQuotaDoesNotExist, CodeQuotaDoesNotExist, http.StatusNotFound},
+	{ErrScheduleDoesNotExist, CodeScheduleDoesNotExist, http.StatusNotFound},
+}
+
+// Code returns the ErrorCode of the error, CodeInternal if the error is not one defined by this package
+func Code(err error) ErrorCode {
+	err = errors.Cause(err)
+	for _, e := range errorCodes {
+		if e.err == err {
+			return e.code
+		}
+	}
+	return CodeInternal
+}
+
+// Status returns the http status code sent with the error code
+func (c ErrorCode) Status() int {
+	for _, e := range errorCodes {
+		if e.code == c {
+			return e.status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// ErrorBody is the json body of an error response
+type ErrorBody struct {
+	Code  ErrorCode `json:"code"`
+	Error string    `json:"error"`
+}
+
+// SetError adds the error and its code to the response header and body and sets the status code as needed
 func SetError(w http.ResponseWriter, errOriginal error) {
 	if errOriginal == nil {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 	err := errors.Cause(errOriginal)
+	code := Code(err)
 	h := w.Header()
-	h[HeaderErrors] = []string{err.Error()}
-	switch err {
-	case ErrTopicDoesNotExist, ErrTopicAlreadyExists:
-		w.WriteHeader(http.StatusPreconditionFailed)
-	case ErrInvalidHeaderSizes, ErrInvalidMessageID, ErrInvalidMessageLimit, ErrInvalidTopic, ErrInvalidBodyMissing, ErrInvalidBodyJSON:
-		w.WriteHeader(http.StatusBadRequest)
-	case ErrNoContent:
-		w.WriteHeader(http.StatusNoContent)
-	default:
-		w.WriteHeader(http.StatusInternalServerError)
+	values := make([]string, 2, 3)
+	values[0], values[1] = err.Error(), string(code)
+	h[HeaderErrors] = values[0:1:1]
+	h[HeaderErrorCode] = values[1:2:2]
+	status := code.Status()
+	if !bodyAllowed(status) {
+		w.WriteHeader(status)
+		return
 	}
-	_, _ = w.Write([]byte(errOriginal.Error()))
+	h[ContentType] = append(values[2:2], "application/json")
+	w.WriteHeader(status)
+
+	// the body is the json encoding of an ErrorBody, written without reflection
+	msg := errOriginal.Error()
+	b := make([]byte, 0, 32+len(code)+len(msg))
+	b = append(b, `{"code":`...)
+	b = appendJSONString(b, string(code))
+	b = append(b, `,"error":`...)
+	b = appendJSONString(b, msg)
+	b = append(b, "}\n"...)
+	_, _ = w.Write(b)
+}
+
+// bodyAllowed returns false for the statuses which must not have a response body, 1xx, 204 and 304
+func bodyAllowed(status int) bool {
+	return status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified
 }
 
-// ReadErrors reads any errors from the response header and returns as an error type
+// ReadErrors reads any errors from the response header and returns as an error type. The error code is
+// used if present, otherwise the error is matched by its message
 func ReadErrors(header http.Header) error {
-	errs := header[HeaderErrors]
-	if len(errs) == 0 {
-		return nil
+	if codes := header[HeaderErrorCode]; len(codes) > 0 && codes[0] != string(CodeInternal) {
+		for _, e := range errorCodes {
+			if string(e.code) == codes[0] {
+				return e.err
+			}
+		}
 	}
-	for _, err := range errs {
-		switch err {
-		case "":
+	for _, msg := range header[HeaderErrors] {
+		if msg == "" {
 			continue
-		case errTopicDoesNotExist:
-			return ErrTopicDoesNotExist
-		case errTopicAlreadyExists:
-			return ErrTopicAlreadyExists
-		case errInvalidHeaderSizes:
-			return ErrInvalidHeaderSizes
-		case errInvalidMessageID:
-			return ErrInvalidMessageID
-		case errInvalidMessageLimit:
-			return ErrInvalidMessageLimit
-		case errInvalidTopic:
-			return ErrInvalidTopic
-		case errInvalidBodyMissing:
-			return ErrInvalidBodyMissing
-		case errInvalidBodyJSON:
-			return ErrInvalidBodyJSON
-		case errNoContent:
-			return ErrNoContent
-		default:
-			return errors.New(err)
 		}
+		for _, e := range errorCodes {
+			if e.err.Error() == msg {
+				return e.err
+			}
+		}
+		return errors.New(msg)
 	}
 	return nil
 }
@@ -106,11 +314,10 @@ func ReadSizes(header http.Header) ([]int64, error) {
 	if len(sizes) == 0 {
 		return nil, ErrInvalidHeaderSizes
 	}
-	var err error
+	var ok bool
 	msgSizes := make([]int64, len(sizes))
 	for i, size := range sizes {
-		msgSizes[i], err = strconv.ParseInt(size, 10, 64)
-		if err != nil {
+		if msgSizes[i], ok = parseSize(size); !ok {
 			return nil, ErrInvalidHeaderSizes
 		}
 	}
@@ -119,22 +326,496 @@ func ReadSizes(header http.Header) ([]int64, error) {
 
 // SetSizes sets the sizes of the messages in the header
 func SetSizes(msgSizes []int64, h http.Header) http.Header {
-	sizes := make([]string, len(msgSizes))
+	var v values
+	v.init(len(msgSizes), 8)
 	for i := range msgSizes {
-		sizes[i] = strconv.FormatInt(msgSizes[i], 10)
+		v.buf = strconv.AppendInt(v.buf, msgSizes[i], 10)
+		v.end(i)
+	}
+	h[HeaderSizes] = v.strings()
+	return h
+}
+
+// SetTimestamps sets the time each message was produced in the header, in RFC 3339 format with nanoseconds
+func SetTimestamps(timestamps []time.Time, h http.Header) http.Header {
+	var v values
+	v.init(len(timestamps), len(time.RFC3339Nano))
+	for i := range timestamps {
+		v.buf = timestamps[i].UTC().AppendFormat(v.buf, time.RFC3339Nano)
+		v.end(i)
 	}
-	h[HeaderSizes] = sizes
+	h[HeaderTimestamps] = v.strings()
+	return h
+}
+
+// ReadTimestamps reads the time each message was produced from the header, nil is returned if the
+// header is missing
+func ReadTimestamps(header http.Header) ([]time.Time, error) {
+	values := header[HeaderTimestamps]
+	if len(values) == 0 {
+		return nil, nil
+	}
+	var err error
+	timestamps := make([]time.Time, len(values))
+	for i, v := range values {
+		timestamps[i], err = time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid header: "+HeaderTimestamps)
+		}
+	}
+	return timestamps, nil
+}
+
+// MessageID is a server assigned random (version 4) UUID identifying a message
+type MessageID [16]byte
+
+// NewMessageID returns a new random MessageID
+func NewMessageID() (MessageID, error) {
+	var id MessageID
+	if _, err := rand.Read(id[:]); err != nil {
+		return id, errors.Wrap(err, "unable to generate message id")
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return id, nil
+}
+
+// String returns the id in the canonical UUID format
+func (id MessageID) String() string {
+	var b [36]byte
+	return string(id.appendString(b[:0]))
+}
+
+// appendString appends the id in the canonical UUID format to b
+func (id MessageID) appendString(b []byte) []byte {
+	n := len(b)
+	b = append(b, "00000000-0000-0000-0000-000000000000"...)
+	hex.Encode(b[n:n+8], id[0:4])
+	hex.Encode(b[n+9:n+13], id[4:6])
+	hex.Encode(b[n+14:n+18], id[6:8])
+	hex.Encode(b[n+19:n+23], id[8:10])
+	hex.Encode(b[n+24:], id[10:])
+	return b
+}
+
+// IsZero returns true for the nil UUID, used for messages which were not assigned an id
+func (id MessageID) IsZero() bool {
+	return id == MessageID{}
+}
+
+// ParseMessageID parses a UUID in the canonical format
+func ParseMessageID(s string) (MessageID, error) {
+	var id MessageID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return id, errors.Errorf("invalid message id %q", s)
+	}
+	j := 0
+	for _, group := range [...][2]int{{0, 8}, {9, 13}, {14, 18}, {19, 23}, {24, 36}} {
+		for i := group[0]; i < group[1]; i += 2 {
+			hi, ok1 := fromHex(s[i])
+			lo, ok2 := fromHex(s[i+1])
+			if !ok1 || !ok2 {
+				return id, errors.Errorf("invalid message id %q", s)
+			}
+			id[j] = hi<<4 | lo
+			j++
+		}
+	}
+	return id, nil
+}
+
+// SetMessageIDs sets the id of each message in the header
+func SetMessageIDs(ids []MessageID, h http.Header) http.Header {
+	var v values
+	v.init(len(ids), 36)
+	for i := range ids {
+		v.buf = ids[i].appendString(v.buf)
+		v.end(i)
+	}
+	h[HeaderMessageIDs] = v.strings()
+	return h
+}
+
+// ReadMessageIDs reads the id of each message from the header, nil is returned if the header is missing
+func ReadMessageIDs(header http.Header) ([]MessageID, error) {
+	values := header[HeaderMessageIDs]
+	if len(values) == 0 {
+		return nil, nil
+	}
+	var err error
+	ids := make([]MessageID, len(values))
+	for i, v := range values {
+		ids[i], err = ParseMessageID(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid header: "+HeaderMessageIDs)
+		}
+	}
+	return ids, nil
+}
+
+// MaxContentTypeLength is the longest content type which can be stored with a message
+const MaxContentTypeLength = 255
+
+// SetContentTypes sets the content type of each message in the header, messages without a content type
+// have an empty value
+func SetContentTypes(types []string, h http.Header) http.Header {
+	h[HeaderContentTypes] = append([]string(nil), types...)
+	return h
+}
+
+// ReadContentTypes reads the content type of each message from the header, nil is returned if the header
+// is missing. Each non-empty value must be a valid media type
+func ReadContentTypes(header http.Header) ([]string, error) {
+	values := header[HeaderContentTypes]
+	if len(values) == 0 {
+		return nil, nil
+	}
+	types := make([]string, len(values))
+	for i, v := range values {
+		var err error
+		if types[i], err = ParseContentType(v); err != nil {
+			return nil, err
+		}
+	}
+	return types, nil
+}
+
+// ParseContentType checks the content type of a message is empty or a valid media type, returning it
+// without surrounding whitespace
+func ParseContentType(v string) (string, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return "", nil
+	}
+	if len(v) > MaxContentTypeLength {
+		return "", ErrInvalidContentType
+	}
+	if mediaType, _, err := mime.ParseMediaType(v); err != nil || !strings.Contains(mediaType, "/") {
+		return "", ErrInvalidContentType
+	}
+	return v, nil
+}
+
+// SetTopicInfo sets the offsets, message count and size of the topic in the header
+func SetTopicInfo(info *TopicInfo, h http.Header) http.Header {
+	h[HeaderMinOffset] = []string{strconv.FormatInt(info.MinOffset, 10)}
+	h[HeaderMaxOffset] = []string{strconv.FormatInt(info.MaxOffset, 10)}
+	h[HeaderCount] = []string{strconv.FormatInt(info.MaxOffset-info.MinOffset+1, 10)}
+	h[HeaderTopicSize] = []string{strconv.FormatInt(info.Size, 10)}
 	return h
 }
 
+// ReadTopicInfo reads the offsets and size of a topic from the header
+func ReadTopicInfo(header http.Header) (*TopicInfo, error) {
+	info := &TopicInfo{}
+	for key, v := range map[string]*int64{HeaderMinOffset: &info.MinOffset, HeaderMaxOffset: &info.MaxOffset, HeaderTopicSize: &info.Size} {
+		values := header[key]
+		if len(values) == 0 {
+			return nil, errors.New("invalid header: missing " + key)
+		}
+		var err error
+		if *v, err = strconv.ParseInt(values[0], 10, 64); err != nil {
+			return nil, errors.Wrap(err, "invalid header: "+key)
+		}
+	}
+	return info, nil
+}
+
 // ModifyRequest is the request structure required by the modify endpoints
 type ModifyRequest struct {
-	Truncate int64     `json:"truncate,omitempty"`
-	Before   time.Time `json:"before,omitempty"`
+	Truncate int64        `json:"truncate,omitempty"`
+	Before   time.Time    `json:"before,omitempty"`
+	Delete   *OffsetRange `json:"delete,omitempty"`
+	Redact   *OffsetRange `json:"redact,omitempty"`
+	Purge    bool         `json:"purge,omitempty"`
+	Config   *TopicConfig `json:"config,omitempty"`
+}
+
+// TopicConfig is the configuration of a topic. In modify requests only the fields which are set are changed
+type TopicConfig struct {
+	ReadOnly  *bool         `json:"readOnly,omitempty"`
+	Retention *Duration     `json:"retention,omitempty"`
+	Truncate  *TruncateRule `json:"truncate,omitempty"`
+}
+
+// TruncateRule truncates a topic to the messages produced within Keep whenever Cron matches in UTC, for
+// example a Cron of "@hourly" and a Keep of "24h" keeps the last day of messages. In modify requests a rule
+// with an empty Cron removes the topic's rule
+type TruncateRule struct {
+	Cron string   `json:"cron"`
+	Keep Duration `json:"keep"`
+}
+
+// Duration is a time.Duration encoded in json as a string such as "24h"
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes a duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.Wrap(err, "invalid duration")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return errors.Wrap(err, "invalid duration")
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// OffsetRange is an inclusive range of message offsets
+type OffsetRange struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
 }
 
 // TopicInfo is the response structure returned by the modify endpoints
 type TopicInfo struct {
-	MinOffset int64 `json:"minOffset"`
-	MaxOffset int64 `json:"maxOffset"`
+	MinOffset int64        `json:"minOffset"`
+	MaxOffset int64        `json:"maxOffset"`
+	Size      int64        `json:"size,omitempty"`
+	Config    *TopicConfig `json:"config,omitempty"`
+}
+
+// MessageCount is the response structure of the count endpoint, the number of messages of the topic with
+// offsets from From up to but excluding To
+type MessageCount struct {
+	Topic string `json:"topic"`
+	From  int64  `json:"from"`
+	To    int64  `json:"to"`
+	Count int64  `json:"count"`
+}
+
+// ReplayResponse is the response structure returned by the replay endpoint
+type ReplayResponse struct {
+	Count int64 `json:"count"`
+}
+
+// JoinGroupRequest is the request structure of the group join endpoint, a member joins with an empty id
+// and sends its assigned id with each heartbeat. Topics matching the prefix and/or regex are subscribed to
+// along with the listed topics, and are matched again with each heartbeat
+type JoinGroupRequest struct {
+	Member string   `json:"member,omitempty"`
+	Topics []string `json:"topics"`
+	Prefix string   `json:"prefix,omitempty"`
+	Regex  string   `json:"regex,omitempty"`
+}
+
+// GroupAssignment is the response structure of the group join endpoint. Topics are the topics assigned
+// to the member in the generation, with the group's next offset for each topic it has consumed
+type GroupAssignment struct {
+	Group      string           `json:"group"`
+	Member     string           `json:"member"`
+	Generation int64            `json:"generation"`
+	Topics     []string         `json:"topics"`
+	Offsets    map[string]int64 `json:"offsets,omitempty"`
+}
+
+// GroupMember is a live member of a consumer group and its assigned topics
+type GroupMember struct {
+	ID       string    `json:"id"`
+	Topics   []string  `json:"topics"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// GroupDescription is the response structure of the group describe endpoint, offsets are the group's next
+// offset for each topic it has consumed
+type GroupDescription struct {
+	Group      string           `json:"group"`
+	Generation int64            `json:"generation"`
+	Members    []GroupMember    `json:"members"`
+	Offsets    map[string]int64 `json:"offsets,omitempty"`
+}
+
+// CommitRequest is the request structure of the group commit endpoint, the next offset of the group for
+// each topic
+type CommitRequest struct {
+	Offsets map[string]int64 `json:"offsets"`
+}
+
+// ScalingHint is the response structure of the scaling endpoint, summarizing the lag of a consumer group for
+// autoscalers. LagRate is the change of the lag in messages per second over the scaling window, positive
+// while the group falls behind, and Consumers is the suggested number of consumers to keep each one's share of
+// the lag projected over the window within the target lag
+type ScalingHint struct {
+	Group     string           `json:"group"`
+	Lag       int64            `json:"lag"`
+	LagRate   float64          `json:"lagRate"`
+	Topics    int              `json:"topics"`
+	Members   int              `json:"members"`
+	TargetLag int64            `json:"targetLag"`
+	Consumers int64            `json:"consumers"`
+	TopicLag  map[string]int64 `json:"topicLag,omitempty"`
+}
+
+// Offset reset positions
+const (
+	ResetEarliest  = "earliest"
+	ResetLatest    = "latest"
+	ResetTimestamp = "timestamp"
+	ResetOffset    = "offset"
+)
+
+// OffsetReset is the position a group's offset of a topic is reset to: the earliest or latest message of the
+// topic, the first message produced at or after the timestamp, or the given offset
+type OffsetReset struct {
+	To        string    `json:"to"`
+	Timestamp time.Time `json:"timestamp,omitempty"`
+	Offset    int64     `json:"offset,omitempty"`
+}
+
+// ResetRequest is the request structure of the group reset endpoint, the position of the group's offset for
+// each topic. In a dry run the offsets are returned without being committed
+type ResetRequest struct {
+	Topics map[string]OffsetReset `json:"topics"`
+	DryRun bool                   `json:"dryRun,omitempty"`
+}
+
+// ResetResponse is the response structure of the group reset endpoint, the group's next offset for each topic
+type ResetResponse struct {
+	Offsets map[string]int64 `json:"offsets"`
+	DryRun  bool             `json:"dryRun,omitempty"`
+}
+
+// ACL permissions
+const (
+	PermissionConsume = "consume"
+	PermissionProduce = "produce"
+	PermissionAdmin   = "admin"
+)
+
+// ACLRule grants a principal permissions on the topics it matches. A principal of "*" matches every
+// principal, and a topic ending in "*" matches every topic starting with the rest of it
+type ACLRule struct {
+	ID          string   `json:"id"`
+	Principal   string   `json:"principal"`
+	Topic       string   `json:"topic"`
+	Permissions []string `json:"permissions"`
+}
+
+// ACL is the export and import format of the acl endpoint. The version changes with every change to the
+// rules and is sent as the ETag of acl responses
+type ACL struct {
+	Version int64     `json:"version"`
+	Rules   []ACLRule `json:"rules"`
+}
+
+// User is a user of the server's user store, the password is only sent when the user is created or
+// changed and is never returned
+type User struct {
+	Name     string    `json:"name"`
+	Password string    `json:"password,omitempty"`
+	Admin    bool      `json:"admin"`
+	Created  time.Time `json:"created"`
+}
+
+// TopicKey is the data key which encrypts the messages of a topic, or of a subject if Subject is set, at
+// rest. New messages are encrypted with the current version, older messages are decrypted with the version
+// they were encrypted with
+type TopicKey struct {
+	Topic    string           `json:"topic,omitempty"`
+	Subject  string           `json:"subject,omitempty"`
+	Current  uint32           `json:"current"`
+	Versions []DataKeyVersion `json:"versions"`
+}
+
+// DataKeyVersion is a version of a topic's data key, stored wrapped by the key encryption key with the id KEK
+type DataKeyVersion struct {
+	Version uint32    `json:"version"`
+	KEK     string    `json:"kek"`
+	Created time.Time `json:"created"`
+	// Shredded is when the version was destroyed, messages encrypted with it can no longer be read
+	Shredded *time.Time `json:"shredded,omitempty"`
+}
+
+// ProduceQuota limits the rate messages are produced to a topic, or by a principal if Principal is set.
+// Rates of 0 are not limited. Producers may burst up to one second of each rate, further produces are
+// rejected until the quota has refilled. A principal of "*" gives every principal without a quota of its
+// own a separate quota with the same rates
+type ProduceQuota struct {
+	Topic             string  `json:"topic,omitempty"`
+	Principal         string  `json:"principal,omitempty"`
+	MessagesPerSecond float64 `json:"messagesPerSecond,omitempty"`
+	BytesPerSecond    float64 `json:"bytesPerSecond,omitempty"`
+}
+
+// Schedule is a request the server sends to itself whenever its cron expression matches, in the timezone if
+// it is set or else in UTC. The request skips authentication and authorization, as only admins manage
+// schedules. LastRun, LastStatus and NextRun are only returned by the server
+type Schedule struct {
+	Name       string            `json:"name"`
+	Cron       string            `json:"cron"`
+	Timezone   string            `json:"timezone,omitempty"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       string            `json:"body,omitempty"`
+	LastRun    *time.Time        `json:"lastRun,omitempty"`
+	LastStatus int               `json:"lastStatus,omitempty"`
+	NextRun    *time.Time        `json:"nextRun,omitempty"`
+}
+
+// Corruption is a damaged copy of a file set found by a scrub of the queue
+type Corruption struct {
+	Topic    string `json:"topic"`
+	File     string `json:"file"`
+	Dir      string `json:"dir"`
+	Reason   string `json:"reason"`
+	Repaired bool   `json:"repaired"`
+}
+
+// CacheStats are the cumulative counters of the queue file caches
+type CacheStats struct {
+	ProduceHits      int64 `json:"produceHits"`
+	ProduceMisses    int64 `json:"produceMisses"`
+	ProduceEvictions int64 `json:"produceEvictions"`
+	ConsumeHits      int64 `json:"consumeHits"`
+	ConsumeMisses    int64 `json:"consumeMisses"`
+	ConsumeEvictions int64 `json:"consumeEvictions"`
+	OpenFiles        int64 `json:"openFiles"`
+	FileRejections   int64 `json:"fileRejections"`
+	ReadAheads       int64 `json:"readAheads"`
+	Syncs            int64 `json:"syncs"`
+}
+
+// QueueDebug is a snapshot of the internal state of the queue, used for live debugging
+type QueueDebug struct {
+	Stats        CacheStats               `json:"stats"`
+	OpenFiles    []string                 `json:"openFiles"`
+	Producers    map[string]ProducerDebug `json:"producers"`
+	ConsumeNames map[string][]string      `json:"consumeNames"`
+}
+
+// ProducerDebug is the current write position of a cached producer file set
+type ProducerDebug struct {
+	File      string `json:"file"`
+	NextID    int64  `json:"nextID"`
+	DatOffset int64  `json:"datOffset"`
+	LogOffset int64  `json:"logOffset"`
+}
+
+// DiskUsage is the disk usage of the queue, as returned by the queue's DiskUsage method
+type DiskUsage struct {
+	Dirs   []DirUsage       `json:"dirs"`
+	Topics map[string]int64 `json:"topics"`
+}
+
+// DirUsage is the usage of the filesystem containing a single queue directory
+type DirUsage struct {
+	Path  string `json:"path"`
+	Total int64  `json:"total"`
+	Free  int64  `json:"free"`
+}
+
+// UsedFraction returns the fraction of the filesystem in use, between 0 and 1
+func (d DirUsage) UsedFraction() float64 {
+	if d.Total <= 0 {
+		return 0
+	}
+	return float64(d.Total-d.Free) / float64(d.Total)
 }
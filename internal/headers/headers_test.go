@@ -1,10 +1,13 @@
 package headers
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -28,6 +31,38 @@ func TestErrors(t *testing.T) {
 	// no content
 	testError(t, ErrNoContent, http.StatusNoContent)
 
+	// insufficient storage
+	testError(t, ErrInsufficientStorage, http.StatusInsufficientStorage)
+
+	// schemas
+	testError(t, ErrInvalidMessage, http.StatusBadRequest)
+	testError(t, ErrInvalidSchema, http.StatusBadRequest)
+	testError(t, ErrSchemaDoesNotExist, http.StatusNotFound)
+	testError(t, ErrInvalidCloudEvent, http.StatusBadRequest)
+	testError(t, ErrInvalidBodyLength, http.StatusBadRequest)
+	testError(t, ErrTopicLimitReached, http.StatusForbidden)
+	testError(t, ErrTopicQuotaExceeded, http.StatusTooManyRequests)
+	testError(t, ErrDiskFull, http.StatusServiceUnavailable)
+	testError(t, ErrInvalidSequence, http.StatusBadRequest)
+	testError(t, ErrUnknownTransform, http.StatusBadRequest)
+	testError(t, ErrTopicReadOnly, http.StatusForbidden)
+	testError(t, ErrTooManyOpenFiles, http.StatusServiceUnavailable)
+	testError(t, ErrInvalidRange, http.StatusRequestedRangeNotSatisfiable)
+	testError(t, ErrInvalidGroup, http.StatusBadRequest)
+	testError(t, ErrInvalidBatchVersion, http.StatusBadRequest)
+	testError(t, ErrUnsupportedFeature, http.StatusBadRequest)
+	testError(t, ErrOverloaded, http.StatusTooManyRequests)
+	testError(t, ErrForbidden, http.StatusForbidden)
+	testError(t, ErrACLConflict, http.StatusPreconditionFailed)
+	testError(t, ErrACLRuleDoesNotExist, http.StatusNotFound)
+	testError(t, ErrUnauthorized, http.StatusUnauthorized)
+	testError(t, ErrUserDoesNotExist, http.StatusNotFound)
+	testError(t, ErrKeyDoesNotExist, http.StatusNotFound)
+	testError(t, ErrInvalidSubject, http.StatusBadRequest)
+	testError(t, ErrProduceQuota, http.StatusTooManyRequests)
+	testError(t, ErrQuotaDoesNotExist, http.StatusNotFound)
+	testError(t, ErrScheduleDoesNotExist, http.StatusNotFound)
+
 	// undefined error
 	testError(t, errors.New("some new error"), http.StatusInternalServerError)
 
@@ -41,6 +76,25 @@ func TestErrors(t *testing.T) {
 	if err := ReadErrors(map[string][]string{HeaderErrors: {"", errInvalidTopic}}); err != ErrInvalidTopic {
 		t.Fatal(err)
 	}
+
+	// codes take precedence over messages, unknown codes fall back to the message
+	if err := ReadErrors(map[string][]string{HeaderErrorCode: {"no_content"}, HeaderErrors: {errInvalidTopic}}); err != ErrNoContent {
+		t.Fatal(err)
+	}
+	if err := ReadErrors(map[string][]string{HeaderErrorCode: {"future_code"}, HeaderErrors: {errInvalidTopic}}); err != ErrInvalidTopic {
+		t.Fatal(err)
+	}
+	if err := ReadErrors(map[string][]string{HeaderErrorCode: {"internal"}, HeaderErrors: {"disk failure"}}); err == nil || err.Error() != "disk failure" {
+		t.Fatal(err)
+	}
+	if ErrorCode("future_code").Status() != http.StatusInternalServerError {
+		t.Fatal("expected unknown codes to be internal errors")
+	}
+	for status, allowed := range map[int]bool{http.StatusContinue: false, http.StatusNoContent: false, http.StatusNotModified: false, http.StatusOK: true, http.StatusPreconditionFailed: true} {
+		if bodyAllowed(status) != allowed {
+			t.Error(status, allowed)
+		}
+	}
 }
 
 func testError(t *testing.T, errIn error, code int, wrapped ...struct{}) {
@@ -54,6 +108,24 @@ func testError(t *testing.T, errIn error, code int, wrapped ...struct{}) {
 		t.Fatal(code, resp.StatusCode)
 	}
 
+	if errIn != nil {
+		var body ErrorBody
+		if code != http.StatusNoContent {
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != Code(errIn) || body.Error != errIn.Error() || resp.Header.Get(ContentType) != "application/json" {
+				t.Fatal(body, resp.Header)
+			}
+		} else if w.Body.Len() != 0 || resp.Header.Get(ContentType) != "" {
+			// statuses without a body only carry the error headers
+			t.Fatal(w.Body.String(), resp.Header)
+		}
+		if resp.Header.Get(HeaderErrorCode) != string(Code(errIn)) || Code(errIn).Status() != code {
+			t.Fatal(resp.Header, Code(errIn))
+		}
+	}
+
 	errOut := ReadErrors(resp.Header)
 	if errors.Cause(errIn) != errOut {
 		// check unique errors
@@ -68,6 +140,7 @@ func TestSizes(t *testing.T) {
 	testSize(t, map[string][]string{}, nil, ErrInvalidHeaderSizes)
 	testSize(t, map[string][]string{HeaderSizes: {}}, nil, ErrInvalidHeaderSizes)
 	testSize(t, map[string][]string{HeaderSizes: {"blue"}}, nil, ErrInvalidHeaderSizes)
+	testSize(t, map[string][]string{HeaderSizes: {"1", "-1"}}, nil, ErrInvalidHeaderSizes)
 	testSize(t, map[string][]string{HeaderSizes: {"123"}}, []int64{123}, nil)
 	testSize(t, map[string][]string{HeaderSizes: {"123", "456"}}, []int64{123, 456}, nil)
 
@@ -81,6 +154,92 @@ func TestSizes(t *testing.T) {
 
 }
 
+func TestTimestamps(t *testing.T) {
+	timestamps, err := ReadTimestamps(http.Header{})
+	if timestamps != nil || err != nil {
+		t.Fatal(timestamps, err)
+	}
+	if _, err = ReadTimestamps(http.Header{HeaderTimestamps: {"yesterday"}}); err == nil {
+		t.Fatal("expected invalid timestamp error")
+	}
+
+	now := time.Now()
+	h := SetTimestamps([]time.Time{now, now.Add(time.Nanosecond)}, http.Header{})
+	timestamps, err = ReadTimestamps(h)
+	if err != nil || len(timestamps) != 2 || !timestamps[0].Equal(now) || !timestamps[1].Equal(now.Add(time.Nanosecond)) {
+		t.Fatal(timestamps, err)
+	}
+}
+
+func TestMessageIDs(t *testing.T) {
+	id, err := NewMessageID()
+	if err != nil || id.IsZero() {
+		t.Fatal(id, err)
+	}
+	str := id.String()
+	if len(str) != 36 || str[14] != '4' || !strings.ContainsRune("89ab", rune(str[19])) {
+		t.Fatal(str)
+	}
+	parsed, err := ParseMessageID(str)
+	if err != nil || parsed != id {
+		t.Fatal(parsed, err)
+	}
+	if (MessageID{}).String() != "00000000-0000-0000-0000-000000000000" {
+		t.Fatal((MessageID{}).String())
+	}
+	for _, invalid := range []string{"", "not-a-uuid", "0000000000000000-0000-0000-00000000", "zzzzzzzz-0000-0000-0000-000000000000", "0000000--000-0000-0000-000000000000", "00000000-0000-0000-0000-00000000000-"} {
+		if _, err = ParseMessageID(invalid); err == nil {
+			t.Error("expected invalid id error", invalid)
+		}
+	}
+
+	ids, err := ReadMessageIDs(http.Header{})
+	if ids != nil || err != nil {
+		t.Fatal(ids, err)
+	}
+	if _, err = ReadMessageIDs(http.Header{HeaderMessageIDs: {"invalid"}}); err == nil {
+		t.Fatal("expected invalid header error")
+	}
+	ids, err = ReadMessageIDs(SetMessageIDs([]MessageID{id, {}}, http.Header{}))
+	if err != nil || len(ids) != 2 || ids[0] != id || !ids[1].IsZero() {
+		t.Fatal(ids, err)
+	}
+}
+
+func TestContentTypes(t *testing.T) {
+	types, err := ReadContentTypes(http.Header{})
+	if types != nil || err != nil {
+		t.Fatal(types, err)
+	}
+	for _, invalid := range []string{"text", "text/", "not a type", "text/plain; charset", strings.Repeat("a", 250) + "/plain"} {
+		if _, err = ReadContentTypes(http.Header{HeaderContentTypes: {invalid}}); err != ErrInvalidContentType {
+			t.Error("expected invalid content type error", invalid, err)
+		}
+	}
+	types, err = ReadContentTypes(SetContentTypes([]string{"application/json", "", " text/plain; charset=utf-8"}, http.Header{}))
+	if err != nil || !reflect.DeepEqual(types, []string{"application/json", "", "text/plain; charset=utf-8"}) {
+		t.Fatal(types, err)
+	}
+}
+
+func TestTopicInfo(t *testing.T) {
+	h := SetTopicInfo(&TopicInfo{MinOffset: 5, MaxOffset: 9, Size: 320}, http.Header{})
+	if h.Get(HeaderCount) != "5" {
+		t.Fatal(h)
+	}
+	info, err := ReadTopicInfo(h)
+	if err != nil || *info != (TopicInfo{MinOffset: 5, MaxOffset: 9, Size: 320}) {
+		t.Fatal(info, err)
+	}
+	if _, err = ReadTopicInfo(http.Header{}); err == nil {
+		t.Fatal("expected missing header error")
+	}
+	h[HeaderTopicSize] = []string{"large"}
+	if _, err = ReadTopicInfo(h); err == nil {
+		t.Fatal("expected invalid header error")
+	}
+}
+
 func testSize(t *testing.T, header http.Header, sizes []int64, err error) {
 	s, e := ReadSizes(header)
 	if err != e {
@@ -90,3 +249,19 @@ func testSize(t *testing.T, header http.Header, sizes []int64, err error) {
 		t.Fatal(header, sizes, s)
 	}
 }
+
+func TestDuration(t *testing.T) {
+	b, err := json.Marshal(Duration(36 * time.Hour))
+	if err != nil || string(b) != `"36h0m0s"` {
+		t.Fatal(string(b), err)
+	}
+	var d Duration
+	if err = json.Unmarshal([]byte(`"90m"`), &d); err != nil || time.Duration(d) != 90*time.Minute {
+		t.Fatal(d, err)
+	}
+	for _, invalid := range []string{`5`, `"5 days"`} {
+		if err = json.Unmarshal([]byte(invalid), &d); err == nil {
+			t.Fatal(invalid)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package headers
+
+// RedactedMarker is the tombstone written over the payload of redacted messages. It is repeated to fill the
+// message and cut to its size, so redacted messages keep their size and offset
+const RedactedMarker = "\x00REDACTED"
+
+// Redacted returns the tombstone of a redacted message of the size
+func Redacted(size int) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = RedactedMarker[i%len(RedactedMarker)]
+	}
+	return b
+}
+
+// IsRedacted returns true if the message is the tombstone of a redacted message. Empty messages are not
+// redacted
+func IsRedacted(msg []byte) bool {
+	if len(msg) == 0 {
+		return false
+	}
+	for i := range msg {
+		if msg[i] != RedactedMarker[i%len(RedactedMarker)] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,20 @@
+package headers
+
+import (
+	"testing"
+)
+
+func TestRedacted(t *testing.T) {
+	for _, size := range []int{1, 5, len(RedactedMarker), 25} {
+		msg := Redacted(size)
+		if len(msg) != size || !IsRedacted(msg) {
+			t.Fatal(size, msg)
+		}
+	}
+	if string(Redacted(12)) != RedactedMarker+"\x00RE" {
+		t.Fatal(Redacted(12))
+	}
+	if IsRedacted(nil) || IsRedacted([]byte("REDACTED")) || IsRedacted(append(Redacted(10), 'x')) {
+		t.Fatal("unexpected redacted message")
+	}
+}
@@ -0,0 +1,32 @@
+package headers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+// Headers of requests signed with a shared key
+const (
+	HeaderSignature      = "X-Signature"
+	HeaderSignatureKey   = "X-Signature-Key"
+	HeaderSignatureTime  = "X-Signature-Time"
+	HeaderSignatureNonce = "X-Signature-Nonce"
+	HeaderContentSHA256  = "X-Content-Sha256"
+)
+
+// ContentSHA256 returns the value of the X-Content-Sha256 header for the body, the hex encoded sha256 hash
+func ContentSHA256(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// SignRequest returns the value of the X-Signature header of a request, sha256=<signature> where the
+// signature is the hex encoded HMAC-SHA256 of the method, request uri, unix timestamp, nonce and content hash,
+// each followed by a newline
+func SignRequest(secret []byte, method, uri string, timestamp int64, nonce, contentSHA256 string) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte(method + "\n" + uri + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + nonce + "\n" + contentSHA256 + "\n"))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
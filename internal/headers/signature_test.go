@@ -0,0 +1,31 @@
+package headers
+
+import (
+	"testing"
+)
+
+func TestSignRequest(t *testing.T) {
+	hash := ContentSHA256(nil)
+	if hash != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Fatal(hash)
+	}
+	sig := SignRequest([]byte("secret"), "POST", "/topics/a", 1600000000, "n", hash)
+	if sig[:7] != "sha256=" || len(sig) != 7+64 {
+		t.Fatal(sig)
+	}
+	if sig != SignRequest([]byte("secret"), "POST", "/topics/a", 1600000000, "n", hash) {
+		t.Fatal("expected the same signature")
+	}
+	for _, other := range []string{
+		SignRequest([]byte("other"), "POST", "/topics/a", 1600000000, "n", hash),
+		SignRequest([]byte("secret"), "GET", "/topics/a", 1600000000, "n", hash),
+		SignRequest([]byte("secret"), "POST", "/topics/b", 1600000000, "n", hash),
+		SignRequest([]byte("secret"), "POST", "/topics/a", 1600000001, "n", hash),
+		SignRequest([]byte("secret"), "POST", "/topics/a", 1600000000, "n", ContentSHA256([]byte("body"))),
+		SignRequest([]byte("secret"), "POST", "/topics/a", 1600000000, "m", hash),
+	} {
+		if other == sig {
+			t.Fatal("expected a different signature")
+		}
+	}
+}
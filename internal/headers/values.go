@@ -0,0 +1,115 @@
+package headers
+
+import (
+	"unicode/utf8"
+)
+
+// values builds the values of a header with one value per message. The values are appended to a single
+// buffer and sliced from a single string, so a header costs the same few allocations for any number of
+// messages
+type values struct {
+	buf  []byte
+	ends []int
+}
+
+// init prepares the builder for n values of about size bytes each
+func (v *values) init(n, size int) {
+	v.buf = make([]byte, 0, n*size)
+	v.ends = make([]int, n)
+}
+
+// end marks the end of the i-th value
+func (v *values) end(i int) {
+	v.ends[i] = len(v.buf)
+}
+
+// strings returns the values as strings sharing the memory of one string
+func (v *values) strings() []string {
+	s := string(v.buf)
+	out := make([]string, len(v.ends))
+	start := 0
+	for i, end := range v.ends {
+		out[i] = s[start:end]
+		start = end
+	}
+	return out
+}
+
+// parseSize parses a non-negative decimal message size
+func parseSize(s string) (int64, bool) {
+	if s == "" || len(s) > 18 {
+		return 0, false
+	}
+	var n int64
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int64(c-'0')
+	}
+	return n, true
+}
+
+// fromHex returns the value of a hexadecimal digit
+func fromHex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// appendJSONString appends s to b as a json string, replacing invalid utf-8 as encoding/json does
+func appendJSONString(b []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hex[r&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
@@ -0,0 +1,103 @@
+package headers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestValues(t *testing.T) {
+	var v values
+	v.init(3, 2)
+	for i, s := range []string{"a", "", "bcd"} {
+		v.buf = append(v.buf, s...)
+		v.end(i)
+	}
+	if got := v.strings(); !reflect.DeepEqual(got, []string{"a", "", "bcd"}) {
+		t.Fatal(got)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int64{"0": 0, "7": 7, "123456789012345678": 123456789012345678} {
+		if n, ok := parseSize(s); !ok || n != want {
+			t.Error(s, n, ok)
+		}
+	}
+	for _, s := range []string{"", "-1", "+1", "1.5", " 1", "1234567890123456789"} {
+		if _, ok := parseSize(s); ok {
+			t.Error("expected invalid size", s)
+		}
+	}
+}
+
+func TestAppendJSONString(t *testing.T) {
+	for _, s := range []string{"", "plain", `quote " and \ slash`, "new\nline\ttab\r", "<a&b>", "\x00\x1f", "héllo", "\xff invalid", "sep  "} {
+		want, err := json.Marshal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := appendJSONString(nil, s); string(got) != string(want) {
+			t.Errorf("%q: got %s, want %s", s, got, want)
+		}
+	}
+}
+
+func TestHeaderAllocs(t *testing.T) {
+	sizes := make([]int64, 100)
+	timestamps := make([]time.Time, 100)
+	ids := make([]MessageID, 100)
+	for i := range sizes {
+		sizes[i] = int64(i * 1000)
+		timestamps[i] = time.Now()
+	}
+	h := http.Header{}
+	// the buffer, the value ends, the string and the values, for any number of messages
+	for name, fn := range map[string]func(){
+		"sizes":      func() { SetSizes(sizes, h) },
+		"timestamps": func() { SetTimestamps(timestamps, h) },
+		"ids":        func() { SetMessageIDs(ids, h) },
+	} {
+		if n := testing.AllocsPerRun(10, fn); n > 4 {
+			t.Error(name, n)
+		}
+	}
+	SetSizes(sizes, h)
+	if n := testing.AllocsPerRun(10, func() { _, _ = ReadSizes(h) }); n > 1 {
+		t.Error("read sizes", n)
+	}
+	SetMessageIDs(ids, h)
+	if n := testing.AllocsPerRun(10, func() { _, _ = ReadMessageIDs(h) }); n > 1 {
+		t.Error("read ids", n)
+	}
+}
+
+func BenchmarkSetSizes(b *testing.B) {
+	sizes := make([]int64, 100)
+	h := http.Header{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		SetSizes(sizes, h)
+	}
+}
+
+func BenchmarkReadSizes(b *testing.B) {
+	h := SetSizes(make([]int64, 100), http.Header{})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = ReadSizes(h)
+	}
+}
+
+func BenchmarkSetError(b *testing.B) {
+	err := errors.Wrap(ErrInvalidTopic, "wrapped")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		SetError(httptest.NewRecorder(), err)
+	}
+}
@@ -0,0 +1,86 @@
+package headers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Headers negotiating the batch format between clients and servers
+const (
+	HeaderBatchVersion  = "X-Batch-Version"
+	HeaderBatchFeatures = "X-Batch-Features"
+)
+
+// BatchVersion is the latest version of the batch format. Requests without a version use version 0, the
+// format of clients which predate versioning
+const BatchVersion = 1
+
+// Features of the batch format. Producers list the features their batch uses, consumers list the
+// features they understand. Unversioned clients receive every feature which existed before versioning
+const (
+	FeatureTimestamps   = "timestamps"
+	FeatureMessageIDs   = "ids"
+	FeatureContentTypes = "content-types"
+)
+
+// BatchFeatures are the batch features supported by this version
+var BatchFeatures = []string{FeatureTimestamps, FeatureMessageIDs, FeatureContentTypes}
+
+// ReadBatchVersion reads the batch version and features requested in the header, version 0 and no
+// features are returned if the header is missing
+func ReadBatchVersion(header http.Header) (int, []string, error) {
+	values := header[HeaderBatchVersion]
+	if len(values) == 0 {
+		return 0, nil, nil
+	}
+	version, err := strconv.Atoi(values[0])
+	if err != nil || version < 0 {
+		return 0, nil, ErrInvalidBatchVersion
+	}
+	var features []string
+	for _, value := range header[HeaderBatchFeatures] {
+		for _, feature := range strings.Split(value, ",") {
+			if feature = strings.TrimSpace(feature); feature != "" {
+				features = append(features, feature)
+			}
+		}
+	}
+	return version, features, nil
+}
+
+// NegotiateBatch returns the latest version supported by both sides, and the requested features which
+// are supported. Unsupported features are returned separately
+func NegotiateBatch(version int, features []string) (int, []string, []string) {
+	if version > BatchVersion {
+		version = BatchVersion
+	}
+	var supported, unsupported []string
+	for _, feature := range features {
+		if containsFeature(BatchFeatures, feature) {
+			supported = append(supported, feature)
+		} else {
+			unsupported = append(unsupported, feature)
+		}
+	}
+	return version, supported, unsupported
+}
+
+// SetBatchVersion sets the batch version and features in the header
+func SetBatchVersion(version int, features []string, h http.Header) http.Header {
+	h[HeaderBatchVersion] = []string{strconv.Itoa(version)}
+	if len(features) > 0 {
+		h[HeaderBatchFeatures] = []string{strings.Join(features, ",")}
+	}
+	return h
+}
+
+// containsFeature returns true if the feature is in the list
+func containsFeature(features []string, feature string) bool {
+	for _, f := range features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
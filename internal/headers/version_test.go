@@ -0,0 +1,37 @@
+package headers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestBatchVersion(t *testing.T) {
+	version, features, err := ReadBatchVersion(http.Header{})
+	if version != 0 || features != nil || err != nil {
+		t.Fatal(version, features, err)
+	}
+	for _, invalid := range []string{"", "one", "-1"} {
+		if _, _, err = ReadBatchVersion(http.Header{HeaderBatchVersion: {invalid}}); err != ErrInvalidBatchVersion {
+			t.Error(invalid, err)
+		}
+	}
+
+	h := SetBatchVersion(7, []string{FeatureMessageIDs, "keys", " "}, http.Header{})
+	version, features, err = ReadBatchVersion(h)
+	if version != 7 || !reflect.DeepEqual(features, []string{FeatureMessageIDs, "keys"}) || err != nil {
+		t.Fatal(version, features, err)
+	}
+
+	version, supported, unsupported := NegotiateBatch(version, features)
+	if version != BatchVersion || !reflect.DeepEqual(supported, []string{FeatureMessageIDs}) || !reflect.DeepEqual(unsupported, []string{"keys"}) {
+		t.Fatal(version, supported, unsupported)
+	}
+	if version, _, _ = NegotiateBatch(0, nil); version != 0 {
+		t.Fatal(version)
+	}
+
+	if h = SetBatchVersion(1, nil, http.Header{}); len(h[HeaderBatchFeatures]) != 0 || h.Get(HeaderBatchVersion) != "1" {
+		t.Fatal(h)
+	}
+}
@@ -0,0 +1,191 @@
+// Package protowire reads and writes the protobuf wire format, shared by the grpc api, the envelope format
+// and the validation of protobuf schemas.
+package protowire
+
+import (
+	"github.com/pkg/errors"
+)
+
+// protobuf wire types
+const (
+	Varint  = 0
+	Fixed64 = 1
+	Bytes   = 2
+	Fixed32 = 5
+)
+
+// ErrMalformed is returned for messages which are not valid protobuf
+var ErrMalformed = errors.New("malformed protobuf message")
+
+// AppendVarint appends v as a varint
+func AppendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// AppendTag appends the tag of a field with the wire type
+func AppendTag(b []byte, field int, wire int) []byte {
+	return AppendVarint(b, uint64(field)<<3|uint64(wire))
+}
+
+// AppendInt appends an int64 field, zero values are omitted as in proto3
+func AppendInt(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	return AppendVarint(AppendTag(b, field, Varint), uint64(v))
+}
+
+// AppendString appends a string field, empty values are omitted as in proto3
+func AppendString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return append(AppendVarint(AppendTag(b, field, Bytes), uint64(len(s))), s...)
+}
+
+// AppendBytes appends a bytes field, empty values are kept so repeated fields keep their length
+func AppendBytes(b []byte, field int, v []byte) []byte {
+	return append(AppendVarint(AppendTag(b, field, Bytes), uint64(len(v))), v...)
+}
+
+// Decoder reads the fields of a protobuf message. The first error is recorded and all further reads
+// return zero values
+type Decoder struct {
+	b    []byte
+	wire int
+	err  error
+}
+
+// NewDecoder returns a decoder reading the message b. Values read share the memory of b
+func NewDecoder(b []byte) *Decoder {
+	return &Decoder{b: b}
+}
+
+// Next reads the next field tag, returning false at the end of the message or after an error
+func (d *Decoder) Next() (int, bool) {
+	if d.err != nil || len(d.b) == 0 {
+		return 0, false
+	}
+	d.wire = Varint
+	tag := d.Varint()
+	if d.err != nil || tag>>3 == 0 || tag>>3 > 1<<29 {
+		d.Fail()
+		return 0, false
+	}
+	d.wire = int(tag & 7)
+	return int(tag >> 3), true
+}
+
+// Wire returns the wire type of the field read by Next
+func (d *Decoder) Wire() int {
+	return d.wire
+}
+
+// Err returns the first error of the decoder
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+// Len returns the number of bytes left to read
+func (d *Decoder) Len() int {
+	return len(d.b)
+}
+
+// Fail records ErrMalformed unless an error is already recorded
+func (d *Decoder) Fail() {
+	if d.err == nil {
+		d.err = ErrMalformed
+	}
+}
+
+// Varint reads the value of a varint field
+func (d *Decoder) Varint() uint64 {
+	if d.wire != Varint {
+		d.Fail()
+	}
+	if d.err != nil {
+		return 0
+	}
+	var v uint64
+	for i := 0; i < 10 && i < len(d.b); i++ {
+		v |= uint64(d.b[i]&0x7f) << (7 * uint(i))
+		if d.b[i] < 0x80 {
+			d.b = d.b[i+1:]
+			return v
+		}
+	}
+	d.Fail()
+	return 0
+}
+
+// Int64 reads the value of an int64 field
+func (d *Decoder) Int64() int64 {
+	return int64(d.Varint())
+}
+
+// Bytes reads the value of a length delimited field
+func (d *Decoder) Bytes() []byte {
+	if d.wire != Bytes {
+		d.Fail()
+	}
+	d.wire = Varint
+	n := d.Varint()
+	d.wire = Bytes
+	if d.err != nil || n > uint64(len(d.b)) {
+		d.Fail()
+		return nil
+	}
+	v := d.b[:n:n]
+	d.b = d.b[n:]
+	return v
+}
+
+// String reads the value of a string field
+func (d *Decoder) String() string {
+	return string(d.Bytes())
+}
+
+// Value reads the value of a field of any wire type, returning varints as n and length delimited values
+// as b. Fixed size values are skipped
+func (d *Decoder) Value() (n uint64, b []byte) {
+	switch d.wire {
+	case Varint:
+		return d.Varint(), nil
+	case Bytes:
+		return 0, d.Bytes()
+	}
+	d.Skip()
+	return 0, nil
+}
+
+// Skip discards the value of an unknown field
+func (d *Decoder) Skip() {
+	d.SkipValue(d.wire)
+}
+
+// SkipValue discards a value of the wire type, such as the values of a packed repeated field
+func (d *Decoder) SkipValue(wire int) {
+	d.wire = wire
+	switch wire {
+	case Varint:
+		_ = d.Varint()
+	case Bytes:
+		_ = d.Bytes()
+	case Fixed64, Fixed32:
+		n := 8
+		if wire == Fixed32 {
+			n = 4
+		}
+		if len(d.b) < n {
+			d.Fail()
+			return
+		}
+		d.b = d.b[n:]
+	default:
+		d.Fail()
+	}
+}
@@ -0,0 +1,81 @@
+package protowire
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVarint(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 300, 1<<63 + 5} {
+		d := NewDecoder(AppendVarint(nil, v))
+		if got := d.Varint(); got != v || d.Err() != nil || d.Len() != 0 {
+			t.Error(v, got, d.Err())
+		}
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	// unknown fields of every wire type are skipped
+	b := AppendString(nil, 1, "topic")
+	b = AppendInt(b, 9, -3)
+	b = append(AppendTag(b, 10, Fixed64), 1, 2, 3, 4, 5, 6, 7, 8)
+	b = append(AppendTag(b, 11, Fixed32), 1, 2, 3, 4)
+	b = AppendBytes(b, 12, []byte("ignored"))
+	b = AppendBytes(AppendBytes(b, 2, []byte("a")), 2, nil)
+	var topic string
+	var values [][]byte
+	d := NewDecoder(b)
+	for field, ok := d.Next(); ok; field, ok = d.Next() {
+		switch field {
+		case 1:
+			topic = d.String()
+		case 2:
+			values = append(values, d.Bytes())
+		default:
+			d.Skip()
+		}
+	}
+	if d.Err() != nil || topic != "topic" || !reflect.DeepEqual(values, [][]byte{[]byte("a"), {}}) {
+		t.Fatal(topic, values, d.Err())
+	}
+
+	// values of any wire type are read by Value
+	d = NewDecoder(append(AppendInt(AppendString(nil, 1, "x"), 2, 7), AppendTag(nil, 3, Fixed32)...))
+	d.b = append(d.b, 1, 2, 3, 4)
+	var n uint64
+	var v []byte
+	for _, ok := d.Next(); ok; _, ok = d.Next() {
+		switch m, b := d.Value(); d.Wire() {
+		case Varint:
+			n = m
+		case Bytes:
+			v = b
+		}
+	}
+	if d.Err() != nil || n != 7 || string(v) != "x" {
+		t.Fatal(n, v, d.Err())
+	}
+
+	for _, b := range [][]byte{
+		{0x0a, 0x05, 'a'},    // bytes longer than the message
+		{0x08},               // truncated varint
+		{0x00, 0x01},         // field 0
+		{0x0b},               // group wire type
+		AppendInt(nil, 1, 5), // string with the varint wire type
+	} {
+		d := NewDecoder(b)
+		for _, ok := d.Next(); ok; _, ok = d.Next() {
+			_ = d.String()
+		}
+		if d.Err() != ErrMalformed {
+			t.Errorf("%x %v", b, d.Err())
+		}
+	}
+
+	// packed values are skipped by their wire type
+	d = NewDecoder(append(AppendVarint(nil, 300), 1, 2, 3))
+	d.SkipValue(Varint)
+	if d.SkipValue(Fixed32); d.Err() != ErrMalformed {
+		t.Fatal(d.Err())
+	}
+}
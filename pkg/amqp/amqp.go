@@ -0,0 +1,198 @@
+// Package amqp implements an AMQP 0.9.1 front end on top of a haraqa server, easing migration from RabbitMQ.
+//
+// Queues are haraqa topics and behave like RabbitMQ streams: messages are appended to the topic and never
+// removed by consumers. Each consumer reads independently from the position given by the x-stream-offset
+// consume argument, one of "first", "last", "next" (the default) or a numeric offset. Acknowledgements are
+// used for prefetch flow control only, rejected messages are not redelivered.
+//
+// Messages published to the default exchange are written to the queue named by the routing key. Direct,
+// fanout and topic exchanges route messages to each bound queue, exchanges and bindings are held in memory
+// and must be redeclared after a restart. Only message bodies are stored, message properties are discarded.
+// Publisher confirms are supported, transactions and basic.get are not.
+package amqp
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+// Queue is the subset of the haraqa server used to serve amqp clients, it is implemented by *server.Server
+type Queue interface {
+	CreateTopic(ctx context.Context, topic string) error
+	InspectTopic(ctx context.Context, topic string) (*headers.TopicInfo, error)
+	ProduceMsgs(ctx context.Context, topic string, msgs ...[]byte) error
+	ConsumeMsgs(ctx context.Context, topic string, id, limit int64) ([][]byte, error)
+}
+
+var _ Queue = &server.Server{}
+
+// Option represents a optional function argument to NewListener
+type Option func(*Listener) error
+
+// WithPollInterval sets how often queues with consumers are checked for new messages
+func WithPollInterval(interval time.Duration) Option {
+	return func(l *Listener) error {
+		if interval <= 0 {
+			return errors.New("invalid interval, value must be greater than 0")
+		}
+		l.pollInterval = interval
+		return nil
+	}
+}
+
+// WithHeartbeat sets the heartbeat interval proposed to clients, 0 disables heartbeats
+func WithHeartbeat(interval time.Duration) Option {
+	return func(l *Listener) error {
+		if interval < 0 || interval > 65535*time.Second {
+			return errors.New("invalid interval, value must be between 0 and 65535s")
+		}
+		l.heartbeat = interval
+		return nil
+	}
+}
+
+// WithLogger sets the logger used to report connection errors
+func WithLogger(logger server.Logger) Option {
+	return func(l *Listener) error {
+		if logger == nil {
+			return errors.New("logger cannot be nil")
+		}
+		l.logger = logger
+		return nil
+	}
+}
+
+// Listener serves amqp clients backed by a haraqa queue
+type Listener struct {
+	q            Queue
+	logger       server.Logger
+	router       *router
+	pollInterval time.Duration
+	heartbeat    time.Duration
+
+	mux       sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	done      chan struct{}
+	isClosed  bool
+	wg        sync.WaitGroup
+}
+
+// NewListener creates a new amqp listener on top of the queue
+func NewListener(q Queue, opts ...Option) (*Listener, error) {
+	if q == nil {
+		return nil, errors.New("queue cannot be nil")
+	}
+	l := &Listener{
+		q:            q,
+		logger:       noOpLogger{},
+		router:       newRouter(),
+		pollInterval: 100 * time.Millisecond,
+		heartbeat:    60 * time.Second,
+		listeners:    make(map[net.Listener]struct{}),
+		conns:        make(map[net.Conn]struct{}),
+		done:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		if err := opt(l); err != nil {
+			return nil, errors.Wrap(err, "invalid option")
+		}
+	}
+	return l, nil
+}
+
+// ListenAndServe listens on the tcp address and serves amqp clients until the listener is closed
+func (l *Listener) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return l.Serve(ln)
+}
+
+// Serve accepts connections on ln and serves amqp clients until the listener is closed
+func (l *Listener) Serve(ln net.Listener) error {
+	l.mux.Lock()
+	if l.isClosed {
+		l.mux.Unlock()
+		_ = ln.Close()
+		return errors.New("listener closed")
+	}
+	l.listeners[ln] = struct{}{}
+	l.mux.Unlock()
+
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-l.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		l.mux.Lock()
+		if l.isClosed {
+			l.mux.Unlock()
+			_ = nc.Close()
+			return nil
+		}
+		l.conns[nc] = struct{}{}
+		l.wg.Add(1)
+		l.mux.Unlock()
+		go func() {
+			defer l.wg.Done()
+			l.serveConn(nc)
+		}()
+	}
+}
+
+// Close stops all listeners and closes any open connections
+func (l *Listener) Close() error {
+	l.mux.Lock()
+	if !l.isClosed {
+		close(l.done)
+	}
+	l.isClosed = true
+	var err error
+	for ln := range l.listeners {
+		if e := ln.Close(); e != nil {
+			err = e
+		}
+	}
+	for nc := range l.conns {
+		_ = nc.Close()
+	}
+	l.mux.Unlock()
+	l.wg.Wait()
+	return err
+}
+
+func (l *Listener) serveConn(nc net.Conn) {
+	defer func() {
+		_ = nc.Close()
+		l.mux.Lock()
+		delete(l.conns, nc)
+		l.mux.Unlock()
+	}()
+
+	c := newConn(l, nc)
+	if err := c.serve(); err != nil {
+		l.logger.Debug("amqp connection closed", "remote", nc.RemoteAddr().String(), "err", err)
+	}
+}
+
+var _ server.Logger = noOpLogger{}
+
+type noOpLogger struct{}
+
+func (noOpLogger) Debug(string, ...interface{}) {}
+func (noOpLogger) Info(string, ...interface{})  {}
+func (noOpLogger) Warn(string, ...interface{})  {}
+func (noOpLogger) Error(string, ...interface{}) {}
@@ -0,0 +1,315 @@
+package amqp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+type testQueue struct {
+	mux    sync.Mutex
+	topics map[string][][]byte
+}
+
+func (q *testQueue) CreateTopic(ctx context.Context, topic string) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if _, ok := q.topics[topic]; ok {
+		return headers.ErrTopicAlreadyExists
+	}
+	q.topics[topic] = [][]byte{}
+	return nil
+}
+
+func (q *testQueue) InspectTopic(ctx context.Context, topic string) (*headers.TopicInfo, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	msgs, ok := q.topics[topic]
+	if !ok {
+		return nil, headers.ErrTopicDoesNotExist
+	}
+	return &headers.TopicInfo{MinOffset: 0, MaxOffset: int64(len(msgs)) - 1}, nil
+}
+
+func (q *testQueue) ProduceMsgs(ctx context.Context, topic string, msgs ...[]byte) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if _, ok := q.topics[topic]; !ok {
+		return headers.ErrTopicDoesNotExist
+	}
+	q.topics[topic] = append(q.topics[topic], msgs...)
+	return nil
+}
+
+func (q *testQueue) ConsumeMsgs(ctx context.Context, topic string, id, limit int64) ([][]byte, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	msgs, ok := q.topics[topic]
+	if !ok {
+		return nil, headers.ErrTopicDoesNotExist
+	}
+	msgs = msgs[id:]
+	if int64(len(msgs)) > limit {
+		msgs = msgs[:limit]
+	}
+	return msgs, nil
+}
+
+func (q *testQueue) messages(topic string) [][]byte {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return q.topics[topic]
+}
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+	if _, err = conn.Write(protocolHeader); err != nil {
+		t.Fatal(err)
+	}
+	c.expect(0, classConnection, 10)
+	c.send(0, classConnection, 11, appendLongstr(appendShortstr(appendLongstr(appendTable(nil, nil), []byte("PLAIN")), ""), []byte("\x00guest\x00guest")))
+	c.expect(0, classConnection, 30)
+	c.send(0, classConnection, 31, appendShort(appendLong(appendShort(nil, 0), frameMin), 0))
+	c.send(0, classConnection, 40, append(appendShortstr(appendShortstr(nil, "/"), ""), 0))
+	c.expect(0, classConnection, 41)
+	return c
+}
+
+func (c *testClient) send(channel, classID, methodID uint16, args []byte) {
+	c.t.Helper()
+	payload := append(appendShort(appendShort(nil, classID), methodID), args...)
+	if _, err := c.conn.Write(appendFrame(nil, frameMethod, channel, payload)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) publish(channel uint16, exchange, key string, body []byte) {
+	c.t.Helper()
+	c.send(channel, classBasic, 40, append(appendShortstr(appendShortstr(appendShort(nil, 0), exchange), key), 0))
+	header := appendShort(appendLonglong(appendShort(appendShort(nil, classBasic), 0), uint64(len(body))), 0)
+	b := appendFrame(nil, frameHeader, channel, header)
+	for len(body) > 0 {
+		n := len(body)
+		if n > 10 {
+			n = 10
+		}
+		b = appendFrame(b, frameBody, channel, body[:n])
+		body = body[n:]
+	}
+	if _, err := c.conn.Write(b); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) read() frame {
+	c.t.Helper()
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	f, err := readFrame(c.r, frameMaxDefault)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return f
+}
+
+// expect reads a method frame and returns a decoder for its arguments
+func (c *testClient) expect(channel, classID, methodID uint16) *decoder {
+	c.t.Helper()
+	f := c.read()
+	d := &decoder{b: f.payload}
+	if f.kind != frameMethod || f.channel != channel || d.short() != classID || d.short() != methodID {
+		c.t.Fatal(f)
+	}
+	return d
+}
+
+// delivery reads a basic.deliver and its content, returning the delivery tag, routing key and body
+func (c *testClient) delivery(channel uint16) (uint64, string, []byte) {
+	c.t.Helper()
+	d := c.expect(channel, classBasic, 60)
+	_ = d.shortstr()
+	tag := d.longlong()
+	_, _ = d.octet(), d.shortstr()
+	key := d.shortstr()
+
+	f := c.read()
+	h := &decoder{b: f.payload}
+	_, _ = h.short(), h.short()
+	size := h.longlong()
+	if f.kind != frameHeader || h.short() != 0x2000 || h.table() == nil || h.err != nil {
+		c.t.Fatal(f)
+	}
+	var body []byte
+	for uint64(len(body)) < size {
+		f = c.read()
+		if f.kind != frameBody || len(f.payload) > frameMin-8 {
+			c.t.Fatal(f.kind, len(f.payload))
+		}
+		body = append(body, f.payload...)
+	}
+	return tag, key, body
+}
+
+func TestNewListener(t *testing.T) {
+	if _, err := NewListener(nil); err == nil {
+		t.Error("expected nil queue error")
+	}
+	for _, opt := range []Option{WithPollInterval(0), WithHeartbeat(-1), WithLogger(nil)} {
+		if _, err := NewListener(&testQueue{}, opt); err == nil {
+			t.Error("expected invalid option error")
+		}
+	}
+}
+
+func TestStartOffset(t *testing.T) {
+	info := &headers.TopicInfo{MinOffset: 2, MaxOffset: 9}
+	for arg, expected := range map[interface{}]int64{nil: 10, "first": 2, "last": 9, "next": 10, int8(3): 3, uint32(4): 4, int64(5): 5} {
+		if offset, ok := startOffset(arg, info); !ok || offset != expected {
+			t.Error(arg, offset, ok)
+		}
+	}
+	for _, arg := range []interface{}{"oldest", int64(-1), true} {
+		if _, ok := startOffset(arg, info); ok {
+			t.Error(arg)
+		}
+	}
+	if offset, _ := startOffset("last", &headers.TopicInfo{MinOffset: 0, MaxOffset: -1}); offset != 0 {
+		t.Error(offset)
+	}
+}
+
+func TestListener(t *testing.T) {
+	q := &testQueue{topics: map[string][][]byte{"orders": {[]byte("old")}}}
+	l, err := NewListener(q, WithPollInterval(5*time.Millisecond), WithHeartbeat(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- l.Serve(ln) }()
+	defer func() {
+		if err := l.Close(); err != nil {
+			t.Error(err)
+		}
+		if err := <-served; err != nil {
+			t.Error(err)
+		}
+	}()
+	addr := ln.Addr().String()
+
+	// an unsupported protocol header is answered with the supported version
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = conn.Write([]byte{'A', 'M', 'Q', 'P', 1, 1, 0, 9})
+	header := make([]byte, len(protocolHeader))
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = bufio.NewReader(conn).Read(header); err != nil || !bytes.Equal(header, protocolHeader) {
+		t.Fatal(header, err)
+	}
+	_ = conn.Close()
+
+	c := dial(t, addr)
+	defer c.conn.Close()
+	c.send(1, classChannel, 10, appendShortstr(nil, ""))
+	c.expect(1, classChannel, 11)
+
+	// declare a queue and bind it to a topic exchange
+	c.send(1, classQueue, 10, appendTable(append(appendShortstr(appendShort(nil, 0), "orders"), 0), nil))
+	d := c.expect(1, classQueue, 11)
+	if d.shortstr() != "orders" || d.long() != 1 {
+		t.Fatal("unexpected declare-ok")
+	}
+	c.send(1, classExchange, 10, appendTable(append(appendShortstr(appendShortstr(appendShort(nil, 0), "events"), "topic"), 0), nil))
+	c.expect(1, classExchange, 11)
+	c.send(1, classQueue, 20, appendTable(append(appendShortstr(appendShortstr(appendShortstr(appendShort(nil, 0), ""), "events"), "order.#"), 0), nil))
+	c.expect(1, classQueue, 21)
+
+	// publish with confirms
+	c.send(1, classConfirm, 10, []byte{0})
+	c.expect(1, classConfirm, 11)
+	large := bytes.Repeat([]byte("x"), 3*frameMin)
+	c.publish(1, "events", "order.created", []byte("first message"))
+	c.publish(1, "", "orders", large)
+	c.publish(1, "events", "user.created", []byte("unroutable"))
+	for i := uint64(1); i <= 3; i++ {
+		if d = c.expect(1, classBasic, 80); d.longlong() != i {
+			t.Fatal("unexpected ack")
+		}
+	}
+	if !reflect.DeepEqual(q.messages("orders"), [][]byte{[]byte("old"), []byte("first message"), large}) {
+		t.Fatal(q.messages("orders"))
+	}
+
+	// consume from the start with a prefetch of 2
+	c.send(1, classBasic, 10, append(appendShort(appendLong(nil, 0), 2), 0))
+	c.expect(1, classBasic, 11)
+	args := map[string]interface{}{"x-stream-offset": "first"}
+	c.send(1, classBasic, 20, appendTable(append(appendShortstr(appendShortstr(appendShort(nil, 0), "orders"), "c1"), 0), args))
+	if d = c.expect(1, classBasic, 21); d.shortstr() != "c1" {
+		t.Fatal("unexpected consume-ok")
+	}
+	for i, expected := range [][]byte{[]byte("old"), []byte("first message")} {
+		tag, key, body := c.delivery(1)
+		if tag != uint64(i+1) || key != "orders" || !bytes.Equal(body, expected) {
+			t.Fatal(tag, key, string(body))
+		}
+	}
+	c.send(1, classBasic, 80, append(appendLonglong(nil, 2), 1))
+	if tag, _, body := c.delivery(1); tag != 3 || !bytes.Equal(body, large) {
+		t.Fatal(tag, len(body))
+	}
+
+	// errors close the channel
+	c.send(1, classBasic, 80, append(appendLonglong(nil, 7), 0))
+	if d = c.expect(1, classChannel, 40); d.short() != replyPreconditionFailed {
+		t.Fatal("expected precondition failed")
+	}
+	c.send(1, classBasic, 30, append(appendShortstr(nil, "c1"), 0)) // ignored while closing
+	c.send(1, classChannel, 41, nil)
+	c.send(2, classChannel, 10, appendShortstr(nil, ""))
+	c.expect(2, classChannel, 11)
+	c.send(2, classQueue, 10, appendTable(append(appendShortstr(appendShort(nil, 0), "missing"), 1), nil))
+	if d = c.expect(2, classChannel, 40); d.short() != replyNotFound {
+		t.Fatal("expected not found")
+	}
+	c.send(2, classChannel, 41, nil)
+
+	// unknown methods close the connection
+	c.send(3, classChannel, 10, appendShortstr(nil, ""))
+	c.expect(3, classChannel, 11)
+	c.send(3, 90, 10, nil)
+	if d = c.expect(0, classConnection, 50); d.short() != replyNotImplemented {
+		t.Fatal("expected not implemented")
+	}
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := readFrame(c.r, frameMaxDefault); err == nil {
+		t.Fatal("expected closed connection")
+	}
+
+	c = dial(t, addr)
+	defer c.conn.Close()
+	c.send(0, classConnection, 50, appendShort(appendShort(appendShortstr(appendShort(nil, 200), ""), 0), 0))
+	c.expect(0, classConnection, 51)
+}
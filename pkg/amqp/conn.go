@@ -0,0 +1,880 @@
+package amqp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// reply codes
+const (
+	replyAccessRefused      uint16 = 403
+	replyNotFound           uint16 = 404
+	replyPreconditionFailed uint16 = 406
+	replyFrameError         uint16 = 501
+	replyCommandInvalid     uint16 = 503
+	replyChannelError       uint16 = 504
+	replyUnexpectedFrame    uint16 = 505
+	replyResourceError      uint16 = 506
+	replyNotAllowed         uint16 = 530
+	replyNotImplemented     uint16 = 540
+	replyInternalError      uint16 = 541
+)
+
+// class ids
+const (
+	classConnection uint16 = 10
+	classChannel    uint16 = 20
+	classExchange   uint16 = 40
+	classQueue      uint16 = 50
+	classBasic      uint16 = 60
+	classConfirm    uint16 = 85
+)
+
+const (
+	handshakeTimeout = 10 * time.Second
+	channelMax       = 2047
+	frameMaxDefault  = 128 << 10
+	maxBodySize      = 100 << 20
+	consumeLimit     = 100
+)
+
+// errClientClosed is returned once the client has closed the connection
+var errClientClosed = errors.New("amqp: connection closed by client")
+
+// amqpError is a channel or connection exception sent to the client
+type amqpError struct {
+	code       uint16
+	text       string
+	classID    uint16
+	methodID   uint16
+	connection bool
+}
+
+func (e *amqpError) Error() string {
+	return "amqp: " + strconv.Itoa(int(e.code)) + " " + e.text
+}
+
+func channelError(code uint16, text string, classID, methodID uint16) error {
+	return &amqpError{code: code, text: text, classID: classID, methodID: methodID}
+}
+
+func connectionError(code uint16, text string, classID, methodID uint16) error {
+	return &amqpError{code: code, text: text, classID: classID, methodID: methodID, connection: true}
+}
+
+// queueError converts a queue error to a channel exception
+func queueError(err error, classID, methodID uint16) error {
+	switch errors.Cause(err) {
+	case headers.ErrTopicDoesNotExist:
+		return channelError(replyNotFound, "no queue", classID, methodID)
+	case headers.ErrInvalidTopic:
+		return channelError(replyPreconditionFailed, "invalid queue name", classID, methodID)
+	case headers.ErrInsufficientStorage:
+		return channelError(replyResourceError, err.Error(), classID, methodID)
+	case headers.ErrInvalidMessage:
+		return channelError(replyPreconditionFailed, err.Error(), classID, methodID)
+	}
+	return channelError(replyInternalError, err.Error(), classID, methodID)
+}
+
+type consumer struct {
+	tag    string
+	queue  string
+	noAck  bool
+	offset int64
+}
+
+type publishing struct {
+	exchange  string
+	key       string
+	hasHeader bool
+	size      uint64
+	body      []byte
+}
+
+type channel struct {
+	id          uint16
+	closing     bool
+	confirm     bool
+	publishSeq  uint64
+	publish     *publishing
+	prefetch    int
+	deliveryTag uint64
+	unacked     []uint64
+	lastQueue   string
+	consumers   map[string]*consumer
+}
+
+// conn is the state of a single client connection
+type conn struct {
+	l  *Listener
+	nc net.Conn
+	r  *bufio.Reader
+
+	wmux      sync.Mutex
+	lastWrite time.Time
+
+	frameMax  uint32
+	heartbeat time.Duration
+
+	mux      sync.Mutex
+	channels map[uint16]*channel
+	tags     int
+}
+
+func newConn(l *Listener, nc net.Conn) *conn {
+	return &conn{
+		l:        l,
+		nc:       nc,
+		r:        bufio.NewReader(nc),
+		frameMax: frameMaxDefault,
+		channels: make(map[uint16]*channel),
+	}
+}
+
+func (c *conn) write(b []byte) error {
+	c.wmux.Lock()
+	defer c.wmux.Unlock()
+	c.lastWrite = time.Now()
+	_, err := c.nc.Write(b)
+	return err
+}
+
+func (c *conn) sendMethod(channel, classID, methodID uint16, args []byte) error {
+	payload := appendShort(appendShort(make([]byte, 0, 4+len(args)), classID), methodID)
+	return c.write(appendFrame(nil, frameMethod, channel, append(payload, args...)))
+}
+
+func closeArgs(e *amqpError) []byte {
+	args := appendShortstr(appendShort(nil, e.code), e.text)
+	return appendShort(appendShort(args, e.classID), e.methodID)
+}
+
+// serve handles the connection until the client disconnects or a connection exception occurs
+func (c *conn) serve() error {
+	ctx := context.Background()
+	_ = c.nc.SetDeadline(time.Now().Add(handshakeTimeout))
+	header := make([]byte, len(protocolHeader))
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header, protocolHeader) {
+		_, _ = c.nc.Write(protocolHeader)
+		return errors.New("unsupported protocol header")
+	}
+	if err := c.handshake(); err != nil {
+		return err
+	}
+	_ = c.nc.SetDeadline(time.Time{})
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.poll(ctx, done)
+	}()
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	for {
+		if c.heartbeat > 0 {
+			_ = c.nc.SetReadDeadline(time.Now().Add(2 * c.heartbeat))
+		}
+		f, err := readFrame(c.r, c.frameMax)
+		if err == errFrameTooLarge || err == errMalformedFrame {
+			err = connectionError(replyFrameError, err.Error(), 0, 0)
+		} else if err != nil {
+			return err
+		} else {
+			err = c.handleFrame(ctx, f)
+		}
+		if err == errClientClosed {
+			return nil
+		}
+		if e, ok := err.(*amqpError); ok {
+			if !e.connection {
+				if err = c.closeChannel(f.channel, e); err != nil {
+					return err
+				}
+				continue
+			}
+			_ = c.sendMethod(0, classConnection, 50, closeArgs(e))
+			return e
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readMethod reads the next method frame on channel 0, skipping heartbeats
+func (c *conn) readMethod(classID, methodID uint16) (*decoder, error) {
+	for {
+		f, err := readFrame(c.r, c.frameMax)
+		if err != nil {
+			return nil, err
+		}
+		if f.kind == frameHeartbeat {
+			continue
+		}
+		d := &decoder{b: f.payload}
+		if f.kind != frameMethod || f.channel != 0 || d.short() != classID || d.short() != methodID {
+			return nil, errors.Errorf("expected method %d.%d", classID, methodID)
+		}
+		return d, nil
+	}
+}
+
+// handshake negotiates the connection. Any credentials are accepted
+func (c *conn) handshake() error {
+	args := append([]byte{0, 9}, appendTable(nil, map[string]interface{}{
+		"product": "haraqa",
+		"capabilities": map[string]interface{}{
+			"publisher_confirms":         true,
+			"basic.nack":                 true,
+			"exchange_exchange_bindings": false,
+			"consumer_cancel_notify":     false,
+		},
+	})...)
+	args = appendLongstr(appendLongstr(args, []byte("PLAIN AMQPLAIN")), []byte("en_US"))
+	if err := c.sendMethod(0, classConnection, 10, args); err != nil {
+		return err
+	}
+	d, err := c.readMethod(classConnection, 11) // start-ok
+	if err != nil {
+		return err
+	}
+
+	heartbeat := uint16(c.l.heartbeat / time.Second)
+	args = appendShort(appendLong(appendShort(nil, channelMax), frameMaxDefault), heartbeat)
+	if err = c.sendMethod(0, classConnection, 30, args); err != nil {
+		return err
+	}
+	if d, err = c.readMethod(classConnection, 31); err != nil { // tune-ok
+		return err
+	}
+	_ = d.short() // channel max
+	frameMax := d.long()
+	clientHeartbeat := d.short()
+	if d.err != nil {
+		return d.err
+	}
+	if frameMax != 0 && frameMax < c.frameMax {
+		c.frameMax = frameMax
+	}
+	if c.frameMax < frameMin {
+		return errors.New("frame max too small")
+	}
+	c.heartbeat = time.Duration(clientHeartbeat) * time.Second
+
+	if _, err = c.readMethod(classConnection, 40); err != nil { // open
+		return err
+	}
+	return c.sendMethod(0, classConnection, 41, appendShortstr(nil, ""))
+}
+
+func (c *conn) handleFrame(ctx context.Context, f frame) error {
+	switch f.kind {
+	case frameHeartbeat:
+		return nil
+	case frameMethod:
+		d := &decoder{b: f.payload}
+		classID, methodID := d.short(), d.short()
+		if d.err != nil {
+			return connectionError(replyFrameError, "short method frame", 0, 0)
+		}
+		if f.channel == 0 {
+			if classID == classConnection && (methodID == 50 || methodID == 51) {
+				if methodID == 50 {
+					_ = c.sendMethod(0, classConnection, 51, nil)
+				}
+				return errClientClosed
+			}
+			return connectionError(replyCommandInvalid, "unexpected method on channel 0", classID, methodID)
+		}
+		return c.handleMethod(ctx, f.channel, classID, methodID, d)
+	case frameHeader, frameBody:
+		return c.handleContent(ctx, f)
+	}
+	return connectionError(replyFrameError, "unknown frame type", 0, 0)
+}
+
+// closeChannel sends a channel close for the exception and discards frames until the client confirms
+func (c *conn) closeChannel(id uint16, e *amqpError) error {
+	c.mux.Lock()
+	if ch, ok := c.channels[id]; ok {
+		ch.closing = true
+		ch.publish = nil
+	}
+	c.mux.Unlock()
+	return c.sendMethod(id, classChannel, 40, closeArgs(e))
+}
+
+func (c *conn) handleMethod(ctx context.Context, id, classID, methodID uint16, d *decoder) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	ch, ok := c.channels[id]
+	if classID == classChannel && methodID == 10 {
+		if ok || id > channelMax {
+			return connectionError(replyChannelError, "channel already open", classID, methodID)
+		}
+		c.channels[id] = &channel{id: id, consumers: make(map[string]*consumer)}
+		return c.sendMethod(id, classChannel, 11, appendLongstr(nil, nil))
+	}
+	if !ok {
+		return connectionError(replyChannelError, "channel not open", classID, methodID)
+	}
+	if ch.closing {
+		if classID == classChannel && (methodID == 40 || methodID == 41) {
+			delete(c.channels, id)
+			if methodID == 40 {
+				return c.sendMethod(id, classChannel, 41, nil)
+			}
+		}
+		return nil
+	}
+	if ch.publish != nil {
+		return connectionError(replyUnexpectedFrame, "expected content frame", classID, methodID)
+	}
+
+	err := c.dispatch(ctx, ch, classID, methodID, d)
+	if err == nil && d.err != nil {
+		return connectionError(replyFrameError, "malformed method arguments", classID, methodID)
+	}
+	return err
+}
+
+func (c *conn) dispatch(ctx context.Context, ch *channel, classID, methodID uint16, d *decoder) error {
+	switch classID {
+	case classChannel:
+		switch methodID {
+		case 20: // flow
+			return c.sendMethod(ch.id, classChannel, 21, []byte{d.octet() & 1})
+		case 40: // close
+			delete(c.channels, ch.id)
+			return c.sendMethod(ch.id, classChannel, 41, nil)
+		}
+	case classExchange:
+		switch methodID {
+		case 10:
+			return c.exchangeDeclare(ch, d)
+		case 20:
+			return c.exchangeDelete(ch, d)
+		}
+	case classQueue:
+		switch methodID {
+		case 10:
+			return c.queueDeclare(ctx, ch, d)
+		case 20:
+			return c.queueBind(ctx, ch, d)
+		case 40:
+			return c.queueDelete(ctx, ch, d)
+		case 50:
+			return c.queueUnbind(ch, d)
+		}
+	case classBasic:
+		switch methodID {
+		case 10:
+			return c.basicQos(ch, d)
+		case 20:
+			return c.basicConsume(ctx, ch, d)
+		case 30:
+			return c.basicCancel(ch, d)
+		case 40:
+			return c.basicPublish(ch, d)
+		case 80:
+			tag := d.longlong()
+			return c.ack(ch, tag, d.octet()&1 != 0, classID, methodID)
+		case 90:
+			tag := d.longlong()
+			_ = d.octet() // requeue, rejected messages are never redelivered
+			return c.ack(ch, tag, false, classID, methodID)
+		case 120:
+			tag := d.longlong()
+			return c.ack(ch, tag, d.octet()&1 != 0, classID, methodID)
+		}
+	case classConfirm:
+		if methodID == 10 {
+			ch.confirm = true
+			if d.octet()&1 != 0 {
+				return nil
+			}
+			return c.sendMethod(ch.id, classConfirm, 11, nil)
+		}
+	}
+	return connectionError(replyNotImplemented, "method not implemented", classID, methodID)
+}
+
+func (c *conn) exchangeDeclare(ch *channel, d *decoder) error {
+	_ = d.short()
+	name := d.shortstr()
+	kind := d.shortstr()
+	bits := d.octet()
+	_ = d.table()
+	if d.err != nil {
+		return nil
+	}
+	if bits&1 != 0 { // passive
+		if !c.l.router.exists(name) {
+			return channelError(replyNotFound, "no exchange '"+name+"'", classExchange, 10)
+		}
+	} else {
+		if name == "" || (strings.HasPrefix(name, "amq.") && !c.l.router.exists(name)) {
+			return channelError(replyAccessRefused, "exchange name '"+name+"' is reserved", classExchange, 10)
+		}
+		if kind != exchangeDirect && kind != exchangeFanout && kind != exchangeTopic {
+			return connectionError(replyCommandInvalid, "unsupported exchange type '"+kind+"'", classExchange, 10)
+		}
+		if !c.l.router.declare(name, kind) {
+			return channelError(replyPreconditionFailed, "exchange '"+name+"' exists with a different type", classExchange, 10)
+		}
+	}
+	if bits&16 != 0 { // no wait
+		return nil
+	}
+	return c.sendMethod(ch.id, classExchange, 11, nil)
+}
+
+func (c *conn) exchangeDelete(ch *channel, d *decoder) error {
+	_ = d.short()
+	name := d.shortstr()
+	bits := d.octet()
+	if d.err != nil {
+		return nil
+	}
+	if name == "" || strings.HasPrefix(name, "amq.") {
+		return channelError(replyAccessRefused, "exchange name '"+name+"' is reserved", classExchange, 20)
+	}
+	c.l.router.delete(name)
+	if bits&2 != 0 { // no wait
+		return nil
+	}
+	return c.sendMethod(ch.id, classExchange, 21, nil)
+}
+
+func (c *conn) queueDeclare(ctx context.Context, ch *channel, d *decoder) error {
+	_ = d.short()
+	name := d.shortstr()
+	bits := d.octet()
+	_ = d.table()
+	if d.err != nil {
+		return nil
+	}
+	if name == "" {
+		var id [8]byte
+		_, _ = rand.Read(id[:])
+		name = "amq.gen-" + hex.EncodeToString(id[:])
+	} else if strings.HasPrefix(name, "amq.") {
+		return channelError(replyAccessRefused, "queue name '"+name+"' is reserved", classQueue, 10)
+	}
+	if bits&1 == 0 { // not passive
+		err := c.l.q.CreateTopic(ctx, name)
+		if err != nil && errors.Cause(err) != headers.ErrTopicAlreadyExists {
+			return queueError(err, classQueue, 10)
+		}
+	}
+	info, err := c.l.q.InspectTopic(ctx, name)
+	if err != nil {
+		return queueError(err, classQueue, 10)
+	}
+	ch.lastQueue = name
+	if bits&16 != 0 { // no wait
+		return nil
+	}
+	args := appendShortstr(nil, name)
+	args = appendLong(args, uint32(info.MaxOffset+1-info.MinOffset))
+	args = appendLong(args, 0)
+	return c.sendMethod(ch.id, classQueue, 11, args)
+}
+
+func (c *conn) queueBind(ctx context.Context, ch *channel, d *decoder) error {
+	_ = d.short()
+	queue := d.shortstr()
+	name := d.shortstr()
+	key := d.shortstr()
+	bits := d.octet()
+	_ = d.table()
+	if d.err != nil {
+		return nil
+	}
+	if queue == "" {
+		queue = ch.lastQueue
+	}
+	if _, err := c.l.q.InspectTopic(ctx, queue); err != nil {
+		return queueError(err, classQueue, 20)
+	}
+	if name == "" {
+		return channelError(replyAccessRefused, "cannot bind to the default exchange", classQueue, 20)
+	}
+	if !c.l.router.bind(name, queue, key) {
+		return channelError(replyNotFound, "no exchange '"+name+"'", classQueue, 20)
+	}
+	if bits&1 != 0 { // no wait
+		return nil
+	}
+	return c.sendMethod(ch.id, classQueue, 21, nil)
+}
+
+func (c *conn) queueUnbind(ch *channel, d *decoder) error {
+	_ = d.short()
+	queue := d.shortstr()
+	name := d.shortstr()
+	key := d.shortstr()
+	_ = d.table()
+	if d.err != nil {
+		return nil
+	}
+	if queue == "" {
+		queue = ch.lastQueue
+	}
+	c.l.router.unbind(name, queue, key)
+	return c.sendMethod(ch.id, classQueue, 51, nil)
+}
+
+// queueDelete removes the bindings of the queue. The haraqa topic and its messages are kept
+func (c *conn) queueDelete(ctx context.Context, ch *channel, d *decoder) error {
+	_ = d.short()
+	queue := d.shortstr()
+	bits := d.octet()
+	if d.err != nil {
+		return nil
+	}
+	if queue == "" {
+		queue = ch.lastQueue
+	}
+	info, err := c.l.q.InspectTopic(ctx, queue)
+	if err != nil {
+		return queueError(err, classQueue, 40)
+	}
+	c.l.router.unbindQueue(queue)
+	if bits&4 != 0 { // no wait
+		return nil
+	}
+	return c.sendMethod(ch.id, classQueue, 41, appendLong(nil, uint32(info.MaxOffset+1-info.MinOffset)))
+}
+
+func (c *conn) basicQos(ch *channel, d *decoder) error {
+	_ = d.long() // prefetch size
+	ch.prefetch = int(d.short())
+	_ = d.octet() // global
+	return c.sendMethod(ch.id, classBasic, 11, nil)
+}
+
+func (c *conn) basicConsume(ctx context.Context, ch *channel, d *decoder) error {
+	_ = d.short()
+	queue := d.shortstr()
+	tag := d.shortstr()
+	bits := d.octet()
+	args := d.table()
+	if d.err != nil {
+		return nil
+	}
+	if queue == "" {
+		queue = ch.lastQueue
+	}
+	if tag == "" {
+		c.tags++
+		tag = "amq.ctag-" + strconv.Itoa(c.tags)
+	}
+	if _, ok := ch.consumers[tag]; ok {
+		return connectionError(replyNotAllowed, "consumer tag '"+tag+"' already in use", classBasic, 20)
+	}
+	info, err := c.l.q.InspectTopic(ctx, queue)
+	if err != nil {
+		return queueError(err, classBasic, 20)
+	}
+	offset, ok := startOffset(args["x-stream-offset"], info)
+	if !ok {
+		return channelError(replyPreconditionFailed, "invalid x-stream-offset", classBasic, 20)
+	}
+	ch.consumers[tag] = &consumer{tag: tag, queue: queue, noAck: bits&2 != 0, offset: offset}
+	if bits&8 != 0 { // no wait
+		return nil
+	}
+	return c.sendMethod(ch.id, classBasic, 21, appendShortstr(nil, tag))
+}
+
+// startOffset returns the offset a consumer starts from given the x-stream-offset argument
+func startOffset(arg interface{}, info *headers.TopicInfo) (int64, bool) {
+	var offset int64
+	switch v := arg.(type) {
+	case nil:
+		return info.MaxOffset + 1, true
+	case string:
+		switch v {
+		case "first":
+			return info.MinOffset, true
+		case "last":
+			if info.MaxOffset < info.MinOffset {
+				return info.MinOffset, true
+			}
+			return info.MaxOffset, true
+		case "next":
+			return info.MaxOffset + 1, true
+		}
+		return 0, false
+	case int8:
+		offset = int64(v)
+	case uint8:
+		offset = int64(v)
+	case int16:
+		offset = int64(v)
+	case uint16:
+		offset = int64(v)
+	case int32:
+		offset = int64(v)
+	case uint32:
+		offset = int64(v)
+	case int64:
+		offset = v
+	default:
+		return 0, false
+	}
+	return offset, offset >= 0
+}
+
+func (c *conn) basicCancel(ch *channel, d *decoder) error {
+	tag := d.shortstr()
+	bits := d.octet()
+	if d.err != nil {
+		return nil
+	}
+	delete(ch.consumers, tag)
+	if bits&1 != 0 { // no wait
+		return nil
+	}
+	return c.sendMethod(ch.id, classBasic, 31, appendShortstr(nil, tag))
+}
+
+func (c *conn) basicPublish(ch *channel, d *decoder) error {
+	_ = d.short()
+	name := d.shortstr()
+	key := d.shortstr()
+	_ = d.octet() // mandatory and immediate, unroutable messages are dropped
+	if d.err != nil {
+		return nil
+	}
+	if !c.l.router.exists(name) {
+		return channelError(replyNotFound, "no exchange '"+name+"'", classBasic, 40)
+	}
+	ch.publish = &publishing{exchange: name, key: key}
+	return nil
+}
+
+// ack removes acknowledged deliveries, allowing more messages to be delivered within the prefetch count
+func (c *conn) ack(ch *channel, tag uint64, multiple bool, classID, methodID uint16) error {
+	unacked := ch.unacked[:0]
+	found := false
+	for _, t := range ch.unacked {
+		switch {
+		case t == tag, multiple && (tag == 0 || t < tag):
+			found = true
+		default:
+			unacked = append(unacked, t)
+		}
+	}
+	ch.unacked = unacked
+	if !found && !(multiple && tag == 0) {
+		return channelError(replyPreconditionFailed, "unknown delivery tag "+strconv.FormatUint(tag, 10), classID, methodID)
+	}
+	return nil
+}
+
+// handleContent collects the content header and body frames of a publish
+func (c *conn) handleContent(ctx context.Context, f frame) error {
+	c.mux.Lock()
+	ch, ok := c.channels[f.channel]
+	if !ok {
+		c.mux.Unlock()
+		return connectionError(replyChannelError, "channel not open", 0, 0)
+	}
+	if ch.closing {
+		c.mux.Unlock()
+		return nil
+	}
+	p := ch.publish
+	if p == nil || p.hasHeader == (f.kind == frameHeader) {
+		c.mux.Unlock()
+		return connectionError(replyUnexpectedFrame, "unexpected content frame", 0, 0)
+	}
+	if f.kind == frameHeader {
+		d := &decoder{b: f.payload}
+		_, _ = d.short(), d.short()
+		p.size = d.longlong()
+		p.hasHeader = true
+		if d.err != nil {
+			c.mux.Unlock()
+			return connectionError(replyFrameError, "malformed content header", 0, 0)
+		}
+		if p.size > maxBodySize {
+			c.mux.Unlock()
+			return channelError(replyPreconditionFailed, "message too large", classBasic, 40)
+		}
+	} else {
+		p.body = append(p.body, f.payload...)
+		if uint64(len(p.body)) > p.size {
+			c.mux.Unlock()
+			return connectionError(replyFrameError, "content body larger than header size", 0, 0)
+		}
+	}
+	if !p.hasHeader || uint64(len(p.body)) < p.size {
+		c.mux.Unlock()
+		return nil
+	}
+	ch.publish = nil
+	confirm := ch.confirm
+	if confirm {
+		ch.publishSeq++
+	}
+	seq := ch.publishSeq
+	c.mux.Unlock()
+
+	err := c.publish(ctx, p)
+	if err != nil {
+		c.l.logger.Warn("unable to publish amqp message", "exchange", p.exchange, "key", p.key, "err", err)
+	}
+	if !confirm {
+		return nil
+	}
+	if err != nil {
+		return c.sendMethod(f.channel, classBasic, 120, append(appendLonglong(nil, seq), 0))
+	}
+	return c.sendMethod(f.channel, classBasic, 80, append(appendLonglong(nil, seq), 0))
+}
+
+// publish writes the message to each routed queue, messages routed to queues which do not exist are dropped
+func (c *conn) publish(ctx context.Context, p *publishing) error {
+	if p.body == nil {
+		p.body = []byte{}
+	}
+	for _, queue := range c.l.router.route(p.exchange, p.key) {
+		err := c.l.q.ProduceMsgs(ctx, queue, p.body)
+		if err != nil && errors.Cause(err) != headers.ErrTopicDoesNotExist && errors.Cause(err) != headers.ErrInvalidTopic {
+			return err
+		}
+	}
+	return nil
+}
+
+// poll periodically delivers new messages to consumers and sends heartbeats until done is closed
+func (c *conn) poll(ctx context.Context, done chan struct{}) {
+	ticker := time.NewTicker(c.l.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-c.l.done:
+			return
+		case <-ticker.C:
+			err := c.deliver(ctx)
+			if err == nil {
+				err = c.sendHeartbeat()
+			}
+			if err != nil {
+				// closing the connection stops the read loop
+				_ = c.nc.Close()
+				return
+			}
+		}
+	}
+}
+
+func (c *conn) sendHeartbeat() error {
+	c.wmux.Lock()
+	idle := time.Since(c.lastWrite)
+	c.wmux.Unlock()
+	if c.heartbeat <= 0 || idle < c.heartbeat/2 {
+		return nil
+	}
+	return c.write(appendFrame(nil, frameHeartbeat, 0, nil))
+}
+
+// deliver sends any new messages to each consumer, up to the channel's prefetch count
+func (c *conn) deliver(ctx context.Context) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for _, ch := range c.channels {
+		if ch.closing {
+			continue
+		}
+		for _, cons := range ch.consumers {
+			limit := int64(consumeLimit)
+			if !cons.noAck && ch.prefetch > 0 {
+				limit = int64(ch.prefetch - len(ch.unacked))
+				if limit <= 0 {
+					continue
+				}
+			}
+			info, err := c.l.q.InspectTopic(ctx, cons.queue)
+			if err != nil {
+				if errors.Cause(err) != headers.ErrTopicDoesNotExist {
+					c.l.logger.Error("unable to inspect topic", "topic", cons.queue, "err", err)
+				}
+				continue
+			}
+			if cons.offset < info.MinOffset {
+				cons.offset = info.MinOffset
+			}
+			if cons.offset > info.MaxOffset {
+				continue
+			}
+			msgs, err := c.l.q.ConsumeMsgs(ctx, cons.queue, cons.offset, limit)
+			if err != nil {
+				c.l.logger.Error("unable to consume", "topic", cons.queue, "offset", cons.offset, "err", err)
+				continue
+			}
+			for _, msg := range msgs {
+				ch.deliveryTag++
+				if !cons.noAck {
+					ch.unacked = append(ch.unacked, ch.deliveryTag)
+				}
+				if err = c.write(c.delivery(ch.id, cons, ch.deliveryTag, msg)); err != nil {
+					return err
+				}
+				cons.offset++
+			}
+		}
+	}
+	return nil
+}
+
+// delivery returns the basic.deliver method, content header and body frames of a message
+func (c *conn) delivery(channel uint16, cons *consumer, tag uint64, msg []byte) []byte {
+	method := appendShort(appendShort(nil, classBasic), 60)
+	method = appendLonglong(appendShortstr(method, cons.tag), tag)
+	method = appendShortstr(appendShortstr(append(method, 0), ""), cons.queue)
+	b := appendFrame(make([]byte, 0, len(msg)+128), frameMethod, channel, method)
+
+	header := appendShort(appendShort(nil, classBasic), 0)
+	header = appendShort(appendLonglong(header, uint64(len(msg))), 0x2000)
+	header = appendTable(header, map[string]interface{}{"x-stream-offset": cons.offset})
+	b = appendFrame(b, frameHeader, channel, header)
+
+	chunk := int(c.frameMax) - 8
+	for len(msg) > 0 {
+		n := len(msg)
+		if n > chunk {
+			n = chunk
+		}
+		b = appendFrame(b, frameBody, channel, msg[:n])
+		msg = msg[n:]
+	}
+	return b
+}
@@ -0,0 +1,247 @@
+package amqp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// frame types
+const (
+	frameMethod    byte = 1
+	frameHeader    byte = 2
+	frameBody      byte = 3
+	frameHeartbeat byte = 8
+	frameEnd       byte = 0xce
+)
+
+// frameMin is the smallest frame max size a client may negotiate
+const frameMin = 4096
+
+var (
+	errMalformedFrame = errors.New("amqp: malformed frame")
+	errFrameTooLarge  = errors.New("amqp: frame too large")
+)
+
+// protocolHeader is sent by clients when opening a connection
+var protocolHeader = []byte{'A', 'M', 'Q', 'P', 0, 0, 9, 1}
+
+type frame struct {
+	kind    byte
+	channel uint16
+	payload []byte
+}
+
+// readFrame reads the next frame, returning an error if its payload is larger than max
+func readFrame(r *bufio.Reader, max uint32) (frame, error) {
+	var header [7]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return frame{}, err
+	}
+	size := binary.BigEndian.Uint32(header[3:])
+	if size > max {
+		return frame{}, errFrameTooLarge
+	}
+	f := frame{kind: header[0], channel: binary.BigEndian.Uint16(header[1:]), payload: make([]byte, size+1)}
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return frame{}, err
+	}
+	if f.payload[size] != frameEnd {
+		return frame{}, errMalformedFrame
+	}
+	f.payload = f.payload[:size]
+	return f, nil
+}
+
+// appendFrame appends the framed payload to b
+func appendFrame(b []byte, kind byte, channel uint16, payload []byte) []byte {
+	b = append(b, kind, byte(channel>>8), byte(channel))
+	b = appendLong(b, uint32(len(payload)))
+	b = append(b, payload...)
+	return append(b, frameEnd)
+}
+
+// decoder reads amqp field types. The first error is recorded and all further reads return zero values
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = errMalformedFrame
+		return nil
+	}
+	b := d.b[:n:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) octet() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) short() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) long() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) longlong() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) shortstr() string {
+	return string(d.next(int(d.octet())))
+}
+
+func (d *decoder) longstr() []byte {
+	n := d.long()
+	if n > uint32(len(d.b)) {
+		d.err = errMalformedFrame
+		return nil
+	}
+	return d.next(int(n))
+}
+
+// decimal is an amqp decimal field value, value / 10^scale
+type decimal struct {
+	scale uint8
+	value int32
+}
+
+// table reads a field table
+func (d *decoder) table() map[string]interface{} {
+	t := &decoder{b: d.longstr()}
+	if d.err != nil {
+		return nil
+	}
+	table := make(map[string]interface{})
+	for len(t.b) > 0 && t.err == nil {
+		name := t.shortstr()
+		table[name] = t.value()
+	}
+	d.err = t.err
+	return table
+}
+
+// value reads a field value prefixed by its type
+func (d *decoder) value() interface{} {
+	switch d.octet() {
+	case 't':
+		return d.octet() != 0
+	case 'b':
+		return int8(d.octet())
+	case 'B':
+		return d.octet()
+	case 's':
+		return int16(d.short())
+	case 'u':
+		return d.short()
+	case 'I':
+		return int32(d.long())
+	case 'i':
+		return d.long()
+	case 'l':
+		return int64(d.longlong())
+	case 'f':
+		return math.Float32frombits(d.long())
+	case 'd':
+		return math.Float64frombits(d.longlong())
+	case 'D':
+		return decimal{scale: d.octet(), value: int32(d.long())}
+	case 'S':
+		return string(d.longstr())
+	case 'x':
+		return d.longstr()
+	case 'A':
+		a := &decoder{b: d.longstr()}
+		var values []interface{}
+		for len(a.b) > 0 && a.err == nil {
+			values = append(values, a.value())
+		}
+		if d.err == nil {
+			d.err = a.err
+		}
+		return values
+	case 'T':
+		return time.Unix(int64(d.longlong()), 0)
+	case 'F':
+		return d.table()
+	case 'V':
+		return nil
+	}
+	if d.err == nil {
+		d.err = errMalformedFrame
+	}
+	return nil
+}
+
+func appendShort(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendLong(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendLonglong(b []byte, v uint64) []byte {
+	return appendLong(appendLong(b, uint32(v>>32)), uint32(v))
+}
+
+func appendShortstr(b []byte, s string) []byte {
+	if len(s) > 255 {
+		s = s[:255]
+	}
+	return append(append(b, byte(len(s))), s...)
+}
+
+func appendLongstr(b []byte, s []byte) []byte {
+	return append(appendLong(b, uint32(len(s))), s...)
+}
+
+// appendTable appends a field table. Only the value types sent by the listener are supported
+func appendTable(b []byte, table map[string]interface{}) []byte {
+	var t []byte
+	for name, value := range table {
+		t = appendShortstr(t, name)
+		switch v := value.(type) {
+		case bool:
+			if v {
+				t = append(t, 't', 1)
+			} else {
+				t = append(t, 't', 0)
+			}
+		case int64:
+			t = appendLonglong(append(t, 'l'), uint64(v))
+		case string:
+			t = appendLongstr(append(t, 'S'), []byte(v))
+		case map[string]interface{}:
+			t = appendTable(append(t, 'F'), v)
+		default:
+			t = append(t, 'V')
+		}
+	}
+	return appendLongstr(b, t)
+}
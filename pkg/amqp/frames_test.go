@@ -0,0 +1,63 @@
+package amqp
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestFrames(t *testing.T) {
+	b := appendFrame(nil, frameMethod, 3, []byte("payload"))
+	b = appendFrame(b, frameHeartbeat, 0, nil)
+	r := bufio.NewReader(bytes.NewReader(b))
+	f, err := readFrame(r, frameMin)
+	if err != nil || f.kind != frameMethod || f.channel != 3 || string(f.payload) != "payload" {
+		t.Fatal(f, err)
+	}
+	if f, err = readFrame(r, frameMin); err != nil || f.kind != frameHeartbeat || len(f.payload) != 0 {
+		t.Fatal(f, err)
+	}
+
+	if _, err = readFrame(bufio.NewReader(bytes.NewReader(b)), 4); err != errFrameTooLarge {
+		t.Error(err)
+	}
+	b[len("payload")+7] = 0
+	if _, err = readFrame(bufio.NewReader(bytes.NewReader(b)), frameMin); err != errMalformedFrame {
+		t.Error(err)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	table := map[string]interface{}{
+		"bool":   true,
+		"int":    int64(-5),
+		"string": "value",
+		"nested": map[string]interface{}{"false": false},
+	}
+	b := appendShortstr(appendLonglong(appendLong(appendShort([]byte{7}, 513), 70000), 1<<40), "short")
+	b = appendTable(appendLongstr(b, []byte("long")), table)
+
+	d := &decoder{b: b}
+	if d.octet() != 7 || d.short() != 513 || d.long() != 70000 || d.longlong() != 1<<40 ||
+		d.shortstr() != "short" || string(d.longstr()) != "long" {
+		t.Fatal(d.err)
+	}
+	if v := d.table(); !reflect.DeepEqual(v, table) || d.err != nil {
+		t.Fatal(v, d.err)
+	}
+	if d.short() != 0 || d.err != errMalformedFrame {
+		t.Fatal(d.err)
+	}
+
+	// field types only sent by clients
+	d = &decoder{b: append([]byte{'A'}, appendLongstr(nil, []byte{'b', 0xff, 'u', 0, 2, 'I', 0xff, 0xff, 0xff, 0xfe, 'V'})...)}
+	if v := d.value(); !reflect.DeepEqual(v, []interface{}{int8(-1), uint16(2), int32(-2), nil}) || d.err != nil {
+		t.Fatal(v, d.err)
+	}
+
+	d = &decoder{b: []byte{'?'}}
+	if d.value(); d.err != errMalformedFrame {
+		t.Fatal(d.err)
+	}
+}
@@ -0,0 +1,168 @@
+package amqp
+
+import (
+	"strings"
+	"sync"
+)
+
+// exchange types
+const (
+	exchangeDirect = "direct"
+	exchangeFanout = "fanout"
+	exchangeTopic  = "topic"
+)
+
+type binding struct {
+	queue string
+	key   string
+}
+
+type exchange struct {
+	kind     string
+	bindings []binding
+}
+
+// router holds the declared exchanges and their bindings. Exchanges are kept in memory and must be
+// redeclared by clients after a restart, the queues they route to are persisted as haraqa topics
+type router struct {
+	mux       sync.RWMutex
+	exchanges map[string]*exchange
+}
+
+func newRouter() *router {
+	return &router{
+		exchanges: map[string]*exchange{
+			"amq.direct": {kind: exchangeDirect},
+			"amq.fanout": {kind: exchangeFanout},
+			"amq.topic":  {kind: exchangeTopic},
+		},
+	}
+}
+
+// declare creates the exchange if it does not exist. It returns false if the exchange exists with a different type
+func (r *router) declare(name, kind string) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if e, ok := r.exchanges[name]; ok {
+		return e.kind == kind
+	}
+	r.exchanges[name] = &exchange{kind: kind}
+	return true
+}
+
+func (r *router) exists(name string) bool {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	_, ok := r.exchanges[name]
+	return ok || name == ""
+}
+
+func (r *router) delete(name string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	delete(r.exchanges, name)
+}
+
+// bind routes messages matching the key from the exchange to the queue. It returns false if the exchange does not exist
+func (r *router) bind(name, queue, key string) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	e, ok := r.exchanges[name]
+	if !ok {
+		return false
+	}
+	for _, b := range e.bindings {
+		if b.queue == queue && b.key == key {
+			return true
+		}
+	}
+	e.bindings = append(e.bindings, binding{queue: queue, key: key})
+	return true
+}
+
+func (r *router) unbind(name, queue, key string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	e, ok := r.exchanges[name]
+	if !ok {
+		return
+	}
+	for i, b := range e.bindings {
+		if b.queue == queue && b.key == key {
+			e.bindings = append(e.bindings[:i], e.bindings[i+1:]...)
+			return
+		}
+	}
+}
+
+// unbindQueue removes all bindings to the queue
+func (r *router) unbindQueue(queue string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for _, e := range r.exchanges {
+		bindings := e.bindings[:0]
+		for _, b := range e.bindings {
+			if b.queue != queue {
+				bindings = append(bindings, b)
+			}
+		}
+		e.bindings = bindings
+	}
+}
+
+// route returns the queues a message published to the exchange with the routing key is delivered to.
+// The default exchange routes directly to the queue named by the routing key
+func (r *router) route(name, key string) []string {
+	if name == "" {
+		return []string{key}
+	}
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	e, ok := r.exchanges[name]
+	if !ok {
+		return nil
+	}
+	var queues []string
+	seen := make(map[string]struct{})
+	for _, b := range e.bindings {
+		if _, ok := seen[b.queue]; ok {
+			continue
+		}
+		if e.kind == exchangeFanout || (e.kind == exchangeDirect && b.key == key) ||
+			(e.kind == exchangeTopic && matchRoutingKey(b.key, key)) {
+			seen[b.queue] = struct{}{}
+			queues = append(queues, b.queue)
+		}
+	}
+	return queues
+}
+
+// matchRoutingKey returns true if the routing key matches the topic exchange binding pattern.
+// Words are separated by dots, * matches exactly one word and # matches zero or more words
+func matchRoutingKey(pattern, key string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchWords(pattern, words []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case "#":
+			for i := 0; i <= len(words); i++ {
+				if matchWords(pattern[1:], words[i:]) {
+					return true
+				}
+			}
+			return false
+		case "*":
+			if len(words) == 0 {
+				return false
+			}
+		default:
+			if len(words) == 0 || words[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, words = pattern[1:], words[1:]
+	}
+	return len(words) == 0
+}
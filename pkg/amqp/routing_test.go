@@ -0,0 +1,79 @@
+package amqp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchRoutingKey(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, key string
+		match        bool
+	}{
+		{"a.b", "a.b", true},
+		{"a.b", "a.c", false},
+		{"a.*", "a.b", true},
+		{"a.*", "a.b.c", false},
+		{"a.#", "a", true},
+		{"a.#", "a.b.c", true},
+		{"#.c", "a.b.c", true},
+		{"#", "", true},
+		{"*.b.#", "a.b", true},
+		{"*.b.#", "b", false},
+	} {
+		if matchRoutingKey(tt.pattern, tt.key) != tt.match {
+			t.Error(tt.pattern, tt.key)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	r := newRouter()
+	if !r.exists("") || !r.exists("amq.topic") || r.exists("logs") {
+		t.Fatal("unexpected exchanges")
+	}
+	if !r.declare("logs", exchangeFanout) || !r.declare("logs", exchangeFanout) || r.declare("logs", exchangeDirect) {
+		t.Fatal("unexpected declare result")
+	}
+	if r.bind("missing", "q", "") {
+		t.Fatal("expected missing exchange")
+	}
+
+	r.bind("logs", "q1", "")
+	r.bind("logs", "q2", "ignored")
+	r.bind("amq.direct", "q1", "info")
+	r.bind("amq.direct", "q2", "info")
+	r.bind("amq.direct", "q2", "error")
+	r.bind("amq.topic", "q1", "orders.*")
+	r.bind("amq.topic", "q1", "#")
+
+	for _, tt := range []struct {
+		exchange, key string
+		queues        []string
+	}{
+		{"", "q3", []string{"q3"}},
+		{"logs", "any", []string{"q1", "q2"}},
+		{"amq.direct", "info", []string{"q1", "q2"}},
+		{"amq.direct", "error", []string{"q2"}},
+		{"amq.direct", "debug", nil},
+		{"amq.topic", "orders.new", []string{"q1"}},
+		{"missing", "", nil},
+	} {
+		if queues := r.route(tt.exchange, tt.key); !reflect.DeepEqual(queues, tt.queues) {
+			t.Error(tt.exchange, tt.key, queues)
+		}
+	}
+
+	r.unbind("amq.direct", "q2", "error")
+	r.unbindQueue("q1")
+	if queues := r.route("amq.direct", "info"); !reflect.DeepEqual(queues, []string{"q2"}) {
+		t.Error(queues)
+	}
+	if queues := r.route("amq.direct", "error"); queues != nil {
+		t.Error(queues)
+	}
+	r.delete("logs")
+	if r.exists("logs") {
+		t.Error("expected deleted exchange")
+	}
+}
@@ -0,0 +1,37 @@
+package connect
+
+import (
+	"context"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/haraqa"
+	"github.com/pkg/errors"
+)
+
+// NewClientQueue returns a Queue which sends requests to a haraqa server using the client
+func NewClientQueue(c *haraqa.Client) Queue {
+	return &clientQueue{c: c}
+}
+
+type clientQueue struct {
+	c *haraqa.Client
+}
+
+func (q *clientQueue) CreateTopic(ctx context.Context, topic string) error {
+	return q.c.WithContext(ctx).CreateTopic(topic)
+}
+
+func (q *clientQueue) ProduceMsgs(ctx context.Context, topic string, msgs ...[]byte) error {
+	return q.c.WithContext(ctx).ProduceMsgs(topic, msgs...)
+}
+
+func (q *clientQueue) ConsumeMsgs(ctx context.Context, topic string, id, limit int64) ([][]byte, error) {
+	if id < 0 {
+		return nil, headers.ErrInvalidMessageID
+	}
+	msgs, err := q.c.WithContext(ctx).ConsumeMsgs(topic, uint64(id), int(limit))
+	if errors.Cause(err) == headers.ErrNoContent {
+		return nil, nil
+	}
+	return msgs, err
+}
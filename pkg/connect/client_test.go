@@ -0,0 +1,41 @@
+package connect
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/haraqa"
+	"github.com/pkg/errors"
+)
+
+func TestClientQueue(t *testing.T) {
+	ts := httptest.NewServer(newTestServer(t))
+	defer ts.Close()
+	c, err := haraqa.NewClient(haraqa.WithURL(ts.URL), haraqa.WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := NewClientQueue(c)
+	ctx := context.Background()
+
+	if err = q.ProduceMsgs(ctx, "t", []byte("a")); errors.Cause(err) != headers.ErrTopicDoesNotExist {
+		t.Fatal(err)
+	}
+	if err = q.CreateTopic(ctx, "t"); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, err := q.ConsumeMsgs(ctx, "t", 0, 10); err != nil || len(msgs) != 0 {
+		t.Fatal(msgs, err)
+	}
+	if err = q.ProduceMsgs(ctx, "t", []byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, err := q.ConsumeMsgs(ctx, "t", 1, 10); err != nil || len(msgs) != 1 || string(msgs[0]) != "b" {
+		t.Fatal(msgs, err)
+	}
+	if _, err = q.ConsumeMsgs(ctx, "t", -1, 10); err != headers.ErrInvalidMessageID {
+		t.Fatal(err)
+	}
+}
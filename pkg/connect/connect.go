@@ -0,0 +1,85 @@
+// Package connect runs connectors which copy messages between haraqa topics and external systems.
+//
+// A Sink writes messages read from topics and a Source reads messages to produce to topics. The Runner
+// polls the topics of each sink, retries failed writes with exponential backoff and stores the offset
+// reached after each write, so sinks resume where they left off. Sinks which store offsets alongside their
+// own data, such as in the same database transaction, implement OffsetSink for exactly once delivery.
+// Sources are read from the position stored after their last produced messages, giving at least once
+// delivery.
+//
+// Connectors can be added to a runner directly, or registered by type with RegisterSink and RegisterSource
+// and created from json configuration with AddConnector, as done by cmd/connect.
+package connect
+
+import (
+	"context"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/server"
+)
+
+// Queue is the subset of the haraqa api used by the runner, it is implemented by *server.Server and by
+// the value returned from NewClientQueue
+type Queue interface {
+	CreateTopic(ctx context.Context, topic string) error
+	ProduceMsgs(ctx context.Context, topic string, msgs ...[]byte) error
+	ConsumeMsgs(ctx context.Context, topic string, id, limit int64) ([][]byte, error)
+}
+
+var _ Queue = &server.Server{}
+
+// inspector is implemented by queues which report topic offsets, it is used to skip truncated messages
+type inspector interface {
+	InspectTopic(ctx context.Context, topic string) (*headers.TopicInfo, error)
+}
+
+// Batch is a set of consecutive messages from a topic, Offset is the offset of the first message
+type Batch struct {
+	Topic  string
+	Offset int64
+	Msgs   [][]byte
+}
+
+// Sink writes messages from topics to an external system. Batches of a topic are written in order and
+// without gaps, except where messages were truncated before being read. A failed batch is retried, so Write
+// must be safe to call again with messages it has already written
+type Sink interface {
+	Write(ctx context.Context, batch Batch) error
+	Close() error
+}
+
+// OffsetSink is a Sink which stores the offsets it has written with its data, the runner resumes from the
+// offset it returns instead of storing offsets itself
+type OffsetSink interface {
+	Sink
+	// Offset returns the next offset to write for the topic, or -1 if none is stored
+	Offset(ctx context.Context, topic string) (int64, error)
+}
+
+// Flusher is a Sink which buffers messages, Flush is called while the topic has no new messages
+type Flusher interface {
+	Flush(ctx context.Context, topic string) error
+}
+
+// Message is a message read by a Source and the topic to produce it to
+type Message struct {
+	Topic string
+	Value []byte
+}
+
+// Source reads messages from an external system
+type Source interface {
+	// Read returns the next messages after the position, which is empty when reading for the first time,
+	// and the position following them. It may return no messages if none are available
+	Read(ctx context.Context, position string) ([]Message, string, error)
+	Close() error
+}
+
+var _ server.Logger = noOpLogger{}
+
+type noOpLogger struct{}
+
+func (noOpLogger) Debug(string, ...interface{}) {}
+func (noOpLogger) Info(string, ...interface{})  {}
+func (noOpLogger) Warn(string, ...interface{})  {}
+func (noOpLogger) Error(string, ...interface{}) {}
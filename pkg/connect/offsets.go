@@ -0,0 +1,75 @@
+package connect
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// OffsetStore stores the offsets of sinks and positions of sources by key
+type OffsetStore interface {
+	Get(key string) (string, bool, error)
+	Set(key, value string) error
+}
+
+// NewMemoryOffsetStore returns a store which keeps offsets in memory, they are lost when the process exits
+func NewMemoryOffsetStore() OffsetStore {
+	return &fileOffsetStore{values: make(map[string]string)}
+}
+
+// NewFileOffsetStore returns a store which keeps offsets in a json file, the file is replaced atomically on each change
+func NewFileOffsetStore(path string) (OffsetStore, error) {
+	if path == "" {
+		return nil, errors.New("offset file cannot be empty")
+	}
+	s := &fileOffsetStore{path: path, values: make(map[string]string)}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read offset file")
+	}
+	if err = json.Unmarshal(b, &s.values); err != nil {
+		return nil, errors.Wrap(err, "unable to parse offset file")
+	}
+	return s, nil
+}
+
+type fileOffsetStore struct {
+	path   string
+	mux    sync.Mutex
+	values map[string]string
+}
+
+func (s *fileOffsetStore) Get(key string) (string, bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	v, ok := s.values[key]
+	return v, ok, nil
+}
+
+func (s *fileOffsetStore) Set(key, value string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.values[key] = value
+	if s.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(s.values, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "unable to write offset file")
+	}
+	if err = os.Rename(tmp, s.path); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrap(err, "unable to replace offset file")
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package connect
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileOffsetStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "connect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "offsets.json")
+
+	if _, err = NewFileOffsetStore(""); err == nil {
+		t.Error("expected empty path error")
+	}
+	store, err := NewFileOffsetStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := store.Get("k"); ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if err = store.Set("k", "v"); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewFileOffsetStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := store.Get("k"); v != "v" || !ok || err != nil {
+		t.Fatal(v, ok, err)
+	}
+
+	if err = ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewFileOffsetStore(path); err == nil {
+		t.Error("expected parse error")
+	}
+	if _, err = NewFileOffsetStore(dir); err == nil {
+		t.Error("expected read error")
+	}
+}
@@ -0,0 +1,103 @@
+package connect
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// SinkFactory creates a sink from its json configuration
+type SinkFactory func(config json.RawMessage) (Sink, error)
+
+// SourceFactory creates a source from its json configuration
+type SourceFactory func(config json.RawMessage) (Source, error)
+
+var (
+	registryMux sync.RWMutex
+	sinkTypes   = make(map[string]SinkFactory)
+	sourceTypes = make(map[string]SourceFactory)
+)
+
+// RegisterSink makes a sink type available to AddConnector. It panics if the type is already registered
+func RegisterSink(typ string, factory SinkFactory) {
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	if factory == nil {
+		panic("connect: nil sink factory for " + typ)
+	}
+	if _, ok := sinkTypes[typ]; ok {
+		panic("connect: sink type " + typ + " registered twice")
+	}
+	sinkTypes[typ] = factory
+}
+
+// RegisterSource makes a source type available to AddConnector. It panics if the type is already registered
+func RegisterSource(typ string, factory SourceFactory) {
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	if factory == nil {
+		panic("connect: nil source factory for " + typ)
+	}
+	if _, ok := sourceTypes[typ]; ok {
+		panic("connect: source type " + typ + " registered twice")
+	}
+	sourceTypes[typ] = factory
+}
+
+// Types returns the registered sink and source types
+func Types() (sinks []string, sources []string) {
+	registryMux.RLock()
+	defer registryMux.RUnlock()
+	for typ := range sinkTypes {
+		sinks = append(sinks, typ)
+	}
+	for typ := range sourceTypes {
+		sources = append(sources, typ)
+	}
+	sort.Strings(sinks)
+	sort.Strings(sources)
+	return sinks, sources
+}
+
+// ConnectorConfig is the json configuration of a connector. Topics are the topics read by a sink,
+// Config is passed to the factory of the registered type
+type ConnectorConfig struct {
+	Name   string          `json:"name"`
+	Type   string          `json:"type"`
+	Topics []string        `json:"topics,omitempty"`
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// AddConnector creates a connector of a registered type and adds it to the runner
+func (r *Runner) AddConnector(cfg ConnectorConfig) error {
+	registryMux.RLock()
+	sinkFactory, isSink := sinkTypes[cfg.Type]
+	sourceFactory, isSource := sourceTypes[cfg.Type]
+	registryMux.RUnlock()
+
+	switch {
+	case isSink:
+		sink, err := sinkFactory(cfg.Config)
+		if err != nil {
+			return errors.Wrapf(err, "unable to create %s sink %q", cfg.Type, cfg.Name)
+		}
+		if err = r.AddSink(cfg.Name, sink, cfg.Topics...); err != nil {
+			_ = sink.Close()
+			return err
+		}
+	case isSource:
+		source, err := sourceFactory(cfg.Config)
+		if err != nil {
+			return errors.Wrapf(err, "unable to create %s source %q", cfg.Type, cfg.Name)
+		}
+		if err = r.AddSource(cfg.Name, source); err != nil {
+			_ = source.Close()
+			return err
+		}
+	default:
+		return errors.Errorf("unknown connector type %q", cfg.Type)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package connect
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	RegisterSink("test-sink", func(config json.RawMessage) (Sink, error) {
+		if string(config) == "" {
+			return nil, errors.New("missing config")
+		}
+		return &recordSink{}, nil
+	})
+	RegisterSource("test-source", func(config json.RawMessage) (Source, error) {
+		return &sliceSource{}, nil
+	})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected duplicate type panic")
+			}
+		}()
+		RegisterSink("test-sink", func(json.RawMessage) (Sink, error) { return nil, nil })
+	}()
+	sinks, sources := Types()
+	if len(sinks) != 1 || sinks[0] != "test-sink" || len(sources) != 1 || sources[0] != "test-source" {
+		t.Fatal(sinks, sources)
+	}
+
+	r, err := NewRunner(newTestServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var cfgs []ConnectorConfig
+	err = json.Unmarshal([]byte(`[
+		{"name": "a", "type": "test-sink", "topics": ["t"], "config": {}},
+		{"name": "b", "type": "test-source"}
+	]`), &cfgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cfg := range cfgs {
+		if err = r.AddConnector(cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, cfg := range []ConnectorConfig{
+		{Name: "c", Type: "unknown"},
+		{Name: "c", Type: "test-sink", Topics: []string{"t"}},
+		{Name: "a", Type: "test-sink", Topics: []string{"t"}, Config: json.RawMessage("{}")},
+		{Name: "b", Type: "test-source"},
+	} {
+		if err = r.AddConnector(cfg); err == nil {
+			t.Error("expected error", cfg)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package connect
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+// Option represents a optional function argument to NewRunner
+type Option func(*Runner) error
+
+// WithOffsetStore sets where offsets are stored, the default keeps them in memory
+func WithOffsetStore(store OffsetStore) Option {
+	return func(r *Runner) error {
+		if store == nil {
+			return errors.New("offset store cannot be nil")
+		}
+		r.store = store
+		return nil
+	}
+}
+
+// WithPollInterval sets how often idle topics and sources are checked for new messages
+func WithPollInterval(interval time.Duration) Option {
+	return func(r *Runner) error {
+		if interval <= 0 {
+			return errors.New("invalid interval, value must be greater than 0")
+		}
+		r.pollInterval = interval
+		return nil
+	}
+}
+
+// WithBatchSize sets the maximum number of messages passed to a sink in each write
+func WithBatchSize(size int64) Option {
+	return func(r *Runner) error {
+		if size <= 0 {
+			return errors.New("invalid batch size, value must be greater than 0")
+		}
+		r.batchSize = size
+		return nil
+	}
+}
+
+// WithBackoff sets the delay before retrying after an error, doubling after each attempt up to max
+func WithBackoff(min, max time.Duration) Option {
+	return func(r *Runner) error {
+		if min <= 0 || max < min {
+			return errors.New("invalid backoff, min must be greater than 0 and max at least min")
+		}
+		r.minBackoff, r.maxBackoff = min, max
+		return nil
+	}
+}
+
+// WithLogger sets the logger used to report connector errors
+func WithLogger(logger server.Logger) Option {
+	return func(r *Runner) error {
+		if logger == nil {
+			return errors.New("logger cannot be nil")
+		}
+		r.logger = logger
+		return nil
+	}
+}
+
+// Runner runs sinks and sources against a queue
+type Runner struct {
+	q            Queue
+	store        OffsetStore
+	logger       server.Logger
+	pollInterval time.Duration
+	batchSize    int64
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+
+	mux       sync.Mutex
+	sinks     map[string]sinkConfig
+	sources   map[string]Source
+	isRunning bool
+	isClosed  bool
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+type sinkConfig struct {
+	sink   Sink
+	topics []string
+}
+
+// NewRunner creates a runner reading from and producing to the queue
+func NewRunner(q Queue, opts ...Option) (*Runner, error) {
+	if q == nil {
+		return nil, errors.New("queue cannot be nil")
+	}
+	// connectors are configured by the operator, not by clients, so the server does not check its ACL for them
+	ctx, cancel := context.WithCancel(server.WithTrusted(context.Background()))
+	r := &Runner{
+		q:            q,
+		store:        NewMemoryOffsetStore(),
+		logger:       noOpLogger{},
+		pollInterval: time.Second,
+		batchSize:    500,
+		minBackoff:   time.Second,
+		maxBackoff:   time.Minute,
+		sinks:        make(map[string]sinkConfig),
+		sources:      make(map[string]Source),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			cancel()
+			return nil, errors.Wrap(err, "invalid option")
+		}
+	}
+	return r, nil
+}
+
+func (r *Runner) add(name string, fn func()) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if name == "" {
+		return errors.New("connector name cannot be empty")
+	}
+	if _, ok := r.sinks[name]; ok {
+		return errors.Errorf("connector %q already exists", name)
+	}
+	if _, ok := r.sources[name]; ok {
+		return errors.Errorf("connector %q already exists", name)
+	}
+	if r.isRunning || r.isClosed {
+		return errors.New("connectors must be added before the runner is started")
+	}
+	fn()
+	return nil
+}
+
+// AddSink adds a sink writing messages from the topics
+func (r *Runner) AddSink(name string, sink Sink, topics ...string) error {
+	if sink == nil {
+		return errors.New("sink cannot be nil")
+	}
+	if len(topics) == 0 {
+		return errors.Errorf("sink %q has no topics", name)
+	}
+	for _, topic := range topics {
+		if topic == "" {
+			return headers.ErrInvalidTopic
+		}
+	}
+	return r.add(name, func() { r.sinks[name] = sinkConfig{sink: sink, topics: topics} })
+}
+
+// AddSource adds a source producing messages to topics
+func (r *Runner) AddSource(name string, source Source) error {
+	if source == nil {
+		return errors.New("source cannot be nil")
+	}
+	return r.add(name, func() { r.sources[name] = source })
+}
+
+// Run runs the connectors until the runner is closed
+func (r *Runner) Run() error {
+	r.mux.Lock()
+	if r.isClosed || r.isRunning {
+		r.mux.Unlock()
+		return errors.New("runner already started")
+	}
+	r.isRunning = true
+	for name, cfg := range r.sinks {
+		for _, topic := range cfg.topics {
+			r.wg.Add(1)
+			go func(name string, sink Sink, topic string) {
+				defer r.wg.Done()
+				r.runSink(name, sink, topic)
+			}(name, cfg.sink, topic)
+		}
+	}
+	for name, source := range r.sources {
+		r.wg.Add(1)
+		go func(name string, source Source) {
+			defer r.wg.Done()
+			r.runSource(name, source)
+		}(name, source)
+	}
+	r.mux.Unlock()
+	<-r.ctx.Done()
+	r.wg.Wait()
+	return nil
+}
+
+// Close stops the connectors, waits for them to return and closes them
+func (r *Runner) Close() error {
+	r.mux.Lock()
+	r.isClosed = true
+	r.mux.Unlock()
+	r.cancel()
+	r.wg.Wait()
+
+	var err error
+	for name, cfg := range r.sinks {
+		if e := cfg.sink.Close(); e != nil {
+			err = errors.Wrapf(e, "unable to close sink %q", name)
+		}
+	}
+	for name, source := range r.sources {
+		if e := source.Close(); e != nil {
+			err = errors.Wrapf(e, "unable to close source %q", name)
+		}
+	}
+	return err
+}
+
+// wait waits for the duration, returning false if the runner was closed
+func (r *Runner) wait(d time.Duration) bool {
+	select {
+	case <-r.ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// retry calls fn until it succeeds, waiting with exponential backoff between attempts. It returns false
+// if the runner was closed
+func (r *Runner) retry(fn func() error, msg string, keyvals ...interface{}) bool {
+	backoff := r.minBackoff
+	for {
+		err := fn()
+		if err == nil {
+			return true
+		}
+		if r.ctx.Err() != nil {
+			return false
+		}
+		r.logger.Warn(msg, append(keyvals, "err", err)...)
+		if !r.wait(backoff) {
+			return false
+		}
+		if backoff *= 2; backoff > r.maxBackoff {
+			backoff = r.maxBackoff
+		}
+	}
+}
+
+// sinkOffset returns the offset to start writing the topic from
+func (r *Runner) sinkOffset(name string, sink Sink, topic string) (int64, error) {
+	if s, ok := sink.(OffsetSink); ok {
+		offset, err := s.Offset(r.ctx, topic)
+		if err != nil || offset >= 0 {
+			return offset, err
+		}
+	}
+	v, ok, err := r.store.Get(sinkKey(name, topic))
+	if err != nil || !ok {
+		return 0, err
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+func sinkKey(name, topic string) string {
+	return "sink/" + name + "/" + topic
+}
+
+func (r *Runner) runSink(name string, sink Sink, topic string) {
+	_, ownOffsets := sink.(OffsetSink)
+	var next int64
+	if !r.retry(func() error {
+		var err error
+		next, err = r.sinkOffset(name, sink, topic)
+		return err
+	}, "unable to read sink offset", "sink", name, "topic", topic) {
+		return
+	}
+	r.logger.Info("starting sink", "sink", name, "topic", topic, "offset", next)
+
+	insp, _ := r.q.(inspector)
+	for r.ctx.Err() == nil {
+		if insp != nil {
+			if info, err := insp.InspectTopic(r.ctx, topic); err == nil && next < info.MinOffset {
+				r.logger.Warn("sink skipping truncated messages", "sink", name, "topic", topic, "from", next, "to", info.MinOffset)
+				next = info.MinOffset
+			}
+		}
+		msgs, err := r.q.ConsumeMsgs(r.ctx, topic, next, r.batchSize)
+		switch errors.Cause(err) {
+		case nil, headers.ErrTopicDoesNotExist, headers.ErrNoContent:
+		default:
+			if r.ctx.Err() == nil {
+				r.logger.Warn("unable to read topic", "sink", name, "topic", topic, "err", err)
+			}
+		}
+		if len(msgs) == 0 {
+			if f, ok := sink.(Flusher); ok && err == nil {
+				if err = f.Flush(r.ctx, topic); err != nil {
+					r.logger.Warn("unable to flush sink", "sink", name, "topic", topic, "err", err)
+				}
+			}
+			r.wait(r.pollInterval)
+			continue
+		}
+
+		batch := Batch{Topic: topic, Offset: next, Msgs: msgs}
+		if !r.retry(func() error { return sink.Write(r.ctx, batch) }, "unable to write to sink", "sink", name, "topic", topic, "offset", next) {
+			return
+		}
+		next += int64(len(msgs))
+		if !ownOffsets {
+			if err = r.store.Set(sinkKey(name, topic), strconv.FormatInt(next, 10)); err != nil {
+				r.logger.Error("unable to store sink offset", "sink", name, "topic", topic, "err", err)
+			}
+		}
+	}
+}
+
+func (r *Runner) runSource(name string, source Source) {
+	key := "source/" + name
+	var position string
+	if !r.retry(func() error {
+		var err error
+		position, _, err = r.store.Get(key)
+		return err
+	}, "unable to read source position", "source", name) {
+		return
+	}
+	r.logger.Info("starting source", "source", name, "position", position)
+
+	for r.ctx.Err() == nil {
+		msgs, next, err := source.Read(r.ctx, position)
+		if err != nil {
+			if r.ctx.Err() == nil {
+				r.logger.Warn("unable to read from source", "source", name, "err", err)
+				r.wait(r.minBackoff)
+			}
+			continue
+		}
+		if len(msgs) == 0 {
+			if next != position {
+				position = next
+				if err = r.store.Set(key, position); err != nil {
+					r.logger.Error("unable to store source position", "source", name, "err", err)
+				}
+			}
+			r.wait(r.pollInterval)
+			continue
+		}
+
+		// produce consecutive messages for the same topic together, keeping their order
+		for start := 0; start < len(msgs); {
+			end := start + 1
+			for end < len(msgs) && msgs[end].Topic == msgs[start].Topic {
+				end++
+			}
+			topic, values := msgs[start].Topic, make([][]byte, 0, end-start)
+			for _, msg := range msgs[start:end] {
+				values = append(values, msg.Value)
+			}
+			if !r.retry(func() error { return r.produce(topic, values) }, "unable to produce source messages", "source", name, "topic", topic) {
+				return
+			}
+			start = end
+		}
+		position = next
+		if err = r.store.Set(key, position); err != nil {
+			r.logger.Error("unable to store source position", "source", name, "err", err)
+		}
+	}
+}
+
+// produce produces the messages, creating the topic if it does not exist
+func (r *Runner) produce(topic string, msgs [][]byte) error {
+	err := r.q.ProduceMsgs(r.ctx, topic, msgs...)
+	if errors.Cause(err) == headers.ErrTopicDoesNotExist {
+		err = r.q.CreateTopic(r.ctx, topic)
+		if err == nil || errors.Cause(err) == headers.ErrTopicAlreadyExists {
+			err = r.q.ProduceMsgs(r.ctx, topic, msgs...)
+		}
+	}
+	return err
+}
@@ -0,0 +1,240 @@
+package connect
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/pkg/server"
+)
+
+func newTestServer(t *testing.T) *server.Server {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "connect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := server.NewServer(server.WithFileQueue([]string{dir}, false, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = s.Close()
+		_ = os.RemoveAll(dir)
+	})
+	return s
+}
+
+// recordSink records the messages written to it, failing the first write
+type recordSink struct {
+	mux     sync.Mutex
+	msgs    map[string][]string
+	offsets map[string]int64
+	failed  bool
+	flushed bool
+	closed  bool
+}
+
+func (s *recordSink) Write(ctx context.Context, batch Batch) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if !s.failed {
+		s.failed = true
+		return errors.New("write failed")
+	}
+	if next, ok := s.offsets[batch.Topic]; ok && next != batch.Offset {
+		return errors.New("unexpected offset " + strconv.FormatInt(batch.Offset, 10))
+	}
+	for _, msg := range batch.Msgs {
+		s.msgs[batch.Topic] = append(s.msgs[batch.Topic], string(msg))
+	}
+	s.offsets[batch.Topic] = batch.Offset + int64(len(batch.Msgs))
+	return nil
+}
+
+func (s *recordSink) Flush(ctx context.Context, topic string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.flushed = true
+	return nil
+}
+
+func (s *recordSink) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *recordSink) count(topic string) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.msgs[topic])
+}
+
+// offsetSink stores its own offsets
+type offsetSink struct {
+	recordSink
+	next int64
+}
+
+func (s *offsetSink) Offset(ctx context.Context, topic string) (int64, error) {
+	return s.next, nil
+}
+
+// sliceSource reads messages from a slice, the position is the index of the next message
+type sliceSource struct {
+	msgs []Message
+}
+
+func (s *sliceSource) Read(ctx context.Context, position string) ([]Message, string, error) {
+	var i int
+	if position != "" {
+		var err error
+		if i, err = strconv.Atoi(position); err != nil {
+			return nil, "", err
+		}
+	}
+	end := i + 2
+	if end > len(s.msgs) {
+		end = len(s.msgs)
+	}
+	return s.msgs[i:end], strconv.Itoa(end), nil
+}
+
+func (s *sliceSource) Close() error { return nil }
+
+func waitFor(t *testing.T, fn func() bool) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		if fn() {
+			return
+		}
+	}
+	t.Fatal("timed out")
+}
+
+func TestNewRunner(t *testing.T) {
+	if _, err := NewRunner(nil); err == nil {
+		t.Error("expected nil queue error")
+	}
+	q := newTestServer(t)
+	for _, opt := range []Option{
+		WithOffsetStore(nil),
+		WithPollInterval(0),
+		WithBatchSize(0),
+		WithBackoff(0, time.Second),
+		WithBackoff(time.Second, time.Millisecond),
+		WithLogger(nil),
+	} {
+		if _, err := NewRunner(q, opt); err == nil {
+			t.Error("expected invalid option error")
+		}
+	}
+
+	r, err := NewRunner(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &recordSink{}
+	if err = r.AddSink("", sink, "t"); err == nil {
+		t.Error("expected empty name error")
+	}
+	if err = r.AddSink("s", nil, "t"); err == nil {
+		t.Error("expected nil sink error")
+	}
+	if err = r.AddSink("s", sink); err == nil {
+		t.Error("expected no topics error")
+	}
+	if err = r.AddSink("s", sink, ""); err == nil {
+		t.Error("expected invalid topic error")
+	}
+	if err = r.AddSink("s", sink, "t"); err != nil {
+		t.Error(err)
+	}
+	if err = r.AddSource("s", &sliceSource{}); err == nil {
+		t.Error("expected duplicate name error")
+	}
+	if err = r.AddSource("src", nil); err == nil {
+		t.Error("expected nil source error")
+	}
+	if err = r.Close(); err != nil || !sink.closed {
+		t.Fatal(err)
+	}
+	if err = r.Run(); err == nil {
+		t.Error("expected closed error")
+	}
+	if err = r.AddSource("src", &sliceSource{}); err == nil {
+		t.Error("expected closed error")
+	}
+}
+
+func TestRunner(t *testing.T) {
+	q := newTestServer(t)
+	ctx := context.Background()
+	if err := q.CreateTopic(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.ProduceMsgs(ctx, "a", []byte("a0"), []byte("a1"), []byte("a2")); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewMemoryOffsetStore()
+	if err := store.Set("sink/rec/a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRunner(q, WithOffsetStore(store), WithPollInterval(time.Millisecond), WithBatchSize(2), WithBackoff(time.Millisecond, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recordSink{msgs: map[string][]string{}, offsets: map[string]int64{}}
+	own := &offsetSink{recordSink: recordSink{msgs: map[string][]string{}, offsets: map[string]int64{}, failed: true}, next: 2}
+	source := &sliceSource{msgs: []Message{{"a", []byte("a3")}, {"b", []byte("b0")}, {"b", []byte("b1")}}}
+	if err = r.AddSink("rec", rec, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.AddSink("own", own, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.AddSource("src", source); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := r.Run(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// the source creates topic b, the sinks resume from their stored offsets and retry the failed write
+	waitFor(t, func() bool { return rec.count("a") == 3 && rec.count("b") == 2 && own.count("a") == 2 })
+	waitFor(t, func() bool { v, _, _ := store.Get("sink/rec/b"); return v == "2" })
+	if v, _, _ := store.Get("source/src"); v != "3" {
+		t.Fatal(v)
+	}
+	if _, ok, _ := store.Get("sink/own/a"); ok {
+		t.Fatal("unexpected offset stored for offset sink")
+	}
+	rec.mux.Lock()
+	if got := rec.msgs["a"]; got[0] != "a1" || got[2] != "a3" || !rec.flushed {
+		t.Fatal(got, rec.flushed)
+	}
+	if got := rec.msgs["b"]; got[0] != "b0" || got[1] != "b1" {
+		t.Fatal(got)
+	}
+	rec.mux.Unlock()
+
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if !rec.closed || !own.closed {
+		t.Fatal("expected sinks to be closed")
+	}
+}
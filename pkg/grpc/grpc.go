@@ -0,0 +1,172 @@
+// Package grpc serves the haraqa produce, consume and topic admin api over gRPC, for clients which prefer
+// protobuf and HTTP/2 streaming. The service is defined in haraqa.proto, clients can generate stubs from it.
+//
+// Requests are served by net/http. Plaintext HTTP/2, used by most gRPC clients without tls, requires the
+// server to be built with go1.24 or later, otherwise a tls config must be given. Request messages may be gzip
+// compressed, responses are never compressed.
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+// Queue is the subset of the haraqa server used to serve gRPC clients, it is implemented by *server.Server
+type Queue interface {
+	CreateTopic(ctx context.Context, topic string) error
+	DeleteTopic(ctx context.Context, topic string) error
+	ListTopics(ctx context.Context, prefix, suffix, regex string) ([]string, error)
+	InspectTopic(ctx context.Context, topic string) (*headers.TopicInfo, error)
+	ModifyTopic(ctx context.Context, topic string, request headers.ModifyRequest) (*headers.TopicInfo, error)
+	ProduceMsgs(ctx context.Context, topic string, msgs ...[]byte) error
+	ConsumeGroupMsgs(ctx context.Context, topic, group string, id, limit int64) ([][]byte, error)
+}
+
+var _ Queue = &server.Server{}
+
+// Option represents a optional function argument to NewListener
+type Option func(*Listener) error
+
+// WithTLSConfig serves gRPC over tls, the config must contain a certificate
+func WithTLSConfig(config *tls.Config) Option {
+	return func(l *Listener) error {
+		if config == nil {
+			return errors.New("tls config cannot be nil")
+		}
+		l.tlsConfig = config.Clone()
+		for _, proto := range config.NextProtos {
+			if proto == "h2" {
+				return nil
+			}
+		}
+		l.tlsConfig.NextProtos = append([]string{"h2"}, config.NextProtos...)
+		return nil
+	}
+}
+
+// WithPollInterval sets how often streaming consumers check for new messages
+func WithPollInterval(interval time.Duration) Option {
+	return func(l *Listener) error {
+		if interval <= 0 {
+			return errors.New("invalid interval, value must be greater than 0")
+		}
+		l.pollInterval = interval
+		return nil
+	}
+}
+
+// WithMaxMessageSize sets the largest request message accepted, in bytes
+func WithMaxMessageSize(size int64) Option {
+	return func(l *Listener) error {
+		if size <= 0 {
+			return errors.New("invalid size, value must be greater than 0")
+		}
+		l.maxMessageSize = size
+		return nil
+	}
+}
+
+// WithMiddleware wraps the handler of every call, such as with server.Authenticate so that calls are
+// authenticated the same way as the server's http requests
+func WithMiddleware(middleware func(http.Handler) http.Handler) Option {
+	return func(l *Listener) error {
+		if middleware == nil {
+			return errors.New("middleware cannot be nil")
+		}
+		l.middleware = middleware
+		return nil
+	}
+}
+
+// WithLogger sets the logger used to report request errors
+func WithLogger(logger server.Logger) Option {
+	return func(l *Listener) error {
+		if logger == nil {
+			return errors.New("logger cannot be nil")
+		}
+		l.logger = logger
+		return nil
+	}
+}
+
+// Listener serves gRPC clients backed by a haraqa queue
+type Listener struct {
+	q              Queue
+	logger         server.Logger
+	tlsConfig      *tls.Config
+	pollInterval   time.Duration
+	maxMessageSize int64
+	middleware     func(http.Handler) http.Handler
+	srv            *http.Server
+}
+
+// NewListener creates a new gRPC listener on top of the queue
+func NewListener(q Queue, opts ...Option) (*Listener, error) {
+	if q == nil {
+		return nil, errors.New("queue cannot be nil")
+	}
+	l := &Listener{
+		q:              q,
+		logger:         noOpLogger{},
+		pollInterval:   100 * time.Millisecond,
+		maxMessageSize: 16 << 20,
+	}
+	for _, opt := range opts {
+		if err := opt(l); err != nil {
+			return nil, errors.Wrap(err, "invalid option")
+		}
+	}
+	var handler http.Handler = l
+	if l.middleware != nil {
+		handler = l.middleware(handler)
+	}
+	l.srv = &http.Server{Handler: handler, TLSConfig: l.tlsConfig}
+	if !enableH2C(l.srv) && l.tlsConfig == nil {
+		return nil, errors.New("plaintext http/2 requires go1.24 or later, a tls config is required")
+	}
+	return l, nil
+}
+
+// ListenAndServe listens on the tcp address and serves gRPC clients until the listener is closed
+func (l *Listener) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return l.Serve(ln)
+}
+
+// Serve accepts connections on ln and serves gRPC clients until the listener is closed
+func (l *Listener) Serve(ln net.Listener) error {
+	var err error
+	if l.tlsConfig != nil {
+		err = l.srv.ServeTLS(ln, "", "")
+	} else {
+		err = l.srv.Serve(ln)
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Close stops all listeners and closes any open connections
+func (l *Listener) Close() error {
+	return l.srv.Close()
+}
+
+var _ server.Logger = noOpLogger{}
+
+type noOpLogger struct{}
+
+func (noOpLogger) Debug(string, ...interface{}) {}
+func (noOpLogger) Info(string, ...interface{})  {}
+func (noOpLogger) Warn(string, ...interface{})  {}
+func (noOpLogger) Error(string, ...interface{}) {}
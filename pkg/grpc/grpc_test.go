@@ -0,0 +1,408 @@
+package grpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/internal/protowire"
+	"github.com/haraqa/haraqa/pkg/server"
+)
+
+type testQueue struct {
+	mux    sync.Mutex
+	topics map[string][][]byte
+	groups map[string]int64
+}
+
+func (q *testQueue) CreateTopic(ctx context.Context, topic string) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if _, ok := q.topics[topic]; ok {
+		return headers.ErrTopicAlreadyExists
+	}
+	q.topics[topic] = [][]byte{}
+	return nil
+}
+
+func (q *testQueue) DeleteTopic(ctx context.Context, topic string) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if _, ok := q.topics[topic]; !ok {
+		return headers.ErrTopicDoesNotExist
+	}
+	delete(q.topics, topic)
+	return nil
+}
+
+func (q *testQueue) ListTopics(ctx context.Context, prefix, suffix, regex string) ([]string, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	var topics []string
+	for topic := range q.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+func (q *testQueue) InspectTopic(ctx context.Context, topic string) (*headers.TopicInfo, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	msgs, ok := q.topics[topic]
+	if !ok {
+		return nil, headers.ErrTopicDoesNotExist
+	}
+	return &headers.TopicInfo{MinOffset: 0, MaxOffset: int64(len(msgs)) - 1}, nil
+}
+
+func (q *testQueue) ModifyTopic(ctx context.Context, topic string, request headers.ModifyRequest) (*headers.TopicInfo, error) {
+	if request.Truncate == 0 {
+		return nil, nil
+	}
+	return q.InspectTopic(ctx, topic)
+}
+
+func (q *testQueue) ProduceMsgs(ctx context.Context, topic string, msgs ...[]byte) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if _, ok := q.topics[topic]; !ok {
+		return headers.ErrTopicDoesNotExist
+	}
+	q.topics[topic] = append(q.topics[topic], msgs...)
+	return nil
+}
+
+func (q *testQueue) ConsumeGroupMsgs(ctx context.Context, topic, group string, id, limit int64) ([][]byte, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	msgs, ok := q.topics[topic]
+	if !ok {
+		return nil, headers.ErrTopicDoesNotExist
+	}
+	if id > int64(len(msgs)) {
+		id = int64(len(msgs))
+	}
+	msgs = msgs[id:]
+	if limit > 0 && int64(len(msgs)) > limit {
+		msgs = msgs[:limit]
+	}
+	if group != "" {
+		q.groups[group] = id + int64(len(msgs))
+	}
+	return msgs, nil
+}
+
+func frame(msg []byte) []byte {
+	b := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(msg)))
+	return append(b, msg...)
+}
+
+func readFrames(t *testing.T, r io.Reader) [][]byte {
+	t.Helper()
+	var msgs [][]byte
+	for {
+		var prefix [5]byte
+		if _, err := io.ReadFull(r, prefix[:]); err == io.EOF {
+			return msgs
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+		if _, err := io.ReadFull(r, msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func equal(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+type testClient struct {
+	t      *testing.T
+	client *http.Client
+	url    string
+	user   string
+}
+
+func (c *testClient) request(ctx context.Context, method string, body []byte, encoding string) *http.Response {
+	c.t.Helper()
+	req, err := http.NewRequest(http.MethodPost, c.url+"/"+serviceName+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if c.user != "" {
+		req.SetBasicAuth(c.user, "secret")
+	}
+	if encoding != "" {
+		req.Header.Set("Grpc-Encoding", encoding)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 2 {
+		c.t.Fatal(resp.Status, resp.Proto)
+	}
+	return resp
+}
+
+// call makes a unary or client streaming call, returning the response messages and grpc status
+func (c *testClient) call(method string, msgs ...[]byte) ([][]byte, int) {
+	c.t.Helper()
+	var body []byte
+	for _, msg := range msgs {
+		body = append(body, frame(msg)...)
+	}
+	resp := c.request(context.Background(), method, body, "")
+	defer resp.Body.Close()
+	responses := readFrames(c.t, resp.Body)
+	code, err := strconv.Atoi(resp.Trailer.Get("Grpc-Status"))
+	if err != nil {
+		c.t.Fatal(resp.Trailer)
+	}
+	return responses, code
+}
+
+func TestNewListener(t *testing.T) {
+	if _, err := NewListener(nil); err == nil {
+		t.Error("expected nil queue error")
+	}
+	for _, opt := range []Option{WithTLSConfig(nil), WithPollInterval(0), WithMaxMessageSize(0), WithLogger(nil)} {
+		if _, err := NewListener(&testQueue{}, opt); err == nil {
+			t.Error("expected invalid option error")
+		}
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	for v, expected := range map[string]time.Duration{"1H": time.Hour, "5S": 5 * time.Second, "100m": 100 * time.Millisecond, "99999999n": 99999999} {
+		if timeout, ok := parseTimeout(v); !ok || timeout != expected {
+			t.Error(v, timeout)
+		}
+	}
+	for _, v := range []string{"", "S", "1", "1x", "-1S", "123456789S"} {
+		if _, ok := parseTimeout(v); ok {
+			t.Error(v)
+		}
+	}
+	if msg := encodeMessage("topic \"a\" 100%\n"); msg != "topic \"a\" 100%25%0A" {
+		t.Error(msg)
+	}
+}
+
+func TestListener(t *testing.T) {
+	q := &testQueue{topics: map[string][][]byte{"events": {[]byte("old")}}, groups: map[string]int64{}}
+	l, err := NewListener(q, WithPollInterval(5*time.Millisecond), WithMaxMessageSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(l)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+	c := &testClient{t: t, client: ts.Client(), url: ts.URL}
+
+	// non grpc requests are rejected
+	resp, err := c.client.Post(ts.URL+"/"+serviceName+"/Produce", "application/json", nil)
+	if err != nil || resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatal(resp, err)
+	}
+	resp.Body.Close()
+
+	// topic admin
+	if msgs, code := c.call("CreateTopic", protowire.AppendString(nil, 1, "orders")); code != codeOK || !equal(msgs, [][]byte{{}}) {
+		t.Fatal(msgs, code)
+	}
+	if _, code := c.call("CreateTopic", protowire.AppendString(nil, 1, "orders")); code != codeAlreadyExists {
+		t.Fatal(code)
+	}
+	if msgs, code := c.call("ListTopics", nil); code != codeOK || !equal(msgs, [][]byte{marshalTopics([]string{"events", "orders"})}) {
+		t.Fatal(msgs, code)
+	}
+	if msgs, code := c.call("InspectTopic", protowire.AppendString(nil, 1, "events")); code != codeOK || !equal(msgs, [][]byte{marshalTopicInfo(0, 0)}) {
+		t.Fatal(msgs, code)
+	}
+	if msgs, code := c.call("TruncateTopic", protowire.AppendString(nil, 1, "events")); code != codeOK || !equal(msgs, [][]byte{marshalTopicInfo(0, 0)}) {
+		t.Fatal(msgs, code)
+	}
+	if _, code := c.call("DeleteTopic", protowire.AppendString(nil, 1, "missing")); code != codeNotFound {
+		t.Fatal(code)
+	}
+	if _, code := c.call("Unknown", nil); code != codeUnimplemented {
+		t.Fatal(code)
+	}
+	if _, code := c.call("InspectTopic"); code != codeInternal {
+		t.Fatal(code)
+	}
+	if _, code := c.call("InspectTopic", []byte{0x08}); code != codeInvalidArgument {
+		t.Fatal(code)
+	}
+	if _, code := c.call("Produce", make([]byte, 65)); code != codeResourceExhausted {
+		t.Fatal(code)
+	}
+
+	// produce
+	produce := protowire.AppendBytes(protowire.AppendBytes(protowire.AppendString(nil, 1, "orders"), 2, []byte("a")), 2, []byte("b"))
+	if msgs, code := c.call("Produce", produce); code != codeOK || !equal(msgs, [][]byte{marshalProduceResponse(2)}) {
+		t.Fatal(msgs, code)
+	}
+	if msgs, code := c.call("ProduceStream", produce, protowire.AppendBytes(protowire.AppendString(nil, 1, "orders"), 2, []byte("c"))); code != codeOK ||
+		!equal(msgs, [][]byte{marshalProduceResponse(3)}) {
+		t.Fatal(msgs, code)
+	}
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, _ = zw.Write(protowire.AppendBytes(protowire.AppendString(nil, 1, "orders"), 2, []byte("zip")))
+	_ = zw.Close()
+	body := frame(compressed.Bytes())
+	body[0] = 1
+	resp = c.request(context.Background(), "Produce", body, "gzip")
+	if msgs := readFrames(t, resp.Body); resp.Trailer.Get("Grpc-Status") != "0" || !equal(msgs, [][]byte{marshalProduceResponse(1)}) {
+		t.Fatal(msgs, resp.Trailer)
+	}
+	resp.Body.Close()
+	if _, code := c.call("Produce", protowire.AppendString(nil, 1, "missing")); code != codeNotFound {
+		t.Fatal(code)
+	}
+
+	// consume
+	consume := protowire.AppendInt(protowire.AppendInt(protowire.AppendString(nil, 1, "orders"), 2, 1), 3, 2)
+	if msgs, code := c.call("Consume", protowire.AppendString(consume, 4, "group")); code != codeOK ||
+		!equal(msgs, [][]byte{marshalConsumeResponse(1, [][]byte{[]byte("b"), []byte("a")})}) {
+		t.Fatal(msgs, code)
+	}
+	if q.groups["group"] != 3 {
+		t.Fatal(q.groups)
+	}
+	if msgs, code := c.call("Consume", protowire.AppendInt(protowire.AppendString(nil, 1, "orders"), 2, -1)); code != codeOK ||
+		!equal(msgs, [][]byte{marshalConsumeResponse(5, [][]byte{[]byte("zip")})}) {
+		t.Fatal(msgs, code)
+	}
+
+	// consume stream sends new messages as they are produced
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resp = c.request(ctx, "ConsumeStream", frame(protowire.AppendInt(protowire.AppendString(nil, 1, "orders"), 2, 5)), "")
+	defer resp.Body.Close()
+	read := func(expected []byte) {
+		t.Helper()
+		var prefix [5]byte
+		if _, err := io.ReadFull(resp.Body, prefix[:]); err != nil {
+			t.Fatal(err)
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+		if _, err := io.ReadFull(resp.Body, msg); err != nil || !bytes.Equal(msg, expected) {
+			t.Fatal(msg, err)
+		}
+	}
+	read(marshalConsumeResponse(5, [][]byte{[]byte("zip")}))
+	if err = q.ProduceMsgs(ctx, "orders", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	read(marshalConsumeResponse(6, [][]byte{[]byte("new")}))
+	cancel()
+	_, _ = ioutil.ReadAll(resp.Body)
+}
+
+func TestListener_Serve(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.NotFoundHandler())
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+	l, err := NewListener(&testQueue{topics: map[string][][]byte{"a": nil}}, WithTLSConfig(ts.TLS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- l.Serve(ln) }()
+
+	c := &testClient{t: t, client: ts.Client(), url: "https://" + ln.Addr().String()}
+	if msgs, code := c.call("ListTopics", nil); code != codeOK || !equal(msgs, [][]byte{marshalTopics([]string{"a"})}) {
+		t.Fatal(msgs, code)
+	}
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-served; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListener_Middleware(t *testing.T) {
+	dir := ".haraqa-grpc-auth"
+	defer os.RemoveAll(dir)
+	s, err := server.NewServer(server.WithFileQueue([]string{dir}, true, 5000), server.WithUserStore("", "root", "secret"),
+		server.WithACL("", "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err = s.PutUser(context.Background(), headers.User{Name: "app", Password: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewListener(s, WithMiddleware(nil)); err == nil {
+		t.Fatal("expected nil middleware error")
+	}
+	l, err := NewListener(s, WithMiddleware(s.Authenticate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(l.srv.Handler)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	// calls without credentials are rejected before they reach the service
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/"+serviceName+"/ListTopics", bytes.NewReader(frame(nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := ts.Client().Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatal(resp, err)
+	}
+	resp.Body.Close()
+
+	// authenticated calls are made as the user and checked against the acl rules
+	root := &testClient{t: t, client: ts.Client(), url: ts.URL, user: "root"}
+	if _, code := root.call("CreateTopic", protowire.AppendString(nil, 1, "orders")); code != codeOK {
+		t.Fatal(code)
+	}
+	app := &testClient{t: t, client: ts.Client(), url: ts.URL, user: "app"}
+	if _, code := app.call("DeleteTopic", protowire.AppendString(nil, 1, "orders")); code != codePermissionDenied {
+		t.Fatal(code)
+	}
+}
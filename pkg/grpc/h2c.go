@@ -0,0 +1,15 @@
+//go:build go1.24
+// +build go1.24
+
+package grpc
+
+import "net/http"
+
+// enableH2C allows plaintext HTTP/2 connections with prior knowledge, as used by gRPC clients without tls
+func enableH2C(srv *http.Server) bool {
+	srv.Protocols = new(http.Protocols)
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetHTTP2(true)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+	return true
+}
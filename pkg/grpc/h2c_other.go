@@ -0,0 +1,11 @@
+//go:build !go1.24
+// +build !go1.24
+
+package grpc
+
+import "net/http"
+
+// enableH2C returns false, plaintext HTTP/2 is only supported by net/http from go1.24
+func enableH2C(srv *http.Server) bool {
+	return false
+}
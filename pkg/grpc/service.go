@@ -0,0 +1,392 @@
+package grpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// serviceName is the fully qualified name of the Haraqa service in haraqa.proto
+const serviceName = "haraqa.v1.Haraqa"
+
+// gRPC status codes
+const (
+	codeOK                = 0
+	codeCanceled          = 1
+	codeUnknown           = 2
+	codeInvalidArgument   = 3
+	codeDeadlineExceeded  = 4
+	codeNotFound          = 5
+	codeAlreadyExists     = 6
+	codePermissionDenied  = 7
+	codeResourceExhausted = 8
+	codeUnimplemented     = 12
+	codeInternal          = 13
+	codeUnauthenticated   = 16
+)
+
+// statusError is an error returned to the client with a gRPC status code
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return "grpc: code " + strconv.Itoa(e.code) + ": " + e.msg
+}
+
+func statusErrorf(code int, format string, args ...interface{}) error {
+	return &statusError{code: code, msg: errors.Errorf(format, args...).Error()}
+}
+
+// status converts an error to a gRPC status code and message
+func status(err error) (int, string) {
+	if e, ok := err.(*statusError); ok {
+		return e.code, e.msg
+	}
+	switch errors.Cause(err) {
+	case nil:
+		return codeOK, ""
+	case headers.ErrTopicDoesNotExist:
+		return codeNotFound, err.Error()
+	case headers.ErrTopicAlreadyExists:
+		return codeAlreadyExists, err.Error()
+	case headers.ErrInvalidTopic, headers.ErrInvalidMessageID, headers.ErrInvalidMessageLimit, headers.ErrInvalidMessage:
+		return codeInvalidArgument, err.Error()
+	case headers.ErrInsufficientStorage, headers.ErrProduceQuota:
+		return codeResourceExhausted, err.Error()
+	case headers.ErrForbidden:
+		return codePermissionDenied, err.Error()
+	case headers.ErrUnauthorized:
+		return codeUnauthenticated, err.Error()
+	case context.Canceled:
+		return codeCanceled, err.Error()
+	case context.DeadlineExceeded:
+		return codeDeadlineExceeded, err.Error()
+	}
+	return codeUnknown, err.Error()
+}
+
+// encodeMessage percent encodes the grpc-message trailer
+func encodeMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			b.WriteString("%" + strings.ToUpper(strconv.FormatUint(uint64(c)|0x100, 16)[1:]))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// parseTimeout parses the grpc-timeout header, an integer of at most 8 digits followed by a unit
+func parseTimeout(v string) (time.Duration, bool) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+	unit, ok := units[v[len(v)-1]]
+	return time.Duration(n) * unit, ok
+}
+
+// stream reads request messages from and writes response messages to a single gRPC call
+type stream struct {
+	r       *http.Request
+	w       http.ResponseWriter
+	maxSize int64
+	gzip    bool
+}
+
+// recv reads the next request message, returning io.EOF once the client has finished sending
+func (s *stream) recv() ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(s.r.Body, prefix[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, statusErrorf(codeInternal, "unable to read request: %v", err)
+	}
+	size := int64(binary.BigEndian.Uint32(prefix[1:]))
+	if size > s.maxSize {
+		return nil, statusErrorf(codeResourceExhausted, "request message larger than max (%d vs. %d)", size, s.maxSize)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(s.r.Body, msg); err != nil {
+		return nil, statusErrorf(codeInternal, "unable to read request: %v", err)
+	}
+	switch {
+	case prefix[0] == 0:
+		return msg, nil
+	case prefix[0] != 1:
+		return nil, statusErrorf(codeInternal, "invalid compressed flag %d", prefix[0])
+	case !s.gzip:
+		return nil, statusErrorf(codeUnimplemented, "compressed message without a supported grpc-encoding")
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(msg))
+	if err != nil {
+		return nil, statusErrorf(codeInternal, "unable to decompress request: %v", err)
+	}
+	msg, err = ioutil.ReadAll(io.LimitReader(zr, s.maxSize+1))
+	if err != nil {
+		return nil, statusErrorf(codeInternal, "unable to decompress request: %v", err)
+	}
+	if int64(len(msg)) > s.maxSize {
+		return nil, statusErrorf(codeResourceExhausted, "request message larger than max (%d)", s.maxSize)
+	}
+	return msg, nil
+}
+
+// recvOne reads the single request message of a unary or server streaming call
+func (s *stream) recvOne() ([]byte, error) {
+	msg, err := s.recv()
+	if err == io.EOF {
+		return nil, statusErrorf(codeInternal, "missing request message")
+	}
+	return msg, err
+}
+
+// send writes a response message and flushes it to the client
+func (s *stream) send(msg []byte) error {
+	var prefix [5]byte
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+	if _, err := s.w.Write(prefix[:]); err != nil {
+		return err
+	}
+	if _, err := s.w.Write(msg); err != nil {
+		return err
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// ServeHTTP handles gRPC calls to the Haraqa service, it can be mounted on any HTTP/2 server
+func (l *Listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	contentType := r.Header.Get(headers.ContentType)
+	if r.Method != http.MethodPost || r.ProtoMajor != 2 ||
+		(contentType != "application/grpc" && contentType != "application/grpc+proto") {
+		http.Error(w, "gRPC requests must be HTTP/2 POST requests with content type application/grpc", http.StatusUnsupportedMediaType)
+		return
+	}
+	ctx := r.Context()
+	if v := r.Header.Get("Grpc-Timeout"); v != "" {
+		if timeout, ok := parseTimeout(v); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+	}
+	w.Header()[headers.ContentType] = []string{"application/grpc"}
+	w.Header()["Grpc-Accept-Encoding"] = []string{"gzip"}
+	w.WriteHeader(http.StatusOK)
+
+	s := &stream{r: r, w: w, maxSize: l.maxMessageSize}
+	switch r.Header.Get("Grpc-Encoding") {
+	case "", "identity":
+	case "gzip":
+		s.gzip = true
+	}
+
+	method := strings.TrimPrefix(r.URL.Path, "/"+serviceName+"/")
+	err := l.call(ctx, s, method)
+	code, msg := status(err)
+	if code != codeOK && code != codeCanceled {
+		l.logger.Warn("grpc call failed", "method", method, "code", code, "err", err)
+	}
+	w.Header()[http.TrailerPrefix+"Grpc-Status"] = []string{strconv.Itoa(code)}
+	if msg != "" {
+		w.Header()[http.TrailerPrefix+"Grpc-Message"] = []string{encodeMessage(msg)}
+	}
+}
+
+func (l *Listener) call(ctx context.Context, s *stream, method string) error {
+	switch method {
+	case "ListTopics":
+		return l.listTopics(ctx, s)
+	case "CreateTopic", "DeleteTopic", "InspectTopic":
+		return l.topic(ctx, s, method)
+	case "TruncateTopic":
+		return l.truncateTopic(ctx, s)
+	case "Produce":
+		return l.produce(ctx, s, false)
+	case "ProduceStream":
+		return l.produce(ctx, s, true)
+	case "Consume":
+		return l.consume(ctx, s, false)
+	case "ConsumeStream":
+		return l.consume(ctx, s, true)
+	}
+	return statusErrorf(codeUnimplemented, "unknown method %s", s.r.URL.Path)
+}
+
+func invalidRequest(err error) error {
+	return statusErrorf(codeInvalidArgument, "invalid request message: %v", err)
+}
+
+func (l *Listener) listTopics(ctx context.Context, s *stream) error {
+	b, err := s.recvOne()
+	if err != nil {
+		return err
+	}
+	var req listTopicsRequest
+	if err = req.unmarshal(b); err != nil {
+		return invalidRequest(err)
+	}
+	topics, err := l.q.ListTopics(ctx, req.prefix, req.suffix, req.regex)
+	if err != nil {
+		return err
+	}
+	return s.send(marshalTopics(topics))
+}
+
+func (l *Listener) topic(ctx context.Context, s *stream, method string) error {
+	b, err := s.recvOne()
+	if err != nil {
+		return err
+	}
+	var req topicRequest
+	if err = req.unmarshal(b); err != nil {
+		return invalidRequest(err)
+	}
+	switch method {
+	case "CreateTopic":
+		err = l.q.CreateTopic(ctx, req.topic)
+	case "DeleteTopic":
+		err = l.q.DeleteTopic(ctx, req.topic)
+	default:
+		var info *headers.TopicInfo
+		if info, err = l.q.InspectTopic(ctx, req.topic); err == nil {
+			return s.send(marshalTopicInfo(info.MinOffset, info.MaxOffset))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return s.send(nil)
+}
+
+func (l *Listener) truncateTopic(ctx context.Context, s *stream) error {
+	b, err := s.recvOne()
+	if err != nil {
+		return err
+	}
+	var req truncateRequest
+	if err = req.unmarshal(b); err != nil {
+		return invalidRequest(err)
+	}
+	request := headers.ModifyRequest{Truncate: req.offset}
+	if req.before > 0 {
+		request.Before = time.Unix(req.before, 0)
+	}
+	info, err := l.q.ModifyTopic(ctx, req.topic, request)
+	if err == nil && info == nil {
+		info, err = l.q.InspectTopic(ctx, req.topic)
+	}
+	if err != nil {
+		return err
+	}
+	return s.send(marshalTopicInfo(info.MinOffset, info.MaxOffset))
+}
+
+// produce writes the messages of each request, stream calls read requests until the client closes the stream
+func (l *Listener) produce(ctx context.Context, s *stream, streaming bool) error {
+	var count int64
+	for {
+		b, err := s.recv()
+		if err == io.EOF && streaming {
+			break
+		}
+		if err == io.EOF {
+			return statusErrorf(codeInternal, "missing request message")
+		}
+		if err != nil {
+			return err
+		}
+		var req produceRequest
+		if err = req.unmarshal(b); err != nil {
+			return invalidRequest(err)
+		}
+		if err = l.q.ProduceMsgs(ctx, req.topic, req.messages...); err != nil {
+			return err
+		}
+		count += int64(len(req.messages))
+		if !streaming {
+			break
+		}
+	}
+	return s.send(marshalProduceResponse(count))
+}
+
+// consume sends messages from the requested offset. Stream calls keep sending batches as messages are produced
+// until the client cancels the call
+func (l *Listener) consume(ctx context.Context, s *stream, streaming bool) error {
+	b, err := s.recvOne()
+	if err != nil {
+		return err
+	}
+	var req consumeRequest
+	if err = req.unmarshal(b); err != nil {
+		return invalidRequest(err)
+	}
+	if req.offset < 0 {
+		info, err := l.q.InspectTopic(ctx, req.topic)
+		if err != nil {
+			return err
+		}
+		req.offset = info.MaxOffset
+		if req.offset < info.MinOffset {
+			req.offset = info.MinOffset
+		}
+	}
+
+	var ticker *time.Ticker
+	for {
+		msgs, err := l.q.ConsumeGroupMsgs(ctx, req.topic, req.group, req.offset, req.limit)
+		if err != nil {
+			return err
+		}
+		if len(msgs) > 0 || !streaming {
+			if err = s.send(marshalConsumeResponse(req.offset, msgs)); err != nil || !streaming {
+				return err
+			}
+			req.offset += int64(len(msgs))
+			continue
+		}
+
+		// skip messages removed by truncation
+		info, err := l.q.InspectTopic(ctx, req.topic)
+		if err != nil {
+			return err
+		}
+		if req.offset < info.MinOffset {
+			req.offset = info.MinOffset
+			continue
+		}
+		if ticker == nil {
+			ticker = time.NewTicker(l.pollInterval)
+			defer ticker.Stop()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
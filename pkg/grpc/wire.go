@@ -0,0 +1,142 @@
+package grpc
+
+import (
+	"github.com/haraqa/haraqa/internal/protowire"
+)
+
+// message types, see haraqa.proto
+
+type topicRequest struct {
+	topic string
+}
+
+func (m *topicRequest) unmarshal(b []byte) error {
+	d := protowire.NewDecoder(b)
+	for field, ok := d.Next(); ok; field, ok = d.Next() {
+		switch field {
+		case 1:
+			m.topic = d.String()
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+type listTopicsRequest struct {
+	prefix, suffix, regex string
+}
+
+func (m *listTopicsRequest) unmarshal(b []byte) error {
+	d := protowire.NewDecoder(b)
+	for field, ok := d.Next(); ok; field, ok = d.Next() {
+		switch field {
+		case 1:
+			m.prefix = d.String()
+		case 2:
+			m.suffix = d.String()
+		case 3:
+			m.regex = d.String()
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+type truncateRequest struct {
+	topic  string
+	offset int64
+	before int64
+}
+
+func (m *truncateRequest) unmarshal(b []byte) error {
+	d := protowire.NewDecoder(b)
+	for field, ok := d.Next(); ok; field, ok = d.Next() {
+		switch field {
+		case 1:
+			m.topic = d.String()
+		case 2:
+			m.offset = d.Int64()
+		case 3:
+			m.before = d.Int64()
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+type produceRequest struct {
+	topic    string
+	messages [][]byte
+}
+
+func (m *produceRequest) unmarshal(b []byte) error {
+	d := protowire.NewDecoder(b)
+	for field, ok := d.Next(); ok; field, ok = d.Next() {
+		switch field {
+		case 1:
+			m.topic = d.String()
+		case 2:
+			m.messages = append(m.messages, d.Bytes())
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+type consumeRequest struct {
+	topic  string
+	offset int64
+	limit  int64
+	group  string
+}
+
+func (m *consumeRequest) unmarshal(b []byte) error {
+	d := protowire.NewDecoder(b)
+	for field, ok := d.Next(); ok; field, ok = d.Next() {
+		switch field {
+		case 1:
+			m.topic = d.String()
+		case 2:
+			m.offset = d.Int64()
+		case 3:
+			m.limit = d.Int64()
+		case 4:
+			m.group = d.String()
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+func marshalTopics(topics []string) []byte {
+	var b []byte
+	for _, topic := range topics {
+		b = protowire.AppendBytes(b, 1, []byte(topic))
+	}
+	return b
+}
+
+func marshalTopicInfo(minOffset, maxOffset int64) []byte {
+	return protowire.AppendInt(protowire.AppendInt(nil, 1, minOffset), 2, maxOffset)
+}
+
+func marshalProduceResponse(count int64) []byte {
+	return protowire.AppendInt(nil, 1, count)
+}
+
+func marshalConsumeResponse(offset int64, msgs [][]byte) []byte {
+	size := 11
+	for _, msg := range msgs {
+		size += len(msg) + 11
+	}
+	b := protowire.AppendInt(make([]byte, 0, size), 1, offset)
+	for _, msg := range msgs {
+		b = protowire.AppendBytes(b, 2, msg)
+	}
+	return b
+}
@@ -0,0 +1,58 @@
+package grpc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/protowire"
+)
+
+func TestWire(t *testing.T) {
+	// unknown fields of every wire type are skipped
+	b := protowire.AppendString(nil, 1, "topic")
+	b = protowire.AppendInt(b, 9, -3)
+	b = append(protowire.AppendTag(b, 10, protowire.Fixed64), 1, 2, 3, 4, 5, 6, 7, 8)
+	b = append(protowire.AppendTag(b, 11, protowire.Fixed32), 1, 2, 3, 4)
+	b = protowire.AppendBytes(b, 12, []byte("ignored"))
+	b = protowire.AppendBytes(protowire.AppendBytes(b, 2, []byte("a")), 2, nil)
+	var req produceRequest
+	if err := req.unmarshal(b); err != nil || req.topic != "topic" || !reflect.DeepEqual(req.messages, [][]byte{[]byte("a"), {}}) {
+		t.Fatal(req, err)
+	}
+
+	var consume consumeRequest
+	b = protowire.AppendString(protowire.AppendInt(protowire.AppendInt(protowire.AppendString(nil, 1, "t"), 2, -1), 3, 10), 4, "g")
+	if err := consume.unmarshal(b); err != nil || consume != (consumeRequest{topic: "t", offset: -1, limit: 10, group: "g"}) {
+		t.Fatal(consume, err)
+	}
+
+	for _, b := range [][]byte{
+		{0x0a, 0x05, 'a'},              // bytes longer than the message
+		{0x08},                         // truncated varint
+		{0x00, 0x01},                   // field 0
+		{0x0b},                         // group wire type
+		protowire.AppendInt(nil, 1, 5), // topic with the varint wire type
+	} {
+		if err := (&topicRequest{}).unmarshal(b); err != protowire.ErrMalformed {
+			t.Errorf("%x %v", b, err)
+		}
+	}
+
+	d := protowire.NewDecoder(marshalConsumeResponse(5, [][]byte{[]byte("x"), {}}))
+	var offset int64
+	var msgs [][]byte
+	for field, ok := d.Next(); ok; field, ok = d.Next() {
+		switch field {
+		case 1:
+			offset = d.Int64()
+		case 2:
+			msgs = append(msgs, d.Bytes())
+		}
+	}
+	if d.Err() != nil || offset != 5 || !reflect.DeepEqual(msgs, [][]byte{[]byte("x"), {}}) {
+		t.Fatal(offset, msgs, d.Err())
+	}
+	if len(marshalTopicInfo(0, 0)) != 0 || len(marshalProduceResponse(0)) != 0 {
+		t.Error("expected zero values to be omitted")
+	}
+}
@@ -0,0 +1,98 @@
+package haraqa
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// ACL returns the server's ACL rules and their version, which can be passed to the other ACL methods to
+// only make changes if the rules have not changed since
+func (c *Client) ACL() (*headers.ACL, error) {
+	var acl headers.ACL
+	if _, err := c.aclRequest(http.MethodGet, "", 0, nil, &acl, "haraqa.ACL"); err != nil {
+		return nil, errors.Wrap(err, "error getting acl")
+	}
+	return &acl, nil
+}
+
+// PutACLRule adds the rule to the server's ACL, or replaces the rule with the same id. The server generates
+// an id if it is empty. The change fails with headers.ErrACLConflict unless version is 0 or the current
+// version of the rules. The rule as stored and the new version are returned
+func (c *Client) PutACLRule(rule headers.ACLRule, version int64) (*headers.ACLRule, int64, error) {
+	method, path := http.MethodPost, ""
+	if rule.ID != "" {
+		method, path = http.MethodPut, "/"+url.PathEscape(rule.ID)
+	}
+	var put headers.ACLRule
+	version, err := c.aclRequest(method, path, version, rule, &put, "haraqa.PutACLRule")
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "error putting acl rule")
+	}
+	return &put, version, nil
+}
+
+// DeleteACLRule removes the rule with the id from the server's ACL. The change fails with
+// headers.ErrACLConflict unless version is 0 or the current version of the rules. The new version is returned
+func (c *Client) DeleteACLRule(id string, version int64) (int64, error) {
+	version, err := c.aclRequest(http.MethodDelete, "/"+url.PathEscape(id), version, nil, nil, "haraqa.DeleteACLRule")
+	if err != nil {
+		return 0, errors.Wrap(err, "error deleting acl rule")
+	}
+	return version, nil
+}
+
+// ImportACL replaces every rule of the server's ACL with the rules of the acl, such as those returned by ACL.
+// The change fails with headers.ErrACLConflict unless version is 0 or the current version of the rules
+func (c *Client) ImportACL(acl headers.ACL, version int64) (*headers.ACL, error) {
+	var imported headers.ACL
+	if _, err := c.aclRequest(http.MethodPut, "", version, acl, &imported, "haraqa.ImportACL"); err != nil {
+		return nil, errors.Wrap(err, "error importing acl")
+	}
+	return &imported, nil
+}
+
+// aclRequest sends a request to the acl endpoint, with the version as its If-Match header if it is not 0.
+// The body is sent as json if it is not nil and the response is decoded into v if it is not nil. The version
+// of the rules in the response ETag is returned
+func (c *Client) aclRequest(method, path string, version int64, body, v interface{}, operation string) (int64, error) {
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return 0, err
+		}
+	}
+	req, err := http.NewRequestWithContext(c.ctx, method, c.url+"/acl"+path, bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header[headers.ContentType] = []string{"application/json"}
+	}
+	if version != 0 {
+		req.Header.Set("If-Match", `"`+strconv.FormatInt(version, 10)+`"`)
+	}
+
+	resp, err := c.do(req, operation, "")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return 0, headers.ReadErrors(resp.Header)
+	}
+	if v != nil {
+		if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return 0, err
+		}
+	}
+	version, _ = strconv.ParseInt(strings.Trim(resp.Header.Get("ETag"), `"`), 10, 64)
+	return version, nil
+}
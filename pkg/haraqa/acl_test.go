@@ -0,0 +1,62 @@
+package haraqa
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+func TestClient_ACL(t *testing.T) {
+	dir := ".haraqa-acl"
+	defer os.RemoveAll(dir)
+	root := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(server.WithPrincipal(r.Context(), "root")))
+		})
+	}
+	s, err := server.NewServer(server.WithFileQueue([]string{dir}, false, 5000), server.WithACL("", "root"), server.WithMiddleware(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c, err := NewClient(WithHandler(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acl, err := c.ACL()
+	if err != nil || acl.Version != 1 || len(acl.Rules) != 0 {
+		t.Fatal(acl, err)
+	}
+	rule, version, err := c.PutACLRule(headers.ACLRule{Principal: "app", Topic: "orders", Permissions: []string{"consume"}}, acl.Version)
+	if err != nil || rule.ID == "" || version != 2 {
+		t.Fatal(rule, version, err)
+	}
+	rule.Permissions = append(rule.Permissions, "produce")
+	if _, _, err = c.PutACLRule(*rule, acl.Version); errors.Cause(err) != headers.ErrACLConflict {
+		t.Fatal(err)
+	}
+	if rule, version, err = c.PutACLRule(*rule, version); err != nil || len(rule.Permissions) != 2 || version != 3 {
+		t.Fatal(rule, version, err)
+	}
+
+	acl, err = c.ACL()
+	if err != nil || acl.Version != 3 || len(acl.Rules) != 1 {
+		t.Fatal(acl, err)
+	}
+	acl.Rules = append(acl.Rules, headers.ACLRule{ID: "public", Principal: "*", Topic: "public*", Permissions: []string{"consume"}})
+	imported, err := c.ImportACL(*acl, acl.Version)
+	if err != nil || imported.Version != 4 || len(imported.Rules) != 2 {
+		t.Fatal(imported, err)
+	}
+	if version, err = c.DeleteACLRule("public", 0); err != nil || version != 5 {
+		t.Fatal(version, err)
+	}
+	if _, err = c.DeleteACLRule("public", 0); errors.Cause(err) != headers.ErrACLRuleDoesNotExist {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+package haraqa
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCircuitOpen is returned without sending the request while the client's circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open: server is failing, try again later")
+
+// WithCircuitBreaker stops sending requests after the given number of consecutive failures, where a
+// failure is a request which could not be sent or a 429 or 5xx response. Requests fail immediately with
+// ErrCircuitOpen until the cooldown has passed, then a single request is let through to probe the
+// server. A successful probe closes the breaker, a failed one opens it for another cooldown. This keeps a
+// fleet of retrying producers from overwhelming a struggling server
+func WithCircuitBreaker(failures int, cooldown time.Duration) Option {
+	return func(c *Client) error {
+		if failures <= 0 {
+			return errors.New("invalid circuit breaker: failures must be greater than 0")
+		}
+		if cooldown <= 0 {
+			return errors.New("invalid circuit breaker: cooldown must be greater than 0")
+		}
+		c.breaker = &breaker{threshold: failures, cooldown: cooldown}
+		return nil
+	}
+}
+
+// breaker is a circuit breaker shared by a client and its copies
+type breaker struct {
+	mux       sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow returns true if a request can be sent. Once the cooldown of an open breaker has passed one
+// request is allowed as a probe, other requests are rejected until it completes
+func (b *breaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// done records the result of a request, opening the breaker once the failures reach the threshold
+func (b *breaker) done(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// release ends a probe without recording a result
+func (b *breaker) release() {
+	if b == nil {
+		return
+	}
+	b.mux.Lock()
+	b.probing = false
+	b.mux.Unlock()
+}
+
+// failed returns true if the response shows the server is failing rather than rejecting the request
+func failed(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,116 @@
+package haraqa
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestWithCircuitBreaker(t *testing.T) {
+	for _, opt := range []Option{WithCircuitBreaker(0, time.Second), WithCircuitBreaker(1, 0)} {
+		if err := opt(&Client{}); err == nil {
+			t.Error("expected invalid circuit breaker error")
+		}
+	}
+	c := &Client{}
+	if err := WithCircuitBreaker(3, time.Second)(c); err != nil || c.breaker.threshold != 3 || c.breaker.cooldown != time.Second {
+		t.Fatal(c.breaker, err)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	var b *breaker
+	if !b.allow() {
+		t.Fatal("a nil breaker allows every request")
+	}
+	b.done(true)
+	b.release()
+
+	b = &breaker{threshold: 2, cooldown: 10 * time.Millisecond}
+	b.done(true)
+	if !b.allow() {
+		t.Fatal("expected a closed breaker")
+	}
+	b.done(true)
+	if b.allow() {
+		t.Fatal("expected an open breaker")
+	}
+
+	// a single probe is allowed after the cooldown
+	time.Sleep(15 * time.Millisecond)
+	if !b.allow() || b.allow() {
+		t.Fatal("expected a single probe")
+	}
+	b.release()
+	if !b.allow() {
+		t.Fatal("expected a probe after an abandoned probe")
+	}
+	b.done(true)
+	if b.allow() {
+		t.Fatal("expected a failed probe to reopen the breaker")
+	}
+	time.Sleep(15 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("expected a probe")
+	}
+	b.done(false)
+	if !b.allow() || !b.allow() {
+		t.Fatal("expected a successful probe to close the breaker")
+	}
+}
+
+func TestClient_CircuitBreaker(t *testing.T) {
+	var healthy int32
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch {
+		case r.URL.Path == "/topics/existing":
+			headers.SetError(w, headers.ErrTopicAlreadyExists)
+		case atomic.LoadInt32(&healthy) == 0:
+			headers.SetError(w, headers.ErrTooManyOpenFiles)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(WithURL(srv.URL), WithCircuitBreaker(2, 20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rejected requests do not count as failures
+	for i := 0; i < 3; i++ {
+		if err = c.CreateTopic("existing"); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err = c.ProduceMsgs("topic", []byte("hello")); err == nil || errors.Cause(err) == ErrCircuitOpen {
+			t.Fatal(err)
+		}
+	}
+
+	// copies of the client share the breaker
+	if err = c.WithContext(context.Background()).ProduceMsgs("topic", []byte("hello")); errors.Cause(err) != ErrCircuitOpen {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 5 {
+		t.Fatal(n)
+	}
+
+	// the server recovers and the probe closes the breaker
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(25 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if err = c.ProduceMsgs("topic", []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
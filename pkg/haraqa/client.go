@@ -1,17 +1,25 @@
 package haraqa
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
 	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/url"
 	urlpkg "net/url"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/tracing"
 	"github.com/pkg/errors"
 )
 
@@ -41,10 +49,99 @@ func WithHTTPClient(client *http.Client) Option {
 	}
 }
 
+// WithTracer sets the tracer used to create a span for each request. The span is propagated to the
+// server using the W3C traceparent header
+func WithTracer(tracer tracing.Tracer) Option {
+	return func(c *Client) error {
+		if tracer == nil {
+			return errors.New("invalid tracer: tracer cannot be nil")
+		}
+		c.tracer = tracer
+		return nil
+	}
+}
+
+// WithConsumerGroup sets the consumer group sent with each consume request, this is used by the
+// server to track the group's position and report its lag
+func WithConsumerGroup(group string) Option {
+	return func(c *Client) error {
+		c.group = group
+		return nil
+	}
+}
+
+// WithProducerID sets the producer id sent with batches produced by ProduceSeq, servers with producer
+// deduplication enabled drop batches repeating a recent sequence number of the producer
+func WithProducerID(id string) Option {
+	return func(c *Client) error {
+		if id == "" {
+			return errors.New("invalid producer id: id cannot be empty")
+		}
+		c.producerID = id
+		return nil
+	}
+}
+
+// WithCreateTopics creates missing topics when they are produced to, instead of returning an error.
+// This overrides the server's default for the client's produce requests
+func WithCreateTopics(create bool) Option {
+	return func(c *Client) error {
+		c.createTopics = &create
+		return nil
+	}
+}
+
+// WithBasicAuth sends the user's basic auth credentials with each request, for servers with a user store
+func WithBasicAuth(user, password string) Option {
+	return func(c *Client) error {
+		if user == "" {
+			return errors.New("invalid user: user cannot be empty")
+		}
+		c.user, c.password = user, password
+		return nil
+	}
+}
+
+// WithTokenSource sends a bearer token from the source with each request, for servers which verify OIDC
+// access tokens. The source is called for every request so that it can refresh expired tokens
+func WithTokenSource(source func() (string, error)) Option {
+	return func(c *Client) error {
+		if source == nil {
+			return errors.New("invalid token source: source cannot be nil")
+		}
+		c.token = source
+		return nil
+	}
+}
+
+// WithRequestSigning signs each request with the shared key, for servers which authenticate signed requests.
+// Request bodies are read into memory to be hashed before they are sent
+func WithRequestSigning(keyID, secret string) Option {
+	return func(c *Client) error {
+		if keyID == "" || secret == "" {
+			return errors.New("invalid signing key: id and secret cannot be empty")
+		}
+		c.signKey, c.signSecret = keyID, []byte(secret)
+		return nil
+	}
+}
+
 // Client is a lightweight client around the haraqa http api, use NewClient() to create a new client
 type Client struct {
-	c   *http.Client
-	url string
+	c            *http.Client
+	url          string
+	ctx          context.Context
+	tracer       tracing.Tracer
+	group        string
+	producerID   string
+	user         string
+	password     string
+	token        func() (string, error)
+	signKey      string
+	signSecret   []byte
+	createTopics *bool
+	breaker      *breaker
+	discovery    *discovery
 }
 
 // NewClient creates a new client instance. Any options given override the local defaults
@@ -66,7 +163,9 @@ func NewClient(opts ...Option) (*Client, error) {
 				MaxIdleConnsPerHost:   1000,
 			},
 		},
-		url: "http://127.0.0.1:4353",
+		url:    "http://127.0.0.1:4353",
+		ctx:    context.Background(),
+		tracer: tracing.NoopTracer{},
 	}
 
 	for _, opt := range opts {
@@ -78,14 +177,101 @@ func NewClient(opts ...Option) (*Client, error) {
 	return c, nil
 }
 
+// WithContext returns a shallow copy of the client which sends its requests using the given context.
+// The context is used for cancellation and as the parent of any traced requests
+func (c *Client) WithContext(ctx context.Context) *Client {
+	if ctx == nil {
+		panic("nil context")
+	}
+	c2 := *c
+	c2.ctx = ctx
+	return &c2
+}
+
+// do sends the request within a new span, the span is ended once the response headers are received
+func (c *Client) do(req *http.Request, name, topic string) (*http.Response, error) {
+	if c.token != nil {
+		token, err := c.token()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to get token")
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if c.signKey != "" {
+		if err := c.sign(req); err != nil {
+			return nil, errors.Wrap(err, "unable to sign request")
+		}
+	}
+	if !c.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+	ctx, span := c.tracer.Start(req.Context(), name, tracing.SpanKindClient)
+	defer span.End()
+	if topic != "" {
+		span.SetAttribute("messaging.destination", topic)
+	}
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+	delete(req.Header, tracing.HeaderTraceParent)
+	if tp := span.TraceParent(); tp.IsValid() {
+		req.Header[tracing.HeaderTraceParent] = []string{tp.String()}
+	}
+
+	resp, err := c.send(req.WithContext(ctx))
+	if req.Context().Err() != nil {
+		// requests abandoned by the caller say nothing about the server
+		c.breaker.release()
+	} else {
+		c.breaker.done(failed(resp, err))
+	}
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttribute("http.status_code", resp.StatusCode)
+	span.RecordError(headers.ReadErrors(resp.Header))
+	return resp, nil
+}
+
+// sign reads the body of the request to hash it and sets the headers of a signed request
+func (c *Client) sign(req *http.Request) error {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+		req.ContentLength = int64(len(body))
+	}
+	nonce, err := headers.NewMessageID()
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	hash := headers.ContentSHA256(body)
+	req.Header.Set(headers.HeaderSignatureKey, c.signKey)
+	req.Header.Set(headers.HeaderSignatureTime, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(headers.HeaderSignatureNonce, nonce.String())
+	req.Header.Set(headers.HeaderContentSHA256, hash)
+	req.Header.Set(headers.HeaderSignature, headers.SignRequest(c.signSecret, req.Method, req.URL.RequestURI(), timestamp, nonce.String(), hash))
+	return nil
+}
+
 // CreateTopic Creates a new topic. It returns an error if the topic already exists
 func (c *Client) CreateTopic(topic string) error {
-	req, err := http.NewRequest(http.MethodPut, c.url+"/topics/"+topic, nil)
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPut, c.url+"/topics/"+topic, nil)
 	if err != nil {
 		return err
 	}
 
-	resp, err := c.c.Do(req)
+	resp, err := c.do(req, "haraqa.CreateTopic", topic)
 	if err != nil {
 		return err
 	}
@@ -98,12 +284,12 @@ func (c *Client) CreateTopic(topic string) error {
 
 // DeleteTopic Delete a topic
 func (c *Client) DeleteTopic(topic string) error {
-	req, err := http.NewRequest(http.MethodDelete, c.url+"/topics/"+topic, nil)
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodDelete, c.url+"/topics/"+topic, nil)
 	if err != nil {
 		return err
 	}
 
-	resp, err := c.c.Do(req)
+	resp, err := c.do(req, "haraqa.DeleteTopic", topic)
 	if err != nil {
 		return err
 	}
@@ -114,13 +300,234 @@ func (c *Client) DeleteTopic(topic string) error {
 	return nil
 }
 
+// InspectTopic Returns the offsets and size of a topic, and ErrTopicDoesNotExist if it does not exist.
+// The topic's messages are not read
+func (c *Client) InspectTopic(topic string) (*headers.TopicInfo, error) {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodHead, c.url+"/topics/"+topic, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req, "haraqa.InspectTopic", topic)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return nil, errors.Wrap(err, "error inspecting topic")
+	}
+	return headers.ReadTopicInfo(resp.Header)
+}
+
+// CountMessages returns the number of messages of a topic with offsets from from up to but excluding to,
+// counted by the server from its indexes. A negative bound is left open, starting or ending with the topic
+func (c *Client) CountMessages(topic string, from, to int64) (*headers.MessageCount, error) {
+	query := url.Values{}
+	if from >= 0 {
+		query.Set("from", strconv.FormatInt(from, 10))
+	}
+	if to >= 0 {
+		query.Set("to", strconv.FormatInt(to, 10))
+	}
+	return c.countMessages(topic, query)
+}
+
+// CountMessagesBetween returns the number of messages of a topic produced from from up to but excluding to.
+// A zero bound is left open, starting or ending with the topic
+func (c *Client) CountMessagesBetween(topic string, from, to time.Time) (*headers.MessageCount, error) {
+	query := url.Values{}
+	if !from.IsZero() {
+		query.Set("from", from.Format(time.RFC3339Nano))
+	}
+	if !to.IsZero() {
+		query.Set("to", to.Format(time.RFC3339Nano))
+	}
+	return c.countMessages(topic, query)
+}
+
+func (c *Client) countMessages(topic string, query url.Values) (*headers.MessageCount, error) {
+	path := c.url + "/topics/" + topic + "/count"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req, "haraqa.CountMessages", topic)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return nil, errors.Wrap(err, "error counting messages")
+	}
+	var count headers.MessageCount
+	if err = json.NewDecoder(resp.Body).Decode(&count); err != nil {
+		return nil, err
+	}
+	return &count, nil
+}
+
+// PurgeTopic Removes every message of a topic, the topic is kept and later messages continue from the
+// offset after the purged messages
+func (c *Client) PurgeTopic(topic string) error {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodDelete, c.url+"/topics/"+topic+"?purge=true", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req, "haraqa.PurgeTopic", topic)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return errors.Wrap(err, "error purging topic")
+	}
+	return nil
+}
+
+// RedactedMarker is the tombstone written over the payload of redacted messages, repeated to their size
+const RedactedMarker = headers.RedactedMarker
+
+// IsRedacted returns true if a consumed message is the tombstone of a message redacted by RedactMessages.
+// Empty messages are not redacted
+func IsRedacted(msg []byte) bool {
+	return headers.IsRedacted(msg)
+}
+
+// RedactMessages Overwrites the messages of a topic with offsets from through to with a tombstone, see
+// IsRedacted. The messages keep their offsets and sizes
+func (c *Client) RedactMessages(topic string, from, to int64) error {
+	b, err := json.Marshal(map[string]interface{}{"redact": headers.OffsetRange{From: from, To: to}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPatch, c.url+"/topics/"+topic, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header[headers.ContentType] = []string{"application/json"}
+
+	resp, err := c.do(req, "haraqa.RedactMessages", topic)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return errors.Wrap(err, "error redacting messages")
+	}
+	return nil
+}
+
+// SetTopicReadOnly Marks a topic as read-only, rejecting produces while consumers can still read it
+func (c *Client) SetTopicReadOnly(topic string, readOnly bool) error {
+	return c.configureTopic(topic, map[string]interface{}{"readOnly": readOnly})
+}
+
+// SetTopicRetention sets the maximum age of a topic's messages, overriding the server's retention. A
+// retention of 0 keeps the topic's messages forever
+func (c *Client) SetTopicRetention(topic string, retention time.Duration) error {
+	return c.configureTopic(topic, map[string]interface{}{"retention": retention.String()})
+}
+
+// SetTopicTruncation truncates a topic to the messages produced within keep whenever the cron expression
+// matches in UTC, replacing the topic's truncation rule. An empty cron expression removes the rule
+func (c *Client) SetTopicTruncation(topic, cron string, keep time.Duration) error {
+	return c.configureTopic(topic, map[string]interface{}{"truncate": map[string]string{"cron": cron, "keep": keep.String()}})
+}
+
+// configureTopic updates the given fields of the configuration of a topic
+func (c *Client) configureTopic(topic string, config map[string]interface{}) error {
+	b, err := json.Marshal(map[string]interface{}{"config": config})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPatch, c.url+"/topics/"+topic, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header[headers.ContentType] = []string{"application/json"}
+
+	resp, err := c.do(req, "haraqa.ConfigureTopic", topic)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return errors.Wrap(err, "error configuring topic")
+	}
+	return nil
+}
+
+// RestoreTopic Restores a deleted topic, it returns an error if the topic is no longer in the server's trash
+func (c *Client) RestoreTopic(topic string) error {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPut, c.url+"/topics/"+topic+"?restore=true", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req, "haraqa.RestoreTopic", topic)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		err = headers.ReadErrors(resp.Header)
+		return errors.Wrap(err, "error restoring topic")
+	}
+	return nil
+}
+
+// Replay copies the messages of src with offsets from through to into dst, applying the server registered
+// transform if it is not empty. A negative to replays up to the last message. The number of messages
+// produced to dst is returned
+func (c *Client) Replay(src, dst string, from, to int64, transform string) (int64, error) {
+	query := url.Values{}
+	query.Set("replay", src)
+	query.Set("from", strconv.FormatInt(from, 10))
+	query.Set("to", strconv.FormatInt(to, 10))
+	if transform != "" {
+		query.Set("transform", transform)
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPost, c.url+"/topics/"+dst+"?"+query.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.do(req, "haraqa.Replay", dst)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return 0, errors.Wrap(err, "error replaying topic")
+	}
+	var replay headers.ReplayResponse
+	if err = json.NewDecoder(resp.Body).Decode(&replay); err != nil {
+		return 0, err
+	}
+	return replay.Count, nil
+}
+
 // ListTopics Lists all topics, filter by prefix, suffix, and/or a regex expression
 func (c *Client) ListTopics(prefix, suffix, regex string) error {
 	prefix = urlpkg.QueryEscape(prefix)
 	suffix = urlpkg.QueryEscape(suffix)
 	regex = urlpkg.QueryEscape(regex)
 	path := c.url + "/topics?prefix=" + prefix + "&suffix=" + suffix + "&regex=" + regex
-	resp, err := http.Get(path)
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, "haraqa.ListTopics", "")
 	if err != nil {
 		return err
 	}
@@ -133,21 +540,81 @@ func (c *Client) ListTopics(prefix, suffix, regex string) error {
 
 // Produce sends messages from a reader to the designated topic
 func (c *Client) Produce(topic string, sizes []int64, r io.Reader) error {
-	req, err := http.NewRequest(http.MethodPost, c.url+"/topics/"+topic, r)
+	_, err := c.ProduceWithIDs(topic, sizes, r)
+	return err
+}
+
+// ProduceWithIDs is Produce, returning the UUID the server assigned to each message. No ids are returned
+// if the server does not assign message ids
+func (c *Client) ProduceWithIDs(topic string, sizes []int64, r io.Reader) ([]string, error) {
+	return c.produce(topic, "", "", nil, sizes, r)
+}
+
+// ProduceSeq is ProduceWithIDs, numbering the batch with the sequence number so that retries with the same
+// sequence number are only written once by servers with producer deduplication enabled. The client must be
+// created with WithProducerID. No ids are returned if the batch was a duplicate
+func (c *Client) ProduceSeq(topic string, seq uint64, sizes []int64, r io.Reader) ([]string, error) {
+	if c.producerID == "" {
+		return nil, errors.New("invalid producer id: client has no producer id")
+	}
+	return c.produce(topic, strconv.FormatUint(seq, 10), "", nil, sizes, r)
+}
+
+// ProduceSubject is ProduceWithIDs, encrypting the messages with the data key of the subject, such as the
+// user the messages belong to, instead of the key of the topic. Shredding the subject's key with
+// ShredSubjectKey makes the messages unreadable. The server must have encryption enabled
+func (c *Client) ProduceSubject(topic, subject string, sizes []int64, r io.Reader) ([]string, error) {
+	if subject == "" {
+		return nil, errors.Wrap(headers.ErrInvalidSubject, "subject cannot be empty")
+	}
+	return c.produce(topic, "", subject, nil, sizes, r)
+}
+
+// ProduceTypes is ProduceWithIDs, storing the content type of each message, such as application/json,
+// alongside it. Messages with an empty content type are produced without one. The content types are
+// returned in the ContentType of consumed Messages
+func (c *Client) ProduceTypes(topic string, types []string, sizes []int64, r io.Reader) ([]string, error) {
+	if len(types) != len(sizes) {
+		return nil, errors.Wrapf(headers.ErrInvalidContentType, "expected %d content types but got %d", len(sizes), len(types))
+	}
+	return c.produce(topic, "", "", types, sizes, r)
+}
+
+// produce sends a produce request, with the producer id and sequence number if seq is set, the
+// encryption subject if subject is set and the content types if types is set
+func (c *Client) produce(topic, seq, subject string, types []string, sizes []int64, r io.Reader) ([]string, error) {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPost, c.url+"/topics/"+topic, r)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	req.Header = headers.SetSizes(sizes, req.Header)
+	if types != nil {
+		// servers which do not store content types reject the batch rather than dropping them
+		headers.SetBatchVersion(headers.BatchVersion, []string{headers.FeatureContentTypes}, req.Header)
+		headers.SetContentTypes(types, req.Header)
+	} else {
+		headers.SetBatchVersion(headers.BatchVersion, nil, req.Header)
+	}
+	if seq != "" {
+		req.Header[headers.HeaderProducerID] = []string{c.producerID}
+		req.Header[headers.HeaderSequence] = []string{seq}
+	}
+	if subject != "" {
+		req.Header[headers.HeaderEncryptionSubject] = []string{subject}
+	}
+	if c.createTopics != nil {
+		req.Header[headers.HeaderCreate] = []string{strconv.FormatBool(*c.createTopics)}
+	}
 
-	resp, err := c.c.Do(req)
+	resp, err := c.do(req, "haraqa.Produce", topic)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
 		err = headers.ReadErrors(resp.Header)
-		return errors.Wrap(err, "error producing")
+		return nil, errors.Wrap(err, "error producing")
 	}
-	return nil
+	return resp.Header[headers.HeaderMessageIDs], nil
 }
 
 // ProduceMsgs sends the messages to the designated topic
@@ -177,6 +644,26 @@ var getRequestPool = &sync.Pool{
 // Consume reads messages off of a topic starting from id, no more than the given limit is returned.
 // If limit is less than 1, the server sets the limit.
 func (c *Client) Consume(topic string, id uint64, limit int) (io.ReadCloser, []int64, error) {
+	resp, sizes, err := c.consume(topic, id, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, sizes, nil
+}
+
+// consume sends a consume request, returning the response and the sizes of its messages. If the
+// connection fails while the body is read the rest of the batch is requested again
+func (c *Client) consume(topic string, id uint64, limit int) (*http.Response, []int64, error) {
+	resp, sizes, err := c.consumeRange(topic, id, limit, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp.Body = &resumeBody{c: c, topic: topic, id: id, limit: limit, sizes: sizes, body: resp.Body}
+	return resp, sizes, nil
+}
+
+// consumeRange sends a consume request for the batch body from skip bytes
+func (c *Client) consumeRange(topic string, id uint64, limit int, skip int64) (*http.Response, []int64, error) {
 	var err error
 	req := getRequestPool.Get().(*http.Request)
 	defer getRequestPool.Put(req)
@@ -187,8 +674,19 @@ func (c *Client) Consume(topic string, id uint64, limit int) (io.ReadCloser, []i
 	if limit > 0 {
 		req.URL.RawQuery += "&limit=" + strconv.Itoa(limit)
 	}
+	if c.group != "" {
+		req.Header[headers.HeaderGroup] = []string{c.group}
+	} else {
+		delete(req.Header, headers.HeaderGroup)
+	}
+	headers.SetBatchVersion(headers.BatchVersion, headers.BatchFeatures, req.Header)
+	if skip > 0 {
+		req.Header["Range"] = []string{"bytes=" + strconv.FormatInt(skip, 10) + "-"}
+	} else {
+		delete(req.Header, "Range")
+	}
 
-	resp, err := c.c.Do(req)
+	resp, err := c.do(req.WithContext(c.ctx), "haraqa.Consume", topic)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -199,10 +697,81 @@ func (c *Client) Consume(topic string, id uint64, limit int) (io.ReadCloser, []i
 
 	sizes, err := headers.ReadSizes(resp.Header)
 	if err != nil {
+		_ = resp.Body.Close()
 		return nil, nil, err
 	}
 
-	return resp.Body, sizes, nil
+	return resp, sizes, nil
+}
+
+// maxResumes is the number of times the body of a consume is requested again after a failed read
+const maxResumes = 3
+
+// resumeBody is the body of a consume response, which resumes the consume from the bytes already read
+// if reading fails part way through
+type resumeBody struct {
+	c       *Client
+	topic   string
+	id      uint64
+	limit   int
+	sizes   []int64
+	body    io.ReadCloser
+	read    int64
+	resumes int
+}
+
+func (b *resumeBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.read += int64(n)
+	if err == nil || err == io.EOF || b.resumes >= maxResumes || b.c.ctx.Err() != nil {
+		return n, err
+	}
+	var total int64
+	for _, size := range b.sizes {
+		total += size
+	}
+	if b.read >= total {
+		return n, io.EOF
+	}
+	if resumeErr := b.resume(); resumeErr != nil {
+		return n, err
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return b.Read(p)
+}
+
+// resume replaces the body with the rest of the batch, which must have the same messages
+func (b *resumeBody) resume() error {
+	b.resumes++
+	resp, sizes, err := b.c.consumeRange(b.topic, b.id, b.limit, b.read)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusPartialContent || !equalSizes(sizes, b.sizes) {
+		_ = resp.Body.Close()
+		return errors.New("unable to resume consume, the batch has changed")
+	}
+	_ = b.body.Close()
+	b.body = resp.Body
+	return nil
+}
+
+func (b *resumeBody) Close() error {
+	return b.body.Close()
+}
+
+func equalSizes(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
 
 // ConsumeMsgs reads messages off of a topic starting from id, no more than the given limit is returned.
@@ -223,3 +792,494 @@ func (c *Client) ConsumeMsgs(topic string, id uint64, limit int) ([][]byte, erro
 	}
 	return msgs, nil
 }
+
+// Message is a consumed message along with its metadata
+type Message struct {
+	Topic     string
+	Offset    uint64
+	Data      []byte
+	Timestamp time.Time
+	ID        string
+	// ContentType is the content type the message was produced with, empty if it had none
+	ContentType string
+	// Key and Headers are the key and headers the message was produced with by ProduceBatch
+	Key     []byte
+	Headers map[string]string
+}
+
+// ConsumeMessages reads messages off of a topic starting from id like ConsumeMsgs, along with the time
+// each message was produced, its id and its content type. Timestamps are zero and ids empty if the server
+// does not send them
+func (c *Client) ConsumeMessages(topic string, id uint64, limit int) ([]Message, error) {
+	resp, sizes, err := c.consume(topic, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	msgs, err := readMessages(resp.Header, resp.Body, sizes)
+	if err != nil {
+		return nil, err
+	}
+	for i := range msgs {
+		msgs[i].Topic, msgs[i].Offset = topic, id+uint64(i)
+	}
+	return msgs, nil
+}
+
+// ConsumePrefix reads messages off of every topic starting with prefix, merged in the order they were
+// produced. Each topic is read from its offset in offsets, or from id if it has none. No more than
+// the given limit is returned, if limit is less than 1 the server sets the limit
+func (c *Client) ConsumePrefix(prefix string, id uint64, offsets map[string]uint64, limit int) ([]Message, error) {
+	query := url.Values{}
+	query.Set("id", strconv.FormatUint(id, 10))
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	for topic, offset := range offsets {
+		query.Add("from", topic+":"+strconv.FormatUint(offset, 10))
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, c.url+"/topics/"+prefix+"*?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.group != "" {
+		req.Header[headers.HeaderGroup] = []string{c.group}
+	}
+
+	resp, err := c.do(req, "haraqa.ConsumePrefix", prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return nil, errors.Wrap(err, "error consuming")
+	}
+	sizes, err := headers.ReadSizes(resp.Header)
+	if err != nil {
+		return nil, err
+	}
+	topics, offsetValues := resp.Header[headers.HeaderTopics], resp.Header[headers.HeaderOffsets]
+	if len(topics) != len(sizes) || len(offsetValues) != len(sizes) {
+		return nil, errors.Errorf("unable to read messages, expected %d topics and offsets", len(sizes))
+	}
+	msgs, err := readMessages(resp.Header, resp.Body, sizes)
+	if err != nil {
+		return nil, err
+	}
+	for i := range msgs {
+		msgs[i].Topic = topics[i]
+		if msgs[i].Offset, err = strconv.ParseUint(offsetValues[i], 10, 64); err != nil {
+			return nil, errors.Wrap(err, "invalid header: "+headers.HeaderOffsets)
+		}
+	}
+	return msgs, nil
+}
+
+// readMessages reads the messages and their metadata from a consume response
+func readMessages(header http.Header, body io.Reader, sizes []int64) ([]Message, error) {
+	timestamps, err := headers.ReadTimestamps(header)
+	if err != nil {
+		return nil, err
+	}
+	if timestamps != nil && len(timestamps) != len(sizes) {
+		return nil, errors.Errorf("unable to read messages, expected %d timestamps but got %d", len(sizes), len(timestamps))
+	}
+	ids, err := headers.ReadMessageIDs(header)
+	if err != nil {
+		return nil, err
+	}
+	if ids != nil && len(ids) != len(sizes) {
+		return nil, errors.Errorf("unable to read messages, expected %d ids but got %d", len(sizes), len(ids))
+	}
+	types, err := headers.ReadContentTypes(header)
+	if err != nil {
+		return nil, err
+	}
+	if types != nil && len(types) != len(sizes) {
+		return nil, errors.Errorf("unable to read messages, expected %d content types but got %d", len(sizes), len(types))
+	}
+	msgs := make([]Message, len(sizes))
+	for i := range sizes {
+		msgs[i].Data = make([]byte, sizes[i])
+		if _, err = io.ReadAtLeast(body, msgs[i].Data, len(msgs[i].Data)); err != nil {
+			return nil, err
+		}
+		if timestamps != nil {
+			msgs[i].Timestamp = timestamps[i]
+		}
+		if ids != nil && !ids[i].IsZero() {
+			msgs[i].ID = ids[i].String()
+		}
+		if types != nil {
+			msgs[i].ContentType = types[i]
+			readRecord(&msgs[i])
+		}
+	}
+	return msgs, nil
+}
+
+// ConsumeAll reads every message currently in the topic starting from id in a single request. Use
+// ConsumeAllFunc to handle the messages batch by batch instead of holding them all in memory
+func (c *Client) ConsumeAll(topic string, id uint64) ([][]byte, error) {
+	msgs := [][]byte{}
+	err := c.ConsumeAllFunc(topic, id, func(batch []Message) error {
+		for i := range batch {
+			msgs = append(msgs, batch[i].Data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// ConsumeAllFunc reads every message currently in the topic starting from id in a single request, calling
+// fn with each batch as it arrives. It returns when the last batch has been read or fn returns an error
+func (c *Client) ConsumeAllFunc(topic string, id uint64, fn func(msgs []Message) error) error {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, c.url+"/topics/"+topic+"?id="+strconv.FormatUint(id, 10)+"&limit=all", nil)
+	if err != nil {
+		return err
+	}
+	if c.group != "" {
+		req.Header[headers.HeaderGroup] = []string{c.group}
+	}
+
+	resp, err := c.do(req, "haraqa.ConsumeAll", topic)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return errors.Wrap(err, "error consuming")
+	}
+	return readBatches(resp, topic, fn)
+}
+
+// Follow streams the messages of the topic from id as they are produced, calling fn with each batch of
+// no more than limit messages. It returns when fn returns an error, the client's context is done or the
+// server ends the stream. A negative id follows the topic from the next message produced
+func (c *Client) Follow(topic string, id int64, limit int, fn func(msgs []Message) error) error {
+	query := url.Values{}
+	query.Set("id", strconv.FormatInt(id, 10))
+	query.Set("follow", "true")
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, c.url+"/topics/"+topic+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if c.group != "" {
+		req.Header[headers.HeaderGroup] = []string{c.group}
+	}
+
+	resp, err := c.do(req, "haraqa.Follow", topic)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return errors.Wrap(err, "error consuming")
+	}
+	return readBatches(resp, topic, fn)
+}
+
+// readBatches calls fn with the messages of each part of a multipart/mixed consume response
+func readBatches(resp *http.Response, topic string, fn func(msgs []Message) error) error {
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get(headers.ContentType))
+	if err != nil || mediaType != "multipart/mixed" {
+		return errors.Errorf("unable to read messages, unexpected content type %q", resp.Header.Get(headers.ContentType))
+	}
+
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		header := http.Header(part.Header)
+		if err = headers.ReadErrors(header); err != nil {
+			return errors.Wrap(err, "error consuming")
+		}
+		// parts without messages are heartbeats
+		if len(header[headers.HeaderSizes]) == 0 {
+			continue
+		}
+		sizes, err := headers.ReadSizes(header)
+		if err != nil {
+			return err
+		}
+		offsets := header[headers.HeaderOffsets]
+		if len(offsets) != len(sizes) {
+			return errors.Errorf("unable to read messages, expected %d offsets but got %d", len(sizes), len(offsets))
+		}
+		msgs, err := readMessages(header, part, sizes)
+		if err != nil {
+			return err
+		}
+		for i := range msgs {
+			msgs[i].Topic = topic
+			if msgs[i].Offset, err = strconv.ParseUint(offsets[i], 10, 64); err != nil {
+				return errors.Wrap(err, "invalid header: "+headers.HeaderOffsets)
+			}
+		}
+		if err = fn(msgs); err != nil {
+			return err
+		}
+	}
+}
+
+// Watch calls fn with the latest offset of the topic when the watch starts and each time the offset
+// advances past lastOffset, so that consumers can wait for messages instead of polling. It returns when
+// fn returns an error, the client's context is done or the server ends the stream. Use a lastOffset of
+// -1 to be called with the current offset
+func (c *Client) Watch(topic string, lastOffset int64, fn func(maxOffset int64) error) error {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, c.url+"/topics/"+topic+"/watch", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastOffset >= 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatInt(lastOffset, 10))
+	}
+
+	resp, err := c.do(req, "haraqa.Watch", topic)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return errors.Wrap(err, "error watching topic")
+	}
+
+	var event, data string
+	r := bufio.NewReader(resp.Body)
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && event == "error":
+			var body headers.ErrorBody
+			if err = json.Unmarshal([]byte(data), &body); err != nil {
+				return errors.Wrap(err, "unable to read watch error")
+			}
+			return errors.Wrap(headers.ReadErrors(http.Header{headers.HeaderErrorCode: {string(body.Code)}, headers.HeaderErrors: {body.Error}}), "error watching topic")
+		case line == "" && event == "offset":
+			var watch struct {
+				MaxOffset int64 `json:"maxOffset"`
+			}
+			if err = json.Unmarshal([]byte(data), &watch); err != nil {
+				return errors.Wrap(err, "unable to read watch event")
+			}
+			if err = fn(watch.MaxOffset); err != nil {
+				return err
+			}
+			event, data = "", ""
+		}
+	}
+}
+
+// JoinGroup joins the client's consumer group, set with WithConsumerGroup, subscribing to the given topics.
+// Pass an empty member to join as a new member, and the returned member id with later calls as heartbeats.
+// The topics assigned to the member in the group's current generation are returned, members which do not
+// send a heartbeat within the server's session timeout are removed and their topics reassigned
+func (c *Client) JoinGroup(member string, topics []string) (*headers.GroupAssignment, error) {
+	return c.JoinGroupPattern(member, topics, "", "")
+}
+
+// JoinGroupPattern is JoinGroup, also subscribing to every topic matching the prefix and/or regex. Matching
+// topics created after the member joined are added to the group's assignment with the following heartbeats
+func (c *Client) JoinGroupPattern(member string, topics []string, prefix, regex string) (*headers.GroupAssignment, error) {
+	if c.group == "" {
+		return nil, errors.New("invalid consumer group: group cannot be empty")
+	}
+	b, err := json.Marshal(headers.JoinGroupRequest{Member: member, Topics: topics, Prefix: prefix, Regex: regex})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPost, c.url+"/groups/"+url.PathEscape(c.group), bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header[headers.ContentType] = []string{"application/json"}
+
+	resp, err := c.do(req, "haraqa.JoinGroup", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return nil, errors.Wrap(err, "error joining group")
+	}
+	var assignment headers.GroupAssignment
+	if err = json.NewDecoder(resp.Body).Decode(&assignment); err != nil {
+		return nil, err
+	}
+	return &assignment, nil
+}
+
+// LeaveGroup removes the member from the client's consumer group, its topics are reassigned to the remaining
+// members without waiting for the session timeout
+func (c *Client) LeaveGroup(member string) error {
+	if c.group == "" {
+		return errors.New("invalid consumer group: group cannot be empty")
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodDelete, c.url+"/groups/"+url.PathEscape(c.group)+"?member="+url.QueryEscape(member), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req, "haraqa.LeaveGroup", "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		err = headers.ReadErrors(resp.Header)
+		return errors.Wrap(err, "error leaving group")
+	}
+	return nil
+}
+
+// DescribeGroup returns the live members of the client's consumer group with their topics, and the group's
+// next offset for each topic it has consumed
+func (c *Client) DescribeGroup() (*headers.GroupDescription, error) {
+	if c.group == "" {
+		return nil, errors.New("invalid consumer group: group cannot be empty")
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, c.url+"/groups/"+url.PathEscape(c.group), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req, "haraqa.DescribeGroup", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return nil, errors.Wrap(err, "error describing group")
+	}
+	var description headers.GroupDescription
+	if err = json.NewDecoder(resp.Body).Decode(&description); err != nil {
+		return nil, err
+	}
+	return &description, nil
+}
+
+// ScalingHint returns the lag of the client's consumer group, the rate it changes at and the suggested number
+// of consumers to keep the lag of each within targetLag, or the server's target lag if targetLag is 0
+func (c *Client) ScalingHint(targetLag int64) (*headers.ScalingHint, error) {
+	if c.group == "" {
+		return nil, errors.New("invalid consumer group: group cannot be empty")
+	}
+	path := c.url + "/scaling/" + url.PathEscape(c.group)
+	if targetLag > 0 {
+		path += "?targetLag=" + strconv.FormatInt(targetLag, 10)
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req, "haraqa.ScalingHint", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return nil, errors.Wrap(err, "error getting scaling hint")
+	}
+	var hint headers.ScalingHint
+	if err = json.NewDecoder(resp.Body).Decode(&hint); err != nil {
+		return nil, err
+	}
+	return &hint, nil
+}
+
+// CommitOffsets sets the next offset of the client's consumer group for each of the given topics. Consumes
+// by a client with a consumer group commit their offsets as they are read, use a client without a group to
+// consume messages which are only committed once they are processed
+func (c *Client) CommitOffsets(offsets map[string]int64) error {
+	if c.group == "" {
+		return errors.New("invalid consumer group: group cannot be empty")
+	}
+	b, err := json.Marshal(headers.CommitRequest{Offsets: offsets})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPut, c.url+"/groups/"+url.PathEscape(c.group), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header[headers.ContentType] = []string{"application/json"}
+
+	resp, err := c.do(req, "haraqa.CommitOffsets", "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		err = headers.ReadErrors(resp.Header)
+		return errors.Wrap(err, "error committing offsets")
+	}
+	return nil
+}
+
+// ResetOffsets moves the next offset of the client's consumer group for each topic to the position of its
+// reset, such as {To: "timestamp", Timestamp: deployed} to reprocess messages after a fixed consumer is
+// deployed. The new offsets are returned, and are only committed if dryRun is false. Resets require the admin
+// permission, and the group's consumers should be stopped while they run
+func (c *Client) ResetOffsets(resets map[string]headers.OffsetReset, dryRun bool) (map[string]int64, error) {
+	if c.group == "" {
+		return nil, errors.New("invalid consumer group: group cannot be empty")
+	}
+	b, err := json.Marshal(headers.ResetRequest{Topics: resets, DryRun: dryRun})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPatch, c.url+"/groups/"+url.PathEscape(c.group), bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header[headers.ContentType] = []string{"application/json"}
+
+	resp, err := c.do(req, "haraqa.ResetOffsets", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return nil, errors.Wrap(err, "error resetting offsets")
+	}
+	var reset headers.ResetResponse
+	if err = json.NewDecoder(resp.Body).Decode(&reset); err != nil {
+		return nil, err
+	}
+	return reset.Offsets, nil
+}
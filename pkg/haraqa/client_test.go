@@ -2,13 +2,23 @@ package haraqa
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/haraqa/haraqa/pkg/tracing"
 
 	"github.com/pkg/errors"
 )
@@ -177,6 +187,241 @@ func TestClient_DeleteTopic(t *testing.T) {
 	}
 }
 
+func TestClient_InspectTopic(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Error(r.Method)
+		}
+		switch r.URL.Path {
+		case "/topics/inspect_topic":
+			headers.SetTopicInfo(&headers.TopicInfo{MinOffset: 0, MaxOffset: 9, Size: 100}, w.Header())
+		default:
+			headers.SetError(w, headers.ErrTopicDoesNotExist)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := c.InspectTopic("inspect_topic")
+	if err != nil || *info != (headers.TopicInfo{MinOffset: 0, MaxOffset: 9, Size: 100}) {
+		t.Fatal(info, err)
+	}
+	if _, err = c.InspectTopic("missing_topic"); !errors.Is(err, headers.ErrTopicDoesNotExist) {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_CountMessages(t *testing.T) {
+	from := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Error(r.Method)
+		}
+		switch r.URL.String() {
+		case "/topics/count_topic/count?from=2":
+			_, _ = w.Write([]byte(`{"topic":"count_topic","from":2,"to":10,"count":8}`))
+		case "/topics/count_topic/count?from=2021-01-02T03%3A04%3A05Z":
+			_, _ = w.Write([]byte(`{"topic":"count_topic","from":5,"to":10,"count":5}`))
+		default:
+			headers.SetError(w, headers.ErrTopicDoesNotExist)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := c.CountMessages("count_topic", 2, -1)
+	if err != nil || *count != (headers.MessageCount{Topic: "count_topic", From: 2, To: 10, Count: 8}) {
+		t.Fatal(count, err)
+	}
+	count, err = c.CountMessagesBetween("count_topic", from, time.Time{})
+	if err != nil || count.From != 5 || count.Count != 5 {
+		t.Fatal(count, err)
+	}
+	if _, err = c.CountMessages("missing_topic", -1, -1); !errors.Is(err, headers.ErrTopicDoesNotExist) {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_PurgeTopic(t *testing.T) {
+	var count int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			t.Error("invalid method")
+		}
+		if r.URL.String() != "/topics/purge_topic?purge=true" {
+			t.Errorf("invalid url path %q", r.URL.String())
+		}
+		switch count {
+		case 0:
+			_, _ = w.Write([]byte(`{"minOffset":10,"maxOffset":9}`))
+		case 1:
+			headers.SetError(w, headers.ErrTopicDoesNotExist)
+		}
+		count++
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL))
+	if err != nil {
+		t.Error(err)
+	}
+	if err = c.PurgeTopic("purge_topic"); err != nil {
+		t.Error(err)
+	}
+	if err = c.PurgeTopic("purge_topic"); !errors.Is(err, headers.ErrTopicDoesNotExist) {
+		t.Error(err)
+	}
+}
+
+func TestClient_RedactMessages(t *testing.T) {
+	var count int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			t.Error("invalid method")
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		if string(b) != `{"redact":{"from":2,"to":4}}` {
+			t.Errorf("invalid body %q", b)
+		}
+		switch count {
+		case 0:
+			_, _ = w.Write([]byte(`{"minOffset":0,"maxOffset":9}`))
+		case 1:
+			headers.SetError(w, headers.ErrInvalidMessageID)
+		}
+		count++
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL))
+	if err != nil {
+		t.Error(err)
+	}
+	if err = c.RedactMessages("redact_topic", 2, 4); err != nil {
+		t.Error(err)
+	}
+	if err = c.RedactMessages("redact_topic", 2, 4); !errors.Is(err, headers.ErrInvalidMessageID) {
+		t.Error(err)
+	}
+
+	if !IsRedacted([]byte(RedactedMarker+RedactedMarker[:3])) || IsRedacted([]byte("secret")) || IsRedacted(nil) {
+		t.Error("unexpected redacted messages")
+	}
+}
+
+func TestClient_SetTopicReadOnly(t *testing.T) {
+	var count int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/topics/frozen_topic" {
+			t.Error(r.Method, r.URL)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != `{"config":{"readOnly":true}}` {
+			t.Error(string(body))
+		}
+		switch count {
+		case 0:
+			_, _ = w.Write([]byte(`{"minOffset":0,"maxOffset":9,"config":{"readOnly":true}}`))
+		case 1:
+			headers.SetError(w, headers.ErrTopicDoesNotExist)
+		}
+		count++
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL))
+	if err != nil {
+		t.Error(err)
+	}
+	if err = c.SetTopicReadOnly("frozen_topic", true); err != nil {
+		t.Error(err)
+	}
+	if err = c.SetTopicReadOnly("frozen_topic", true); !errors.Is(err, headers.ErrTopicDoesNotExist) {
+		t.Error(err)
+	}
+}
+
+func TestClient_SetTopicRetention(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/topics/audit_topic" {
+			t.Error(r.Method, r.URL)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != `{"config":{"retention":"8760h0m0s"}}` {
+			t.Error(string(body))
+		}
+		_, _ = w.Write([]byte(`{"minOffset":0,"maxOffset":9,"config":{"retention":"8760h0m0s"}}`))
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL))
+	if err != nil {
+		t.Error(err)
+	}
+	if err = c.SetTopicRetention("audit_topic", 365*24*time.Hour); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClient_SetTopicTruncation(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/topics/rolling_topic" {
+			t.Error(r.Method, r.URL)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != `{"config":{"truncate":{"cron":"@hourly","keep":"24h0m0s"}}}` {
+			t.Error(string(body))
+		}
+		_, _ = w.Write([]byte(`{"minOffset":0,"maxOffset":9,"config":{"truncate":{"cron":"@hourly","keep":"24h0m0s"}}}`))
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL))
+	if err != nil {
+		t.Error(err)
+	}
+	if err = c.SetTopicTruncation("rolling_topic", "@hourly", 24*time.Hour); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClient_RestoreTopic(t *testing.T) {
+	var count int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Error("invalid method")
+		}
+		if r.URL.String() != "/topics/restore_topic?restore=true" {
+			t.Errorf("invalid url path %q", r.URL.String())
+		}
+		switch count {
+		case 0:
+			w.WriteHeader(http.StatusCreated)
+		case 1:
+			headers.SetError(w, headers.ErrTopicAlreadyExists)
+		}
+		count++
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.RestoreTopic("restore_topic"); err != nil {
+		t.Error(err)
+	}
+	if err = c.RestoreTopic("restore_topic"); !errors.Is(err, headers.ErrTopicAlreadyExists) {
+		t.Error(err)
+	}
+}
+
 func TestClient_ListTopics(t *testing.T) {
 	var count int
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -235,7 +480,11 @@ func TestClient_Produce(t *testing.T) {
 			if string(b) != "test_body" {
 				t.Error(string(b))
 			}
-			w.WriteHeader(http.StatusOK)
+			if count == 0 {
+				w.WriteHeader(http.StatusOK)
+			} else {
+				w.WriteHeader(http.StatusNoContent)
+			}
 		case 2:
 			headers.SetError(w, headers.ErrInvalidHeaderSizes)
 		}
@@ -360,3 +609,634 @@ func TestClient_Consume(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestClient_ConsumeMessages(t *testing.T) {
+	now := time.Now()
+	var count int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers.SetSizes([]int64{4, 5}, w.Header())
+		switch count {
+		case 0:
+			headers.SetTimestamps([]time.Time{now, now.Add(time.Millisecond)}, w.Header())
+			headers.SetMessageIDs([]headers.MessageID{{}, {1}}, w.Header())
+		case 2:
+			headers.SetTimestamps([]time.Time{now}, w.Header())
+		}
+		count++
+		_, _ = w.Write([]byte("test_body"))
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := c.ConsumeMessages("consume_topic", 0, -1)
+	if err != nil || len(msgs) != 2 || string(msgs[0].Data) != "test" || string(msgs[1].Data) != "_body" {
+		t.Fatal(msgs, err)
+	}
+	if !msgs[0].Timestamp.Equal(now) || !msgs[1].Timestamp.Equal(now.Add(time.Millisecond)) {
+		t.Fatal(msgs)
+	}
+	if msgs[0].ID != "" || msgs[1].ID != "01000000-0000-0000-0000-000000000000" {
+		t.Fatal(msgs)
+	}
+	if msgs[0].Topic != "consume_topic" || msgs[0].Offset != 0 || msgs[1].Offset != 1 {
+		t.Fatal(msgs)
+	}
+
+	// servers without timestamps
+	msgs, err = c.ConsumeMessages("consume_topic", 0, -1)
+	if err != nil || len(msgs) != 2 || !msgs[0].Timestamp.IsZero() {
+		t.Fatal(msgs, err)
+	}
+
+	// mismatched timestamps
+	if _, err = c.ConsumeMessages("consume_topic", 0, -1); err == nil {
+		t.Fatal("expected timestamp count error")
+	}
+}
+
+func TestClient_ConsumePrefix(t *testing.T) {
+	var count int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/topics/orders.*" || r.URL.Query().Get("id") != "1" || r.URL.Query().Get("limit") != "2" ||
+			r.URL.Query().Get("from") != "orders.us:5" || r.Header.Get(headers.HeaderGroup) != "group" {
+			t.Error(r.URL, r.Header)
+		}
+		headers.SetSizes([]int64{4, 5}, w.Header())
+		w.Header()[headers.HeaderTopics] = []string{"orders.eu", "orders.us"}
+		switch count {
+		case 0:
+			w.Header()[headers.HeaderOffsets] = []string{"1", "5"}
+		case 1:
+			w.Header()[headers.HeaderOffsets] = []string{"1"}
+		case 2:
+			w.Header()[headers.HeaderOffsets] = []string{"1", "x"}
+		}
+		count++
+		_, _ = w.Write([]byte("test_body"))
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL), WithConsumerGroup("group"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := c.ConsumePrefix("orders.", 1, map[string]uint64{"orders.us": 5}, 2)
+	if err != nil || len(msgs) != 2 || string(msgs[0].Data) != "test" || string(msgs[1].Data) != "_body" {
+		t.Fatal(msgs, err)
+	}
+	if msgs[0].Topic != "orders.eu" || msgs[0].Offset != 1 || msgs[1].Topic != "orders.us" || msgs[1].Offset != 5 {
+		t.Fatal(msgs)
+	}
+
+	// missing and invalid offsets
+	for i := 0; i < 2; i++ {
+		if _, err = c.ConsumePrefix("orders.", 1, map[string]uint64{"orders.us": 5}, 2); err == nil {
+			t.Fatal("expected offsets error")
+		}
+	}
+}
+
+func TestClient_Replay(t *testing.T) {
+	var fail bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.Method != http.MethodPost || r.URL.Path != "/topics/dst" || query.Get("replay") != "src" ||
+			query.Get("from") != "1" || query.Get("to") != "-1" || query.Get("transform") != "upper" {
+			t.Error(r.Method, r.URL)
+		}
+		if fail {
+			headers.SetError(w, headers.ErrUnknownTransform)
+			return
+		}
+		_, _ = w.Write([]byte(`{"count":3}`))
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := c.Replay("src", "dst", 1, -1, "upper")
+	if err != nil || count != 3 {
+		t.Fatal(count, err)
+	}
+	fail = true
+	if _, err = c.Replay("src", "dst", 1, -1, "upper"); errors.Cause(err) != headers.ErrUnknownTransform {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_ProduceWithIDs(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()[headers.HeaderMessageIDs] = []string{"id1", "id2"}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := c.ProduceWithIDs("produce_topic", []int64{1, 1}, bytes.NewBufferString("ab"))
+	if err != nil || len(ids) != 2 || ids[0] != "id1" || ids[1] != "id2" {
+		t.Fatal(ids, err)
+	}
+}
+
+func TestClient_ProduceTypes(t *testing.T) {
+	dir := ".haraqa-client-types"
+	defer os.RemoveAll(dir)
+	s, err := server.NewServer(server.WithFileQueue([]string{dir}, false, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.CreateTopic("types"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.ProduceTypes("types", []string{"application/json"}, []int64{2, 5}, bytes.NewBufferString("{}hello")); errors.Cause(err) != headers.ErrInvalidContentType {
+		t.Fatal(err)
+	}
+	if _, err = c.ProduceTypes("types", []string{"application/json", ""}, []int64{2, 5}, bytes.NewBufferString("{}hello")); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := c.ConsumeMessages("types", 0, -1)
+	if err != nil || len(msgs) != 2 || msgs[0].ContentType != "application/json" || msgs[1].ContentType != "" || string(msgs[1].Data) != "hello" {
+		t.Fatal(msgs, err)
+	}
+}
+
+func TestClient_ProduceSeq(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(headers.HeaderProducerID) != "producer" || r.Header.Get(headers.HeaderSequence) != "42" {
+			t.Error(r.Header)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	if _, err := NewClient(WithProducerID("")); err == nil {
+		t.Fatal("expected empty producer id error")
+	}
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.ProduceSeq("produce_topic", 42, []int64{1}, bytes.NewBufferString("a")); err == nil {
+		t.Fatal("expected missing producer id error")
+	}
+	c, err = NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL), WithProducerID("producer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.ProduceSeq("produce_topic", 42, []int64{1}, bytes.NewBufferString("a")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_CreateTopics(t *testing.T) {
+	var create []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		create = r.Header[headers.HeaderCreate]
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		opts   []Option
+		header []string
+	}{
+		{nil, nil},
+		{[]Option{WithCreateTopics(true)}, []string{"true"}},
+		{[]Option{WithCreateTopics(false)}, []string{"false"}},
+	} {
+		c, err := NewClient(append(tt.opts, WithHTTPClient(ts.Client()), WithURL(ts.URL))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = c.Produce("produce_topic", []int64{1}, bytes.NewBufferString("a")); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(create, tt.header) {
+			t.Fatal(create, tt.header)
+		}
+	}
+}
+
+func TestClient_Tracing(t *testing.T) {
+	if err := WithTracer(nil)(&Client{}); err == nil {
+		t.Error("expected nil tracer error")
+	}
+
+	parent := tracing.TraceParent{TraceID: tracing.NewTraceID(), SpanID: tracing.NewSpanID(), Flags: 1}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tp, err := tracing.ParseTraceParent(r.Header.Get(tracing.HeaderTraceParent))
+		if err != nil {
+			t.Error(err)
+		}
+		if tp.TraceID != parent.TraceID || tp.SpanID == parent.SpanID {
+			t.Error(tp, parent)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	tracer, err := tracing.NewOTLPTracer(ts.URL, "haraqa-client", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL), WithTracer(tracer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := tracing.ContextWithTraceParent(context.Background(), parent)
+	if err = c.WithContext(ctx).CreateTopic("traced_topic"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClient_ConsumerGroup(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(headers.HeaderGroup) != "test_group" {
+			t.Errorf("invalid group %q", r.Header.Get(headers.HeaderGroup))
+		}
+		headers.SetSizes([]int64{4}, w.Header())
+		_, _ = w.Write([]byte("test"))
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL), WithConsumerGroup("test_group"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := c.ConsumeMsgs("consume_topic", 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || string(msgs[0]) != "test" {
+		t.Error(msgs)
+	}
+}
+
+func TestClient_ConsumeAll(t *testing.T) {
+	var count int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("limit") != "all" || r.URL.Query().Get("id") != "3" {
+			t.Error(r.URL.RawQuery)
+		}
+		defer func() { count++ }()
+		switch count {
+		case 1:
+			headers.SetError(w, headers.ErrNoContent)
+			return
+		case 3:
+			headers.SetError(w, headers.ErrTopicDoesNotExist)
+			return
+		case 4:
+			_, _ = w.Write([]byte("test"))
+			return
+		}
+		mw := multipart.NewWriter(w)
+		w.Header().Set(headers.ContentType, "multipart/mixed; boundary="+mw.Boundary())
+		for i, batch := range []string{"test", "_body"} {
+			part := textproto.MIMEHeader{}
+			if i == 0 {
+				headers.SetSizes([]int64{1, 3}, http.Header(part))
+				part[headers.HeaderOffsets] = []string{"3", "4"}
+			} else {
+				headers.SetSizes([]int64{5}, http.Header(part))
+				part[headers.HeaderOffsets] = []string{"5"}
+			}
+			pw, _ := mw.CreatePart(part)
+			_, _ = pw.Write([]byte(batch))
+			w.(http.Flusher).Flush()
+		}
+		if count == 2 {
+			_, _ = mw.CreatePart(textproto.MIMEHeader{headers.HeaderErrors: []string{"disk failure"}})
+		}
+		_ = mw.Close()
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := c.ConsumeAll("consume_topic", 3)
+	if err != nil || len(msgs) != 3 || string(msgs[0]) != "t" || string(msgs[1]) != "est" || string(msgs[2]) != "_body" {
+		t.Error(msgs, err)
+	}
+	msgs, err = c.ConsumeAll("consume_topic", 3)
+	if err != nil || len(msgs) != 0 {
+		t.Error(msgs, err)
+	}
+
+	// batches are passed to fn as they are read, up to the error ending the stream
+	var batches [][]Message
+	err = c.ConsumeAllFunc("consume_topic", 3, func(batch []Message) error {
+		batches = append(batches, batch)
+		return nil
+	})
+	if err == nil || err.Error() != "error consuming: disk failure" || len(batches) != 2 {
+		t.Error(batches, err)
+	}
+	if batches[0][1].Offset != 4 || string(batches[0][1].Data) != "est" || batches[1][0].Offset != 5 || batches[1][0].Topic != "consume_topic" {
+		t.Error(batches)
+	}
+	if _, err = c.ConsumeAll("consume_topic", 3); !errors.Is(err, headers.ErrTopicDoesNotExist) {
+		t.Error(err)
+	}
+	if _, err = c.ConsumeAll("consume_topic", 3); err == nil {
+		t.Error("expected content type error")
+	}
+
+	// errors returned by fn end the stream
+	errStop := errors.New("stop")
+	if err = c.ConsumeAllFunc("consume_topic", 3, func([]Message) error { return errStop }); err != errStop {
+		t.Error(err)
+	}
+}
+
+func TestClient_Follow(t *testing.T) {
+	var count int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("follow") != "true" || r.URL.Query().Get("id") != "-1" || r.URL.Query().Get("limit") != "10" {
+			t.Error(r.URL.RawQuery)
+		}
+		count++
+		if count == 3 {
+			headers.SetError(w, headers.ErrTopicDoesNotExist)
+			return
+		}
+		mw := multipart.NewWriter(w)
+		w.Header().Set(headers.ContentType, "multipart/mixed; boundary="+mw.Boundary())
+		_, _ = mw.CreatePart(textproto.MIMEHeader{})
+		for i := 0; i < 2; i++ {
+			part := textproto.MIMEHeader{}
+			headers.SetSizes([]int64{3, 2}, http.Header(part))
+			part[headers.HeaderOffsets] = []string{strconv.Itoa(4 + 2*i), strconv.Itoa(5 + 2*i)}
+			pw, _ := mw.CreatePart(part)
+			_, _ = pw.Write([]byte("onetw"))
+		}
+		if count == 1 {
+			_, _ = mw.CreatePart(textproto.MIMEHeader{headers.HeaderErrors: []string{headers.ErrTopicDoesNotExist.Error()}})
+		}
+		_ = mw.Close()
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// batches are passed to fn until the stream ends with an error
+	var msgs []Message
+	err = c.Follow("follow_topic", -1, 10, func(batch []Message) error {
+		msgs = append(msgs, batch...)
+		return nil
+	})
+	if !errors.Is(err, headers.ErrTopicDoesNotExist) || len(msgs) != 4 {
+		t.Fatal(msgs, err)
+	}
+	if msgs[0].Topic != "follow_topic" || msgs[0].Offset != 4 || string(msgs[0].Data) != "one" || msgs[3].Offset != 7 || string(msgs[3].Data) != "tw" {
+		t.Fatal(msgs)
+	}
+
+	// errors returned by fn end the stream
+	errStop := errors.New("stop")
+	if err = c.Follow("follow_topic", -1, 10, func([]Message) error { return errStop }); err != errStop {
+		t.Fatal(err)
+	}
+
+	if err = c.Follow("follow_topic", -1, 10, func([]Message) error { return nil }); !errors.Is(err, headers.ErrTopicDoesNotExist) {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_ConsumeResume(t *testing.T) {
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		headers.SetSizes([]int64{5, 5}, w.Header())
+		switch r.Header.Get("Range") {
+		case "":
+			// fail part way through the body
+			w.Header().Set("Content-Length", "10")
+			_, _ = w.Write([]byte("hel"))
+			w.(http.Flusher).Flush()
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_ = conn.Close()
+		case "bytes=3-":
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write([]byte("loworld"))
+		default:
+			t.Error(r.Header.Get("Range"))
+		}
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := c.ConsumeMsgs("resume_topic", 0, 2)
+	if err != nil || len(msgs) != 2 || string(msgs[0]) != "hello" || string(msgs[1]) != "world" {
+		t.Fatal(msgs, err)
+	}
+	if !reflect.DeepEqual(ranges, []string{"", "bytes=3-"}) {
+		t.Fatal(ranges)
+	}
+}
+
+func TestClient_Watch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/topics/watch_topic/watch" || r.Header.Get("Accept") != "text/event-stream" {
+			t.Error(r.URL.Path, r.Header)
+		}
+		if r.Header.Get("Last-Event-ID") == "" {
+			headers.SetError(w, headers.ErrTopicDoesNotExist)
+			return
+		}
+		_, _ = w.Write([]byte("id: 4\nevent: offset\ndata: {\"topic\":\"watch_topic\",\"maxOffset\":4}\n\n: heartbeat\n\n"))
+		_, _ = w.Write([]byte("id: 6\nevent: offset\ndata: {\"topic\":\"watch_topic\",\"maxOffset\":6}\n\n"))
+		_, _ = w.Write([]byte("event: error\ndata: {\"code\":\"topic_does_not_exist\",\"error\":\"topic does not exist\"}\n\n"))
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets []int64
+	err = c.Watch("watch_topic", 3, func(maxOffset int64) error {
+		offsets = append(offsets, maxOffset)
+		return nil
+	})
+	if !errors.Is(err, headers.ErrTopicDoesNotExist) || !reflect.DeepEqual(offsets, []int64{4, 6}) {
+		t.Fatal(offsets, err)
+	}
+	if err = c.Watch("watch_topic", -1, func(int64) error { return nil }); !errors.Is(err, headers.ErrTopicDoesNotExist) {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_Groups(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/scaling/test_group" {
+			if r.URL.Query().Get("targetLag") != "50" {
+				t.Error(r.URL)
+			}
+			_, _ = w.Write([]byte(`{"group":"test_group","lag":120,"lagRate":1.5,"topics":2,"targetLag":50,"consumers":2}`))
+			return
+		}
+		if r.URL.Path != "/groups/test_group" {
+			t.Error(r.URL.Path)
+		}
+		switch r.Method {
+		case http.MethodPost:
+			var req headers.JoinGroupRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Member != "" || len(req.Topics) != 2 {
+				t.Error(req, err)
+			}
+			_, _ = w.Write([]byte(`{"group":"test_group","member":"m1","generation":2,"topics":["t1"],"offsets":{"t1":4}}`))
+		case http.MethodDelete:
+			if r.URL.Query().Get("member") != "m1" {
+				t.Error(r.URL)
+			}
+			headers.SetError(w, headers.ErrInvalidGroup)
+		case http.MethodPatch:
+			var req headers.ResetRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Topics["t1"].To != headers.ResetEarliest || !req.DryRun {
+				t.Error(req, err)
+			}
+			_, _ = w.Write([]byte(`{"offsets":{"t1":2},"dryRun":true}`))
+		}
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.JoinGroup("", []string{"t1", "t2"}); err == nil {
+		t.Fatal("expected missing group error")
+	}
+	if err = c.LeaveGroup("m1"); err == nil {
+		t.Fatal("expected missing group error")
+	}
+	if _, err = c.ResetOffsets(map[string]headers.OffsetReset{"t1": {To: headers.ResetEarliest}}, true); err == nil {
+		t.Fatal("expected missing group error")
+	}
+	if _, err = c.ScalingHint(0); err == nil {
+		t.Fatal("expected missing group error")
+	}
+
+	c, err = NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL), WithConsumerGroup("test_group"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assignment, err := c.JoinGroup("", []string{"t1", "t2"})
+	if err != nil || assignment.Member != "m1" || assignment.Generation != 2 || len(assignment.Topics) != 1 || assignment.Offsets["t1"] != 4 {
+		t.Fatal(assignment, err)
+	}
+	if err = c.LeaveGroup("m1"); errors.Cause(err) != headers.ErrInvalidGroup {
+		t.Fatal(err)
+	}
+	offsets, err := c.ResetOffsets(map[string]headers.OffsetReset{"t1": {To: headers.ResetEarliest}}, true)
+	if err != nil || offsets["t1"] != 2 {
+		t.Fatal(offsets, err)
+	}
+	hint, err := c.ScalingHint(50)
+	if err != nil || hint.Lag != 120 || hint.LagRate != 1.5 || hint.Consumers != 2 {
+		t.Fatal(hint, err)
+	}
+}
+
+func TestWithTokenSource(t *testing.T) {
+	if _, err := NewClient(WithTokenSource(nil)); err == nil {
+		t.Fatal("expected invalid token source error")
+	}
+
+	tokens := []string{"first", "second"}
+	var received []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusCreated)
+	})
+	c, err := NewClient(WithHandler(handler), WithTokenSource(func() (string, error) {
+		if len(tokens) == 0 {
+			return "", errors.New("no token")
+		}
+		token := tokens[0]
+		tokens = tokens[1:]
+		return token, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = c.CreateTopic("topic"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(received) != 2 || received[0] != "Bearer first" || received[1] != "Bearer second" {
+		t.Fatal(received)
+	}
+	if err = c.CreateTopic("topic"); err == nil {
+		t.Fatal("expected token source error")
+	}
+}
+
+func TestWithRequestSigning(t *testing.T) {
+	if _, err := NewClient(WithRequestSigning("device", "")); err == nil {
+		t.Fatal("expected invalid signing key error")
+	}
+
+	dir := ".haraqa-signing"
+	defer os.RemoveAll(dir)
+	s, err := server.NewServer(server.WithFileQueue([]string{dir}, false, 5000), server.WithRequestSigning(map[string]string{"device": "secret"}, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	c, err := NewClient(WithHandler(s), WithRequestSigning("device", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.CreateTopic("readings"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = c.ProduceMsgs("readings", []byte("reading")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs, err := c.ConsumeMsgs("readings", 0, 10)
+	if err != nil || len(msgs) != 2 {
+		t.Fatal(msgs, err)
+	}
+
+	wrong, err := NewClient(WithHandler(s), WithRequestSigning("device", "wrong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = wrong.ProduceMsgs("readings", []byte("reading")); errors.Cause(err) != headers.ErrUnauthorized {
+		t.Fatal(err)
+	}
+}
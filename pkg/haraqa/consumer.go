@@ -0,0 +1,178 @@
+package haraqa
+
+import (
+	"context"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// ConsumerOption represents a optional function argument to NewConsumer
+type ConsumerOption func(*Consumer) error
+
+// WithRetries calls the handler of a failed message up to n more times, waiting backoff between attempts
+func WithRetries(n int, backoff time.Duration) ConsumerOption {
+	return func(c *Consumer) error {
+		if n < 0 {
+			return errors.New("invalid retries, value must not be negative")
+		}
+		if backoff < 0 {
+			return errors.New("invalid backoff, value must not be negative")
+		}
+		c.retries, c.backoff = n, backoff
+		return nil
+	}
+}
+
+// WithDeadLetterTopic produces messages which still fail after their retries to the topic and continues
+// with the next message. Without a dead letter topic Process returns the handler's error
+func WithDeadLetterTopic(topic string) ConsumerOption {
+	return func(c *Consumer) error {
+		if topic == "" {
+			return errors.New("invalid dead letter topic: topic cannot be empty")
+		}
+		c.deadLetter = topic
+		return nil
+	}
+}
+
+// WithBatchLimit sets the maximum number of messages consumed and committed at once, if n is less than 1
+// the server sets the limit
+func WithBatchLimit(n int) ConsumerOption {
+	return func(c *Consumer) error {
+		c.limit = n
+		return nil
+	}
+}
+
+// WithPollInterval sets how long Process waits before consuming again once it has read every message of
+// the topic, the default is one second
+func WithPollInterval(interval time.Duration) ConsumerOption {
+	return func(c *Consumer) error {
+		if interval <= 0 {
+			return errors.New("invalid poll interval, value must be greater than 0")
+		}
+		c.poll = interval
+		return nil
+	}
+}
+
+// Consumer processes the messages of a topic for the client's consumer group with at-least-once delivery,
+// the group's offset is only committed once the handler has succeeded for each message before it
+type Consumer struct {
+	c          *Client
+	topic      string
+	retries    int
+	backoff    time.Duration
+	deadLetter string
+	limit      int
+	poll       time.Duration
+}
+
+// NewConsumer creates a consumer of the topic. The client must be created with WithConsumerGroup, the
+// consumer starts from the group's committed offset of the topic
+func (c *Client) NewConsumer(topic string, opts ...ConsumerOption) (*Consumer, error) {
+	if c.group == "" {
+		return nil, errors.New("invalid consumer group: group cannot be empty")
+	}
+	if topic == "" {
+		return nil, errors.New("invalid topic: topic cannot be empty")
+	}
+	consumer := &Consumer{
+		c:     c,
+		topic: topic,
+		poll:  time.Second,
+	}
+	for _, opt := range opts {
+		if err := opt(consumer); err != nil {
+			return nil, err
+		}
+	}
+	return consumer, nil
+}
+
+// Process calls fn with each message of the topic in order, from the group's committed offset, until the
+// context is done. The offset of each batch is committed once fn has succeeded for every message in it, so
+// messages are processed again after a restart if their batch was not committed. A message for which fn
+// still fails after its retries is produced to the dead letter topic if one is set, otherwise Process
+// commits the messages before it and returns the error
+func (c *Consumer) Process(ctx context.Context, fn func(msg Message) error) error {
+	description, err := c.c.WithContext(ctx).DescribeGroup()
+	if err != nil {
+		return err
+	}
+	offset := description.Offsets[c.topic]
+
+	// consumes by a client with a group commit the offset as they are read
+	reader := c.c.WithContext(ctx)
+	reader.group = ""
+
+	for {
+		msgs, err := reader.ConsumeMessages(c.topic, uint64(offset), c.limit)
+		if err != nil && errors.Cause(err) != headers.ErrNoContent {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if len(msgs) == 0 {
+			if err = sleep(ctx, c.poll); err != nil {
+				return err
+			}
+			continue
+		}
+
+		next := offset
+		for _, msg := range msgs {
+			if err = c.handle(ctx, msg, fn); err != nil {
+				break
+			}
+			next = int64(msg.Offset) + 1
+		}
+		if next > offset {
+			// commit with the client's context, so processed messages are committed once ctx is done
+			if commitErr := c.c.CommitOffsets(map[string]int64{c.topic: next}); commitErr != nil && err == nil {
+				err = commitErr
+			}
+			offset = next
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handle calls fn with the message, retrying it and then forwarding it to the dead letter topic if it fails
+func (c *Consumer) handle(ctx context.Context, msg Message, fn func(msg Message) error) error {
+	var err error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, c.backoff); err != nil {
+				return err
+			}
+		}
+		if err = fn(msg); err == nil {
+			return nil
+		}
+	}
+	if c.deadLetter == "" {
+		return errors.Wrapf(err, "unable to process message %d of %s", msg.Offset, c.topic)
+	}
+	if err := c.c.WithContext(ctx).ProduceMsgs(c.deadLetter, msg.Data); err != nil {
+		return errors.Wrap(err, "unable to produce to dead letter topic")
+	}
+	return nil
+}
+
+// sleep waits for the duration, returning early with the context's error if it is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
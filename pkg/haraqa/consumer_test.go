@@ -0,0 +1,119 @@
+package haraqa
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+func TestNewConsumer(t *testing.T) {
+	c, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.NewConsumer("topic"); err == nil {
+		t.Error("expected missing group error")
+	}
+	c, err = NewClient(WithConsumerGroup("group"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.NewConsumer(""); err == nil {
+		t.Error("expected missing topic error")
+	}
+	for _, opt := range []ConsumerOption{
+		WithRetries(-1, 0),
+		WithRetries(1, -1),
+		WithDeadLetterTopic(""),
+		WithPollInterval(0),
+	} {
+		if _, err = c.NewConsumer("topic", opt); err == nil {
+			t.Error("expected invalid option error")
+		}
+	}
+	consumer, err := c.NewConsumer("topic", WithRetries(2, time.Second), WithDeadLetterTopic("dlq"), WithBatchLimit(5), WithPollInterval(time.Minute))
+	if err != nil || consumer.retries != 2 || consumer.backoff != time.Second || consumer.deadLetter != "dlq" ||
+		consumer.limit != 5 || consumer.poll != time.Minute {
+		t.Fatal(consumer, err)
+	}
+}
+
+func TestConsumer_Process(t *testing.T) {
+	dir := ".haraqa-consumer"
+	defer os.RemoveAll(dir)
+	s, err := server.NewServer(server.WithFileQueue([]string{dir}, true, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL), WithConsumerGroup("group"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, topic := range []string{"jobs", "dlq"} {
+		if err = c.CreateTopic(topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = c.ProduceMsgs("jobs", []byte("a"), []byte("bad"), []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	failure := errors.New("failed")
+
+	// without a dead letter topic the messages before the failure are committed
+	consumer, err := c.NewConsumer("jobs", WithRetries(1, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var processed []string
+	err = consumer.Process(context.Background(), func(msg Message) error {
+		processed = append(processed, string(msg.Data))
+		if string(msg.Data) == "bad" {
+			return failure
+		}
+		return nil
+	})
+	if errors.Cause(err) != failure || len(processed) != 3 || processed[1] != "bad" || processed[2] != "bad" {
+		t.Fatal(processed, err)
+	}
+	description, err := c.DescribeGroup()
+	if err != nil || description.Offsets["jobs"] != 1 {
+		t.Fatal(description, err)
+	}
+
+	// the failed message is forwarded to the dead letter topic and processing continues from the commit
+	consumer, err = c.NewConsumer("jobs", WithDeadLetterTopic("dlq"), WithPollInterval(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	processed = nil
+	err = consumer.Process(ctx, func(msg Message) error {
+		processed = append(processed, string(msg.Data))
+		if string(msg.Data) == "c" {
+			cancel()
+		}
+		if string(msg.Data) == "bad" {
+			return failure
+		}
+		return nil
+	})
+	if err != context.Canceled || len(processed) != 2 || processed[0] != "bad" || processed[1] != "c" {
+		t.Fatal(processed, err)
+	}
+	if description, err = c.DescribeGroup(); err != nil || description.Offsets["jobs"] != 3 {
+		t.Fatal(description, err)
+	}
+	msgs, err := c.ConsumeMsgs("dlq", 0, -1)
+	if err != nil || len(msgs) != 1 || string(msgs[0]) != "bad" {
+		t.Fatal(msgs, err)
+	}
+}
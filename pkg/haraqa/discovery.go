@@ -0,0 +1,206 @@
+package haraqa
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// WithDiscovery resolves the hostname of the client's url to its addresses, such as the A records of a
+// kubernetes headless service, and spreads requests across them. Requests which cannot connect to an
+// address are retried against the next one, and the hostname is resolved again after the interval.
+// The request's Host header is left as the hostname
+func WithDiscovery(interval time.Duration) Option {
+	return withDiscovery(&discovery{interval: interval})
+}
+
+// WithSRVDiscovery resolves the servers of the client's url from the SRV records of the service, proto
+// and hostname, e.g. _haraqa._tcp.haraqa.default.svc.cluster.local for the service "haraqa" and proto
+// "tcp". Requests are spread across the servers of the lowest priority, the others are only used once
+// those cannot be connected to. With an empty service and proto, the hostname's SRV record is looked up
+// directly. Records are resolved again after the interval
+func WithSRVDiscovery(service, proto string, interval time.Duration) Option {
+	return withDiscovery(&discovery{srv: true, service: service, proto: proto, interval: interval})
+}
+
+func withDiscovery(d *discovery) Option {
+	return func(c *Client) error {
+		if d.interval <= 0 {
+			return errors.New("invalid discovery interval: interval must be greater than 0")
+		}
+		d.resolver = net.DefaultResolver
+		c.discovery = d
+		return nil
+	}
+}
+
+// resolver looks up the addresses of a hostname, it is implemented by *net.Resolver
+type resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// discovery holds the endpoints resolved from the client's hostname, it is shared by a client and its copies
+type discovery struct {
+	resolver resolver
+	srv      bool
+	service  string
+	proto    string
+	interval time.Duration
+
+	mux       sync.Mutex
+	host      string
+	endpoints []string
+	primary   int
+	resolved  time.Time
+	resolving bool
+	next      int
+	failed    map[string]time.Time
+}
+
+// order returns the addresses to send a request for the url to, in the order they should be tried.
+// Requests rotate through the primary endpoints, endpoints which recently failed are tried last. The
+// caller which finds the endpoints out of date resolves them again, others use the previous endpoints
+func (d *discovery) order(ctx context.Context, u *url.URL) []string {
+	if d == nil || net.ParseIP(u.Hostname()) != nil {
+		return nil
+	}
+	d.mux.Lock()
+	if d.host != u.Host {
+		d.host, d.endpoints, d.primary, d.resolved = u.Host, nil, 0, time.Time{}
+	}
+	if !d.resolving && (len(d.endpoints) == 0 || time.Since(d.resolved) >= d.interval) {
+		d.resolving = true
+		d.mux.Unlock()
+		endpoints, primary, err := d.resolve(ctx, u)
+		d.mux.Lock()
+		d.resolving = false
+		if err == nil && d.host == u.Host {
+			d.endpoints, d.primary, d.resolved = endpoints, primary, time.Now()
+		}
+	}
+	defer d.mux.Unlock()
+	if len(d.endpoints) == 0 {
+		return nil
+	}
+
+	next := d.next % d.primary
+	d.next = next + 1
+	order := make([]string, 0, len(d.endpoints))
+	order = append(order, d.endpoints[next:d.primary]...)
+	order = append(order, d.endpoints[:next]...)
+	order = append(order, d.endpoints[d.primary:]...)
+
+	// move recently failed endpoints to the end, keeping their order
+	var failed []string
+	healthy := order[:0]
+	for _, endpoint := range order {
+		if t, ok := d.failed[endpoint]; ok && time.Since(t) < d.interval {
+			failed = append(failed, endpoint)
+			continue
+		}
+		healthy = append(healthy, endpoint)
+	}
+	return append(healthy, failed...)
+}
+
+// resolve looks up the endpoints of the url's hostname, returning them with the number of primary endpoints
+func (d *discovery) resolve(ctx context.Context, u *url.URL) ([]string, int, error) {
+	if !d.srv {
+		addrs, err := d.resolver.LookupHost(ctx, u.Hostname())
+		if err != nil {
+			return nil, 0, err
+		}
+		endpoints := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			endpoints = append(endpoints, joinHostPort(addr, u.Port()))
+		}
+		return endpoints, len(endpoints), nil
+	}
+
+	// records are sorted by priority and randomized by weight
+	_, records, err := d.resolver.LookupSRV(ctx, d.service, d.proto, u.Hostname())
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(records) == 0 {
+		return nil, 0, errors.New("no srv records found")
+	}
+	endpoints := make([]string, 0, len(records))
+	primary := 0
+	for _, record := range records {
+		if record.Priority == records[0].Priority {
+			primary++
+		}
+		endpoints = append(endpoints, joinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))))
+	}
+	return endpoints, primary, nil
+}
+
+// fail records that a request could not connect to the endpoint
+func (d *discovery) fail(endpoint string) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.failed == nil || len(d.failed) > len(d.endpoints) {
+		d.failed = make(map[string]time.Time)
+	}
+	d.failed[endpoint] = time.Now()
+}
+
+// joinHostPort joins the host and port, leaving the port out if it is empty
+func joinHostPort(host, port string) string {
+	if port != "" {
+		return net.JoinHostPort(host, port)
+	}
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// send sends the request to each of the endpoints discovered for its hostname in turn, until one of them
+// can be connected to. Requests are only retried if their body can be sent again
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	endpoints := c.discovery.order(req.Context(), req.URL)
+	if len(endpoints) == 0 {
+		return c.c.Do(req)
+	}
+
+	var resp *http.Response
+	var err error
+	for i, endpoint := range endpoints {
+		r := req.Clone(req.Context())
+		if i > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				break
+			}
+			if r.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		r.URL.Host, r.Host = endpoint, req.URL.Host
+		resp, err = c.c.Do(r)
+		if err == nil || req.Context().Err() != nil || !dialFailed(err) {
+			return resp, err
+		}
+		c.discovery.fail(endpoint)
+	}
+	return resp, err
+}
+
+// dialFailed returns true if the error is from failing to connect to the server, in which case the
+// request was not sent
+func dialFailed(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
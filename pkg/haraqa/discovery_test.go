@@ -0,0 +1,217 @@
+package haraqa
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// staticResolver resolves every hostname to the same addresses and records
+type staticResolver struct {
+	mux     sync.Mutex
+	addrs   []string
+	records []*net.SRV
+}
+
+func (r *staticResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if len(r.addrs) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return r.addrs, nil
+}
+
+func (r *staticResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return name, r.records, nil
+}
+
+func (r *staticResolver) set(addrs []string, records []*net.SRV) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.addrs, r.records = addrs, records
+}
+
+// countingServer counts the requests it receives and the host they were sent to
+func countingServer() (*httptest.Server, *int32, *atomic.Value) {
+	var requests int32
+	var host atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		host.Store(r.Host)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return srv, &requests, &host
+}
+
+// srvRecord returns the record of a server's address
+func srvRecord(t *testing.T, addr string, priority uint16) *net.SRV {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &net.SRV{Target: host + ".", Port: uint16(p), Priority: priority}
+}
+
+func TestWithDiscovery(t *testing.T) {
+	for _, opt := range []Option{WithDiscovery(0), WithSRVDiscovery("haraqa", "tcp", -time.Second)} {
+		if err := opt(&Client{}); err == nil {
+			t.Error("expected invalid discovery interval error")
+		}
+	}
+	c := &Client{}
+	if err := WithSRVDiscovery("haraqa", "tcp", time.Second)(c); err != nil || !c.discovery.srv || c.discovery.service != "haraqa" || c.discovery.proto != "tcp" || c.discovery.interval != time.Second {
+		t.Fatal(c.discovery, err)
+	}
+	if err := WithDiscovery(time.Minute)(c); err != nil || c.discovery.srv || c.discovery.resolver == nil {
+		t.Fatal(c.discovery, err)
+	}
+}
+
+func TestDiscovery_Order(t *testing.T) {
+	var d *discovery
+	u, _ := url.Parse("http://haraqa:4353")
+	if order := d.order(context.Background(), u); order != nil {
+		t.Fatal(order)
+	}
+
+	r := &staticResolver{addrs: []string{"10.0.0.1", "10.0.0.2", "fd00::3"}}
+	d = &discovery{resolver: r, interval: time.Hour}
+	expected := [][]string{
+		{"10.0.0.1:4353", "10.0.0.2:4353", "[fd00::3]:4353"},
+		{"10.0.0.2:4353", "[fd00::3]:4353", "10.0.0.1:4353"},
+		{"[fd00::3]:4353", "10.0.0.1:4353", "10.0.0.2:4353"},
+	}
+	for _, e := range expected {
+		if order := d.order(context.Background(), u); len(order) != 3 || order[0] != e[0] || order[1] != e[1] || order[2] != e[2] {
+			t.Fatal(order)
+		}
+	}
+
+	// failed endpoints are tried last
+	d.fail("10.0.0.1:4353")
+	if order := d.order(context.Background(), u); len(order) != 3 || order[0] != "10.0.0.2:4353" || order[2] != "10.0.0.1:4353" {
+		t.Fatal(order)
+	}
+
+	// addresses are used directly
+	ip, _ := url.Parse("http://127.0.0.1:4353")
+	if order := d.order(context.Background(), ip); order != nil {
+		t.Fatal(order)
+	}
+
+	// srv records of a lower priority are only used for failover
+	r.set(nil, []*net.SRV{{Target: "a.", Port: 1}, {Target: "b.", Port: 2}, {Target: "c.", Port: 3, Priority: 1}})
+	d = &discovery{resolver: r, srv: true, interval: time.Hour}
+	for _, e := range []string{"a:1", "b:2", "a:1"} {
+		if order := d.order(context.Background(), u); len(order) != 3 || order[0] != e || order[2] != "c:3" {
+			t.Fatal(order)
+		}
+	}
+
+	// resolution failures use the hostname
+	r.set(nil, nil)
+	d = &discovery{resolver: r, interval: time.Hour}
+	if order := d.order(context.Background(), u); order != nil {
+		t.Fatal(order)
+	}
+}
+
+func TestClient_Discovery(t *testing.T) {
+	srv, requests, host := countingServer()
+	defer srv.Close()
+	_, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &staticResolver{addrs: []string{"127.0.0.1"}}
+	c, err := NewClient(WithURL("http://haraqa.test:"+port), WithDiscovery(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.discovery.resolver = r
+	if err = c.ProduceMsgs("topic", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(requests) != 1 || host.Load() != "haraqa.test:"+port {
+		t.Fatal(*requests, host.Load())
+	}
+}
+
+func TestClient_SRVDiscovery(t *testing.T) {
+	a, aRequests, _ := countingServer()
+	defer a.Close()
+	b, bRequests, _ := countingServer()
+	defer b.Close()
+	backup, backupRequests, _ := countingServer()
+	defer backup.Close()
+
+	// a listener which has been closed refuses connections
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	_ = l.Close()
+
+	r := &staticResolver{records: []*net.SRV{
+		srvRecord(t, closed, 0),
+		srvRecord(t, a.Listener.Addr().String(), 0),
+		srvRecord(t, b.Listener.Addr().String(), 0),
+		srvRecord(t, backup.Listener.Addr().String(), 1),
+	}}
+	c, err := NewClient(WithURL("http://haraqa.test"), WithSRVDiscovery("haraqa", "tcp", 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.discovery.resolver = r
+
+	// requests fail over from the closed endpoint and are spread across the primary endpoints
+	for i := 0; i < 6; i++ {
+		if err = c.ProduceMsgs("topic", []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, m := atomic.LoadInt32(aRequests), atomic.LoadInt32(bRequests); n+m != 6 || n < 2 || m < 2 || atomic.LoadInt32(backupRequests) != 0 {
+		t.Fatal(n, m, *backupRequests)
+	}
+
+	// the records are resolved again after the interval
+	r.set(nil, []*net.SRV{srvRecord(t, backup.Listener.Addr().String(), 0)})
+	time.Sleep(60 * time.Millisecond)
+	if err = c.WithContext(context.Background()).ProduceMsgs("topic", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(backupRequests); n != 1 {
+		t.Fatal(n)
+	}
+
+	// requests whose body cannot be sent again are not retried
+	r.set(nil, []*net.SRV{srvRecord(t, closed, 0), srvRecord(t, a.Listener.Addr().String(), 0)})
+	time.Sleep(60 * time.Millisecond)
+	var failures int
+	for i := 0; i < 2; i++ {
+		if err = c.Produce("topic", []int64{5}, struct{ io.Reader }{strings.NewReader("hello")}); err != nil {
+			failures++
+		}
+	}
+	if failures != 1 {
+		t.Fatal(failures)
+	}
+}
@@ -0,0 +1,109 @@
+package haraqa
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// WithHandler sends the client's requests to the handler in the same process instead of over the network,
+// such as a server.Server embedded in the application. Responses are streamed as the handler writes them,
+// so Follow and Watch work as they do over http. The url's host is ignored
+func WithHandler(handler http.Handler) Option {
+	return func(c *Client) error {
+		if handler == nil {
+			return errors.New("invalid handler: handler cannot be nil")
+		}
+		c.c = &http.Client{Transport: handlerTransport{handler: handler}}
+		return nil
+	}
+}
+
+// handlerTransport is an http.RoundTripper serving requests with an in process handler
+type handlerTransport struct {
+	handler http.Handler
+}
+
+// RoundTrip serves the request in a new goroutine, returning once the handler writes the response header.
+// The response body is read from the handler as it is written
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/1.1", 1, 1
+	r.RequestURI = req.URL.RequestURI()
+	// in process requests are treated as coming from the loopback address
+	r.RemoteAddr = "127.0.0.1:0"
+	if r.Host == "" {
+		r.Host = req.URL.Host
+	}
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+
+	body, pw := io.Pipe()
+	w := &pipeWriter{
+		header: make(http.Header),
+		body:   pw,
+		ready:  make(chan struct{}),
+		resp:   &http.Response{Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1, Body: body, Request: req},
+	}
+	go func() {
+		defer func() {
+			// like a connection, the body ends with an error if the request was canceled
+			if p := recover(); p != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				_ = pw.CloseWithError(errors.Errorf("handler panic: %v", p))
+			} else {
+				w.WriteHeader(http.StatusOK)
+				_ = pw.CloseWithError(req.Context().Err())
+			}
+			_ = r.Body.Close()
+		}()
+		t.handler.ServeHTTP(w, r)
+	}()
+	<-w.ready
+	return w.resp, nil
+}
+
+// pipeWriter is an http.ResponseWriter writing the response body to a pipe
+type pipeWriter struct {
+	header http.Header
+	body   *io.PipeWriter
+	once   sync.Once
+	ready  chan struct{}
+	resp   *http.Response
+}
+
+func (w *pipeWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader sends the response, the header cannot be changed after it is written
+func (w *pipeWriter) WriteHeader(code int) {
+	if code < 200 {
+		return
+	}
+	w.once.Do(func() {
+		w.resp.StatusCode = code
+		w.resp.Status = strconv.Itoa(code) + " " + http.StatusText(code)
+		w.resp.Header = w.header.Clone()
+		w.resp.ContentLength = -1
+		if length, err := strconv.ParseInt(w.resp.Header.Get("Content-Length"), 10, 64); err == nil {
+			w.resp.ContentLength = length
+		}
+		close(w.ready)
+	})
+}
+
+// Write writes to the response body, blocking until the client reads it
+func (w *pipeWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+// Flush sends the response header, the body is never buffered
+func (w *pipeWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,118 @@
+package haraqa
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+func TestWithHandler(t *testing.T) {
+	if err := WithHandler(nil)(&Client{}); err == nil {
+		t.Error("expected invalid handler error")
+	}
+}
+
+func TestClient_Embedded(t *testing.T) {
+	dir := ".haraqa-embedded"
+	defer os.RemoveAll(dir)
+	s, err := server.NewServer(server.WithFileQueue([]string{dir}, false, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	c, err := NewClient(WithHandler(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.CreateTopic("embedded"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.CreateTopic("embedded"); err == nil {
+		t.Fatal("expected topic exists error")
+	}
+	if err = c.ProduceMsgs("embedded", []byte("hello"), []byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := c.ConsumeMsgs("embedded", 0, -1)
+	if err != nil || len(msgs) != 2 || string(msgs[0]) != "hello" || string(msgs[1]) != "world" {
+		t.Fatal(msgs, err)
+	}
+	info, err := c.InspectTopic("embedded")
+	if err != nil || info.MaxOffset != 1 {
+		t.Fatal(info, err)
+	}
+
+	// follows are streamed as messages are produced
+	errDone := errors.New("done")
+	followed := make(chan error, 1)
+	go func() {
+		followed <- c.Follow("embedded", -1, 10, func(msgs []Message) error {
+			if len(msgs) != 1 || string(msgs[0].Data) != "streamed" {
+				return errors.Errorf("unexpected messages %v", msgs)
+			}
+			return errDone
+		})
+	}()
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("timed out")
+		}
+		if err = c.ProduceMsgs("embedded", []byte("streamed")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err = <-followed:
+		default:
+			continue
+		}
+		break
+	}
+	if errors.Cause(err) != errDone {
+		t.Fatal(err)
+	}
+
+	// requests stop with the client's context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = c.WithContext(ctx).Watch("embedded", 100, func(int64) error { return nil }); err == nil {
+		t.Fatal("expected context error")
+	}
+}
+
+func TestHandlerTransport(t *testing.T) {
+	c, err := NewClient(WithHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/topics/panic" {
+			panic("handler panic")
+		}
+		if r.RemoteAddr != "127.0.0.1:0" || r.RequestURI != "/topics/embedded" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.CreateTopic("embedded"); err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://embedded/topics/panic", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.c.Do(req)
+	if err != nil || resp.StatusCode != http.StatusInternalServerError {
+		t.Fatal(resp, err)
+	}
+	if _, err = ioutil.ReadAll(resp.Body); err == nil || !strings.Contains(err.Error(), "handler panic") {
+		t.Fatal(err)
+	}
+}
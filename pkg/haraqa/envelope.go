@@ -0,0 +1,103 @@
+package haraqa
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/haraqa/haraqa/internal/envelope"
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// ProduceBatch sends the messages to the topic as a protobuf batch envelope, keeping the key, headers and
+// content type of each message. The ids assigned to the messages are returned if the server assigns ids
+func (c *Client) ProduceBatch(topic string, msgs ...Message) ([]string, error) {
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+	batch := make([]envelope.Message, len(msgs))
+	for i := range msgs {
+		batch[i] = envelope.Message{Data: msgs[i].Data, Key: msgs[i].Key, Headers: msgs[i].Headers, ContentType: msgs[i].ContentType}
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPost, c.url+"/topics/"+topic, bytes.NewReader(envelope.Marshal(batch)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header[headers.ContentType] = []string{envelope.ContentType}
+	if c.createTopics != nil {
+		req.Header[headers.HeaderCreate] = []string{strconv.FormatBool(*c.createTopics)}
+	}
+
+	resp, err := c.do(req, "haraqa.ProduceBatch", topic)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		err = headers.ReadErrors(resp.Header)
+		return nil, errors.Wrap(err, "error producing")
+	}
+	return resp.Header[headers.HeaderMessageIDs], nil
+}
+
+// ConsumeBatch reads up to limit messages off of the topic starting from id as a protobuf batch envelope,
+// along with the key, headers, content type, timestamp and id of each message
+func (c *Client) ConsumeBatch(topic string, id uint64, limit int) ([]Message, error) {
+	u := c.url + "/topics/" + topic + "?id=" + strconv.FormatUint(id, 10)
+	if limit > 0 {
+		u += "&limit=" + strconv.Itoa(limit)
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header["Accept"] = []string{envelope.ContentType}
+	if c.group != "" {
+		req.Header[headers.HeaderGroup] = []string{c.group}
+	}
+
+	resp, err := c.do(req, "haraqa.ConsumeBatch", topic)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return nil, errors.Wrap(err, "error consuming")
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	batch, err := envelope.Unmarshal(b)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]Message, len(batch))
+	for i := range batch {
+		msgs[i] = Message{
+			Topic:       batch[i].Topic,
+			Offset:      uint64(batch[i].Offset),
+			Data:        batch[i].Data,
+			Timestamp:   batch[i].Timestamp,
+			ID:          batch[i].ID,
+			ContentType: batch[i].ContentType,
+			Key:         batch[i].Key,
+			Headers:     batch[i].Headers,
+		}
+	}
+	return msgs, nil
+}
+
+// readRecord replaces a message stored as a record with the message it holds
+func readRecord(msg *Message) {
+	if msg.ContentType != envelope.RecordContentType {
+		return
+	}
+	record, err := envelope.FromRecord(msg.Data)
+	if err != nil {
+		return
+	}
+	msg.Data, msg.Key, msg.Headers, msg.ContentType = record.Data, record.Key, record.Headers, record.ContentType
+}
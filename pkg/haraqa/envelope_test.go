@@ -0,0 +1,64 @@
+package haraqa
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+func TestClient_ProduceBatch(t *testing.T) {
+	dir := ".haraqa-client-batch"
+	defer os.RemoveAll(dir)
+	s, err := server.NewServer(server.WithFileQueue([]string{dir}, false, 5000), server.WithMessageIDs(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.CreateTopic("batch"); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := c.ProduceBatch("batch"); err != nil || ids != nil {
+		t.Fatal(ids, err)
+	}
+	ids, err := c.ProduceBatch("batch",
+		Message{Data: []byte("hello"), ContentType: "text/plain"},
+		Message{Data: []byte("world"), Key: []byte("key"), Headers: map[string]string{"source": "test"}},
+	)
+	if err != nil || len(ids) != 2 {
+		t.Fatal(ids, err)
+	}
+	if _, err = c.ProduceBatch("batch", Message{Data: []byte("a"), ContentType: "text"}); errors.Cause(err) != headers.ErrInvalidContentType {
+		t.Fatal(err)
+	}
+
+	check := func(msgs []Message, err error) {
+		t.Helper()
+		if err != nil || len(msgs) != 2 {
+			t.Fatal(msgs, err)
+		}
+		if string(msgs[0].Data) != "hello" || msgs[0].ContentType != "text/plain" || msgs[0].ID != ids[0] || msgs[0].Timestamp.IsZero() {
+			t.Fatal(msgs[0])
+		}
+		if string(msgs[1].Data) != "world" || string(msgs[1].Key) != "key" || msgs[1].Headers["source"] != "test" || msgs[1].ContentType != "" || msgs[1].ID != ids[1] || msgs[1].Offset != 1 {
+			t.Fatal(msgs[1])
+		}
+	}
+	check(c.ConsumeBatch("batch", 0, 10))
+	// records are decoded by the sizes header format too
+	check(c.ConsumeMessages("batch", 0, 10))
+
+	if _, err = c.ConsumeBatch("batch", 2, 10); errors.Cause(err) != headers.ErrNoContent {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,101 @@
+package haraqa
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// TopicKeys returns the versions of the data key of every encrypted topic
+func (c *Client) TopicKeys() ([]headers.TopicKey, error) {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, c.url+"/keys", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req, "haraqa.TopicKeys", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return nil, errors.Wrap(err, "error listing topic keys")
+	}
+	var keys struct {
+		Keys []headers.TopicKey `json:"keys"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return nil, err
+	}
+	return keys.Keys, nil
+}
+
+// RotateTopicKey adds a new version of the topic's data key, which encrypts the messages produced from then
+// on. Existing messages are not rewritten
+func (c *Client) RotateTopicKey(topic string) (*headers.TopicKey, error) {
+	key, err := c.keyRequest(http.MethodPost, "/keys/"+url.PathEscape(topic), "haraqa.RotateTopicKey", topic)
+	return key, errors.Wrap(err, "error rotating topic key")
+}
+
+// ShredTopicKey destroys every version of the topic's data key. The messages of the topic encrypted with
+// it can no longer be read and are consumed as empty messages
+func (c *Client) ShredTopicKey(topic string) (*headers.TopicKey, error) {
+	key, err := c.keyRequest(http.MethodDelete, "/keys/"+url.PathEscape(topic), "haraqa.ShredTopicKey", topic)
+	return key, errors.Wrap(err, "error shredding topic key")
+}
+
+// ShredSubjectKey destroys every version of the subject's data key. The messages produced for the subject
+// with ProduceSubject can no longer be read in any topic and are consumed as empty messages
+func (c *Client) ShredSubjectKey(subject string) (*headers.TopicKey, error) {
+	key, err := c.keyRequest(http.MethodDelete, "/keys?subject="+url.QueryEscape(subject), "haraqa.ShredSubjectKey", "")
+	return key, errors.Wrap(err, "error shredding subject key")
+}
+
+// keyRequest sends a request to a /keys endpoint which responds with a key
+func (c *Client) keyRequest(method, path, op, topic string) (*headers.TopicKey, error) {
+	req, err := http.NewRequestWithContext(c.ctx, method, c.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req, op, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, headers.ReadErrors(resp.Header)
+	}
+	var key headers.TopicKey
+	if err = json.NewDecoder(resp.Body).Decode(&key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// RewrapKeys wraps every topic data key again with the server's current key encryption key, so that an old
+// key encryption key can be retired. It returns the number of keys rewrapped
+func (c *Client) RewrapKeys() (int, error) {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPost, c.url+"/keys", nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.do(req, "haraqa.RewrapKeys", "")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return 0, errors.Wrap(err, "error rewrapping keys")
+	}
+	var result struct {
+		Rewrapped int `json:"rewrapped"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	return result.Rewrapped, nil
+}
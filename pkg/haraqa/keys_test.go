@@ -0,0 +1,81 @@
+package haraqa
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/kms"
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+func TestClient_TopicKeys(t *testing.T) {
+	dir, file := ".haraqa-client-keys", ".haraqa-client-keys.json"
+	defer os.RemoveAll(dir)
+	defer os.Remove(file)
+	kek, err := kms.NewStatic("a", map[string][]byte{"a": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := server.NewServer(server.WithFileQueue([]string{dir}, false, 5000), server.WithEncryption(file, kek))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c, err := NewClient(WithHandler(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.CreateTopic("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.ProduceMsgs("orders", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	key, err := c.RotateTopicKey("orders")
+	if err != nil || key.Topic != "orders" || key.Current != 2 {
+		t.Fatal(key, err)
+	}
+	if err = c.ProduceMsgs("orders", []byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := c.ConsumeMsgs("orders", 0, 2)
+	if err != nil || len(msgs) != 2 || string(msgs[0]) != "hello" || string(msgs[1]) != "world" {
+		t.Fatal(msgs, err)
+	}
+
+	keys, err := c.TopicKeys()
+	if err != nil || len(keys) != 1 || len(keys[0].Versions) != 2 || keys[0].Versions[0].KEK != "a" {
+		t.Fatal(keys, err)
+	}
+	if n, err := c.RewrapKeys(); err != nil || n != 2 {
+		t.Fatal(n, err)
+	}
+
+	if _, err = c.RotateTopicKey("."); errors.Cause(err) != headers.ErrInvalidTopic {
+		t.Fatal(err)
+	}
+	// shredded messages are consumed empty
+	if _, err = c.ProduceSubject("orders", "user/1", []int64{4}, bytes.NewBufferString("mine")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.ProduceSubject("orders", "", []int64{4}, bytes.NewBufferString("mine")); errors.Cause(err) != headers.ErrInvalidSubject {
+		t.Fatal(err)
+	}
+	if key, err = c.ShredSubjectKey("user/1"); err != nil || key.Subject != "user/1" || key.Versions[0].Shredded == nil {
+		t.Fatal(key, err)
+	}
+	if _, err = c.ShredSubjectKey("user/2"); errors.Cause(err) != headers.ErrKeyDoesNotExist {
+		t.Fatal(err)
+	}
+	if key, err = c.ShredTopicKey("orders"); err != nil || len(key.Versions) != 2 || key.Versions[1].Shredded == nil {
+		t.Fatal(key, err)
+	}
+	msgs, err = c.ConsumeMsgs("orders", 0, 3)
+	if err != nil || len(msgs) != 3 || len(msgs[0]) != 0 || len(msgs[2]) != 0 {
+		t.Fatal(msgs, err)
+	}
+}
@@ -0,0 +1,86 @@
+package haraqa
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// ProduceQuotas returns the produce quotas of every topic and principal
+func (c *Client) ProduceQuotas() ([]headers.ProduceQuota, error) {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, c.url+"/quotas", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req, "haraqa.ProduceQuotas", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return nil, errors.Wrap(err, "error listing produce quotas")
+	}
+	var quotas struct {
+		Quotas []headers.ProduceQuota `json:"quotas"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&quotas); err != nil {
+		return nil, err
+	}
+	return quotas.Quotas, nil
+}
+
+// PutProduceQuota sets the produce quota of the quota's topic, or of its principal if the principal is set,
+// replacing any existing quota. Produces beyond the quota are rejected with headers.ErrProduceQuota
+func (c *Client) PutProduceQuota(quota headers.ProduceQuota) error {
+	b, err := json.Marshal(headers.ProduceQuota{MessagesPerSecond: quota.MessagesPerSecond, BytesPerSecond: quota.BytesPerSecond})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPut, c.url+quotaPath(quota.Topic, quota.Principal), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header[headers.ContentType] = []string{"application/json"}
+
+	resp, err := c.do(req, "haraqa.PutProduceQuota", quota.Topic)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		err = headers.ReadErrors(resp.Header)
+		return errors.Wrap(err, "error putting produce quota")
+	}
+	return nil
+}
+
+// DeleteProduceQuota removes the produce quota of the topic, or of the principal if principal is set
+func (c *Client) DeleteProduceQuota(topic, principal string) error {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodDelete, c.url+quotaPath(topic, principal), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, "haraqa.DeleteProduceQuota", topic)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		err = headers.ReadErrors(resp.Header)
+		return errors.Wrap(err, "error deleting produce quota")
+	}
+	return nil
+}
+
+// quotaPath returns the path of the quota of the topic, or of the principal if principal is set
+func quotaPath(topic, principal string) string {
+	if principal != "" {
+		return "/quotas?principal=" + url.QueryEscape(principal)
+	}
+	return "/quotas/" + url.PathEscape(topic)
+}
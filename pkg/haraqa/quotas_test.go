@@ -0,0 +1,61 @@
+package haraqa
+
+import (
+	"os"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+func TestClient_ProduceQuotas(t *testing.T) {
+	dir := ".haraqa-quotas"
+	defer os.RemoveAll(dir)
+	s, err := server.NewServer(server.WithFileQueue([]string{dir}, false, 5000), server.WithProduceQuotas(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	c, err := NewClient(WithHandler(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.CreateTopic("quota"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.PutProduceQuota(headers.ProduceQuota{Topic: "quota", MessagesPerSecond: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.PutProduceQuota(headers.ProduceQuota{Principal: "tenant/1", BytesPerSecond: 1 << 20}); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.PutProduceQuota(headers.ProduceQuota{Topic: "quota"}); errors.Cause(err) != headers.ErrInvalidBodyJSON {
+		t.Fatal(err)
+	}
+	quotas, err := c.ProduceQuotas()
+	if err != nil || len(quotas) != 2 || quotas[0].Topic != "quota" || quotas[1].Principal != "tenant/1" {
+		t.Fatal(quotas, err)
+	}
+
+	if err = c.ProduceMsgs("quota", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.ProduceMsgs("quota", []byte("b")); errors.Cause(err) != headers.ErrProduceQuota {
+		t.Fatal(err)
+	}
+
+	if err = c.DeleteProduceQuota("", "tenant/1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.DeleteProduceQuota("quota", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.DeleteProduceQuota("quota", ""); errors.Cause(err) != headers.ErrQuotaDoesNotExist {
+		t.Fatal(err)
+	}
+	if err = c.ProduceMsgs("quota", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,80 @@
+package haraqa
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// Schedules returns every schedule with the times of its last and next run
+func (c *Client) Schedules() ([]headers.Schedule, error) {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, c.url+"/schedules", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req, "haraqa.Schedules", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return nil, errors.Wrap(err, "error listing schedules")
+	}
+	var schedules struct {
+		Schedules []headers.Schedule `json:"schedules"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&schedules); err != nil {
+		return nil, err
+	}
+	return schedules.Schedules, nil
+}
+
+// PutSchedule adds the schedule, or replaces the schedule with the same name. The server sends the schedule's
+// request to itself whenever its cron expression matches
+func (c *Client) PutSchedule(schedule headers.Schedule) error {
+	name := schedule.Name
+	schedule.Name, schedule.LastRun, schedule.LastStatus, schedule.NextRun = "", nil, 0, nil
+	b, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPut, c.url+"/schedules/"+url.PathEscape(name), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header[headers.ContentType] = []string{"application/json"}
+
+	resp, err := c.do(req, "haraqa.PutSchedule", "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		err = headers.ReadErrors(resp.Header)
+		return errors.Wrap(err, "error putting schedule")
+	}
+	return nil
+}
+
+// DeleteSchedule removes the schedule
+func (c *Client) DeleteSchedule(name string) error {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodDelete, c.url+"/schedules/"+url.PathEscape(name), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, "haraqa.DeleteSchedule", "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		err = headers.ReadErrors(resp.Header)
+		return errors.Wrap(err, "error deleting schedule")
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package haraqa
+
+import (
+	"os"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+func TestClient_Schedules(t *testing.T) {
+	dir := ".haraqa-schedules"
+	defer os.RemoveAll(dir)
+	s, err := server.NewServer(server.WithFileQueue([]string{dir}, false, 5000), server.WithSchedules(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	c, err := NewClient(WithHandler(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.PutSchedule(headers.Schedule{Name: "nightly truncate", Cron: "0 3 * * *", Timezone: "Europe/London",
+		Method: "PATCH", Path: "/topics/logs", Body: `{"truncate":100}`}); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.PutSchedule(headers.Schedule{Name: "invalid", Cron: "0 3 * *", Method: "PATCH", Path: "/topics/logs"}); errors.Cause(err) != headers.ErrInvalidBodyJSON {
+		t.Fatal(err)
+	}
+	schedules, err := c.Schedules()
+	if err != nil || len(schedules) != 1 || schedules[0].Name != "nightly truncate" || schedules[0].NextRun == nil || schedules[0].LastRun != nil {
+		t.Fatal(schedules, err)
+	}
+
+	if err = c.DeleteSchedule("nightly truncate"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.DeleteSchedule("nightly truncate"); errors.Cause(err) != headers.ErrScheduleDoesNotExist {
+		t.Fatal(err)
+	}
+}
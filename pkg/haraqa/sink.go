@@ -0,0 +1,59 @@
+package haraqa
+
+import (
+	"context"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// SinkStore is implemented by applications writing messages to their own storage, such as a database, to
+// make the writes exactly-once. The store keeps the offset of the next message of each topic alongside the
+// written messages, so that a sink which restarts resumes after the last message it wrote
+type SinkStore interface {
+	// Offset returns the offset of the next message of the topic to write, 0 if none have been written
+	Offset(ctx context.Context, topic string) (uint64, error)
+	// Write writes the messages and stores the offset after the last message atomically, such as in a
+	// single database transaction. If the offset cannot be stored the messages must not be written
+	Write(ctx context.Context, topic string, msgs []Message) error
+}
+
+// Sink writes each message of the topic to the store exactly once, until the context is done or a write
+// fails. Messages are consumed from the store's offset rather than the group's, so messages consumed again
+// after a restart or a failed write which was stored are not written twice. The group's offset is committed
+// after each write so that the group's lag can still be monitored
+func (c *Consumer) Sink(ctx context.Context, store SinkStore) error {
+	offset, err := store.Offset(ctx, c.topic)
+	if err != nil {
+		return errors.Wrap(err, "unable to read sink offset")
+	}
+
+	// consumes by a client with a group commit the offset as they are read
+	reader := c.c.WithContext(ctx)
+	reader.group = ""
+
+	for {
+		msgs, err := reader.ConsumeMessages(c.topic, offset, c.limit)
+		if err != nil && errors.Cause(err) != headers.ErrNoContent {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if len(msgs) == 0 {
+			if err = sleep(ctx, c.poll); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// a failed write may still have been stored, calling Sink again resumes from the store's offset
+		if err = store.Write(ctx, c.topic, msgs); err != nil {
+			return errors.Wrap(err, "unable to write to sink")
+		}
+		offset = msgs[len(msgs)-1].Offset + 1
+		if err = c.c.CommitOffsets(map[string]int64{c.topic: int64(offset)}); err != nil {
+			return err
+		}
+	}
+}
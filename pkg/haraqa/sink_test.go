@@ -0,0 +1,90 @@
+package haraqa
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+// memoryStore is a SinkStore which writes messages and offsets together under a lock, as a database
+// would in a transaction
+type memoryStore struct {
+	mux     sync.Mutex
+	offsets map[string]uint64
+	written []string
+	// fail stores the write and then returns an error, as if the response from the database was lost
+	fail bool
+}
+
+func (m *memoryStore) Offset(ctx context.Context, topic string) (uint64, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.offsets[topic], nil
+}
+
+func (m *memoryStore) Write(ctx context.Context, topic string, msgs []Message) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for _, msg := range msgs {
+		m.written = append(m.written, string(msg.Data))
+	}
+	m.offsets[topic] = msgs[len(msgs)-1].Offset + 1
+	if m.fail {
+		return errors.New("connection lost")
+	}
+	return nil
+}
+
+func TestConsumer_Sink(t *testing.T) {
+	dir := ".haraqa-sink"
+	defer os.RemoveAll(dir)
+	s, err := server.NewServer(server.WithFileQueue([]string{dir}, true, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c, err := NewClient(WithHTTPClient(ts.Client()), WithURL(ts.URL), WithConsumerGroup("group"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.CreateTopic("events"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.ProduceMsgs("events", []byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := c.NewConsumer("events", WithBatchLimit(1), WithPollInterval(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first write is stored but reported as failed
+	store := &memoryStore{offsets: make(map[string]uint64), fail: true}
+	if err = consumer.Sink(context.Background(), store); err == nil {
+		t.Fatal("expected write error")
+	}
+
+	// the sink resumes from the store's offset, so the stored message is not written again
+	store.fail = false
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err = consumer.Sink(ctx, store); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	if len(store.written) != 2 || store.written[0] != "a" || store.written[1] != "b" || store.offsets["events"] != 2 {
+		t.Fatal(store.written, store.offsets)
+	}
+	description, err := c.DescribeGroup()
+	if err != nil || description.Offsets["events"] != 2 {
+		t.Fatal(description, err)
+	}
+}
@@ -0,0 +1,79 @@
+package haraqa
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// Users returns the users of the server's user store, without their passwords
+func (c *Client) Users() ([]headers.User, error) {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, c.url+"/users", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req, "haraqa.Users", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = headers.ReadErrors(resp.Header)
+		return nil, errors.Wrap(err, "error listing users")
+	}
+	var users struct {
+		Users []headers.User `json:"users"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&users); err != nil {
+		return nil, err
+	}
+	return users.Users, nil
+}
+
+// PutUser adds the user to the server's user store or replaces the user with the same name, an existing
+// user keeps its password if the password is empty. Users which are not admins can only change their own
+// password
+func (c *Client) PutUser(user headers.User) error {
+	b, err := json.Marshal(headers.User{Password: user.Password, Admin: user.Admin})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPut, c.url+"/users/"+url.PathEscape(user.Name), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header[headers.ContentType] = []string{"application/json"}
+
+	resp, err := c.do(req, "haraqa.PutUser", "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		err = headers.ReadErrors(resp.Header)
+		return errors.Wrap(err, "error putting user")
+	}
+	return nil
+}
+
+// DeleteUser removes the user from the server's user store
+func (c *Client) DeleteUser(name string) error {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodDelete, c.url+"/users/"+url.PathEscape(name), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, "haraqa.DeleteUser", "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		err = headers.ReadErrors(resp.Header)
+		return errors.Wrap(err, "error deleting user")
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package haraqa
+
+import (
+	"os"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+func TestWithBasicAuth(t *testing.T) {
+	if _, err := NewClient(WithBasicAuth("", "pass")); err == nil {
+		t.Fatal("expected invalid user error")
+	}
+	c, err := NewClient(WithBasicAuth("user", "pass"))
+	if err != nil || c.user != "user" || c.password != "pass" {
+		t.Fatal(c, err)
+	}
+}
+
+func TestClient_Users(t *testing.T) {
+	dir := ".haraqa-users"
+	defer os.RemoveAll(dir)
+	s, err := server.NewServer(server.WithFileQueue([]string{dir}, false, 5000), server.WithUserStore("", "root", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	anonymous, err := NewClient(WithHandler(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = anonymous.Users(); errors.Cause(err) != headers.ErrUnauthorized {
+		t.Fatal(err)
+	}
+
+	c, err := NewClient(WithHandler(s), WithBasicAuth("root", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.PutUser(headers.User{Name: "app", Password: "pass"}); err != nil {
+		t.Fatal(err)
+	}
+	users, err := c.Users()
+	if err != nil || len(users) != 2 || users[0].Name != "app" || users[1].Name != "root" {
+		t.Fatal(users, err)
+	}
+
+	app, err := NewClient(WithHandler(s), WithBasicAuth("app", "pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = app.PutUser(headers.User{Name: "app", Password: "changed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = app.DeleteUser("root"); errors.Cause(err) != headers.ErrUnauthorized {
+		t.Fatal(err)
+	}
+
+	if err = c.DeleteUser("app"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.DeleteUser("app"); errors.Cause(err) != headers.ErrUserDoesNotExist {
+		t.Fatal(err)
+	}
+}
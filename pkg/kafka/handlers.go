@@ -0,0 +1,304 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+)
+
+// handleAPIVersions writes the supported api versions
+func (l *Listener) handleAPIVersions(e *encoder, version int16, code int16) {
+	e.int16(code)
+	e.int32(int32(len(supportedVersions)))
+	for _, key := range []int16{apiProduce, apiFetch, apiListOffsets, apiMetadata, apiVersions} {
+		e.int16(key)
+		e.int16(supportedVersions[key][0])
+		e.int16(supportedVersions[key][1])
+	}
+	if version >= 1 {
+		e.int32(0) // throttle time
+	}
+}
+
+// handleMetadata writes the listener as the only broker and a single partition per topic
+func (l *Listener) handleMetadata(ctx context.Context, e *encoder, version int16, d *decoder) {
+	n := d.arrayLen()
+	var topics []string
+	for i := 0; i < n; i++ {
+		topics = append(topics, d.string())
+	}
+	if d.err != nil {
+		return
+	}
+
+	// v0 requests all topics with an empty array, v1 with a null array
+	var listErr error
+	if (version == 0 && n == 0) || n < 0 {
+		topics, listErr = l.q.ListTopics(ctx, "", "", "")
+	}
+
+	host, port := l.addr()
+	e.int32(1)
+	e.int32(0) // node id
+	e.string(host)
+	e.int32(port)
+	if version >= 1 {
+		e.nullString() // rack
+		e.int32(0)     // controller id
+	}
+
+	if listErr != nil {
+		l.logger.Error("unable to list topics", "err", listErr)
+	}
+	e.int32(int32(len(topics)))
+	for _, topic := range topics {
+		_, err := l.q.InspectTopic(ctx, topic)
+		code := errorCode(err)
+		e.int16(code)
+		e.string(topic)
+		if version >= 1 {
+			e.bool(false) // is internal
+		}
+		if code != errNone {
+			e.int32(0)
+			continue
+		}
+		e.int32(1)
+		e.int16(errNone)
+		e.int32(0) // partition
+		e.int32(0) // leader
+		e.int32(1) // replicas
+		e.int32(0)
+		e.int32(1) // in sync replicas
+		e.int32(0)
+	}
+}
+
+// handleProduce appends the message values to each topic. It returns false if the client does not
+// expect a response
+func (l *Listener) handleProduce(ctx context.Context, e *encoder, version int16, d *decoder) bool {
+	acks := d.int16()
+	_ = d.int32() // timeout
+
+	nTopics := d.arrayLen()
+	e.int32(int32(nTopics))
+	for i := 0; i < nTopics && d.err == nil; i++ {
+		topic := d.string()
+		e.string(topic)
+		nPartitions := d.arrayLen()
+		e.int32(int32(nPartitions))
+		for j := 0; j < nPartitions && d.err == nil; j++ {
+			partition := d.int32()
+			set := d.bytes()
+			baseOffset, code := l.produce(ctx, topic, partition, set)
+			e.int32(partition)
+			e.int16(code)
+			e.int64(baseOffset)
+			if version >= 2 {
+				e.int64(-1) // log append time
+			}
+		}
+	}
+	if version >= 1 {
+		e.int32(0) // throttle time
+	}
+	return acks != 0
+}
+
+// produce writes the message set to the topic, returning the offset of the first message.
+// The offset is read before writing and may be inexact if the topic has concurrent producers
+func (l *Listener) produce(ctx context.Context, topic string, partition int32, set []byte) (int64, int16) {
+	if partition != 0 {
+		return -1, errUnknownTopicOrPartition
+	}
+	msgs, err := decodeMessageSet(set)
+	if err != nil {
+		l.logger.Debug("invalid kafka message set", "topic", topic, "err", err)
+		return -1, errCorruptMessage
+	}
+	info, err := l.q.InspectTopic(ctx, topic)
+	if err != nil {
+		return -1, errorCode(err)
+	}
+	if len(msgs) == 0 {
+		return info.MaxOffset + 1, errNone
+	}
+	values := make([][]byte, len(msgs))
+	for i := range msgs {
+		values[i] = msgs[i].value
+	}
+	if err = l.q.ProduceMsgs(ctx, topic, values...); err != nil {
+		return -1, errorCode(err)
+	}
+	return info.MaxOffset + 1, errNone
+}
+
+type fetchPartition struct {
+	partition int32
+	offset    int64
+	maxBytes  int32
+}
+
+type fetchTopic struct {
+	name       string
+	partitions []fetchPartition
+}
+
+// handleFetch writes the messages of each requested partition, waiting up to the max wait time for
+// at least min bytes of messages to be available
+func (l *Listener) handleFetch(ctx context.Context, e *encoder, version int16, d *decoder) {
+	_ = d.int32() // replica id
+	maxWait := time.Duration(d.int32()) * time.Millisecond
+	minBytes := int(d.int32())
+	nTopics := d.arrayLen()
+	topics := make([]fetchTopic, 0, nTopics)
+	for i := 0; i < nTopics && d.err == nil; i++ {
+		t := fetchTopic{name: d.string()}
+		nPartitions := d.arrayLen()
+		for j := 0; j < nPartitions && d.err == nil; j++ {
+			t.partitions = append(t.partitions, fetchPartition{
+				partition: d.int32(),
+				offset:    d.int64(),
+				maxBytes:  d.int32(),
+			})
+		}
+		topics = append(topics, t)
+	}
+	if d.err != nil {
+		return
+	}
+
+	// messages are written with the v0 format for fetch v0 and v1, and with v1 for fetch v2
+	magic := int8(0)
+	if version >= 2 {
+		magic = 1
+	}
+
+	start := len(e.b)
+	deadline := time.Now().Add(maxWait)
+	for {
+		e.b = e.b[:start]
+		if version >= 1 {
+			e.int32(0) // throttle time
+		}
+		total := l.fetch(ctx, e, magic, topics)
+		remaining := time.Until(deadline)
+		if total >= minBytes || remaining <= 0 {
+			return
+		}
+		if remaining > 100*time.Millisecond {
+			remaining = 100 * time.Millisecond
+		}
+		if !l.wait(remaining) {
+			return
+		}
+	}
+}
+
+// fetch writes the fetch response topics and returns the total size of the message sets
+func (l *Listener) fetch(ctx context.Context, e *encoder, magic int8, topics []fetchTopic) int {
+	var total int
+	e.int32(int32(len(topics)))
+	for _, t := range topics {
+		e.string(t.name)
+		e.int32(int32(len(t.partitions)))
+		for _, p := range t.partitions {
+			e.int32(p.partition)
+			code, highWatermark, msgs := l.consume(ctx, t.name, p)
+			e.int16(code)
+			e.int64(highWatermark)
+
+			sizeAt := len(e.b)
+			e.int32(0)
+			for i, msg := range msgs {
+				before := len(e.b)
+				e.message(magic, p.offset+int64(i), msg)
+				// always return at least one message so large messages do not block the consumer
+				if i > 0 && len(e.b)-sizeAt-4 > int(p.maxBytes) {
+					e.b = e.b[:before]
+					break
+				}
+			}
+			size := len(e.b) - sizeAt - 4
+			binary.BigEndian.PutUint32(e.b[sizeAt:], uint32(size))
+			total += size
+		}
+	}
+	return total
+}
+
+// consume reads messages from the partition, returning the error code, high watermark and messages
+func (l *Listener) consume(ctx context.Context, topic string, p fetchPartition) (int16, int64, [][]byte) {
+	if p.partition != 0 {
+		return errUnknownTopicOrPartition, -1, nil
+	}
+	info, err := l.q.InspectTopic(ctx, topic)
+	if err != nil {
+		return errorCode(err), -1, nil
+	}
+	highWatermark := info.MaxOffset + 1
+	if p.offset < info.MinOffset || p.offset > highWatermark {
+		return errOffsetOutOfRange, highWatermark, nil
+	}
+	if p.offset == highWatermark {
+		return errNone, highWatermark, nil
+	}
+	msgs, err := l.q.ConsumeMsgs(ctx, topic, p.offset, fetchLimit)
+	if err != nil {
+		l.logger.Error("unable to consume", "topic", topic, "offset", p.offset, "err", err)
+		return errorCode(err), highWatermark, nil
+	}
+	return errNone, highWatermark, msgs
+}
+
+// handleListOffsets writes the earliest (-2) or latest (-1) offset of each requested partition
+func (l *Listener) handleListOffsets(ctx context.Context, e *encoder, version int16, d *decoder) {
+	_ = d.int32() // replica id
+	nTopics := d.arrayLen()
+	e.int32(int32(nTopics))
+	for i := 0; i < nTopics && d.err == nil; i++ {
+		topic := d.string()
+		e.string(topic)
+		nPartitions := d.arrayLen()
+		e.int32(int32(nPartitions))
+		for j := 0; j < nPartitions && d.err == nil; j++ {
+			partition := d.int32()
+			timestamp := d.int64()
+			if version == 0 {
+				_ = d.int32() // max number of offsets
+			}
+			offset, code := l.listOffset(ctx, topic, partition, timestamp)
+			e.int32(partition)
+			e.int16(code)
+			if version == 0 {
+				if code != errNone {
+					e.int32(0)
+					continue
+				}
+				e.int32(1)
+				e.int64(offset)
+				continue
+			}
+			e.int64(-1) // timestamp
+			e.int64(offset)
+		}
+	}
+}
+
+func (l *Listener) listOffset(ctx context.Context, topic string, partition int32, timestamp int64) (int64, int16) {
+	if partition != 0 {
+		return -1, errUnknownTopicOrPartition
+	}
+	info, err := l.q.InspectTopic(ctx, topic)
+	if err != nil {
+		return -1, errorCode(err)
+	}
+	switch timestamp {
+	case -1:
+		return info.MaxOffset + 1, errNone
+	case -2:
+		return info.MinOffset, errNone
+	}
+	l.logger.Debug("unsupported kafka offset timestamp", "topic", topic, "timestamp", timestamp)
+	return -1, errUnknownServerError
+}
@@ -0,0 +1,307 @@
+// Package kafka implements a listener speaking a subset of the kafka wire protocol on top of a haraqa server,
+// allowing existing kafka clients and tools to produce and fetch messages without code changes.
+//
+// Each haraqa topic is exposed as a kafka topic with a single partition (0) led by the listener itself.
+// The supported apis are ApiVersions (v0-2), Metadata (v0-1), Produce (v0-2), Fetch (v0-2) and
+// ListOffsets (v0-1), using the uncompressed v0 and v1 message formats. Message keys are discarded,
+// only values are stored. Consumer groups and transactions are not supported.
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+// Queue is the subset of the haraqa server used to serve kafka requests, it is implemented by *server.Server
+type Queue interface {
+	ListTopics(ctx context.Context, prefix, suffix, regex string) ([]string, error)
+	InspectTopic(ctx context.Context, topic string) (*headers.TopicInfo, error)
+	ProduceMsgs(ctx context.Context, topic string, msgs ...[]byte) error
+	ConsumeMsgs(ctx context.Context, topic string, id, limit int64) ([][]byte, error)
+}
+
+var _ Queue = &server.Server{}
+
+// maxRequestSize is the largest request accepted before the connection is closed
+const maxRequestSize = 100 << 20
+
+// fetchLimit is the maximum number of messages read from the queue per partition in a fetch request
+const fetchLimit = 1000
+
+// Option represents a optional function argument to NewListener
+type Option func(*Listener) error
+
+// WithAdvertisedAddr sets the host and port returned to clients in metadata responses. It defaults to
+// the address of the net.Listener given to Serve
+func WithAdvertisedAddr(host string, port int) Option {
+	return func(l *Listener) error {
+		if host == "" {
+			return errors.New("host cannot be empty")
+		}
+		if port <= 0 || port > 65535 {
+			return errors.New("invalid port, value must be between 1 and 65535")
+		}
+		l.host, l.port = host, int32(port)
+		return nil
+	}
+}
+
+// WithLogger sets the logger used to report connection errors
+func WithLogger(logger server.Logger) Option {
+	return func(l *Listener) error {
+		if logger == nil {
+			return errors.New("logger cannot be nil")
+		}
+		l.logger = logger
+		return nil
+	}
+}
+
+// Listener serves kafka protocol requests backed by a haraqa queue
+type Listener struct {
+	q      Queue
+	logger server.Logger
+	host   string
+	port   int32
+
+	mux       sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	done      chan struct{}
+	isClosed  bool
+	wg        sync.WaitGroup
+}
+
+// NewListener creates a new kafka listener on top of the queue
+func NewListener(q Queue, opts ...Option) (*Listener, error) {
+	if q == nil {
+		return nil, errors.New("queue cannot be nil")
+	}
+	l := &Listener{
+		q:         q,
+		logger:    noOpLogger{},
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		if err := opt(l); err != nil {
+			return nil, errors.Wrap(err, "invalid option")
+		}
+	}
+	return l, nil
+}
+
+// ListenAndServe listens on the tcp address and serves kafka requests until the listener is closed
+func (l *Listener) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return l.Serve(ln)
+}
+
+// Serve accepts connections on ln and serves kafka requests until the listener is closed
+func (l *Listener) Serve(ln net.Listener) error {
+	l.mux.Lock()
+	if l.isClosed {
+		l.mux.Unlock()
+		_ = ln.Close()
+		return errors.New("listener closed")
+	}
+	l.listeners[ln] = struct{}{}
+	if l.host == "" {
+		if addr, ok := ln.Addr().(*net.TCPAddr); ok {
+			l.host, l.port = addr.IP.String(), int32(addr.Port)
+			if addr.IP.IsUnspecified() {
+				l.host = "localhost"
+			}
+		}
+	}
+	l.mux.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-l.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		l.mux.Lock()
+		if l.isClosed {
+			l.mux.Unlock()
+			_ = conn.Close()
+			return nil
+		}
+		l.conns[conn] = struct{}{}
+		l.wg.Add(1)
+		l.mux.Unlock()
+		go func() {
+			defer l.wg.Done()
+			l.serveConn(conn)
+		}()
+	}
+}
+
+// Close stops all listeners and closes any open connections
+func (l *Listener) Close() error {
+	l.mux.Lock()
+	if !l.isClosed {
+		close(l.done)
+	}
+	l.isClosed = true
+	var err error
+	for ln := range l.listeners {
+		if e := ln.Close(); e != nil {
+			err = e
+		}
+	}
+	for conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.mux.Unlock()
+	l.wg.Wait()
+	return err
+}
+
+func (l *Listener) serveConn(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+		l.mux.Lock()
+		delete(l.conns, conn)
+		l.mux.Unlock()
+	}()
+
+	var sizeBuf [4]byte
+	for {
+		if _, err := io.ReadFull(conn, sizeBuf[:]); err != nil {
+			return
+		}
+		size := int32(binary.BigEndian.Uint32(sizeBuf[:]))
+		if size < 8 || size > maxRequestSize {
+			l.logger.Warn("invalid kafka request size", "remote", conn.RemoteAddr().String(), "size", size)
+			return
+		}
+		req := make([]byte, size)
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+
+		resp, ok := l.handle(req)
+		if !ok {
+			return
+		}
+		if resp == nil {
+			continue
+		}
+		if _, err := conn.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+// handle decodes the request and returns the framed response. A nil response is returned for
+// requests which do not expect one, and false if the connection should be closed
+func (l *Listener) handle(req []byte) ([]byte, bool) {
+	d := &decoder{b: req}
+	apiKey := d.int16()
+	apiVersion := d.int16()
+	correlationID := d.int32()
+	clientID := d.string()
+	if d.err != nil {
+		return nil, false
+	}
+
+	e := &encoder{b: make([]byte, 8, 64)}
+	binary.BigEndian.PutUint32(e.b[4:], uint32(correlationID))
+
+	versions, ok := supportedVersions[apiKey]
+	if apiKey == apiVersions && (!ok || apiVersion > versions[1]) {
+		// respond with the v0 format so the client can retry with a supported version
+		l.handleAPIVersions(e, 0, errUnsupportedVersion)
+		return e.frame(), true
+	}
+	if !ok || apiVersion < versions[0] || apiVersion > versions[1] {
+		l.logger.Warn("unsupported kafka request", "client", clientID, "apiKey", apiKey, "apiVersion", apiVersion)
+		return nil, false
+	}
+
+	ctx := context.Background()
+	switch apiKey {
+	case apiVersions:
+		l.handleAPIVersions(e, apiVersion, errNone)
+	case apiMetadata:
+		l.handleMetadata(ctx, e, apiVersion, d)
+	case apiProduce:
+		if !l.handleProduce(ctx, e, apiVersion, d) {
+			return nil, d.err == nil
+		}
+	case apiFetch:
+		l.handleFetch(ctx, e, apiVersion, d)
+	case apiListOffsets:
+		l.handleListOffsets(ctx, e, apiVersion, d)
+	}
+	if d.err != nil {
+		l.logger.Warn("invalid kafka request", "client", clientID, "apiKey", apiKey, "apiVersion", apiVersion, "err", d.err)
+		return nil, false
+	}
+	return e.frame(), true
+}
+
+// frame sets the size prefix of the response and returns it
+func (e *encoder) frame() []byte {
+	binary.BigEndian.PutUint32(e.b, uint32(len(e.b)-4))
+	return e.b
+}
+
+// errorCode converts a queue error to a kafka error code
+func errorCode(err error) int16 {
+	switch errors.Cause(err) {
+	case nil:
+		return errNone
+	case headers.ErrTopicDoesNotExist, headers.ErrInvalidTopic:
+		return errUnknownTopicOrPartition
+	case headers.ErrInsufficientStorage:
+		return errKafkaStorageError
+	case headers.ErrInvalidMessage:
+		return errInvalidRecord
+	}
+	return errUnknownServerError
+}
+
+func (l *Listener) addr() (string, int32) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.host, l.port
+}
+
+func (l *Listener) wait(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-l.done:
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+var _ server.Logger = noOpLogger{}
+
+type noOpLogger struct{}
+
+func (noOpLogger) Debug(string, ...interface{}) {}
+func (noOpLogger) Info(string, ...interface{})  {}
+func (noOpLogger) Warn(string, ...interface{})  {}
+func (noOpLogger) Error(string, ...interface{}) {}
@@ -0,0 +1,350 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+type testQueue struct {
+	mux    sync.Mutex
+	topics map[string][][]byte
+}
+
+func (q *testQueue) ListTopics(ctx context.Context, prefix, suffix, regex string) ([]string, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	var topics []string
+	for topic := range q.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+func (q *testQueue) InspectTopic(ctx context.Context, topic string) (*headers.TopicInfo, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	msgs, ok := q.topics[topic]
+	if !ok {
+		return nil, headers.ErrTopicDoesNotExist
+	}
+	return &headers.TopicInfo{MinOffset: 0, MaxOffset: int64(len(msgs)) - 1}, nil
+}
+
+func (q *testQueue) ProduceMsgs(ctx context.Context, topic string, msgs ...[]byte) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if _, ok := q.topics[topic]; !ok {
+		return headers.ErrTopicDoesNotExist
+	}
+	q.topics[topic] = append(q.topics[topic], msgs...)
+	return nil
+}
+
+func (q *testQueue) ConsumeMsgs(ctx context.Context, topic string, id, limit int64) ([][]byte, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	msgs, ok := q.topics[topic]
+	if !ok {
+		return nil, headers.ErrTopicDoesNotExist
+	}
+	msgs = msgs[id:]
+	if int64(len(msgs)) > limit {
+		msgs = msgs[:limit]
+	}
+	return msgs, nil
+}
+
+type testClient struct {
+	t           *testing.T
+	conn        net.Conn
+	correlation int32
+}
+
+// send writes a request without waiting for a response
+func (c *testClient) send(key, version int16, body func(e *encoder)) {
+	c.t.Helper()
+	c.correlation++
+	e := &encoder{b: make([]byte, 4)}
+	e.int16(key)
+	e.int16(version)
+	e.int32(c.correlation)
+	e.string("test")
+	body(e)
+	if _, err := c.conn.Write(e.frame()); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// request sends a request and returns a decoder positioned at the start of the response body
+func (c *testClient) request(key, version int16, body func(e *encoder)) *decoder {
+	c.t.Helper()
+	c.send(key, version, body)
+	var size [4]byte
+	if _, err := io.ReadFull(c.conn, size[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	resp := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(c.conn, resp); err != nil {
+		c.t.Fatal(err)
+	}
+	d := &decoder{b: resp}
+	if id := d.int32(); id != c.correlation {
+		c.t.Fatal("unexpected correlation id", id, c.correlation)
+	}
+	return d
+}
+
+func TestNewListener(t *testing.T) {
+	if _, err := NewListener(nil); err == nil {
+		t.Error("expected nil queue error")
+	}
+	for _, opt := range []Option{WithAdvertisedAddr("", 9092), WithAdvertisedAddr("host", 0), WithLogger(nil)} {
+		if _, err := NewListener(&testQueue{}, opt); err == nil {
+			t.Error("expected invalid option error")
+		}
+	}
+}
+
+func TestListener(t *testing.T) {
+	q := &testQueue{topics: map[string][][]byte{"events": nil, "other": {[]byte("x")}}}
+	l, err := NewListener(q, WithAdvertisedAddr("kafka.local", 9092))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- l.Serve(ln) }()
+	defer func() {
+		if err := l.Close(); err != nil {
+			t.Error(err)
+		}
+		if err := <-served; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := &testClient{t: t, conn: conn}
+
+	t.Run("api versions", func(t *testing.T) {
+		d := c.request(apiVersions, 1, func(e *encoder) {})
+		if code := d.int16(); code != errNone {
+			t.Fatal(code)
+		}
+		versions := make(map[int16][2]int16)
+		for n := d.arrayLen(); n > 0; n-- {
+			versions[d.int16()] = [2]int16{d.int16(), d.int16()}
+		}
+		if !reflect.DeepEqual(versions, supportedVersions) || d.int32() != 0 || d.err != nil {
+			t.Fatal(versions, d.err)
+		}
+
+		d = c.request(apiVersions, 3, func(e *encoder) {})
+		if code := d.int16(); code != errUnsupportedVersion {
+			t.Fatal(code)
+		}
+	})
+
+	t.Run("metadata", func(t *testing.T) {
+		d := c.request(apiMetadata, 0, func(e *encoder) { e.int32(0) })
+		if d.arrayLen() != 1 || d.int32() != 0 || d.string() != "kafka.local" || d.int32() != 9092 {
+			t.Fatal("unexpected broker")
+		}
+		var topics []string
+		for n := d.arrayLen(); n > 0; n-- {
+			if code := d.int16(); code != errNone {
+				t.Fatal(code)
+			}
+			topics = append(topics, d.string())
+			if d.arrayLen() != 1 || d.int16() != errNone || d.int32() != 0 || d.int32() != 0 {
+				t.Fatal("unexpected partition")
+			}
+			if d.arrayLen() != 1 || d.int32() != 0 || d.arrayLen() != 1 || d.int32() != 0 {
+				t.Fatal("unexpected replicas")
+			}
+		}
+		if !reflect.DeepEqual(topics, []string{"events", "other"}) || d.err != nil {
+			t.Fatal(topics, d.err)
+		}
+
+		d = c.request(apiMetadata, 1, func(e *encoder) {
+			e.int32(1)
+			e.string("missing")
+		})
+		_, _, _, _ = d.arrayLen(), d.int32(), d.string(), d.int32()
+		if d.string() != "" || d.int32() != 0 {
+			t.Fatal("unexpected rack or controller")
+		}
+		if d.arrayLen() != 1 || d.int16() != errUnknownTopicOrPartition || d.string() != "missing" || d.int8() != 0 || d.arrayLen() != 0 {
+			t.Fatal("expected unknown topic")
+		}
+	})
+
+	produce := func(version int16, acks int16, topic string, values ...string) {
+		c.send(apiProduce, version, func(e *encoder) {
+			e.int16(acks)
+			e.int32(1000)
+			e.int32(1)
+			e.string(topic)
+			e.int32(1)
+			e.int32(0)
+			set := &encoder{}
+			for _, v := range values {
+				set.message(1, 0, []byte(v))
+			}
+			e.bytes(set.b)
+		})
+	}
+	t.Run("produce", func(t *testing.T) {
+		// acks of 0 has no response
+		produce(2, 0, "events", "a")
+
+		d := c.request(apiProduce, 2, func(e *encoder) {
+			e.int16(1)
+			e.int32(1000)
+			e.int32(2)
+			for _, topic := range []string{"events", "missing"} {
+				e.string(topic)
+				e.int32(1)
+				e.int32(0)
+				set := &encoder{}
+				set.message(1, 0, []byte("b"))
+				set.message(1, 0, []byte("c"))
+				e.bytes(set.b)
+			}
+		})
+		if d.arrayLen() != 2 || d.string() != "events" || d.arrayLen() != 1 || d.int32() != 0 {
+			t.Fatal("unexpected response")
+		}
+		if code, offset := d.int16(), d.int64(); code != errNone || offset != 1 || d.int64() != -1 {
+			t.Fatal(code, offset)
+		}
+		if d.string() != "missing" || d.arrayLen() != 1 || d.int32() != 0 || d.int16() != errUnknownTopicOrPartition {
+			t.Fatal("expected unknown topic")
+		}
+		if _, _, throttle := d.int64(), d.int64(), d.int32(); throttle != 0 || d.err != nil || len(d.b) != 0 {
+			t.Fatal(d.err, d.b)
+		}
+		if !reflect.DeepEqual(q.topics["events"], [][]byte{[]byte("a"), []byte("b"), []byte("c")}) {
+			t.Fatal(q.topics["events"])
+		}
+	})
+
+	t.Run("list offsets", func(t *testing.T) {
+		d := c.request(apiListOffsets, 0, func(e *encoder) {
+			e.int32(-1)
+			e.int32(1)
+			e.string("events")
+			e.int32(2)
+			e.int32(0)
+			e.int64(-2)
+			e.int32(1)
+			e.int32(0)
+			e.int64(-1)
+			e.int32(1)
+		})
+		if d.arrayLen() != 1 || d.string() != "events" || d.arrayLen() != 2 {
+			t.Fatal("unexpected response")
+		}
+		for _, expected := range []int64{0, 3} {
+			if d.int32() != 0 || d.int16() != errNone || d.arrayLen() != 1 {
+				t.Fatal("unexpected partition")
+			}
+			if offset := d.int64(); offset != expected {
+				t.Fatal(offset, expected)
+			}
+		}
+
+		d = c.request(apiListOffsets, 1, func(e *encoder) {
+			e.int32(-1)
+			e.int32(1)
+			e.string("events")
+			e.int32(1)
+			e.int32(0)
+			e.int64(-1)
+		})
+		_, _, _, _ = d.arrayLen(), d.string(), d.arrayLen(), d.int32()
+		if code, _, offset := d.int16(), d.int64(), d.int64(); code != errNone || offset != 3 || d.err != nil {
+			t.Fatal(code, offset, d.err)
+		}
+	})
+
+	fetch := func(version int16, offset int64, maxWait, maxBytes int32) (int16, int64, []message) {
+		t.Helper()
+		d := c.request(apiFetch, version, func(e *encoder) {
+			e.int32(-1)
+			e.int32(maxWait)
+			e.int32(1)
+			e.int32(1)
+			e.string("events")
+			e.int32(1)
+			e.int32(0)
+			e.int64(offset)
+			e.int32(maxBytes)
+		})
+		if version >= 1 {
+			_ = d.int32()
+		}
+		if d.arrayLen() != 1 || d.string() != "events" || d.arrayLen() != 1 || d.int32() != 0 {
+			t.Fatal("unexpected response")
+		}
+		code, highWatermark := d.int16(), d.int64()
+		msgs, err := decodeMessageSet(d.bytes())
+		if err != nil || d.err != nil {
+			t.Fatal(err, d.err)
+		}
+		return code, highWatermark, msgs
+	}
+	t.Run("fetch", func(t *testing.T) {
+		code, highWatermark, msgs := fetch(2, 0, 0, 1<<20)
+		if code != errNone || highWatermark != 3 || len(msgs) != 3 {
+			t.Fatal(code, highWatermark, msgs)
+		}
+		for i, v := range []string{"a", "b", "c"} {
+			if msgs[i].offset != int64(i) || string(msgs[i].value) != v {
+				t.Error(msgs[i])
+			}
+		}
+
+		// a max bytes smaller than a message still returns the first message
+		code, _, msgs = fetch(0, 1, 0, 1)
+		if code != errNone || len(msgs) != 1 || msgs[0].offset != 1 || string(msgs[0].value) != "b" {
+			t.Fatal(code, msgs)
+		}
+
+		if code, _, _ = fetch(1, 4, 0, 1<<20); code != errOffsetOutOfRange {
+			t.Fatal(code)
+		}
+
+		start := time.Now()
+		code, _, msgs = fetch(1, 3, 50, 1<<20)
+		if code != errNone || len(msgs) != 0 || time.Since(start) < 50*time.Millisecond {
+			t.Fatal(code, msgs, time.Since(start))
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		c.send(apiFetch, 11, func(e *encoder) {})
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatal(err)
+		}
+	})
+}
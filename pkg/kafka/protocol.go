@@ -0,0 +1,232 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/pkg/errors"
+)
+
+// api keys of the supported requests
+const (
+	apiProduce     int16 = 0
+	apiFetch       int16 = 1
+	apiListOffsets int16 = 2
+	apiMetadata    int16 = 3
+	apiVersions    int16 = 18
+)
+
+// supportedVersions are the inclusive min and max versions of each supported api
+var supportedVersions = map[int16][2]int16{
+	apiProduce:     {0, 2},
+	apiFetch:       {0, 2},
+	apiListOffsets: {0, 1},
+	apiMetadata:    {0, 1},
+	apiVersions:    {0, 2},
+}
+
+// kafka error codes
+const (
+	errNone                    int16 = 0
+	errUnknownServerError      int16 = -1
+	errOffsetOutOfRange        int16 = 1
+	errCorruptMessage          int16 = 2
+	errUnknownTopicOrPartition int16 = 3
+	errUnsupportedVersion      int16 = 35
+	errKafkaStorageError       int16 = 56
+	errInvalidRecord           int16 = 87
+)
+
+var (
+	errShortBuffer       = errors.New("kafka: short buffer")
+	errCompression       = errors.New("kafka: compressed messages are not supported")
+	errUnsupportedFormat = errors.New("kafka: unsupported message format")
+	errInvalidCRC        = errors.New("kafka: invalid message crc")
+)
+
+// decoder reads big endian kafka primitives. The first error is recorded and all further reads return zero values
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = errShortBuffer
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads a string with an int16 length, null strings are returned as empty strings
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+// bytes reads a byte slice with an int32 length, null byte slices are returned as nil
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+// arrayLen reads the length of an array, null arrays are returned as -1
+func (d *decoder) arrayLen() int {
+	n := d.int32()
+	if d.err == nil && int(n) > len(d.b) {
+		// every element is at least one byte, guard against huge allocations
+		d.err = errShortBuffer
+		return 0
+	}
+	return int(n)
+}
+
+// encoder writes big endian kafka primitives
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) int8(v int8) {
+	e.b = append(e.b, byte(v))
+}
+
+func (e *encoder) int16(v int16) {
+	e.b = append(e.b, byte(v>>8), byte(v))
+}
+
+func (e *encoder) int32(v int32) {
+	e.b = append(e.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *encoder) int64(v int64) {
+	e.int32(int32(v >> 32))
+	e.int32(int32(v))
+}
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *encoder) nullString() {
+	e.int16(-1)
+}
+
+func (e *encoder) bytes(b []byte) {
+	if b == nil {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(b)))
+	e.b = append(e.b, b...)
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.int8(1)
+		return
+	}
+	e.int8(0)
+}
+
+// message is a single kafka message of a v0 or v1 message set
+type message struct {
+	offset    int64
+	timestamp int64
+	key       []byte
+	value     []byte
+}
+
+// decodeMessageSet parses a v0 or v1 message set. A partial message at the end of the set is ignored
+func decodeMessageSet(b []byte) ([]message, error) {
+	var msgs []message
+	d := &decoder{b: b}
+	for len(d.b) >= 12 {
+		offset := d.int64()
+		size := d.int32()
+		if size < 0 || int(size) > len(d.b) {
+			break
+		}
+		m := &decoder{b: d.next(int(size))}
+		crc := uint32(m.int32())
+		if m.err == nil && crc32.ChecksumIEEE(m.b) != crc {
+			return nil, errInvalidCRC
+		}
+		magic := m.int8()
+		attributes := m.int8()
+		if magic > 1 {
+			return nil, errUnsupportedFormat
+		}
+		if attributes&0x07 != 0 {
+			return nil, errCompression
+		}
+		msg := message{offset: offset, timestamp: -1}
+		if magic == 1 {
+			msg.timestamp = m.int64()
+		}
+		msg.key = m.bytes()
+		msg.value = m.bytes()
+		if m.err != nil {
+			return nil, m.err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// encodeMessage appends a message of the given magic version to the message set
+func (e *encoder) message(magic int8, offset int64, value []byte) {
+	e.int64(offset)
+	sizeAt := len(e.b)
+	e.int32(0)
+	crcAt := len(e.b)
+	e.int32(0)
+	e.int8(magic)
+	e.int8(0)
+	if magic == 1 {
+		e.int64(-1)
+	}
+	e.bytes(nil)
+	e.bytes(value)
+	binary.BigEndian.PutUint32(e.b[sizeAt:], uint32(len(e.b)-crcAt))
+	binary.BigEndian.PutUint32(e.b[crcAt:], crc32.ChecksumIEEE(e.b[crcAt+4:]))
+}
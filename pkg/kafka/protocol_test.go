@@ -0,0 +1,92 @@
+package kafka
+
+import (
+	"bytes"
+	"hash/crc32"
+	"testing"
+)
+
+func TestEncoderDecoder(t *testing.T) {
+	e := &encoder{}
+	e.int8(-1)
+	e.int16(-2)
+	e.int32(-3)
+	e.int64(-4)
+	e.string("topic")
+	e.nullString()
+	e.bytes([]byte("value"))
+	e.bytes(nil)
+	e.bool(true)
+	e.int32(2)
+
+	d := &decoder{b: e.b}
+	if d.int8() != -1 || d.int16() != -2 || d.int32() != -3 || d.int64() != -4 {
+		t.Fatal("unexpected integer")
+	}
+	if d.string() != "topic" || d.string() != "" {
+		t.Fatal("unexpected string")
+	}
+	if !bytes.Equal(d.bytes(), []byte("value")) || d.bytes() != nil {
+		t.Fatal("unexpected bytes")
+	}
+	if d.int8() != 1 {
+		t.Fatal("unexpected bool")
+	}
+	// the array length is larger than the remaining buffer
+	if d.arrayLen() != 0 || d.err != errShortBuffer {
+		t.Fatal(d.err)
+	}
+	if d.int64() != 0 || d.string() != "" {
+		t.Fatal("expected zero values after an error")
+	}
+}
+
+func TestMessageSet(t *testing.T) {
+	for _, magic := range []int8{0, 1} {
+		e := &encoder{}
+		e.message(magic, 5, []byte("hello"))
+		e.message(magic, 6, nil)
+		e.message(magic, 7, []byte("partial"))
+
+		msgs, err := decodeMessageSet(e.b[:len(e.b)-3])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != 2 {
+			t.Fatal(msgs)
+		}
+		if msgs[0].offset != 5 || string(msgs[0].value) != "hello" || msgs[0].key != nil || msgs[0].timestamp != -1 {
+			t.Error(msgs[0])
+		}
+		if msgs[1].offset != 6 || msgs[1].value != nil {
+			t.Error(msgs[1])
+		}
+	}
+
+	e := &encoder{}
+	e.message(1, 0, []byte("hello"))
+	corrupt := append([]byte{}, e.b...)
+	corrupt[len(corrupt)-1] = 'O'
+	if _, err := decodeMessageSet(corrupt); err != errInvalidCRC {
+		t.Error(err)
+	}
+
+	// magic byte is at offset 16, attributes at 17. Rewrite the crc after each change
+	for _, test := range []struct {
+		at    int
+		value byte
+		err   error
+	}{
+		{16, 2, errUnsupportedFormat},
+		{17, 1, errCompression},
+	} {
+		b := append([]byte{}, e.b...)
+		b[test.at] = test.value
+		fixed := &encoder{b: b[:12]}
+		fixed.int32(int32(crc32.ChecksumIEEE(b[16:])))
+		fixed.b = append(fixed.b, b[16:]...)
+		if _, err := decodeMessageSet(fixed.b); err != test.err {
+			t.Error(test.at, err)
+		}
+	}
+}
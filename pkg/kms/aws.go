@@ -0,0 +1,97 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/sigv4"
+	"github.com/pkg/errors"
+)
+
+// AWS wraps keys with a symmetric AWS KMS key. The id of a wrapped key is the ARN of the KMS key which
+// wrapped it. KMS keeps the versions of a key when it is rotated, so keys only need to be rewrapped when
+// moving to a different KMS key
+type AWS struct {
+	keyID    string
+	region   string
+	endpoint *url.URL
+	creds    sigv4.Credentials
+	client   *http.Client
+}
+
+// NewAWS creates an AWS key wrapper using the KMS key, given by its id, ARN or alias, in the region.
+// Credentials must be given with WithCredentials
+func NewAWS(keyID, region string, opts ...Option) (*AWS, error) {
+	if keyID == "" {
+		return nil, errors.New("kms key id cannot be empty")
+	}
+	if region == "" {
+		return nil, errors.New("region cannot be empty")
+	}
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if o.accessKey == "" {
+		return nil, errors.New("credentials are required")
+	}
+	a := &AWS{
+		keyID:    keyID,
+		region:   region,
+		endpoint: o.endpoint,
+		creds:    sigv4.Credentials{AccessKey: o.accessKey, SecretKey: o.secretKey, SessionToken: o.sessionToken},
+		client:   o.client,
+	}
+	if a.endpoint == nil {
+		a.endpoint = &url.URL{Scheme: "https", Host: "kms." + region + ".amazonaws.com", Path: "/"}
+	}
+	return a, nil
+}
+
+func (a *AWS) call(ctx context.Context, action string, body, response interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, a.endpoint.String(), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	sigv4.Sign(req, a.creds, a.region, "kms", sigv4.SHA256Hex(b), time.Now())
+	return errors.Wrapf(postJSON(a.client, req, response), "unable to call kms %s", action)
+}
+
+// WrapKey encrypts the key with the KMS key
+func (a *AWS) WrapKey(ctx context.Context, key []byte) ([]byte, string, error) {
+	var resp struct {
+		CiphertextBlob []byte
+		KeyId          string
+	}
+	err := a.call(ctx, "Encrypt", map[string]interface{}{"KeyId": a.keyID, "Plaintext": key}, &resp)
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.CiphertextBlob, resp.KeyId, nil
+}
+
+// UnwrapKey decrypts the key with the KMS key which wrapped it
+func (a *AWS) UnwrapKey(ctx context.Context, wrapped []byte, kekID string) ([]byte, error) {
+	var resp struct {
+		Plaintext []byte
+	}
+	body := map[string]interface{}{"CiphertextBlob": wrapped}
+	if kekID != "" {
+		body["KeyId"] = kekID
+	}
+	if err := a.call(ctx, "Decrypt", body, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
@@ -0,0 +1,98 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewAWS(t *testing.T) {
+	creds := WithCredentials("id", "secret", "")
+	if _, err := NewAWS("", "us-east-1", creds); err == nil {
+		t.Fatal("expected missing key id")
+	}
+	if _, err := NewAWS("alias/haraqa", "", creds); err == nil {
+		t.Fatal("expected missing region")
+	}
+	if _, err := NewAWS("alias/haraqa", "us-east-1"); err == nil {
+		t.Fatal("expected missing credentials")
+	}
+	if _, err := NewAWS("alias/haraqa", "us-east-1", creds, WithEndpoint("kms")); err == nil {
+		t.Fatal("expected invalid option")
+	}
+	a, err := NewAWS("alias/haraqa", "eu-west-1", creds)
+	if err != nil || a.endpoint.String() != "https://kms.eu-west-1.amazonaws.com/" {
+		t.Fatal(a, err)
+	}
+}
+
+func TestAWS(t *testing.T) {
+	const arn = "arn:aws:kms:us-east-1:111122223333:key/1234abcd"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=id/") ||
+			!strings.Contains(r.Header.Get("Authorization"), "/us-east-1/kms/aws4_request") ||
+			r.Header.Get("Content-Type") != "application/x-amz-json-1.1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.Encrypt":
+			if body["KeyId"] != "alias/haraqa" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"__type":"NotFoundException","message":"key not found"}`))
+				return
+			}
+			// the fake ciphertext is the plaintext reversed
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"CiphertextBlob": reverse(body["Plaintext"].(string)), "KeyId": arn})
+		case "TrentService.Decrypt":
+			if body["KeyId"] != arn {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"Plaintext": reverse(body["CiphertextBlob"].(string)), "KeyId": arn})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	a, err := NewAWS("alias/haraqa", "us-east-1", WithCredentials("id", "secret", "token"), WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("0123456789abcdef0123456789abcdef")
+	wrapped, id, err := a.WrapKey(ctx, key)
+	if err != nil || id != arn || bytes.Equal(wrapped, key) {
+		t.Fatal(wrapped, id, err)
+	}
+	if unwrapped, err := a.UnwrapKey(ctx, wrapped, id); err != nil || !bytes.Equal(unwrapped, key) {
+		t.Fatal(unwrapped, err)
+	}
+	if _, err = a.UnwrapKey(ctx, wrapped, "other"); err == nil {
+		t.Fatal("expected error")
+	}
+
+	a.keyID = "alias/missing"
+	if _, _, err = a.WrapKey(ctx, key); err == nil || !strings.Contains(err.Error(), "NotFoundException") {
+		t.Fatal(err)
+	}
+}
+
+// reverse reverses the bytes of the base64 encoded value, returning them base64 encoded
+func reverse(encoded string) []byte {
+	var b []byte
+	_ = json.Unmarshal([]byte(`"`+encoded+`"`), &b)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
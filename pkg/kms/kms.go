@@ -0,0 +1,173 @@
+// Package kms wraps the data keys of an encrypting haraqa server with a key encryption key (KEK). Each type
+// is a server.KeyWrapper, passed to server.WithEncryption: Static holds the KEKs in memory, Vault uses the
+// transit secrets engine of HashiCorp Vault and AWS uses AWS KMS.
+//
+// The id returned with a wrapped key names the KEK version which wrapped it, so that the keys wrapped by an
+// old KEK can be found and rewrapped once the KEK is rotated.
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+var (
+	_ server.KeyWrapper = &Static{}
+	_ server.KeyWrapper = &Vault{}
+	_ server.KeyWrapper = &AWS{}
+)
+
+// Option represents a optional function argument to NewVault and NewAWS
+type Option func(*options) error
+
+type options struct {
+	client       *http.Client
+	mount        string
+	endpoint     *url.URL
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// WithHTTPClient sets the client used to send requests
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) error {
+		if client == nil {
+			return errors.New("http client cannot be nil")
+		}
+		o.client = client
+		return nil
+	}
+}
+
+// WithMount sets the path the Vault transit secrets engine is mounted at, the default is transit
+func WithMount(mount string) Option {
+	return func(o *options) error {
+		if mount == "" {
+			return errors.New("mount cannot be empty")
+		}
+		o.mount = mount
+		return nil
+	}
+}
+
+// WithCredentials sets the access key, secret key and optional session token AWS requests are signed with
+func WithCredentials(accessKey, secretKey, sessionToken string) Option {
+	return func(o *options) error {
+		if accessKey == "" || secretKey == "" {
+			return errors.New("access key and secret key cannot be empty")
+		}
+		o.accessKey, o.secretKey, o.sessionToken = accessKey, secretKey, sessionToken
+		return nil
+	}
+}
+
+// WithEndpoint sets the url of the AWS KMS endpoint, such as a VPC endpoint or a local emulator
+func WithEndpoint(endpoint string) Option {
+	return func(o *options) error {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return errors.Wrap(err, "invalid endpoint")
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Errorf("invalid endpoint %q, an http or https url is required", endpoint)
+		}
+		o.endpoint = u
+		return nil
+	}
+}
+
+func newOptions(opts []Option) (*options, error) {
+	o := &options{client: http.DefaultClient, mount: "transit"}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, errors.Wrap(err, "invalid option")
+		}
+	}
+	return o, nil
+}
+
+// postJSON posts the request as json and decodes the json response, returning an error with the body of any
+// response which is not a 2xx
+func postJSON(client *http.Client, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(b))
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Static wraps keys with AES-GCM under KEKs held in memory. Keys are wrapped with the current KEK and
+// unwrapped with the KEK they were wrapped with, so a new KEK can be made current while the old ones are
+// kept until every key has been rewrapped
+type Static struct {
+	current string
+	keks    map[string]cipher.AEAD
+}
+
+// NewStatic creates a Static key wrapper from the KEKs, which map ids to 16, 24 or 32 byte AES keys. The
+// current KEK wraps new keys
+func NewStatic(current string, keks map[string][]byte) (*Static, error) {
+	s := &Static{current: current, keks: make(map[string]cipher.AEAD, len(keks))}
+	for id, key := range keks {
+		if id == "" {
+			return nil, errors.New("kek id cannot be empty")
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid kek %q", id)
+		}
+		if s.keks[id], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := s.keks[current]; !ok {
+		return nil, errors.Errorf("current kek %q is not one of the keks", current)
+	}
+	return s, nil
+}
+
+// WrapKey encrypts the key with the current KEK
+func (s *Static) WrapKey(ctx context.Context, key []byte) ([]byte, string, error) {
+	aead := s.keks[s.current]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(key)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, "", errors.Wrap(err, "unable to generate nonce")
+	}
+	return aead.Seal(nonce, nonce, key, []byte(s.current)), s.current, nil
+}
+
+// UnwrapKey decrypts the key with the KEK it was wrapped with
+func (s *Static) UnwrapKey(ctx context.Context, wrapped []byte, kekID string) ([]byte, error) {
+	aead, ok := s.keks[kekID]
+	if !ok {
+		return nil, errors.Errorf("unknown kek %q", kekID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key is too short")
+	}
+	key, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(kekID))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to unwrap key with kek %q", kekID)
+	}
+	return key, nil
+}
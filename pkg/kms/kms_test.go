@@ -0,0 +1,65 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestStatic(t *testing.T) {
+	old, current := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 16)
+	if _, err := NewStatic("a", map[string][]byte{"a": []byte("short")}); err == nil {
+		t.Fatal("expected invalid kek")
+	}
+	if _, err := NewStatic("b", map[string][]byte{"a": old}); err == nil {
+		t.Fatal("expected missing current kek")
+	}
+	if _, err := NewStatic("", map[string][]byte{"": old}); err == nil {
+		t.Fatal("expected empty kek id")
+	}
+	ctx := context.Background()
+	s, err := NewStatic("a", map[string][]byte{"a": old})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("0123456789abcdef0123456789abcdef")
+	wrapped, id, err := s.WrapKey(ctx, key)
+	if err != nil || id != "a" || bytes.Contains(wrapped, key) {
+		t.Fatal(wrapped, id, err)
+	}
+
+	// a new current kek still unwraps keys wrapped by the old one
+	s, err = NewStatic("b", map[string][]byte{"a": old, "b": current})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unwrapped, err := s.UnwrapKey(ctx, wrapped, id); err != nil || !bytes.Equal(unwrapped, key) {
+		t.Fatal(unwrapped, err)
+	}
+	if _, err = s.UnwrapKey(ctx, wrapped, "b"); err == nil {
+		t.Fatal("expected error unwrapping with the wrong kek")
+	}
+	if _, err = s.UnwrapKey(ctx, wrapped, "c"); err == nil {
+		t.Fatal("expected unknown kek")
+	}
+	if _, err = s.UnwrapKey(ctx, wrapped[:4], "a"); err == nil {
+		t.Fatal("expected short key")
+	}
+	if _, id, err = s.WrapKey(ctx, key); err != nil || id != "b" {
+		t.Fatal(id, err)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	for _, opt := range []Option{WithHTTPClient(nil), WithMount(""), WithCredentials("", "secret", ""), WithEndpoint("kms"), WithEndpoint(":")} {
+		if _, err := newOptions([]Option{opt}); err == nil {
+			t.Fatal("expected invalid option")
+		}
+	}
+	client := &http.Client{}
+	o, err := newOptions([]Option{WithHTTPClient(client), WithMount("keys"), WithCredentials("id", "secret", "token"), WithEndpoint("http://127.0.0.1:4566")})
+	if err != nil || o.client != client || o.mount != "keys" || o.sessionToken != "token" || o.endpoint.Host != "127.0.0.1:4566" {
+		t.Fatal(o, err)
+	}
+}
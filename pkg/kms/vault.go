@@ -0,0 +1,100 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Vault wraps keys with a key of the transit secrets engine of HashiCorp Vault. The id of a wrapped key is
+// the mount, key name and key version, e.g. transit/haraqa:v2, so keys wrapped before the transit key was
+// rotated can be found
+type Vault struct {
+	addr   *url.URL
+	token  string
+	key    string
+	mount  string
+	client *http.Client
+}
+
+// NewVault creates a Vault key wrapper using the transit key at the Vault address, authenticating with
+// the token
+func NewVault(addr, token, key string, opts ...Option) (*Vault, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid vault address")
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.Errorf("invalid vault address %q, an http or https url is required", addr)
+	}
+	if token == "" {
+		return nil, errors.New("vault token cannot be empty")
+	}
+	if key == "" || strings.Contains(key, "/") {
+		return nil, errors.Errorf("invalid transit key %q", key)
+	}
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Vault{addr: u, token: token, key: key, mount: strings.Trim(o.mount, "/"), client: o.client}, nil
+}
+
+func (v *Vault) post(ctx context.Context, op string, body, response interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	u := *v.addr
+	u.Path = strings.TrimRight(u.Path, "/") + "/v1/" + v.mount + "/" + op + "/" + url.PathEscape(v.key)
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", v.token)
+	return errors.Wrapf(postJSON(v.client, req, response), "unable to %s with vault", op)
+}
+
+// WrapKey encrypts the key with the latest version of the transit key
+func (v *Vault) WrapKey(ctx context.Context, key []byte) ([]byte, string, error) {
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	err := v.post(ctx, "encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(key)}, &resp)
+	if err != nil {
+		return nil, "", err
+	}
+	// ciphertexts have the form vault:v<version>:<base64>
+	parts := strings.SplitN(resp.Data.Ciphertext, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" {
+		return nil, "", errors.New("unexpected vault ciphertext")
+	}
+	return []byte(resp.Data.Ciphertext), v.mount + "/" + v.key + ":" + parts[1], nil
+}
+
+// UnwrapKey decrypts the key with the version of the transit key named in its ciphertext
+func (v *Vault) UnwrapKey(ctx context.Context, wrapped []byte, kekID string) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := v.post(ctx, "decrypt", map[string]string{"ciphertext": string(wrapped)}, &resp); err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid vault plaintext")
+	}
+	return key, nil
+}
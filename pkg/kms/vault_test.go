@@ -0,0 +1,93 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// transit is a fake of the vault transit engine which "encrypts" by base64 encoding the plaintext again
+func transit(t *testing.T, version *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/haraqa":
+			ciphertext := "vault:v" + string(rune('0'+*version)) + ":" + base64.StdEncoding.EncodeToString([]byte(body["plaintext"]))
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"ciphertext": ciphertext}})
+		case "/v1/transit/decrypt/haraqa":
+			parts := strings.SplitN(body["ciphertext"], ":", 3)
+			plaintext, err := base64.StdEncoding.DecodeString(parts[2])
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"plaintext": string(plaintext)}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestNewVault(t *testing.T) {
+	if _, err := NewVault("vault", "token", "haraqa"); err == nil {
+		t.Fatal("expected invalid address")
+	}
+	if _, err := NewVault("http://127.0.0.1:8200", "", "haraqa"); err == nil {
+		t.Fatal("expected missing token")
+	}
+	if _, err := NewVault("http://127.0.0.1:8200", "token", "a/b"); err == nil {
+		t.Fatal("expected invalid key")
+	}
+	if _, err := NewVault("http://127.0.0.1:8200", "token", "haraqa", WithMount("")); err == nil {
+		t.Fatal("expected invalid option")
+	}
+}
+
+func TestVault(t *testing.T) {
+	version := 1
+	srv := transit(t, &version)
+	defer srv.Close()
+	ctx := context.Background()
+	v, err := NewVault(srv.URL, "token", "haraqa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("0123456789abcdef0123456789abcdef")
+	wrapped, id, err := v.WrapKey(ctx, key)
+	if err != nil || id != "transit/haraqa:v1" || !strings.HasPrefix(string(wrapped), "vault:v1:") {
+		t.Fatal(string(wrapped), id, err)
+	}
+	if unwrapped, err := v.UnwrapKey(ctx, wrapped, id); err != nil || !bytes.Equal(unwrapped, key) {
+		t.Fatal(unwrapped, err)
+	}
+
+	// the id follows the version of the transit key
+	version = 2
+	if _, id, err = v.WrapKey(ctx, key); err != nil || id != "transit/haraqa:v2" {
+		t.Fatal(id, err)
+	}
+
+	v, err = NewVault(srv.URL, "wrong", "haraqa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = v.WrapKey(ctx, key); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatal(err)
+	}
+	if _, err = v.UnwrapKey(ctx, wrapped, id); err == nil {
+		t.Fatal("expected error")
+	}
+}
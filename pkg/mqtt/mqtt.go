@@ -0,0 +1,202 @@
+// Package mqtt implements an MQTT 3.1.1 listener on top of a haraqa server, mapping MQTT topics to haraqa topics
+// so devices can publish to and subscribe from the queue directly.
+//
+// Published messages are appended to the haraqa topic of the same name, acknowledged once written for QoS 1
+// and 2. Subscriptions, including + and # wildcards, receive messages produced after subscribing and are
+// always granted QoS 0. Haraqa topic names are lower case, so topics are matched case insensitively.
+// Retained messages and persistent sessions are not supported.
+package mqtt
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+// Queue is the subset of the haraqa server used to serve mqtt clients, it is implemented by *server.Server
+type Queue interface {
+	CreateTopic(ctx context.Context, topic string) error
+	ListTopics(ctx context.Context, prefix, suffix, regex string) ([]string, error)
+	InspectTopic(ctx context.Context, topic string) (*headers.TopicInfo, error)
+	ProduceMsgs(ctx context.Context, topic string, msgs ...[]byte) error
+	ConsumeMsgs(ctx context.Context, topic string, id, limit int64) ([][]byte, error)
+}
+
+var _ Queue = &server.Server{}
+
+// Option represents a optional function argument to NewListener
+type Option func(*Listener) error
+
+// WithAutoCreateTopics creates haraqa topics which do not exist when they are first published to
+func WithAutoCreateTopics(autoCreate bool) Option {
+	return func(l *Listener) error {
+		l.autoCreate = autoCreate
+		return nil
+	}
+}
+
+// WithPollInterval sets how often subscribed topics are checked for new messages
+func WithPollInterval(interval time.Duration) Option {
+	return func(l *Listener) error {
+		if interval <= 0 {
+			return errors.New("invalid interval, value must be greater than 0")
+		}
+		l.pollInterval = interval
+		return nil
+	}
+}
+
+// WithLogger sets the logger used to report connection errors
+func WithLogger(logger server.Logger) Option {
+	return func(l *Listener) error {
+		if logger == nil {
+			return errors.New("logger cannot be nil")
+		}
+		l.logger = logger
+		return nil
+	}
+}
+
+// Listener serves mqtt clients backed by a haraqa queue
+type Listener struct {
+	q            Queue
+	logger       server.Logger
+	autoCreate   bool
+	pollInterval time.Duration
+
+	mux       sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	done      chan struct{}
+	isClosed  bool
+	wg        sync.WaitGroup
+}
+
+// NewListener creates a new mqtt listener on top of the queue
+func NewListener(q Queue, opts ...Option) (*Listener, error) {
+	if q == nil {
+		return nil, errors.New("queue cannot be nil")
+	}
+	l := &Listener{
+		q:            q,
+		logger:       noOpLogger{},
+		pollInterval: 100 * time.Millisecond,
+		listeners:    make(map[net.Listener]struct{}),
+		conns:        make(map[net.Conn]struct{}),
+		done:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		if err := opt(l); err != nil {
+			return nil, errors.Wrap(err, "invalid option")
+		}
+	}
+	return l, nil
+}
+
+// ListenAndServe listens on the tcp address and serves mqtt clients until the listener is closed
+func (l *Listener) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return l.Serve(ln)
+}
+
+// Serve accepts connections on ln and serves mqtt clients until the listener is closed
+func (l *Listener) Serve(ln net.Listener) error {
+	l.mux.Lock()
+	if l.isClosed {
+		l.mux.Unlock()
+		_ = ln.Close()
+		return errors.New("listener closed")
+	}
+	l.listeners[ln] = struct{}{}
+	l.mux.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-l.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		l.mux.Lock()
+		if l.isClosed {
+			l.mux.Unlock()
+			_ = conn.Close()
+			return nil
+		}
+		l.conns[conn] = struct{}{}
+		l.wg.Add(1)
+		l.mux.Unlock()
+		go func() {
+			defer l.wg.Done()
+			l.serveConn(conn)
+		}()
+	}
+}
+
+// Close stops all listeners and closes any open connections
+func (l *Listener) Close() error {
+	l.mux.Lock()
+	if !l.isClosed {
+		close(l.done)
+	}
+	l.isClosed = true
+	var err error
+	for ln := range l.listeners {
+		if e := ln.Close(); e != nil {
+			err = e
+		}
+	}
+	for conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.mux.Unlock()
+	l.wg.Wait()
+	return err
+}
+
+func (l *Listener) serveConn(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+		l.mux.Lock()
+		delete(l.conns, conn)
+		l.mux.Unlock()
+	}()
+
+	s := newSession(l, conn)
+	if err := s.serve(); err != nil {
+		l.logger.Debug("mqtt connection closed", "remote", conn.RemoteAddr().String(), "client", s.clientID, "err", err)
+	}
+}
+
+// publish appends the message to the topic, creating the topic if auto creation is enabled
+func (l *Listener) publish(ctx context.Context, topic string, msg []byte) error {
+	err := l.q.ProduceMsgs(ctx, topic, msg)
+	if errors.Cause(err) != headers.ErrTopicDoesNotExist || !l.autoCreate {
+		return err
+	}
+	err = l.q.CreateTopic(ctx, topic)
+	if err != nil && errors.Cause(err) != headers.ErrTopicAlreadyExists {
+		return err
+	}
+	return l.q.ProduceMsgs(ctx, topic, msg)
+}
+
+var _ server.Logger = noOpLogger{}
+
+type noOpLogger struct{}
+
+func (noOpLogger) Debug(string, ...interface{}) {}
+func (noOpLogger) Info(string, ...interface{})  {}
+func (noOpLogger) Warn(string, ...interface{})  {}
+func (noOpLogger) Error(string, ...interface{}) {}
@@ -0,0 +1,248 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+type testQueue struct {
+	mux    sync.Mutex
+	topics map[string][][]byte
+}
+
+func (q *testQueue) CreateTopic(ctx context.Context, topic string) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if _, ok := q.topics[topic]; ok {
+		return headers.ErrTopicAlreadyExists
+	}
+	q.topics[topic] = [][]byte{}
+	return nil
+}
+
+func (q *testQueue) ListTopics(ctx context.Context, prefix, suffix, regex string) ([]string, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	var topics []string
+	for topic := range q.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+func (q *testQueue) InspectTopic(ctx context.Context, topic string) (*headers.TopicInfo, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	msgs, ok := q.topics[topic]
+	if !ok {
+		return nil, headers.ErrTopicDoesNotExist
+	}
+	return &headers.TopicInfo{MinOffset: 0, MaxOffset: int64(len(msgs)) - 1}, nil
+}
+
+func (q *testQueue) ProduceMsgs(ctx context.Context, topic string, msgs ...[]byte) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if _, ok := q.topics[topic]; !ok {
+		return headers.ErrTopicDoesNotExist
+	}
+	q.topics[topic] = append(q.topics[topic], msgs...)
+	return nil
+}
+
+func (q *testQueue) ConsumeMsgs(ctx context.Context, topic string, id, limit int64) ([][]byte, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	msgs, ok := q.topics[topic]
+	if !ok {
+		return nil, headers.ErrTopicDoesNotExist
+	}
+	msgs = msgs[id:]
+	if int64(len(msgs)) > limit {
+		msgs = msgs[:limit]
+	}
+	return msgs, nil
+}
+
+func (q *testQueue) messages(topic string) [][]byte {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return q.topics[topic]
+}
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, addr string, flags byte, payload []byte) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = appendUint16(body, 60)
+	c.send(packetConnect, 0, append(body, payload...))
+	p := c.read()
+	if p.kind != packetConnack || len(p.body) != 2 || p.body[1] != connAccepted {
+		t.Fatal(p)
+	}
+	return c
+}
+
+func (c *testClient) send(kind, flags byte, body []byte) {
+	c.t.Helper()
+	if _, err := c.conn.Write(encodePacket(kind, flags, body)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) read() packet {
+	c.t.Helper()
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	p, err := readPacket(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return p
+}
+
+func (c *testClient) expect(kind byte, body []byte) {
+	c.t.Helper()
+	p := c.read()
+	if p.kind != kind || !bytes.Equal(p.body, body) {
+		c.t.Fatal(p, kind, body)
+	}
+}
+
+func publishBody(topic string, id uint16, msg string) []byte {
+	body := appendString(nil, topic)
+	if id > 0 {
+		body = appendUint16(body, id)
+	}
+	return append(body, msg...)
+}
+
+func TestNewListener(t *testing.T) {
+	if _, err := NewListener(nil); err == nil {
+		t.Error("expected nil queue error")
+	}
+	for _, opt := range []Option{WithPollInterval(0), WithLogger(nil)} {
+		if _, err := NewListener(&testQueue{}, opt); err == nil {
+			t.Error("expected invalid option error")
+		}
+	}
+}
+
+func TestListener(t *testing.T) {
+	q := &testQueue{topics: map[string][][]byte{"sensors/a": {[]byte("old")}, "other": nil}}
+	l, err := NewListener(q, WithAutoCreateTopics(true), WithPollInterval(5*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- l.Serve(ln) }()
+	defer func() {
+		if err := l.Close(); err != nil {
+			t.Error(err)
+		}
+		if err := <-served; err != nil {
+			t.Error(err)
+		}
+	}()
+	addr := ln.Addr().String()
+
+	// an unsupported protocol level is refused
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+	refused.send(packetConnect, 0, append(appendString(nil, "MQTT"), 5, 0x02, 0, 60, 0, 0))
+	refused.expect(packetConnack, []byte{0, connBadProtocolVersion})
+	_ = conn.Close()
+
+	sub := dial(t, addr, 0x02, appendString(nil, "sub"))
+	defer sub.conn.Close()
+	subscribe := append(appendString(appendUint16(nil, 1), "Sensors/+"), 1)
+	subscribe = append(appendString(subscribe, "a#"), 0)
+	sub.send(packetSubscribe, 0x02, subscribe)
+	sub.expect(packetSuback, []byte{0, 1, 0, subackFailure})
+
+	// will topic "status", will message "gone"
+	will := append(appendString(nil, "pub"), appendString(nil, "status")...)
+	will = append(will, appendString(nil, "gone")...)
+	pub := dial(t, addr, 0x06, will)
+	pub.send(packetPublish, 0, publishBody("sensors/a", 0, "qos0"))
+	pub.send(packetPublish, 0x02, publishBody("sensors/a", 7, "qos1"))
+	pub.expect(packetPuback, []byte{0, 7})
+	pub.send(packetPublish, 0x04, publishBody("sensors/B", 8, "qos2"))
+	pub.expect(packetPubrec, []byte{0, 8})
+	// a retransmitted QoS 2 publish is not stored twice
+	pub.send(packetPublish, 0x0c, publishBody("sensors/B", 8, "qos2"))
+	pub.expect(packetPubrec, []byte{0, 8})
+	pub.send(packetPubrel, 0x02, []byte{0, 8})
+	pub.expect(packetPubcomp, []byte{0, 8})
+	pub.send(packetPingreq, 0, nil)
+	pub.expect(packetPingresp, nil)
+
+	if !reflect.DeepEqual(q.messages("sensors/a"), [][]byte{[]byte("old"), []byte("qos0"), []byte("qos1")}) {
+		t.Fatal(q.messages("sensors/a"))
+	}
+	if !reflect.DeepEqual(q.messages("sensors/b"), [][]byte{[]byte("qos2")}) {
+		t.Fatal(q.messages("sensors/b"))
+	}
+
+	// existing topics start at the end, new topics from the beginning
+	received := make(map[string][]string)
+	for i := 0; i < 3; i++ {
+		p := sub.read()
+		if p.kind != packetPublish || p.flags != 0 {
+			t.Fatal(p)
+		}
+		d := &decoder{b: p.body}
+		topic := d.string()
+		received[topic] = append(received[topic], string(d.b))
+	}
+	expected := map[string][]string{"sensors/a": {"qos0", "qos1"}, "sensors/b": {"qos2"}}
+	if !reflect.DeepEqual(received, expected) {
+		t.Fatal(received)
+	}
+
+	sub.send(packetUnsubscribe, 0x02, appendString(appendUint16(nil, 2), "sensors/+"))
+	sub.expect(packetUnsuback, []byte{0, 2})
+
+	// closing without a disconnect publishes the will
+	_ = pub.conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(q.messages("status")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !reflect.DeepEqual(q.messages("status"), [][]byte{[]byte("gone")}) {
+		t.Fatal(q.messages("status"))
+	}
+
+	sub.send(packetDisconnect, 0, nil)
+	_ = sub.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := readPacket(sub.r); err == nil {
+		t.Fatal("expected closed connection")
+	}
+}
@@ -0,0 +1,71 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestPackets(t *testing.T) {
+	for _, size := range []int{0, 127, 128, 16383, 16384, 2097152} {
+		body := bytes.Repeat([]byte{'a'}, size)
+		b := encodePacket(packetPublish, 0x03, body)
+		p, err := readPacket(bufio.NewReader(bytes.NewReader(b)))
+		if err != nil {
+			t.Fatal(size, err)
+		}
+		if p.kind != packetPublish || p.flags != 0x03 || !bytes.Equal(p.body, body) {
+			t.Fatal(size, p.kind, p.flags, len(p.body))
+		}
+	}
+
+	// remaining length must be at most 4 bytes
+	if _, err := readPacket(bufio.NewReader(bytes.NewReader([]byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01}))); err != errMalformedPacket {
+		t.Error(err)
+	}
+
+	d := &decoder{b: appendString(appendUint16([]byte{7}, 513), "topic")}
+	if d.byte() != 7 || d.uint16() != 513 || d.string() != "topic" || d.err != nil {
+		t.Fatal(d.err)
+	}
+	if d.uint16() != 0 || d.err != errMalformedPacket {
+		t.Fatal(d.err)
+	}
+}
+
+func TestTopics(t *testing.T) {
+	for topic, valid := range map[string]bool{"a/b": true, "": false, "a/+": false, "a/#": false} {
+		if validTopicName(topic) != valid {
+			t.Error(topic)
+		}
+	}
+	for filter, valid := range map[string]bool{
+		"a/b": true, "+": true, "#": true, "a/+/c": true, "a/#": true, "+/+": true,
+		"": false, "a/#/c": false, "a#": false, "a/b+": false,
+	} {
+		if validTopicFilter(filter) != valid {
+			t.Error(filter)
+		}
+	}
+	for _, test := range []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"+/b", "a/b", true},
+		{"#", "a/b", true},
+		{"#", "$sys/a", false},
+		{"+/a", "$sys/a", false},
+		{"$sys/#", "$sys/a", true},
+		{"a/b/c", "a/b", false},
+	} {
+		if matchTopic(test.filter, test.topic) != test.match {
+			t.Error(test.filter, test.topic)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// connectTimeout is how long a new connection has to send its CONNECT packet
+const connectTimeout = 10 * time.Second
+
+// listInterval is how often topics are listed to find new matches for wildcard subscriptions
+const listInterval = time.Second
+
+// consumeLimit is the maximum number of messages read from a topic at once
+const consumeLimit = 100
+
+// session is the state of a single client connection
+type session struct {
+	l    *Listener
+	conn net.Conn
+	r    *bufio.Reader
+	wmux sync.Mutex
+
+	clientID  string
+	keepAlive time.Duration
+	willTopic string
+	willMsg   []byte
+	hasWill   bool
+
+	// received QoS 2 packet ids awaiting a PUBREL
+	pubrel map[uint16]struct{}
+
+	mux      sync.Mutex
+	filters  map[string]struct{}
+	offsets  map[string]int64
+	lastList time.Time
+}
+
+func newSession(l *Listener, conn net.Conn) *session {
+	return &session{
+		l:       l,
+		conn:    conn,
+		r:       bufio.NewReader(conn),
+		pubrel:  make(map[uint16]struct{}),
+		filters: make(map[string]struct{}),
+		offsets: make(map[string]int64),
+	}
+}
+
+func (s *session) write(kind, flags byte, body []byte) error {
+	s.wmux.Lock()
+	defer s.wmux.Unlock()
+	_, err := s.conn.Write(encodePacket(kind, flags, body))
+	return err
+}
+
+// serve handles the connection until the client disconnects or a protocol error occurs
+func (s *session) serve() error {
+	ctx := context.Background()
+	_ = s.conn.SetReadDeadline(time.Now().Add(connectTimeout))
+	p, err := readPacket(s.r)
+	if err != nil {
+		return err
+	}
+	if p.kind != packetConnect {
+		return errors.New("expected connect packet")
+	}
+	code, err := s.connect(p)
+	if err != nil {
+		return err
+	}
+	if err = s.write(packetConnack, 0, []byte{0, code}); err != nil {
+		return err
+	}
+	if code != connAccepted {
+		return errors.Errorf("connection refused with code %d", code)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.poll(ctx, done)
+	}()
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	disconnected := false
+	defer func() {
+		if !disconnected && s.hasWill {
+			if err := s.l.publish(ctx, s.willTopic, s.willMsg); err != nil {
+				s.l.logger.Warn("unable to publish mqtt will", "client", s.clientID, "topic", s.willTopic, "err", err)
+			}
+		}
+	}()
+
+	for {
+		deadline := time.Time{}
+		if s.keepAlive > 0 {
+			deadline = time.Now().Add(s.keepAlive * 3 / 2)
+		}
+		_ = s.conn.SetReadDeadline(deadline)
+		p, err := readPacket(s.r)
+		if err != nil {
+			return err
+		}
+		switch p.kind {
+		case packetPublish:
+			err = s.handlePublish(ctx, p)
+		case packetPubrel:
+			err = s.handlePubrel(p)
+		case packetSubscribe:
+			err = s.handleSubscribe(ctx, p)
+		case packetUnsubscribe:
+			err = s.handleUnsubscribe(p)
+		case packetPingreq:
+			err = s.write(packetPingresp, 0, nil)
+		case packetDisconnect:
+			disconnected = true
+			return nil
+		case packetPuback, packetPubrec, packetPubcomp:
+			// messages are only delivered with QoS 0, there is nothing to acknowledge
+		default:
+			err = errors.Errorf("unexpected packet type %d", p.kind)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// connect parses the CONNECT packet and returns the connack return code
+func (s *session) connect(p packet) (byte, error) {
+	d := &decoder{b: p.body}
+	name := d.string()
+	level := d.byte()
+	flags := d.byte()
+	s.keepAlive = time.Duration(d.uint16()) * time.Second
+	s.clientID = d.string()
+	if d.err != nil {
+		return 0, d.err
+	}
+	if !(name == "MQTT" && level == 4) && !(name == "MQIsdp" && level == 3) {
+		return connBadProtocolVersion, nil
+	}
+	if flags&0x01 != 0 {
+		return 0, errMalformedPacket
+	}
+	if s.clientID == "" && flags&0x02 == 0 {
+		return connIdentifierRejected, nil
+	}
+	if flags&0x04 != 0 {
+		s.willTopic = strings.ToLower(d.string())
+		s.willMsg = d.bytes()
+		s.hasWill = true
+		if d.err == nil && !validTopicName(s.willTopic) {
+			return 0, errors.New("invalid will topic")
+		}
+	}
+	if flags&0x80 != 0 {
+		_ = d.string() // username
+	}
+	if flags&0x40 != 0 {
+		_ = d.bytes() // password
+	}
+	return connAccepted, d.err
+}
+
+func (s *session) handlePublish(ctx context.Context, p packet) error {
+	qos := (p.flags >> 1) & 0x03
+	if qos > 2 {
+		return errors.New("invalid publish qos")
+	}
+	d := &decoder{b: p.body}
+	topic := strings.ToLower(d.string())
+	var id uint16
+	if qos > 0 {
+		id = d.uint16()
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if !validTopicName(topic) || strings.HasPrefix(topic, "$") {
+		return errors.Errorf("invalid publish topic %q", topic)
+	}
+
+	// a retransmitted QoS 2 message has already been stored
+	if _, ok := s.pubrel[id]; ok && qos == 2 {
+		return s.write(packetPubrec, 0, appendUint16(nil, id))
+	}
+	if err := s.l.publish(ctx, topic, d.b); err != nil {
+		s.l.logger.Warn("unable to publish mqtt message", "client", s.clientID, "topic", topic, "err", err)
+		return err
+	}
+	switch qos {
+	case 1:
+		return s.write(packetPuback, 0, appendUint16(nil, id))
+	case 2:
+		s.pubrel[id] = struct{}{}
+		return s.write(packetPubrec, 0, appendUint16(nil, id))
+	}
+	return nil
+}
+
+func (s *session) handlePubrel(p packet) error {
+	d := &decoder{b: p.body}
+	id := d.uint16()
+	if d.err != nil || p.flags != 0x02 {
+		return errMalformedPacket
+	}
+	delete(s.pubrel, id)
+	return s.write(packetPubcomp, 0, appendUint16(nil, id))
+}
+
+func (s *session) handleSubscribe(ctx context.Context, p packet) error {
+	d := &decoder{b: p.body}
+	id := d.uint16()
+	if p.flags != 0x02 || len(d.b) == 0 {
+		return errMalformedPacket
+	}
+	var filters []string
+	codes := appendUint16(nil, id)
+	for len(d.b) > 0 && d.err == nil {
+		filter := strings.ToLower(d.string())
+		_ = d.byte() // requested qos, always granted 0
+		if !validTopicFilter(filter) {
+			codes = append(codes, subackFailure)
+			continue
+		}
+		codes = append(codes, 0)
+		filters = append(filters, filter)
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	// subscriptions receive messages produced after subscribing
+	s.mux.Lock()
+	var topics, list []string
+	listed := false
+	for _, filter := range filters {
+		s.filters[filter] = struct{}{}
+		if !hasWildcard(filter) {
+			topics = append(topics, filter)
+			continue
+		}
+		if !listed {
+			var err error
+			if list, err = s.l.q.ListTopics(ctx, "", "", ""); err != nil {
+				s.l.logger.Error("unable to list topics", "err", err)
+			}
+			listed, s.lastList = true, time.Now()
+		}
+		for _, topic := range list {
+			if matchTopic(filter, topic) {
+				topics = append(topics, topic)
+			}
+		}
+	}
+	for _, topic := range topics {
+		if _, ok := s.offsets[topic]; ok {
+			continue
+		}
+		s.offsets[topic] = 0
+		if info, err := s.l.q.InspectTopic(ctx, topic); err == nil {
+			s.offsets[topic] = info.MaxOffset + 1
+		}
+	}
+	s.mux.Unlock()
+
+	return s.write(packetSuback, 0, codes)
+}
+
+func (s *session) handleUnsubscribe(p packet) error {
+	d := &decoder{b: p.body}
+	id := d.uint16()
+	if p.flags != 0x02 || len(d.b) == 0 {
+		return errMalformedPacket
+	}
+	s.mux.Lock()
+	for len(d.b) > 0 && d.err == nil {
+		delete(s.filters, strings.ToLower(d.string()))
+	}
+	for topic := range s.offsets {
+		if !s.matches(topic) {
+			delete(s.offsets, topic)
+		}
+	}
+	s.mux.Unlock()
+	if d.err != nil {
+		return d.err
+	}
+	return s.write(packetUnsuback, 0, appendUint16(nil, id))
+}
+
+// matches returns true if any subscription matches the topic, s.mux must be held
+func (s *session) matches(topic string) bool {
+	for filter := range s.filters {
+		if matchTopic(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// poll periodically delivers new messages on subscribed topics until done is closed
+func (s *session) poll(ctx context.Context, done chan struct{}) {
+	ticker := time.NewTicker(s.l.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-s.l.done:
+			return
+		case <-ticker.C:
+			if err := s.deliver(ctx); err != nil {
+				// closing the connection stops the read loop
+				_ = s.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// deliver publishes any new messages on subscribed topics to the client
+func (s *session) deliver(ctx context.Context) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	wildcards := false
+	for filter := range s.filters {
+		wildcards = wildcards || hasWildcard(filter)
+	}
+	if wildcards && time.Since(s.lastList) >= listInterval {
+		topics, err := s.l.q.ListTopics(ctx, "", "", "")
+		if err != nil {
+			s.l.logger.Error("unable to list topics", "err", err)
+		}
+		for _, topic := range topics {
+			// topics created after subscribing are read from the beginning
+			if _, ok := s.offsets[topic]; !ok && s.matches(topic) {
+				s.offsets[topic] = 0
+			}
+		}
+		s.lastList = time.Now()
+	}
+
+	for topic, offset := range s.offsets {
+		info, err := s.l.q.InspectTopic(ctx, topic)
+		if err != nil {
+			if errors.Cause(err) != headers.ErrTopicDoesNotExist {
+				s.l.logger.Error("unable to inspect topic", "topic", topic, "err", err)
+				continue
+			}
+			if _, ok := s.filters[topic]; ok {
+				s.offsets[topic] = 0
+			} else {
+				delete(s.offsets, topic)
+			}
+			continue
+		}
+		if offset < info.MinOffset || offset > info.MaxOffset+1 {
+			offset = info.MinOffset
+		}
+		for offset <= info.MaxOffset {
+			msgs, err := s.l.q.ConsumeMsgs(ctx, topic, offset, consumeLimit)
+			if err != nil {
+				s.l.logger.Error("unable to consume", "topic", topic, "offset", offset, "err", err)
+				break
+			}
+			if len(msgs) == 0 {
+				break
+			}
+			for _, msg := range msgs {
+				body := appendString(make([]byte, 0, len(topic)+2+len(msg)), topic)
+				if err = s.write(packetPublish, 0, append(body, msg...)); err != nil {
+					return err
+				}
+			}
+			offset += int64(len(msgs))
+		}
+		s.offsets[topic] = offset
+	}
+	return nil
+}
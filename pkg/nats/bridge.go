@@ -0,0 +1,358 @@
+// Package nats bridges a NATS server and a haraqa queue. Messages published to the subscribed NATS subjects
+// are persisted to haraqa topics, giving NATS users a durable and replayable history, and messages produced
+// to haraqa topics can be republished to NATS.
+//
+// Subjects map to topics by replacing dots with slashes, the subject orders.created is stored in the topic
+// orders/created. Topics are created when their first message arrives. Core NATS delivers messages at most
+// once, messages published while the bridge is disconnected are not persisted. Republished topics are read
+// from the end when first seen and resume where they left off after a reconnect.
+package nats
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+// Queue is the subset of the haraqa server used by the bridge, it is implemented by *server.Server
+type Queue interface {
+	CreateTopic(ctx context.Context, topic string) error
+	InspectTopic(ctx context.Context, topic string) (*headers.TopicInfo, error)
+	ProduceMsgs(ctx context.Context, topic string, msgs ...[]byte) error
+	ConsumeMsgs(ctx context.Context, topic string, id, limit int64) ([][]byte, error)
+}
+
+var _ Queue = &server.Server{}
+
+// Option represents a optional function argument to NewBridge
+type Option func(*Bridge) error
+
+// WithSubjects sets the NATS subjects to persist, subjects may contain the * and > wildcards
+func WithSubjects(subjects ...string) Option {
+	return func(b *Bridge) error {
+		for _, subject := range subjects {
+			if !validSubject(subject, true) {
+				return errors.Errorf("invalid subject %q", subject)
+			}
+		}
+		b.subjects = append(b.subjects, subjects...)
+		return nil
+	}
+}
+
+// WithQueueGroup subscribes to subjects as part of a queue group, sharing messages between bridges in the group
+func WithQueueGroup(group string) Option {
+	return func(b *Bridge) error {
+		if group == "" || strings.ContainsAny(group, " \t\r\n") {
+			return errors.Errorf("invalid queue group %q", group)
+		}
+		b.group = group
+		return nil
+	}
+}
+
+// WithRepublish sets the haraqa topics to publish to NATS as they are produced to
+func WithRepublish(topics ...string) Option {
+	return func(b *Bridge) error {
+		for _, topic := range topics {
+			if !validSubject(topicSubject(topic), false) {
+				return errors.Errorf("invalid topic %q", topic)
+			}
+		}
+		b.republish = append(b.republish, topics...)
+		return nil
+	}
+}
+
+// WithCredentials sets the user and password sent to the NATS server
+func WithCredentials(user, password string) Option {
+	return func(b *Bridge) error {
+		b.user, b.password = user, password
+		return nil
+	}
+}
+
+// WithToken sets the authentication token sent to the NATS server
+func WithToken(token string) Option {
+	return func(b *Bridge) error {
+		b.token = token
+		return nil
+	}
+}
+
+// WithTLSConfig sets the tls configuration used to connect to the NATS server
+func WithTLSConfig(config *tls.Config) Option {
+	return func(b *Bridge) error {
+		if config == nil {
+			return errors.New("tls config cannot be nil")
+		}
+		b.tlsConfig = config
+		return nil
+	}
+}
+
+// WithPollInterval sets how often republished topics are checked for new messages
+func WithPollInterval(interval time.Duration) Option {
+	return func(b *Bridge) error {
+		if interval <= 0 {
+			return errors.New("invalid interval, value must be greater than 0")
+		}
+		b.pollInterval = interval
+		return nil
+	}
+}
+
+// WithReconnectWait sets how long to wait before reconnecting after the connection is lost
+func WithReconnectWait(wait time.Duration) Option {
+	return func(b *Bridge) error {
+		if wait <= 0 {
+			return errors.New("invalid wait, value must be greater than 0")
+		}
+		b.reconnectWait = wait
+		return nil
+	}
+}
+
+// WithLogger sets the logger used to report connection and bridging errors
+func WithLogger(logger server.Logger) Option {
+	return func(b *Bridge) error {
+		if logger == nil {
+			return errors.New("logger cannot be nil")
+		}
+		b.logger = logger
+		return nil
+	}
+}
+
+// Bridge copies messages between a NATS server and a haraqa queue
+type Bridge struct {
+	q             Queue
+	addr          string
+	logger        server.Logger
+	subjects      []string
+	group         string
+	republish     []string
+	user          string
+	password      string
+	token         string
+	tlsConfig     *tls.Config
+	pollInterval  time.Duration
+	reconnectWait time.Duration
+	pingInterval  time.Duration
+
+	// next offset of each republished topic, only used by the poll goroutine
+	offsets map[string]int64
+
+	mux      sync.Mutex
+	nc       net.Conn
+	done     chan struct{}
+	isClosed bool
+	wg       sync.WaitGroup
+}
+
+// NewBridge creates a bridge to the NATS server at addr, given as host:port or a nats:// or tls:// url
+// which may include credentials
+func NewBridge(q Queue, addr string, opts ...Option) (*Bridge, error) {
+	if q == nil {
+		return nil, errors.New("queue cannot be nil")
+	}
+	b := &Bridge{
+		q:             q,
+		logger:        noOpLogger{},
+		pollInterval:  100 * time.Millisecond,
+		reconnectWait: 2 * time.Second,
+		pingInterval:  30 * time.Second,
+		offsets:       make(map[string]int64),
+		done:          make(chan struct{}),
+	}
+	if err := b.parseAddr(addr); err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		if err := opt(b); err != nil {
+			return nil, errors.Wrap(err, "invalid option")
+		}
+	}
+	if len(b.subjects) == 0 && len(b.republish) == 0 {
+		return nil, errors.New("no subjects or topics to bridge")
+	}
+	return b, nil
+}
+
+func (b *Bridge) parseAddr(addr string) error {
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return errors.Wrap(err, "invalid nats url")
+		}
+		switch u.Scheme {
+		case "nats":
+		case "tls":
+			b.tlsConfig = &tls.Config{ServerName: u.Hostname()}
+		default:
+			return errors.Errorf("unsupported nats url scheme %q", u.Scheme)
+		}
+		if u.User != nil {
+			if password, ok := u.User.Password(); ok {
+				b.user, b.password = u.User.Username(), password
+			} else {
+				b.token = u.User.Username()
+			}
+		}
+		addr = u.Host
+	}
+	if addr == "" {
+		return errors.New("nats address cannot be empty")
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "4222")
+	}
+	b.addr = addr
+	return nil
+}
+
+// Run connects to the NATS server and bridges messages until the bridge is closed, reconnecting after errors
+func (b *Bridge) Run() error {
+	b.mux.Lock()
+	if b.isClosed {
+		b.mux.Unlock()
+		return errors.New("bridge closed")
+	}
+	b.wg.Add(1)
+	b.mux.Unlock()
+	defer b.wg.Done()
+
+	for {
+		err := b.runConn()
+		select {
+		case <-b.done:
+			return nil
+		default:
+		}
+		b.logger.Warn("nats connection lost", "addr", b.addr, "err", err)
+		select {
+		case <-b.done:
+			return nil
+		case <-time.After(b.reconnectWait):
+		}
+	}
+}
+
+// Close disconnects from the NATS server and stops the bridge
+func (b *Bridge) Close() error {
+	b.mux.Lock()
+	if !b.isClosed {
+		close(b.done)
+	}
+	b.isClosed = true
+	if b.nc != nil {
+		_ = b.nc.Close()
+	}
+	b.mux.Unlock()
+	b.wg.Wait()
+	return nil
+}
+
+func (b *Bridge) runConn() error {
+	nc, err := net.DialTimeout("tcp", b.addr, handshakeTimeout)
+	if err != nil {
+		return err
+	}
+	b.mux.Lock()
+	if b.isClosed {
+		b.mux.Unlock()
+		return nc.Close()
+	}
+	b.nc = nc
+	b.mux.Unlock()
+	defer func() {
+		_ = nc.Close()
+		b.mux.Lock()
+		b.nc = nil
+		b.mux.Unlock()
+	}()
+
+	// the bridged subjects and topics are configured by the operator, not by clients
+	ctx := server.WithTrusted(context.Background())
+	c := newConn(b, nc)
+	if err = c.handshake(); err != nil {
+		return err
+	}
+	b.logger.Info("connected to nats", "addr", b.addr)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.poll(ctx, done)
+	}()
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+	return c.readLoop(ctx)
+}
+
+// persist writes a message received on the subject to its topic, creating the topic if required
+func (b *Bridge) persist(ctx context.Context, subject string, msg []byte) {
+	topic := subjectTopic(subject)
+	err := b.q.ProduceMsgs(ctx, topic, msg)
+	if errors.Cause(err) == headers.ErrTopicDoesNotExist {
+		err = b.q.CreateTopic(ctx, topic)
+		if err == nil || errors.Cause(err) == headers.ErrTopicAlreadyExists {
+			err = b.q.ProduceMsgs(ctx, topic, msg)
+		}
+	}
+	if err != nil {
+		b.logger.Warn("unable to persist nats message", "subject", subject, "topic", topic, "err", err)
+	}
+}
+
+// subjectTopic returns the topic messages on the subject are stored in
+func subjectTopic(subject string) string {
+	return strings.ReplaceAll(subject, ".", "/")
+}
+
+// topicSubject returns the subject messages from the topic are republished to
+func topicSubject(topic string) string {
+	return strings.ReplaceAll(strings.Trim(topic, "/"), "/", ".")
+}
+
+// validSubject returns true if the subject is made of non empty dot separated tokens
+func validSubject(subject string, wildcards bool) bool {
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return false
+	}
+	tokens := strings.Split(subject, ".")
+	for i, token := range tokens {
+		switch {
+		case token == "":
+			return false
+		case token == "*", token == ">" && i == len(tokens)-1:
+			if !wildcards {
+				return false
+			}
+		case strings.ContainsAny(token, "*>"):
+			return false
+		}
+	}
+	return true
+}
+
+var _ server.Logger = noOpLogger{}
+
+type noOpLogger struct{}
+
+func (noOpLogger) Debug(string, ...interface{}) {}
+func (noOpLogger) Info(string, ...interface{})  {}
+func (noOpLogger) Warn(string, ...interface{})  {}
+func (noOpLogger) Error(string, ...interface{}) {}
@@ -0,0 +1,291 @@
+package nats
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+const (
+	handshakeTimeout  = 10 * time.Second
+	writeTimeout      = 10 * time.Second
+	defaultMaxPayload = 1 << 20
+	maxLineSize       = 64 << 10
+	consumeLimit      = 100
+)
+
+// serverInfo is the subset of the INFO message used by the bridge
+type serverInfo struct {
+	MaxPayload  int64 `json:"max_payload"`
+	TLSRequired bool  `json:"tls_required"`
+}
+
+type connectOptions struct {
+	Verbose   bool   `json:"verbose"`
+	Pedantic  bool   `json:"pedantic"`
+	Name      string `json:"name"`
+	Lang      string `json:"lang"`
+	Version   string `json:"version"`
+	Protocol  int    `json:"protocol"`
+	Echo      bool   `json:"echo"`
+	User      string `json:"user,omitempty"`
+	Pass      string `json:"pass,omitempty"`
+	AuthToken string `json:"auth_token,omitempty"`
+}
+
+// conn is a single connection to the NATS server
+type conn struct {
+	b          *Bridge
+	nc         net.Conn
+	r          *bufio.Reader
+	wmux       sync.Mutex
+	maxPayload int64
+}
+
+func newConn(b *Bridge, nc net.Conn) *conn {
+	return &conn{b: b, nc: nc, r: bufio.NewReaderSize(nc, maxLineSize)}
+}
+
+func (c *conn) write(b []byte) error {
+	c.wmux.Lock()
+	defer c.wmux.Unlock()
+	_ = c.nc.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.nc.Write(b)
+	return err
+}
+
+// readLine reads a protocol line and splits it into the operation and its arguments
+func (c *conn) readLine() (string, string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", "", errors.New("nats: protocol line too long")
+	}
+	if err != nil {
+		return "", "", err
+	}
+	op, args := splitOp(string(bytes.TrimRight(line, "\r\n")))
+	return op, args, nil
+}
+
+func splitOp(line string) (string, string) {
+	line = strings.TrimLeft(line, " \t")
+	i := strings.IndexAny(line, " \t")
+	if i < 0 {
+		return strings.ToUpper(line), ""
+	}
+	return strings.ToUpper(line[:i]), strings.TrimLeft(line[i:], " \t")
+}
+
+// handshake reads the server INFO, upgrades to tls if required and sends CONNECT and the subscriptions
+func (c *conn) handshake() error {
+	_ = c.nc.SetDeadline(time.Now().Add(handshakeTimeout))
+	op, args, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	var info serverInfo
+	if op != "INFO" || json.Unmarshal([]byte(args), &info) != nil {
+		return errors.New("nats: expected server info")
+	}
+	c.maxPayload = info.MaxPayload
+	if c.maxPayload <= 0 {
+		c.maxPayload = defaultMaxPayload
+	}
+	if info.TLSRequired || c.b.tlsConfig != nil {
+		if c.b.tlsConfig == nil {
+			return errors.New("nats: server requires tls")
+		}
+		tc := tls.Client(c.nc, c.b.tlsConfig)
+		if err = tc.Handshake(); err != nil {
+			return err
+		}
+		c.nc, c.r = tc, bufio.NewReaderSize(tc, maxLineSize)
+	}
+
+	connect, err := json.Marshal(connectOptions{
+		Name:      "haraqa",
+		Lang:      "go",
+		Version:   "1.0.0",
+		Protocol:  1,
+		Echo:      false,
+		User:      c.b.user,
+		Pass:      c.b.password,
+		AuthToken: c.b.token,
+	})
+	if err != nil {
+		return err
+	}
+	cmd := append(append([]byte("CONNECT "), connect...), "\r\n"...)
+	for i, subject := range c.b.subjects {
+		cmd = append(cmd, "SUB "+subject+" "...)
+		if c.b.group != "" {
+			cmd = append(cmd, c.b.group+" "...)
+		}
+		cmd = append(strconv.AppendInt(cmd, int64(i+1), 10), "\r\n"...)
+	}
+	if err = c.write(append(cmd, "PING\r\n"...)); err != nil {
+		return err
+	}
+
+	// the server replies to the PING once the connect and subscriptions have been processed
+	for {
+		op, args, err = c.readLine()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case "PONG":
+			return c.nc.SetDeadline(time.Time{})
+		case "PING":
+			err = c.write([]byte("PONG\r\n"))
+		case "+OK", "INFO":
+		case "-ERR":
+			err = errors.Errorf("nats: %s", strings.Trim(args, "'"))
+		default:
+			err = errors.Errorf("nats: unexpected %s during connect", op)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readLoop persists received messages until the connection fails
+func (c *conn) readLoop(ctx context.Context) error {
+	for {
+		// the poll goroutine pings the server, so a healthy connection always has something to read
+		_ = c.nc.SetReadDeadline(time.Now().Add(2 * c.b.pingInterval))
+		op, args, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case "MSG":
+			subject, size, err := parseMsg(args)
+			if err != nil {
+				return err
+			}
+			if size > c.maxPayload {
+				return errors.New("nats: message larger than max payload")
+			}
+			msg := make([]byte, size+2)
+			if _, err = io.ReadFull(c.r, msg); err != nil {
+				return err
+			}
+			if !bytes.HasSuffix(msg, []byte("\r\n")) {
+				return errors.New("nats: malformed message")
+			}
+			c.b.persist(ctx, subject, msg[:size])
+		case "PING":
+			if err = c.write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case "PONG", "+OK", "INFO":
+		case "-ERR":
+			// fatal errors are followed by the server closing the connection
+			c.b.logger.Warn("nats error", "err", strings.Trim(args, "'"))
+		default:
+			return errors.Errorf("nats: unexpected %s", op)
+		}
+	}
+}
+
+// parseMsg parses the arguments of a MSG, "<subject> <sid> [reply-to] <#bytes>"
+func parseMsg(args string) (string, int64, error) {
+	fields := strings.Fields(args)
+	if len(fields) != 3 && len(fields) != 4 {
+		return "", 0, errors.New("nats: malformed message")
+	}
+	size, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+	if err != nil || size < 0 {
+		return "", 0, errors.New("nats: malformed message size")
+	}
+	return fields[0], size, nil
+}
+
+// poll periodically republishes new messages and pings the server until done is closed
+func (c *conn) poll(ctx context.Context, done chan struct{}) {
+	ticker := time.NewTicker(c.b.pollInterval)
+	defer ticker.Stop()
+	lastPing := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case <-c.b.done:
+			return
+		case <-ticker.C:
+			err := c.republish(ctx)
+			if err == nil && time.Since(lastPing) >= c.b.pingInterval {
+				err = c.write([]byte("PING\r\n"))
+				lastPing = time.Now()
+			}
+			if err != nil {
+				// closing the connection stops the read loop
+				_ = c.nc.Close()
+				return
+			}
+		}
+	}
+}
+
+// republish publishes any new messages on the republished topics
+func (c *conn) republish(ctx context.Context) error {
+	for _, topic := range c.b.republish {
+		offset, ok := c.b.offsets[topic]
+		info, err := c.b.q.InspectTopic(ctx, topic)
+		if err != nil {
+			if errors.Cause(err) != headers.ErrTopicDoesNotExist {
+				c.b.logger.Error("unable to inspect topic", "topic", topic, "err", err)
+				continue
+			}
+			// topics created after the bridge started are read from the beginning
+			c.b.offsets[topic] = 0
+			continue
+		}
+		if !ok {
+			offset = info.MaxOffset + 1
+		}
+		if offset < info.MinOffset || offset > info.MaxOffset+1 {
+			offset = info.MinOffset
+		}
+		subject := topicSubject(topic)
+		for offset <= info.MaxOffset {
+			msgs, err := c.b.q.ConsumeMsgs(ctx, topic, offset, consumeLimit)
+			if err != nil {
+				c.b.logger.Error("unable to consume", "topic", topic, "offset", offset, "err", err)
+				break
+			}
+			if len(msgs) == 0 {
+				break
+			}
+			var cmd []byte
+			for i, msg := range msgs {
+				if int64(len(msg)) > c.maxPayload {
+					c.b.logger.Warn("message larger than nats max payload not republished", "topic", topic, "offset", offset+int64(i))
+					continue
+				}
+				cmd = append(cmd, "PUB "+subject+" "...)
+				cmd = append(strconv.AppendInt(cmd, int64(len(msg)), 10), "\r\n"...)
+				cmd = append(append(cmd, msg...), "\r\n"...)
+			}
+			if err = c.write(cmd); err != nil {
+				return err
+			}
+			offset += int64(len(msgs))
+		}
+		c.b.offsets[topic] = offset
+	}
+	return nil
+}
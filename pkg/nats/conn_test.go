@@ -0,0 +1,32 @@
+package nats
+
+import "testing"
+
+func TestSplitOp(t *testing.T) {
+	for line, expected := range map[string][2]string{
+		"PING":                    {"PING", ""},
+		"msg  a.b 1 5":            {"MSG", "a.b 1 5"},
+		"-ERR 'Unknown Protocol'": {"-ERR", "'Unknown Protocol'"},
+		"\tINFO {}":               {"INFO", "{}"},
+	} {
+		if op, args := splitOp(line); op != expected[0] || args != expected[1] {
+			t.Error(line, op, args)
+		}
+	}
+}
+
+func TestParseMsg(t *testing.T) {
+	subject, size, err := parseMsg("orders.new 1 5")
+	if err != nil || subject != "orders.new" || size != 5 {
+		t.Fatal(subject, size, err)
+	}
+	subject, size, err = parseMsg("orders.new 1 _INBOX.abc 0")
+	if err != nil || subject != "orders.new" || size != 0 {
+		t.Fatal(subject, size, err)
+	}
+	for _, args := range []string{"", "a 1", "a 1 b c d", "a 1 -1", "a 1 x"} {
+		if _, _, err = parseMsg(args); err == nil {
+			t.Error(args)
+		}
+	}
+}
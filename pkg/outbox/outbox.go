@@ -0,0 +1,140 @@
+// Package outbox publishes the rows of an application's outbox table to haraqa topics. The Source is a
+// connect.Source, run with a connect.Runner.
+//
+// Applications insert a row into the outbox table in the same transaction as the change it describes, and
+// implement RowSource to read the rows in order of their id. The id of the last row produced is stored by
+// the runner as the source's position, so publishing resumes after it when the runner restarts and each
+// row is produced at least once. Rows which have been produced and checkpointed can be removed from the
+// table by also implementing RowDeleter.
+package outbox
+
+import (
+	"context"
+	"io"
+	"strconv"
+
+	"github.com/haraqa/haraqa/pkg/connect"
+	"github.com/pkg/errors"
+)
+
+var _ connect.Source = &Source{}
+
+// Row is a row of an outbox table. Rows without a topic are produced to the source's default topic
+type Row struct {
+	ID    int64
+	Topic string
+	Value []byte
+}
+
+// RowSource reads the rows of an outbox table
+type RowSource interface {
+	// Rows returns up to limit rows with an id greater than after, in order of id
+	Rows(ctx context.Context, after int64, limit int) ([]Row, error)
+}
+
+// RowDeleter is a RowSource which removes rows once they have been produced
+type RowDeleter interface {
+	// Delete removes the rows with an id less than or equal to id
+	Delete(ctx context.Context, id int64) error
+}
+
+// Option represents a optional function argument to NewSource
+type Option func(*Source) error
+
+// WithBatchSize sets the maximum number of rows read at once, the default is 100
+func WithBatchSize(size int) Option {
+	return func(s *Source) error {
+		if size <= 0 {
+			return errors.New("invalid batch size, value must be greater than 0")
+		}
+		s.batchSize = size
+		return nil
+	}
+}
+
+// WithTopic sets the topic rows without a topic are produced to
+func WithTopic(topic string) Option {
+	return func(s *Source) error {
+		if topic == "" {
+			return errors.New("topic cannot be empty")
+		}
+		s.topic = topic
+		return nil
+	}
+}
+
+// Source produces the rows of an outbox table read from a RowSource
+type Source struct {
+	rows      RowSource
+	topic     string
+	batchSize int
+	deleted   int64
+}
+
+// NewSource creates a source publishing the rows read from rows
+func NewSource(rows RowSource, opts ...Option) (*Source, error) {
+	if rows == nil {
+		return nil, errors.New("row source cannot be nil")
+	}
+	s := &Source{
+		rows:      rows,
+		batchSize: 100,
+		deleted:   -1,
+	}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Read returns the messages of the rows after the position, the id of the last row produced. The rows
+// up to the position have been produced, so they are deleted first if the row source is a RowDeleter
+func (s *Source) Read(ctx context.Context, position string) ([]connect.Message, string, error) {
+	after := int64(-1)
+	if position != "" {
+		var err error
+		if after, err = strconv.ParseInt(position, 10, 64); err != nil {
+			return nil, position, errors.Wrap(err, "invalid outbox position")
+		}
+		if deleter, ok := s.rows.(RowDeleter); ok && after > s.deleted {
+			if err = deleter.Delete(ctx, after); err != nil {
+				return nil, position, errors.Wrap(err, "unable to delete outbox rows")
+			}
+			s.deleted = after
+		}
+	}
+
+	rows, err := s.rows.Rows(ctx, after, s.batchSize)
+	if err != nil {
+		return nil, position, errors.Wrap(err, "unable to read outbox rows")
+	}
+	msgs := make([]connect.Message, 0, len(rows))
+	for _, row := range rows {
+		if row.ID <= after {
+			return nil, position, errors.Errorf("outbox row %d is not after %d", row.ID, after)
+		}
+		topic := row.Topic
+		if topic == "" {
+			topic = s.topic
+		}
+		if topic == "" {
+			return nil, position, errors.Errorf("outbox row %d has no topic", row.ID)
+		}
+		msgs = append(msgs, connect.Message{Topic: topic, Value: row.Value})
+		after = row.ID
+	}
+	if len(msgs) == 0 {
+		return nil, position, nil
+	}
+	return msgs, strconv.FormatInt(after, 10), nil
+}
+
+// Close closes the row source if it is an io.Closer
+func (s *Source) Close() error {
+	if closer, ok := s.rows.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package outbox
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/pkg/connect"
+	"github.com/haraqa/haraqa/pkg/server"
+)
+
+// table is an in memory outbox table
+type table struct {
+	mux    sync.Mutex
+	rows   []Row
+	closed bool
+}
+
+func (t *table) Rows(ctx context.Context, after int64, limit int) ([]Row, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	var rows []Row
+	for _, row := range t.rows {
+		if row.ID > after && len(rows) < limit {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+func (t *table) Delete(ctx context.Context, id int64) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for len(t.rows) > 0 && t.rows[0].ID <= id {
+		t.rows = t.rows[1:]
+	}
+	return nil
+}
+
+func (t *table) Close() error {
+	t.closed = true
+	return nil
+}
+
+func (t *table) len() int {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return len(t.rows)
+}
+
+// rowsFunc is a RowSource which is not a RowDeleter or io.Closer
+type rowsFunc func(ctx context.Context, after int64, limit int) ([]Row, error)
+
+func (fn rowsFunc) Rows(ctx context.Context, after int64, limit int) ([]Row, error) {
+	return fn(ctx, after, limit)
+}
+
+func TestNewSource(t *testing.T) {
+	if _, err := NewSource(nil); err == nil {
+		t.Error("expected nil row source error")
+	}
+	for _, opt := range []Option{WithBatchSize(0), WithTopic("")} {
+		if _, err := NewSource(&table{}, opt); err == nil {
+			t.Error("expected invalid option error")
+		}
+	}
+	s, err := NewSource(&table{}, WithBatchSize(5), WithTopic("events"))
+	if err != nil || s.batchSize != 5 || s.topic != "events" {
+		t.Fatal(s, err)
+	}
+}
+
+func TestSource_Read(t *testing.T) {
+	rows := &table{rows: []Row{{ID: 3, Value: []byte("a")}, {ID: 7, Topic: "orders", Value: []byte("b")}, {ID: 9, Value: []byte("c")}}}
+	s, err := NewSource(rows, WithBatchSize(2), WithTopic("events"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	msgs, position, err := s.Read(ctx, "")
+	if err != nil || position != "7" || len(msgs) != 2 || msgs[0].Topic != "events" || msgs[1].Topic != "orders" || string(msgs[1].Value) != "b" {
+		t.Fatal(msgs, position, err)
+	}
+
+	// reading after the position deletes the rows which were produced
+	msgs, position, err = s.Read(ctx, position)
+	if err != nil || position != "9" || len(msgs) != 1 || string(msgs[0].Value) != "c" || rows.len() != 1 {
+		t.Fatal(msgs, position, err)
+	}
+	msgs, position, err = s.Read(ctx, position)
+	if err != nil || position != "9" || len(msgs) != 0 || rows.len() != 0 {
+		t.Fatal(msgs, position, err)
+	}
+
+	if _, _, err = s.Read(ctx, "x"); err == nil {
+		t.Error("expected invalid position error")
+	}
+	noTopic, err := NewSource(&table{rows: []Row{{ID: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = noTopic.Read(ctx, ""); err == nil {
+		t.Error("expected missing topic error")
+	}
+	unordered, err := NewSource(rowsFunc(func(ctx context.Context, after int64, limit int) ([]Row, error) {
+		return []Row{{ID: after, Topic: "a"}}, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = unordered.Read(ctx, "4"); err == nil {
+		t.Error("expected unordered row error")
+	}
+	if err = unordered.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Close(); err != nil || !rows.closed {
+		t.Fatal(err)
+	}
+}
+
+func TestSource_Runner(t *testing.T) {
+	dir := ".haraqa-outbox"
+	defer os.RemoveAll(dir)
+	q, err := server.NewServer(server.WithFileQueue([]string{dir}, false, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	rows := &table{rows: []Row{{ID: 1, Topic: "orders", Value: []byte("created")}, {ID: 2, Topic: "orders", Value: []byte("paid")}}}
+	s, err := NewSource(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := connect.NewMemoryOffsetStore()
+	r, err := connect.NewRunner(q, connect.WithOffsetStore(store), connect.WithPollInterval(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.AddSource("outbox", s); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := r.Run(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	for start := time.Now(); rows.len() > 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("timed out")
+		}
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if v, _, _ := store.Get("source/outbox"); v != "2" {
+		t.Fatal(v)
+	}
+	msgs, err := q.ConsumeMsgs(context.Background(), "orders", 0, -1)
+	if err != nil || len(msgs) != 2 || string(msgs[0]) != "created" || string(msgs[1]) != "paid" {
+		t.Fatal(msgs, err)
+	}
+}
@@ -0,0 +1,361 @@
+package postgres
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	protocolVersion = 196608
+	sslRequestCode  = 80877103
+)
+
+// Error is an error reported by the postgres server
+type Error struct {
+	Severity string
+	Code     string
+	Message  string
+}
+
+func (e *Error) Error() string {
+	return "postgres: " + e.Severity + " " + e.Code + ": " + e.Message
+}
+
+// conn is a minimal postgres client connection using the text format of the extended query protocol
+type conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+	w  []byte
+}
+
+// dial connects and authenticates to the server described by the config
+func dial(cfg *config, timeout time.Duration) (*conn, error) {
+	nc, err := net.DialTimeout("tcp", cfg.addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	_ = nc.SetDeadline(time.Now().Add(timeout))
+	if cfg.tls != nil {
+		if nc, err = startTLS(nc, cfg.tls); err != nil {
+			return nil, err
+		}
+	}
+	c := &conn{nc: nc, r: bufio.NewReader(nc)}
+	if err = c.startup(cfg.user, cfg.password, cfg.database); err != nil {
+		_ = nc.Close()
+		return nil, err
+	}
+	_ = nc.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func startTLS(nc net.Conn, config *tls.Config) (net.Conn, error) {
+	var req [8]byte
+	binary.BigEndian.PutUint32(req[0:], 8)
+	binary.BigEndian.PutUint32(req[4:], sslRequestCode)
+	if _, err := nc.Write(req[:]); err != nil {
+		_ = nc.Close()
+		return nil, err
+	}
+	var resp [1]byte
+	if _, err := io.ReadFull(nc, resp[:]); err != nil {
+		_ = nc.Close()
+		return nil, err
+	}
+	if resp[0] != 'S' {
+		_ = nc.Close()
+		return nil, errors.New("server does not support tls")
+	}
+	tc := tls.Client(nc, config)
+	if err := tc.Handshake(); err != nil {
+		_ = nc.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+func (c *conn) Close() error {
+	c.message('X', func() {})
+	_ = c.flush()
+	return c.nc.Close()
+}
+
+func (c *conn) int16(v int) { c.w = append(c.w, byte(v>>8), byte(v)) }
+func (c *conn) int32(v int) {
+	c.w = append(c.w, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+func (c *conn) cstring(s string) { c.w = append(append(c.w, s...), 0) }
+
+func (c *conn) flush() error {
+	_, err := c.nc.Write(c.w)
+	c.w = c.w[:0]
+	return err
+}
+
+// message adds a message built by fn to the write buffer, a zero type is used for the startup message
+func (c *conn) message(typ byte, fn func()) {
+	start := len(c.w)
+	if typ != 0 {
+		c.w = append(c.w, typ)
+		start++
+	}
+	c.w = append(c.w, 0, 0, 0, 0)
+	fn()
+	binary.BigEndian.PutUint32(c.w[start:], uint32(len(c.w)-start))
+}
+
+// read reads the next message, skipping notices and parameter status messages
+func (c *conn) read() (byte, []byte, error) {
+	for {
+		var header [5]byte
+		if _, err := io.ReadFull(c.r, header[:]); err != nil {
+			return 0, nil, err
+		}
+		n := binary.BigEndian.Uint32(header[1:])
+		if n < 4 || n > 1<<30 {
+			return 0, nil, errors.Errorf("invalid message length %d", n)
+		}
+		body := make([]byte, n-4)
+		if _, err := io.ReadFull(c.r, body); err != nil {
+			return 0, nil, err
+		}
+		switch header[0] {
+		case 'N', 'S', 'K':
+			continue
+		case 'E':
+			return header[0], body, parseError(body)
+		}
+		return header[0], body, nil
+	}
+}
+
+func parseError(body []byte) *Error {
+	e := &Error{}
+	for len(body) > 1 {
+		field := body[0]
+		i := 1
+		for i < len(body) && body[i] != 0 {
+			i++
+		}
+		value := string(body[1:i])
+		switch field {
+		case 'S':
+			e.Severity = value
+		case 'C':
+			e.Code = value
+		case 'M':
+			e.Message = value
+		}
+		if i >= len(body) {
+			break
+		}
+		body = body[i+1:]
+	}
+	return e
+}
+
+func (c *conn) startup(user, password, database string) error {
+	c.message(0, func() {
+		c.int32(protocolVersion)
+		c.cstring("user")
+		c.cstring(user)
+		if database != "" {
+			c.cstring("database")
+			c.cstring(database)
+		}
+		c.cstring("application_name")
+		c.cstring("haraqa")
+		c.w = append(c.w, 0)
+	})
+	if err := c.flush(); err != nil {
+		return err
+	}
+
+	var sc *scramClient
+	for {
+		typ, body, err := c.read()
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case 'Z':
+			return nil
+		case 'R':
+		default:
+			return errors.Errorf("unexpected message %q during startup", typ)
+		}
+		if len(body) < 4 {
+			return errors.New("invalid authentication message")
+		}
+		switch code := binary.BigEndian.Uint32(body); code {
+		case 0:
+			// authentication ok
+		case 3:
+			c.message('p', func() { c.cstring(password) })
+		case 5:
+			if len(body) < 8 {
+				return errors.New("invalid md5 salt")
+			}
+			c.message('p', func() { c.cstring(md5Password(user, password, body[4:8])) })
+		case 10:
+			if !strings.Contains(string(body[4:]), "SCRAM-SHA-256\x00") {
+				return errors.New("server requires an unsupported sasl mechanism")
+			}
+			if sc, err = newScramClient("", password); err != nil {
+				return err
+			}
+			first := sc.clientFirst()
+			c.message('p', func() {
+				c.cstring("SCRAM-SHA-256")
+				c.int32(len(first))
+				c.w = append(c.w, first...)
+			})
+		case 11:
+			if sc == nil {
+				return errors.New("unexpected sasl continue")
+			}
+			final, err := sc.clientFinal(string(body[4:]))
+			if err != nil {
+				return err
+			}
+			c.message('p', func() { c.w = append(c.w, final...) })
+		case 12:
+			if sc == nil {
+				return errors.New("unexpected sasl final")
+			}
+			if err = sc.verifyServer(string(body[4:])); err != nil {
+				return err
+			}
+		default:
+			return errors.Errorf("unsupported authentication method %d", code)
+		}
+		if err = c.flush(); err != nil {
+			return err
+		}
+	}
+}
+
+func md5Password(user, password string, salt []byte) string {
+	inner := md5.Sum([]byte(password + user))
+	outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), salt...))
+	return "md5" + hex.EncodeToString(outer[:])
+}
+
+// simpleQuery runs statements without parameters, such as BEGIN and COMMIT
+func (c *conn) simpleQuery(sql string) error {
+	c.message('Q', func() { c.cstring(sql) })
+	if err := c.flush(); err != nil {
+		return err
+	}
+	_, err := c.results()
+	return err
+}
+
+// statement is a single statement of a pipeline
+type statement struct {
+	sql  string
+	args [][]string
+}
+
+// exec runs each statement once for each of its sets of arguments, returning the rows of the last
+// statement. All statements are sent before any results are read
+func (c *conn) exec(stmts ...statement) ([][][]byte, error) {
+	for _, stmt := range stmts {
+		c.message('P', func() {
+			c.cstring("")
+			c.cstring(stmt.sql)
+			c.int16(0)
+		})
+		for _, args := range stmt.args {
+			c.message('B', func() {
+				c.cstring("")
+				c.cstring("")
+				c.int16(0)
+				c.int16(len(args))
+				for _, arg := range args {
+					c.int32(len(arg))
+					c.w = append(c.w, arg...)
+				}
+				c.int16(0)
+			})
+			c.message('E', func() {
+				c.cstring("")
+				c.int32(0)
+			})
+		}
+	}
+	c.message('S', func() {})
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+	return c.results()
+}
+
+// query runs a single statement and returns its rows
+func (c *conn) query(sql string, args ...string) ([][][]byte, error) {
+	return c.exec(statement{sql: sql, args: [][]string{args}})
+}
+
+// results reads messages until the server is ready for the next query, returning the rows of the last
+// command and the first error
+func (c *conn) results() ([][][]byte, error) {
+	var rows [][][]byte
+	var first error
+	for {
+		typ, body, err := c.read()
+		if _, ok := err.(*Error); ok {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case 'Z':
+			return rows, first
+		case 'T', '1':
+			rows = nil
+		case 'D':
+			row, err := parseDataRow(body)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, row)
+		}
+	}
+}
+
+func parseDataRow(body []byte) ([][]byte, error) {
+	if len(body) < 2 {
+		return nil, errors.New("invalid data row")
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	body = body[2:]
+	row := make([][]byte, n)
+	for i := range row {
+		if len(body) < 4 {
+			return nil, errors.New("invalid data row")
+		}
+		size := int32(binary.BigEndian.Uint32(body))
+		body = body[4:]
+		if size < 0 {
+			continue
+		}
+		if int(size) > len(body) {
+			return nil, errors.New("invalid data row")
+		}
+		row[i], body = body[:size], body[size:]
+	}
+	return row, nil
+}
@@ -0,0 +1,44 @@
+package postgres
+
+import "testing"
+
+func TestScramClient(t *testing.T) {
+	// example exchange from RFC 7677
+	s := &scramClient{user: "user", password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO"}
+	if first := s.clientFirst(); first != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Fatal(first)
+	}
+	final, err := s.clientFinal("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if final != "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=" {
+		t.Fatal(final)
+	}
+	if err = s.verifyServer("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.verifyServer("v=AAAA"); err == nil {
+		t.Fatal("expected invalid signature")
+	}
+	if err = s.verifyServer("e=invalid-proof"); err == nil {
+		t.Fatal("expected server error")
+	}
+
+	for _, serverFirst := range []string{
+		"r=other,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+		"r=rOprNGfwEbeRWgbNEkqO,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+		"r=rOprNGfwEbeRWgbNEkqOx,s=!,i=4096",
+		"r=rOprNGfwEbeRWgbNEkqOx,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=0",
+	} {
+		if _, err = s.clientFinal(serverFirst); err == nil {
+			t.Error("expected invalid server first error", serverFirst)
+		}
+	}
+}
+
+func TestMD5Password(t *testing.T) {
+	if got := md5Password("user", "pass", []byte{1, 2, 3, 4}); got != "md56cf524962d8413e6b0cdf79fddff891c" {
+		t.Fatal(got)
+	}
+}
@@ -0,0 +1,224 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/haraqa/haraqa/pkg/connect"
+	"github.com/pkg/errors"
+)
+
+// quoteIdent quotes a possibly schema qualified identifier
+func quoteIdent(name string) string {
+	parts := strings.Split(name, ".")
+	for i := range parts {
+		parts[i] = `"` + strings.ReplaceAll(parts[i], `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+func quoteIdents(names []string) string {
+	quoted := make([]string, len(names))
+	for i := range names {
+		quoted[i] = quoteIdent(names[i])
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// upsertSQL returns the statement writing a json object given as $1 to the table
+func upsertSQL(table string, columns, conflict []string) string {
+	cols := quoteIdents(columns)
+	sql := "INSERT INTO " + quoteIdent(table) + " (" + cols + ") SELECT " + cols +
+		" FROM jsonb_populate_record(NULL::" + quoteIdent(table) + ", $1::jsonb)"
+	if len(conflict) == 0 {
+		return sql
+	}
+	isConflict := make(map[string]bool, len(conflict))
+	for _, c := range conflict {
+		isConflict[c] = true
+	}
+	var updates []string
+	for _, c := range columns {
+		if !isConflict[c] {
+			updates = append(updates, quoteIdent(c)+" = EXCLUDED."+quoteIdent(c))
+		}
+	}
+	sql += " ON CONFLICT (" + quoteIdents(conflict) + ")"
+	if len(updates) == 0 {
+		return sql + " DO NOTHING"
+	}
+	return sql + " DO UPDATE SET " + strings.Join(updates, ", ")
+}
+
+// isObject returns true if the message is a json object
+func isObject(msg []byte) bool {
+	msg = bytes.TrimSpace(msg)
+	return len(msg) > 0 && msg[0] == '{' && json.Valid(msg)
+}
+
+// connect returns the open connection, connecting and reading the table columns if there is none
+func (s *Sink) connect() (*conn, error) {
+	if s.isClosed {
+		return nil, errors.New("sink closed")
+	}
+	if s.c != nil {
+		return s.c, nil
+	}
+	c, err := dial(s.cfg, s.dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if s.upsert, err = s.setup(c); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	s.c, s.offsets = c, make(map[string]int64)
+	return c, nil
+}
+
+// reset closes the connection, offsets are read again after reconnecting
+func (s *Sink) reset() {
+	if s.c != nil {
+		_ = s.c.Close()
+	}
+	s.c, s.offsets = nil, nil
+}
+
+// watch interrupts reads and writes on the connection if the context is cancelled before the returned
+// function is called
+func watch(ctx context.Context, c *conn) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = c.nc.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// Offset returns the next offset to write for the topic, or -1 if none is stored
+func (s *Sink) Offset(ctx context.Context, topic string) (int64, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	c, err := s.connect()
+	if err != nil {
+		return 0, err
+	}
+	defer watch(ctx, c)()
+	next, err := s.readOffset(c, topic)
+	if err != nil {
+		s.reset()
+		return 0, err
+	}
+	return next, nil
+}
+
+// readOffset returns the stored offset of the topic, or -1 if none is stored
+func (s *Sink) readOffset(c *conn, topic string) (int64, error) {
+	if next, ok := s.offsets[topic]; ok {
+		return next, nil
+	}
+	rows, err := c.query("SELECT next_offset FROM "+quoteIdent(s.offsetsTable)+" WHERE topic = $1 AND target = $2", topic, s.table)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to read offset")
+	}
+	next := int64(-1)
+	if len(rows) > 0 {
+		if next, err = strconv.ParseInt(string(rows[0][0]), 10, 64); err != nil {
+			return 0, errors.Wrap(err, "invalid stored offset")
+		}
+	}
+	s.offsets[topic] = next
+	return next, nil
+}
+
+// Write upserts the messages and stores the offset after them in a single transaction. Messages before
+// the stored offset were already written and are skipped
+func (s *Sink) Write(ctx context.Context, batch connect.Batch) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	c, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer watch(ctx, c)()
+	next, err := s.readOffset(c, batch.Topic)
+	if err != nil {
+		s.reset()
+		return err
+	}
+	offset, msgs := batch.Offset, batch.Msgs
+	if skip := next - offset; skip > 0 {
+		if skip >= int64(len(msgs)) {
+			return nil
+		}
+		offset, msgs = next, msgs[skip:]
+	}
+
+	rows := statement{sql: s.upsert}
+	for i, msg := range msgs {
+		if !isObject(msg) {
+			s.logger.Warn("postgres sink skipping message which is not a json object", "topic", batch.Topic, "offset", offset+int64(i))
+			continue
+		}
+		rows.args = append(rows.args, []string{string(msg)})
+	}
+	last := offset + int64(len(msgs))
+	offsetSQL := "INSERT INTO " + quoteIdent(s.offsetsTable) + ` (topic, target, next_offset) VALUES ($1, $2, $3)
+		ON CONFLICT (topic, target) DO UPDATE SET next_offset = EXCLUDED.next_offset`
+	_, err = c.exec(
+		statement{sql: "BEGIN", args: [][]string{{}}},
+		rows,
+		statement{sql: offsetSQL, args: [][]string{{batch.Topic, s.table, strconv.FormatInt(last, 10)}}},
+		statement{sql: "COMMIT", args: [][]string{{}}},
+	)
+	if err != nil {
+		if _, ok := err.(*Error); ok {
+			_ = c.simpleQuery("ROLLBACK")
+		}
+		s.reset()
+		return errors.Wrapf(err, "unable to write offsets %d to %d", offset, last-1)
+	}
+	s.offsets[batch.Topic] = last
+	return nil
+}
+
+// setup reads the table columns and creates the offsets table, returning the upsert statement
+func (s *Sink) setup(c *conn) (string, error) {
+	rows, err := c.query(`SELECT attname FROM pg_attribute WHERE attrelid = $1::regclass AND attnum > 0 AND NOT attisdropped ORDER BY attnum`, quoteIdent(s.table))
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to read columns of %q", s.table)
+	}
+	if len(rows) == 0 {
+		return "", errors.Errorf("table %q has no columns", s.table)
+	}
+	columns := make([]string, len(rows))
+	for i := range rows {
+		columns[i] = string(rows[i][0])
+	}
+
+	conflict := s.conflict
+	if conflict == nil {
+		rows, err = c.query(`SELECT a.attname FROM pg_index i JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+			WHERE i.indrelid = $1::regclass AND i.indisprimary`, quoteIdent(s.table))
+		if err != nil {
+			return "", errors.Wrapf(err, "unable to read primary key of %q", s.table)
+		}
+		for i := range rows {
+			conflict = append(conflict, string(rows[i][0]))
+		}
+	}
+
+	err = c.simpleQuery("CREATE TABLE IF NOT EXISTS " + quoteIdent(s.offsetsTable) +
+		" (topic text NOT NULL, target text NOT NULL, next_offset bigint NOT NULL, PRIMARY KEY (topic, target))")
+	if err != nil {
+		return "", errors.Wrap(err, "unable to create offsets table")
+	}
+	return upsertSQL(s.table, columns, conflict), nil
+}
@@ -0,0 +1,142 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+// run delivers batches to the subscription url until it is cancelled
+func (d *Dispatcher) run(sub *subscription) {
+	for {
+		delivered, err := d.deliverNext(sub)
+		if err != nil && sub.ctx.Err() == nil {
+			d.logger.Warn("unable to read messages for push subscription", "id", sub.ID, "topic", sub.Topic, "err", err)
+		}
+		if delivered {
+			continue
+		}
+		select {
+		case <-sub.ctx.Done():
+			return
+		case <-time.After(d.pollInterval):
+		}
+	}
+}
+
+// deliverNext delivers the next batch of messages, returning false if there were none
+func (d *Dispatcher) deliverNext(sub *subscription) (bool, error) {
+	ctx := sub.ctx
+	offset := d.offset(sub)
+	info, err := d.q.InspectTopic(ctx, sub.Topic)
+	if errors.Cause(err) == headers.ErrTopicDoesNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if offset < info.MinOffset || offset > info.MaxOffset+1 {
+		// the topic was truncated past the subscription, or deleted and recreated
+		d.logger.Warn("push subscription offset out of range, restarting from the oldest message",
+			"id", sub.ID, "topic", sub.Topic, "offset", offset, "minOffset", info.MinOffset, "maxOffset", info.MaxOffset)
+		offset = info.MinOffset
+		d.checkpoint(sub, offset, "")
+	}
+	if offset > info.MaxOffset {
+		return false, nil
+	}
+	msgs, err := d.q.ConsumeMsgs(ctx, sub.Topic, offset, sub.BatchSize)
+	if err != nil {
+		return false, err
+	}
+	if len(msgs) == 0 {
+		return false, nil
+	}
+
+	backoff := d.minBackoff
+	for attempt := 1; ; attempt++ {
+		err = d.post(ctx, sub, offset, msgs)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return false, nil
+		}
+		d.logger.Warn("unable to deliver push subscription", "id", sub.ID, "url", sub.URL, "offset", offset, "attempt", attempt, "err", err)
+		d.checkpoint(sub, offset, err.Error())
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > d.maxBackoff {
+			backoff = d.maxBackoff
+		}
+	}
+	d.checkpoint(sub, offset+int64(len(msgs)), "")
+	return true, nil
+}
+
+// post sends a batch of messages starting at offset to the subscription url
+func (d *Dispatcher) post(ctx context.Context, sub *subscription, offset int64, msgs [][]byte) error {
+	sizes := make([]int64, len(msgs))
+	for i := range msgs {
+		sizes[i] = int64(len(msgs[i]))
+	}
+	body := bytes.Join(msgs, nil)
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	headers.SetSizes(sizes, req.Header)
+	req.Header.Set(headers.ContentType, "application/octet-stream")
+	req.Header.Set(HeaderTopic, sub.Topic)
+	req.Header.Set(HeaderOffset, strconv.FormatInt(offset, 10))
+	req.Header.Set(HeaderSubscription, sub.ID)
+	if sub.Secret != "" {
+		req.Header.Set(server.HeaderWebhookSignature, server.SignWebhook([]byte(sub.Secret), body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (d *Dispatcher) offset(sub *subscription) int64 {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return sub.Offset
+}
+
+// checkpoint records the next offset and last error of the subscription, saving the state file if the offset changed
+func (d *Dispatcher) checkpoint(sub *subscription, offset int64, lastError string) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.subs[sub.ID] != sub {
+		// unsubscribed while delivering
+		return
+	}
+	sub.LastError = lastError
+	if sub.Offset == offset {
+		return
+	}
+	sub.Offset = offset
+	if err := d.saveLocked(); err != nil {
+		d.logger.Error("unable to save push subscription state", "file", d.stateFile, "err", err)
+	}
+}
@@ -0,0 +1,86 @@
+package push
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// ServeHTTP manages subscriptions, paths are relative to where the dispatcher is mounted:
+//
+//	GET /        lists the subscriptions
+//	POST /       subscribes, the body is a json Subscription
+//	GET /{id}    returns the subscription
+//	DELETE /{id} unsubscribes
+//
+// Secrets are never returned
+func (d *Dispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(r.URL.Path, "/")
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, redact(d.Subscriptions()...))
+	case id == "" && r.Method == http.MethodPost:
+		if r.Body == nil {
+			headers.SetError(w, headers.ErrInvalidBodyMissing)
+			return
+		}
+		defer r.Body.Close()
+		sub := Subscription{Offset: -1}
+		if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+			headers.SetError(w, headers.ErrInvalidBodyJSON)
+			return
+		}
+		sub, err := d.Subscribe(r.Context(), sub)
+		switch errors.Cause(err) {
+		case nil:
+			writeJSON(w, http.StatusCreated, redact(sub)[0])
+		case headers.ErrTopicDoesNotExist, headers.ErrInvalidTopic:
+			headers.SetError(w, err)
+		case ErrSubscriptionExists:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	case id != "" && r.Method == http.MethodGet:
+		for _, sub := range d.Subscriptions() {
+			if sub.ID == id {
+				writeJSON(w, http.StatusOK, redact(sub)[0])
+				return
+			}
+		}
+		http.Error(w, ErrSubscriptionNotFound.Error(), http.StatusNotFound)
+	case id != "" && r.Method == http.MethodDelete:
+		err := d.Unsubscribe(id)
+		switch {
+		case err == ErrSubscriptionNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		if id == "" {
+			w.Header().Set("Allow", "GET, POST")
+		} else {
+			w.Header().Set("Allow", "GET, DELETE")
+		}
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func redact(subs ...Subscription) []Subscription {
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+	return subs
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header()[headers.ContentType] = []string{"application/json"}
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
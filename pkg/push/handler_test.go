@@ -0,0 +1,114 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/pkg/server"
+)
+
+func TestDispatcher_ServeHTTP(t *testing.T) {
+	q := &testQueue{topics: map[string][][]byte{"topic": nil}}
+	d, err := NewDispatcher(q, WithPollInterval(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		var r *http.Request
+		if body == "" {
+			r = httptest.NewRequest(method, path, nil)
+		} else {
+			r = httptest.NewRequest(method, path, strings.NewReader(body))
+		}
+		d.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do(http.MethodPost, "/", `{"id":"a","topic":"topic","url":"http://127.0.0.1:1","secret":"s"}`)
+	var sub Subscription
+	if err = json.Unmarshal(w.Body.Bytes(), &sub); w.Code != http.StatusCreated || err != nil {
+		t.Fatal(w.Code, w.Body.String(), err)
+	}
+	if sub.ID != "a" || sub.Secret != "" || sub.Offset != 0 || sub.BatchSize != DefaultBatchSize {
+		t.Fatal(sub)
+	}
+	if d.Subscriptions()[0].Secret != "s" {
+		t.Fatal(d.Subscriptions())
+	}
+
+	w = do(http.MethodGet, "/", "")
+	var subs []Subscription
+	if err = json.Unmarshal(w.Body.Bytes(), &subs); w.Code != http.StatusOK || err != nil || len(subs) != 1 || subs[0].Secret != "" {
+		t.Fatal(w.Code, w.Body.String(), err)
+	}
+	if w = do(http.MethodGet, "/a", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"id":"a"`) {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	for _, tt := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{http.MethodPost, "/", "", http.StatusBadRequest},
+		{http.MethodPost, "/", "{", http.StatusBadRequest},
+		{http.MethodPost, "/", `{"id":"a","topic":"topic","url":"http://127.0.0.1:1"}`, http.StatusConflict},
+		{http.MethodPost, "/", `{"topic":"missing","url":"http://127.0.0.1:1"}`, http.StatusPreconditionFailed},
+		{http.MethodPost, "/", `{"topic":"topic","url":"ftp://127.0.0.1:1"}`, http.StatusBadRequest},
+		{http.MethodGet, "/b", "", http.StatusNotFound},
+		{http.MethodDelete, "/b", "", http.StatusNotFound},
+		{http.MethodPut, "/", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/a", "", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/a", "", http.StatusNoContent},
+	} {
+		if w = do(tt.method, tt.path, tt.body); w.Code != tt.code {
+			t.Error(tt.method, tt.path, tt.body, w.Code, w.Body.String())
+		}
+	}
+	if len(d.Subscriptions()) != 0 {
+		t.Fatal(d.Subscriptions())
+	}
+}
+
+func TestDispatcher_Protect(t *testing.T) {
+	dir := ".haraqa-push-protect"
+	defer os.RemoveAll(dir)
+	s, err := server.NewServer(server.WithFileQueue([]string{dir}, true, 5000), server.WithUserStore("", "root", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.CreateTopic(context.Background(), "topic"); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDispatcher(s, WithPollInterval(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	h := s.Protect(http.StripPrefix("/subscriptions", d))
+
+	// subscriptions cannot be created without credentials
+	for user, code := range map[string]int{"": http.StatusUnauthorized, "root": http.StatusCreated} {
+		r := httptest.NewRequest(http.MethodPost, "/subscriptions/", strings.NewReader(`{"topic":"topic","url":"http://127.0.0.1:1"}`))
+		if user != "" {
+			r.SetBasicAuth(user, "secret")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Fatal(user, w.Code, w.Body.String())
+		}
+	}
+	if subs := d.Subscriptions(); len(subs) != 1 {
+		t.Fatal(subs)
+	}
+}
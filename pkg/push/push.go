@@ -0,0 +1,306 @@
+// Package push delivers haraqa messages to http endpoints, for consumers which cannot poll the queue.
+//
+// Each subscription posts batches of new messages from a topic to a url, in order and one batch at a time.
+// The request body holds the concatenated messages and their sizes are given in the X-Sizes header, the
+// same format as the consume endpoint. A batch is retried with exponential backoff until the endpoint
+// responds with a 2xx status, so delivery is at least once and later messages are never delivered before
+// earlier ones. Delivered offsets are checkpointed to the state file, if one is set, so subscriptions
+// resume where they left off after a restart.
+package push
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+// Queue is the subset of the haraqa server used to deliver messages, it is implemented by *server.Server
+type Queue interface {
+	InspectTopic(ctx context.Context, topic string) (*headers.TopicInfo, error)
+	ConsumeMsgs(ctx context.Context, topic string, id, limit int64) ([][]byte, error)
+}
+
+var _ Queue = &server.Server{}
+
+// Headers sent with each delivery
+const (
+	HeaderTopic        = "X-Haraqa-Topic"
+	HeaderOffset       = "X-Haraqa-Offset"
+	HeaderSubscription = "X-Haraqa-Subscription"
+)
+
+// DefaultBatchSize is the maximum number of messages delivered per request if a subscription does not set one
+const DefaultBatchSize = 100
+
+// Subscription posts messages from a topic to a url
+type Subscription struct {
+	ID    string `json:"id"`
+	Topic string `json:"topic"`
+	URL   string `json:"url"`
+	// Secret signs each request body using HMAC-SHA256, see server.VerifyWebhook
+	Secret    string `json:"secret,omitempty"`
+	BatchSize int64  `json:"batchSize,omitempty"`
+	// Offset is the next message to deliver, when subscribing a negative offset starts from the end of the topic
+	Offset    int64  `json:"offset"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Option represents a optional function argument to NewDispatcher
+type Option func(*Dispatcher) error
+
+// WithStateFile sets the file subscriptions and their offsets are stored in. Existing subscriptions are
+// loaded from the file when the dispatcher is created
+func WithStateFile(path string) Option {
+	return func(d *Dispatcher) error {
+		if path == "" {
+			return errors.New("state file cannot be empty")
+		}
+		d.stateFile = path
+		return nil
+	}
+}
+
+// WithPollInterval sets how often topics are checked for new messages once a subscription is up to date
+func WithPollInterval(interval time.Duration) Option {
+	return func(d *Dispatcher) error {
+		if interval <= 0 {
+			return errors.New("invalid interval, value must be greater than 0")
+		}
+		d.pollInterval = interval
+		return nil
+	}
+}
+
+// WithBackoff sets the delay before the first retry of a failed delivery, doubling after each attempt up to max
+func WithBackoff(min, max time.Duration) Option {
+	return func(d *Dispatcher) error {
+		if min <= 0 || max < min {
+			return errors.New("invalid backoff, min must be greater than 0 and max at least min")
+		}
+		d.minBackoff, d.maxBackoff = min, max
+		return nil
+	}
+}
+
+// WithHTTPClient sets the client used to post messages
+func WithHTTPClient(client *http.Client) Option {
+	return func(d *Dispatcher) error {
+		if client == nil {
+			return errors.New("http client cannot be nil")
+		}
+		d.client = client
+		return nil
+	}
+}
+
+// WithLogger sets the logger used to report delivery errors
+func WithLogger(logger server.Logger) Option {
+	return func(d *Dispatcher) error {
+		if logger == nil {
+			return errors.New("logger cannot be nil")
+		}
+		d.logger = logger
+		return nil
+	}
+}
+
+// Dispatcher delivers messages to the subscribed urls
+type Dispatcher struct {
+	q            Queue
+	logger       server.Logger
+	client       *http.Client
+	stateFile    string
+	pollInterval time.Duration
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+
+	mux      sync.Mutex
+	subs     map[string]*subscription
+	isClosed bool
+	wg       sync.WaitGroup
+}
+
+type subscription struct {
+	Subscription
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewDispatcher creates a dispatcher and starts delivering any subscriptions stored in the state file
+func NewDispatcher(q Queue, opts ...Option) (*Dispatcher, error) {
+	if q == nil {
+		return nil, errors.New("queue cannot be nil")
+	}
+	d := &Dispatcher{
+		q:            q,
+		logger:       noOpLogger{},
+		client:       &http.Client{Timeout: 30 * time.Second},
+		pollInterval: 500 * time.Millisecond,
+		minBackoff:   time.Second,
+		maxBackoff:   time.Minute,
+		subs:         make(map[string]*subscription),
+	}
+	for _, opt := range opts {
+		if err := opt(d); err != nil {
+			return nil, errors.Wrap(err, "invalid option")
+		}
+	}
+	subs, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subs {
+		if err = validate(sub); err != nil {
+			return nil, errors.Wrapf(err, "invalid subscription %q in state file", sub.ID)
+		}
+		d.start(sub)
+	}
+	return d, nil
+}
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+func validate(sub Subscription) error {
+	if !validID.MatchString(sub.ID) {
+		return errors.Errorf("invalid id %q", sub.ID)
+	}
+	if sub.Topic == "" {
+		return headers.ErrInvalidTopic
+	}
+	u, err := url.Parse(sub.URL)
+	if err != nil {
+		return errors.Wrapf(err, "invalid url %q", sub.URL)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.Errorf("invalid url %q, scheme must be http or https", sub.URL)
+	}
+	if sub.BatchSize < 0 {
+		return errors.New("invalid batch size, value cannot be negative")
+	}
+	return nil
+}
+
+// Subscribe adds the subscription and starts delivering messages. A random id is generated if none is given
+func (d *Dispatcher) Subscribe(ctx context.Context, sub Subscription) (Subscription, error) {
+	if sub.ID == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return Subscription{}, errors.Wrap(err, "unable to generate id")
+		}
+		sub.ID = hex.EncodeToString(b)
+	}
+	if sub.BatchSize == 0 {
+		sub.BatchSize = DefaultBatchSize
+	}
+	sub.LastError = ""
+	if err := validate(sub); err != nil {
+		return Subscription{}, err
+	}
+	info, err := d.q.InspectTopic(ctx, sub.Topic)
+	if err != nil {
+		return Subscription{}, err
+	}
+	if sub.Offset < 0 {
+		sub.Offset = info.MaxOffset + 1
+	}
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.isClosed {
+		return Subscription{}, errors.New("dispatcher closed")
+	}
+	if _, ok := d.subs[sub.ID]; ok {
+		return Subscription{}, ErrSubscriptionExists
+	}
+	d.start(sub)
+	if err = d.saveLocked(); err != nil {
+		d.stopLocked(sub.ID)
+		return Subscription{}, err
+	}
+	return sub, nil
+}
+
+// Errors returned by the Dispatcher
+var (
+	ErrSubscriptionExists   = errors.New("subscription already exists")
+	ErrSubscriptionNotFound = errors.New("subscription does not exist")
+)
+
+// Unsubscribe stops and removes the subscription
+func (d *Dispatcher) Unsubscribe(id string) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if _, ok := d.subs[id]; !ok {
+		return ErrSubscriptionNotFound
+	}
+	d.stopLocked(id)
+	return d.saveLocked()
+}
+
+// Subscriptions returns the current subscriptions ordered by id
+func (d *Dispatcher) Subscriptions() []Subscription {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.snapshotLocked()
+}
+
+func (d *Dispatcher) snapshotLocked() []Subscription {
+	subs := make([]Subscription, 0, len(d.subs))
+	for _, sub := range d.subs {
+		subs = append(subs, sub.Subscription)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].ID < subs[j].ID })
+	return subs
+}
+
+// Close stops all deliveries, in flight requests are cancelled and redelivered on restart
+func (d *Dispatcher) Close() error {
+	d.mux.Lock()
+	d.isClosed = true
+	for _, sub := range d.subs {
+		sub.cancel()
+	}
+	d.mux.Unlock()
+	d.wg.Wait()
+	return nil
+}
+
+// start registers the subscription and starts its delivery goroutine, the caller must hold d.mux if
+// the dispatcher is in use
+func (d *Dispatcher) start(sub Subscription) {
+	// subscriptions are managed by admins, so deliveries are not checked against the ACL rules
+	ctx, cancel := context.WithCancel(server.WithTrusted(context.Background()))
+	s := &subscription{Subscription: sub, ctx: ctx, cancel: cancel}
+	d.subs[sub.ID] = s
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.run(s)
+	}()
+}
+
+func (d *Dispatcher) stopLocked(id string) {
+	if sub, ok := d.subs[id]; ok {
+		sub.cancel()
+		delete(d.subs, id)
+	}
+}
+
+var _ server.Logger = noOpLogger{}
+
+type noOpLogger struct{}
+
+func (noOpLogger) Debug(string, ...interface{}) {}
+func (noOpLogger) Info(string, ...interface{})  {}
+func (noOpLogger) Warn(string, ...interface{})  {}
+func (noOpLogger) Error(string, ...interface{}) {}
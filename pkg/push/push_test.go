@@ -0,0 +1,251 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/server"
+)
+
+type testQueue struct {
+	mux    sync.Mutex
+	topics map[string][][]byte
+}
+
+func (q *testQueue) InspectTopic(ctx context.Context, topic string) (*headers.TopicInfo, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	msgs, ok := q.topics[topic]
+	if !ok {
+		return nil, headers.ErrTopicDoesNotExist
+	}
+	return &headers.TopicInfo{MinOffset: 0, MaxOffset: int64(len(msgs)) - 1}, nil
+}
+
+func (q *testQueue) ConsumeMsgs(ctx context.Context, topic string, id, limit int64) ([][]byte, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	msgs, ok := q.topics[topic]
+	if !ok {
+		return nil, headers.ErrTopicDoesNotExist
+	}
+	msgs = msgs[id:]
+	if int64(len(msgs)) > limit {
+		msgs = msgs[:limit]
+	}
+	return msgs, nil
+}
+
+func (q *testQueue) produce(topic string, msgs ...string) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	for _, msg := range msgs {
+		q.topics[topic] = append(q.topics[topic], []byte(msg))
+	}
+}
+
+type delivery struct {
+	offset int64
+	msgs   []string
+	valid  bool
+}
+
+// receiver records deliveries, failing the first request to exercise retries
+func receiver(t *testing.T, deliveries chan<- delivery) *httptest.Server {
+	var mux sync.Mutex
+	failed := false
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		fail := !failed
+		failed = true
+		mux.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		sizes, err := headers.ReadSizes(r.Header)
+		if err != nil {
+			t.Error(err)
+		}
+		d := delivery{valid: server.VerifyWebhook([]byte("secret"), body, r.Header.Get(server.HeaderWebhookSignature))}
+		d.offset, _ = strconv.ParseInt(r.Header.Get(HeaderOffset), 10, 64)
+		for _, size := range sizes {
+			d.msgs = append(d.msgs, string(body[:size]))
+			body = body[size:]
+		}
+		deliveries <- d
+	}))
+}
+
+func expectDelivery(t *testing.T, deliveries <-chan delivery, offset int64, msgs ...string) {
+	t.Helper()
+	select {
+	case d := <-deliveries:
+		if d.offset != offset || !d.valid || len(d.msgs) != len(msgs) {
+			t.Fatal(d)
+		}
+		for i := range msgs {
+			if d.msgs[i] != msgs[i] {
+				t.Fatal(d)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for delivery")
+	}
+}
+
+func TestNewDispatcher(t *testing.T) {
+	if _, err := NewDispatcher(nil); err == nil {
+		t.Error("expected nil queue error")
+	}
+	q := &testQueue{topics: map[string][][]byte{}}
+	for _, opt := range []Option{
+		WithStateFile(""),
+		WithPollInterval(0),
+		WithBackoff(0, time.Second),
+		WithBackoff(time.Second, time.Millisecond),
+		WithHTTPClient(nil),
+		WithLogger(nil),
+	} {
+		if _, err := NewDispatcher(q, opt); err == nil {
+			t.Error("expected invalid option error")
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "push")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state := filepath.Join(dir, "state.json")
+	if err = ioutil.WriteFile(state, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewDispatcher(q, WithStateFile(state)); err == nil {
+		t.Error("expected invalid state file error")
+	}
+	if err = ioutil.WriteFile(state, []byte(`[{"id":"a","topic":"t","url":"ftp://x"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewDispatcher(q, WithStateFile(state)); err == nil {
+		t.Error("expected invalid subscription error")
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state := filepath.Join(dir, "state.json")
+
+	deliveries := make(chan delivery, 10)
+	ts := receiver(t, deliveries)
+	defer ts.Close()
+
+	q := &testQueue{topics: map[string][][]byte{"topic": nil}}
+	q.produce("topic", "old")
+	opts := []Option{WithStateFile(state), WithPollInterval(time.Millisecond), WithBackoff(time.Millisecond, 10*time.Millisecond)}
+	d, err := NewDispatcher(q, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, sub := range []Subscription{
+		{Topic: "missing", URL: ts.URL},
+		{Topic: "", URL: ts.URL},
+		{Topic: "topic", URL: "://bad"},
+		{Topic: "topic", URL: ts.URL, BatchSize: -1},
+		{ID: "bad id", Topic: "topic", URL: ts.URL},
+	} {
+		if _, err = d.Subscribe(ctx, sub); err == nil {
+			t.Error("expected invalid subscription error", sub)
+		}
+	}
+
+	sub, err := d.Subscribe(ctx, Subscription{ID: "sub", Topic: "topic", URL: ts.URL, Secret: "secret", BatchSize: 2, Offset: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Offset != 1 || sub.BatchSize != 2 {
+		t.Fatal(sub)
+	}
+	if _, err = d.Subscribe(ctx, sub); err != ErrSubscriptionExists {
+		t.Fatal(err)
+	}
+
+	q.produce("topic", "a", "b", "c")
+	expectDelivery(t, deliveries, 1, "a", "b")
+	expectDelivery(t, deliveries, 3, "c")
+	for start := time.Now(); d.Subscriptions()[0].Offset != 4; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("timed out waiting for checkpoint")
+		}
+	}
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// resume from the checkpoint
+	q.produce("topic", "d")
+	d, err = NewDispatcher(q, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs := d.Subscriptions()
+	if len(subs) != 1 || subs[0].ID != "sub" || subs[0].Offset != 4 {
+		t.Fatal(subs)
+	}
+	expectDelivery(t, deliveries, 4, "d")
+
+	if err = d.Unsubscribe("sub"); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Unsubscribe("sub"); err != ErrSubscriptionNotFound {
+		t.Fatal(err)
+	}
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.Subscribe(ctx, Subscription{Topic: "topic", URL: ts.URL}); err == nil {
+		t.Fatal("expected closed error")
+	}
+	b, err := ioutil.ReadFile(state)
+	if err != nil || !bytes.Equal(b, []byte("[]")) {
+		t.Fatal(string(b), err)
+	}
+}
+
+func TestDispatcher_OutOfRange(t *testing.T) {
+	deliveries := make(chan delivery, 10)
+	ts := receiver(t, deliveries)
+	defer ts.Close()
+
+	q := &testQueue{topics: map[string][][]byte{"topic": nil}}
+	d, err := NewDispatcher(q, WithPollInterval(time.Millisecond), WithBackoff(time.Millisecond, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if _, err = d.Subscribe(context.Background(), Subscription{Topic: "topic", URL: ts.URL, Secret: "secret", Offset: 10}); err != nil {
+		t.Fatal(err)
+	}
+	q.produce("topic", "a")
+	expectDelivery(t, deliveries, 0, "a")
+}
@@ -0,0 +1,52 @@
+package push
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// load reads the subscriptions from the state file, a missing file has no subscriptions
+func (d *Dispatcher) load() ([]Subscription, error) {
+	if d.stateFile == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(d.stateFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read state file")
+	}
+	var subs []Subscription
+	if err = json.Unmarshal(b, &subs); err != nil {
+		return nil, errors.Wrap(err, "unable to parse state file")
+	}
+	return subs, nil
+}
+
+// saveLocked writes the subscriptions to the state file, replacing it atomically. The caller must hold d.mux
+func (d *Dispatcher) saveLocked() error {
+	if d.stateFile == "" {
+		return nil
+	}
+	subs := d.snapshotLocked()
+	for i := range subs {
+		subs[i].LastError = ""
+	}
+	b, err := json.MarshalIndent(subs, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := d.stateFile + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "unable to write state file")
+	}
+	if err = os.Rename(tmp, d.stateFile); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrap(err, "unable to replace state file")
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// objectURL returns the url of the key, or of the bucket if key is empty
+func (s *Sink) objectURL(key string) *url.URL {
+	var u url.URL
+	if s.endpoint != nil {
+		u = *s.endpoint
+		u.Path = strings.TrimRight(u.Path, "/") + "/" + s.bucket + "/" + key
+	} else {
+		u = url.URL{Scheme: "https", Host: s.bucket + ".s3." + s.region + ".amazonaws.com", Path: "/" + key}
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	return &u
+}
+
+func (s *Sink) do(ctx context.Context, method string, u *url.URL, header http.Header, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req, sha256Hex(body), time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e struct {
+			Code    string
+			Message string
+		}
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		if xml.Unmarshal(b, &e) == nil && e.Code != "" {
+			return nil, errors.Errorf("unexpected status %d: %s: %s", resp.StatusCode, e.Code, e.Message)
+		}
+		return nil, errors.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// putObject uploads the object
+func (s *Sink) putObject(ctx context.Context, key, contentType string, body []byte) error {
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	_, err := s.do(ctx, http.MethodPut, s.objectURL(key), header, body)
+	return errors.Wrapf(err, "unable to put object %q", key)
+}
+
+// listObjects returns the keys directly under the prefix
+func (s *Sink) listObjects(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		u := s.objectURL("")
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+		b, err := s.do(ctx, http.MethodGet, u, nil, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to list objects with prefix %q", prefix)
+		}
+		var result struct {
+			IsTruncated           bool
+			NextContinuationToken string
+			Contents              []struct {
+				Key string
+			}
+		}
+		if err = xml.Unmarshal(b, &result); err != nil {
+			return nil, errors.Wrap(err, "invalid list objects response")
+		}
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
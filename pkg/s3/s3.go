@@ -0,0 +1,181 @@
+// Package s3 archives haraqa topics to S3 compatible object storage. The Sink is a connect.Sink, run
+// with a connect.Runner.
+//
+// Each topic is written to newline delimited json objects named <prefix><topic>/<first>-<last>.ndjson, where
+// first and last are the zero padded offsets of the messages in the object. Each line holds the topic and
+// offset of a message, with messages which are valid json stored in the value field and any other message
+// base64 encoded in the data field. A new object is started once the current one reaches the maximum size
+// or age, or when the sink is closed. The offset to resume from is found by listing the objects already
+// written, so no other state is kept and an object interrupted by a restart is rewritten under the same name.
+package s3
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/pkg/connect"
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+var _ connect.OffsetSink = &Sink{}
+var _ connect.Flusher = &Sink{}
+
+// Option represents a optional function argument to NewSink
+type Option func(*Sink) error
+
+// WithCredentials sets the access key, secret key and optional session token used to sign requests
+func WithCredentials(accessKey, secretKey, sessionToken string) Option {
+	return func(s *Sink) error {
+		if accessKey == "" || secretKey == "" {
+			return errors.New("access key and secret key cannot be empty")
+		}
+		s.accessKey, s.secretKey, s.sessionToken = accessKey, secretKey, sessionToken
+		return nil
+	}
+}
+
+// WithRegion sets the bucket region, the default is us-east-1
+func WithRegion(region string) Option {
+	return func(s *Sink) error {
+		if region == "" {
+			return errors.New("region cannot be empty")
+		}
+		s.region = region
+		return nil
+	}
+}
+
+// WithEndpoint sets the url of an S3 compatible service such as MinIO, objects are addressed as <endpoint>/<bucket>/<key>
+func WithEndpoint(endpoint string) Option {
+	return func(s *Sink) error {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return errors.Wrap(err, "invalid endpoint")
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Errorf("invalid endpoint %q, an http or https url is required", endpoint)
+		}
+		s.endpoint = u
+		return nil
+	}
+}
+
+// WithPrefix sets the prefix added to all object keys
+func WithPrefix(prefix string) Option {
+	return func(s *Sink) error {
+		s.prefix = strings.TrimLeft(prefix, "/")
+		return nil
+	}
+}
+
+// WithRolling sets the size in bytes and the age at which objects are written and a new object started
+func WithRolling(maxSize int64, maxAge time.Duration) Option {
+	return func(s *Sink) error {
+		if maxSize <= 0 || maxAge <= 0 {
+			return errors.New("invalid rolling, size and age must be greater than 0")
+		}
+		s.maxSize, s.maxAge = maxSize, maxAge
+		return nil
+	}
+}
+
+// WithGzip compresses objects with gzip, adding .gz to their names
+func WithGzip() Option {
+	return func(s *Sink) error {
+		s.gzip = true
+		return nil
+	}
+}
+
+// WithHTTPClient sets the client used to send requests
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *Sink) error {
+		if client == nil {
+			return errors.New("http client cannot be nil")
+		}
+		s.client = client
+		return nil
+	}
+}
+
+// WithLogger sets the logger used to report upload errors
+func WithLogger(logger server.Logger) Option {
+	return func(s *Sink) error {
+		if logger == nil {
+			return errors.New("logger cannot be nil")
+		}
+		s.logger = logger
+		return nil
+	}
+}
+
+// Sink writes topics to an S3 bucket
+type Sink struct {
+	bucket       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	region       string
+	endpoint     *url.URL
+	prefix       string
+	maxSize      int64
+	maxAge       time.Duration
+	gzip         bool
+	client       *http.Client
+	logger       server.Logger
+
+	mux     sync.Mutex
+	objects map[string]*object
+}
+
+// NewSink creates a sink writing to the bucket
+func NewSink(bucket string, opts ...Option) (*Sink, error) {
+	if bucket == "" || strings.ContainsAny(bucket, "/ ") {
+		return nil, errors.Errorf("invalid bucket %q", bucket)
+	}
+	s := &Sink{
+		bucket:  bucket,
+		region:  "us-east-1",
+		maxSize: 64 << 20,
+		maxAge:  5 * time.Minute,
+		client:  &http.Client{Timeout: 5 * time.Minute},
+		logger:  noOpLogger{},
+		objects: make(map[string]*object),
+	}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, errors.Wrap(err, "invalid option")
+		}
+	}
+	if s.accessKey == "" {
+		return nil, errors.New("credentials are required")
+	}
+	return s, nil
+}
+
+// Close writes the objects being built
+func (s *Sink) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var err error
+	for topic, o := range s.objects {
+		if e := s.flush(context.Background(), topic, o); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+var _ server.Logger = noOpLogger{}
+
+type noOpLogger struct{}
+
+func (noOpLogger) Debug(string, ...interface{}) {}
+func (noOpLogger) Info(string, ...interface{})  {}
+func (noOpLogger) Warn(string, ...interface{})  {}
+func (noOpLogger) Error(string, ...interface{}) {}
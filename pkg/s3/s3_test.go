@@ -0,0 +1,209 @@
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/pkg/connect"
+)
+
+// bucket is a minimal path style S3 server
+type bucket struct {
+	mux     sync.Mutex
+	objects map[string][]byte
+}
+
+func (b *bucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>denied</Message></Error>`))
+		return
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := ioutil.ReadAll(r.Body)
+		if sha256Hex(body) != r.Header.Get("X-Amz-Content-Sha256") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b.objects[key] = body
+	case http.MethodGet:
+		prefix := r.URL.Query().Get("prefix")
+		var result struct {
+			XMLName  xml.Name `xml:"ListBucketResult"`
+			Contents []struct{ Key string }
+		}
+		for key := range b.objects {
+			if strings.HasPrefix(key, prefix) && !strings.Contains(key[len(prefix):], "/") {
+				result.Contents = append(result.Contents, struct{ Key string }{key})
+			}
+		}
+		_ = xml.NewEncoder(w).Encode(&result)
+	}
+}
+
+func (b *bucket) keys() []string {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	var keys []string
+	for key := range b.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (b *bucket) waitFor(t *testing.T, key string) []byte {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		b.mux.Lock()
+		body, ok := b.objects[key]
+		b.mux.Unlock()
+		if ok {
+			return body
+		}
+	}
+	t.Fatal("timed out waiting for", key, b.keys())
+	return nil
+}
+
+func TestNewSink(t *testing.T) {
+	creds := WithCredentials("key", "secret", "")
+	if _, err := NewSink("a/b", creds); err == nil {
+		t.Error("expected invalid bucket error")
+	}
+	if _, err := NewSink("bucket"); err == nil {
+		t.Error("expected missing credentials error")
+	}
+	for _, opt := range []Option{
+		WithCredentials("", "", ""),
+		WithRegion(""),
+		WithEndpoint("ftp://localhost"),
+		WithEndpoint("://"),
+		WithRolling(0, time.Second),
+		WithHTTPClient(nil),
+		WithLogger(nil),
+	} {
+		if _, err := NewSink("bucket", creds, opt); err == nil {
+			t.Error("expected invalid option error")
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	prefix := "archive/t/"
+	if last, ok := parseKey(prefix, prefix+"00000000000000000002-00000000000000000010.ndjson.gz"); !ok || last != 10 {
+		t.Error(last, ok)
+	}
+	for _, key := range []string{prefix + "x.ndjson", prefix + "1-2.json", prefix + "a-b.ndjson", prefix + "n/1-2.ndjson"} {
+		if _, ok := parseKey(prefix, key); ok {
+			t.Error(key)
+		}
+	}
+}
+
+func TestSink(t *testing.T) {
+	b := &bucket{objects: map[string][]byte{}}
+	ts := httptest.NewServer(b)
+	defer ts.Close()
+
+	ctx := context.Background()
+	opts := []Option{
+		WithCredentials("key", "secret", ""),
+		WithEndpoint(ts.URL),
+		WithPrefix("/archive/"),
+		WithRolling(1, time.Hour),
+	}
+	s, err := NewSink("bucket", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset, err := s.Offset(ctx, "t"); err != nil || offset != -1 {
+		t.Fatal(offset, err)
+	}
+	err = s.Write(ctx, connect.Batch{Topic: "t", Offset: 0, Msgs: [][]byte{[]byte(`{"a":1}`), []byte("text"), {}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := b.waitFor(t, "archive/t/00000000000000000000-00000000000000000002.ndjson")
+	expected := `{"topic":"t","offset":0,"value":{"a":1}}` + "\n" +
+		`{"topic":"t","offset":1,"data":"dGV4dA=="}` + "\n" +
+		`{"topic":"t","offset":2,"data":""}` + "\n"
+	if string(body) != expected {
+		t.Fatal(string(body))
+	}
+	if err = s.Write(ctx, connect.Batch{Topic: "t", Offset: 3, Msgs: [][]byte{[]byte("3")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := b.keys(); len(keys) != 2 || keys[1] != "archive/t/00000000000000000003-00000000000000000003.ndjson" {
+		t.Fatal(keys)
+	}
+
+	// resume after the last object, skip messages written twice and write on close
+	s, err = NewSink("bucket", append(opts, WithRolling(1<<20, time.Hour), WithGzip())...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset, err := s.Offset(ctx, "t"); err != nil || offset != 4 {
+		t.Fatal(offset, err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = s.Write(ctx, connect.Batch{Topic: "t", Offset: 4, Msgs: [][]byte{[]byte("4"), []byte("5")}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Flush(ctx, "t"); err != nil || len(b.keys()) != 2 {
+		t.Fatal(b.keys(), err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	body = b.waitFor(t, "archive/t/00000000000000000004-00000000000000000005.ndjson.gz")
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err = ioutil.ReadAll(r); err != nil || string(body) != `{"topic":"t","offset":4,"value":4}`+"\n"+`{"topic":"t","offset":5,"value":5}`+"\n" {
+		t.Fatal(string(body), err)
+	}
+	if keys := b.keys(); len(keys) != 3 {
+		t.Fatal(keys)
+	}
+}
+
+func TestSink_Errors(t *testing.T) {
+	ts := httptest.NewServer(&bucket{objects: map[string][]byte{}})
+	defer ts.Close()
+	s, err := NewSink("bucket", WithCredentials("other", "secret", "token"), WithEndpoint(ts.URL), WithRolling(1<<20, time.Nanosecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err = s.Offset(ctx, "t"); err == nil || !strings.Contains(err.Error(), "AccessDenied: denied") {
+		t.Fatal(err)
+	}
+	// the object is kept after a failed write
+	batch := connect.Batch{Topic: "t", Offset: 0, Msgs: [][]byte{[]byte("0")}}
+	if err = s.Write(ctx, batch); err == nil {
+		t.Fatal("expected write error")
+	}
+	if err = s.Flush(ctx, "t"); err == nil || s.objects["t"].count != 1 {
+		t.Fatal(err)
+	}
+}
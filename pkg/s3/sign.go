@@ -0,0 +1,26 @@
+package s3
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/sigv4"
+)
+
+const emptyBodySHA256 = sigv4.EmptyBodySHA256
+
+// sign adds an AWS signature version 4 authorization header to the request. All headers already set on
+// the request are signed along with the host
+func (s *Sink) sign(req *http.Request, payloadHash string, now time.Time) {
+	creds := sigv4.Credentials{AccessKey: s.accessKey, SecretKey: s.secretKey, SessionToken: s.sessionToken}
+	sigv4.Sign(req, creds, s.region, "s3", payloadHash, now)
+}
+
+// uriEncode percent encodes everything except unreserved characters, and slashes unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	return sigv4.URIEncode(s, encodeSlash)
+}
+
+func sha256Hex(b []byte) string {
+	return sigv4.SHA256Hex(b)
+}
@@ -0,0 +1,177 @@
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/haraqa/haraqa/pkg/connect"
+	"github.com/pkg/errors"
+)
+
+// object is the object being built for a topic
+type object struct {
+	buf     bytes.Buffer
+	first   int64
+	count   int64
+	started time.Time
+}
+
+type line struct {
+	Topic  string          `json:"topic"`
+	Offset int64           `json:"offset"`
+	Value  json.RawMessage `json:"value,omitempty"`
+	Data   *[]byte         `json:"data,omitempty"`
+}
+
+func (o *object) add(topic string, offset int64, msg []byte) error {
+	if o.count == 0 {
+		o.first, o.started = offset, time.Now()
+	}
+	l := line{Topic: topic, Offset: offset}
+	if json.Valid(msg) {
+		l.Value = msg
+	} else {
+		l.Data = &msg
+	}
+	b, err := json.Marshal(&l)
+	if err != nil {
+		return err
+	}
+	o.buf.Write(b)
+	o.buf.WriteByte('\n')
+	o.count++
+	return nil
+}
+
+// topicPrefix returns the prefix of the objects of a topic
+func (s *Sink) topicPrefix(topic string) string {
+	return s.prefix + strings.Trim(topic, "/") + "/"
+}
+
+// objectKey returns the name of the object holding the offsets first to last
+func (s *Sink) objectKey(topic string, first, last int64) string {
+	key := fmt.Sprintf("%s%020d-%020d.ndjson", s.topicPrefix(topic), first, last)
+	if s.gzip {
+		key += ".gz"
+	}
+	return key
+}
+
+// parseKey returns the last offset in the object, or false if the key is not an object written by the sink
+func parseKey(prefix, key string) (int64, bool) {
+	name := strings.TrimSuffix(strings.TrimPrefix(key, prefix), ".gz")
+	if !strings.HasSuffix(name, ".ndjson") || strings.Contains(name, "/") {
+		return 0, false
+	}
+	parts := strings.Split(strings.TrimSuffix(name, ".ndjson"), "-")
+	if len(parts) != 2 {
+		return 0, false
+	}
+	if _, err := strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return 0, false
+	}
+	last, err := strconv.ParseInt(parts[1], 10, 64)
+	return last, err == nil
+}
+
+// Offset returns the offset after the last object written for the topic, or -1 if there are none
+func (s *Sink) Offset(ctx context.Context, topic string) (int64, error) {
+	prefix := s.topicPrefix(topic)
+	keys, err := s.listObjects(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	next := int64(-1)
+	for _, key := range keys {
+		if last, ok := parseKey(prefix, key); ok && last+1 > next {
+			next = last + 1
+		}
+	}
+	return next, nil
+}
+
+// Write adds the messages to the object being built for the topic, writing it once it reaches the
+// maximum size or age. Messages already added by an earlier attempt are skipped
+func (s *Sink) Write(ctx context.Context, batch connect.Batch) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	o, ok := s.objects[batch.Topic]
+	if !ok {
+		o = &object{}
+		s.objects[batch.Topic] = o
+	}
+	offset, msgs := batch.Offset, batch.Msgs
+	if o.count > 0 {
+		next := o.first + o.count
+		if skip := next - offset; skip > 0 {
+			if skip > int64(len(msgs)) {
+				skip = int64(len(msgs))
+			}
+			offset, msgs = offset+skip, msgs[skip:]
+		}
+		if len(msgs) > 0 && offset != next {
+			// messages were truncated, objects always hold consecutive offsets
+			if err := s.flush(ctx, batch.Topic, o); err != nil {
+				return err
+			}
+		}
+	}
+	for _, msg := range msgs {
+		if err := o.add(batch.Topic, offset, msg); err != nil {
+			return err
+		}
+		offset++
+	}
+	if int64(o.buf.Len()) >= s.maxSize {
+		return s.flush(ctx, batch.Topic, o)
+	}
+	return s.flushOld(ctx, batch.Topic, o)
+}
+
+// Flush writes the object being built for the topic if it has reached the maximum age
+func (s *Sink) Flush(ctx context.Context, topic string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	o, ok := s.objects[topic]
+	if !ok {
+		return nil
+	}
+	return s.flushOld(ctx, topic, o)
+}
+
+func (s *Sink) flushOld(ctx context.Context, topic string, o *object) error {
+	if o.count > 0 && time.Since(o.started) >= s.maxAge {
+		return s.flush(ctx, topic, o)
+	}
+	return nil
+}
+
+// flush uploads the object and resets it, the object is kept if the upload fails
+func (s *Sink) flush(ctx context.Context, topic string, o *object) error {
+	if o.count == 0 {
+		return nil
+	}
+	body := o.buf.Bytes()
+	contentType := "application/x-ndjson"
+	if s.gzip {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		_, _ = w.Write(body)
+		_ = w.Close()
+		body, contentType = buf.Bytes(), "application/gzip"
+	}
+	key := s.objectKey(topic, o.first, o.first+o.count-1)
+	if err := s.putObject(ctx, key, contentType, body); err != nil {
+		return errors.Wrapf(err, "unable to write s3 object %q", key)
+	}
+	s.logger.Debug("wrote s3 object", "topic", topic, "key", key, "messages", o.count)
+	o.buf.Reset()
+	o.count = 0
+	return nil
+}
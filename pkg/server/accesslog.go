@@ -0,0 +1,244 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// Access log formats
+const (
+	AccessLogCommon   = "common"
+	AccessLogCombined = "combined"
+	AccessLogJSON     = "json"
+)
+
+// AccessLogFields are the fields an access log can write, in the order of json access logs by default. The
+// count is the number of messages in the batch produced or consumed and the duration is in seconds
+var AccessLogFields = []string{
+	"time", "remote", "principal", "method", "path", "query", "proto", "status", "duration",
+	"requestSize", "responseSize", "topic", "count", "referer", "userAgent",
+}
+
+// WithAccessLog writes a line to w for every request once it completes, in the common or combined log
+// format or as json. The fields are the keys of json lines, every field by default, and are appended to
+// common and combined lines as key=value pairs unless the format already has them. Clients behind the
+// trusted proxies of WithNetworkACL are logged with their own address
+func WithAccessLog(w io.Writer, format string, fields ...string) Option {
+	return func(s *Server) error {
+		if w == nil {
+			return errors.New("invalid access log, writer cannot be nil")
+		}
+		format = strings.ToLower(format)
+		switch format {
+		case AccessLogCommon, AccessLogCombined, AccessLogJSON:
+		default:
+			return errors.Errorf("invalid access log format %q, expected one of common, combined or json", format)
+		}
+		for _, field := range fields {
+			if !isAccessLogField(field) {
+				return errors.Errorf("invalid access log field %q", field)
+			}
+		}
+		if format == AccessLogJSON && len(fields) == 0 {
+			fields = AccessLogFields
+		}
+		if format != AccessLogJSON {
+			fields = extraAccessLogFields(format, fields)
+		}
+		s.accessLog = &accessLog{w: w, format: format, fields: fields}
+		return nil
+	}
+}
+
+// ParseAccessLogFields splits a comma separated list of access log fields, returning an error for unknown fields
+func ParseAccessLogFields(list string) ([]string, error) {
+	var fields []string
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !isAccessLogField(field) {
+			return nil, errors.Errorf("invalid access log field %q", field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func isAccessLogField(field string) bool {
+	for _, f := range AccessLogFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// extraAccessLogFields returns the fields which the common or combined format does not already write
+func extraAccessLogFields(format string, fields []string) []string {
+	var extra []string
+	for _, field := range fields {
+		switch field {
+		case "time", "remote", "principal", "method", "path", "query", "proto", "status", "responseSize":
+			continue
+		case "referer", "userAgent":
+			if format == AccessLogCombined {
+				continue
+			}
+		}
+		extra = append(extra, field)
+	}
+	return extra
+}
+
+// accessLog writes the lines of completed requests, one at a time
+type accessLog struct {
+	mux    sync.Mutex
+	w      io.Writer
+	format string
+	fields []string
+}
+
+// accessEntry is a completed request
+type accessEntry struct {
+	start     time.Time
+	duration  time.Duration
+	remote    string
+	principal string
+	request   *http.Request
+	status    int
+	size      int64
+	count     int
+}
+
+type accessEntryKey struct{}
+
+// value returns the value of the field for the entry
+func (e *accessEntry) value(field string) interface{} {
+	r := e.request
+	switch field {
+	case "time":
+		return e.start
+	case "remote":
+		return e.remote
+	case "principal":
+		return e.principal
+	case "method":
+		return r.Method
+	case "path":
+		return r.URL.Path
+	case "query":
+		return r.URL.RawQuery
+	case "proto":
+		return r.Proto
+	case "status":
+		return e.status
+	case "duration":
+		return e.duration.Seconds()
+	case "requestSize":
+		return r.ContentLength
+	case "responseSize":
+		return e.size
+	case "topic":
+		if !strings.HasPrefix(r.URL.Path, "/topics/") {
+			return ""
+		}
+		topic := strings.TrimPrefix(r.URL.Path, "/topics/")
+		if isWatch(r) {
+			topic = strings.TrimSuffix(topic, watchSuffix)
+		} else if isCount(r) {
+			topic = strings.TrimSuffix(topic, countSuffix)
+		}
+		topic, _ = cleanTopic(topic)
+		return topic
+	case "count":
+		return e.count
+	case "referer":
+		return r.Referer()
+	case "userAgent":
+		return r.UserAgent()
+	}
+	return nil
+}
+
+// write formats the entry and writes it as a line
+func (l *accessLog) write(e *accessEntry) {
+	var buf bytes.Buffer
+	if l.format == AccessLogJSON {
+		buf.WriteByte('{')
+		for i, field := range l.fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			b, _ := json.Marshal(e.value(field))
+			buf.WriteString(strconv.Quote(field))
+			buf.WriteByte(':')
+			buf.Write(b)
+		}
+		buf.WriteString("}\n")
+	} else {
+		user := e.principal
+		if user == "" {
+			user = "-"
+		}
+		size := "-"
+		if e.size > 0 {
+			size = strconv.FormatInt(e.size, 10)
+		}
+		r := e.request
+		fmt.Fprintf(&buf, "%s - %s [%s] %q %d %s", e.remote, user, e.start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+r.URL.RequestURI()+" "+r.Proto, e.status, size)
+		if l.format == AccessLogCombined {
+			fmt.Fprintf(&buf, " %q %q", r.Referer(), r.UserAgent())
+		}
+		for _, field := range l.fields {
+			buf.WriteString(" " + field + "=" + logfmtValue(e.value(field)))
+		}
+		buf.WriteByte('\n')
+	}
+
+	l.mux.Lock()
+	_, _ = l.w.Write(buf.Bytes())
+	l.mux.Unlock()
+}
+
+// logAccess wraps the handler, writing each request to the access log once it completes
+func (s *Server) logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry := &accessEntry{start: time.Now(), remote: remoteIP(r), request: r}
+		if s.network != nil {
+			if ip := s.network.clientIP(r); ip != nil {
+				entry.remote = ip.String()
+			}
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), accessEntryKey{}, entry)))
+
+		entry.duration = time.Since(entry.start)
+		entry.status, entry.size = sw.status, sw.size
+		entry.count = len(r.Header[headers.HeaderSizes]) + len(w.Header()[headers.HeaderSizes])
+		s.accessLog.write(entry)
+	})
+}
+
+// recordPrincipal records the principal authenticated by the middlewares in the request's access log entry
+func (s *Server) recordPrincipal(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if entry, ok := r.Context().Value(accessEntryKey{}).(*accessEntry); ok {
+			entry.principal = Principal(r.Context())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
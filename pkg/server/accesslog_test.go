@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestWithAccessLog(t *testing.T) {
+	for _, option := range []Option{
+		WithAccessLog(nil, AccessLogJSON),
+		WithAccessLog(&bytes.Buffer{}, "apache"),
+		WithAccessLog(&bytes.Buffer{}, AccessLogJSON, "topic", "bytes"),
+	} {
+		if err := option(&Server{}); err == nil {
+			t.Error("expected invalid option error")
+		}
+	}
+	s := &Server{}
+	if err := WithAccessLog(&bytes.Buffer{}, "JSON")(s); err != nil || len(s.accessLog.fields) != len(AccessLogFields) {
+		t.Fatal(err)
+	}
+	// the common and combined formats only append the fields they do not have
+	if err := WithAccessLog(&bytes.Buffer{}, AccessLogCombined, "status", "topic", "userAgent", "count")(s); err != nil ||
+		strings.Join(s.accessLog.fields, ",") != "topic,count" {
+		t.Fatal(s.accessLog.fields, err)
+	}
+
+	if fields, err := ParseAccessLogFields(" topic, count,,status "); err != nil || strings.Join(fields, ",") != "topic,count,status" {
+		t.Fatal(fields, err)
+	}
+	if _, err := ParseAccessLogFields("topic,size"); err == nil {
+		t.Fatal("expected invalid field error")
+	}
+}
+
+func TestServer_AccessLog(t *testing.T) {
+	dir := ".haraqa-access-log"
+	defer os.RemoveAll(dir)
+	authenticate := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), r.Header.Get("User"))))
+		})
+	}
+	var buf bytes.Buffer
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithACL("", "root"), WithMiddleware(authenticate),
+		WithAccessLog(&buf, AccessLogJSON, "remote", "principal", "method", "path", "status", "topic", "count"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	request := func(user, method, path, body string, h http.Header) {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range h {
+			r.Header[k] = v
+		}
+		r.Header.Set("User", user)
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	request("root", http.MethodPut, "/topics/orders", "", nil)
+	request("root", http.MethodPost, "/topics/orders", "abcde", http.Header{headers.HeaderSizes: {"2", "3"}})
+	request("app", http.MethodGet, "/topics/orders?id=0", "", nil)
+
+	type line struct {
+		Remote    string `json:"remote"`
+		Principal string `json:"principal"`
+		Method    string `json:"method"`
+		Path      string `json:"path"`
+		Status    int    `json:"status"`
+		Topic     string `json:"topic"`
+		Count     int    `json:"count"`
+	}
+	var lines []line
+	for _, b := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var l line
+		if err = json.Unmarshal(b, &l); err != nil {
+			t.Fatal(string(b), err)
+		}
+		lines = append(lines, l)
+	}
+	if len(lines) != 3 {
+		t.Fatal(buf.String())
+	}
+	if l := lines[1]; l.Remote != "192.0.2.1" || l.Principal != "root" || l.Method != http.MethodPost || l.Path != "/topics/orders" ||
+		l.Status != http.StatusNoContent || l.Topic != "orders" || l.Count != 2 {
+		t.Fatal(l)
+	}
+	// requests rejected by the acl are logged with their principal
+	if l := lines[2]; l.Principal != "app" || l.Status != http.StatusForbidden {
+		t.Fatal(l)
+	}
+	if !strings.HasPrefix(buf.String(), `{"remote":"192.0.2.1","principal":"root","method":"PUT"`) {
+		t.Fatal(buf.String())
+	}
+}
+
+func TestAccessLog_Write(t *testing.T) {
+	var buf bytes.Buffer
+	l := &accessLog{w: &buf, format: AccessLogCombined, fields: []string{"topic", "count"}}
+	r := httptest.NewRequest(http.MethodGet, "/topics/orders?id=5", nil)
+	r.Header.Set("User-Agent", "curl/7.68.0")
+	start := time.Date(2021, time.January, 30, 10, 30, 15, 0, time.UTC)
+	entry := &accessEntry{start: start, remote: "192.0.2.1", request: r, status: http.StatusOK, size: 42, count: 3}
+	l.write(entry)
+	expected := `192.0.2.1 - - [30/Jan/2021:10:30:15 +0000] "GET /topics/orders?id=5 HTTP/1.1" 200 42 "" "curl/7.68.0" topic=orders count=3` + "\n"
+	if buf.String() != expected {
+		t.Fatalf("%q", buf.String())
+	}
+
+	buf.Reset()
+	l.format, l.fields = AccessLogCommon, nil
+	entry.principal, entry.size = "app", 0
+	l.write(entry)
+	if expected = `192.0.2.1 - app [30/Jan/2021:10:30:15 +0000] "GET /topics/orders?id=5 HTTP/1.1" 200 -` + "\n"; buf.String() != expected {
+		t.Fatalf("%q", buf.String())
+	}
+}
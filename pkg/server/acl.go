@@ -0,0 +1,461 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// WithACL enables authorization of requests against the ACL rules managed at the /acl endpoint. Rules are
+// stored in the file, or only in memory if it is empty. The admins are principals granted every permission
+// whatever the rules, so that rules can be managed before any are defined. Requests are made as the
+// principal set in their context with WithPrincipal, usually by an authentication middleware
+func WithACL(file string, admins ...string) Option {
+	return func(s *Server) error {
+		a := &accessList{file: file, version: 1, admins: make(map[string]bool, len(admins))}
+		for _, admin := range admins {
+			if admin = strings.TrimSpace(admin); admin != "" {
+				a.admins[admin] = true
+			}
+		}
+		if err := a.load(); err != nil {
+			return err
+		}
+		s.acl = a
+		return nil
+	}
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of the context carrying the authenticated principal of a request, which the
+// ACL rules are checked against
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// Principal returns the principal set in the context with WithPrincipal, empty for anonymous requests
+func Principal(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
+
+type trustedKey struct{}
+
+// WithTrusted returns a copy of the context for calls made on behalf of the operator rather than a client, such
+// as by the connectors, push subscriptions and bridges configured with the server. The message methods do not
+// check the ACL rules for them
+func WithTrusted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, trustedKey{}, true)
+}
+
+// trusted returns true if the context was returned by WithTrusted
+func trusted(ctx context.Context) bool {
+	ok, _ := ctx.Value(trustedKey{}).(bool)
+	return ok
+}
+
+// accessList holds the ACL rules, the version is incremented with each change
+type accessList struct {
+	file    string
+	admins  map[string]bool
+	mux     sync.RWMutex
+	version int64
+	rules   []headers.ACLRule
+}
+
+// load reads the stored rules
+func (a *accessList) load() error {
+	if a.file == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(a.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to read acl file")
+	}
+	var acl headers.ACL
+	if err = json.Unmarshal(b, &acl); err != nil {
+		return errors.Wrap(err, "unable to parse acl file")
+	}
+	rules, err := cleanRules(acl.Rules)
+	if err != nil {
+		return errors.Wrap(err, "invalid acl file")
+	}
+	a.rules = rules
+	if acl.Version > a.version {
+		a.version = acl.Version
+	}
+	return nil
+}
+
+// save writes the rules to the file, replacing it atomically
+func (a *accessList) save() error {
+	if a.file == "" {
+		return nil
+	}
+	b, err := json.Marshal(headers.ACL{Version: a.version, Rules: a.rules})
+	if err != nil {
+		return err
+	}
+	tmp := a.file + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "unable to write acl file")
+	}
+	if err = os.Rename(tmp, a.file); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrap(err, "unable to replace acl file")
+	}
+	return nil
+}
+
+// allowed returns true if the principal is an admin or a rule grants it the permission on the topic
+func (a *accessList) allowed(principal, topic, permission string) bool {
+	if a.admins[principal] {
+		return true
+	}
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+	for _, rule := range a.rules {
+		if rule.Principal != "*" && rule.Principal != principal {
+			continue
+		}
+		if !matchTopic(rule.Topic, topic) {
+			continue
+		}
+		for _, p := range rule.Permissions {
+			if p == permission || p == headers.PermissionAdmin {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// update applies the change to a copy of the rules and saves them as the next version. The change is
+// rejected with ErrACLConflict if version is not 0 and is not the current version
+func (a *accessList) update(version int64, change func(rules []headers.ACLRule) ([]headers.ACLRule, error)) (int64, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if version != 0 && version != a.version {
+		return a.version, headers.ErrACLConflict
+	}
+	rules, err := change(append([]headers.ACLRule{}, a.rules...))
+	if err != nil {
+		return a.version, err
+	}
+	rules, err = cleanRules(rules)
+	if err != nil {
+		return a.version, err
+	}
+	old := a.rules
+	a.rules, a.version = rules, a.version+1
+	if err = a.save(); err != nil {
+		a.rules, a.version = old, a.version-1
+		return a.version, err
+	}
+	return a.version, nil
+}
+
+// export returns a copy of the rules and their version
+func (a *accessList) export() *headers.ACL {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+	return &headers.ACL{Version: a.version, Rules: append([]headers.ACLRule{}, a.rules...)}
+}
+
+// matchTopic returns true if the topic of a rule matches the topic, a rule topic ending in "*" matches
+// every topic starting with the rest of it
+func matchTopic(pattern, topic string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(topic, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == topic
+}
+
+// cleanRules validates the rules, generating the ids of rules without one
+func cleanRules(rules []headers.ACLRule) ([]headers.ACLRule, error) {
+	ids := make(map[string]bool, len(rules))
+	for i := range rules {
+		rule := &rules[i]
+		if rule.ID == "" {
+			id, err := headers.NewMessageID()
+			if err != nil {
+				return nil, err
+			}
+			rule.ID = id.String()
+		}
+		if ids[rule.ID] {
+			return nil, errors.Wrapf(headers.ErrInvalidBodyJSON, "duplicate acl rule id %q", rule.ID)
+		}
+		ids[rule.ID] = true
+		if rule.Principal == "" {
+			return nil, errors.Wrapf(headers.ErrInvalidBodyJSON, "acl rule %q has no principal", rule.ID)
+		}
+		if rule.Topic != "*" {
+			topic, err := cleanTopic(strings.TrimSuffix(rule.Topic, "*"))
+			if err != nil {
+				return nil, errors.Wrapf(headers.ErrInvalidBodyJSON, "acl rule %q has an invalid topic", rule.ID)
+			}
+			if strings.HasSuffix(rule.Topic, "*") {
+				topic += "*"
+			}
+			rule.Topic = topic
+		}
+		if len(rule.Permissions) == 0 {
+			return nil, errors.Wrapf(headers.ErrInvalidBodyJSON, "acl rule %q has no permissions", rule.ID)
+		}
+		for _, p := range rule.Permissions {
+			switch p {
+			case headers.PermissionConsume, headers.PermissionProduce, headers.PermissionAdmin:
+			default:
+				return nil, errors.Wrapf(headers.ErrInvalidBodyJSON, "acl rule %q has an unknown permission %q", rule.ID, p)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// requiredPermission returns the topic and permission a request requires, ok is false if it requires none.
+// Consumes, watches and counts require consume, produces require produce and changes to topics, their schemas,
+// the raw files, the acl, the keys, the produce quotas, the schedules and resets of group offsets require admin
+func requiredPermission(r *http.Request) (topic, permission string, ok bool) {
+	path := r.URL.Path
+	switch {
+	case path == "/acl" || strings.HasPrefix(path, "/acl/"), strings.HasPrefix(path, "/raw"),
+		path == "/keys" || strings.HasPrefix(path, "/keys/"), path == "/quotas" || strings.HasPrefix(path, "/quotas/"),
+		path == "/schedules" || strings.HasPrefix(path, "/schedules/"),
+		strings.HasPrefix(path, "/groups/") && r.Method == http.MethodPatch:
+		return "*", headers.PermissionAdmin, true
+	case strings.HasPrefix(path, "/topics/") && len(path) > len("/topics/"):
+		topic := strings.TrimPrefix(path, "/topics/")
+		if isWatch(r) {
+			topic = strings.TrimSuffix(topic, watchSuffix)
+		} else if isCount(r) {
+			topic = strings.TrimSuffix(topic, countSuffix)
+		}
+		topic, err := cleanTopic(topic)
+		if err != nil {
+			return "", "", false
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			return topic, headers.PermissionConsume, true
+		case http.MethodPost:
+			return topic, headers.PermissionProduce, true
+		case http.MethodPut, http.MethodPatch, http.MethodDelete:
+			return topic, headers.PermissionAdmin, true
+		}
+	case strings.HasPrefix(path, "/schemas/") && r.Method != http.MethodGet:
+		topic, err := cleanTopic(strings.TrimPrefix(path, "/schemas/"))
+		if err != nil {
+			return "", "", false
+		}
+		return topic, headers.PermissionAdmin, true
+	}
+	return "", "", false
+}
+
+// allowed returns true if the ACL rules grant the principal of the request the permission on the topic, or
+// the scopes of its access token do
+func (s *Server) allowed(ctx context.Context, topic, permission string) bool {
+	if s.acl != nil && s.acl.allowed(Principal(ctx), topic, permission) {
+		return true
+	}
+	return granted(ctx, topic, permission)
+}
+
+// authorizes returns true if requests are checked against the ACL rules or the scopes of access tokens
+func (s *Server) authorizes() bool {
+	return s.acl != nil || (s.oidc != nil && len(s.oidc.scopes) > 0)
+}
+
+// permit returns ErrForbidden unless the principal of the context has the permission on the topic, or the
+// server does not authorize requests. Trusted calls are always permitted
+func (s *Server) permit(ctx context.Context, topic, permission string) error {
+	if !s.authorizes() || trusted(ctx) || s.allowed(ctx, topic, permission) {
+		return nil
+	}
+	s.logger.Warn("request forbidden", "principal", Principal(ctx), "topic", topic, "permission", permission)
+	return headers.ErrForbidden
+}
+
+// authorize rejects requests with ErrForbidden unless their principal has the permission they require
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if topic, permission, ok := requiredPermission(r); ok {
+			if err := s.permit(r.Context(), topic, permission); err != nil {
+				headers.SetError(w, err)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ACL returns the ACL rules and their version
+func (s *Server) ACL(ctx context.Context) (*headers.ACL, error) {
+	if s.acl == nil {
+		return nil, errors.New("acl is not enabled")
+	}
+	return s.acl.export(), nil
+}
+
+// PutACLRule adds the rule, or replaces the rule with the same id, generating an id if it is empty. The
+// change is rejected with ErrACLConflict unless version is 0 or the current version of the rules. The
+// rule and the new version are returned
+func (s *Server) PutACLRule(ctx context.Context, rule headers.ACLRule, version int64) (*headers.ACLRule, int64, error) {
+	if s.acl == nil {
+		return nil, 0, errors.New("acl is not enabled")
+	}
+	cleaned, err := cleanRules([]headers.ACLRule{rule})
+	if err != nil {
+		return nil, 0, err
+	}
+	rule = cleaned[0]
+	version, err = s.acl.update(version, func(rules []headers.ACLRule) ([]headers.ACLRule, error) {
+		for i := range rules {
+			if rules[i].ID == rule.ID {
+				rules[i] = rule
+				return rules, nil
+			}
+		}
+		return append(rules, rule), nil
+	})
+	if err != nil {
+		return nil, version, err
+	}
+	s.logger.Info("acl rule updated", "id", rule.ID, "principal", rule.Principal, "topic", rule.Topic)
+	return &rule, version, nil
+}
+
+// DeleteACLRule removes the rule with the id. The change is rejected with ErrACLConflict unless version is
+// 0 or the current version of the rules. The new version is returned
+func (s *Server) DeleteACLRule(ctx context.Context, id string, version int64) (int64, error) {
+	if s.acl == nil {
+		return 0, errors.New("acl is not enabled")
+	}
+	version, err := s.acl.update(version, func(rules []headers.ACLRule) ([]headers.ACLRule, error) {
+		for i := range rules {
+			if rules[i].ID == id {
+				return append(rules[:i], rules[i+1:]...), nil
+			}
+		}
+		return nil, headers.ErrACLRuleDoesNotExist
+	})
+	if err != nil {
+		return version, err
+	}
+	s.logger.Info("acl rule deleted", "id", id)
+	return version, nil
+}
+
+// ImportACL replaces every rule with the rules of the acl, such as those exported by ACL. The change is
+// rejected with ErrACLConflict unless version is 0 or the current version of the rules. The imported
+// rules and their version are returned
+func (s *Server) ImportACL(ctx context.Context, acl headers.ACL, version int64) (*headers.ACL, error) {
+	if s.acl == nil {
+		return nil, errors.New("acl is not enabled")
+	}
+	if _, err := s.acl.update(version, func([]headers.ACLRule) ([]headers.ACLRule, error) {
+		return append([]headers.ACLRule{}, acl.Rules...), nil
+	}); err != nil {
+		return nil, err
+	}
+	imported := s.acl.export()
+	s.logger.Info("acl imported", "rules", len(imported.Rules), "version", imported.Version)
+	return imported, nil
+}
+
+// HandleACL handles requests to the /acl endpoints. GET /acl exports the rules and PUT /acl imports them,
+// replacing every rule. POST /acl adds a rule, GET and PUT /acl/{id} return and replace a rule and DELETE
+// /acl/{id} removes it. Every response has the version of the rules as its ETag, changes are only made if
+// the If-Match header is missing or matches the current version
+func (s *Server) HandleACL(w http.ResponseWriter, r *http.Request) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+	var version int64
+	if match := strings.Trim(r.Header.Get("If-Match"), `"`); match != "" && match != "*" {
+		var err error
+		if version, err = strconv.ParseInt(match, 10, 64); err != nil || version < 1 {
+			headers.SetError(w, headers.ErrACLConflict)
+			return
+		}
+	}
+	setVersion := func(version int64) {
+		w.Header().Set("ETag", `"`+strconv.FormatInt(version, 10)+`"`)
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/acl"), "/")
+	switch {
+	case r.Method == http.MethodGet:
+		acl, err := s.ACL(r.Context())
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		setVersion(acl.Version)
+		if id == "" {
+			writeSchemaJSON(w, http.StatusOK, acl)
+			return
+		}
+		for _, rule := range acl.Rules {
+			if rule.ID == id {
+				writeSchemaJSON(w, http.StatusOK, rule)
+				return
+			}
+		}
+		headers.SetError(w, headers.ErrACLRuleDoesNotExist)
+	case r.Method == http.MethodPut && id == "":
+		var acl headers.ACL
+		if r.Body == nil || json.NewDecoder(r.Body).Decode(&acl) != nil {
+			headers.SetError(w, headers.ErrInvalidBodyJSON)
+			return
+		}
+		imported, err := s.ImportACL(r.Context(), acl, version)
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		setVersion(imported.Version)
+		writeSchemaJSON(w, http.StatusOK, imported)
+	case r.Method == http.MethodPost && id == "", r.Method == http.MethodPut:
+		var rule headers.ACLRule
+		if r.Body == nil || json.NewDecoder(r.Body).Decode(&rule) != nil {
+			headers.SetError(w, headers.ErrInvalidBodyJSON)
+			return
+		}
+		status := http.StatusCreated
+		if id != "" {
+			rule.ID, status = id, http.StatusOK
+		}
+		put, version, err := s.PutACLRule(r.Context(), rule, version)
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		setVersion(version)
+		writeSchemaJSON(w, status, put)
+	case r.Method == http.MethodDelete && id != "":
+		version, err := s.DeleteACLRule(r.Context(), id, version)
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		setVersion(version)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestServer_ACL(t *testing.T) {
+	dir, file := ".haraqa-acl", ".haraqa-acl.json"
+	defer os.RemoveAll(dir)
+	defer os.Remove(file)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithACL(file, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	acl, err := s.ACL(ctx)
+	if err != nil || acl.Version != 1 || len(acl.Rules) != 0 {
+		t.Fatal(acl, err)
+	}
+	rule, version, err := s.PutACLRule(ctx, headers.ACLRule{Principal: "app", Topic: "Orders*", Permissions: []string{"produce"}}, 1)
+	if err != nil || version != 2 || rule.ID == "" || rule.Topic != "orders*" {
+		t.Fatal(rule, version, err)
+	}
+
+	// changes to an old version are rejected
+	if _, _, err = s.PutACLRule(ctx, headers.ACLRule{Principal: "app", Topic: "*", Permissions: []string{"admin"}}, 1); err != headers.ErrACLConflict {
+		t.Fatal(err)
+	}
+	if _, _, err = s.PutACLRule(ctx, headers.ACLRule{Principal: "app", Topic: "*", Permissions: []string{"write"}}, 0); errors.Cause(err) != headers.ErrInvalidBodyJSON {
+		t.Fatal(err)
+	}
+
+	// a rule is replaced by id
+	rule.Permissions = []string{"produce", "consume"}
+	if _, version, err = s.PutACLRule(ctx, *rule, version); err != nil || version != 3 {
+		t.Fatal(version, err)
+	}
+	if _, err = s.DeleteACLRule(ctx, "unknown", 0); err != headers.ErrACLRuleDoesNotExist {
+		t.Fatal(err)
+	}
+	if acl, err = s.ACL(ctx); err != nil || len(acl.Rules) != 1 || len(acl.Rules[0].Permissions) != 2 {
+		t.Fatal(acl, err)
+	}
+
+	// the rules are restored from the file
+	restored := &accessList{file: file, version: 1}
+	if err = restored.load(); err != nil || restored.version != 3 || !reflect.DeepEqual(restored.rules, acl.Rules) {
+		t.Fatal(restored, err)
+	}
+
+	// an import replaces every rule
+	imported, err := s.ImportACL(ctx, headers.ACL{Rules: []headers.ACLRule{
+		{ID: "a", Principal: "*", Topic: "public", Permissions: []string{"consume"}},
+		{ID: "b", Principal: "app", Topic: "orders", Permissions: []string{"produce"}},
+	}}, 3)
+	if err != nil || imported.Version != 4 || len(imported.Rules) != 2 {
+		t.Fatal(imported, err)
+	}
+	if _, err = s.ImportACL(ctx, headers.ACL{Rules: []headers.ACLRule{{ID: "a", Principal: "x", Topic: "t", Permissions: []string{"consume"}}, {ID: "a", Principal: "y", Topic: "t", Permissions: []string{"consume"}}}}, 0); errors.Cause(err) != headers.ErrInvalidBodyJSON {
+		t.Fatal(err)
+	}
+	if version, err = s.DeleteACLRule(ctx, "a", 4); err != nil || version != 5 {
+		t.Fatal(version, err)
+	}
+
+	if _, err = (&Server{}).ACL(ctx); err == nil {
+		t.Fatal("expected acl is not enabled error")
+	}
+}
+
+func TestServer_ACLMessages(t *testing.T) {
+	dir := ".haraqa-acl-messages"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithACL("", "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, _, err = s.PutACLRule(context.Background(), headers.ACLRule{Principal: "app", Topic: "orders", Permissions: []string{"produce"}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = s.PutACLRule(context.Background(), headers.ACLRule{Principal: "*", Topic: "public", Permissions: []string{"consume"}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	root := WithPrincipal(context.Background(), "root")
+	app := WithPrincipal(context.Background(), "app")
+	anonymous := context.Background()
+	for _, topic := range []string{"orders", "public"} {
+		if err = s.CreateTopic(root, topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// protocol listeners calling the message methods are checked like http requests
+	if err = s.ProduceMsgs(app, "orders", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(anonymous, "orders", []byte("b")); err != headers.ErrForbidden {
+		t.Fatal(err)
+	}
+	if _, err = s.ConsumeMsgs(app, "orders", 0, -1); err != headers.ErrForbidden {
+		t.Fatal(err)
+	}
+	if _, err = s.ConsumeMsgs(anonymous, "public", 0, -1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.InspectTopic(app, "public"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.CountMessages(anonymous, "orders", 0, 1); err != headers.ErrForbidden {
+		t.Fatal(err)
+	}
+	if err = s.CreateTopic(app, "created"); err != headers.ErrForbidden {
+		t.Fatal(err)
+	}
+	if _, err = s.PurgeTopic(app, "orders"); err != headers.ErrForbidden {
+		t.Fatal(err)
+	}
+	if err = s.DeleteTopic(anonymous, "public"); err != headers.ErrForbidden {
+		t.Fatal(err)
+	}
+
+	// replays need consume on the source as well as produce on the destination
+	if _, err = s.ReplayTopic(app, "public", "orders", 0, -1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.ReplayTopic(app, "orders", "public", 0, -1, nil); err != headers.ErrForbidden {
+		t.Fatal(err)
+	}
+
+	// trusted calls made on behalf of the operator are not checked
+	if msgs, err := s.ConsumeMsgs(WithTrusted(anonymous), "orders", 0, -1); err != nil || len(msgs) != 1 {
+		t.Fatal(msgs, err)
+	}
+}
+
+func TestServer_HandleACL(t *testing.T) {
+	dir := ".haraqa-handle-acl"
+	defer os.RemoveAll(dir)
+	authenticate := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), r.Header.Get("X-User"))))
+		})
+	}
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithACL("", "root"), WithMiddleware(authenticate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	request := func(user, method, path, etag string, body interface{}) *httptest.ResponseRecorder {
+		var b []byte
+		if body != nil {
+			b, _ = json.Marshal(body)
+		}
+		r := httptest.NewRequest(method, path, bytes.NewReader(b))
+		r.Header.Set("X-User", user)
+		if etag != "" {
+			r.Header.Set("If-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	// only admins can manage the acl
+	if w := request("app", http.MethodGet, "/acl", "", nil); w.Code != http.StatusForbidden || headers.ReadErrors(w.Header()) != headers.ErrForbidden {
+		t.Fatal(w.Code, w.Header())
+	}
+	w := request("root", http.MethodPost, "/acl", `"1"`, headers.ACLRule{Principal: "app", Topic: "orders", Permissions: []string{"produce"}})
+	if w.Code != http.StatusCreated || w.Header().Get("ETag") != `"2"` {
+		t.Fatal(w.Code, w.Header(), w.Body.String())
+	}
+	if w = request("root", http.MethodPut, "/acl/admin", `"1"`, headers.ACLRule{Principal: "ops", Topic: "*", Permissions: []string{"admin"}}); w.Code != http.StatusPreconditionFailed {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = request("root", http.MethodPut, "/acl/admin", `"2"`, headers.ACLRule{Principal: "ops", Topic: "*", Permissions: []string{"admin"}}); w.Code != http.StatusOK || w.Header().Get("ETag") != `"3"` {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = request("ops", http.MethodGet, "/acl/admin", "", nil); w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte(`"principal":"ops"`)) {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if w = request("ops", http.MethodGet, "/acl/unknown", "", nil); w.Code != http.StatusNotFound {
+		t.Fatal(w.Code)
+	}
+
+	// topics require the permission of the request
+	if w = request("ops", http.MethodPut, "/topics/orders", "", nil); w.Code != http.StatusCreated {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = request("app", http.MethodPost, "/topics/orders", "", nil); w.Code == http.StatusForbidden {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = request("app", http.MethodGet, "/topics/orders?id=0", "", nil); w.Code != http.StatusForbidden {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = request("", http.MethodDelete, "/topics/orders", "", nil); w.Code != http.StatusForbidden {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = request("", http.MethodGet, "/topics", "", nil); w.Code != http.StatusOK {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	// export and import
+	w = request("ops", http.MethodGet, "/acl", "", nil)
+	var acl headers.ACL
+	if err = json.NewDecoder(w.Body).Decode(&acl); err != nil || acl.Version != 3 || len(acl.Rules) != 2 {
+		t.Fatal(acl, err)
+	}
+	if w = request("ops", http.MethodPut, "/acl", `"3"`, headers.ACL{Rules: acl.Rules[:1]}); w.Code != http.StatusOK || w.Header().Get("ETag") != `"4"` {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = request("ops", http.MethodGet, "/acl", "", nil); w.Code != http.StatusForbidden {
+		t.Fatal(w.Code)
+	}
+	if w = request("root", http.MethodDelete, "/acl/admin", "", nil); w.Code != http.StatusNotFound {
+		t.Fatal(w.Code)
+	}
+	if w = request("root", http.MethodPut, "/acl", "bad", nil); w.Code != http.StatusPreconditionFailed {
+		t.Fatal(w.Code)
+	}
+	if w = request("root", http.MethodPatch, "/acl", "", nil); w.Code != http.StatusMethodNotAllowed {
+		t.Fatal(w.Code)
+	}
+}
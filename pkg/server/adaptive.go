@@ -0,0 +1,128 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// adaptiveInitialLimit is the limit of the first consumes of a topic without a default consume limit
+	adaptiveInitialLimit = 100
+	// adaptiveMaxLimit is the largest limit used without a default consume limit
+	adaptiveMaxLimit = 1 << 16
+	// adaptiveWeight is the weight of each consume in the average message size of a topic
+	adaptiveWeight = 0.2
+)
+
+// WithAdaptiveConsumeLimit tunes the limit of consumes which do not set one for each topic, instead of
+// using the default consume limit for every topic. The limit is sized so a batch holds about targetBytes
+// of the topic's recent messages, doubled while full batches are served within targetLatency and halved
+// when a batch takes longer. A targetLatency of 0 only sizes batches by bytes. If a default consume limit
+// is set it is the largest limit used
+func WithAdaptiveConsumeLimit(targetBytes int64, targetLatency time.Duration) Option {
+	return func(s *Server) error {
+		if targetBytes <= 0 {
+			return errors.New("adaptive consume limit target bytes must be greater than 0")
+		}
+		if targetLatency < 0 {
+			return errors.New("adaptive consume limit target latency cannot be negative")
+		}
+		s.adaptive = &adaptiveLimits{targetBytes: targetBytes, targetLatency: targetLatency, topics: make(map[string]*adaptiveLimit)}
+		return nil
+	}
+}
+
+// adaptiveLimits holds the tuned consume limit of each topic
+type adaptiveLimits struct {
+	targetBytes   int64
+	targetLatency time.Duration
+	mux           sync.Mutex
+	topics        map[string]*adaptiveLimit
+}
+
+type adaptiveLimit struct {
+	limit   int64
+	msgSize float64
+}
+
+// consumeLimit returns the limit of a consume of the topic which does not set one
+func (s *Server) consumeLimit(topic string) int64 {
+	if s.adaptive == nil || strings.HasSuffix(topic, "*") {
+		return s.defaultConsumeLimit
+	}
+	return s.adaptive.limit(topic, s.defaultConsumeLimit)
+}
+
+// limit returns the tuned limit of the topic, no larger than max if it is set
+func (a *adaptiveLimits) limit(topic string, max int64) int64 {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if l, ok := a.topics[topic]; ok {
+		if max > 0 && l.limit > max {
+			return max
+		}
+		return l.limit
+	}
+	if max > 0 && max < adaptiveInitialLimit {
+		return max
+	}
+	return adaptiveInitialLimit
+}
+
+// observe tunes the limit of the topic after count messages of n bytes were consumed in elapsed
+func (a *adaptiveLimits) observe(topic string, count int, n int64, elapsed time.Duration) {
+	if count <= 0 {
+		return
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	l, ok := a.topics[topic]
+	if !ok {
+		l = &adaptiveLimit{limit: adaptiveInitialLimit, msgSize: float64(n) / float64(count)}
+		a.topics[topic] = l
+	}
+	l.msgSize += adaptiveWeight * (float64(n)/float64(count) - l.msgSize)
+
+	switch {
+	case a.targetLatency > 0 && elapsed > a.targetLatency:
+		l.limit /= 2
+	case int64(count) >= l.limit:
+		// full batches served in time can grow
+		l.limit *= 2
+	}
+	if l.msgSize > 0 {
+		if bytesLimit := int64(float64(a.targetBytes) / l.msgSize); l.limit > bytesLimit {
+			l.limit = bytesLimit
+		}
+	}
+	if l.limit > adaptiveMaxLimit {
+		l.limit = adaptiveMaxLimit
+	}
+	if l.limit < 1 {
+		l.limit = 1
+	}
+}
+
+// snapshot returns the tuned limit of each topic, no larger than max if it is set
+func (a *adaptiveLimits) snapshot(max int64) map[string]int64 {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	limits := make(map[string]int64, len(a.topics))
+	for topic, l := range a.topics {
+		limits[topic] = l.limit
+		if max > 0 && l.limit > max {
+			limits[topic] = max
+		}
+	}
+	return limits
+}
+
+// deleteTopic forgets the limit of a deleted topic
+func (a *adaptiveLimits) deleteTopic(topic string) {
+	a.mux.Lock()
+	delete(a.topics, topic)
+	a.mux.Unlock()
+}
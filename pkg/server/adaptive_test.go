@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestWithAdaptiveConsumeLimit(t *testing.T) {
+	for _, opt := range []Option{WithAdaptiveConsumeLimit(0, time.Second), WithAdaptiveConsumeLimit(1, -time.Second)} {
+		if err := opt(&Server{}); err == nil {
+			t.Error("expected invalid option error")
+		}
+	}
+}
+
+func TestAdaptiveLimits(t *testing.T) {
+	a := &adaptiveLimits{targetBytes: 1 << 20, targetLatency: 100 * time.Millisecond, topics: make(map[string]*adaptiveLimit)}
+	if l := a.limit("t", -1); l != adaptiveInitialLimit {
+		t.Fatal(l)
+	}
+	if l := a.limit("t", 10); l != 10 {
+		t.Fatal(l)
+	}
+
+	// full batches served in time double the limit, partial batches leave it unchanged
+	a.observe("t", 100, 100, time.Millisecond)
+	if l := a.limit("t", -1); l != 200 {
+		t.Fatal(l)
+	}
+	a.observe("t", 50, 50, time.Millisecond)
+	if l := a.limit("t", -1); l != 200 {
+		t.Fatal(l)
+	}
+	if l := a.limit("t", 150); l != 150 {
+		t.Fatal(l)
+	}
+	// slow batches halve it
+	a.observe("t", 200, 200, time.Second)
+	if l := a.limit("t", -1); l != 100 {
+		t.Fatal(l)
+	}
+	// large messages cap it by the target bytes
+	for i := 0; i < 50; i++ {
+		a.observe("t", 10, 10<<20, time.Millisecond)
+	}
+	if l := a.limit("t", -1); l != 1 {
+		t.Fatal(l)
+	}
+	// tiny messages are capped by the largest limit
+	for i := 0; i < 50; i++ {
+		a.observe("t", int(a.limit("t", -1)), 0, time.Millisecond)
+	}
+	if l := a.limit("t", -1); l != adaptiveMaxLimit {
+		t.Fatal(l)
+	}
+	if limits := a.snapshot(1000); limits["t"] != 1000 {
+		t.Fatal(limits)
+	}
+	a.deleteTopic("t")
+	if l := a.limit("t", -1); l != adaptiveInitialLimit {
+		t.Fatal(l)
+	}
+}
+
+func TestServer_AdaptiveConsumeLimit(t *testing.T) {
+	dir := ".haraqa-adaptive"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithAdaptiveConsumeLimit(1<<20, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.CreateTopic(context.Background(), "adaptive"); err != nil {
+		t.Fatal(err)
+	}
+	msgs := make([][]byte, 400)
+	for i := range msgs {
+		msgs[i] = []byte("message")
+	}
+	if err = s.ProduceMsgs(context.Background(), "adaptive", msgs...); err != nil {
+		t.Fatal(err)
+	}
+
+	consume := func(id int) int {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/topics/adaptive?id="+strconv.Itoa(id), nil))
+		sizes, err := headers.ReadSizes(w.Header())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(sizes)
+	}
+	// consumes without a limit start small and grow while batches are full and fast
+	if n := consume(0); n != adaptiveInitialLimit {
+		t.Fatal(n)
+	}
+	if n := consume(100); n != 2*adaptiveInitialLimit {
+		t.Fatal(n)
+	}
+	if n := consume(300); n != 100 {
+		t.Fatal(n)
+	}
+}
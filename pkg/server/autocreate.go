@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+// WithAutoCreateTopics creates missing topics when they are produced to, instead of returning
+// ErrTopicDoesNotExist. Producers can override the setting per request with the X-Create-Topic header
+func WithAutoCreateTopics(enabled bool) Option {
+	return func(s *Server) error {
+		s.autoCreateTopics = enabled
+		return nil
+	}
+}
+
+// shouldCreateTopic returns true if a missing topic should be created by the produce request
+func (s *Server) shouldCreateTopic(r *http.Request) bool {
+	if v := r.Header.Get(headers.HeaderCreate); v != "" {
+		if create, err := strconv.ParseBool(v); err == nil {
+			return create
+		}
+	}
+	return s.autoCreateTopics
+}
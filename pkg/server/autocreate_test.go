@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestServer_AutoCreateTopics(t *testing.T) {
+	for i, tt := range []struct {
+		enabled bool
+		header  string
+		created bool
+	}{
+		{false, "", false},
+		{true, "", true},
+		{false, "true", true},
+		{true, "false", false},
+		{true, "invalid", true},
+	} {
+		ctrl := gomock.NewController(t)
+		q := NewMockQueue(ctrl)
+		q.EXPECT().RootDir().Times(1).Return("")
+		exists := false
+		q.EXPECT().Produce(gomock.Any(), "created", []int64{5}, gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_ context.Context, topic string, sizes []int64, timestamp uint64, r interface{}) error {
+				if !exists {
+					return headers.ErrTopicDoesNotExist
+				}
+				return nil
+			}).MinTimes(1)
+		q.EXPECT().CreateTopic("created").DoAndReturn(func(topic string) error {
+			exists = true
+			return nil
+		}).MaxTimes(1)
+		q.EXPECT().Close().Return(nil).Times(1)
+		s, err := NewServer(WithQueue(q), WithAutoCreateTopics(tt.enabled))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/topics/created", bytes.NewBufferString("hello"))
+		r.Header.Set(headers.HeaderSizes, "5")
+		if tt.header != "" {
+			r.Header.Set(headers.HeaderCreate, tt.header)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if exists != tt.created {
+			t.Fatal(i, exists)
+		}
+		if tt.created && w.Code != http.StatusNoContent {
+			t.Fatal(i, w.Code, w.Header())
+		}
+		if !tt.created && headers.ReadErrors(w.Header()) != headers.ErrTopicDoesNotExist {
+			t.Fatal(i, w.Code, w.Header())
+		}
+		if err = s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		ctrl.Finish()
+	}
+}
+
+func TestServer_AutoCreateTopicsQuota(t *testing.T) {
+	dir := ".haraqa-autocreate-quota"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithAutoCreateTopics(true), WithTopicCreationQuota(1, time.Hour, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// topics created by produces count against the creation quota of the client
+	produce := func(topic string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/topics/"+topic, bytes.NewBufferString("hello"))
+		r.Header.Set(headers.HeaderSizes, "5")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+	if w := produce("first"); w.Code != http.StatusNoContent {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w := produce("first"); w.Code != http.StatusNoContent {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w := produce("second"); w.Code != http.StatusTooManyRequests || headers.ReadErrors(w.Header()) != headers.ErrTopicQuotaExceeded {
+		t.Fatal(w.Code, w.Header())
+	}
+}
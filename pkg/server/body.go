@@ -0,0 +1,62 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// WithBodyDrainLimit sets how much of a request body left unread by a handler, such as after a produce
+// error, is read and discarded so that the client's connection can be reused. Larger bodies are closed
+// along with the connection. A limit of 0 disables draining, defaults to 4MiB
+func WithBodyDrainLimit(n int64) Option {
+	return func(s *Server) error {
+		if n < 0 {
+			return errors.New("invalid drain limit, value must not be negative")
+		}
+		s.drainLimit = n
+		return nil
+	}
+}
+
+// drainBodies wraps the handler, draining and closing request bodies once the handler returns on every
+// path. Handlers may close the body early, the close is deferred until the body has been drained
+func (s *Server) drainBodies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body := &drainBody{ReadCloser: r.Body}
+		r.Body = body
+		defer body.drain(s.drainLimit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+var errBodyClosed = errors.New("http: invalid Read on closed Body")
+
+// drainBody is a request body which is only closed once drained
+type drainBody struct {
+	io.ReadCloser
+	closed bool
+}
+
+func (b *drainBody) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, errBodyClosed
+	}
+	return b.ReadCloser.Read(p)
+}
+
+func (b *drainBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func (b *drainBody) drain(limit int64) {
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(b.ReadCloser, limit))
+	_ = b.ReadCloser.Close()
+}
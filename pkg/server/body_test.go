@@ -0,0 +1,62 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"os"
+	"testing"
+)
+
+func TestServer_DrainBodies(t *testing.T) {
+	if err := WithBodyDrainLimit(-1)(&Server{}); err == nil {
+		t.Error("expected invalid drain limit error")
+	}
+
+	dir := ".haraqa-body"
+	defer os.RemoveAll(dir)
+	body := bytes.Repeat([]byte("a"), 1<<20)
+
+	for _, tt := range []struct {
+		limit  int64
+		reused bool
+	}{
+		{limit: 4 << 20, reused: true},
+		{limit: 1 << 10, reused: false},
+		{limit: 0, reused: false},
+	} {
+		s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithBodyDrainLimit(tt.limit))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts := httptest.NewServer(s)
+
+		var reused bool
+		for i := 0; i < 2; i++ {
+			// produce without sizes is rejected before the body is read
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/topics/missing", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+				GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused },
+			}))
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Fatal(resp.StatusCode)
+			}
+		}
+		if reused != tt.reused {
+			t.Error(tt.limit, reused)
+		}
+		ts.Close()
+		_ = s.Close()
+	}
+}
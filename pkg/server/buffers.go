@@ -0,0 +1,39 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+)
+
+// WithBufferPool sets the size of the buffers the file queue writes messages through and the largest
+// buffer kept for reuse. Batches larger than max are written through a buffer which is released after the
+// write, so one large batch does not raise the memory held by the pool. With size classes buffers are
+// pooled separately by powers of two between size and max, so small batches do not hold large buffers
+func WithBufferPool(size, max int, sizeClasses bool) Option {
+	return func(s *Server) error {
+		if size <= 0 {
+			return errors.New("invalid buffer size, value must be greater than 0")
+		}
+		if max < size {
+			return errors.New("invalid max buffer size, value must be at least the buffer size")
+		}
+		s.buffers = bufferPool{size: size, max: max, sizeClasses: sizeClasses}
+		return nil
+	}
+}
+
+// bufferPool is the buffer pool configuration of the queue
+type bufferPool struct {
+	size, max   int
+	sizeClasses bool
+}
+
+// setBufferPool applies the buffer pool configuration to the queue, if it supports it
+func (s *Server) setBufferPool() error {
+	q, ok := s.q.(interface {
+		SetBufferPool(size, max int, sizeClasses bool) error
+	})
+	if !ok {
+		return errors.New("buffer pools are not supported by the queue")
+	}
+	return q.SetBufferPool(s.buffers.size, s.buffers.max, s.buffers.sizeClasses)
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+)
+
+func TestServer_BufferPool(t *testing.T) {
+	for _, opt := range []Option{WithBufferPool(0, 10, true), WithBufferPool(10, 5, true)} {
+		if err := opt(&Server{}); err == nil {
+			t.Error("expected invalid buffer pool error")
+		}
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	q := NewMockQueue(ctrl)
+	if _, err := NewServer(WithQueue(q), WithBufferPool(1024, 4096, true)); err == nil {
+		t.Error("expected unsupported queue error")
+	}
+
+	dir := ".haraqa-buffer-pool"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithBufferPool(16, 64, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.CreateTopic(context.Background(), "buffers"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(context.Background(), "buffers", []byte("hello"), make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := s.ConsumeMsgs(context.Background(), "buffers", 0, -1)
+	if err != nil || len(msgs) != 2 || string(msgs[0]) != "hello" || len(msgs[1]) != 100 {
+		t.Fatal(msgs, err)
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// WithCacheMonitor periodically reports the queue's file cache hits, misses and evictions, the number of
+// open queue files and the number of opens rejected by WithMaxOpenFiles to the metrics handler
+func WithCacheMonitor(interval time.Duration) Option {
+	return func(s *Server) error {
+		if interval <= 0 {
+			return errors.New("invalid interval, value must be greater than 0")
+		}
+		s.cacheInterval = interval
+		return nil
+	}
+}
+
+// WithMaxOpenFiles caps the number of files the file queue holds open to produce and consume messages.
+// Set below the process file limit, requests beyond the cap fail with headers.ErrTooManyOpenFiles
+// instead of the queue failing to open files at random
+func WithMaxOpenFiles(n int64) Option {
+	return func(s *Server) error {
+		if n <= 0 {
+			return errors.New("invalid max open files, value must be greater than 0")
+		}
+		s.maxOpenFiles = n
+		return nil
+	}
+}
+
+// setMaxOpenFiles applies the open file cap to the queue, if it supports one
+func (s *Server) setMaxOpenFiles() error {
+	q, ok := s.q.(interface{ SetMaxOpenFiles(n int64) })
+	if !ok {
+		return errors.New("max open files is not supported by the queue")
+	}
+	q.SetMaxOpenFiles(s.maxOpenFiles)
+	return nil
+}
+
+// WithReadAhead enables prefetching for sequential consumers of the file queue. Up to size bytes of the
+// messages following a sequential consume are read in the background, so that consumers keeping up with a
+// topic are served from the page cache rather than waiting on the disk
+func WithReadAhead(size int64) Option {
+	return func(s *Server) error {
+		if size <= 0 {
+			return errors.New("invalid read ahead size, value must be greater than 0")
+		}
+		s.readAhead = size
+		return nil
+	}
+}
+
+// setReadAhead applies the read-ahead size to the queue, if it supports read-ahead
+func (s *Server) setReadAhead() error {
+	q, ok := s.q.(interface{ SetReadAhead(size int64) })
+	if !ok {
+		return errors.New("read ahead is not supported by the queue")
+	}
+	q.SetReadAhead(s.readAhead)
+	return nil
+}
+
+// WithPreload warms the queue's caches at startup for the topics written to within the window, so the
+// first requests to active topics after a restart do not wait on a cold disk cache. Topics are preloaded
+// in the background while the server accepts requests
+func WithPreload(window time.Duration) Option {
+	return func(s *Server) error {
+		if window <= 0 {
+			return errors.New("invalid preload window, value must be greater than 0")
+		}
+		s.preloadWindow = window
+		return nil
+	}
+}
+
+// preload warms the caches of recently active topics, stopping early if the server is closed
+func (s *Server) preload() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	start := time.Now()
+	topics, err := s.q.Preload(ctx, start.Add(-s.preloadWindow))
+	if err != nil && errors.Cause(err) != context.Canceled {
+		s.logger.Error("unable to preload topics", "err", err)
+	}
+	s.logger.Info("topics preloaded", "topics", len(topics), "duration", time.Since(start))
+}
+
+func (s *Server) monitorCache() {
+	ticker := time.NewTicker(s.cacheInterval)
+	defer ticker.Stop()
+	var last headers.CacheStats
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			last = s.checkCache(last)
+		}
+	}
+}
+
+// checkCache reports the change in cache stats since the last check, returning the current stats
+func (s *Server) checkCache(last headers.CacheStats) headers.CacheStats {
+	stats := s.q.CacheStats()
+	s.metrics.FileCache("produce", stats.ProduceHits-last.ProduceHits, stats.ProduceMisses-last.ProduceMisses,
+		stats.ProduceEvictions-last.ProduceEvictions)
+	s.metrics.FileCache("consume", stats.ConsumeHits-last.ConsumeHits, stats.ConsumeMisses-last.ConsumeMisses,
+		stats.ConsumeEvictions-last.ConsumeEvictions)
+	s.metrics.OpenFiles(stats.OpenFiles)
+	s.metrics.FileRejections(stats.FileRejections - last.FileRejections)
+	return stats
+}
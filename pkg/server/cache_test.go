@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+type cacheMetrics struct {
+	noOpMetrics
+	caches     map[string][3]int64
+	openFiles  int64
+	rejections int64
+}
+
+func (m *cacheMetrics) FileCache(cache string, hits, misses, evictions int64) {
+	m.caches[cache] = [3]int64{hits, misses, evictions}
+}
+
+func (m *cacheMetrics) OpenFiles(n int64) {
+	m.openFiles = n
+}
+
+func (m *cacheMetrics) FileRejections(n int64) {
+	m.rejections = n
+}
+
+func TestWithCacheMonitor(t *testing.T) {
+	if err := WithCacheMonitor(0)(&Server{}); err == nil {
+		t.Error("expected interval error")
+	}
+	s := &Server{}
+	if err := WithCacheMonitor(time.Second)(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.cacheInterval != time.Second {
+		t.Error(s.cacheInterval)
+	}
+}
+
+func TestServer_checkCache(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Times(1).Return("")
+	q.EXPECT().CacheStats().Return(headers.CacheStats{
+		ProduceHits: 10, ProduceMisses: 2, ProduceEvictions: 1,
+		ConsumeHits: 20, ConsumeMisses: 4, ConsumeEvictions: 3,
+		OpenFiles: 6, FileRejections: 3,
+	}).Times(1)
+	q.EXPECT().Close().Return(nil).Times(1)
+
+	metrics := &cacheMetrics{caches: map[string][3]int64{}}
+	s, err := NewServer(WithQueue(q), WithMetrics(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	stats := s.checkCache(headers.CacheStats{ProduceHits: 5, ConsumeHits: 5, FileRejections: 1})
+	if stats.OpenFiles != 6 || metrics.openFiles != 6 || metrics.rejections != 2 {
+		t.Error(stats, metrics.openFiles, metrics.rejections)
+	}
+	if metrics.caches["produce"] != [3]int64{5, 2, 1} || metrics.caches["consume"] != [3]int64{15, 4, 3} {
+		t.Error(metrics.caches)
+	}
+}
+
+func TestServer_monitorCache(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Times(1).Return("")
+	q.EXPECT().CacheStats().Return(headers.CacheStats{}).MinTimes(1)
+	q.EXPECT().Close().Return(nil).Times(1)
+	s, err := NewServer(WithQueue(q), WithCacheMonitor(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_Preload(t *testing.T) {
+	if err := WithPreload(0)(&Server{}); err == nil {
+		t.Error("expected invalid window error")
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// preloading runs in the background until it finishes or the server is closed
+	started := make(chan struct{})
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Times(1).Return("")
+	q.EXPECT().Preload(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, since time.Time) ([]string, error) {
+		if d := time.Since(since); d < time.Hour || d > time.Hour+time.Minute {
+			t.Error(since)
+		}
+		close(started)
+		<-ctx.Done()
+		return []string{"a"}, ctx.Err()
+	}).Times(1)
+	q.EXPECT().Close().Return(nil).Times(1)
+	logger := &testLogger{}
+	s, err := NewServer(WithQueue(q), WithPreload(time.Hour), WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(logger.entries) != 1 || !strings.HasPrefix(logger.entries[0], "info: topics preloaded topics 1 duration") {
+		t.Fatal(logger.entries)
+	}
+}
+
+func TestServer_MaxOpenFiles(t *testing.T) {
+	if err := WithMaxOpenFiles(0)(&Server{}); err == nil {
+		t.Error("expected invalid max open files error")
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	q := NewMockQueue(ctrl)
+	if _, err := NewServer(WithQueue(q), WithMaxOpenFiles(10)); err == nil {
+		t.Error("expected unsupported queue error")
+	}
+
+	dir := ".haraqa-max-open-files"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithMaxOpenFiles(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, topic := range []string{"first", "second"} {
+		if err = s.CreateTopic(context.Background(), topic); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.ProduceMsgs(context.Background(), topic, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := s.q.CacheStats(); stats.OpenFiles != 2 {
+		t.Fatal(stats)
+	}
+}
+
+func TestServer_ReadAhead(t *testing.T) {
+	if err := WithReadAhead(0)(&Server{}); err == nil {
+		t.Error("expected invalid read-ahead error")
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	q := NewMockQueue(ctrl)
+	if _, err := NewServer(WithQueue(q), WithReadAhead(1024)); err == nil {
+		t.Error("expected unsupported queue error")
+	}
+
+	dir := ".haraqa-read-ahead"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithReadAhead(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
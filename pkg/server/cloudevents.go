@@ -0,0 +1,310 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// CloudEvents media types of the structured and batched http bindings
+const (
+	ContentTypeCloudEvent      = "application/cloudevents+json"
+	ContentTypeCloudEventBatch = "application/cloudevents-batch+json"
+)
+
+const (
+	cloudEventsVersion = "1.0"
+	cloudEventsPrefix  = "Ce-"
+
+	// type of the events emitted for messages which were not produced as cloudevents
+	cloudEventsMessageType = "io.haraqa.message"
+)
+
+// cloudevents consume modes
+const (
+	ceModeBinary     = "binary"
+	ceModeStructured = "structured"
+	ceModeBatch      = "batch"
+)
+
+// readCloudEvents reads a produce request made with one of the CloudEvents http bindings. Events are
+// stored in the structured json format, so the attributes of binary events are kept with their data.
+// If the request is not a cloudevent ok is false and the body is left unread
+func readCloudEvents(r *http.Request) (msgs [][]byte, ok bool, err error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get(headers.ContentType))
+	binary := r.Header.Get(cloudEventsPrefix+"Specversion") != ""
+	if mediaType != ContentTypeCloudEvent && mediaType != ContentTypeCloudEventBatch && !binary {
+		return nil, false, nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, true, err
+	}
+
+	switch mediaType {
+	case ContentTypeCloudEvent:
+		msg, err := compactCloudEvent(body)
+		if err != nil {
+			return nil, true, err
+		}
+		return [][]byte{msg}, true, nil
+	case ContentTypeCloudEventBatch:
+		var batch []json.RawMessage
+		if err = json.Unmarshal(body, &batch); err != nil {
+			return nil, true, errors.Wrap(headers.ErrInvalidCloudEvent, "batch must be a json array")
+		}
+		if len(batch) == 0 {
+			return nil, true, errors.Wrap(headers.ErrInvalidCloudEvent, "batch is empty")
+		}
+		msgs = make([][]byte, len(batch))
+		for i := range batch {
+			if msgs[i], err = compactCloudEvent(batch[i]); err != nil {
+				return nil, true, errors.Wrapf(err, "event %d", i)
+			}
+		}
+		return msgs, true, nil
+	}
+
+	msg, err := encodeBinaryCloudEvent(r.Header, body)
+	if err != nil {
+		return nil, true, err
+	}
+	return [][]byte{msg}, true, nil
+}
+
+// decodeCloudEvent parses a cloudevent in the structured json format and checks its required attributes
+func decodeCloudEvent(b []byte) (map[string]json.RawMessage, error) {
+	var attrs map[string]json.RawMessage
+	if err := json.Unmarshal(b, &attrs); err != nil || attrs == nil {
+		return nil, errors.Wrap(headers.ErrInvalidCloudEvent, "event must be a json object")
+	}
+	for _, name := range []string{"specversion", "id", "source", "type"} {
+		var v string
+		if err := json.Unmarshal(attrs[name], &v); err != nil || v == "" {
+			return nil, errors.Wrapf(headers.ErrInvalidCloudEvent, "missing required attribute %q", name)
+		}
+		if name == "specversion" && v != cloudEventsVersion {
+			return nil, errors.Wrapf(headers.ErrInvalidCloudEvent, "unsupported specversion %q", v)
+		}
+	}
+	if _, ok := attrs["data_base64"]; ok {
+		if _, ok = attrs["data"]; ok {
+			return nil, errors.Wrap(headers.ErrInvalidCloudEvent, "data and data_base64 are mutually exclusive")
+		}
+	}
+	return attrs, nil
+}
+
+// compactCloudEvent validates the event and removes insignificant whitespace
+func compactCloudEvent(b []byte) ([]byte, error) {
+	if _, err := decodeCloudEvent(b); err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, len(b)))
+	if err := json.Compact(buf, b); err != nil {
+		return nil, errors.Wrap(headers.ErrInvalidCloudEvent, err.Error())
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeBinaryCloudEvent converts an event of the binary binding, with its attributes in Ce- headers and
+// its data in the body, to the structured json format
+func encodeBinaryCloudEvent(h http.Header, body []byte) ([]byte, error) {
+	event := make(map[string]interface{}, len(h))
+	for key, values := range h {
+		if len(values) == 0 || len(key) <= len(cloudEventsPrefix) || !strings.EqualFold(key[:len(cloudEventsPrefix)], cloudEventsPrefix) {
+			continue
+		}
+		name := strings.ToLower(key[len(cloudEventsPrefix):])
+		if name == "data" || name == "data_base64" || name == "datacontenttype" {
+			return nil, errors.Wrapf(headers.ErrInvalidCloudEvent, "invalid attribute header %q", key)
+		}
+		v, err := url.PathUnescape(values[0])
+		if err != nil {
+			return nil, errors.Wrapf(headers.ErrInvalidCloudEvent, "invalid value of attribute %q", name)
+		}
+		event[name] = v
+	}
+	contentType := h.Get(headers.ContentType)
+	if contentType != "" {
+		event["datacontenttype"] = contentType
+	}
+	if len(body) > 0 {
+		setCloudEventData(event, contentType, body)
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = decodeCloudEvent(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// setCloudEventData adds the data to the event as json if possible, as a string if it is text and
+// as base64 otherwise
+func setCloudEventData(event map[string]interface{}, contentType string, data []byte) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case (contentType == "" || isJSONMediaType(mediaType)) && json.Valid(data):
+		event["data"] = json.RawMessage(data)
+	case strings.HasPrefix(mediaType, "text/") && utf8.Valid(data):
+		event["data"] = string(data)
+	default:
+		event["data_base64"] = base64.StdEncoding.EncodeToString(data)
+	}
+}
+
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// cloudEventsMode returns the cloudevents mode requested by a consumer, either through the Accept header
+// for the structured and batched bindings or the cloudevents=binary query parameter for the binary binding
+func cloudEventsMode(r *http.Request) string {
+	if r.URL.Query().Get("cloudevents") == ceModeBinary {
+		return ceModeBinary
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(accept))
+		switch mediaType {
+		case ContentTypeCloudEvent:
+			return ceModeStructured
+		case ContentTypeCloudEventBatch:
+			return ceModeBatch
+		}
+	}
+	return ""
+}
+
+// consumeCloudEvents writes consumed messages as cloudevents. The structured and binary modes return a
+// single event, the batch mode returns up to limit events. Messages which were not produced as cloudevents
+// are wrapped in an event of type io.haraqa.message identified by their offset within the topic
+func (s *Server) consumeCloudEvents(w http.ResponseWriter, r *http.Request, mode, topic string, id, limit int64) {
+	if mode != ceModeBatch {
+		limit = 1
+	}
+	msgs, err := s.ConsumeGroupMsgs(r.Context(), topic, r.Header.Get(headers.HeaderGroup), id, limit)
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+	if len(msgs) == 0 {
+		headers.SetError(w, headers.ErrNoContent)
+		return
+	}
+	if id < 0 {
+		// negative ids consume the latest message
+		if info, err := s.InspectTopic(r.Context(), topic); err == nil {
+			id = info.MaxOffset - int64(len(msgs)) + 1
+		}
+	}
+
+	events := make([]json.RawMessage, len(msgs))
+	var attrs map[string]json.RawMessage
+	for i, msg := range msgs {
+		events[i], attrs = toCloudEvent(topic, id+int64(i), msg)
+	}
+
+	switch mode {
+	case ceModeBinary:
+		writeBinaryCloudEvent(w, attrs)
+	case ceModeStructured:
+		w.Header()[headers.ContentType] = []string{ContentTypeCloudEvent}
+		_, _ = w.Write(events[0])
+	default:
+		w.Header()[headers.ContentType] = []string{ContentTypeCloudEventBatch}
+		_ = json.NewEncoder(w).Encode(events)
+	}
+}
+
+// toCloudEvent returns the message in the structured json format along with its attributes
+func toCloudEvent(topic string, offset int64, msg []byte) (json.RawMessage, map[string]json.RawMessage) {
+	if attrs, err := decodeCloudEvent(msg); err == nil {
+		return msg, attrs
+	}
+	event := map[string]interface{}{
+		"specversion": cloudEventsVersion,
+		"id":          strconv.FormatInt(offset, 10),
+		"source":      "/topics/" + topic,
+		"type":        cloudEventsMessageType,
+	}
+	if json.Valid(msg) {
+		event["datacontenttype"] = "application/json"
+	} else {
+		event["datacontenttype"] = "application/octet-stream"
+	}
+	if len(msg) > 0 {
+		setCloudEventData(event, event["datacontenttype"].(string), msg)
+	}
+	b, _ := json.Marshal(event)
+	attrs, _ := decodeCloudEvent(b)
+	return b, attrs
+}
+
+// writeBinaryCloudEvent writes the attributes of the event as Ce- headers and its data as the body
+func writeBinaryCloudEvent(w http.ResponseWriter, attrs map[string]json.RawMessage) {
+	h := w.Header()
+	for name, raw := range attrs {
+		if name == "data" || name == "data_base64" || name == "datacontenttype" {
+			continue
+		}
+		var v string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			v = string(raw)
+		}
+		h[http.CanonicalHeaderKey(cloudEventsPrefix+name)] = []string{escapeCloudEventHeader(v)}
+	}
+
+	var contentType string
+	_ = json.Unmarshal(attrs["datacontenttype"], &contentType)
+	var body []byte
+	if raw, ok := attrs["data_base64"]; ok {
+		var encoded string
+		_ = json.Unmarshal(raw, &encoded)
+		body, _ = base64.StdEncoding.DecodeString(encoded)
+	} else if raw, ok := attrs["data"]; ok {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		body = raw
+		if contentType == "" {
+			contentType = "application/json"
+		} else if !isJSONMediaType(mediaType) {
+			var text string
+			if err := json.Unmarshal(raw, &text); err == nil {
+				body = []byte(text)
+			}
+		}
+	}
+	if contentType != "" {
+		h[headers.ContentType] = []string{contentType}
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// escapeCloudEventHeader percent encodes the characters which the http binding requires to be escaped
+func escapeCloudEventHeader(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c <= ' ' || c > '~' || c == '"' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
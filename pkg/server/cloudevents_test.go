@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestServer_CloudEvents(t *testing.T) {
+	dir := ".haraqa-cloudevents"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	if err = s.CreateTopic(ctx, "events"); err != nil {
+		t.Fatal(err)
+	}
+
+	produce := func(h http.Header, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/topics/events", strings.NewReader(body))
+		for k, v := range h {
+			r.Header[k] = v
+		}
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	// binary
+	w := produce(http.Header{
+		"Ce-Specversion": {"1.0"},
+		"Ce-Id":          {"a1"},
+		"Ce-Source":      {"/orders"},
+		"Ce-Type":        {"order.created"},
+		"Ce-Subject":     {"caf%C3%A9 50%25"},
+		"Content-Type":   {"application/json"},
+	}, `{"total": 2}`)
+	if w.Code != http.StatusNoContent {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	// structured
+	w = produce(http.Header{"Content-Type": {ContentTypeCloudEvent + "; charset=utf-8"}},
+		`{"specversion": "1.0", "id": "a2", "source": "/orders", "type": "order.paid", "datacontenttype": "text/plain", "data": "paid"}`)
+	if w.Code != http.StatusNoContent {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	// batch
+	w = produce(http.Header{"Content-Type": {ContentTypeCloudEventBatch}},
+		`[{"specversion":"1.0","id":"a3","source":"/orders","type":"order.shipped","data_base64":"AQI="},{"specversion":"1.0","id":"a4","source":"/orders","type":"order.closed"}]`)
+	if w.Code != http.StatusNoContent {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	// plain message
+	if err = s.ProduceMsgs(ctx, "events", []byte("raw"), []byte(`{"x":1}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// invalid events
+	for _, tt := range []struct {
+		h    http.Header
+		body string
+	}{
+		{http.Header{"Ce-Specversion": {"1.0"}, "Ce-Id": {"a1"}}, ""},
+		{http.Header{"Ce-Specversion": {"0.3"}, "Ce-Id": {"a1"}, "Ce-Source": {"/"}, "Ce-Type": {"t"}}, ""},
+		{http.Header{"Ce-Specversion": {"1.0"}, "Ce-Id": {"%zz"}, "Ce-Source": {"/"}, "Ce-Type": {"t"}}, ""},
+		{http.Header{"Content-Type": {ContentTypeCloudEvent}}, `{"specversion":"1.0"}`},
+		{http.Header{"Content-Type": {ContentTypeCloudEvent}}, `[]`},
+		{http.Header{"Content-Type": {ContentTypeCloudEvent}}, `{"specversion":"1.0","id":"1","source":"/","type":"t","data":1,"data_base64":"AQ=="}`},
+		{http.Header{"Content-Type": {ContentTypeCloudEventBatch}}, `{}`},
+		{http.Header{"Content-Type": {ContentTypeCloudEventBatch}}, `[]`},
+		{http.Header{"Content-Type": {ContentTypeCloudEventBatch}}, `[{"specversion":"1.0","id":"1","source":"/","type":"t"},{}]`},
+	} {
+		w = produce(tt.h, tt.body)
+		if w.Code != http.StatusBadRequest || errors.Cause(headers.ReadErrors(w.Header())) != headers.ErrInvalidCloudEvent {
+			t.Error(tt.h, tt.body, w.Code, w.Body.String())
+		}
+	}
+
+	consume := func(path string, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	// events are stored in the structured format
+	msgs, err := s.ConsumeMsgs(ctx, "events", 0, 1)
+	if err != nil || len(msgs) != 1 || string(msgs[0]) != `{"data":{"total":2},"datacontenttype":"application/json","id":"a1","source":"/orders","specversion":"1.0","subject":"café 50%","type":"order.created"}` {
+		t.Fatal(string(msgs[0]), err)
+	}
+
+	// structured
+	w = consume("/topics/events?id=1", "text/plain, "+ContentTypeCloudEvent)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != ContentTypeCloudEvent ||
+		w.Body.String() != `{"specversion":"1.0","id":"a2","source":"/orders","type":"order.paid","datacontenttype":"text/plain","data":"paid"}` {
+		t.Fatal(w.Code, w.Header(), w.Body.String())
+	}
+
+	// batch, wrapping plain messages
+	w = consume("/topics/events?id=2&limit=10", ContentTypeCloudEventBatch)
+	expected := `[{"specversion":"1.0","id":"a3","source":"/orders","type":"order.shipped","data_base64":"AQI="},` +
+		`{"specversion":"1.0","id":"a4","source":"/orders","type":"order.closed"},` +
+		`{"data_base64":"cmF3","datacontenttype":"application/octet-stream","id":"4","source":"/topics/events","specversion":"1.0","type":"io.haraqa.message"},` +
+		`{"data":{"x":1},"datacontenttype":"application/json","id":"5","source":"/topics/events","specversion":"1.0","type":"io.haraqa.message"}]`
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != ContentTypeCloudEventBatch || strings.TrimSpace(w.Body.String()) != expected {
+		t.Fatal(w.Code, w.Header(), w.Body.String())
+	}
+
+	// binary
+	for path, tt := range map[string]struct {
+		contentType string
+		body        string
+		h           map[string]string
+	}{
+		"/topics/events?id=0&cloudevents=binary":  {"application/json", `{"total":2}`, map[string]string{"Ce-Id": "a1", "Ce-Subject": "caf%C3%A9%2050%25", "Ce-Type": "order.created", "Ce-Specversion": "1.0"}},
+		"/topics/events?id=1&cloudevents=binary":  {"text/plain", "paid", map[string]string{"Ce-Id": "a2"}},
+		"/topics/events?id=2&cloudevents=binary":  {"", "\x01\x02", map[string]string{"Ce-Id": "a3"}},
+		"/topics/events?id=3&cloudevents=binary":  {"", "", map[string]string{"Ce-Id": "a4"}},
+		"/topics/events?id=4&cloudevents=binary":  {"application/octet-stream", "raw", map[string]string{"Ce-Id": "4", "Ce-Source": "/topics/events"}},
+		"/topics/events?id=-1&cloudevents=binary": {"application/json", `{"x":1}`, map[string]string{"Ce-Id": "5"}},
+	} {
+		w = consume(path, "")
+		body, _ := ioutil.ReadAll(w.Body)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != tt.contentType || string(body) != tt.body {
+			t.Error(path, w.Code, w.Header(), string(body))
+		}
+		for k, v := range tt.h {
+			if w.Header().Get(k) != v {
+				t.Error(path, k, w.Header().Get(k))
+			}
+		}
+		if w.Header().Get("Ce-Data") != "" || w.Header().Get("Ce-Datacontenttype") != "" {
+			t.Error(path, w.Header())
+		}
+	}
+
+	// empty and missing topics
+	if w = consume("/topics/events?id=10", ContentTypeCloudEvent); w.Code != http.StatusNoContent {
+		t.Fatal(w.Code)
+	}
+	if w = consume("/topics/missing?id=0", ContentTypeCloudEventBatch); w.Code != http.StatusPreconditionFailed {
+		t.Fatal(w.Code)
+	}
+}
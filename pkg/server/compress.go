@@ -0,0 +1,117 @@
+package server
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// minCompressSize is the smallest batch body compressed, smaller batches are sent as stored
+const minCompressSize = 1024
+
+// WithConsumeCompression gzips the batches of consume responses at the level, from gzip.BestSpeed to
+// gzip.BestCompression, for clients which send an Accept-Encoding of gzip. Messages are stored
+// uncompressed, so batches are compressed as they are sent, costing CPU on every compressed consume, and
+// clients which do not accept gzip are sent the stored bytes. Small batches and consumes resumed with a
+// Range header are never compressed
+func WithConsumeCompression(level int) Option {
+	return func(s *Server) error {
+		if level < gzip.BestSpeed || level > gzip.BestCompression {
+			return errors.Errorf("invalid compression level %d, value must be between %d and %d", level, gzip.BestSpeed, gzip.BestCompression)
+		}
+		s.consumeGzip = &sync.Pool{
+			New: func() interface{} {
+				gz, _ := gzip.NewWriterLevel(ioutil.Discard, level)
+				return gz
+			},
+		}
+		return nil
+	}
+}
+
+// acceptsGzip returns true if the Accept-Encoding header of the request allows a gzip response, either
+// naming gzip or * with a non zero q value
+func acceptsGzip(r *http.Request) bool {
+	var star bool
+	for _, value := range r.Header["Accept-Encoding"] {
+		for _, coding := range strings.Split(value, ",") {
+			params := strings.Split(coding, ";")
+			name := strings.ToLower(strings.TrimSpace(params[0]))
+			if name != "gzip" && name != "*" {
+				continue
+			}
+			q := 1.0
+			for _, param := range params[1:] {
+				if param = strings.TrimSpace(param); strings.HasPrefix(param, "q=") {
+					q, _ = strconv.ParseFloat(param[2:], 64)
+				}
+			}
+			if name == "gzip" {
+				return q > 0
+			}
+			star = q > 0
+		}
+	}
+	return star
+}
+
+// gzipWriter compresses a consumed batch if its body is large enough. The message headers describe the
+// uncompressed batch, only Content-Length is removed as the compressed length is not known until the
+// batch has been sent
+type gzipWriter struct {
+	http.ResponseWriter
+	pool        *sync.Pool
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	h["Vary"] = append(h["Vary"], "Accept-Encoding")
+	if code == http.StatusOK || code == http.StatusPartialContent {
+		sizes, _ := headers.ReadSizes(h)
+		var total int64
+		for _, size := range sizes {
+			total += size
+		}
+		if total >= minCompressSize {
+			delete(h, "Content-Length")
+			h["Content-Encoding"] = []string{"gzip"}
+			w.gz = w.pool.Get().(*gzip.Writer)
+			w.gz.Reset(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// close flushes the end of the compressed body and returns the gzip writer to the pool
+func (w *gzipWriter) close() error {
+	if w.gz == nil {
+		return nil
+	}
+	err := w.gz.Close()
+	w.gz.Reset(ioutil.Discard)
+	w.pool.Put(w.gz)
+	w.gz = nil
+	return err
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestWithConsumeCompression(t *testing.T) {
+	s := &Server{}
+	for _, level := range []int{-1, 0, 10} {
+		if err := WithConsumeCompression(level)(s); err == nil {
+			t.Fatal(level)
+		}
+	}
+	if err := WithConsumeCompression(gzip.BestSpeed)(s); err != nil || s.consumeGzip == nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for value, accepted := range map[string]bool{
+		"":                       false,
+		"gzip":                   true,
+		"deflate, GZIP;q=0.5":    true,
+		"br, gzip;q=0":           false,
+		"*":                      true,
+		"*;q=0":                  false,
+		"*, gzip;q=0":            false,
+		"identity":               false,
+		"gzip; level=1; q=0.001": true,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/topics/compressed", nil)
+		if value != "" {
+			r.Header.Set("Accept-Encoding", value)
+		}
+		if acceptsGzip(r) != accepted {
+			t.Error(value, !accepted)
+		}
+	}
+}
+
+func TestServer_ConsumeCompression(t *testing.T) {
+	dir := ".haraqa-compress"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, false, 5000), WithConsumeCompression(gzip.BestSpeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	if err = s.CreateTopic(ctx, "compressed"); err != nil {
+		t.Fatal(err)
+	}
+	large, small := bytes.Repeat([]byte("compress"), minCompressSize), []byte("small")
+	if err = s.ProduceMsgs(ctx, "compressed", large, small); err != nil {
+		t.Fatal(err)
+	}
+
+	consume := func(query, encoding, rng string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/topics/compressed?"+query, nil)
+		if encoding != "" {
+			r.Header.Set("Accept-Encoding", encoding)
+		}
+		if rng != "" {
+			r.Header.Set("Range", rng)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	// large batches are compressed for clients accepting gzip
+	w := consume("id=0&limit=2", "gzip", "")
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Content-Length") != "" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w.Body.Len() >= len(large) {
+		t.Fatal(w.Body.Len())
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(gz)
+	if err != nil || !bytes.Equal(body, append(large, small...)) {
+		t.Fatal(len(body), err)
+	}
+
+	// other clients, small batches and resumed batches are sent as stored
+	if w = consume("id=0&limit=2", "", ""); w.Header().Get("Content-Encoding") != "" || !bytes.Equal(w.Body.Bytes(), append(large, small...)) {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = consume("id=1&limit=1", "gzip", ""); w.Header().Get("Content-Encoding") != "" || w.Body.String() != "small" {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = consume("id=0&limit=2", "gzip", "bytes=8-"); w.Header().Get("Content-Encoding") != "" || w.Body.Len() != len(large)+len(small)-8 {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = consume("id=5&limit=1", "gzip", ""); w.Code != http.StatusNoContent || w.Header().Get("Content-Encoding") != "" {
+		t.Fatal(w.Code, w.Header())
+	}
+}
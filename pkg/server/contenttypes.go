@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"io"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+type contentTypesKey struct{}
+
+// WithContentTypes returns a context for producing messages with the given content type each, messages
+// with an empty content type are produced without one. The content types are returned in the
+// X-Content-Types header when the messages are consumed
+func WithContentTypes(ctx context.Context, types []string) context.Context {
+	return context.WithValue(ctx, contentTypesKey{}, types)
+}
+
+// ContentTypes returns the content types set in the context with WithContentTypes
+func ContentTypes(ctx context.Context) []string {
+	types, _ := ctx.Value(contentTypesKey{}).([]string)
+	return types
+}
+
+// typedQueue is implemented by queues which store the content type of each message
+type typedQueue interface {
+	ProduceWithTypes(ctx context.Context, topic string, msgSizes []int64, ids []headers.MessageID, types []string, timestamp uint64, r io.Reader) error
+}
+
+// checkContentTypes checks the content types in the context, if any, name each of the messages
+func checkContentTypes(ctx context.Context, sizes []int64) error {
+	types := ContentTypes(ctx)
+	if types == nil {
+		return nil
+	}
+	if len(types) != len(sizes) {
+		return headers.ErrInvalidContentType
+	}
+	for _, t := range types {
+		if len(t) > headers.MaxContentTypeLength {
+			return headers.ErrInvalidContentType
+		}
+	}
+	return nil
+}
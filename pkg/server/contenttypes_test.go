@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestServer_ContentTypes(t *testing.T) {
+	dir := ".haraqa-content-types"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.CreateTopic(context.Background(), "types"); err != nil {
+		t.Fatal(err)
+	}
+
+	produce := func(types ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/topics/types", bytes.NewBufferString("{}hello"))
+		r.Header[headers.HeaderSizes] = []string{"2", "5"}
+		r.Header[headers.HeaderContentTypes] = types
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+	if w := produce("application/json", ""); w.Code != http.StatusNoContent {
+		t.Fatal(w.Code, w.Header())
+	}
+	for _, types := range [][]string{{"application/json"}, {"application/json", "text"}} {
+		if w := produce(types...); w.Code != http.StatusBadRequest || headers.ReadErrors(w.Header()) != headers.ErrInvalidContentType {
+			t.Fatal(types, w.Code, w.Header())
+		}
+	}
+
+	// messages produced through the api take their content types from the context
+	ctx := WithContentTypes(context.Background(), []string{"text/plain"})
+	if err = s.ProduceMsgs(ctx, "types", []byte("again")); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(WithContentTypes(context.Background(), []string{"text/plain"}), "types", []byte("a"), []byte("b")); err == nil {
+		t.Fatal("expected content type count error")
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/topics/types?id=0", nil))
+	types, err := headers.ReadContentTypes(w.Header())
+	if err != nil || !reflect.DeepEqual(types, []string{"application/json", "", "text/plain"}) {
+		t.Fatal(types, err)
+	}
+}
+
+func TestServer_ContentTypesUnsupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Return("").AnyTimes()
+	q.EXPECT().Close().Return(nil).AnyTimes()
+	s, err := NewServer(WithQueue(q))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := WithContentTypes(context.Background(), []string{"text/plain"})
+	if err = s.ProduceMsgs(ctx, "types", []byte("hello")); errors.Cause(err) != headers.ErrUnsupportedFeature {
+		t.Fatal(err)
+	}
+}
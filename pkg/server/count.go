@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// countSuffix ends the path of a count request, /topics/{topic}/count
+const countSuffix = "/count"
+
+// isCount returns true if the request counts the messages of a topic. Consumes of a topic ending in /count
+// always have an id, which count requests never do
+func isCount(r *http.Request) bool {
+	if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, countSuffix) {
+		return false
+	}
+	query := r.URL.Query()
+	_, ok := query["id"]
+	return !ok && query.Get("config") != "true"
+}
+
+// CountMessages returns the number of messages of the topic with offsets from from up to but excluding to,
+// from the indexes of the queue, so that reconciliation jobs can compare the counts of a source and a sink.
+// The range is limited to the messages in the topic
+func (s *Server) CountMessages(ctx context.Context, topic string, from, to int64) (*headers.MessageCount, error) {
+	topic, err := cleanTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.permit(ctx, topic, headers.PermissionConsume); err != nil {
+		return nil, err
+	}
+	info, err := s.q.InspectTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	return s.countMessages(topic, info, from, to)
+}
+
+// countMessages counts the messages of the topic in the range, limited to the messages in the topic
+func (s *Server) countMessages(topic string, info *headers.TopicInfo, from, to int64) (*headers.MessageCount, error) {
+	q, ok := s.q.(interface {
+		CountMessages(topic string, from, to int64) (int64, error)
+	})
+	if !ok {
+		return nil, errors.Wrap(headers.ErrUnsupportedFeature, "queue does not count messages")
+	}
+	end := info.MinOffset
+	if info.MaxOffset >= info.MinOffset {
+		end = info.MaxOffset + 1
+	}
+	if from < info.MinOffset {
+		from = info.MinOffset
+	}
+	if to > end {
+		to = end
+	}
+	if to < from {
+		to = from
+	}
+	count, err := q.CountMessages(topic, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return &headers.MessageCount{Topic: topic, From: from, To: to, Count: count}, nil
+}
+
+// HandleCount handles GET requests to /topics/{topic}/count, returning the number of messages from the from
+// query parameter up to but excluding to. Each is an offset or an RFC3339 time, which stands for the offset of
+// the first message produced at or after it. By default the range is every message in the topic
+func (s *Server) HandleCount(w http.ResponseWriter, r *http.Request) {
+	topic, err := cleanTopic(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/topics/"), countSuffix))
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+	info, err := s.q.InspectTopic(topic)
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+	query := r.URL.Query()
+	from, err := s.countBound(r.Context(), topic, info, query.Get("from"), info.MinOffset)
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+	to, err := s.countBound(r.Context(), topic, info, query.Get("to"), info.MaxOffset+1)
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+	count, err := s.countMessages(topic, info, from, to)
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+	writeSchemaJSON(w, http.StatusOK, count)
+}
+
+// countBound returns the offset of a bound of a count range, given as an offset or a time, or def if it is empty
+func (s *Server) countBound(ctx context.Context, topic string, info *headers.TopicInfo, value string, def int64) (int64, error) {
+	if value == "" {
+		return def, nil
+	}
+	if offset, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return offset, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return 0, errors.Wrapf(headers.ErrInvalidMessageID, "invalid offset or time %q", value)
+	}
+	return s.offsetAt(ctx, topic, info, t)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestServer_CountMessages(t *testing.T) {
+	dir := ".haraqa-count"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	for _, topic := range []string{"orders", "empty"} {
+		if err = s.CreateTopic(ctx, topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(-4 * time.Hour)
+	for i := 0; i < 4; i++ {
+		timestamp := uint64(start.Add(time.Duration(i) * time.Hour).UnixNano())
+		if err = s.q.Produce(ctx, "orders", []int64{5}, timestamp, bytes.NewBufferString("order")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if count, err := s.CountMessages(ctx, "orders", 1, 100); err != nil || count.From != 1 || count.To != 4 || count.Count != 3 {
+		t.Fatal(count, err)
+	}
+	if count, err := s.CountMessages(ctx, "orders", 3, 1); err != nil || count.Count != 0 {
+		t.Fatal(count, err)
+	}
+	if _, err := s.CountMessages(ctx, "missing", 0, 1); errors.Cause(err) != headers.ErrTopicDoesNotExist {
+		t.Fatal(err)
+	}
+
+	count := func(path string, query url.Values) (*httptest.ResponseRecorder, headers.MessageCount) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path+"?"+query.Encode(), nil))
+		var c headers.MessageCount
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&c); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w, c
+	}
+	for query, expected := range map[string]int64{
+		"":               4,
+		"from=1":         3,
+		"to=2":           2,
+		"from=-5&to=100": 4,
+		"from=2&to=2":    0,
+		"from=" + url.QueryEscape(start.Add(90*time.Minute).Format(time.RFC3339Nano)): 2,
+		"to=" + url.QueryEscape(start.Add(-time.Hour).Format(time.RFC3339)):           0,
+		"from=1&to=" + url.QueryEscape(time.Now().Format(time.RFC3339Nano)):           3,
+	} {
+		values, _ := url.ParseQuery(query)
+		w, c := count("/topics/orders/count", values)
+		if w.Code != http.StatusOK || c.Topic != "orders" || c.Count != expected {
+			t.Fatal(query, w.Code, c)
+		}
+	}
+	if w, c := count("/topics/empty/count", nil); w.Code != http.StatusOK || c.Count != 0 {
+		t.Fatal(w.Code, c)
+	}
+	if w, _ := count("/topics/orders/count", url.Values{"from": {"yesterday"}}); w.Code != http.StatusBadRequest {
+		t.Fatal(w.Code)
+	}
+	if w, _ := count("/topics/missing/count", nil); w.Code != http.StatusPreconditionFailed {
+		t.Fatal(w.Code)
+	}
+
+	// requests with an id consume a topic ending in /count
+	r := httptest.NewRequest(http.MethodGet, "/topics/orders/count?id=0", nil)
+	if isCount(r) {
+		t.Fatal("expected a consume")
+	}
+	if op := requestOperation(httptest.NewRequest(http.MethodGet, "/topics/orders/count", nil)); op != "count" {
+		t.Fatal(op)
+	}
+
+	// queues which cannot count return an error
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Return("").AnyTimes()
+	q.EXPECT().InspectTopic("orders").Return(&headers.TopicInfo{MinOffset: 0, MaxOffset: 3}, nil)
+	mocked, err := NewServer(WithQueue(q))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mocked.CountMessages(ctx, "orders", 0, 4); errors.Cause(err) != headers.ErrUnsupportedFeature {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cronSchedule is a parsed cron expression. Each field is a bitmask of the values it matches
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// days match either the day of the month or the day of the week when both are restricted, as in cron
+	anyDay bool
+}
+
+// cronDescriptors are the shorthands accepted in place of the five fields
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	cronMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronDays   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// parseCron parses a cron expression of five fields, minute, hour, day of month, month and day of week, or one
+// of the descriptors @yearly, @monthly, @weekly, @daily and @hourly. Fields are comma separated lists of
+// values, ranges and * with an optional /step, months and days of the week may be given by their first three
+// letters. Sunday is both 0 and 7
+func parseCron(expr string) (*cronSchedule, error) {
+	if d, ok := cronDescriptors[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("invalid cron expression %q, expected 5 fields", expr)
+	}
+	c := &cronSchedule{}
+	var err error
+	for _, f := range []struct {
+		field    string
+		mask     *uint64
+		min, max int
+		names    []string
+		nameBase int
+	}{
+		{fields[0], &c.minute, 0, 59, nil, 0},
+		{fields[1], &c.hour, 0, 23, nil, 0},
+		{fields[2], &c.dom, 1, 31, nil, 0},
+		{fields[3], &c.month, 1, 12, cronMonths, 1},
+		{fields[4], &c.dow, 0, 7, cronDays, 0},
+	} {
+		if *f.mask, err = parseCronField(f.field, f.min, f.max, f.names, f.nameBase); err != nil {
+			return nil, errors.Wrapf(err, "invalid cron expression %q", expr)
+		}
+	}
+	// sunday is both 0 and 7
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.anyDay = strings.HasPrefix(fields[2], "*") || strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+// parseCronField returns the bitmask of the values matched by the field
+func parseCronField(field string, min, max int, names []string, nameBase int) (uint64, error) {
+	var mask uint64
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(item, '/'); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step %q", item[i+1:])
+			}
+			item = item[:i]
+		}
+		low, high := min, max
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			if low, err = parseCronValue(bounds[0], min, max, names, nameBase); err != nil {
+				return 0, err
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = parseCronValue(bounds[1], min, max, names, nameBase); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// a value with a step runs from the value to the maximum, e.g. 5/15 is 5-59/15
+				high = max
+			}
+			if high < low {
+				return 0, errors.Errorf("invalid range %q", item)
+			}
+		}
+		for v := low; v <= high; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+// parseCronValue parses a number or a name of a field's values
+func parseCronValue(s string, min, max int, names []string, nameBase int) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(s, name) {
+			return i + nameBase, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < min || v > max {
+		return 0, errors.Errorf("invalid value %q, expected %d-%d", s, min, max)
+	}
+	return v, nil
+}
+
+// next returns the first time after t the schedule matches, in t's location, or the zero time if it does not
+// match within five years, as for the 30th of February
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, t.Location())
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchDay returns true if the day of t matches the day of the month and the day of the week, or either
+// if both are restricted
+func (c *cronSchedule) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.anyDay {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8",
+		"5-1 * * * *", "*/0 * * * *", "a * * * *", "* * * foo *", "1-2-3 * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("expected invalid cron expression %q", expr)
+		}
+	}
+
+	c, err := parseCron("*/15 9-17 * * mon-fri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.minute != 1|1<<15|1<<30|1<<45 || c.dow != 0x3e || !c.anyDay {
+		t.Fatalf("%x %x %v", c.minute, c.dow, c.anyDay)
+	}
+	if c, err = parseCron("5/20 0 1,15 JAN 7"); err != nil || c.minute != 1<<5|1<<25|1<<45 || c.dow != 1|1<<7 || c.anyDay {
+		t.Fatal(c, err)
+	}
+	if c, err = parseCron("@Daily"); err != nil || c.minute != 1 || c.hour != 1 || c.dom>>1 != 1<<31-1 {
+		t.Fatal(c, err)
+	}
+}
+
+func TestCronSchedule_Next(t *testing.T) {
+	start := time.Date(2021, time.January, 30, 10, 30, 15, 0, time.UTC) // a saturday
+	for expr, expected := range map[string]time.Time{
+		"* * * * *":       time.Date(2021, time.January, 30, 10, 31, 0, 0, time.UTC),
+		"@hourly":         time.Date(2021, time.January, 30, 11, 0, 0, 0, time.UTC),
+		"30 10 * * *":     time.Date(2021, time.January, 31, 10, 30, 0, 0, time.UTC),
+		"0 0 * * mon-fri": time.Date(2021, time.February, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 31 2-12 *":   time.Date(2021, time.March, 31, 0, 0, 0, 0, time.UTC),
+		"0 0 29 2 *":      time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC),
+		// with both days restricted either matches
+		"0 12 15 * sun": time.Date(2021, time.January, 31, 12, 0, 0, 0, time.UTC),
+		"0 0 30 2 *":    {},
+	} {
+		c, err := parseCron(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next := c.next(start); !next.Equal(expected) {
+			t.Errorf("%q: expected %v, got %v", expr, expected, next)
+		}
+	}
+
+	// times are matched in the location of the time given
+	loc := time.FixedZone("UTC+5:30", 5*3600+1800)
+	c, _ := parseCron("@daily")
+	if next := c.next(start.In(loc)); !next.Equal(time.Date(2021, time.January, 31, 0, 0, 0, 0, loc)) {
+		t.Error(next)
+	}
+}
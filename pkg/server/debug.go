@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+// inFlight counts the requests currently being handled, all fields are updated atomically
+type inFlight struct {
+	produce int64
+	consume int64
+	other   int64
+}
+
+// counter returns the in flight counter for the request
+func (f *inFlight) counter(r *http.Request) *int64 {
+	switch operation(r) {
+	case OpProduce:
+		return &f.produce
+	case OpConsume:
+		return &f.consume
+	}
+	return &f.other
+}
+
+// operation returns the operation type of the request, one of produce, consume or other
+func operation(r *http.Request) string {
+	if len(r.URL.Path) > len("/topics/") && strings.HasPrefix(r.URL.Path, "/topics/") {
+		switch r.Method {
+		case http.MethodPost:
+			return OpProduce
+		case http.MethodGet:
+			return OpConsume
+		}
+	}
+	return OpOther
+}
+
+type debugResponse struct {
+	headers.QueueDebug
+	InFlight       map[string]int64            `json:"inFlight"`
+	ConsumerGroups map[string]map[string]int64 `json:"consumerGroups"`
+	ConsumeLimits  map[string]int64            `json:"consumeLimits,omitempty"`
+}
+
+// HandleDebugQueue returns a json snapshot of the queue's open files and cache contents, the write offsets of
+// each cached topic, consumer group offsets, adaptive consume limits and the number of in flight requests. It is not routed by the
+// server and should only be exposed on an admin endpoint
+func (s *Server) HandleDebugQueue(w http.ResponseWriter, r *http.Request) {
+	response := debugResponse{
+		QueueDebug: s.q.DebugInfo(),
+		InFlight: map[string]int64{
+			"produce": atomic.LoadInt64(&s.inFlight.produce),
+			"consume": atomic.LoadInt64(&s.inFlight.consume),
+			"other":   atomic.LoadInt64(&s.inFlight.other),
+		},
+		ConsumerGroups: s.groupOffsets.snapshot(),
+	}
+	if s.adaptive != nil {
+		response.ConsumeLimits = s.adaptive.snapshot(s.defaultConsumeLimit)
+	}
+	w.Header()[headers.ContentType] = []string{"application/json"}
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(&response)
+}
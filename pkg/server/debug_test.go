@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestServer_HandleDebugQueue(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	queueDebug := headers.QueueDebug{
+		Stats:        headers.CacheStats{OpenFiles: 2},
+		OpenFiles:    []string{"a", "a.log"},
+		Producers:    map[string]headers.ProducerDebug{"debug": {File: "a", NextID: 3, DatOffset: 96, LogOffset: 10}},
+		ConsumeNames: map[string][]string{"debug": {"a", "a.log"}},
+	}
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Times(1).Return("")
+	q.EXPECT().Produce(gomock.Any(), "debug", []int64{5}, gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, string, []int64, uint64, io.Reader) error {
+			close(started)
+			<-release
+			return nil
+		}).Times(1)
+	q.EXPECT().Consume(gomock.Any(), "debug", int64(0), int64(-1), gomock.Any()).Return(1, nil).Times(1)
+	q.EXPECT().DebugInfo().Return(queueDebug).Times(1)
+	q.EXPECT().Close().Return(nil).Times(1)
+
+	s, err := NewServer(WithQueue(q))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// record a consumer group offset
+	r, err := http.NewRequest(http.MethodGet, "/topics/debug?id=0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set(headers.HeaderGroup, "group")
+	s.ServeHTTP(httptest.NewRecorder(), r)
+
+	// hold a produce request in flight
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r, err := http.NewRequest(http.MethodPost, "/topics/debug", bytes.NewBufferString("hello"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		r.Header.Set(headers.HeaderSizes, "5")
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	r, err = http.NewRequest(http.MethodGet, "/debug/queue", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.HandleDebugQueue(w, r)
+	close(release)
+	<-done
+
+	if w.Code != http.StatusOK || w.Header().Get(headers.ContentType) != "application/json" {
+		t.Fatal(w.Code, w.Header())
+	}
+	var response debugResponse
+	if err = json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(response.QueueDebug, queueDebug) {
+		t.Errorf("%+v", response.QueueDebug)
+	}
+	if !reflect.DeepEqual(response.InFlight, map[string]int64{"produce": 1, "consume": 0, "other": 0}) {
+		t.Error(response.InFlight)
+	}
+	if response.ConsumerGroups["debug"]["group"] != 1 {
+		t.Error(response.ConsumerGroups)
+	}
+}
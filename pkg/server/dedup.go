@@ -0,0 +1,111 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// WithProducerDedup drops produce requests which repeat a recent sequence number of their producer. Producers
+// identify themselves with the X-Producer-Id header and number each batch with the X-Producer-Seq header, the
+// last window sequence numbers of each producer and topic are remembered until the producer is idle for ttl
+func WithProducerDedup(window int, ttl time.Duration) Option {
+	return func(s *Server) error {
+		if window <= 0 {
+			return errors.New("invalid dedup window, value must be greater than 0")
+		}
+		if ttl <= 0 {
+			return errors.New("invalid dedup ttl, value must be greater than 0")
+		}
+		s.dedup = &dedup{
+			window:    window,
+			ttl:       ttl,
+			producers: make(map[string]*producerSeqs),
+		}
+		return nil
+	}
+}
+
+// dedup tracks the recent sequence numbers of each producer
+type dedup struct {
+	mux       sync.Mutex
+	window    int
+	ttl       time.Duration
+	producers map[string]*producerSeqs
+	pruned    time.Time
+}
+
+// producerSeqs is a rolling window of the sequence numbers produced by a producer to a topic. The lock is
+// held for the duration of each produce so that a retry waits for the outcome of the original request
+type producerSeqs struct {
+	sync.Mutex
+	ring     []uint64
+	next     int
+	seen     map[uint64]bool
+	lastSeen time.Time
+}
+
+// producer returns the sequence numbers of the request's producer along with the request's sequence
+// number, nil is returned if deduplication is disabled or the request has no producer id
+func (d *dedup) producer(r *http.Request, topic string) (*producerSeqs, uint64, error) {
+	if d == nil {
+		return nil, 0, nil
+	}
+	id := r.Header.Get(headers.HeaderProducerID)
+	if id == "" {
+		return nil, 0, nil
+	}
+	seq, err := strconv.ParseUint(r.Header.Get(headers.HeaderSequence), 10, 64)
+	if err != nil {
+		return nil, 0, headers.ErrInvalidSequence
+	}
+
+	now := time.Now()
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	// forget idle producers
+	if now.Sub(d.pruned) >= d.ttl {
+		for k, p := range d.producers {
+			if now.Sub(p.lastSeen) >= d.ttl {
+				delete(d.producers, k)
+			}
+		}
+		d.pruned = now
+	}
+
+	key := topic + "\x00" + id
+	p, ok := d.producers[key]
+	if !ok {
+		p = &producerSeqs{ring: make([]uint64, 0, d.window), seen: make(map[uint64]bool, d.window)}
+		d.producers[key] = p
+	}
+	p.lastSeen = now
+	return p, seq, nil
+}
+
+// contains returns true if seq is in the window. The producer lock must be held
+func (p *producerSeqs) contains(seq uint64) bool {
+	return p.seen[seq]
+}
+
+// add adds seq to the window, evicting the oldest sequence number if the window is full. False is
+// returned if seq is already in the window. The producer lock must be held
+func (p *producerSeqs) add(seq uint64) bool {
+	if p.seen[seq] {
+		return false
+	}
+	if len(p.ring) < cap(p.ring) {
+		p.ring = append(p.ring, seq)
+	} else {
+		delete(p.seen, p.ring[p.next])
+		p.ring[p.next] = seq
+		p.next = (p.next + 1) % len(p.ring)
+	}
+	p.seen[seq] = true
+	return true
+}
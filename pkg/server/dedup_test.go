@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestWithProducerDedup(t *testing.T) {
+	if err := WithProducerDedup(0, time.Minute)(&Server{}); err == nil {
+		t.Error("expected window error")
+	}
+	if err := WithProducerDedup(10, 0)(&Server{}); err == nil {
+		t.Error("expected ttl error")
+	}
+	s := &Server{}
+	if err := WithProducerDedup(10, time.Minute)(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.dedup == nil || s.dedup.window != 10 || s.dedup.ttl != time.Minute {
+		t.Fatal(s.dedup)
+	}
+}
+
+func TestServer_ProducerDedup(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Times(1).Return("")
+	produced := map[string]int{}
+	q.EXPECT().Produce(gomock.Any(), gomock.Any(), []int64{5}, gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, topic string, sizes []int64, timestamp uint64, r interface{}) error {
+			produced[topic]++
+			if topic == "failing" && produced[topic] == 1 {
+				return errors.New("test produce error")
+			}
+			return nil
+		}).AnyTimes()
+	q.EXPECT().Close().Return(nil).Times(1)
+	s, err := NewServer(WithQueue(q), WithProducerDedup(2, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	produce := func(topic, producer, seq string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/topics/"+topic, bytes.NewBufferString("hello"))
+		r.Header.Set(headers.HeaderSizes, "5")
+		if producer != "" {
+			r.Header.Set(headers.HeaderProducerID, producer)
+			r.Header.Set(headers.HeaderSequence, seq)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	for i, tt := range []struct {
+		topic, producer, seq string
+		status               int
+		duplicate            bool
+	}{
+		{"dedup", "p1", "1", http.StatusNoContent, false},
+		{"dedup", "p1", "1", http.StatusNoContent, true},
+		{"dedup", "p1", "2", http.StatusNoContent, false},
+		{"dedup", "p2", "1", http.StatusNoContent, false},
+		{"other", "p1", "1", http.StatusNoContent, false},
+		{"dedup", "", "", http.StatusNoContent, false},
+		{"dedup", "", "", http.StatusNoContent, false},
+		{"dedup", "p1", "invalid", http.StatusBadRequest, false},
+
+		// the oldest sequence number is evicted once the window is full
+		{"dedup", "p1", "3", http.StatusNoContent, false},
+		{"dedup", "p1", "2", http.StatusNoContent, true},
+		{"dedup", "p1", "1", http.StatusNoContent, false},
+
+		// failed batches are not remembered
+		{"failing", "p1", "1", http.StatusInternalServerError, false},
+		{"failing", "p1", "1", http.StatusNoContent, false},
+		{"failing", "p1", "1", http.StatusNoContent, true},
+	} {
+		w := produce(tt.topic, tt.producer, tt.seq)
+		if w.Code != tt.status || (w.Header().Get(headers.HeaderDuplicate) == "true") != tt.duplicate {
+			t.Fatal(i, w.Code, w.Header())
+		}
+		if w.Code == http.StatusBadRequest && headers.ReadErrors(w.Header()) != headers.ErrInvalidSequence {
+			t.Fatal(i, w.Header())
+		}
+	}
+	if produced["dedup"] != 7 || produced["other"] != 1 || produced["failing"] != 2 {
+		t.Fatal(produced)
+	}
+
+	// idle producers are forgotten
+	for _, p := range s.dedup.producers {
+		p.lastSeen = time.Now().Add(-time.Hour)
+	}
+	s.dedup.pruned = time.Now().Add(-time.Hour)
+	if w := produce("dedup", "p1", "1"); w.Header().Get(headers.HeaderDuplicate) != "" {
+		t.Fatal(w.Header())
+	}
+	if len(s.dedup.producers) != 1 {
+		t.Fatal(s.dedup.producers)
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// WithDiskMonitor periodically checks the disk usage of the queue, reporting it and the number of messages
+// in each topic to the metrics handler.
+// If the used fraction of any queue directory reaches highWater (between 0 and 1) the server becomes
+// degraded, rejecting produce and create topic requests until the usage drops back below the mark
+func WithDiskMonitor(interval time.Duration, highWater float64) Option {
+	return func(s *Server) error {
+		if interval <= 0 {
+			return errors.New("invalid interval, value must be greater than 0")
+		}
+		if highWater <= 0 || highWater > 1 {
+			return errors.New("invalid high water mark, value must be greater than 0 and at most 1")
+		}
+		s.diskInterval = interval
+		s.diskHighWater = highWater
+		return nil
+	}
+}
+
+func (s *Server) monitorDisk() {
+	ticker := time.NewTicker(s.diskInterval)
+	defer ticker.Stop()
+	for {
+		s.checkDisk()
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) checkDisk() {
+	usage, err := s.q.DiskUsage()
+	if err != nil {
+		s.logger.Error("unable to get disk usage", "err", err)
+		return
+	}
+
+	var degradedDir string
+	var degradedFraction float64
+	for _, dir := range usage.Dirs {
+		s.metrics.DiskUsage(dir.Path, dir.Total, dir.Free)
+		if f := dir.UsedFraction(); f >= s.diskHighWater && f > degradedFraction {
+			degradedDir, degradedFraction = dir.Path, f
+		}
+	}
+	for topic, size := range usage.Topics {
+		s.metrics.TopicDiskUsage(topic, size)
+		if info, err := s.q.InspectTopic(topic); err == nil && info != nil {
+			s.metrics.TopicDepth(topic, info.MaxOffset-info.MinOffset+1)
+		}
+	}
+
+	if degradedDir != "" {
+		if atomic.SwapInt32(&s.degraded, 1) == 0 {
+			s.logger.Warn("disk usage above high water mark, rejecting writes", "dir", degradedDir,
+				"used", degradedFraction, "highWater", s.diskHighWater)
+		}
+		return
+	}
+	if atomic.SwapInt32(&s.degraded, 0) == 1 {
+		s.logger.Info("disk usage below high water mark, accepting writes")
+	}
+}
+
+// isDegraded returns true if the disk usage has reached the high water mark
+func (s *Server) isDegraded() bool {
+	return atomic.LoadInt32(&s.degraded) == 1
+}
+
+// setReadOnly disables writes after the queue ran out of disk space. Writes are rejected with
+// headers.ErrDiskFull until the retry period has passed, after which the next write tests the disk again
+func (s *Server) setReadOnly(err error) {
+	if atomic.SwapInt64(&s.readOnlySince, time.Now().UnixNano()) == 0 {
+		s.logger.Error("disk full, rejecting writes", "retry", s.diskFullRetry, "err", err)
+		s.metrics.ReadOnly(true)
+	}
+}
+
+// clearReadOnly enables writes after a write succeeded
+func (s *Server) clearReadOnly() {
+	if atomic.LoadInt64(&s.readOnlySince) != 0 && atomic.SwapInt64(&s.readOnlySince, 0) != 0 {
+		s.logger.Info("disk space available, accepting writes")
+		s.metrics.ReadOnly(false)
+	}
+}
+
+// isReadOnly returns true if a write failed because the disk was full within the retry period
+func (s *Server) isReadOnly() bool {
+	since := atomic.LoadInt64(&s.readOnlySince)
+	return since != 0 && time.Since(time.Unix(0, since)) < s.diskFullRetry
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+type diskMetrics struct {
+	noOpMetrics
+	dirs     map[string][2]int64
+	topics   map[string]int64
+	depths   map[string]int64
+	readOnly []bool
+}
+
+func (m *diskMetrics) TopicDepth(topic string, depth int64) {
+	m.depths[topic] = depth
+}
+
+func (m *diskMetrics) DiskUsage(dir string, total, free int64) {
+	m.dirs[dir] = [2]int64{total, free}
+}
+
+func (m *diskMetrics) TopicDiskUsage(topic string, size int64) {
+	m.topics[topic] = size
+}
+
+func (m *diskMetrics) ReadOnly(readOnly bool) {
+	m.readOnly = append(m.readOnly, readOnly)
+}
+
+func TestWithDiskMonitor(t *testing.T) {
+	if err := WithDiskMonitor(0, 0.9)(&Server{}); err == nil {
+		t.Error("expected interval error")
+	}
+	if err := WithDiskMonitor(time.Second, 0)(&Server{}); err == nil {
+		t.Error("expected high water error")
+	}
+	if err := WithDiskMonitor(time.Second, 1.5)(&Server{}); err == nil {
+		t.Error("expected high water error")
+	}
+	s := &Server{}
+	if err := WithDiskMonitor(time.Second, 0.9)(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.diskInterval != time.Second || s.diskHighWater != 0.9 {
+		t.Error(s.diskInterval, s.diskHighWater)
+	}
+}
+
+func TestServer_checkDisk(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	full := &headers.DiskUsage{
+		Dirs:   []headers.DirUsage{{Path: "a", Total: 100, Free: 50}, {Path: "b", Total: 100, Free: 5}},
+		Topics: map[string]int64{"topic": 10},
+	}
+	empty := &headers.DiskUsage{
+		Dirs: []headers.DirUsage{{Path: "a", Total: 100, Free: 50}, {Path: "b", Total: 100, Free: 90}},
+	}
+	q := NewMockQueue(ctrl)
+	gomock.InOrder(
+		q.EXPECT().RootDir().Times(1).Return(""),
+		q.EXPECT().DiskUsage().Return(full, nil).Times(1),
+		q.EXPECT().InspectTopic("topic").Return(&headers.TopicInfo{MinOffset: 5, MaxOffset: 14}, nil).Times(1),
+		q.EXPECT().DiskUsage().Return(nil, errors.New("test disk error")).Times(1),
+		q.EXPECT().DiskUsage().Return(empty, nil).Times(1),
+		q.EXPECT().Close().Return(nil).Times(1),
+	)
+	metrics := &diskMetrics{dirs: map[string][2]int64{}, topics: map[string]int64{}, depths: map[string]int64{}}
+	logger := &testLogger{}
+	s, err := NewServer(WithQueue(q), WithMetrics(metrics), WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.diskHighWater = 0.9
+
+	// above the high water mark
+	s.checkDisk()
+	if !s.isDegraded() {
+		t.Fatal("expected degraded server")
+	}
+	if metrics.dirs["b"] != [2]int64{100, 5} || metrics.topics["topic"] != 10 || metrics.depths["topic"] != 10 {
+		t.Error(metrics.dirs, metrics.topics, metrics.depths)
+	}
+	for _, method := range []string{http.MethodPost, http.MethodPut} {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest(method, "/topics/topic", bytes.NewBufferString("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusInsufficientStorage {
+			t.Error(method, w.Code)
+		}
+	}
+
+	// errors leave the state unchanged
+	s.checkDisk()
+	if !s.isDegraded() {
+		t.Fatal("expected degraded server")
+	}
+
+	// below the high water mark
+	s.checkDisk()
+	if s.isDegraded() {
+		t.Fatal("expected healthy server")
+	}
+	if len(logger.entries) != 3 {
+		t.Error(logger.entries)
+	}
+}
+
+func TestServer_monitorDisk(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Times(1).Return("")
+	q.EXPECT().DiskUsage().Return(&headers.DiskUsage{}, nil).MinTimes(1)
+	q.EXPECT().Close().Return(nil).Times(1)
+	s, err := NewServer(WithQueue(q), WithDiskMonitor(time.Millisecond, 0.9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_DiskFull(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	topic := "full"
+	errDiskFull := errors.Wrap(headers.ErrDiskFull, "no space left on device")
+	q := NewMockQueue(ctrl)
+	gomock.InOrder(
+		q.EXPECT().RootDir().Times(1).Return(""),
+		q.EXPECT().Produce(gomock.Any(), topic, []int64{5}, gomock.Any(), gomock.Any()).Return(errDiskFull).Times(1),
+		q.EXPECT().Consume(gomock.Any(), topic, int64(0), int64(-1), gomock.Any()).Return(1, nil).Times(1),
+		q.EXPECT().Produce(gomock.Any(), topic, []int64{5}, gomock.Any(), gomock.Any()).Return(nil).Times(1),
+		q.EXPECT().Close().Return(nil).Times(1),
+	)
+	metrics := &diskMetrics{}
+	s, err := NewServer(WithQueue(q), WithMetrics(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	produce := func() *http.Response {
+		r := httptest.NewRequest(http.MethodPost, "/topics/"+topic, bytes.NewBufferString("hello"))
+		r.Header.Set(headers.HeaderSizes, "5")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	// the first write to fail disables writes
+	for i := 0; i < 2; i++ {
+		resp := produce()
+		if resp.StatusCode != http.StatusServiceUnavailable || headers.ReadErrors(resp.Header) != headers.ErrDiskFull {
+			t.Fatal(i, resp.Status)
+		}
+	}
+	if !s.isReadOnly() || len(metrics.readOnly) != 1 || !metrics.readOnly[0] {
+		t.Fatal(metrics.readOnly)
+	}
+	if err = s.CreateTopic(context.Background(), "other"); err != headers.ErrDiskFull {
+		t.Fatal(err)
+	}
+
+	// consumes still work
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/topics/"+topic+"?id=0", nil))
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Code)
+	}
+
+	// writes are tested again after the retry period
+	s.diskFullRetry = 0
+	if resp := produce(); resp.StatusCode != http.StatusNoContent {
+		t.Fatal(resp.Status)
+	}
+	if s.isReadOnly() || len(metrics.readOnly) != 2 || metrics.readOnly[1] {
+		t.Fatal(metrics.readOnly)
+	}
+}
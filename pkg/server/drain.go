@@ -0,0 +1,107 @@
+package server
+
+import (
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// consumeAll streams every message currently in the topic, from id up to the last offset at the time of
+// the request. The queue is read in batches of the default consume limit and each batch is sent as it is
+// read, as a part of a multipart/mixed response framed as for follow=true, so that clients can read the
+// messages of a batch without waiting for the rest of the topic
+func (s *Server) consumeAll(w http.ResponseWriter, r *http.Request, topic string, id int64) {
+	info, err := s.InspectTopic(r.Context(), topic)
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+	switch {
+	case id < 0:
+		id = info.MaxOffset
+	case id < info.MinOffset:
+		id = info.MinOffset
+	}
+	if id > info.MaxOffset {
+		headers.SetError(w, headers.ErrNoContent)
+		return
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header()[headers.ContentType] = []string{"multipart/mixed; boundary=" + mw.Boundary()}
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	start := id
+	for id <= info.MaxOffset {
+		limit := s.consumeLimit(topic)
+		if limit <= 0 || limit > info.MaxOffset-id+1 {
+			limit = info.MaxOffset - id + 1
+		}
+		count, err := s.writeBatch(r.Context(), mw, topic, id, limit)
+		if err != nil {
+			part := textproto.MIMEHeader{}
+			part[headers.HeaderErrors] = []string{errors.Cause(err).Error()}
+			part[headers.HeaderErrorCode] = []string{string(headers.Code(err))}
+			_, _ = mw.CreatePart(part)
+			break
+		}
+		if count == 0 {
+			break
+		}
+		id += int64(count)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	_ = mw.Close()
+	s.commitGroup(r.Header.Get(headers.HeaderGroup), topic, start, int(id-start))
+}
+
+// streamWriter passes the body of a consumed batch through to the response, keeping the batch's headers
+// and status separate as the response headers have already been written
+type streamWriter struct {
+	w      io.Writer
+	header http.Header
+	status int
+}
+
+func (w *streamWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *streamWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status != http.StatusOK && w.status != http.StatusPartialContent {
+		// drop error bodies, the error is reported in a part of its own
+		return len(b), nil
+	}
+	return w.w.Write(b)
+}
+
+func (w *streamWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+// sizes returns the sizes of the batch's messages
+func (w *streamWriter) sizes(count int) ([]int64, error) {
+	if w.status != 0 && w.status != http.StatusOK && w.status != http.StatusPartialContent {
+		return nil, errors.Errorf("unable to read messages, unexpected status %d", w.status)
+	}
+	sizes, err := headers.ReadSizes(w.header)
+	if err != nil {
+		return nil, err
+	}
+	if len(sizes) != count {
+		return nil, errors.Errorf("unable to read messages, expected %d sizes but got %d", count, len(sizes))
+	}
+	return sizes, nil
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestServer_ConsumeAll(t *testing.T) {
+	dir := ".haraqa-drain"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 3), WithDefaultConsumeLimit(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	ctx := context.Background()
+
+	if err = s.CreateTopic(ctx, "drain"); err != nil {
+		t.Fatal(err)
+	}
+	get := func(query string) *http.Response {
+		resp, err := ts.Client().Get(ts.URL + "/topics/drain?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// empty topic
+	resp := get("id=0&limit=all")
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatal(resp.StatusCode)
+	}
+
+	// messages span several files and batches
+	msgs := []string{"a", "bb", "ccc", "dddd", "eeeee", "ffffff", "ggggggg"}
+	for _, msg := range msgs {
+		if err = s.ProduceMsgs(ctx, "drain", []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for query, expected := range map[string][]string{
+		"id=0&limit=all": msgs,
+		"id=2&limit=-1":  msgs[2:],
+		"id=-1&limit=-1": msgs[6:],
+	} {
+		resp = get(query)
+		batches, parts := readBatches(t, resp)
+		if resp.StatusCode != http.StatusOK || strings.Join(batches, "") != strings.Join(expected, "") {
+			t.Fatal(query, resp.StatusCode, batches)
+		}
+		// each batch of up to the default limit is framed as it is read
+		for _, part := range parts {
+			if len(part[headers.HeaderSizes]) > 2 || part.Get(headers.HeaderErrors) != "" {
+				t.Error(query, part)
+			}
+		}
+		last := parts[len(parts)-1]
+		if len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" {
+			t.Error(resp.TransferEncoding)
+		}
+		sizes, err := headers.ReadSizes(last)
+		if err != nil || sizes[len(sizes)-1] != int64(len(expected[len(expected)-1])) {
+			t.Error(query, sizes, err)
+		}
+		if timestamps, err := headers.ReadTimestamps(last); err != nil || len(timestamps) != len(sizes) {
+			t.Error(query, timestamps, err)
+		}
+		if offsets := last[headers.HeaderOffsets]; offsets[len(offsets)-1] != "6" {
+			t.Error(query, offsets)
+		}
+	}
+
+	// truncated messages are skipped
+	if _, err = s.ModifyTopic(ctx, "drain", headers.ModifyRequest{Truncate: 4}); err != nil {
+		t.Fatal(err)
+	}
+	batches, _ := readBatches(t, get("id=0&limit=all"))
+	if strings.Join(batches, "") != strings.Join(msgs[3:], "") {
+		t.Fatal(batches)
+	}
+
+	// past the end
+	resp = get("id=7&limit=all")
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatal(resp.StatusCode)
+	}
+}
+
+func TestServer_ConsumeAllErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	errMock := errors.New("disk failure")
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Return("").Times(1)
+	q.EXPECT().InspectTopic("drain").Return(nil, headers.ErrTopicDoesNotExist).Times(1)
+	q.EXPECT().InspectTopic("drain").Return(&headers.TopicInfo{MinOffset: 0, MaxOffset: 9}, nil).Times(1)
+	q.EXPECT().Consume(gomock.Any(), "drain", int64(0), int64(10), gomock.Any()).DoAndReturn(func(_ context.Context, topic string, id, limit int64, w http.ResponseWriter) (int, error) {
+		headers.SetSizes([]int64{3}, w.Header())
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write([]byte("abc"))
+		return 1, nil
+	}).Times(1)
+	q.EXPECT().Consume(gomock.Any(), "drain", int64(1), int64(9), gomock.Any()).Return(0, errMock).Times(1)
+
+	s, err := NewServer(WithQueue(q))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/topics/drain?id=0&limit=all", nil))
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatal(w.Code)
+	}
+
+	// errors after the response has started end the stream with an error part
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/topics/drain?id=0&limit=all", nil))
+	batches, parts := readBatches(t, w.Result())
+	if w.Code != http.StatusOK || len(parts) != 2 || batches[0] != "abc" {
+		t.Fatal(w.Code, batches)
+	}
+	if sizes, err := headers.ReadSizes(parts[0]); err != nil || len(sizes) != 1 || sizes[0] != 3 {
+		t.Error(sizes, err)
+	}
+	if err = headers.ReadErrors(parts[1]); err == nil || err.Error() != "disk failure" {
+		t.Error(err)
+	}
+}
+
+// readBatches reads the parts of a multipart/mixed consume response, returning their bodies and headers
+func readBatches(t *testing.T, resp *http.Response) ([]string, []http.Header) {
+	t.Helper()
+	defer resp.Body.Close()
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get(headers.ContentType))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatal(resp.Header, err)
+	}
+	var bodies []string
+	var parts []http.Header
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return bodies, parts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies, parts = append(bodies, string(b)), append(parts, http.Header(part.Header))
+	}
+}
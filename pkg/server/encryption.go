@@ -0,0 +1,777 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// KeyWrapper wraps the data keys which encrypt topics with a key encryption key (KEK), such as a key held by
+// a key management service. WrapKey returns the wrapped key and the id of the KEK which wrapped it, and
+// UnwrapKey is given the id back along with the wrapped key. The kms package implements it for AWS KMS,
+// Vault and static keys
+type KeyWrapper interface {
+	WrapKey(ctx context.Context, key []byte) (wrapped []byte, kekID string, err error)
+	UnwrapKey(ctx context.Context, wrapped []byte, kekID string) ([]byte, error)
+}
+
+// encrypted messages start with the magic and the version of the data key, followed by the nonce and the
+// AES-GCM sealed message. Messages encrypted with the key of a subject have the subject magic, and the
+// subject follows the version prefixed by its length
+const (
+	encryptedMagic = "HQE1"
+	subjectMagic   = "HQS1"
+	encryptedNonce = 12
+	encryptedTag   = 16
+	dataKeySize    = 32
+	maxSubjectSize = 255
+)
+
+// errKeyShredded is returned for messages whose key has been shredded
+var errKeyShredded = errors.New("key has been shredded")
+
+type subjectKey struct{}
+
+// WithEncryptionSubject returns a context for producing messages encrypted with the key of the subject
+// instead of the key of their topic. Shredding the subject's key makes every message produced for it
+// unreadable, in every topic
+func WithEncryptionSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectKey{}, subject)
+}
+
+// EncryptionSubject returns the subject set in the context with WithEncryptionSubject
+func EncryptionSubject(ctx context.Context) string {
+	subject, _ := ctx.Value(subjectKey{}).(string)
+	return subject
+}
+
+// WithEncryption encrypts messages at rest with AES-256-GCM, under a data key generated for each topic when
+// it is first produced to, or for each subject producers send in the X-Encryption-Subject header. The data
+// keys are stored in the file wrapped by the KEK, so they are only ever unwrapped in memory, and are managed
+// at the /keys endpoint: a topic's key can be rotated so that new messages use a new version of it, every
+// key can be rewrapped under the current KEK without rewriting any messages, and a key can be shredded so
+// that the messages it encrypted can never be read again. Messages produced before encryption was enabled
+// are consumed as they were written
+func WithEncryption(file string, kek KeyWrapper) Option {
+	return func(s *Server) error {
+		if file == "" {
+			return errors.New("a key file must be given to enable encryption")
+		}
+		if kek == nil {
+			return errors.New("key wrapper cannot be nil")
+		}
+		k := &keyStore{
+			file:     file,
+			kek:      kek,
+			topics:   make(map[string][]*dataKey),
+			subjects: make(map[string][]*dataKey),
+			aeads:    make(map[*dataKey]cipher.AEAD),
+		}
+		if err := k.load(); err != nil {
+			return err
+		}
+		s.encryption = k
+		return nil
+	}
+}
+
+// dataKey is a version of a data key as stored in the key file, the wrapped key of a shredded version is
+// removed
+type dataKey struct {
+	Version  uint32     `json:"version"`
+	Wrapped  []byte     `json:"wrapped,omitempty"`
+	KEK      string     `json:"kek"`
+	Created  time.Time  `json:"created"`
+	Shredded *time.Time `json:"shredded,omitempty"`
+}
+
+// keyName names the key of a topic, or the key of a subject if subject is set
+type keyName struct {
+	topic   string
+	subject string
+}
+
+// keyFile is the format of the key file
+type keyFile struct {
+	Topics   map[string][]*dataKey `json:"topics"`
+	Subjects map[string][]*dataKey `json:"subjects"`
+}
+
+// keyStore holds the versions of the wrapped data keys of each topic and subject, oldest version first, and
+// caches the ciphers of the keys which have been unwrapped
+type keyStore struct {
+	file     string
+	kek      KeyWrapper
+	mux      sync.RWMutex
+	topics   map[string][]*dataKey
+	subjects map[string][]*dataKey
+	aeads    map[*dataKey]cipher.AEAD
+}
+
+// versions returns the map holding the versions of the named key and the name of the key in the map
+func (k *keyStore) versions(n keyName) (map[string][]*dataKey, string) {
+	if n.subject != "" {
+		return k.subjects, n.subject
+	}
+	return k.topics, n.topic
+}
+
+// load reads the stored keys
+func (k *keyStore) load() error {
+	b, err := ioutil.ReadFile(k.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to read key file")
+	}
+	f := keyFile{Topics: k.topics, Subjects: k.subjects}
+	if err = json.Unmarshal(b, &f); err != nil {
+		return errors.Wrap(err, "unable to parse key file")
+	}
+	if f.Topics != nil {
+		k.topics = f.Topics
+	}
+	if f.Subjects != nil {
+		k.subjects = f.Subjects
+	}
+	return nil
+}
+
+// save writes the keys to the file, replacing it atomically
+func (k *keyStore) save() error {
+	b, err := json.Marshal(keyFile{Topics: k.topics, Subjects: k.subjects})
+	if err != nil {
+		return err
+	}
+	tmp := k.file + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "unable to write key file")
+	}
+	if err = os.Rename(tmp, k.file); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrap(err, "unable to replace key file")
+	}
+	return nil
+}
+
+// version returns the version of the named key, or nil if it does not exist
+func (k *keyStore) version(n keyName, version uint32) *dataKey {
+	k.mux.RLock()
+	defer k.mux.RUnlock()
+	m, name := k.versions(n)
+	for _, key := range m[name] {
+		if key.Version == version {
+			return key
+		}
+	}
+	return nil
+}
+
+// cipher returns the cipher of the key, unwrapping the key if it is not cached
+func (k *keyStore) cipher(ctx context.Context, key *dataKey) (cipher.AEAD, error) {
+	k.mux.RLock()
+	aead, ok := k.aeads[key]
+	wrapped, kekID, shredded := key.Wrapped, key.KEK, key.Shredded != nil
+	k.mux.RUnlock()
+	if ok {
+		return aead, nil
+	}
+	if shredded {
+		return nil, errKeyShredded
+	}
+	plain, err := k.kek.UnwrapKey(ctx, wrapped, kekID)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to unwrap data key")
+	}
+	aead, err = newDataCipher(plain)
+	if err != nil {
+		return nil, err
+	}
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	if key.Shredded != nil {
+		// the key was shredded while it was unwrapped
+		return nil, errKeyShredded
+	}
+	k.aeads[key] = aead
+	return aead, nil
+}
+
+func newDataCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid data key")
+	}
+	return cipher.NewGCM(block)
+}
+
+// current returns the current version of the named key and its cipher, generating a new version if there
+// is none or it has been shredded
+func (k *keyStore) current(ctx context.Context, n keyName) (*dataKey, cipher.AEAD, error) {
+	k.mux.RLock()
+	var key *dataKey
+	m, name := k.versions(n)
+	if keys := m[name]; len(keys) > 0 && keys[len(keys)-1].Shredded == nil {
+		key = keys[len(keys)-1]
+	}
+	k.mux.RUnlock()
+	if key == nil {
+		return k.add(ctx, n, true)
+	}
+	aead, err := k.cipher(ctx, key)
+	if err == errKeyShredded {
+		return k.current(ctx, n)
+	}
+	return key, aead, err
+}
+
+// add generates a new version of the named key. If first is set the key is only added if there is no
+// current version, otherwise the current version is returned
+func (k *keyStore) add(ctx context.Context, n keyName, first bool) (*dataKey, cipher.AEAD, error) {
+	plain := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, plain); err != nil {
+		return nil, nil, errors.Wrap(err, "unable to generate data key")
+	}
+	aead, err := newDataCipher(plain)
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, kekID, err := k.kek.WrapKey(ctx, plain)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to wrap data key")
+	}
+
+	k.mux.Lock()
+	m, name := k.versions(n)
+	keys := m[name]
+	if first && len(keys) > 0 && keys[len(keys)-1].Shredded == nil {
+		// another request added the key while this one was wrapped
+		k.mux.Unlock()
+		return k.current(ctx, n)
+	}
+	key := &dataKey{Version: 1, Wrapped: wrapped, KEK: kekID, Created: time.Now().UTC()}
+	if len(keys) > 0 {
+		key.Version = keys[len(keys)-1].Version + 1
+	}
+	m[name] = append(keys, key)
+	if err = k.save(); err != nil {
+		m[name] = keys
+		if len(keys) == 0 {
+			delete(m, name)
+		}
+		k.mux.Unlock()
+		return nil, nil, err
+	}
+	k.aeads[key] = aead
+	k.mux.Unlock()
+	return key, aead, nil
+}
+
+// rewrap wraps every data key again with the current KEK, returning the number of keys rewrapped. The
+// messages encrypted by the keys are unchanged
+func (k *keyStore) rewrap(ctx context.Context) (int, error) {
+	type rewrapped struct {
+		key     *dataKey
+		wrapped []byte
+		kekID   string
+	}
+	k.mux.RLock()
+	var keys []rewrapped
+	for _, m := range []map[string][]*dataKey{k.topics, k.subjects} {
+		for _, versions := range m {
+			for _, key := range versions {
+				if key.Shredded == nil {
+					keys = append(keys, rewrapped{key: key, wrapped: key.Wrapped, kekID: key.KEK})
+				}
+			}
+		}
+	}
+	k.mux.RUnlock()
+
+	updates := make([]rewrapped, 0, len(keys))
+	for _, r := range keys {
+		plain, err := k.kek.UnwrapKey(ctx, r.wrapped, r.kekID)
+		if err != nil {
+			return 0, errors.Wrap(err, "unable to unwrap data key")
+		}
+		wrapped, kekID, err := k.kek.WrapKey(ctx, plain)
+		for i := range plain {
+			plain[i] = 0
+		}
+		if err != nil {
+			return 0, errors.Wrap(err, "unable to wrap data key")
+		}
+		updates = append(updates, rewrapped{key: r.key, wrapped: wrapped, kekID: kekID})
+	}
+
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	old := make([]rewrapped, len(updates))
+	for i, u := range updates {
+		old[i] = rewrapped{key: u.key, wrapped: u.key.Wrapped, kekID: u.key.KEK}
+		if u.key.Shredded == nil {
+			u.key.Wrapped, u.key.KEK = u.wrapped, u.kekID
+		}
+	}
+	if err := k.save(); err != nil {
+		for _, o := range old {
+			o.key.Wrapped, o.key.KEK = o.wrapped, o.kekID
+		}
+		return 0, err
+	}
+	return len(updates), nil
+}
+
+// shred removes every version of the named key from the key file and memory, so that the messages they
+// encrypted can no longer be decrypted. A new version is generated if more messages are produced with it
+func (k *keyStore) shred(n keyName) error {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	m, name := k.versions(n)
+	keys := m[name]
+	if len(keys) == 0 {
+		return headers.ErrKeyDoesNotExist
+	}
+	now := time.Now().UTC()
+	var shredded []*dataKey
+	wrapped := make([][]byte, len(keys))
+	for i, key := range keys {
+		if key.Shredded == nil {
+			wrapped[i] = key.Wrapped
+			key.Wrapped, key.Shredded = nil, &now
+			shredded = append(shredded, key)
+		}
+	}
+	if err := k.save(); err != nil {
+		for i, key := range keys {
+			if wrapped[i] != nil {
+				key.Wrapped, key.Shredded = wrapped[i], nil
+			}
+		}
+		return err
+	}
+	for _, key := range shredded {
+		delete(k.aeads, key)
+	}
+	return nil
+}
+
+// describe returns the versions of the named key
+func (k *keyStore) describe(n keyName) (headers.TopicKey, error) {
+	k.mux.RLock()
+	defer k.mux.RUnlock()
+	m, name := k.versions(n)
+	keys := m[name]
+	if len(keys) == 0 {
+		return headers.TopicKey{}, headers.ErrKeyDoesNotExist
+	}
+	tk := headers.TopicKey{Topic: n.topic, Subject: n.subject, Current: keys[len(keys)-1].Version, Versions: make([]headers.DataKeyVersion, len(keys))}
+	for i, key := range keys {
+		tk.Versions[i] = headers.DataKeyVersion{Version: key.Version, KEK: key.KEK, Created: key.Created, Shredded: key.Shredded}
+	}
+	return tk, nil
+}
+
+// list returns the keys of every topic sorted by topic, followed by the keys of every subject sorted by
+// subject
+func (k *keyStore) list() []headers.TopicKey {
+	k.mux.RLock()
+	names := make([]keyName, 0, len(k.topics)+len(k.subjects))
+	for topic := range k.topics {
+		names = append(names, keyName{topic: topic})
+	}
+	for subject := range k.subjects {
+		names = append(names, keyName{subject: subject})
+	}
+	k.mux.RUnlock()
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].subject != names[j].subject && (names[i].subject == "" || names[j].subject == "") {
+			return names[i].subject == ""
+		}
+		return names[i].topic+names[i].subject < names[j].topic+names[j].subject
+	})
+	keys := make([]headers.TopicKey, 0, len(names))
+	for _, n := range names {
+		if tk, err := k.describe(n); err == nil {
+			keys = append(keys, tk)
+		}
+	}
+	return keys
+}
+
+// encryptedAD is the additional data authenticated with a message, binding it to its topic and key version
+func encryptedAD(header []byte, topic string) []byte {
+	ad := make([]byte, 0, len(header)+len(topic))
+	return append(append(ad, header...), topic...)
+}
+
+// encryptedHeader returns the header of messages encrypted with the version of the topic's key, or of the
+// subject's key if the subject is set
+func encryptedHeader(version uint32, subject string) []byte {
+	if subject == "" {
+		header := make([]byte, len(encryptedMagic)+4)
+		copy(header, encryptedMagic)
+		binary.BigEndian.PutUint32(header[len(encryptedMagic):], version)
+		return header
+	}
+	header := make([]byte, len(subjectMagic)+5+len(subject))
+	copy(header, subjectMagic)
+	binary.BigEndian.PutUint32(header[len(subjectMagic):], version)
+	header[len(subjectMagic)+4] = byte(len(subject))
+	copy(header[len(subjectMagic)+5:], subject)
+	return header
+}
+
+// parseEncryptedHeader returns the name and version of the key which encrypted the message and the length
+// of its header. It returns false if the message was not encrypted
+func parseEncryptedHeader(topic string, msg []byte) (keyName, uint32, int, bool) {
+	if len(msg) < len(encryptedMagic)+4+encryptedNonce+encryptedTag {
+		return keyName{}, 0, 0, false
+	}
+	version := binary.BigEndian.Uint32(msg[len(encryptedMagic):])
+	switch string(msg[:len(encryptedMagic)]) {
+	case encryptedMagic:
+		return keyName{topic: topic}, version, len(encryptedMagic) + 4, true
+	case subjectMagic:
+		n := len(subjectMagic) + 5 + int(msg[len(subjectMagic)+4])
+		if len(msg) < n+encryptedNonce+encryptedTag {
+			return keyName{}, 0, 0, false
+		}
+		return keyName{subject: string(msg[len(subjectMagic)+5 : n])}, version, n, true
+	}
+	return keyName{}, 0, 0, false
+}
+
+// sealMessage encrypts the message of the topic, starting it with the header of the key version
+func sealMessage(aead cipher.AEAD, header []byte, topic string, msg []byte) ([]byte, error) {
+	out := make([]byte, len(header)+encryptedNonce, len(header)+encryptedNonce+len(msg)+encryptedTag)
+	copy(out, header)
+	if _, err := io.ReadFull(rand.Reader, out[len(header):]); err != nil {
+		return nil, errors.Wrap(err, "unable to generate nonce")
+	}
+	return aead.Seal(out, out[len(header):], msg, encryptedAD(header, topic)), nil
+}
+
+// openMessage decrypts a message of the topic, messages which were not encrypted are returned unchanged
+func (k *keyStore) openMessage(ctx context.Context, topic string, msg []byte) ([]byte, error) {
+	n, version, size, ok := parseEncryptedHeader(topic, msg)
+	if !ok {
+		return msg, nil
+	}
+	key := k.version(n, version)
+	if key == nil {
+		if n.subject != "" {
+			return nil, errors.Wrapf(headers.ErrKeyDoesNotExist, "version %d of the key of subject %s", version, n.subject)
+		}
+		return nil, errors.Wrapf(headers.ErrKeyDoesNotExist, "version %d of the key of topic %s", version, topic)
+	}
+	aead, err := k.cipher(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, msg[size:size+encryptedNonce], msg[size+encryptedNonce:], encryptedAD(msg[:size], topic))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decrypt message")
+	}
+	return plain, nil
+}
+
+// sealReader encrypts each message read from r. The key is only fetched on the first read, so that a batch
+// the queue rejects before reading does not generate a key
+type sealReader struct {
+	ctx    context.Context
+	keys   *keyStore
+	topic  string
+	name   keyName
+	r      io.Reader
+	sizes  []int64
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+}
+
+func (sr *sealReader) Read(p []byte) (int, error) {
+	for len(sr.buf) == 0 {
+		if len(sr.sizes) == 0 {
+			return 0, io.EOF
+		}
+		if sr.aead == nil {
+			key, aead, err := sr.keys.current(sr.ctx, sr.name)
+			if err != nil {
+				return 0, err
+			}
+			sr.aead, sr.header = aead, encryptedHeader(key.Version, sr.name.subject)
+		}
+		msg := make([]byte, sr.sizes[0])
+		if _, err := io.ReadFull(sr.r, msg); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		sealed, err := sealMessage(sr.aead, sr.header, sr.topic, msg)
+		if err != nil {
+			return 0, err
+		}
+		sr.sizes, sr.buf = sr.sizes[1:], sealed
+	}
+	n := copy(p, sr.buf)
+	sr.buf = sr.buf[n:]
+	return n, nil
+}
+
+// sealBatch returns the sizes and body of the batch encrypted with the topic's key, or with the key of the
+// subject in the context
+func (s *Server) sealBatch(ctx context.Context, topic string, sizes []int64, r io.Reader) ([]int64, io.Reader) {
+	name := keyName{topic: topic, subject: EncryptionSubject(ctx)}
+	overhead := int64(len(encryptedHeader(0, name.subject)) + encryptedNonce + encryptedTag)
+	sealed := make([]int64, len(sizes))
+	for i := range sizes {
+		sealed[i] = sizes[i] + overhead
+	}
+	return sealed, &sealReader{ctx: ctx, keys: s.encryption, topic: topic, name: name, r: r, sizes: sizes}
+}
+
+// encryptionSubject returns the request's context with the subject of its X-Encryption-Subject header
+func (s *Server) encryptionSubject(r *http.Request) (context.Context, error) {
+	subject := r.Header.Get(headers.HeaderEncryptionSubject)
+	switch {
+	case subject == "":
+		return r.Context(), nil
+	case s.encryption == nil:
+		return nil, errors.Wrap(headers.ErrInvalidSubject, "encryption is not enabled")
+	case len(subject) > maxSubjectSize:
+		return nil, errors.Wrap(headers.ErrInvalidSubject, "subject is too long")
+	}
+	return WithEncryptionSubject(r.Context(), subject), nil
+}
+
+// openWriter collects a consumed batch and writes it decrypted once the consume is complete, as the sizes
+// of the decrypted messages are only known once every message has been read
+type openWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *openWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *openWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.buf.Write(b)
+}
+
+// finish decrypts the collected batch of the topic and writes it to the underlying writer. Messages whose
+// key has been shredded are sent empty
+func (w *openWriter) finish(ctx context.Context, keys *keyStore, topic string) error {
+	if w.status == 0 {
+		return nil
+	}
+	h := w.Header()
+	if w.status != http.StatusOK && w.status != http.StatusPartialContent {
+		w.ResponseWriter.WriteHeader(w.status)
+		_, err := w.ResponseWriter.Write(w.buf.Bytes())
+		return err
+	}
+
+	sizes, err := headers.ReadSizes(h)
+	if err != nil {
+		return err
+	}
+	body := w.buf.Bytes()
+	out := make([]byte, 0, len(body))
+	for i, size := range sizes {
+		if int64(len(body)) < size {
+			err = errors.New("consumed batch is shorter than its sizes")
+			break
+		}
+		var msg []byte
+		if msg, err = keys.openMessage(ctx, topic, body[:size]); err == errKeyShredded {
+			msg, err = nil, nil
+		}
+		if err != nil {
+			break
+		}
+		body = body[size:]
+		sizes[i] = int64(len(msg))
+		out = append(out, msg...)
+	}
+	if err != nil {
+		delete(h, headers.HeaderSizes)
+		delete(h, "Content-Range")
+		delete(h, "Content-Length")
+		return err
+	}
+
+	headers.SetSizes(sizes, h)
+	total := strconv.Itoa(len(out))
+	h["Content-Length"] = []string{total}
+	delete(h, "Content-Range")
+	if w.status == http.StatusPartialContent && len(out) > 0 {
+		h["Content-Range"] = []string{"bytes 0-" + strconv.Itoa(len(out)-1) + "/" + total}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err = w.ResponseWriter.Write(out)
+	return err
+}
+
+// TopicKeys returns the versions of the data key of every topic
+func (s *Server) TopicKeys(ctx context.Context) ([]headers.TopicKey, error) {
+	if s.encryption == nil {
+		return nil, errors.New("encryption is not enabled")
+	}
+	return s.encryption.list(), nil
+}
+
+// TopicKey returns the versions of the topic's data key
+func (s *Server) TopicKey(ctx context.Context, topic string) (headers.TopicKey, error) {
+	if s.encryption == nil {
+		return headers.TopicKey{}, errors.New("encryption is not enabled")
+	}
+	return s.encryption.describe(keyName{topic: topic})
+}
+
+// SubjectKey returns the versions of the subject's data key
+func (s *Server) SubjectKey(ctx context.Context, subject string) (headers.TopicKey, error) {
+	if s.encryption == nil {
+		return headers.TopicKey{}, errors.New("encryption is not enabled")
+	}
+	return s.encryption.describe(keyName{subject: subject})
+}
+
+// RotateTopicKey adds a new version of the topic's data key, which encrypts the messages produced from then
+// on. Existing messages keep the version they were encrypted with
+func (s *Server) RotateTopicKey(ctx context.Context, topic string) (headers.TopicKey, error) {
+	if s.encryption == nil {
+		return headers.TopicKey{}, errors.New("encryption is not enabled")
+	}
+	key, _, err := s.encryption.add(ctx, keyName{topic: topic}, false)
+	if err != nil {
+		return headers.TopicKey{}, err
+	}
+	s.logger.Info("topic key rotated", "topic", topic, "version", key.Version, "kek", key.KEK)
+	return s.encryption.describe(keyName{topic: topic})
+}
+
+// ShredTopicKey destroys every version of the topic's data key, so that the messages of the topic which
+// were encrypted with it can never be read again. They are consumed as empty messages. Messages produced
+// afterwards are encrypted with a new version of the key
+func (s *Server) ShredTopicKey(ctx context.Context, topic string) (headers.TopicKey, error) {
+	return s.shred(keyName{topic: topic})
+}
+
+// ShredSubjectKey destroys every version of the subject's data key, so that the messages produced for the
+// subject can never be read again in any topic. They are consumed as empty messages. This erases a
+// subject's data, such as a user's, from an append only log
+func (s *Server) ShredSubjectKey(ctx context.Context, subject string) (headers.TopicKey, error) {
+	return s.shred(keyName{subject: subject})
+}
+
+func (s *Server) shred(n keyName) (headers.TopicKey, error) {
+	if s.encryption == nil {
+		return headers.TopicKey{}, errors.New("encryption is not enabled")
+	}
+	if err := s.encryption.shred(n); err != nil {
+		return headers.TopicKey{}, err
+	}
+	s.logger.Warn("key shredded", "topic", n.topic, "subject", n.subject)
+	return s.encryption.describe(n)
+}
+
+// RewrapKeys wraps every data key again with the current KEK, so that an old KEK can be retired without
+// rewriting any messages. It returns the number of keys rewrapped
+func (s *Server) RewrapKeys(ctx context.Context) (int, error) {
+	if s.encryption == nil {
+		return 0, errors.New("encryption is not enabled")
+	}
+	n, err := s.encryption.rewrap(ctx)
+	if err != nil {
+		return 0, err
+	}
+	s.logger.Info("topic keys rewrapped", "keys", n)
+	return n, nil
+}
+
+// HandleKeys handles requests to the /keys endpoints. GET /keys lists the data keys of every topic and
+// subject and GET /keys/{topic} returns the key of a topic. POST /keys/{topic} rotates the key of a topic
+// and POST /keys rewraps every key with the current KEK. DELETE /keys/{topic} shreds the key of a topic.
+// The key of a subject is returned and shredded with GET and DELETE /keys?subject={subject}
+func (s *Server) HandleKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/keys"), "/")
+	subject := r.URL.Query().Get("subject")
+	var topic string
+	if name != "" {
+		var err error
+		if topic, err = cleanTopic(name); err != nil {
+			headers.SetError(w, err)
+			return
+		}
+	}
+
+	respond := func(key headers.TopicKey, err error) {
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		writeSchemaJSON(w, http.StatusOK, key)
+	}
+
+	switch {
+	case subject != "" && topic != "":
+		w.WriteHeader(http.StatusBadRequest)
+	case r.Method == http.MethodGet && subject != "":
+		respond(s.SubjectKey(r.Context(), subject))
+	case r.Method == http.MethodDelete && subject != "":
+		respond(s.ShredSubjectKey(r.Context(), subject))
+	case subject != "":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	case r.Method == http.MethodGet && topic == "":
+		keys, err := s.TopicKeys(r.Context())
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		writeSchemaJSON(w, http.StatusOK, map[string][]headers.TopicKey{"keys": keys})
+	case r.Method == http.MethodGet:
+		respond(s.TopicKey(r.Context(), topic))
+	case r.Method == http.MethodPost && topic == "":
+		n, err := s.RewrapKeys(r.Context())
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		writeSchemaJSON(w, http.StatusOK, map[string]int{"rewrapped": n})
+	case r.Method == http.MethodPost:
+		respond(s.RotateTopicKey(r.Context(), topic))
+	case r.Method == http.MethodDelete && topic != "":
+		respond(s.ShredTopicKey(r.Context(), topic))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
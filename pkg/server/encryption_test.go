@@ -0,0 +1,298 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// testKEK wraps keys by xoring them with the byte of the KEK id, which is enough to tell which KEK wrapped
+// a key
+type testKEK struct {
+	current string
+	fail    bool
+}
+
+func (k *testKEK) WrapKey(ctx context.Context, key []byte) ([]byte, string, error) {
+	if k.fail {
+		return nil, "", errors.New("kms unavailable")
+	}
+	return xorKey(key, k.current), k.current, nil
+}
+
+func (k *testKEK) UnwrapKey(ctx context.Context, wrapped []byte, kekID string) ([]byte, error) {
+	if k.fail {
+		return nil, errors.New("kms unavailable")
+	}
+	return xorKey(wrapped, kekID), nil
+}
+
+func xorKey(key []byte, id string) []byte {
+	out := make([]byte, len(key))
+	for i := range key {
+		out[i] = key[i] ^ id[0]
+	}
+	return out
+}
+
+func TestWithEncryption(t *testing.T) {
+	s := &Server{}
+	if err := WithEncryption("", &testKEK{current: "a"})(s); err == nil {
+		t.Fatal("expected missing file error")
+	}
+	if err := WithEncryption(".haraqa-keys.json", nil)(s); err == nil {
+		t.Fatal("expected missing kek error")
+	}
+	file := ".haraqa-keys-invalid.json"
+	defer os.Remove(file)
+	if err := ioutil.WriteFile(file, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WithEncryption(file, &testKEK{current: "a"})(s); err == nil {
+		t.Fatal("expected invalid file error")
+	}
+}
+
+func TestServer_Encryption(t *testing.T) {
+	dir, file := ".haraqa-encryption", ".haraqa-encryption-keys.json"
+	defer os.RemoveAll(dir)
+	defer os.Remove(file)
+	ctx := context.Background()
+
+	// messages produced before encryption is enabled stay readable
+	s, err := NewServer(WithFileQueue([]string{dir}, false, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.q.CreateTopic("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.produce(ctx, "orders", []int64{5}, strings.NewReader("plain"), false); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Close()
+
+	kek := &testKEK{current: "a"}
+	s, err = NewServer(WithFileQueue([]string{dir}, false, 5000), WithEncryption(file, kek))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.produce(ctx, "orders", []int64{6, 5}, strings.NewReader("secret12345"), false); err != nil {
+		t.Fatal(err)
+	}
+	// a missing topic does not get a key
+	if _, err = s.produce(ctx, "missing", []int64{1}, strings.NewReader("x"), false); errors.Cause(err) != headers.ErrTopicDoesNotExist {
+		t.Fatal(err)
+	}
+	if _, err = s.TopicKey(ctx, "missing"); err != headers.ErrKeyDoesNotExist {
+		t.Fatal(err)
+	}
+
+	// messages are encrypted in the queue
+	raw := &bufferWriter{header: make(http.Header)}
+	if _, err = s.q.Consume(ctx, "orders", 1, 2, raw); err != nil {
+		t.Fatal(err)
+	}
+	if sizes, _ := headers.ReadSizes(raw.header); len(sizes) != 2 || sizes[0] != int64(6+len(encryptedHeader(1, ""))+encryptedNonce+encryptedTag) {
+		t.Fatal(sizes)
+	}
+	if bytes.Contains(raw.buf.Bytes(), []byte("secret")) {
+		t.Fatal("message was not encrypted")
+	}
+
+	consume := func(query, rng string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/topics/orders?"+query, nil)
+		if rng != "" {
+			r.Header.Set("Range", rng)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+	w := consume("id=0&limit=3", "")
+	sizes, _ := headers.ReadSizes(w.Header())
+	if w.Code != http.StatusPartialContent || w.Body.String() != "plainsecret12345" || len(sizes) != 3 || sizes[1] != 6 ||
+		w.Header().Get("Content-Length") != "16" || w.Header().Get("Content-Range") != "bytes 0-15/16" {
+		t.Fatal(w.Code, w.Header(), w.Body.String())
+	}
+	if w = consume("id=0&limit=3", "bytes=8-"); w.Code != http.StatusPartialContent || w.Body.String() != "ret12345" {
+		t.Fatal(w.Code, w.Header(), w.Body.String())
+	}
+
+	// rotating the key encrypts new messages with the new version
+	key, err := s.RotateTopicKey(ctx, "orders")
+	if err != nil || key.Current != 2 || len(key.Versions) != 2 {
+		t.Fatal(key, err)
+	}
+	if _, err = s.produce(ctx, "orders", []int64{3}, strings.NewReader("new"), false); err != nil {
+		t.Fatal(err)
+	}
+	if w = consume("id=0&limit=4", ""); w.Body.String() != "plainsecret12345new" {
+		t.Fatal(w.Code, w.Header(), w.Body.String())
+	}
+
+	// rewrapping changes the KEK of every key without changing the messages
+	kek.current = "b"
+	if n, err := s.RewrapKeys(ctx); err != nil || n != 2 {
+		t.Fatal(n, err)
+	}
+	kek.fail = true
+	if _, err = s.RewrapKeys(ctx); err == nil {
+		t.Fatal("expected kms error")
+	}
+	kek.fail = false
+	_ = s.Close()
+
+	s, err = NewServer(WithFileQueue([]string{dir}, false, 5000), WithEncryption(file, kek))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := s.TopicKeys(ctx)
+	if err != nil || len(keys) != 1 || keys[0].Versions[0].KEK != "b" || keys[0].Versions[1].KEK != "b" {
+		t.Fatal(keys, err)
+	}
+	if w = consume("id=0&limit=4", ""); w.Body.String() != "plainsecret12345new" {
+		t.Fatal(w.Code, w.Header(), w.Body.String())
+	}
+
+	// messages produced for a subject are encrypted with the subject's key
+	if _, err = s.produce(WithEncryptionSubject(ctx, "user-1"), "orders", []int64{4}, strings.NewReader("mine"), false); err != nil {
+		t.Fatal(err)
+	}
+	if key, err = s.SubjectKey(ctx, "user-1"); err != nil || key.Subject != "user-1" || key.Current != 1 {
+		t.Fatal(key, err)
+	}
+	if w = consume("id=0&limit=5", ""); w.Body.String() != "plainsecret12345newmine" {
+		t.Fatal(w.Code, w.Header(), w.Body.String())
+	}
+
+	// shredded messages are consumed empty, new messages get a new version of the key
+	if key, err = s.ShredSubjectKey(ctx, "user-1"); err != nil || key.Versions[0].Shredded == nil {
+		t.Fatal(key, err)
+	}
+	if _, err = s.ShredSubjectKey(ctx, "user-2"); err != headers.ErrKeyDoesNotExist {
+		t.Fatal(err)
+	}
+	if _, err = s.produce(WithEncryptionSubject(ctx, "user-1"), "orders", []int64{5}, strings.NewReader("again"), false); err != nil {
+		t.Fatal(err)
+	}
+	w = consume("id=0&limit=6", "")
+	sizes, _ = headers.ReadSizes(w.Header())
+	if w.Body.String() != "plainsecret12345newagain" || len(sizes) != 6 || sizes[4] != 0 {
+		t.Fatal(w.Code, sizes, w.Body.String())
+	}
+	if key, err = s.SubjectKey(ctx, "user-1"); err != nil || key.Current != 2 || key.Versions[1].Shredded != nil {
+		t.Fatal(key, err)
+	}
+	if key, err = s.ShredTopicKey(ctx, "orders"); err != nil || len(key.Versions) != 2 {
+		t.Fatal(key, err)
+	}
+	_ = s.Close()
+
+	// shredding is persisted, the wrapped keys are removed from the file
+	s, err = NewServer(WithFileQueue([]string{dir}, false, 5000), WithEncryption(file, kek))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if b, err := ioutil.ReadFile(file); err != nil || bytes.Count(b, []byte(`"wrapped"`)) != 1 {
+		t.Fatal(string(b), err)
+	}
+	if w = consume("id=0&limit=6", ""); w.Body.String() != "plainagain" {
+		t.Fatal(w.Code, w.Header(), w.Body.String())
+	}
+
+	// a message whose key version is missing cannot be read
+	s.encryption.topics["orders"] = s.encryption.topics["orders"][:1]
+	if w = consume("id=3&limit=1", ""); w.Code != http.StatusNotFound || headers.ReadErrors(w.Header()) != headers.ErrKeyDoesNotExist {
+		t.Fatal(w.Code, w.Header())
+	}
+}
+
+func TestServer_HandleKeys(t *testing.T) {
+	dir, file := ".haraqa-keys", ".haraqa-keys.json"
+	defer os.RemoveAll(dir)
+	defer os.Remove(file)
+	s, err := NewServer(WithFileQueue([]string{dir}, false, 5000), WithEncryption(file, &testKEK{current: "a"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+	if w := request(http.MethodGet, "/keys/orders"); w.Code != http.StatusNotFound {
+		t.Fatal(w.Code, w.Header())
+	}
+	w := request(http.MethodPost, "/keys/Orders")
+	var key headers.TopicKey
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &key) != nil || key.Topic != "orders" || key.Current != 1 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if w = request(http.MethodGet, "/keys/orders"); w.Code != http.StatusOK {
+		t.Fatal(w.Code, w.Header())
+	}
+	var keys struct {
+		Keys []headers.TopicKey `json:"keys"`
+	}
+	if w = request(http.MethodGet, "/keys"); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &keys) != nil || len(keys.Keys) != 1 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if w = request(http.MethodPost, "/keys"); w.Code != http.StatusOK || w.Body.String() != "{\"rewrapped\":1}\n" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if w = request(http.MethodDelete, "/keys"); w.Code != http.StatusMethodNotAllowed {
+		t.Fatal(w.Code)
+	}
+
+	// subjects are set by producers and their keys shredded by subject
+	if err = s.q.CreateTopic("orders"); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/topics/orders", strings.NewReader("hello"))
+	r.Header.Set(headers.HeaderSizes, "5")
+	r.Header.Set(headers.HeaderEncryptionSubject, strings.Repeat("x", maxSubjectSize+1))
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest || headers.ReadErrors(w.Header()) != headers.ErrInvalidSubject {
+		t.Fatal(w.Code, w.Header())
+	}
+	r = httptest.NewRequest(http.MethodPost, "/topics/orders", strings.NewReader("hello"))
+	r.Header.Set(headers.HeaderSizes, "5")
+	r.Header.Set(headers.HeaderEncryptionSubject, "user 1")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = request(http.MethodGet, "/keys?subject=user+1"); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &key) != nil || key.Subject != "user 1" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if w = request(http.MethodPost, "/keys?subject=user+1"); w.Code != http.StatusMethodNotAllowed {
+		t.Fatal(w.Code)
+	}
+	if w = request(http.MethodDelete, "/keys/orders?subject=user+1"); w.Code != http.StatusBadRequest {
+		t.Fatal(w.Code)
+	}
+	if w = request(http.MethodDelete, "/keys?subject=user+1"); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &key) != nil || key.Versions[0].Shredded == nil {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if w = request(http.MethodDelete, "/keys/orders"); w.Code != http.StatusOK {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if w = request(http.MethodGet, "/keys"); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &keys) != nil || len(keys.Keys) != 2 || keys.Keys[1].Subject != "user 1" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/haraqa/haraqa/internal/envelope"
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// ContentTypeProtobufBatch is the media type of the protobuf batch envelope, see internal/envelope/envelope.proto
+const ContentTypeProtobufBatch = envelope.ContentType
+
+// readEnvelope reads a produce request sent as a protobuf batch envelope, returning the messages and their
+// content types. Messages with a key or headers are stored as records. If the request is not an envelope
+// ok is false and the body is left unread
+func readEnvelope(r *http.Request) (msgs [][]byte, types []string, ok bool, err error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get(headers.ContentType))
+	if mediaType != ContentTypeProtobufBatch {
+		return nil, nil, false, nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, true, err
+	}
+	batch, err := envelope.Unmarshal(body)
+	if err != nil {
+		return nil, nil, true, errors.Wrap(headers.ErrInvalidEnvelope, err.Error())
+	}
+	if len(batch) == 0 {
+		return nil, nil, true, headers.ErrInvalidBodyMissing
+	}
+
+	msgs = make([][]byte, len(batch))
+	types = make([]string, len(batch))
+	for i := range batch {
+		if batch[i].ContentType, err = headers.ParseContentType(batch[i].ContentType); err != nil {
+			return nil, nil, true, errors.Wrapf(err, "message %d", i)
+		}
+		if batch[i].IsRecord() {
+			msgs[i], types[i] = envelope.Record(&batch[i]), envelope.RecordContentType
+			continue
+		}
+		msgs[i], types[i] = batch[i].Data, batch[i].ContentType
+	}
+	typed := false
+	for i := range types {
+		typed = typed || types[i] != ""
+	}
+	if !typed {
+		// queues which do not store content types accept batches without any
+		types = nil
+	}
+	return msgs, types, true, nil
+}
+
+// acceptsEnvelope returns true if the consumer asked for a protobuf batch envelope in its Accept header
+func acceptsEnvelope(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(accept)); mediaType == ContentTypeProtobufBatch {
+			return true
+		}
+	}
+	return false
+}
+
+// consumeEnvelope writes up to limit consumed messages as a protobuf batch envelope, with the topic,
+// offset, timestamp, id and content type of each message. Records are decoded back into their message
+func (s *Server) consumeEnvelope(w http.ResponseWriter, r *http.Request, topic string, id, limit int64) {
+	body := new(bytes.Buffer)
+	sw := &streamWriter{w: body, header: make(http.Header)}
+	count, err := s.consume(r.Context(), topic, id, limit, sw)
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+	if count == 0 {
+		headers.SetError(w, headers.ErrNoContent)
+		return
+	}
+	sizes, err := sw.sizes(count)
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+	timestamps, _ := headers.ReadTimestamps(sw.header)
+	ids, _ := headers.ReadMessageIDs(sw.header)
+	types, _ := headers.ReadContentTypes(sw.header)
+	start := id
+	if start < 0 {
+		// negative ids consume the latest message
+		if info, err := s.InspectTopic(r.Context(), topic); err == nil {
+			start = info.MaxOffset - int64(count) + 1
+		}
+	}
+
+	msgs := make([]envelope.Message, count)
+	data := body.Bytes()
+	for i := range msgs {
+		msg := &msgs[i]
+		msg.Data, data = data[:sizes[i]:sizes[i]], data[sizes[i]:]
+		if len(types) == count {
+			msg.ContentType = types[i]
+		}
+		if msg.ContentType == envelope.RecordContentType {
+			if record, err := envelope.FromRecord(msg.Data); err == nil {
+				*msg = record
+			}
+		}
+		msg.Topic, msg.Offset = topic, start+int64(i)
+		if len(timestamps) == count {
+			msg.Timestamp = timestamps[i]
+		}
+		if len(ids) == count && !ids[i].IsZero() {
+			msg.ID = ids[i].String()
+		}
+	}
+
+	w.Header()[headers.ContentType] = []string{ContentTypeProtobufBatch}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(envelope.Marshal(msgs))
+	s.commitGroup(r.Header.Get(headers.HeaderGroup), topic, id, count)
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/envelope"
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestServer_Envelope(t *testing.T) {
+	dir := ".haraqa-envelope"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithMessageIDs(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.CreateTopic(context.Background(), "envelope"); err != nil {
+		t.Fatal(err)
+	}
+
+	produce := func(body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/topics/envelope", bytes.NewReader(body))
+		r.Header.Set(headers.ContentType, ContentTypeProtobufBatch)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+	w := produce(envelope.Marshal([]envelope.Message{
+		{Data: []byte("hello"), ContentType: "text/plain"},
+		{Data: []byte("world"), Key: []byte("key"), Headers: map[string]string{"source": "test"}},
+	}))
+	if w.Code != http.StatusNoContent || len(w.Header()[headers.HeaderMessageIDs]) != 2 {
+		t.Fatal(w.Code, w.Header())
+	}
+	for body, want := range map[string]error{
+		"":         headers.ErrInvalidBodyMissing,
+		"\x0a\x05": headers.ErrInvalidEnvelope,
+		string(envelope.Marshal([]envelope.Message{{Data: []byte("a"), ContentType: "text"}})): headers.ErrInvalidContentType,
+	} {
+		if w = produce([]byte(body)); w.Code != http.StatusBadRequest || headers.ReadErrors(w.Header()) != want {
+			t.Fatalf("%q %d %v", body, w.Code, w.Header())
+		}
+	}
+
+	// consumers accepting the envelope get the messages with their metadata
+	r := httptest.NewRequest(http.MethodGet, "/topics/envelope?id=0", nil)
+	r.Header.Set("Accept", "application/json, "+ContentTypeProtobufBatch)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get(headers.ContentType) != ContentTypeProtobufBatch {
+		t.Fatal(w.Code, w.Header())
+	}
+	msgs, err := envelope.Unmarshal(w.Body.Bytes())
+	if err != nil || len(msgs) != 2 {
+		t.Fatal(msgs, err)
+	}
+	if string(msgs[0].Data) != "hello" || msgs[0].ContentType != "text/plain" || msgs[0].Topic != "envelope" || msgs[0].Offset != 0 || msgs[0].ID == "" || msgs[0].Timestamp.IsZero() {
+		t.Fatal(msgs[0])
+	}
+	if string(msgs[1].Data) != "world" || string(msgs[1].Key) != "key" || msgs[1].Headers["source"] != "test" || msgs[1].Offset != 1 || msgs[1].ID == "" {
+		t.Fatal(msgs[1])
+	}
+
+	// the latest message is consumed with its offset
+	r = httptest.NewRequest(http.MethodGet, "/topics/envelope?id=-1", nil)
+	r.Header.Set("Accept", ContentTypeProtobufBatch)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if msgs, err = envelope.Unmarshal(w.Body.Bytes()); err != nil || len(msgs) != 1 || msgs[0].Offset != 1 {
+		t.Fatal(msgs, err)
+	}
+
+	// plain consumers get the record of messages with keys or headers
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/topics/envelope?id=0", nil))
+	types, err := headers.ReadContentTypes(w.Header())
+	if err != nil || len(types) != 2 || types[1] != envelope.RecordContentType {
+		t.Fatal(types, err)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/topics/envelope?id=2", nil)
+	r.Header.Set("Accept", ContentTypeProtobufBatch)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatal(w.Code)
+	}
+}
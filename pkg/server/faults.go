@@ -0,0 +1,250 @@
+package server
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// errInjectedFault is returned to clients whose request failed with an injected error
+var errInjectedFault = errors.New("injected fault")
+
+// faultOperations are the operations faults can be injected into
+var faultOperations = []string{"produce", "consume", "inspect", "create", "delete", "modify", "list", "watch", "count", "groups", "other"}
+
+// Fault injects failures into the requests of an operation, one of produce, consume, inspect, create, delete,
+// modify, list, watch, count, groups or other, or every operation if it is empty or "*". Each rate is the fraction of
+// requests, from 0 to 1, the failure is injected into. Delayed requests wait for the latency before they are
+// handled, failed requests return the error, an internal error if it is nil, without being handled and
+// partial requests are handled but their response is cut off halfway through its first write, or entirely
+// if it has no body, so that clients cannot tell whether a produce was written
+type Fault struct {
+	Operation   string
+	Latency     time.Duration
+	LatencyRate float64
+	ErrorRate   float64
+	Error       error
+	PartialRate float64
+}
+
+// WithFaultInjection injects latency, errors and partial responses into requests, so that the retry logic
+// of clients can be tested against a real server. It is meant for test environments only and must never be
+// enabled in production. Every fault matching the operation of a request is applied in order, and the seed
+// makes the faults injected into a sequence of requests repeatable. Injected faults are listed in the
+// X-Injected-Fault header of the response
+func WithFaultInjection(seed int64, faults ...Fault) Option {
+	return func(s *Server) error {
+		for _, fault := range faults {
+			if err := checkFault(fault); err != nil {
+				return err
+			}
+		}
+		if len(faults) == 0 {
+			s.faults = nil
+			return nil
+		}
+		s.faults = &faultInjector{rand: rand.New(rand.NewSource(seed)), faults: faults}
+		return nil
+	}
+}
+
+// checkFault returns an error if the fault's operation is unknown or its rates or latency are out of range
+func checkFault(fault Fault) error {
+	if fault.Operation != "" && fault.Operation != "*" && !isFaultOperation(fault.Operation) {
+		return errors.Errorf("invalid fault operation %q", fault.Operation)
+	}
+	for _, rate := range []float64{fault.LatencyRate, fault.ErrorRate, fault.PartialRate} {
+		if rate < 0 || rate > 1 {
+			return errors.Errorf("invalid fault rate %v, value must be between 0 and 1", rate)
+		}
+	}
+	if fault.Latency < 0 {
+		return errors.New("invalid fault latency, value must not be negative")
+	}
+	return nil
+}
+
+func isFaultOperation(operation string) bool {
+	for _, op := range faultOperations {
+		if op == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFaults parses a semicolon separated list of faults of the form operation:kind=value,..., where the
+// kinds are error=rate, partial=rate and latency=duration@rate, the rate of a latency defaulting to 1. For
+// example "produce:error=0.1,partial=0.05;*:latency=200ms@0.5"
+func ParseFaults(spec string) ([]Fault, error) {
+	var faults []Fault
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.Index(entry, ":")
+		if i < 0 {
+			return nil, errors.Errorf("invalid fault %q, expected operation:kind=value", entry)
+		}
+		fault := Fault{Operation: strings.TrimSpace(entry[:i])}
+		for _, kv := range strings.Split(entry[i+1:], ",") {
+			parts := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+			if len(parts) != 2 {
+				return nil, errors.Errorf("invalid fault %q, expected operation:kind=value", entry)
+			}
+			var err error
+			switch parts[0] {
+			case "error":
+				fault.ErrorRate, err = strconv.ParseFloat(parts[1], 64)
+			case "partial":
+				fault.PartialRate, err = strconv.ParseFloat(parts[1], 64)
+			case "latency":
+				latency, rate := parts[1], "1"
+				if j := strings.Index(latency, "@"); j >= 0 {
+					latency, rate = latency[:j], latency[j+1:]
+				}
+				if fault.Latency, err = time.ParseDuration(latency); err == nil {
+					fault.LatencyRate, err = strconv.ParseFloat(rate, 64)
+				}
+			default:
+				return nil, errors.Errorf("invalid fault kind %q, expected one of error, partial or latency", parts[0])
+			}
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid fault %q", entry)
+			}
+		}
+		if err := checkFault(fault); err != nil {
+			return nil, err
+		}
+		faults = append(faults, fault)
+	}
+	return faults, nil
+}
+
+// faultInjector decides which requests faults are injected into
+type faultInjector struct {
+	mux    sync.Mutex
+	rand   *rand.Rand
+	faults []Fault
+}
+
+// roll returns true for the given fraction of calls
+func (f *faultInjector) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.rand.Float64() < rate
+}
+
+// requestOperation returns the operation of the request faults are matched against
+func requestOperation(r *http.Request) string {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/topics"):
+		if len(r.URL.Path) <= len("/topics/") {
+			return "list"
+		}
+		if isWatch(r) {
+			return "watch"
+		}
+		if isCount(r) {
+			return "count"
+		}
+		switch r.Method {
+		case http.MethodGet:
+			return "consume"
+		case http.MethodHead:
+			return "inspect"
+		case http.MethodPost:
+			return "produce"
+		case http.MethodPut:
+			return "create"
+		case http.MethodDelete:
+			return "delete"
+		case http.MethodPatch:
+			return "modify"
+		}
+	case strings.HasPrefix(r.URL.Path, "/groups/"):
+		return "groups"
+	}
+	return "other"
+}
+
+// injectFaults wraps the handler, injecting the faults matching the operation of each request
+func (s *Server) injectFaults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operation := requestOperation(r)
+		partial := false
+		for _, fault := range s.faults.faults {
+			if fault.Operation != "" && fault.Operation != "*" && fault.Operation != operation {
+				continue
+			}
+			if s.faults.roll(fault.LatencyRate) {
+				w.Header().Add(headers.HeaderInjectedFault, "latency")
+				timer := time.NewTimer(fault.Latency)
+				select {
+				case <-timer.C:
+				case <-r.Context().Done():
+					timer.Stop()
+					return
+				}
+			}
+			if s.faults.roll(fault.ErrorRate) {
+				w.Header().Add(headers.HeaderInjectedFault, "error")
+				err := fault.Error
+				if err == nil {
+					err = errInjectedFault
+				}
+				headers.SetError(w, err)
+				return
+			}
+			if s.faults.roll(fault.PartialRate) {
+				w.Header().Add(headers.HeaderInjectedFault, "partial")
+				partial = true
+			}
+		}
+		if !partial {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(&partialWriter{ResponseWriter: w}, r)
+		// abort the connection so the client sees the response end early
+		panic(http.ErrAbortHandler)
+	})
+}
+
+// partialWriter forwards the status and the first half of the first write of a response, discarding the rest
+type partialWriter struct {
+	http.ResponseWriter
+	status  int
+	written bool
+}
+
+func (w *partialWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *partialWriter) Write(b []byte) (int, error) {
+	if !w.written {
+		w.written = true
+		if w.status != 0 {
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+		_, _ = w.ResponseWriter.Write(b[:len(b)/2])
+		if f, ok := w.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	return len(b), nil
+}
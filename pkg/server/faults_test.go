@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestParseFaults(t *testing.T) {
+	faults, err := ParseFaults(" produce:error=0.1,partial=0.05; *:latency=200ms@0.5 ;consume:latency=1s;")
+	if err != nil || len(faults) != 3 {
+		t.Fatal(faults, err)
+	}
+	if f := faults[0]; f.Operation != "produce" || f.ErrorRate != 0.1 || f.PartialRate != 0.05 || f.LatencyRate != 0 {
+		t.Fatal(f)
+	}
+	if f := faults[1]; f.Operation != "*" || f.Latency != 200*time.Millisecond || f.LatencyRate != 0.5 {
+		t.Fatal(f)
+	}
+	if f := faults[2]; f.Latency != time.Second || f.LatencyRate != 1 {
+		t.Fatal(f)
+	}
+	for _, spec := range []string{"produce", "produce:error", "produce:crash=1", "produce:error=x", "produce:error=2",
+		"publish:error=0.1", "consume:latency=fast", "consume:latency=1s@x", "consume:latency=-1s"} {
+		if _, err = ParseFaults(spec); err == nil {
+			t.Errorf("expected invalid fault %q", spec)
+		}
+	}
+}
+
+func TestWithFaultInjection(t *testing.T) {
+	for _, fault := range []Fault{{Operation: "publish"}, {ErrorRate: -0.1}, {PartialRate: 1.5}, {Latency: -time.Second}} {
+		if err := WithFaultInjection(1, fault)(&Server{}); err == nil {
+			t.Error("expected invalid fault error", fault)
+		}
+	}
+	s := &Server{faults: &faultInjector{}}
+	if err := WithFaultInjection(1)(s); err != nil || s.faults != nil {
+		t.Fatal(err)
+	}
+
+	// the seed makes the injected faults repeatable
+	count := func() int {
+		if err := WithFaultInjection(42, Fault{ErrorRate: 0.5})(s); err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if s.faults.roll(0.5) {
+				n++
+			}
+		}
+		return n
+	}
+	if n := count(); n < 400 || n > 600 || n != count() {
+		t.Fatal(n)
+	}
+}
+
+func TestServer_FaultInjection(t *testing.T) {
+	dir := ".haraqa-faults"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithFaultInjection(1,
+		Fault{Operation: "create", Latency: 10 * time.Millisecond, LatencyRate: 1},
+		Fault{Operation: "inspect", ErrorRate: 1, Error: headers.ErrOverloaded},
+		Fault{Operation: "list", ErrorRate: 1},
+		Fault{Operation: "produce", PartialRate: 1},
+		Fault{Operation: "consume", PartialRate: 1},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	request := func(method, path string, body []byte, h http.Header) (*http.Response, error) {
+		req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range h {
+			req.Header[k] = v
+		}
+		return ts.Client().Do(req)
+	}
+
+	start := time.Now()
+	resp, err := request(http.MethodPut, "/topics/orders", nil, nil)
+	if err != nil || resp.StatusCode != http.StatusCreated || resp.Header.Get(headers.HeaderInjectedFault) != "latency" ||
+		time.Since(start) < 10*time.Millisecond {
+		t.Fatal(resp, err)
+	}
+	resp.Body.Close()
+
+	resp, err = request(http.MethodHead, "/topics/orders", nil, nil)
+	if err != nil || headers.ReadErrors(resp.Header) != headers.ErrOverloaded || resp.Header.Get(headers.HeaderInjectedFault) != "error" {
+		t.Fatal(resp, err)
+	}
+	resp.Body.Close()
+	resp, err = request(http.MethodGet, "/topics/", nil, nil)
+	if err != nil || resp.StatusCode != http.StatusInternalServerError {
+		t.Fatal(resp, err)
+	}
+	resp.Body.Close()
+
+	// the produce is written, but its response never reaches the client
+	if _, err = request(http.MethodPost, "/topics/orders", []byte("hello"), http.Header{headers.HeaderSizes: {"5"}}); err == nil {
+		t.Fatal("expected the response to be cut off")
+	}
+	if info, err := s.q.InspectTopic("orders"); err != nil || info.MaxOffset != 0 {
+		t.Fatal(info, err)
+	}
+
+	// consumes are cut off partway through their body
+	if resp, err = request(http.MethodGet, "/topics/orders?id=0", nil, nil); err == nil {
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil {
+			t.Fatal("expected the body to be cut off", string(b))
+		}
+	}
+
+	// other operations are not affected
+	if err = s.CreateTopic(context.Background(), "payments"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = request(http.MethodDelete, "/topics/payments", nil, nil)
+	if err != nil || resp.StatusCode != http.StatusNoContent || resp.Header.Get(headers.HeaderInjectedFault) != "" {
+		t.Fatal(resp, err)
+	}
+	resp.Body.Close()
+}
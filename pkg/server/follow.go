@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// WithFollowHeartbeat sets how often an empty part is sent on an idle follow=true consume, so that
+// proxies and clients can tell an idle stream from a broken connection
+func WithFollowHeartbeat(interval time.Duration) Option {
+	return func(s *Server) error {
+		if interval <= 0 {
+			return errors.New("invalid heartbeat interval, value must be greater than 0")
+		}
+		s.followHeartbeat = interval
+		return nil
+	}
+}
+
+// topicSignals wakes requests waiting for messages to be produced to a topic
+type topicSignals struct {
+	mux     sync.Mutex
+	waiting map[string]chan struct{}
+}
+
+// wait returns a channel which is closed the next time messages are produced to the topic
+func (t *topicSignals) wait(topic string) <-chan struct{} {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.waiting == nil {
+		t.waiting = make(map[string]chan struct{})
+	}
+	c, ok := t.waiting[topic]
+	if !ok {
+		c = make(chan struct{})
+		t.waiting[topic] = c
+	}
+	return c
+}
+
+// notify wakes every request waiting on the topic
+func (t *topicSignals) notify(topic string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if c, ok := t.waiting[topic]; ok {
+		close(c)
+		delete(t.waiting, topic)
+	}
+}
+
+// consumeFollow streams the messages of the topic from id as they are produced, until the client goes
+// away or the server is closed. Each batch is sent as a part of a multipart/mixed response with the
+// X-Sizes, X-Timestamps, X-Offsets and X-Message-Ids headers of its messages. An id < 0 follows the
+// topic from the next message produced
+func (s *Server) consumeFollow(w http.ResponseWriter, r *http.Request, topic string, id, limit int64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		headers.SetError(w, errors.New("streaming is not supported by the response writer"))
+		return
+	}
+	info, err := s.InspectTopic(r.Context(), topic)
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+	switch {
+	case id < 0:
+		id = info.MaxOffset + 1
+	case id < info.MinOffset:
+		id = info.MinOffset
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header()[headers.ContentType] = []string{"multipart/mixed; boundary=" + mw.Boundary()}
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(s.followHeartbeat)
+	defer heartbeat.Stop()
+	group := r.Header.Get(headers.HeaderGroup)
+	for {
+		// wait on the topic before consuming so that messages produced in between are not missed
+		produced := s.signals.wait(topic)
+		count, err := s.writeBatch(r.Context(), mw, topic, id, limit)
+		if err != nil {
+			if r.Context().Err() == nil {
+				part := textproto.MIMEHeader{}
+				part[headers.HeaderErrors] = []string{errors.Cause(err).Error()}
+				part[headers.HeaderErrorCode] = []string{string(headers.Code(err))}
+				_, _ = mw.CreatePart(part)
+				_ = mw.Close()
+			}
+			return
+		}
+		if count > 0 {
+			flusher.Flush()
+			s.commitGroup(group, topic, id, count)
+			id += int64(count)
+			continue
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			_ = mw.Close()
+			return
+		case <-produced:
+		case <-heartbeat.C:
+			if _, err = mw.CreatePart(textproto.MIMEHeader{}); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeBatch writes up to limit messages of the topic from id as a part, returning the number written
+func (s *Server) writeBatch(ctx context.Context, mw *multipart.Writer, topic string, id, limit int64) (int, error) {
+	body := new(bytes.Buffer)
+	batch := &streamWriter{w: body, header: make(http.Header)}
+	count, err := s.consume(ctx, topic, id, limit, batch)
+	if err != nil || count == 0 {
+		return 0, err
+	}
+	if _, err = batch.sizes(count); err != nil {
+		return 0, err
+	}
+
+	part := textproto.MIMEHeader{}
+	for _, key := range []string{headers.HeaderSizes, headers.HeaderTimestamps, headers.HeaderMessageIDs, headers.HeaderContentTypes} {
+		if v, ok := batch.header[key]; ok {
+			part[key] = v
+		}
+	}
+	offsets := make([]string, count)
+	for i := range offsets {
+		offsets[i] = strconv.FormatInt(id+int64(i), 10)
+	}
+	part[headers.HeaderOffsets] = offsets
+	pw, err := mw.CreatePart(part)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = body.WriteTo(pw); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
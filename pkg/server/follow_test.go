@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestWithFollowHeartbeat(t *testing.T) {
+	if err := WithFollowHeartbeat(0)(&Server{}); err == nil {
+		t.Error("expected invalid interval error")
+	}
+	s := &Server{}
+	if err := WithFollowHeartbeat(time.Second)(s); err != nil || s.followHeartbeat != time.Second {
+		t.Error(s.followHeartbeat, err)
+	}
+}
+
+func TestServer_ConsumeFollow(t *testing.T) {
+	dir := ".haraqa-follow"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithFollowHeartbeat(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	ctx := context.Background()
+	if err = s.CreateTopic(ctx, "follow"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(ctx, "follow", []byte("one"), []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(ts.URL + "/topics/follow?id=0&follow=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get(headers.ContentType))
+	if resp.StatusCode != http.StatusOK || err != nil || mediaType != "multipart/mixed" {
+		t.Fatal(resp.StatusCode, mediaType, err)
+	}
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	// readPart reads the next part, skipping heartbeats unless no offsets are expected
+	readPart := func(offsets ...string) string {
+		t.Helper()
+		part, err := mr.NextPart()
+		for err == nil && len(offsets) > 0 && part.Header[headers.HeaderSizes] == nil {
+			part, err = mr.NextPart()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := part.Header[headers.HeaderOffsets]; len(got) != len(offsets) {
+			t.Fatal(part.Header)
+		}
+		b, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	// existing messages are sent first, then messages as they are produced
+	if body := readPart("0", "1"); body != "onetwo" {
+		t.Fatal(body)
+	}
+	if err = s.ProduceMsgs(ctx, "follow", []byte("three")); err != nil {
+		t.Fatal(err)
+	}
+	if body := readPart("2"); body != "three" {
+		t.Fatal(body)
+	}
+
+	// idle streams get empty heartbeat parts
+	if body := readPart(); body != "" {
+		t.Fatal(body)
+	}
+
+	// the stream ends with an error part once the topic is deleted
+	if err = s.DeleteTopic(ctx, "follow"); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := part.Header.Get(headers.HeaderErrorCode); code != "" {
+			if code != string(headers.CodeTopicDoesNotExist) {
+				t.Fatal(part.Header)
+			}
+			break
+		}
+	}
+}
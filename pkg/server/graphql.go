@@ -0,0 +1,347 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// GraphQLSchema is the schema of the admin queries served by HandleGraphQL
+const GraphQLSchema = `type Query {
+  topics(prefix: String, suffix: String, regex: String): [Topic!]!
+  topic(name: String!): Topic
+  groups: [Group!]!
+  stats: Stats!
+  cluster: Cluster!
+}
+
+type Topic {
+  name: String!
+  minOffset: Int!
+  maxOffset: Int!
+  messages: Int!
+  bytes: Int!
+  groups: [GroupOffset!]!
+}
+
+type Group {
+  name: String!
+  topics: [GroupOffset!]!
+}
+
+type GroupOffset {
+  group: String!
+  topic: String!
+  offset: Int!
+  lag: Int!
+}
+
+type Stats {
+  produceHits: Int!
+  produceMisses: Int!
+  produceEvictions: Int!
+  consumeHits: Int!
+  consumeMisses: Int!
+  consumeEvictions: Int!
+  openFiles: Int!
+  fileRejections: Int!
+  readAheads: Int!
+  syncs: Int!
+  inFlightProduce: Int!
+  inFlightConsume: Int!
+  inFlightOther: Int!
+}
+
+type Cluster {
+  hostname: String!
+  startTime: String!
+  uptimeSeconds: Int!
+  degraded: Boolean!
+  dirs: [Dir!]!
+}
+
+type Dir {
+  path: String!
+  total: Int!
+  free: Int!
+  usedFraction: Float!
+}
+`
+
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type graphqlResponse struct {
+	Data   interface{} `json:"data"`
+	Errors []gqlError  `json:"errors,omitempty"`
+}
+
+// HandleGraphQL serves read only administrative queries (topics, offsets, consumer groups, cache stats
+// and server state) described by GraphQLSchema, so dashboards can fetch the fields they need in one
+// request. Queries are sent as json POST requests or with the query parameter of GET requests, a GET
+// request without a query returns the schema. It is not routed by the server
+func (s *Server) HandleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req graphqlRequest
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		req.Query, req.OperationName = query.Get("query"), query.Get("operationName")
+		if req.Query == "" {
+			w.Header()[headers.ContentType] = []string{"text/plain"}
+			_, _ = w.Write([]byte(GraphQLSchema))
+			return
+		}
+		if v := query.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				s.writeGraphQL(w, http.StatusBadRequest, graphqlResponse{Errors: []gqlError{{Message: "invalid variables"}}})
+				return
+			}
+		}
+	case http.MethodPost:
+		if r.Body == nil {
+			headers.SetError(w, headers.ErrInvalidBodyMissing)
+			return
+		}
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeGraphQL(w, http.StatusBadRequest, graphqlResponse{Errors: []gqlError{{Message: "invalid json body"}}})
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	doc, err := gqlParse(req.Query)
+	if err != nil {
+		s.writeGraphQL(w, http.StatusBadRequest, graphqlResponse{Errors: []gqlError{{Message: err.Error()}}})
+		return
+	}
+	e := &gqlExecutor{doc: doc, variables: req.Variables}
+	data, errs := e.execute(req.OperationName, s.graphqlQuery(r))
+	status := http.StatusOK
+	if data == nil {
+		status = http.StatusBadRequest
+	}
+	s.writeGraphQL(w, status, graphqlResponse{Data: data, Errors: errs})
+}
+
+func (s *Server) writeGraphQL(w http.ResponseWriter, status int, response graphqlResponse) {
+	w.Header()[headers.ContentType] = []string{"application/json"}
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(&response)
+}
+
+// stringArg returns the string argument, or an error if it is not a string or null
+func stringArg(args map[string]interface{}, name string, required bool) (string, error) {
+	v, ok := args[name].(string)
+	if !ok && (args[name] != nil || required) {
+		return "", errors.Errorf("argument %q must be a string", name)
+	}
+	return v, nil
+}
+
+func intField(v int64) gqlResolver {
+	return func(map[string]interface{}) (interface{}, error) { return v, nil }
+}
+
+// graphqlQuery returns the root query object. Expensive values shared between fields are loaded once per request
+func (s *Server) graphqlQuery(r *http.Request) *gqlObject {
+	var usageOnce sync.Once
+	var usage *headers.DiskUsage
+	var usageErr error
+	diskUsage := func() (*headers.DiskUsage, error) {
+		usageOnce.Do(func() {
+			usage, usageErr = s.q.DiskUsage()
+		})
+		return usage, usageErr
+	}
+	groups := s.groupOffsets.snapshot()
+	topicInfo := func(topic string) (*headers.TopicInfo, error) {
+		span := s.startSpan(r.Context(), "queue.InspectTopic", topic)
+		info, err := s.q.InspectTopic(topic)
+		span.RecordError(err)
+		span.End()
+		return info, err
+	}
+	groupOffset := func(group, topic string, offset int64) *gqlObject {
+		return &gqlObject{typeName: "GroupOffset", fields: map[string]gqlResolver{
+			"group":  func(map[string]interface{}) (interface{}, error) { return group, nil },
+			"topic":  func(map[string]interface{}) (interface{}, error) { return topic, nil },
+			"offset": intField(offset),
+			"lag": func(map[string]interface{}) (interface{}, error) {
+				info, err := topicInfo(topic)
+				if err != nil {
+					return nil, err
+				}
+				if lag := info.MaxOffset + 1 - offset; lag > 0 {
+					return lag, nil
+				}
+				return int64(0), nil
+			},
+		}}
+	}
+	topicObject := func(name string, info *headers.TopicInfo) *gqlObject {
+		return &gqlObject{typeName: "Topic", fields: map[string]gqlResolver{
+			"name":      func(map[string]interface{}) (interface{}, error) { return name, nil },
+			"minOffset": intField(info.MinOffset),
+			"maxOffset": intField(info.MaxOffset),
+			"messages":  intField(info.MaxOffset + 1 - info.MinOffset),
+			"bytes": func(map[string]interface{}) (interface{}, error) {
+				usage, err := diskUsage()
+				if err != nil {
+					return nil, err
+				}
+				return usage.Topics[name], nil
+			},
+			"groups": func(map[string]interface{}) (interface{}, error) {
+				names := make([]string, 0, len(groups[name]))
+				for group := range groups[name] {
+					names = append(names, group)
+				}
+				sort.Strings(names)
+				objects := make([]*gqlObject, len(names))
+				for i, group := range names {
+					objects[i] = groupOffset(group, name, groups[name][group])
+				}
+				return objects, nil
+			},
+		}}
+	}
+
+	return &gqlObject{typeName: "Query", fields: map[string]gqlResolver{
+		"topics": func(args map[string]interface{}) (interface{}, error) {
+			var filters [3]string
+			for i, name := range []string{"prefix", "suffix", "regex"} {
+				var err error
+				if filters[i], err = stringArg(args, name, false); err != nil {
+					return nil, err
+				}
+			}
+			topics, err := s.ListTopics(r.Context(), filters[0], filters[1], filters[2])
+			if err != nil {
+				return nil, err
+			}
+			objects := make([]*gqlObject, 0, len(topics))
+			for _, topic := range topics {
+				info, err := topicInfo(topic)
+				if err != nil {
+					// the topic may have been deleted since it was listed
+					continue
+				}
+				objects = append(objects, topicObject(topic, info))
+			}
+			return objects, nil
+		},
+		"topic": func(args map[string]interface{}) (interface{}, error) {
+			name, err := stringArg(args, "name", true)
+			if err != nil {
+				return nil, err
+			}
+			if name, err = cleanTopic(name); err != nil {
+				return nil, err
+			}
+			info, err := topicInfo(name)
+			if errors.Cause(err) == headers.ErrTopicDoesNotExist {
+				return (*gqlObject)(nil), nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return topicObject(name, info), nil
+		},
+		"groups": func(map[string]interface{}) (interface{}, error) {
+			byGroup := make(map[string][]string)
+			for topic, offsets := range groups {
+				for group := range offsets {
+					byGroup[group] = append(byGroup[group], topic)
+				}
+			}
+			names := make([]string, 0, len(byGroup))
+			for group := range byGroup {
+				names = append(names, group)
+			}
+			sort.Strings(names)
+			objects := make([]*gqlObject, len(names))
+			for i, group := range names {
+				group, topics := group, byGroup[group]
+				sort.Strings(topics)
+				objects[i] = &gqlObject{typeName: "Group", fields: map[string]gqlResolver{
+					"name": func(map[string]interface{}) (interface{}, error) { return group, nil },
+					"topics": func(map[string]interface{}) (interface{}, error) {
+						offsets := make([]*gqlObject, len(topics))
+						for j, topic := range topics {
+							offsets[j] = groupOffset(group, topic, groups[topic][group])
+						}
+						return offsets, nil
+					},
+				}}
+			}
+			return objects, nil
+		},
+		"stats": func(map[string]interface{}) (interface{}, error) {
+			stats := s.q.CacheStats()
+			return &gqlObject{typeName: "Stats", fields: map[string]gqlResolver{
+				"produceHits":      intField(stats.ProduceHits),
+				"produceMisses":    intField(stats.ProduceMisses),
+				"produceEvictions": intField(stats.ProduceEvictions),
+				"consumeHits":      intField(stats.ConsumeHits),
+				"consumeMisses":    intField(stats.ConsumeMisses),
+				"consumeEvictions": intField(stats.ConsumeEvictions),
+				"openFiles":        intField(stats.OpenFiles),
+				"fileRejections":   intField(stats.FileRejections),
+				"readAheads":       intField(stats.ReadAheads),
+				"syncs":            intField(stats.Syncs),
+				"inFlightProduce":  intField(atomic.LoadInt64(&s.inFlight.produce)),
+				"inFlightConsume":  intField(atomic.LoadInt64(&s.inFlight.consume)),
+				"inFlightOther":    intField(atomic.LoadInt64(&s.inFlight.other)),
+			}}, nil
+		},
+		"cluster": func(map[string]interface{}) (interface{}, error) {
+			return &gqlObject{typeName: "Cluster", fields: map[string]gqlResolver{
+				"hostname": func(map[string]interface{}) (interface{}, error) {
+					return os.Hostname()
+				},
+				"startTime": func(map[string]interface{}) (interface{}, error) {
+					return s.started.UTC().Format(time.RFC3339), nil
+				},
+				"uptimeSeconds": func(map[string]interface{}) (interface{}, error) {
+					return int64(time.Since(s.started) / time.Second), nil
+				},
+				"degraded": func(map[string]interface{}) (interface{}, error) {
+					return s.isDegraded() || s.isReadOnly(), nil
+				},
+				"dirs": func(map[string]interface{}) (interface{}, error) {
+					usage, err := diskUsage()
+					if err != nil {
+						return nil, err
+					}
+					dirs := make([]*gqlObject, len(usage.Dirs))
+					for i, dir := range usage.Dirs {
+						dir := dir
+						dirs[i] = &gqlObject{typeName: "Dir", fields: map[string]gqlResolver{
+							"path":  func(map[string]interface{}) (interface{}, error) { return dir.Path, nil },
+							"total": intField(dir.Total),
+							"free":  intField(dir.Free),
+							"usedFraction": func(map[string]interface{}) (interface{}, error) {
+								return dir.UsedFraction(), nil
+							},
+						}}
+					}
+					return dirs, nil
+				},
+			}}, nil
+		},
+	}}
+}
@@ -0,0 +1,656 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements the subset of GraphQL used by the admin endpoint: query operations with
+// arguments, variables, aliases, fragments and the @include and @skip directives. Mutations,
+// subscriptions and introspection beyond __typename are not supported.
+
+// token kinds
+const (
+	gqlEOF    = iota
+	gqlPunct  // ! $ ( ) ... : = @ [ ] { | }
+	gqlName   // names and keywords
+	gqlInt    // integer values
+	gqlFloat  // float values
+	gqlString // string values, already unescaped
+)
+
+type gqlToken struct {
+	kind  int
+	value string
+	pos   int
+}
+
+// gqlLex splits a query document into tokens, ignoring whitespace, commas and comments
+func gqlLex(src string) ([]gqlToken, error) {
+	src = strings.TrimPrefix(src, "\ufeff")
+	var tokens []gqlToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' && src[i] != '\r' {
+				i++
+			}
+		case strings.IndexByte("!$():=@[]{}|", c) >= 0:
+			tokens = append(tokens, gqlToken{kind: gqlPunct, value: string(c), pos: i})
+			i++
+		case strings.HasPrefix(src[i:], "..."):
+			tokens = append(tokens, gqlToken{kind: gqlPunct, value: "...", pos: i})
+			i += 3
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			tokens = append(tokens, gqlToken{kind: gqlName, value: src[i:j], pos: i})
+			i = j
+		case c == '-' || c >= '0' && c <= '9':
+			j := i + 1
+			kind := gqlInt
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || strings.IndexByte(".eE+-", src[j]) >= 0) {
+				if strings.IndexByte(".eE", src[j]) >= 0 {
+					kind = gqlFloat
+				}
+				j++
+			}
+			tokens = append(tokens, gqlToken{kind: kind, value: src[i:j], pos: i})
+			i = j
+		case c == '"':
+			if strings.HasPrefix(src[i:], `"""`) {
+				end := strings.Index(src[i+3:], `"""`)
+				if end < 0 {
+					return nil, errors.Errorf("unterminated block string at position %d", i)
+				}
+				tokens = append(tokens, gqlToken{kind: gqlString, value: strings.TrimSpace(src[i+3 : i+3+end]), pos: i})
+				i += end + 6
+				continue
+			}
+			j := i + 1
+			for j < len(src) && src[j] != '"' && src[j] != '\n' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) || src[j] != '"' {
+				return nil, errors.Errorf("unterminated string at position %d", i)
+			}
+			var s string
+			if err := json.Unmarshal([]byte(src[i:j+1]), &s); err != nil {
+				return nil, errors.Errorf("invalid string at position %d", i)
+			}
+			tokens = append(tokens, gqlToken{kind: gqlString, value: s, pos: i})
+			i = j + 1
+		default:
+			return nil, errors.Errorf("unexpected character %q at position %d", c, i)
+		}
+	}
+	return append(tokens, gqlToken{kind: gqlEOF, pos: len(src)}), nil
+}
+
+// gqlVariable is a reference to an operation variable in an argument value
+type gqlVariable string
+
+type gqlDirective struct {
+	name string
+	args map[string]interface{}
+}
+
+// gqlSelection is a field, a fragment spread or an inline fragment
+type gqlSelection struct {
+	alias      string
+	name       string
+	args       map[string]interface{}
+	directives []gqlDirective
+	selections []gqlSelection
+	spread     string
+	inline     bool
+}
+
+func (s *gqlSelection) key() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+type gqlOperation struct {
+	name       string
+	defaults   map[string]interface{}
+	selections []gqlSelection
+}
+
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string][]gqlSelection
+}
+
+type gqlParser struct {
+	tokens []gqlToken
+	i      int
+}
+
+// gqlParse parses a query document
+func gqlParse(src string) (*gqlDocument, error) {
+	tokens, err := gqlLex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &gqlParser{tokens: tokens}
+	doc := &gqlDocument{fragments: make(map[string][]gqlSelection)}
+	for p.peek().kind != gqlEOF {
+		switch {
+		case p.peekPunct("{"):
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &gqlOperation{selections: selections})
+		case p.peek().value == "query":
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.peek().value == "fragment":
+			p.i++
+			name := p.next()
+			if on := p.next(); name.kind != gqlName || on.value != "on" || p.next().kind != gqlName {
+				return nil, p.errorf(name, "invalid fragment definition")
+			}
+			if _, err := p.directives(); err != nil {
+				return nil, err
+			}
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.fragments[name.value] = selections
+		case p.peek().value == "mutation" || p.peek().value == "subscription":
+			return nil, p.errorf(p.peek(), "%s operations are not supported", p.peek().value)
+		default:
+			return nil, p.errorf(p.peek(), "unexpected %q", p.peek().value)
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, errors.New("no operations in query document")
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) peek() gqlToken {
+	return p.tokens[p.i]
+}
+
+func (p *gqlParser) next() gqlToken {
+	t := p.tokens[p.i]
+	if t.kind != gqlEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *gqlParser) peekPunct(v string) bool {
+	t := p.peek()
+	return t.kind == gqlPunct && t.value == v
+}
+
+// skipPunct consumes the punctuator if it is next, returning true if it was
+func (p *gqlParser) skipPunct(v string) bool {
+	if p.peekPunct(v) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *gqlParser) expectPunct(v string) error {
+	if !p.skipPunct(v) {
+		return p.errorf(p.peek(), "expected %q", v)
+	}
+	return nil
+}
+
+func (p *gqlParser) errorf(t gqlToken, format string, args ...interface{}) error {
+	return errors.Errorf(format+" at position %d", append(args, t.pos)...)
+}
+
+func (p *gqlParser) operation() (*gqlOperation, error) {
+	p.i++ // query
+	op := &gqlOperation{defaults: make(map[string]interface{})}
+	if p.peek().kind == gqlName {
+		op.name = p.next().value
+	}
+	if p.skipPunct("(") {
+		for !p.skipPunct(")") {
+			if err := p.expectPunct("$"); err != nil {
+				return nil, err
+			}
+			name := p.next()
+			if name.kind != gqlName {
+				return nil, p.errorf(name, "expected variable name")
+			}
+			if err := p.expectPunct(":"); err != nil {
+				return nil, err
+			}
+			if err := p.skipType(); err != nil {
+				return nil, err
+			}
+			if p.skipPunct("=") {
+				v, err := p.value(true)
+				if err != nil {
+					return nil, err
+				}
+				op.defaults[name.value] = v
+			}
+		}
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	selections, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.selections = selections
+	return op, nil
+}
+
+// skipType skips a variable type, variables are coerced by the fields using them
+func (p *gqlParser) skipType() error {
+	if p.skipPunct("[") {
+		if err := p.skipType(); err != nil {
+			return err
+		}
+		if err := p.expectPunct("]"); err != nil {
+			return err
+		}
+	} else if t := p.next(); t.kind != gqlName {
+		return p.errorf(t, "expected type")
+	}
+	p.skipPunct("!")
+	return nil
+}
+
+func (p *gqlParser) selectionSet() ([]gqlSelection, error) {
+	if err := p.expectPunct("{"); err != nil {
+		return nil, err
+	}
+	var selections []gqlSelection
+	for !p.skipPunct("}") {
+		var s gqlSelection
+		var err error
+		if p.skipPunct("...") {
+			switch t := p.peek(); {
+			case t.kind == gqlName && t.value != "on":
+				s.spread = p.next().value
+			case t.kind == gqlName:
+				p.i++
+				if p.next().kind != gqlName {
+					return nil, p.errorf(t, "expected type condition")
+				}
+				s.inline = true
+			default:
+				s.inline = true
+			}
+			if s.directives, err = p.directives(); err != nil {
+				return nil, err
+			}
+			if s.inline {
+				if s.selections, err = p.selectionSet(); err != nil {
+					return nil, err
+				}
+			}
+			selections = append(selections, s)
+			continue
+		}
+
+		name := p.next()
+		if name.kind != gqlName {
+			return nil, p.errorf(name, "expected field name")
+		}
+		s.name = name.value
+		if p.skipPunct(":") {
+			s.alias = s.name
+			if name = p.next(); name.kind != gqlName {
+				return nil, p.errorf(name, "expected field name")
+			}
+			s.name = name.value
+		}
+		if s.args, err = p.arguments(); err != nil {
+			return nil, err
+		}
+		if s.directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		if p.peekPunct("{") {
+			if s.selections, err = p.selectionSet(); err != nil {
+				return nil, err
+			}
+		}
+		selections = append(selections, s)
+	}
+	if len(selections) == 0 {
+		return nil, p.errorf(p.tokens[p.i-1], "empty selection set")
+	}
+	return selections, nil
+}
+
+func (p *gqlParser) arguments() (map[string]interface{}, error) {
+	args := make(map[string]interface{})
+	if !p.skipPunct("(") {
+		return args, nil
+	}
+	for !p.skipPunct(")") {
+		name := p.next()
+		if name.kind != gqlName {
+			return nil, p.errorf(name, "expected argument name")
+		}
+		if err := p.expectPunct(":"); err != nil {
+			return nil, err
+		}
+		v, err := p.value(false)
+		if err != nil {
+			return nil, err
+		}
+		args[name.value] = v
+	}
+	return args, nil
+}
+
+func (p *gqlParser) directives() ([]gqlDirective, error) {
+	var directives []gqlDirective
+	for p.skipPunct("@") {
+		name := p.next()
+		if name.kind != gqlName {
+			return nil, p.errorf(name, "expected directive name")
+		}
+		args, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		directives = append(directives, gqlDirective{name: name.value, args: args})
+	}
+	return directives, nil
+}
+
+// value parses an input value. Enum values are returned as strings
+func (p *gqlParser) value(constant bool) (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case gqlInt:
+		v, err := strconv.ParseInt(t.value, 10, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid int %q", t.value)
+		}
+		return v, nil
+	case gqlFloat:
+		v, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid float %q", t.value)
+		}
+		return v, nil
+	case gqlString:
+		return t.value, nil
+	case gqlName:
+		switch t.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return t.value, nil
+	case gqlPunct:
+		switch t.value {
+		case "$":
+			name := p.next()
+			if constant || name.kind != gqlName {
+				return nil, p.errorf(t, "unexpected variable")
+			}
+			return gqlVariable(name.value), nil
+		case "[":
+			list := []interface{}{}
+			for !p.skipPunct("]") {
+				v, err := p.value(constant)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			return list, nil
+		case "{":
+			obj := make(map[string]interface{})
+			for !p.skipPunct("}") {
+				name := p.next()
+				if name.kind != gqlName || p.expectPunct(":") != nil {
+					return nil, p.errorf(name, "invalid object field")
+				}
+				v, err := p.value(constant)
+				if err != nil {
+					return nil, err
+				}
+				obj[name.value] = v
+			}
+			return obj, nil
+		}
+	}
+	return nil, p.errorf(t, "unexpected %q", t.value)
+}
+
+// gqlResolver returns the value of a field given its arguments. Values are nil, scalars,
+// *gqlObject or slices of either
+type gqlResolver func(args map[string]interface{}) (interface{}, error)
+
+// gqlObject is a resolved object whose fields are resolved when selected
+type gqlObject struct {
+	typeName string
+	fields   map[string]gqlResolver
+}
+
+// gqlMap is a json object which keeps the order its fields were selected in
+type gqlMap struct {
+	keys   []string
+	values []interface{}
+}
+
+func (m *gqlMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(m.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+type gqlError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// gqlExecutor executes a single operation
+type gqlExecutor struct {
+	doc       *gqlDocument
+	variables map[string]interface{}
+	errors    []gqlError
+}
+
+// execute runs the named operation against the root object, returning the data and any field errors
+func (e *gqlExecutor) execute(operationName string, root *gqlObject) (interface{}, []gqlError) {
+	var op *gqlOperation
+	for _, o := range e.doc.operations {
+		if o.name == operationName || (operationName == "" && len(e.doc.operations) == 1) {
+			op = o
+		}
+	}
+	if op == nil {
+		return nil, []gqlError{{Message: "unknown operation " + strconv.Quote(operationName)}}
+	}
+	if e.variables == nil {
+		e.variables = make(map[string]interface{})
+	}
+	for name, v := range op.defaults {
+		if _, ok := e.variables[name]; !ok {
+			e.variables[name] = v
+		}
+	}
+	data, err := e.object(root, op.selections, nil)
+	if err != nil {
+		return nil, []gqlError{{Message: err.Error()}}
+	}
+	return data, e.errors
+}
+
+// resolve substitutes variables in an argument value
+func (e *gqlExecutor) resolve(v interface{}) interface{} {
+	switch v := v.(type) {
+	case gqlVariable:
+		return e.variables[string(v)]
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i := range v {
+			list[i] = e.resolve(v[i])
+		}
+		return list
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(v))
+		for k := range v {
+			obj[k] = e.resolve(v[k])
+		}
+		return obj
+	}
+	return v
+}
+
+// included evaluates the @include and @skip directives
+func (e *gqlExecutor) included(directives []gqlDirective) bool {
+	for _, d := range directives {
+		v, _ := e.resolve(d.args["if"]).(bool)
+		if (d.name == "include" && !v) || (d.name == "skip" && v) {
+			return false
+		}
+	}
+	return true
+}
+
+// collect flattens fragments and merges fields with the same response key, keeping the selection order
+func (e *gqlExecutor) collect(selections []gqlSelection, fields []*gqlSelection, index map[string]*gqlSelection, visited map[string]bool) ([]*gqlSelection, error) {
+	for i := range selections {
+		s := selections[i]
+		if !e.included(s.directives) {
+			continue
+		}
+		if s.spread != "" || s.inline {
+			nested := s.selections
+			if s.spread != "" {
+				if visited[s.spread] {
+					continue
+				}
+				var ok bool
+				if nested, ok = e.doc.fragments[s.spread]; !ok {
+					return nil, errors.Errorf("unknown fragment %q", s.spread)
+				}
+				visited[s.spread] = true
+			}
+			var err error
+			if fields, err = e.collect(nested, fields, index, visited); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if f, ok := index[s.key()]; ok {
+			if f.name != s.name {
+				return nil, errors.Errorf("fields %q and %q conflict on response key %q", f.name, s.name, s.key())
+			}
+			f.selections = append(append([]gqlSelection{}, f.selections...), s.selections...)
+			continue
+		}
+		index[s.key()] = &s
+		fields = append(fields, &s)
+	}
+	return fields, nil
+}
+
+func (e *gqlExecutor) object(obj *gqlObject, selections []gqlSelection, path []interface{}) (*gqlMap, error) {
+	fields, err := e.collect(selections, nil, make(map[string]*gqlSelection), map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+	m := &gqlMap{}
+	for _, f := range fields {
+		fieldPath := append(append([]interface{}{}, path...), f.key())
+		var value interface{}
+		if f.name == "__typename" {
+			value = obj.typeName
+		} else {
+			resolver, ok := obj.fields[f.name]
+			if !ok {
+				return nil, errors.Errorf("cannot query field %q on type %q", f.name, obj.typeName)
+			}
+			args := make(map[string]interface{}, len(f.args))
+			for k, v := range f.args {
+				args[k] = e.resolve(v)
+			}
+			value, err = resolver(args)
+			if err != nil {
+				e.errors = append(e.errors, gqlError{Message: err.Error(), Path: fieldPath})
+				value = nil
+			} else if value, err = e.complete(value, f, fieldPath); err != nil {
+				return nil, err
+			}
+		}
+		m.keys = append(m.keys, f.key())
+		m.values = append(m.values, value)
+	}
+	return m, nil
+}
+
+// complete executes the sub selections of objects and lists of objects
+func (e *gqlExecutor) complete(value interface{}, f *gqlSelection, path []interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case *gqlObject:
+		if v == nil {
+			return nil, nil
+		}
+		if len(f.selections) == 0 {
+			return nil, errors.Errorf("field %q of type %q must have a selection of subfields", f.name, v.typeName)
+		}
+		return e.object(v, f.selections, path)
+	case []*gqlObject:
+		list := make([]interface{}, len(v))
+		for i := range v {
+			var err error
+			if list[i], err = e.complete(v[i], f, append(append([]interface{}{}, path...), i)); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+	if len(f.selections) > 0 {
+		return nil, errors.Errorf("field %q must not have a selection since it is a scalar", f.name)
+	}
+	return value, nil
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestGqlLex(t *testing.T) {
+	tokens, err := gqlLex(`query Q($a: Int = -12) { f(s: "x\"A", f: 1.5e2) ... on T { g } } # comment`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for _, tok := range tokens {
+		values = append(values, tok.value)
+	}
+	got := strings.Join(values, " ")
+	want := `query Q ( $ a : Int = -12 ) { f ( s : x"A f : 1.5e2 ) ... on T { g } } `
+	if got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	for _, src := range []string{`"unterminated`, `{ f(a: "\q") }`, `{ % }`} {
+		if _, err := gqlLex(src); err == nil {
+			t.Fatal("expected error for", src)
+		}
+	}
+}
+
+func TestGqlParse(t *testing.T) {
+	doc, err := gqlParse(`
+		query A($n: String = "x") { a: field(n: $n, list: [1, 2], obj: {k: ENUM}) @skip(if: false) { ...F } }
+		query B { other }
+		fragment F on T { sub }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.operations) != 2 || doc.operations[0].name != "A" || doc.operations[1].name != "B" {
+		t.Fatal(doc.operations)
+	}
+	if doc.operations[0].defaults["n"] != "x" {
+		t.Fatal(doc.operations[0].defaults)
+	}
+	sel := doc.operations[0].selections[0]
+	if sel.key() != "a" || sel.name != "field" || sel.args["n"] != gqlVariable("n") || len(sel.directives) != 1 {
+		t.Fatal(sel)
+	}
+	if obj, ok := sel.args["obj"].(map[string]interface{}); !ok || obj["k"] != "ENUM" {
+		t.Fatal(sel.args)
+	}
+	if _, ok := doc.fragments["F"]; !ok {
+		t.Fatal(doc.fragments)
+	}
+
+	for _, src := range []string{``, `{`, `{ a(b: ) }`, `mutation { a }`, `{ a } fragment F on T`, `{ a(b: $c) }x`} {
+		if _, err := gqlParse(src); err == nil {
+			t.Fatal("expected error for", src)
+		}
+	}
+}
+
+func testRoot() *gqlObject {
+	child := &gqlObject{typeName: "Child", fields: map[string]gqlResolver{
+		"id": func(map[string]interface{}) (interface{}, error) { return int64(1), nil },
+	}}
+	return &gqlObject{typeName: "Query", fields: map[string]gqlResolver{
+		"echo": func(args map[string]interface{}) (interface{}, error) { return args["v"], nil },
+		"fail": func(map[string]interface{}) (interface{}, error) { return nil, errors.New("failed") },
+		"child": func(map[string]interface{}) (interface{}, error) {
+			return child, nil
+		},
+		"none": func(map[string]interface{}) (interface{}, error) { return (*gqlObject)(nil), nil },
+		"list": func(map[string]interface{}) (interface{}, error) {
+			return []*gqlObject{child, child}, nil
+		},
+	}}
+}
+
+func TestGqlExecutor(t *testing.T) {
+	tests := []struct {
+		query     string
+		operation string
+		variables map[string]interface{}
+		data      string
+		errors    int
+	}{
+		{query: `{ echo(v: "a") }`, data: `{"echo":"a"}`},
+		{query: `{ b: echo(v: 1) a: echo(v: 2) }`, data: `{"b":1,"a":2}`},
+		{query: `query Q($v: Int = 3) { echo(v: $v) }`, data: `{"echo":3}`},
+		{query: `query Q($v: Int = 3) { echo(v: $v) }`, variables: map[string]interface{}{"v": "x"}, data: `{"echo":"x"}`},
+		{query: `query A { a: echo(v: 1) } query B { b: echo(v: 2) }`, operation: "B", data: `{"b":2}`},
+		{query: `{ __typename child { __typename id } none { id } }`, data: `{"__typename":"Query","child":{"__typename":"Child","id":1},"none":null}`},
+		{query: `{ list { id } }`, data: `{"list":[{"id":1},{"id":1}]}`},
+		{query: `{ child { ...F ... on Child { id } } } fragment F on Child { id }`, data: `{"child":{"id":1}}`},
+		{query: `{ a: echo(v: 1) @skip(if: true) b: echo(v: 2) @include(if: false) c: echo(v: 3) }`, data: `{"c":3}`},
+		{query: `{ fail echo(v: 1) }`, data: `{"fail":null,"echo":1}`, errors: 1},
+		{query: `{ missing }`, data: `null`, errors: 1},
+		{query: `{ child }`, data: `null`, errors: 1},
+		{query: `{ echo(v: 1) { id } }`, data: `null`, errors: 1},
+		{query: `{ a: echo(v: 1) a: fail }`, data: `null`, errors: 1},
+		{query: `{ ...Missing }`, data: `null`, errors: 1},
+		{query: `query A { echo } query B { echo }`, data: `null`, errors: 1},
+	}
+	for _, tt := range tests {
+		doc, err := gqlParse(tt.query)
+		if err != nil {
+			t.Fatal(tt.query, err)
+		}
+		e := &gqlExecutor{doc: doc, variables: tt.variables}
+		data, errs := e.execute(tt.operation, testRoot())
+		b, err := json.Marshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.data || len(errs) != tt.errors {
+			t.Errorf("%s: got %s %v", tt.query, b, errs)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestServer_HandleGraphQL(t *testing.T) {
+	dir := ".haraqa-graphql"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	if err = s.CreateTopic(ctx, "gql"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(ctx, "gql", []byte("a"), []byte("b"), []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.ConsumeGroupMsgs(ctx, "gql", "readers", 0, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(r *http.Request) (int, string) {
+		w := httptest.NewRecorder()
+		s.HandleGraphQL(w, r)
+		b, _ := ioutil.ReadAll(w.Result().Body)
+		return w.Code, strings.TrimSpace(string(b))
+	}
+
+	// schema
+	code, body := do(httptest.NewRequest(http.MethodGet, "/graphql", nil))
+	if code != http.StatusOK || body != strings.TrimSpace(GraphQLSchema) {
+		t.Fatal(code, body)
+	}
+
+	// get with variables
+	v := url.Values{}
+	v.Set("query", `query T($n: String!) { topic(name: $n) { name minOffset maxOffset groups { group offset lag } } missing: topic(name: "nope") { name } }`)
+	v.Set("variables", `{"n":"gql"}`)
+	code, body = do(httptest.NewRequest(http.MethodGet, "/graphql?"+v.Encode(), nil))
+	if code != http.StatusOK || body != `{"data":{"topic":{"name":"gql","minOffset":0,"maxOffset":2,"groups":[{"group":"readers","offset":1,"lag":2}]},"missing":null}}` {
+		t.Fatal(code, body)
+	}
+
+	// post json
+	query, _ := json.Marshal(graphqlRequest{Query: `{ topics(prefix: "g") { name } groups { name topics { topic offset } } stats { __typename } cluster { degraded dirs { path } } }`})
+	code, body = do(httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(query)))
+	if code != http.StatusOK || body != `{"data":{"topics":[{"name":"gql"}],"groups":[{"name":"readers","topics":[{"topic":"gql","offset":1}]}],"stats":{"__typename":"Stats"},"cluster":{"degraded":false,"dirs":[{"path":"`+dir+`"}]}}}` {
+		t.Fatal(code, body)
+	}
+
+	// field errors
+	query, _ = json.Marshal(graphqlRequest{Query: `{ topic(name: 1) { name } }`})
+	code, body = do(httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(query)))
+	if code != http.StatusOK || !strings.HasPrefix(body, `{"data":{"topic":null},"errors":[`) {
+		t.Fatal(code, body)
+	}
+
+	// invalid requests
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/graphql?query=%7B", nil),
+		httptest.NewRequest(http.MethodGet, "/graphql?query=%7Bstats%7D&variables=x", nil),
+		httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader("{")),
+		httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ unknown }"}`)),
+	} {
+		if code, body = do(r); code != http.StatusBadRequest || !strings.Contains(body, `"errors"`) {
+			t.Fatal(code, body)
+		}
+	}
+	if code, _ = do(httptest.NewRequest(http.MethodDelete, "/graphql", nil)); code != http.StatusMethodNotAllowed {
+		t.Fatal(code)
+	}
+}
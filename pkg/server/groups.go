@@ -0,0 +1,539 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// WithGroupSessionTimeout sets how long a member of a consumer group stays in the group without sending a
+// heartbeat, its topics are then reassigned to the remaining members. The default is 30 seconds
+func WithGroupSessionTimeout(timeout time.Duration) Option {
+	return func(s *Server) error {
+		if timeout <= 0 {
+			return errors.New("invalid session timeout, value must be greater than 0")
+		}
+		s.groups.timeout = timeout
+		return nil
+	}
+}
+
+// WithGroupAssignor sets the assignor dividing the topics of a consumer group among its members, the default
+// is RoundRobinAssignor
+func WithGroupAssignor(assignor Assignor) Option {
+	return func(s *Server) error {
+		if assignor == nil {
+			return errors.New("assignor cannot be nil")
+		}
+		s.groups.assignor = assignor
+		return nil
+	}
+}
+
+// Assignor divides the topics of a consumer group among its members. Subscriptions maps each member to the
+// sorted topics it subscribes to and previous maps each member to its topics in the last generation. Each
+// topic must be assigned to exactly one of the members subscribed to it
+type Assignor func(subscriptions, previous map[string][]string) map[string][]string
+
+// ParseAssignor returns the assignor matching the name, one of roundrobin or sticky
+func ParseAssignor(name string) (Assignor, error) {
+	switch strings.ToLower(name) {
+	case "roundrobin", "round-robin":
+		return RoundRobinAssignor, nil
+	case "sticky":
+		return StickyAssignor, nil
+	}
+	return nil, errors.Errorf("invalid assignor %q", name)
+}
+
+// RoundRobinAssignor assigns the topics in sorted order to the members subscribed to them in turn, so that
+// members with the same subscription get an even share of its topics
+func RoundRobinAssignor(subscriptions, previous map[string][]string) map[string][]string {
+	members := make([]string, 0, len(subscriptions))
+	for member := range subscriptions {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+
+	assignment := make(map[string][]string, len(members))
+	next := 0
+	for _, topic := range subscribedTopics(subscriptions) {
+		for i := range members {
+			member := members[(next+i)%len(members)]
+			if subscribes(subscriptions[member], topic) {
+				assignment[member] = append(assignment[member], topic)
+				next = (next + i + 1) % len(members)
+				break
+			}
+		}
+	}
+	return assignment
+}
+
+// StickyAssignor keeps topics with the members they were assigned to in the previous generation where it can,
+// so that rebalances move as few topics as possible. New topics go to the subscribed member with the fewest
+// topics, and topics are only moved from a member with two or more topics more than another subscribed member
+func StickyAssignor(subscriptions, previous map[string][]string) map[string][]string {
+	members := make([]string, 0, len(subscriptions))
+	for member := range subscriptions {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+
+	// keep the previous assignment of topics the member still subscribes to
+	assignment := make(map[string][]string, len(members))
+	owners := make(map[string]string)
+	for _, member := range members {
+		for _, topic := range previous[member] {
+			if _, ok := owners[topic]; !ok && subscribes(subscriptions[member], topic) {
+				owners[topic] = member
+				assignment[member] = append(assignment[member], topic)
+			}
+		}
+	}
+
+	// assign the remaining topics to the least loaded members
+	for _, topic := range subscribedTopics(subscriptions) {
+		if _, ok := owners[topic]; ok {
+			continue
+		}
+		if member := leastAssigned(members, subscriptions, assignment, topic, -1); member != "" {
+			owners[topic] = member
+			assignment[member] = append(assignment[member], topic)
+		}
+	}
+
+	// move topics from the most loaded members until no subscribed member has two topics fewer
+	for moved := true; moved; {
+		moved = false
+		sort.SliceStable(members, func(i, j int) bool {
+			return len(assignment[members[i]]) > len(assignment[members[j]])
+		})
+		for _, from := range members {
+			topics := assignment[from]
+			for i := len(topics) - 1; i >= 0 && !moved; i-- {
+				to := leastAssigned(members, subscriptions, assignment, topics[i], len(topics)-1)
+				if to == "" {
+					continue
+				}
+				assignment[to] = append(assignment[to], topics[i])
+				assignment[from] = append(topics[:i:i], topics[i+1:]...)
+				moved = true
+			}
+			if moved {
+				break
+			}
+		}
+	}
+	for _, member := range members {
+		sort.Strings(assignment[member])
+	}
+	return assignment
+}
+
+// leastAssigned returns the member subscribed to the topic with the fewest assigned topics, fewer than below
+// if below is not negative. An empty string is returned if there is no such member
+func leastAssigned(members []string, subscriptions, assignment map[string][]string, topic string, below int) string {
+	least := ""
+	for _, member := range members {
+		if !subscribes(subscriptions[member], topic) || (below >= 0 && len(assignment[member]) >= below) {
+			continue
+		}
+		if least == "" || len(assignment[member]) < len(assignment[least]) ||
+			len(assignment[member]) == len(assignment[least]) && member < least {
+			least = member
+		}
+	}
+	return least
+}
+
+// subscribedTopics returns the sorted topics subscribed to by any member
+func subscribedTopics(subscriptions map[string][]string) []string {
+	var topics []string
+	seen := make(map[string]bool)
+	for _, subscription := range subscriptions {
+		for _, topic := range subscription {
+			if !seen[topic] {
+				seen[topic] = true
+				topics = append(topics, topic)
+			}
+		}
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// subscribes returns true if the sorted subscription contains the topic
+func subscribes(subscription []string, topic string) bool {
+	i := sort.SearchStrings(subscription, topic)
+	return i < len(subscription) && subscription[i] == topic
+}
+
+// consumerGroups tracks the live members of each consumer group and the topics assigned to them
+type consumerGroups struct {
+	mux      sync.Mutex
+	timeout  time.Duration
+	assignor Assignor
+	groups   map[string]*consumerGroup
+}
+
+type consumerGroup struct {
+	generation int64
+	members    map[string]*groupMember
+	assignment map[string][]string
+}
+
+type groupMember struct {
+	topics   []string
+	lastSeen time.Time
+}
+
+// join adds the member to the group or records its heartbeat, rebalancing the group if its members or
+// their subscriptions have changed. The member's topics in the current generation are returned, with the
+// group's membership record if its members or assignment changed
+func (g *consumerGroups) join(group, member string, topics []string) (*headers.GroupAssignment, *offsetsRecord, error) {
+	if member == "" {
+		id, err := headers.NewMessageID()
+		if err != nil {
+			return nil, nil, err
+		}
+		member = id.String()
+	}
+
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if g.groups == nil {
+		g.groups = make(map[string]*consumerGroup)
+	}
+	cg, ok := g.groups[group]
+	if !ok {
+		cg = &consumerGroup{members: make(map[string]*groupMember)}
+		g.groups[group] = cg
+	}
+	now := time.Now()
+	generation, members := cg.generation, len(cg.members)
+	g.expire(cg, now)
+	m, ok := cg.members[member]
+	if !ok {
+		m = &groupMember{}
+		cg.members[member] = m
+	}
+	changed := !ok || len(cg.members) != members || !reflect.DeepEqual(m.topics, topics)
+	m.topics, m.lastSeen = topics, now
+	g.rebalance(cg)
+
+	var record *offsetsRecord
+	if changed || cg.generation != generation {
+		record = membership(group, cg)
+	}
+	return &headers.GroupAssignment{
+		Group:      group,
+		Member:     member,
+		Generation: cg.generation,
+		Topics:     append([]string{}, cg.assignment[member]...),
+	}, record, nil
+}
+
+// leave removes the member from the group, its topics are reassigned to the remaining members. The group's
+// membership record is returned if the member was in the group
+func (g *consumerGroups) leave(group, member string) *offsetsRecord {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	cg, ok := g.groups[group]
+	if !ok {
+		return nil
+	}
+	if _, ok = cg.members[member]; !ok {
+		return nil
+	}
+	delete(cg.members, member)
+	if len(cg.members) == 0 {
+		delete(g.groups, group)
+		return membership(group, cg)
+	}
+	g.rebalance(cg)
+	return membership(group, cg)
+}
+
+// restore sets the members of the group from a membership record, unless the group is in a later
+// generation. Restored members keep their topics for one session timeout from now
+func (g *consumerGroups) restore(record offsetsRecord) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if cg, ok := g.groups[record.Group]; ok && cg.generation > record.Generation {
+		return
+	}
+	if len(record.Members) == 0 {
+		delete(g.groups, record.Group)
+		return
+	}
+	if g.groups == nil {
+		g.groups = make(map[string]*consumerGroup)
+	}
+	cg := &consumerGroup{
+		generation: record.Generation,
+		members:    make(map[string]*groupMember, len(record.Members)),
+		assignment: make(map[string][]string, len(record.Members)),
+	}
+	now := time.Now()
+	for id, m := range record.Members {
+		cg.members[id] = &groupMember{topics: m.Topics, lastSeen: now}
+		if len(m.Assigned) > 0 {
+			cg.assignment[id] = m.Assigned
+		}
+	}
+	g.groups[record.Group] = cg
+}
+
+// records returns the membership record of every group
+func (g *consumerGroups) records() []*offsetsRecord {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	records := make([]*offsetsRecord, 0, len(g.groups))
+	for group, cg := range g.groups {
+		records = append(records, membership(group, cg))
+	}
+	return records
+}
+
+// membership returns the record of the group's members and their topics
+func membership(group string, cg *consumerGroup) *offsetsRecord {
+	record := &offsetsRecord{Group: group, Membership: true, Generation: cg.generation}
+	if len(cg.members) > 0 {
+		record.Members = make(map[string]memberRecord, len(cg.members))
+	}
+	for id, m := range cg.members {
+		record.Members[id] = memberRecord{
+			Topics:   append([]string{}, m.topics...),
+			Assigned: append([]string(nil), cg.assignment[id]...),
+		}
+	}
+	return record
+}
+
+// describe returns the live members of the group and their topics
+func (g *consumerGroups) describe(group string) *headers.GroupDescription {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	description := &headers.GroupDescription{Group: group, Members: []headers.GroupMember{}}
+	cg, ok := g.groups[group]
+	if !ok {
+		return description
+	}
+	g.expire(cg, time.Now())
+	description.Generation = cg.generation
+	for id, m := range cg.members {
+		description.Members = append(description.Members, headers.GroupMember{
+			ID:       id,
+			Topics:   append([]string{}, cg.assignment[id]...),
+			LastSeen: m.lastSeen,
+		})
+	}
+	sort.Slice(description.Members, func(i, j int) bool {
+		return description.Members[i].ID < description.Members[j].ID
+	})
+	return description
+}
+
+// expire removes the members which have not sent a heartbeat within the session timeout
+func (g *consumerGroups) expire(cg *consumerGroup, now time.Time) {
+	expired := false
+	for id, m := range cg.members {
+		if now.Sub(m.lastSeen) > g.timeout {
+			delete(cg.members, id)
+			expired = true
+		}
+	}
+	if expired {
+		g.rebalance(cg)
+	}
+}
+
+// rebalance assigns the group's topics to its members, starting a new generation if the assignment changed
+func (g *consumerGroups) rebalance(cg *consumerGroup) {
+	subscriptions := make(map[string][]string, len(cg.members))
+	for id, m := range cg.members {
+		subscriptions[id] = m.topics
+	}
+	assignment := g.assignor(subscriptions, cg.assignment)
+	for id := range assignment {
+		if len(assignment[id]) == 0 {
+			delete(assignment, id)
+		}
+	}
+	if !reflect.DeepEqual(assignment, cg.assignment) {
+		cg.assignment = assignment
+		cg.generation++
+	}
+}
+
+// HandleGroups handles requests to the /groups/{group} endpoints. POST joins a member to the consumer group
+// or records the heartbeat of a member, returning the member's assigned topics. PUT commits the group's
+// offsets, PATCH resets them to positions of their topics, DELETE removes the member given by the member
+// query parameter and GET describes the group's live members and offsets
+func (s *Server) HandleGroups(w http.ResponseWriter, r *http.Request) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+	group := strings.Trim(strings.TrimPrefix(r.URL.Path, "/groups"), "/")
+	if group == "" {
+		headers.SetError(w, headers.ErrInvalidGroup)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		if r.Body == nil {
+			headers.SetError(w, headers.ErrInvalidBodyMissing)
+			return
+		}
+		var req headers.JoinGroupRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			headers.SetError(w, headers.ErrInvalidBodyJSON)
+			return
+		}
+		assignment, err := s.JoinGroupPattern(group, req.Member, req.Topics, req.Prefix, req.Regex)
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		writeSchemaJSON(w, http.StatusOK, assignment)
+	case http.MethodPut:
+		if r.Body == nil {
+			headers.SetError(w, headers.ErrInvalidBodyMissing)
+			return
+		}
+		var req headers.CommitRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			headers.SetError(w, headers.ErrInvalidBodyJSON)
+			return
+		}
+		if err := s.CommitOffsets(group, req.Offsets); err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPatch:
+		if r.Body == nil {
+			headers.SetError(w, headers.ErrInvalidBodyMissing)
+			return
+		}
+		var req headers.ResetRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			headers.SetError(w, headers.ErrInvalidBodyJSON)
+			return
+		}
+		offsets, err := s.ResetOffsets(r.Context(), group, req.Topics, req.DryRun)
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		writeSchemaJSON(w, http.StatusOK, headers.ResetResponse{Offsets: offsets, DryRun: req.DryRun})
+	case http.MethodDelete:
+		s.LeaveGroup(group, r.URL.Query().Get("member"))
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		description := s.groups.describe(group)
+		description.Offsets = s.groupOffsets.group(group)
+		writeSchemaJSON(w, http.StatusOK, description)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// JoinGroup adds a member subscribed to the given topics to the consumer group, or records the heartbeat of
+// an existing member. A member id is generated if it is empty. The topics assigned to the member are returned
+// with the group's next offset for each of them, members must heartbeat within the session timeout to keep
+// their topics and should stop consuming topics which are no longer assigned to them
+func (s *Server) JoinGroup(group, member string, topics []string) (*headers.GroupAssignment, error) {
+	return s.JoinGroupPattern(group, member, topics, "", "")
+}
+
+// JoinGroupPattern is JoinGroup, also subscribing the member to every topic matching the prefix and/or regex.
+// The topics are matched with each heartbeat, so that topics created after the member joined are added to
+// the group's assignment. The internal __offsets topic is never matched
+func (s *Server) JoinGroupPattern(group, member string, topics []string, prefix, regex string) (*headers.GroupAssignment, error) {
+	if prefix != "" || regex != "" {
+		if _, err := regexp.Compile(regex); err != nil {
+			return nil, errors.Wrap(headers.ErrInvalidBodyJSON, "invalid regex")
+		}
+		matched, err := s.ListTopics(context.Background(), prefix, "", regex)
+		if err != nil {
+			return nil, err
+		}
+		topics = topics[:len(topics):len(topics)]
+		for _, topic := range matched {
+			if topic != OffsetsTopic {
+				topics = append(topics, topic)
+			}
+		}
+	}
+
+	subscription := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		topic, err := cleanTopic(topic)
+		if err != nil {
+			return nil, err
+		}
+		if !subscribes(subscription, topic) {
+			subscription = append(subscription, topic)
+			sort.Strings(subscription)
+		}
+	}
+
+	assignment, record, err := s.groups.join(group, member, subscription)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.writeOffsets(record); err != nil {
+		s.logError("unable to store consumer group members", err, "group", group)
+	}
+	for _, topic := range assignment.Topics {
+		if offset, ok := s.groupOffsets.get(group, topic); ok {
+			if assignment.Offsets == nil {
+				assignment.Offsets = make(map[string]int64)
+			}
+			assignment.Offsets[topic] = offset
+		}
+	}
+	return assignment, nil
+}
+
+// LeaveGroup removes a member from the consumer group and reassigns its topics to the remaining members
+func (s *Server) LeaveGroup(group, member string) {
+	if record := s.groups.leave(group, member); record != nil {
+		if err := s.writeOffsets(record); err != nil {
+			s.logError("unable to store consumer group members", err, "group", group)
+		}
+	}
+}
+
+// CommitOffsets sets the next offset of the consumer group for each of the given topics. Consumers which
+// only commit once their messages are processed should consume without the X-Consumer-Group header, which
+// commits the offset after each consume
+func (s *Server) CommitOffsets(group string, offsets map[string]int64) error {
+	clean := make(map[string]int64, len(offsets))
+	for topic, offset := range offsets {
+		topic, err := cleanTopic(topic)
+		if err != nil {
+			return err
+		}
+		if offset < 0 {
+			return errors.Wrap(headers.ErrInvalidBodyJSON, "offsets cannot be negative")
+		}
+		clean[topic] = offset
+	}
+	for topic, offset := range clean {
+		s.groupOffsets.set(group, topic, offset)
+	}
+	return s.writeOffsets(&offsetsRecord{Group: group, Offsets: clean})
+}
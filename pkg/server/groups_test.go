@@ -0,0 +1,278 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestWithGroupOptions(t *testing.T) {
+	if err := WithGroupSessionTimeout(0)(&Server{}); err == nil {
+		t.Error("expected invalid timeout error")
+	}
+	if err := WithGroupAssignor(nil)(&Server{}); err == nil {
+		t.Error("expected nil assignor error")
+	}
+	s := &Server{}
+	if err := WithGroupSessionTimeout(time.Second)(s); err != nil || s.groups.timeout != time.Second {
+		t.Error(s.groups.timeout, err)
+	}
+	if err := WithGroupAssignor(RoundRobinAssignor)(s); err != nil || s.groups.assignor == nil {
+		t.Error(err)
+	}
+}
+
+func TestParseAssignor(t *testing.T) {
+	for _, name := range []string{"roundrobin", "Round-Robin", "sticky"} {
+		if assignor, err := ParseAssignor(name); err != nil || assignor == nil {
+			t.Error(name, err)
+		}
+	}
+	if _, err := ParseAssignor("range"); err == nil {
+		t.Error("expected invalid assignor error")
+	}
+}
+
+func TestRoundRobinAssignor(t *testing.T) {
+	assignment := RoundRobinAssignor(map[string][]string{
+		"a": {"t1", "t2", "t3", "t4"},
+		"b": {"t1", "t2", "t3", "t4"},
+		"c": {"t4", "t5"},
+	}, nil)
+	expected := map[string][]string{
+		"a": {"t1", "t3"},
+		"b": {"t2", "t4"},
+		"c": {"t5"},
+	}
+	if !reflect.DeepEqual(assignment, expected) {
+		t.Fatal(assignment)
+	}
+	if assignment = RoundRobinAssignor(map[string][]string{}, nil); len(assignment) != 0 {
+		t.Fatal(assignment)
+	}
+}
+
+func TestStickyAssignor(t *testing.T) {
+	all := []string{"t1", "t2", "t3", "t4", "t5", "t6"}
+	assignment := StickyAssignor(map[string][]string{"a": all, "b": all}, nil)
+	if !reflect.DeepEqual(assignment, map[string][]string{"a": {"t1", "t3", "t5"}, "b": {"t2", "t4", "t6"}}) {
+		t.Fatal(assignment)
+	}
+
+	// a new member only takes topics from the loaded members
+	previous := assignment
+	assignment = StickyAssignor(map[string][]string{"a": all, "b": all, "c": all}, previous)
+	if len(assignment["a"]) != 2 || len(assignment["b"]) != 2 || len(assignment["c"]) != 2 ||
+		!subscribes(previous["a"], assignment["a"][0]) || !subscribes(previous["a"], assignment["a"][1]) ||
+		!subscribes(previous["b"], assignment["b"][0]) || !subscribes(previous["b"], assignment["b"][1]) {
+		t.Fatal(assignment)
+	}
+
+	// only the topics of a member which leaves are moved
+	previous = assignment
+	assignment = StickyAssignor(map[string][]string{"a": all, "c": all}, previous)
+	if len(assignment["a"]) != 3 || len(assignment["c"]) != 3 ||
+		!subscribes(assignment["a"], previous["a"][0]) || !subscribes(assignment["a"], previous["a"][1]) ||
+		!subscribes(assignment["c"], previous["c"][0]) || !subscribes(assignment["c"], previous["c"][1]) {
+		t.Fatal(assignment)
+	}
+
+	// topics are kept with subscribed members and are not moved to members which do not subscribe to them
+	assignment = StickyAssignor(map[string][]string{"a": {"t1", "t2", "t3"}, "b": {"t4"}}, map[string][]string{"b": {"t1", "t4"}})
+	if !reflect.DeepEqual(assignment, map[string][]string{"a": {"t1", "t2", "t3"}, "b": {"t4"}}) {
+		t.Fatal(assignment)
+	}
+}
+
+func TestServer_Groups(t *testing.T) {
+	dir := ".haraqa-groups"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithGroupSessionTimeout(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	topics := []string{"t1", "t2", "T3", "t3"}
+
+	generated, err := s.JoinGroup("other", "", topics)
+	if err != nil || generated.Member == "" || generated.Group != "other" {
+		t.Fatal(generated, err)
+	}
+
+	a, err := s.JoinGroup("group", "a", topics)
+	if err != nil || a.Member != "a" || a.Generation != 1 || !reflect.DeepEqual(a.Topics, []string{"t1", "t2", "t3"}) {
+		t.Fatal(a, err)
+	}
+
+	// a second member takes some of the topics in a new generation
+	s.groupOffsets.set("group", "t2", 5)
+	b, err := s.JoinGroup("group", "b", topics)
+	if err != nil || b.Member != "b" || b.Generation != 2 || !reflect.DeepEqual(b.Topics, []string{"t2"}) ||
+		!reflect.DeepEqual(b.Offsets, map[string]int64{"t2": 5}) {
+		t.Fatal(b, err)
+	}
+
+	// heartbeats keep the assignment
+	a, err = s.JoinGroup("group", a.Member, topics)
+	if err != nil || a.Generation != 2 || !reflect.DeepEqual(a.Topics, []string{"t1", "t3"}) {
+		t.Fatal(a, err)
+	}
+
+	description := s.groups.describe("group")
+	if description.Generation != 2 || len(description.Members) != 2 || description.Members[1].ID != "b" {
+		t.Fatal(description)
+	}
+
+	// the topics of a member which leaves are reassigned
+	s.LeaveGroup("group", "b")
+	s.LeaveGroup("group", "unknown")
+	a, err = s.JoinGroup("group", a.Member, topics)
+	if err != nil || a.Generation != 3 || len(a.Topics) != 3 {
+		t.Fatal(a, err)
+	}
+
+	if _, err = s.JoinGroup("group", a.Member, []string{""}); err != headers.ErrInvalidTopic {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_GroupsExpire(t *testing.T) {
+	dir := ".haraqa-groups-expire"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithGroupSessionTimeout(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err = s.JoinGroup("group", "a", []string{"t1", "t2"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.JoinGroup("group", "b", []string{"t1", "t2"})
+	if err != nil || len(b.Topics) != 1 {
+		t.Fatal(b, err)
+	}
+
+	// a member which stops sending heartbeats is removed from the group
+	s.groups.groups["group"].members["a"].lastSeen = time.Now().Add(-time.Hour)
+	b, err = s.JoinGroup("group", "b", []string{"t1", "t2"})
+	if err != nil || len(b.Topics) != 2 || b.Generation != 3 {
+		t.Fatal(b, err)
+	}
+	if description := s.groups.describe("group"); len(description.Members) != 1 {
+		t.Fatal(description)
+	}
+}
+
+func TestServer_GroupsPattern(t *testing.T) {
+	dir := ".haraqa-groups-pattern"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithGroupSessionTimeout(time.Hour), WithOffsetsTopic(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	for _, topic := range []string{"tenant-a", "tenant-b", "other"} {
+		if err = s.CreateTopic(ctx, topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, err := s.JoinGroupPattern("group", "a", []string{"other"}, "tenant-", "")
+	if err != nil || !reflect.DeepEqual(a.Topics, []string{"other", "tenant-a", "tenant-b"}) {
+		t.Fatal(a, err)
+	}
+
+	// topics created after joining are added with the next heartbeat
+	if err = s.CreateTopic(ctx, "tenant-c"); err != nil {
+		t.Fatal(err)
+	}
+	a, err = s.JoinGroupPattern("group", "a", []string{"other"}, "tenant-", "")
+	if err != nil || a.Generation != 2 || !reflect.DeepEqual(a.Topics, []string{"other", "tenant-a", "tenant-b", "tenant-c"}) {
+		t.Fatal(a, err)
+	}
+
+	// a regex never matches the internal offsets topic
+	b, err := s.JoinGroupPattern("regex", "b", nil, "", "^(tenant-[ab]|__offsets)$")
+	if err != nil || !reflect.DeepEqual(b.Topics, []string{"tenant-a", "tenant-b"}) {
+		t.Fatal(b, err)
+	}
+
+	if _, err = s.JoinGroupPattern("regex", "b", nil, "", "("); errors.Cause(err) != headers.ErrInvalidBodyJSON {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_HandleGroups(t *testing.T) {
+	dir := ".haraqa-handle-groups"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/groups/group", bytes.NewBufferString(`{"member":"a","topics":["t1"]}`)))
+	var assignment headers.GroupAssignment
+	if err = json.NewDecoder(w.Body).Decode(&assignment); w.Code != http.StatusOK || err != nil ||
+		assignment.Group != "group" || assignment.Member != "a" || !reflect.DeepEqual(assignment.Topics, []string{"t1"}) {
+		t.Fatal(w.Code, assignment, err)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/groups/group", bytes.NewBufferString(`{"offsets":{"T1":7}}`)))
+	if w.Code != http.StatusNoContent {
+		t.Fatal(w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/groups/group", nil))
+	var description headers.GroupDescription
+	if err = json.NewDecoder(w.Body).Decode(&description); w.Code != http.StatusOK || err != nil || len(description.Members) != 1 ||
+		!reflect.DeepEqual(description.Offsets, map[string]int64{"t1": 7}) {
+		t.Fatal(w.Code, description, err)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/groups/group?member=a", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatal(w.Code)
+	}
+	if description := s.groups.describe("group"); len(description.Members) != 0 {
+		t.Fatal(description)
+	}
+
+	for _, tc := range []struct {
+		method, path, body string
+		err                error
+	}{
+		{http.MethodPost, "/groups/group", "{", headers.ErrInvalidBodyJSON},
+		{http.MethodPost, "/groups/group", `{"topics":[""]}`, headers.ErrInvalidTopic},
+		{http.MethodGet, "/groups//", "", headers.ErrInvalidGroup},
+		{http.MethodPut, "/groups/group", "{", headers.ErrInvalidBodyJSON},
+		{http.MethodPut, "/groups/group", `{"offsets":{"t1":-1}}`, headers.ErrInvalidBodyJSON},
+		{http.MethodPut, "/groups/group", `{"offsets":{"":1}}`, headers.ErrInvalidTopic},
+	} {
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body)))
+		if err = headers.ReadErrors(w.Header()); err != tc.err {
+			t.Error(tc.path, tc.body, err)
+		}
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/groups/group", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatal(w.Code)
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -19,14 +20,14 @@ func TestServer_HandleConsume(t *testing.T) {
 	q := NewMockQueue(ctrl)
 	gomock.InOrder(
 		q.EXPECT().RootDir().Times(1).Return(""),
-		q.EXPECT().Consume(topic, int64(123), int64(-1), gomock.Any()).DoAndReturn(func(topic string, offset, limit int64, w http.ResponseWriter) (int, error) {
+		q.EXPECT().Consume(gomock.Any(), topic, int64(123), int64(-1), gomock.Any()).DoAndReturn(func(_ context.Context, topic string, offset, limit int64, w http.ResponseWriter) (int, error) {
 			w.WriteHeader(http.StatusPartialContent)
 			return 10, nil
 		}).Times(1),
-		q.EXPECT().Consume(topic, int64(123), int64(-1), gomock.Any()).Return(10, nil).Times(1),
-		q.EXPECT().Consume(topic, int64(123), int64(-1), gomock.Any()).Return(0, nil).Times(1),
-		q.EXPECT().Consume(topic, int64(123), int64(-1), gomock.Any()).Return(0, headers.ErrTopicDoesNotExist).Times(1),
-		q.EXPECT().Consume(topic, int64(123), int64(-1), gomock.Any()).Return(0, errors.New("test consume error")).Times(1),
+		q.EXPECT().Consume(gomock.Any(), topic, int64(123), int64(-1), gomock.Any()).Return(10, nil).Times(1),
+		q.EXPECT().Consume(gomock.Any(), topic, int64(123), int64(-1), gomock.Any()).Return(0, nil).Times(1),
+		q.EXPECT().Consume(gomock.Any(), topic, int64(123), int64(-1), gomock.Any()).Return(0, headers.ErrTopicDoesNotExist).Times(1),
+		q.EXPECT().Consume(gomock.Any(), topic, int64(123), int64(-1), gomock.Any()).Return(0, errors.New("test consume error")).Times(1),
 		q.EXPECT().Close().Return(nil).Times(1),
 	)
 	s, err := NewServer(WithQueue(q))
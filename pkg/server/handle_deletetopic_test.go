@@ -2,8 +2,11 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 
 	"github.com/golang/mock/gomock"
@@ -107,3 +110,51 @@ func TestServer_HandleDeleteTopic(t *testing.T) {
 		}
 	}
 }
+
+func TestServer_HandlePurgeTopic(t *testing.T) {
+	dir := ".haraqa-purge"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	if err = s.CreateTopic(ctx, "purged"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(ctx, "purged", []byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.ConsumeGroupMsgs(ctx, "purged", "group", 0, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/topics/purged?purge=true", nil))
+	var info headers.TopicInfo
+	if err = json.NewDecoder(w.Body).Decode(&info); w.Code != http.StatusOK || err != nil || info.MinOffset != 2 || info.MaxOffset != 1 {
+		t.Fatal(w.Code, info, err)
+	}
+
+	// committed offsets remain valid for later messages
+	if s.groupOffsets.snapshot()["purged"]["group"] != 2 {
+		t.Fatal(s.groupOffsets.snapshot())
+	}
+	if err = s.ProduceMsgs(ctx, "purged", []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := s.ConsumeMsgs(ctx, "purged", 2, 10)
+	if err != nil || len(msgs) != 1 || string(msgs[0]) != "c" {
+		t.Fatal(msgs, err)
+	}
+
+	if _, err = s.PurgeTopic(ctx, "purged"); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/topics/missing?purge=true", nil))
+	if headers.ReadErrors(w.Header()) != headers.ErrTopicDoesNotExist {
+		t.Fatal(w.Code, w.Header())
+	}
+}
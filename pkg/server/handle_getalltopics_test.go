@@ -22,9 +22,9 @@ func TestServer_HandleGetAllTopics(t *testing.T) {
 	q := NewMockQueue(ctrl)
 	gomock.InOrder(
 		q.EXPECT().RootDir().Times(1).Return(""),
-		q.EXPECT().ListTopics("", "", "").Return(topics, nil).Times(2),
-		q.EXPECT().ListTopics("p", "s", "r").Return(nil, nil).Times(1),
-		q.EXPECT().ListTopics("", "", "").Return(nil, errors.New("test get topics error")).Times(1),
+		q.EXPECT().ListTopics(gomock.Any(), "", "", "").Return(topics, nil).Times(2),
+		q.EXPECT().ListTopics(gomock.Any(), "p", "s", "r").Return(nil, nil).Times(1),
+		q.EXPECT().ListTopics(gomock.Any(), "", "", "").Return(nil, errors.New("test get topics error")).Times(1),
 		q.EXPECT().Close().Return(nil).Times(1),
 	)
 	s, err := NewServer(WithQueue(q))
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestServer_HandleInspectTopic(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	topic := "inspected_topic"
+	q := NewMockQueue(ctrl)
+	gomock.InOrder(
+		q.EXPECT().RootDir().Times(1).Return(""),
+		q.EXPECT().InspectTopic(topic).Return(&headers.TopicInfo{MinOffset: 10, MaxOffset: 19, Size: 1024}, nil).Times(1),
+		q.EXPECT().InspectTopic(topic).Return(nil, headers.ErrTopicDoesNotExist).Times(1),
+		q.EXPECT().Close().Return(nil).Times(1),
+	)
+	s, err := NewServer(WithQueue(q))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// invalid topic
+	w := httptest.NewRecorder()
+	s.HandleInspectTopic(w, httptest.NewRequest(http.MethodHead, "/topics/", nil))
+	if w.Code != http.StatusBadRequest || headers.ReadErrors(w.Header()) != headers.ErrInvalidTopic {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	// existing topic
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/topics/"+topic, nil))
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get(headers.HeaderCount) != "10" {
+		t.Fatal(w.Code, w.Header(), w.Body.String())
+	}
+	info, err := headers.ReadTopicInfo(w.Header())
+	if err != nil || *info != (headers.TopicInfo{MinOffset: 10, MaxOffset: 19, Size: 1024}) {
+		t.Fatal(info, err)
+	}
+
+	// missing topic
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/topics/"+topic, nil))
+	if w.Code != http.StatusPreconditionFailed || headers.ReadErrors(w.Header()) != headers.ErrTopicDoesNotExist {
+		t.Fatal(w.Code, w.Header())
+	}
+}
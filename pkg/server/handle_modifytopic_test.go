@@ -174,3 +174,40 @@ func TestServer_HandleModifyTopic(t *testing.T) {
 		}
 	}
 }
+
+func TestServer_HandleModifyTopicDelete(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	topic := "deleted_topic"
+	q := NewMockQueue(ctrl)
+	gomock.InOrder(
+		q.EXPECT().RootDir().Times(1).Return(""),
+		q.EXPECT().ModifyTopic(topic, headers.ModifyRequest{Delete: &headers.OffsetRange{From: 2, To: 5}}).Return(&headers.TopicInfo{MinOffset: 0, MaxOffset: 9}, nil).Times(1),
+		q.EXPECT().ModifyTopic(topic, headers.ModifyRequest{Redact: &headers.OffsetRange{From: 3, To: 3}}).Return(&headers.TopicInfo{MinOffset: 0, MaxOffset: 9}, nil).Times(1),
+		q.EXPECT().Close().Return(nil).Times(1),
+	)
+	s, err := NewServer(WithQueue(q))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, tt := range []struct {
+		body   string
+		status int
+		err    error
+	}{
+		{`{"delete":{"from":2,"to":5}}`, http.StatusOK, nil},
+		{`{"delete":{"from":5,"to":2}}`, http.StatusBadRequest, headers.ErrInvalidMessageID},
+		{`{"delete":{"from":-1,"to":2}}`, http.StatusBadRequest, headers.ErrInvalidMessageID},
+		{`{"redact":{"from":3,"to":3}}`, http.StatusOK, nil},
+		{`{"redact":{"from":5,"to":2}}`, http.StatusBadRequest, headers.ErrInvalidMessageID},
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/topics/"+topic, bytes.NewBufferString(tt.body)))
+		if w.Code != tt.status || headers.ReadErrors(w.Header()) != tt.err {
+			t.Fatal(tt.body, w.Code, w.Header())
+		}
+	}
+}
@@ -2,6 +2,9 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -20,9 +23,9 @@ func TestServer_HandleProduce(t *testing.T) {
 	q := NewMockQueue(ctrl)
 	gomock.InOrder(
 		q.EXPECT().RootDir().Times(1).Return(""),
-		q.EXPECT().Produce(topic, []int64{5, 6}, gomock.Any(), gomock.Any()).Return(nil).Times(1),
-		q.EXPECT().Produce(topic, []int64{5, 6}, gomock.Any(), gomock.Any()).Return(headers.ErrTopicDoesNotExist).Times(1),
-		q.EXPECT().Produce(topic, []int64{5, 6}, gomock.Any(), gomock.Any()).Return(errors.New("test produce error")).Times(1),
+		q.EXPECT().Produce(gomock.Any(), topic, []int64{5, 6}, gomock.Any(), gomock.Any()).Return(nil).Times(1),
+		q.EXPECT().Produce(gomock.Any(), topic, []int64{5, 6}, gomock.Any(), gomock.Any()).Return(headers.ErrTopicDoesNotExist).Times(1),
+		q.EXPECT().Produce(gomock.Any(), topic, []int64{5, 6}, gomock.Any(), gomock.Any()).Return(errors.New("test produce error")).Times(1),
 		q.EXPECT().Close().Return(nil).Times(1),
 	)
 	s, err := NewServer(WithQueue(q))
@@ -176,3 +179,50 @@ func TestServer_HandleProduce(t *testing.T) {
 		}
 	}
 }
+
+func TestServer_HandleProduceBodyLength(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	topic := "produce_topic"
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Times(1).Return("")
+	q.EXPECT().Produce(gomock.Any(), topic, []int64{5, 6}, gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, topic string, sizes []int64, timestamp uint64, r io.Reader) error {
+		b, err := ioutil.ReadAll(r)
+		if err != nil || string(b) != "Hello World" {
+			t.Error(string(b), err)
+		}
+		return nil
+	}).Times(2)
+	s, err := NewServer(WithQueue(q))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		body    string
+		chunked bool
+		sizes   []string
+		err     error
+	}{
+		{body: "Hello World", sizes: []string{"5", "6"}},
+		{body: "Hello World", sizes: []string{"5", "6"}, chunked: true},
+		{body: "Hello Worl", sizes: []string{"5", "6"}, err: headers.ErrInvalidBodyLength},
+		{body: "Hello World!", sizes: []string{"5", "6"}, err: headers.ErrInvalidBodyLength},
+		{body: "Hello Worl", sizes: []string{"5", "6"}, chunked: true, err: headers.ErrInvalidBodyLength},
+		{body: "Hello World!", sizes: []string{"5", "6"}, chunked: true, err: headers.ErrInvalidBodyLength},
+		{body: "Hello World", sizes: []string{"12", "-1"}, err: headers.ErrInvalidHeaderSizes},
+		{body: "Hello World", sizes: []string{"9223372036854775807", "1"}, err: headers.ErrInvalidHeaderSizes},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/topics/"+topic, strings.NewReader(tt.body))
+		if tt.chunked {
+			r.ContentLength = -1
+		}
+		r.Header[headers.HeaderSizes] = tt.sizes
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if err = headers.ReadErrors(w.Header()); err != tt.err {
+			t.Error(tt, err)
+		}
+	}
+}
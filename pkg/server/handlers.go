@@ -1,7 +1,11 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"path/filepath"
 	"strconv"
@@ -9,6 +13,7 @@ import (
 	"time"
 
 	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
 )
 
 // HandleOptions handles requests to the /topics/... endpoints with method == OPTIONS
@@ -35,25 +40,36 @@ func (s *Server) HandleOptions(w http.ResponseWriter, r *http.Request) {
 
 // HandleGetAllTopics handles requests to the /topics endpoints with method == GET.
 // It returns all topics currently defined in the queue as either a json or csv depending on the
-// request content-type header
+// request content-type header. With a delimiter only one level of the topic hierarchy below the prefix
+// is listed, topics nested deeper are returned as prefixes ending with the delimiter
 func (s *Server) HandleGetAllTopics(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
-	topics, err := s.q.ListTopics(query.Get("prefix"), query.Get("suffix"), query.Get("regex"))
+	span := s.startSpan(r.Context(), "queue.ListTopics", "")
+	topics, err := s.q.ListTopics(r.Context(), query.Get("prefix"), query.Get("suffix"), query.Get("regex"))
+	span.RecordError(err)
+	span.End()
 	if err != nil {
+		s.logError("unable to list topics", err)
 		headers.SetError(w, err)
 		return
 	}
 	if topics == nil {
 		topics = []string{}
 	}
+	listing := map[string][]string{"topics": topics}
+
+	// with a delimiter, topics nested below the next level are rolled up into their common prefixes
+	if delimiter := query.Get("delimiter"); delimiter != "" {
+		topics, listing["prefixes"] = splitTopics(topics, query.Get("prefix"), delimiter)
+		listing["topics"] = topics
+		topics = append(topics, listing["prefixes"]...)
+	}
 
 	var response []byte
 	switch r.Header.Get("Accept") {
 	case "application/json":
 		w.Header()[headers.ContentType] = []string{"application/json"}
-		response, _ = json.Marshal(map[string][]string{
-			"topics": topics,
-		})
+		response, _ = json.Marshal(listing)
 	default:
 		w.Header()[headers.ContentType] = []string{"text/csv"}
 		response = []byte(strings.Join(topics, ","))
@@ -62,18 +78,22 @@ func (s *Server) HandleGetAllTopics(w http.ResponseWriter, r *http.Request) {
 }
 
 // HandleCreateTopic handles requests to the /topics/... endpoints with method == PUT.
-// It will create a topic if the topic does not exist.
+// It will create a topic if the topic does not exist, or restore a deleted topic if restore=true.
 func (s *Server) HandleCreateTopic(w http.ResponseWriter, r *http.Request) {
 	if r.Body != nil {
 		_ = r.Body.Close()
 	}
-
 	topic, err := getTopic(r)
 	if err != nil {
 		headers.SetError(w, err)
 		return
 	}
-	err = s.q.CreateTopic(topic)
+	// restore=true restores a deleted topic instead of creating an empty one
+	if r.URL.Query().Get("restore") == "true" {
+		err = s.restoreTopic(r.Context(), topic)
+	} else {
+		err = s.createTopic(r.Context(), topic)
+	}
 	if err != nil {
 		headers.SetError(w, err)
 		return
@@ -106,12 +126,12 @@ func (s *Server) HandleModifyTopic(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if request.Truncate == 0 {
+	if isEmptyModify(request) {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	info, err := s.q.ModifyTopic(topic, request)
+	info, err := s.modifyTopic(r.Context(), topic, request)
 	if err != nil {
 		headers.SetError(w, err)
 		return
@@ -122,7 +142,7 @@ func (s *Server) HandleModifyTopic(w http.ResponseWriter, r *http.Request) {
 }
 
 // HandleDeleteTopic handles requests to the /topics/... endpoints with method == DELETE.
-// It will delete a topic if the topic exists.
+// It will delete a topic if the topic exists, or purge its messages if purge=true.
 func (s *Server) HandleDeleteTopic(w http.ResponseWriter, r *http.Request) {
 	if r.Body != nil {
 		_ = r.Body.Close()
@@ -133,8 +153,21 @@ func (s *Server) HandleDeleteTopic(w http.ResponseWriter, r *http.Request) {
 		headers.SetError(w, err)
 		return
 	}
-	err = s.q.DeleteTopic(topic)
-	if err != nil {
+
+	// purge=true removes the messages but keeps the topic and its offsets
+	if r.URL.Query().Get("purge") == "true" {
+		info, err := s.modifyTopic(r.Context(), topic, headers.ModifyRequest{Purge: true})
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		w.Header()[headers.ContentType] = []string{"application/json"}
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(&info)
+		return
+	}
+
+	if err = s.deleteTopic(r.Context(), topic); err != nil {
 		headers.SetError(w, err)
 		return
 	}
@@ -143,7 +176,8 @@ func (s *Server) HandleDeleteTopic(w http.ResponseWriter, r *http.Request) {
 }
 
 // HandleProduce handles requests to the /topics/... endpoints with method == POST.
-// It will add the given messages to the queue topic
+// It will add the given messages to the queue topic, or the events of a CloudEvents request or the messages
+// of a protobuf batch envelope
 func (s *Server) HandleProduce(w http.ResponseWriter, r *http.Request) {
 	if r.Body == nil {
 		headers.SetError(w, headers.ErrInvalidBodyMissing)
@@ -152,31 +186,137 @@ func (s *Server) HandleProduce(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		_ = r.Body.Close()
 	}()
+	if s.isDegraded() {
+		headers.SetError(w, headers.ErrInsufficientStorage)
+		return
+	}
+	if s.isReadOnly() {
+		headers.SetError(w, headers.ErrDiskFull)
+		return
+	}
 
 	topic, err := getTopic(r)
 	if err != nil {
 		headers.SetError(w, err)
 		return
 	}
+	if err = negotiateBatch(w, r, true); err != nil {
+		headers.SetError(w, err)
+		return
+	}
 
-	sizes, err := headers.ReadSizes(r.Header)
+	// replay=topic copies messages from another topic instead of the request body
+	if r.URL.Query().Get("replay") != "" {
+		s.handleReplay(w, r, topic)
+		return
+	}
+
+	// drop batches the producer already sent, holding the producer's lock until the batch is written
+	producer, seq, err := s.dedup.producer(r, topic)
 	if err != nil {
 		headers.SetError(w, err)
 		return
 	}
+	if producer != nil {
+		producer.Lock()
+		defer producer.Unlock()
+		if producer.contains(seq) {
+			s.logger.Debug("dropped duplicate batch", "topic", topic, "producer", r.Header.Get(headers.HeaderProducerID), "seq", seq)
+			w.Header()[headers.HeaderDuplicate] = []string{"true"}
+			w.Header()[headers.ContentType] = []string{"text/plain"}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
 
-	err = s.q.Produce(topic, sizes, uint64(time.Now().Unix()), r.Body)
+	var body io.Reader = r.Body
+	var sizes []int64
+	var types []string
+	msgs, ok, err := readCloudEvents(r)
+	if err == nil && !ok {
+		msgs, types, ok, err = readEnvelope(r)
+	}
+	switch {
+	case err != nil:
+		headers.SetError(w, err)
+		return
+	case ok:
+		sizes = make([]int64, len(msgs))
+		for i := range msgs {
+			sizes[i] = int64(len(msgs[i]))
+		}
+		body = bytes.NewReader(bytes.Join(msgs, nil))
+	default:
+		sizes, err = headers.ReadSizes(r.Header)
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		body, err = sizedBody(r, sizes)
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		types, err = headers.ReadContentTypes(r.Header)
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+	}
+	ctx, err := s.encryptionSubject(r)
 	if err != nil {
 		headers.SetError(w, err)
 		return
 	}
-	s.metrics.ProduceMsgs(len(sizes))
+	if types != nil {
+		ctx = WithContentTypes(ctx, types)
+	}
+	ids, err := s.produce(ctx, topic, sizes, body, s.shouldCreateTopic(r))
+	if err != nil {
+		if quota, ok := err.(*quotaError); ok {
+			w.Header()["Retry-After"] = []string{strconv.Itoa(retryAfterSeconds(quota.wait))}
+		}
+		headers.SetError(w, err)
+		return
+	}
+	if producer != nil {
+		producer.add(seq)
+	}
+	if ids != nil {
+		headers.SetMessageIDs(ids, w.Header())
+	}
 	w.Header()[headers.ContentType] = []string{"text/plain"}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// sizedBody checks the length of the request body matches the sum of the message sizes, so that a short
+// or long body is rejected before anything is written. Bodies of unknown length are read into memory
+func sizedBody(r *http.Request, sizes []int64) (io.Reader, error) {
+	var total int64
+	for _, size := range sizes {
+		if total+size < total {
+			return nil, headers.ErrInvalidHeaderSizes
+		}
+		total += size
+	}
+	if r.ContentLength >= 0 {
+		if r.ContentLength != total {
+			return nil, headers.ErrInvalidBodyLength
+		}
+		return r.Body, nil
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, total+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) != total {
+		return nil, headers.ErrInvalidBodyLength
+	}
+	return bytes.NewReader(b), nil
+}
+
 // HandleConsume handles requests to the /topics/... endpoints with method == GET.
-// It will retrieve messages from the queue topic
+// It will retrieve messages from the queue topic, as CloudEvents or a protobuf batch envelope if requested
 func (s *Server) HandleConsume(w http.ResponseWriter, r *http.Request) {
 	if r.Body != nil {
 		_ = r.Body.Close()
@@ -187,6 +327,23 @@ func (s *Server) HandleConsume(w http.ResponseWriter, r *http.Request) {
 		headers.SetError(w, err)
 		return
 	}
+	if err = negotiateBatch(w, r, false); err != nil {
+		headers.SetError(w, err)
+		return
+	}
+
+	// config=true returns the configuration of the topic instead of its messages
+	if r.URL.Query().Get("config") == "true" {
+		config, err := s.TopicConfig(r.Context(), topic)
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		w.Header()[headers.ContentType] = []string{"application/json"}
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(config)
+		return
+	}
 
 	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
 	if err != nil {
@@ -194,20 +351,75 @@ func (s *Server) HandleConsume(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	limit := s.defaultConsumeLimit
+	// limit=all or -1 streams every message currently in the topic
+	limit := s.consumeLimit(topic)
 	queryLimit := r.URL.Query().Get("limit")
-	if queryLimit != "" && queryLimit[0] != '-' {
+	all := queryLimit == "all" || queryLimit == "-1"
+	if queryLimit != "" && queryLimit[0] != '-' && !all {
 		limit, err = strconv.ParseInt(queryLimit, 10, 64)
 		if err != nil {
 			headers.SetError(w, headers.ErrInvalidMessageLimit)
 			return
 		}
 		if limit <= 0 {
-			limit = s.defaultConsumeLimit
+			limit = s.consumeLimit(topic)
 		}
 	}
 
-	count, err := s.q.Consume(topic, id, limit, w)
+	// a topic ending in * consumes every topic with the prefix
+	if strings.HasSuffix(topic, "*") {
+		if all {
+			limit = -1
+		}
+		s.consumePrefix(w, r, strings.TrimSuffix(topic, "*"), id, limit)
+		return
+	}
+
+	// follow=true keeps the response open, streaming messages as they are produced
+	if r.URL.Query().Get("follow") == "true" {
+		s.consumeFollow(w, r, topic, id, limit)
+		return
+	}
+
+	if mode := cloudEventsMode(r); mode != "" {
+		s.consumeCloudEvents(w, r, mode, topic, id, limit)
+		return
+	}
+	if acceptsEnvelope(r) {
+		if all {
+			limit = -1
+		}
+		s.consumeEnvelope(w, r, topic, id, limit)
+		return
+	}
+	if all {
+		s.consumeAll(w, r, topic, id)
+		return
+	}
+
+	// a Range of bytes=N- resumes an interrupted consume N bytes into the batch body
+	cw := w
+	if value := r.Header.Get("Range"); value != "" {
+		skip, err := parseRange(value)
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		cw = &rangeWriter{ResponseWriter: w, skip: skip}
+	}
+
+	// clients accepting gzip are sent compressed batches, unless resuming a batch
+	if s.consumeGzip != nil && cw == w && acceptsGzip(r) {
+		gw := &gzipWriter{ResponseWriter: w, pool: s.consumeGzip}
+		defer func() {
+			if err := gw.close(); err != nil {
+				s.logError("unable to compress consume response", err, "topic", topic)
+			}
+		}()
+		cw = gw
+	}
+
+	count, err := s.consume(r.Context(), topic, id, limit, cw)
 	if err != nil {
 		headers.SetError(w, err)
 		return
@@ -216,11 +428,286 @@ func (s *Server) HandleConsume(w http.ResponseWriter, r *http.Request) {
 		headers.SetError(w, headers.ErrNoContent)
 		return
 	}
-	s.metrics.ConsumeMsgs(count)
+	s.commitGroup(r.Header.Get(headers.HeaderGroup), topic, id, count)
+}
+
+// HandleInspectTopic handles requests to the /topics/... endpoints with method == HEAD.
+// It returns the offsets, message count and size of the topic in headers, without a body
+func (s *Server) HandleInspectTopic(w http.ResponseWriter, r *http.Request) {
+	if r.Body != nil {
+		_ = r.Body.Close()
+	}
+
+	topic, err := getTopic(r)
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+	info, err := s.InspectTopic(r.Context(), topic)
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+	headers.SetTopicInfo(info, w.Header())
+	w.WriteHeader(http.StatusOK)
+}
+
+// createTopic creates the topic, logging the result and calling any hooks
+func (s *Server) createTopic(ctx context.Context, topic string) error {
+	if s.isDegraded() {
+		return headers.ErrInsufficientStorage
+	}
+	if s.isReadOnly() {
+		return headers.ErrDiskFull
+	}
+	key, err := s.reserveCreation(ctx)
+	if err != nil {
+		s.logger.Warn("topic creation quota exceeded", "topic", topic, "key", key)
+		return err
+	}
+	if err = s.reserveTopic(); err != nil {
+		s.releaseCreation(ctx, key)
+		s.logger.Warn("unable to create topic", "topic", topic, "err", err)
+		return err
+	}
+	span := s.startSpan(ctx, "queue.CreateTopic", topic)
+	err = s.q.CreateTopic(topic)
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		s.releaseCreation(ctx, key)
+		s.releaseTopic()
+		if errors.Cause(err) == headers.ErrDiskFull {
+			s.setReadOnly(err)
+		}
+		s.logError("unable to create topic", err, "topic", topic)
+		return err
+	}
+	s.clearReadOnly()
+	s.logger.Info("topic created", "topic", topic)
+	s.onTopicCreate(topic)
+	return nil
+}
+
+// modifyTopic modifies the topic's messages and configuration, logging the result and calling any hooks
+func (s *Server) modifyTopic(ctx context.Context, topic string, request headers.ModifyRequest) (*headers.TopicInfo, error) {
+	for _, r := range []*headers.OffsetRange{request.Delete, request.Redact} {
+		if r != nil && (r.From < 0 || r.To < r.From) {
+			return nil, headers.ErrInvalidMessageID
+		}
+	}
+	var config *headers.TopicConfig
+	if request.Config != nil {
+		var err error
+		if config, err = s.configureTopic(ctx, topic, *request.Config); err != nil {
+			return nil, err
+		}
+	}
+
+	// a request which only configures the topic leaves its messages unchanged
+	request.Config = nil
+	if isEmptyModify(request) {
+		info, err := s.InspectTopic(ctx, topic)
+		if err != nil {
+			return nil, err
+		}
+		info.Config = config
+		return info, nil
+	}
+
+	span := s.startSpan(ctx, "queue.ModifyTopic", topic)
+	info, err := s.q.ModifyTopic(topic, request)
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		s.logError("unable to modify topic", err, "topic", topic, "truncate", request.Truncate, "before", request.Before)
+		return nil, err
+	}
+	if info != nil && request.Purge {
+		s.logger.Info("topic purged", "topic", topic, "minOffset", info.MinOffset)
+	}
+	if info != nil && request.Delete != nil {
+		s.logger.Info("messages deleted", "topic", topic, "from", request.Delete.From, "to", request.Delete.To)
+	}
+	if info != nil && request.Redact != nil {
+		s.logger.Warn("messages redacted", "topic", topic, "from", request.Redact.From, "to", request.Redact.To)
+	}
+	if info != nil && request.Truncate != 0 {
+		s.logger.Info("topic truncated", "topic", topic, "truncate", request.Truncate, "before", request.Before,
+			"minOffset", info.MinOffset, "maxOffset", info.MaxOffset)
+	}
+	if info != nil {
+		info.Config = config
+	}
+	s.onTopicTruncate(topic, request, info)
+	return info, nil
+}
+
+// isEmptyModify returns true if the request does not change the topic
+func isEmptyModify(request headers.ModifyRequest) bool {
+	return request.Truncate == 0 && request.Delete == nil && request.Redact == nil && !request.Purge && request.Config == nil
+}
+
+// deleteTopic deletes the topic, logging the result and calling any hooks
+func (s *Server) deleteTopic(ctx context.Context, topic string) error {
+	span := s.startSpan(ctx, "queue.DeleteTopic", topic)
+	err := s.q.DeleteTopic(topic)
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		s.logError("unable to delete topic", err, "topic", topic)
+		return err
+	}
+	s.releaseTopic()
+	s.groupOffsets.deleteTopic(topic)
+	if s.adaptive != nil {
+		s.adaptive.deleteTopic(topic)
+	}
+	s.topicCounters.deleteTopic(topic)
+	if err = s.topicConfigs.delete(topic); err != nil {
+		s.logError("unable to save topic config", err, "topic", topic)
+	}
+	s.scheduler.removeJob(topic)
+	s.logger.Info("topic deleted", "topic", topic)
+	s.onTopicDelete(topic)
+	if s.deleteGrace == 0 {
+		if _, err = s.purgeTopics(); err != nil {
+			s.logger.Error("unable to purge deleted topics", "err", err)
+		}
+	}
+	return nil
+}
+
+// produce adds the messages in r to the topic, recording metrics and calling any hooks. The ids assigned
+// to the messages are returned if message ids are enabled
+func (s *Server) produce(ctx context.Context, topic string, sizes []int64, r io.Reader, create bool) ([]headers.MessageID, error) {
+	if s.isDegraded() {
+		return nil, headers.ErrInsufficientStorage
+	}
+	if s.isReadOnly() {
+		return nil, headers.ErrDiskFull
+	}
+	if s.topicConfigs.readOnly(topic) || s.isOffsetsTopic(topic) {
+		return nil, headers.ErrTopicReadOnly
+	}
+	if err := s.throttleProduce(ctx, topic, sizes); err != nil {
+		return nil, err
+	}
+	if err := checkContentTypes(ctx, sizes); err != nil {
+		return nil, err
+	}
+	r, err := s.validateMsgs(topic, sizes, r)
+	if err != nil {
+		s.logger.Warn("rejected invalid messages", "topic", topic, "err", err)
+		return nil, err
+	}
+	ids, err := s.newMessageIDs(len(sizes))
+	if err != nil {
+		s.logError("unable to produce", err, "topic", topic, "count", len(sizes))
+		return nil, err
+	}
+	err = s.produceQueue(ctx, topic, sizes, ids, r)
+	if err != nil && create && errors.Cause(err) == headers.ErrTopicDoesNotExist {
+		// the queue rejects a missing topic before reading any messages, so the batch can be retried
+		err = s.createTopic(ctx, topic)
+		if err == nil || errors.Cause(err) == headers.ErrTopicAlreadyExists {
+			err = s.produceQueue(ctx, topic, sizes, ids, r)
+		}
+	}
+	if err != nil {
+		if errors.Cause(err) == headers.ErrDiskFull {
+			s.setReadOnly(err)
+		}
+		s.logError("unable to produce", err, "topic", topic, "count", len(sizes))
+		return nil, err
+	}
+	s.clearReadOnly()
+	s.metrics.ProduceMsgs(len(sizes))
+	s.countProduced(topic, sizes)
+	s.onProduce(topic, sizes)
+	s.signals.notify(topic)
+	return ids, nil
+}
+
+// produceQueue writes the messages to the queue, along with their ids if given and any content types in
+// the context, encrypting them if encryption is enabled
+func (s *Server) produceQueue(ctx context.Context, topic string, sizes []int64, ids []headers.MessageID, r io.Reader) error {
+	span := s.startSpan(ctx, "queue.Produce", topic)
+	defer span.End()
+	span.SetAttribute("messaging.batch.message_count", len(sizes))
+	if s.encryption != nil {
+		sizes, r = s.sealBatch(ctx, topic, sizes, r)
+	}
+	var err error
+	if types := ContentTypes(ctx); types != nil {
+		tq, ok := s.q.(typedQueue)
+		if !ok {
+			return errors.Wrap(headers.ErrUnsupportedFeature, "queue does not store content types")
+		}
+		err = tq.ProduceWithTypes(ctx, topic, sizes, ids, types, uint64(time.Now().UnixNano()), r)
+	} else if ids != nil {
+		err = s.q.ProduceWithIDs(ctx, topic, sizes, ids, uint64(time.Now().UnixNano()), r)
+	} else {
+		err = s.q.Produce(ctx, topic, sizes, uint64(time.Now().UnixNano()), r)
+	}
+	span.RecordError(err)
+	return err
+}
+
+// consume writes up to limit messages from the topic to w, recording metrics and calling any hooks
+func (s *Server) consume(ctx context.Context, topic string, id, limit int64, w http.ResponseWriter) (int, error) {
+	span := s.startSpan(ctx, "queue.Consume", topic)
+	start := time.Now()
+	var count int
+	var err error
+	if s.encryption != nil && !s.isOffsetsTopic(topic) {
+		ow := &openWriter{ResponseWriter: w}
+		if count, err = s.q.Consume(ctx, topic, id, limit, ow); err == nil {
+			err = ow.finish(ctx, s.encryption, topic)
+		}
+	} else {
+		count, err = s.q.Consume(ctx, topic, id, limit, w)
+	}
+	span.SetAttribute("messaging.batch.message_count", count)
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		s.logError("unable to consume", err, "topic", topic, "id", id, "limit", limit)
+		return 0, err
+	}
+	if count > 0 {
+		s.metrics.ConsumeMsgs(count)
+		n := s.countConsumed(topic, count, w.Header())
+		if s.adaptive != nil {
+			s.adaptive.observe(topic, count, n, time.Since(start))
+		}
+		s.onConsume(topic, id, count)
+	}
+	return count, nil
+}
+
+// commitGroup records the next offset of a consumer group after it consumed count messages from id
+func (s *Server) commitGroup(group, topic string, id int64, count int) {
+	if group == "" || id < 0 || count <= 0 {
+		return
+	}
+	next := id + int64(count)
+	if offset, ok := s.groupOffsets.get(group, topic); ok && offset == next {
+		return
+	}
+	s.groupOffsets.set(group, topic, next)
+	if err := s.writeOffsets(&offsetsRecord{Group: group, Offsets: map[string]int64{topic: next}}); err != nil {
+		s.logError("unable to store consumer group offsets", err, "group", group, "topic", topic)
+	}
 }
 
 func getTopic(r *http.Request) (string, error) {
-	topic := filepath.Clean(strings.ToLower(strings.TrimPrefix(r.URL.Path, "/topics/")))
+	return cleanTopic(strings.TrimPrefix(r.URL.Path, "/topics/"))
+}
+
+// cleanTopic normalizes the topic name, returning an error if it is empty
+func cleanTopic(topic string) (string, error) {
+	topic = filepath.Clean(strings.ToLower(topic))
 	if topic == "" || topic == "." {
 		return "", headers.ErrInvalidTopic
 	}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+// Hooks are callbacks invoked by the server after a successful operation, nil hooks are skipped.
+// Hooks are called synchronously from the request goroutine, any slow work should be done asynchronously
+type Hooks struct {
+	OnProduce       func(topic string, sizes []int64)
+	OnConsume       func(topic string, id int64, count int)
+	OnTopicCreate   func(topic string)
+	OnTopicDelete   func(topic string)
+	OnTopicTruncate func(topic string, request headers.ModifyRequest, info *headers.TopicInfo)
+}
+
+// WithHooks adds callbacks for server events. It can be given multiple times, hooks are called in the order added
+func WithHooks(hooks Hooks) Option {
+	return func(s *Server) error {
+		s.hooks = append(s.hooks, hooks)
+		return nil
+	}
+}
+
+func (s *Server) onProduce(topic string, sizes []int64) {
+	for _, h := range s.hooks {
+		if h.OnProduce != nil {
+			h.OnProduce(topic, sizes)
+		}
+	}
+}
+
+func (s *Server) onConsume(topic string, id int64, count int) {
+	for _, h := range s.hooks {
+		if h.OnConsume != nil {
+			h.OnConsume(topic, id, count)
+		}
+	}
+}
+
+func (s *Server) onTopicCreate(topic string) {
+	for _, h := range s.hooks {
+		if h.OnTopicCreate != nil {
+			h.OnTopicCreate(topic)
+		}
+	}
+}
+
+func (s *Server) onTopicDelete(topic string) {
+	for _, h := range s.hooks {
+		if h.OnTopicDelete != nil {
+			h.OnTopicDelete(topic)
+		}
+	}
+}
+
+func (s *Server) onTopicTruncate(topic string, request headers.ModifyRequest, info *headers.TopicInfo) {
+	for _, h := range s.hooks {
+		if h.OnTopicTruncate != nil {
+			h.OnTopicTruncate(topic, request, info)
+		}
+	}
+}
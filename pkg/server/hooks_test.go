@@ -0,0 +1,85 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestServer_Hooks(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Times(1).Return("")
+	q.EXPECT().CreateTopic("hooked").Return(nil).Times(1)
+	q.EXPECT().Produce(gomock.Any(), "hooked", []int64{5}, gomock.Any(), gomock.Any()).Return(nil).Times(1)
+	q.EXPECT().Consume(gomock.Any(), "hooked", int64(3), int64(-1), gomock.Any()).Return(2, nil).Times(1)
+	q.EXPECT().ModifyTopic("hooked", gomock.Any()).Return(&headers.TopicInfo{MinOffset: 2, MaxOffset: 4}, nil).Times(1)
+	q.EXPECT().DeleteTopic("hooked").Return(nil).Times(1)
+	q.EXPECT().Close().Return(nil).Times(1)
+
+	var events []string
+	s, err := NewServer(WithQueue(q),
+		WithHooks(Hooks{
+			OnProduce: func(topic string, sizes []int64) {
+				if !reflect.DeepEqual(sizes, []int64{5}) {
+					t.Error(sizes)
+				}
+				events = append(events, "produce "+topic)
+			},
+			OnConsume: func(topic string, id int64, count int) {
+				if id != 3 || count != 2 {
+					t.Error(id, count)
+				}
+				events = append(events, "consume "+topic)
+			},
+			OnTopicCreate: func(topic string) { events = append(events, "create "+topic) },
+			OnTopicDelete: func(topic string) { events = append(events, "delete "+topic) },
+			OnTopicTruncate: func(topic string, request headers.ModifyRequest, info *headers.TopicInfo) {
+				if request.Truncate != 2 || info.MinOffset != 2 {
+					t.Error(request, info)
+				}
+				events = append(events, "truncate "+topic)
+			},
+		}),
+		WithHooks(Hooks{
+			OnTopicCreate: func(topic string) { events = append(events, "second create "+topic) },
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	requests := []struct {
+		method, url, body string
+	}{
+		{http.MethodPut, "/topics/hooked", ""},
+		{http.MethodPost, "/topics/hooked", "hello"},
+		{http.MethodGet, "/topics/hooked?id=3", ""},
+		{http.MethodPatch, "/topics/hooked", `{"truncate":2}`},
+		{http.MethodDelete, "/topics/hooked", ""},
+	}
+	for _, req := range requests {
+		r, err := http.NewRequest(req.method, req.url, bytes.NewBufferString(req.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.method == http.MethodPost {
+			r.Header.Set(headers.HeaderSizes, "5")
+		}
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	expected := "create hooked,second create hooked,produce hooked,consume hooked,truncate hooked,delete hooked"
+	if strings.Join(events, ",") != expected {
+		t.Error(events)
+	}
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+// WithMessageIDs assigns a random UUID to each produced message. The ids are returned to the producer in
+// the X-Message-Ids header and sent with the messages when they are consumed
+func WithMessageIDs(enabled bool) Option {
+	return func(s *Server) error {
+		s.messageIDs = enabled
+		return nil
+	}
+}
+
+// newMessageIDs returns n new message ids, or nil if message ids are disabled
+func (s *Server) newMessageIDs(n int) ([]headers.MessageID, error) {
+	if !s.messageIDs {
+		return nil, nil
+	}
+	ids := make([]headers.MessageID, n)
+	for i := range ids {
+		var err error
+		if ids[i], err = headers.NewMessageID(); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
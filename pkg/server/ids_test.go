@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestServer_MessageIDs(t *testing.T) {
+	dir := ".haraqa-msgids"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithMessageIDs(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.CreateTopic(context.Background(), "ids"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/topics/ids", bytes.NewBufferString("hello world"))
+	r.Header[headers.HeaderSizes] = []string{"5", "6"}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatal(w.Code)
+	}
+	produced, err := headers.ReadMessageIDs(w.Header())
+	if err != nil || len(produced) != 2 || produced[0] == produced[1] || produced[0].IsZero() {
+		t.Fatal(produced, err)
+	}
+
+	// messages produced through the api also get ids
+	if err = s.ProduceMsgs(context.Background(), "ids", []byte("again")); err != nil {
+		t.Fatal(err)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/topics/ids?id=0", nil))
+	consumed, err := headers.ReadMessageIDs(w.Header())
+	if err != nil || len(consumed) != 3 || consumed[0] != produced[0] || consumed[1] != produced[1] || consumed[2].IsZero() {
+		t.Fatal(consumed, err)
+	}
+}
+
+func TestServer_MessageIDsDisabled(t *testing.T) {
+	dir := ".haraqa-nomsgids"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithMessageIDs(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.CreateTopic(context.Background(), "ids"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/topics/ids", bytes.NewBufferString("hello"))
+	r.Header[headers.HeaderSizes] = []string{"5"}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if _, ok := w.Header()[headers.HeaderMessageIDs]; w.Code != http.StatusNoContent || ok {
+		t.Fatal(w.Code, w.Header())
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/topics/ids?id=0", nil))
+	if _, ok := w.Header()[headers.HeaderMessageIDs]; w.Code != http.StatusOK && w.Code != http.StatusPartialContent || ok {
+		t.Fatal(w.Code, w.Header())
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Janitor tasks, reported to Metrics.JanitorTask
+const (
+	// TaskRetention truncates the messages of topics which are older than their retention
+	TaskRetention = "retention"
+	// TaskTrash purges the deleted topics whose delete grace period has passed
+	TaskTrash = "trash"
+	// TaskOffsets compacts the offsets topic to the latest state of each consumer group
+	TaskOffsets = "offsets"
+)
+
+// WithJanitor configures the background janitor, which purges deleted topics past their grace period and
+// compacts the offsets topic every interval, and applies retention every retention interval. Up to
+// concurrency topics are expired at once. In dry run mode nothing is removed, the messages which would
+// expire are logged and counted in the metrics instead. The default is every minute, one topic at a time
+func WithJanitor(interval time.Duration, concurrency int, dryRun bool) Option {
+	return func(s *Server) error {
+		if interval <= 0 {
+			return errors.New("invalid janitor interval, value must be greater than 0")
+		}
+		if concurrency <= 0 {
+			return errors.New("invalid janitor concurrency, value must be greater than 0")
+		}
+		s.janitor.interval, s.janitor.concurrency, s.janitor.dryRun = interval, concurrency, dryRun
+		return nil
+	}
+}
+
+// janitor is the configuration and state of the background janitor
+type janitor struct {
+	interval      time.Duration
+	concurrency   int
+	dryRun        bool
+	once          sync.Once
+	lastRetention time.Time
+}
+
+// startJanitor starts the janitor if it is not already running. It is started by the server if it has
+// work to do, or later by the first topic given a retention, so that topics can be configured without
+// restarting the server
+func (s *Server) startJanitor() {
+	s.janitor.once.Do(func() {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		// wake often enough for a short grace period or retention interval
+		tick := s.janitor.interval
+		if s.deleteGrace > 0 && s.deleteGrace < tick {
+			tick = s.deleteGrace
+		}
+		if s.retentionInterval < tick {
+			tick = s.retentionInterval
+		}
+		s.janitor.lastRetention = time.Now()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.runJanitor(tick)
+		}()
+	})
+}
+
+// needsJanitor returns true if any janitor task has work to do
+func (s *Server) needsJanitor() bool {
+	return s.deleteGrace > 0 || s.offsets != nil || s.retention > 0 || s.topicConfigs.retains()
+}
+
+func (s *Server) runJanitor(tick time.Duration) {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.sweep(context.Background(), now)
+		}
+	}
+}
+
+// sweep runs each janitor task which is due
+func (s *Server) sweep(ctx context.Context, now time.Time) {
+	if s.deleteGrace > 0 {
+		s.runTask(TaskTrash, s.sweepTrash)
+	}
+	if now.Sub(s.janitor.lastRetention) >= s.retentionInterval && (s.retention > 0 || s.topicConfigs.retains()) {
+		s.janitor.lastRetention = now
+		s.runTask(TaskRetention, func() (int64, error) {
+			return s.applyRetention(ctx)
+		})
+	}
+	if s.offsets != nil {
+		s.runTask(TaskOffsets, s.sweepOffsets)
+	}
+}
+
+// runTask runs the janitor task, recording the number of items it removed and how long it took
+func (s *Server) runTask(task string, fn func() (int64, error)) {
+	start := time.Now()
+	n, err := fn()
+	elapsed := time.Since(start)
+	s.metrics.JanitorTask(task, n, elapsed)
+	if err != nil {
+		s.logError("janitor task failed", err, "task", task)
+		return
+	}
+	if n > 0 {
+		s.logger.Info("janitor task finished", "task", task, "removed", n, "dryRun", s.janitor.dryRun, "duration", elapsed.String())
+	}
+}
+
+// sweepTrash purges the deleted topics past their grace period, they are kept in dry run mode
+func (s *Server) sweepTrash() (int64, error) {
+	if s.janitor.dryRun {
+		return 0, nil
+	}
+	return s.purgeTopics()
+}
+
+// sweepOffsets compacts the offsets topic if records have been written since its last compaction, returning
+// the number of records written since
+func (s *Server) sweepOffsets() (int64, error) {
+	s.offsets.mux.Lock()
+	defer s.offsets.mux.Unlock()
+	n := int64(s.offsets.records)
+	if n == 0 || s.janitor.dryRun {
+		return n, nil
+	}
+	return n, s.compactOffsets()
+}
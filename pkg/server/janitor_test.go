@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+type janitorMetrics struct {
+	noOpMetrics
+	mux     sync.Mutex
+	removed map[string]int64
+}
+
+func (m *janitorMetrics) JanitorTask(task string, removed int64, d time.Duration) {
+	m.mux.Lock()
+	m.removed[task] += removed
+	m.mux.Unlock()
+}
+
+func TestWithJanitor(t *testing.T) {
+	for _, opt := range []Option{WithJanitor(0, 1, false), WithJanitor(time.Minute, 0, false)} {
+		if err := opt(&Server{}); err == nil {
+			t.Error("expected invalid option error")
+		}
+	}
+	s := &Server{}
+	if err := WithJanitor(time.Second, 4, true)(s); err != nil || s.janitor.interval != time.Second || s.janitor.concurrency != 4 || !s.janitor.dryRun {
+		t.Fatal(err, s.janitor.interval, s.janitor.concurrency, s.janitor.dryRun)
+	}
+}
+
+func TestServer_Sweep(t *testing.T) {
+	dir := ".haraqa-janitor"
+	defer os.RemoveAll(dir)
+	metrics := &janitorMetrics{removed: make(map[string]int64)}
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 2), WithMetrics(metrics), WithOffsetsTopic(true),
+		WithRetention(time.Hour, time.Hour), WithJanitor(time.Hour, 2, true), WithDeleteGracePeriod(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	for _, topic := range []string{"a", "b", "deleted"} {
+		if err = s.CreateTopic(ctx, topic); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if err = s.ProduceMsgs(ctx, topic, []byte("old"), []byte("old")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err = s.CommitOffsets("group", map[string]int64{"a": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteTopic(ctx, "deleted"); err != nil {
+		t.Fatal(err)
+	}
+	// deleted topics cannot be consumed by their path in the trash
+	names, err := filepath.Glob(filepath.Join(dir, ".trash", "deleted.*"))
+	if err != nil || len(names) != 1 {
+		t.Fatal(names, err)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/topics/.trash/"+filepath.Base(names[0])+"?id=0", nil))
+	if w.Code != http.StatusBadRequest || w.Header().Get(headers.HeaderErrors) == "" {
+		t.Fatal(w.Code, w.Header())
+	}
+	// the janitor only wakes every hour, so the sweeps below are the only ones
+	s.retention, s.deleteGrace = time.Nanosecond, time.Nanosecond
+	time.Sleep(10 * time.Millisecond)
+
+	// dry runs count what would be removed but keep everything
+	s.sweep(ctx, time.Now().Add(2*time.Hour))
+	if metrics.removed[TaskRetention] != 8 || metrics.removed[TaskOffsets] != 1 || metrics.removed[TaskTrash] != 0 {
+		t.Fatal(metrics.removed)
+	}
+	if info, err := s.q.InspectTopic("a"); err != nil || info.MinOffset != 0 {
+		t.Fatal(info, err)
+	}
+	if err = s.RestoreTopic(ctx, "deleted"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteTopic(ctx, "deleted"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the files of expired messages are removed, keeping the latest file of each topic
+	s.janitor.dryRun = false
+	metrics.removed = make(map[string]int64)
+	s.sweep(ctx, time.Now().Add(4*time.Hour))
+	if metrics.removed[TaskRetention] != 4 || metrics.removed[TaskOffsets] != 1 || metrics.removed[TaskTrash] != 1 {
+		t.Fatal(metrics.removed)
+	}
+	if info, err := s.q.InspectTopic("b"); err != nil || info.MinOffset != 2 {
+		t.Fatal(info, err)
+	}
+	if err = s.RestoreTopic(ctx, "deleted"); err == nil {
+		t.Fatal("expected purged topic")
+	}
+	if offset, ok := s.groupOffsets.get("group", "a"); !ok || offset != 1 {
+		t.Fatal(offset, ok)
+	}
+
+	// retention is only applied once the retention interval has passed
+	metrics.removed = make(map[string]int64)
+	s.sweep(ctx, time.Now().Add(4*time.Hour))
+	if _, ok := metrics.removed[TaskRetention]; ok {
+		t.Fatal(metrics.removed)
+	}
+	if metrics.removed[TaskOffsets] != 0 {
+		t.Fatal(metrics.removed)
+	}
+}
@@ -0,0 +1,523 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// jsonSchema is a compiled JSON Schema. The validation keywords of draft 7 are supported except format,
+// dependencies and if/then/else, which are ignored along with any other unknown keyword. References are
+// limited to pointers within the same document, such as #/definitions/item
+type jsonSchema struct {
+	always     *bool // set for the boolean schemas true and false
+	types      []string
+	enum       []interface{}
+	constant   *interface{}
+	ref        string
+	root       *jsonSchema
+	defs       map[string]*jsonSchema
+	allOf      []*jsonSchema
+	anyOf      []*jsonSchema
+	oneOf      []*jsonSchema
+	not        *jsonSchema
+	properties map[string]*jsonSchema
+	patterns   map[*regexp.Regexp]*jsonSchema
+	additional *jsonSchema
+	required   []string
+	minProps   *int
+	maxProps   *int
+	items      *jsonSchema
+	tuple      []*jsonSchema
+	addItems   *jsonSchema
+	minItems   *int
+	maxItems   *int
+	unique     bool
+	contains   *jsonSchema
+	minLength  *int
+	maxLength  *int
+	pattern    *regexp.Regexp
+	minimum    *float64
+	maximum    *float64
+	exMinimum  *float64
+	exMaximum  *float64
+	multipleOf *float64
+}
+
+// compileJSONSchema parses the schema document
+func compileJSONSchema(b []byte) (*jsonSchema, error) {
+	s, err := parseJSONSchema(b, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s, s.resolve()
+}
+
+func parseJSONSchema(b []byte, root *jsonSchema) (*jsonSchema, error) {
+	b = bytes.TrimSpace(b)
+	s := &jsonSchema{root: root}
+	if s.root == nil {
+		s.root = s
+	}
+	if string(b) == "true" || string(b) == "false" {
+		v := string(b) == "true"
+		s.always = &v
+		return s, nil
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, errors.New("schema must be an object or boolean")
+	}
+
+	var err error
+	sub := func(key string) *jsonSchema {
+		v, ok := raw[key]
+		if !ok || err != nil {
+			return nil
+		}
+		var child *jsonSchema
+		if child, err = parseJSONSchema(v, s.root); err != nil {
+			err = errors.Wrap(err, key)
+		}
+		return child
+	}
+	list := func(key string) []*jsonSchema {
+		v, ok := raw[key]
+		if !ok || err != nil {
+			return nil
+		}
+		var items []json.RawMessage
+		if err = json.Unmarshal(v, &items); err != nil {
+			err = errors.Errorf("%s must be an array of schemas", key)
+			return nil
+		}
+		children := make([]*jsonSchema, len(items))
+		for i := range items {
+			if children[i], err = parseJSONSchema(items[i], s.root); err != nil {
+				err = errors.Wrapf(err, "%s/%d", key, i)
+				return nil
+			}
+		}
+		return children
+	}
+	schemaMap := func(key string) map[string]*jsonSchema {
+		v, ok := raw[key]
+		if !ok || err != nil {
+			return nil
+		}
+		var items map[string]json.RawMessage
+		if err = json.Unmarshal(v, &items); err != nil {
+			err = errors.Errorf("%s must be an object of schemas", key)
+			return nil
+		}
+		children := make(map[string]*jsonSchema, len(items))
+		for name, item := range items {
+			if children[name], err = parseJSONSchema(item, s.root); err != nil {
+				err = errors.Wrapf(err, "%s/%s", key, name)
+				return nil
+			}
+		}
+		return children
+	}
+	decode := func(key string, v interface{}) {
+		if value, ok := raw[key]; ok && err == nil {
+			if e := json.Unmarshal(value, v); e != nil {
+				err = errors.Errorf("invalid %s", key)
+			}
+		}
+	}
+	regex := func(pattern string) *regexp.Regexp {
+		re, e := regexp.Compile(pattern)
+		if e != nil && err == nil {
+			err = errors.Wrapf(e, "invalid pattern %q", pattern)
+		}
+		return re
+	}
+
+	if t, ok := raw["type"]; ok {
+		var single string
+		if json.Unmarshal(t, &single) == nil {
+			s.types = []string{single}
+		} else if json.Unmarshal(t, &s.types) != nil {
+			return nil, errors.New("type must be a string or array of strings")
+		}
+		for _, typ := range s.types {
+			switch typ {
+			case "null", "boolean", "object", "array", "number", "integer", "string":
+			default:
+				return nil, errors.Errorf("unknown type %q", typ)
+			}
+		}
+	}
+	decode("enum", &s.enum)
+	if v, ok := raw["const"]; ok {
+		var c interface{}
+		if err = json.Unmarshal(v, &c); err != nil {
+			return nil, errors.New("invalid const")
+		}
+		s.constant = &c
+	}
+	decode("$ref", &s.ref)
+	s.allOf, s.anyOf, s.oneOf, s.not = list("allOf"), list("anyOf"), list("oneOf"), sub("not")
+	s.properties, s.additional = schemaMap("properties"), sub("additionalProperties")
+	for name, child := range schemaMap("patternProperties") {
+		if s.patterns == nil {
+			s.patterns = make(map[*regexp.Regexp]*jsonSchema)
+		}
+		s.patterns[regex(name)] = child
+	}
+	decode("required", &s.required)
+	decode("minProperties", &s.minProps)
+	decode("maxProperties", &s.maxProps)
+	if v, ok := raw["items"]; ok && len(bytes.TrimSpace(v)) > 0 && bytes.TrimSpace(v)[0] == '[' {
+		s.tuple = list("items")
+	} else {
+		s.items = sub("items")
+	}
+	s.addItems, s.contains = sub("additionalItems"), sub("contains")
+	decode("minItems", &s.minItems)
+	decode("maxItems", &s.maxItems)
+	decode("uniqueItems", &s.unique)
+	decode("minLength", &s.minLength)
+	decode("maxLength", &s.maxLength)
+	var pattern string
+	decode("pattern", &pattern)
+	if pattern != "" {
+		s.pattern = regex(pattern)
+	}
+	decode("minimum", &s.minimum)
+	decode("maximum", &s.maximum)
+	decode("exclusiveMinimum", &s.exMinimum)
+	decode("exclusiveMaximum", &s.exMaximum)
+	decode("multipleOf", &s.multipleOf)
+	if s.multipleOf != nil && *s.multipleOf <= 0 && err == nil {
+		err = errors.New("multipleOf must be greater than 0")
+	}
+	s.defs = schemaMap("definitions")
+	for name, child := range schemaMap("$defs") {
+		if s.defs == nil {
+			s.defs = make(map[string]*jsonSchema)
+		}
+		s.defs[name] = child
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// resolve checks that all references within the schema point to a schema in the document
+func (s *jsonSchema) resolve() error {
+	if s == nil {
+		return nil
+	}
+	if s.ref != "" {
+		if _, err := s.lookup(); err != nil {
+			return err
+		}
+	}
+	children := []*jsonSchema{s.not, s.additional, s.items, s.addItems, s.contains}
+	children = append(append(append(append(children, s.allOf...), s.anyOf...), s.oneOf...), s.tuple...)
+	for _, m := range []map[string]*jsonSchema{s.properties, s.defs} {
+		for _, child := range m {
+			children = append(children, child)
+		}
+	}
+	for _, child := range s.patterns {
+		children = append(children, child)
+	}
+	for _, child := range children {
+		if err := child.resolve(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookup returns the schema referenced by $ref
+func (s *jsonSchema) lookup() (*jsonSchema, error) {
+	if s.ref == "#" {
+		return s.root, nil
+	}
+	if !strings.HasPrefix(s.ref, "#/") {
+		return nil, errors.Errorf("unsupported $ref %q, only local references are supported", s.ref)
+	}
+	target := s.root
+	for _, part := range strings.Split(s.ref[2:], "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		var next *jsonSchema
+		switch {
+		case target.defs != nil && (part == "definitions" || part == "$defs"):
+			continue
+		case target.properties != nil && part == "properties":
+			continue
+		default:
+			if next = target.defs[part]; next == nil {
+				next = target.properties[part]
+			}
+		}
+		if next == nil {
+			return nil, errors.Errorf("unresolved $ref %q", s.ref)
+		}
+		target = next
+	}
+	return target, nil
+}
+
+// validate decodes the json document and validates it against the schema
+func (s *jsonSchema) validate(b []byte) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return errors.New("invalid json")
+	}
+	if d.More() {
+		return errors.New("invalid json, unexpected data after value")
+	}
+	return s.check(v, "$", 0)
+}
+
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case json.Number:
+		if f, err := v.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	}
+	return "string"
+}
+
+// normalize converts numbers to float64 so that values can be compared
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k := range v {
+			m[k] = normalize(v[k])
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i := range v {
+			a[i] = normalize(v[i])
+		}
+		return a
+	}
+	return v
+}
+
+// check validates the value at path, depth guards against recursive references
+func (s *jsonSchema) check(v interface{}, path string, depth int) error {
+	if depth > 100 {
+		return errors.Errorf("%s: schema nested too deeply", path)
+	}
+	if s.always != nil {
+		if !*s.always {
+			return errors.Errorf("%s: no value is allowed", path)
+		}
+		return nil
+	}
+	if s.ref != "" {
+		// like draft 7, other keywords next to $ref are ignored
+		target, err := s.lookup()
+		if err != nil {
+			return err
+		}
+		return target.check(v, path, depth+1)
+	}
+
+	typ := jsonType(v)
+	if len(s.types) > 0 {
+		ok := false
+		for _, t := range s.types {
+			if t == typ || (t == "number" && typ == "integer") {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return errors.Errorf("%s: expected %s, got %s", path, strings.Join(s.types, " or "), typ)
+		}
+	}
+	if s.enum != nil {
+		ok := false
+		for _, e := range s.enum {
+			if reflect.DeepEqual(normalize(e), normalize(v)) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return errors.Errorf("%s: value is not one of the allowed values", path)
+		}
+	}
+	if s.constant != nil && !reflect.DeepEqual(normalize(*s.constant), normalize(v)) {
+		return errors.Errorf("%s: value does not match const", path)
+	}
+
+	for _, sub := range s.allOf {
+		if err := sub.check(v, path, depth+1); err != nil {
+			return err
+		}
+	}
+	if s.anyOf != nil {
+		var err error
+		for _, sub := range s.anyOf {
+			if err = sub.check(v, path, depth+1); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return errors.Errorf("%s: value does not match any schema of anyOf", path)
+		}
+	}
+	if s.oneOf != nil {
+		matched := 0
+		for _, sub := range s.oneOf {
+			if sub.check(v, path, depth+1) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return errors.Errorf("%s: value matches %d schemas of oneOf, expected exactly 1", path, matched)
+		}
+	}
+	if s.not != nil && s.not.check(v, path, depth+1) == nil {
+		return errors.Errorf("%s: value must not match schema", path)
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return s.checkObject(v, path, depth)
+	case []interface{}:
+		return s.checkArray(v, path, depth)
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength != nil && n < *s.minLength {
+			return errors.Errorf("%s: string shorter than %d", path, *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			return errors.Errorf("%s: string longer than %d", path, *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return errors.Errorf("%s: string does not match pattern %q", path, s.pattern.String())
+		}
+	case json.Number:
+		f, _ := v.Float64()
+		switch {
+		case s.minimum != nil && f < *s.minimum:
+			return errors.Errorf("%s: %s is less than %v", path, v, *s.minimum)
+		case s.maximum != nil && f > *s.maximum:
+			return errors.Errorf("%s: %s is greater than %v", path, v, *s.maximum)
+		case s.exMinimum != nil && f <= *s.exMinimum:
+			return errors.Errorf("%s: %s is not greater than %v", path, v, *s.exMinimum)
+		case s.exMaximum != nil && f >= *s.exMaximum:
+			return errors.Errorf("%s: %s is not less than %v", path, v, *s.exMaximum)
+		case s.multipleOf != nil && math.Abs(math.Remainder(f, *s.multipleOf)) > 1e-9:
+			return errors.Errorf("%s: %s is not a multiple of %v", path, v, *s.multipleOf)
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) checkObject(v map[string]interface{}, path string, depth int) error {
+	for _, name := range s.required {
+		if _, ok := v[name]; !ok {
+			return errors.Errorf("%s: missing required property %q", path, name)
+		}
+	}
+	if s.minProps != nil && len(v) < *s.minProps {
+		return errors.Errorf("%s: fewer than %d properties", path, *s.minProps)
+	}
+	if s.maxProps != nil && len(v) > *s.maxProps {
+		return errors.Errorf("%s: more than %d properties", path, *s.maxProps)
+	}
+	for name, value := range v {
+		childPath := path + "." + name
+		matched := false
+		if sub, ok := s.properties[name]; ok {
+			matched = true
+			if err := sub.check(value, childPath, depth+1); err != nil {
+				return err
+			}
+		}
+		for re, sub := range s.patterns {
+			if re.MatchString(name) {
+				matched = true
+				if err := sub.check(value, childPath, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		if !matched && s.additional != nil {
+			if s.additional.always != nil && !*s.additional.always {
+				return errors.Errorf("%s: additional property %q is not allowed", path, name)
+			}
+			if err := s.additional.check(value, childPath, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) checkArray(v []interface{}, path string, depth int) error {
+	if s.minItems != nil && len(v) < *s.minItems {
+		return errors.Errorf("%s: fewer than %d items", path, *s.minItems)
+	}
+	if s.maxItems != nil && len(v) > *s.maxItems {
+		return errors.Errorf("%s: more than %d items", path, *s.maxItems)
+	}
+	for i, item := range v {
+		sub := s.items
+		if s.tuple != nil {
+			sub = s.addItems
+			if i < len(s.tuple) {
+				sub = s.tuple[i]
+			}
+		}
+		if sub != nil {
+			if err := sub.check(item, path+"["+strconv.Itoa(i)+"]", depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	if s.unique {
+		for i := range v {
+			for j := i + 1; j < len(v); j++ {
+				if reflect.DeepEqual(normalize(v[i]), normalize(v[j])) {
+					return errors.Errorf("%s: items %d and %d are equal", path, i, j)
+				}
+			}
+		}
+	}
+	if s.contains != nil {
+		for _, item := range v {
+			if s.contains.check(item, path, depth+1) == nil {
+				return nil
+			}
+		}
+		return errors.Errorf("%s: no item matches contains", path)
+	}
+	return nil
+}
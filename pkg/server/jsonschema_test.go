@@ -0,0 +1,99 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJSONSchema(t *testing.T) {
+	schema := `{
+		"type": "object",
+		"required": ["id", "kind"],
+		"properties": {
+			"id": {"type": "integer", "minimum": 1},
+			"kind": {"enum": ["a", "b"]},
+			"name": {"type": "string", "minLength": 2, "maxLength": 4, "pattern": "^[a-z]+$"},
+			"price": {"type": "number", "exclusiveMinimum": 0, "multipleOf": 0.5},
+			"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2, "uniqueItems": true},
+			"pair": {"type": "array", "items": [{"type": "string"}, {"type": "integer"}], "additionalItems": false},
+			"owner": {"$ref": "#/definitions/owner"},
+			"version": {"const": 1},
+			"note": {"type": ["string", "null"]},
+			"either": {"oneOf": [{"type": "string"}, {"type": "integer"}]},
+			"not": {"not": {"type": "string"}},
+			"any": {"anyOf": [{"type": "boolean"}, {"type": "integer", "maximum": 3}]}
+		},
+		"patternProperties": {"^x-": {"type": "string"}},
+		"additionalProperties": false,
+		"definitions": {
+			"owner": {"type": "object", "properties": {"parent": {"$ref": "#/definitions/owner"}}, "minProperties": 1}
+		}
+	}`
+	s, err := compileJSONSchema([]byte(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := []string{
+		`{"id":1,"kind":"a"}`,
+		`{"id":2,"kind":"b","name":"abc","price":1.5,"tags":["x","y"],"pair":["a",1],"version":1.0,"note":null}`,
+		`{"id":1,"kind":"a","owner":{"parent":{"parent":{"x":1}}},"x-trace":"t","either":3,"not":1,"any":true}`,
+	}
+	for _, msg := range valid {
+		if err = s.validate([]byte(msg)); err != nil {
+			t.Error(msg, err)
+		}
+	}
+
+	invalid := map[string]string{
+		`{"id":1}`:                                 `missing required property "kind"`,
+		`{"id":1.5,"kind":"a"}`:                    "$.id: expected integer, got number",
+		`{"id":0,"kind":"a"}`:                      "$.id: 0 is less than 1",
+		`{"id":1,"kind":"c"}`:                      "$.kind: value is not one of the allowed values",
+		`{"id":1,"kind":"a","name":"a"}`:           "$.name: string shorter than 2",
+		`{"id":1,"kind":"a","name":"abcde"}`:       "$.name: string longer than 4",
+		`{"id":1,"kind":"a","name":"AB"}`:          "does not match pattern",
+		`{"id":1,"kind":"a","price":0}`:            "is not greater than 0",
+		`{"id":1,"kind":"a","price":1.2}`:          "is not a multiple of 0.5",
+		`{"id":1,"kind":"a","tags":["x","x"]}`:     "items 0 and 1 are equal",
+		`{"id":1,"kind":"a","tags":["x","y","z"]}`: "more than 2 items",
+		`{"id":1,"kind":"a","tags":[1]}`:           "$.tags[0]: expected string",
+		`{"id":1,"kind":"a","pair":["a",1,2]}`:     "$.pair[2]: no value is allowed",
+		`{"id":1,"kind":"a","owner":{}}`:           "$.owner: fewer than 1 properties",
+		`{"id":1,"kind":"a","version":2}`:          "value does not match const",
+		`{"id":1,"kind":"a","note":1}`:             "expected string or null",
+		`{"id":1,"kind":"a","either":true}`:        "matches 0 schemas of oneOf",
+		`{"id":1,"kind":"a","not":"s"}`:            "must not match schema",
+		`{"id":1,"kind":"a","any":4}`:              "does not match any schema of anyOf",
+		`{"id":1,"kind":"a","x-trace":1}`:          "$.x-trace: expected string",
+		`{"id":1,"kind":"a","other":1}`:            `additional property "other" is not allowed`,
+		`[]`:                                       "expected object, got array",
+		`{"id":1`:                                  "invalid json",
+		`{"id":1,"kind":"a"} {}`:                   "unexpected data after value",
+	}
+	for msg, expected := range invalid {
+		if err = s.validate([]byte(msg)); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected %q, got %v", msg, expected, err)
+		}
+	}
+
+	for _, schema := range []string{
+		`[]`,
+		`{"type": "float"}`,
+		`{"type": 1}`,
+		`{"properties": {"a": 1}}`,
+		`{"pattern": "("}`,
+		`{"multipleOf": 0}`,
+		`{"$ref": "http://example.com/schema"}`,
+		`{"$ref": "#/definitions/missing"}`,
+		`{"allOf": {}}`,
+		`{"minLength": "1"}`,
+	} {
+		if _, err = compileJSONSchema([]byte(schema)); err == nil {
+			t.Error("expected invalid schema error", schema)
+		}
+	}
+	if s, err = compileJSONSchema([]byte("true")); err != nil || s.validate([]byte("1")) != nil {
+		t.Error(err)
+	}
+}
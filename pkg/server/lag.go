@@ -0,0 +1,120 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// WithLagMonitor periodically reports the lag of each consumer group to the metrics handler.
+// Consumers identify their group with the X-Consumer-Group header on consume requests, the lag
+// is the number of messages between the group's next offset and the end of the topic
+func WithLagMonitor(interval time.Duration) Option {
+	return func(s *Server) error {
+		if interval <= 0 {
+			return errors.New("invalid interval, value must be greater than 0")
+		}
+		s.lagInterval = interval
+		return nil
+	}
+}
+
+// groupOffsets tracks the next offset of each consumer group per topic
+type groupOffsets struct {
+	mux     sync.Mutex
+	offsets map[string]map[string]int64
+}
+
+func (g *groupOffsets) set(group, topic string, offset int64) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if g.offsets == nil {
+		g.offsets = make(map[string]map[string]int64)
+	}
+	groups, ok := g.offsets[topic]
+	if !ok {
+		groups = make(map[string]int64)
+		g.offsets[topic] = groups
+	}
+	groups[group] = offset
+}
+
+// get returns the next offset of the group for the topic, if the group has consumed from it
+func (g *groupOffsets) get(group, topic string) (int64, bool) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	offset, ok := g.offsets[topic][group]
+	return offset, ok
+}
+
+// group returns the next offset of the group for each topic it has consumed
+func (g *groupOffsets) group(group string) map[string]int64 {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	var offsets map[string]int64
+	for topic, groups := range g.offsets {
+		if offset, ok := groups[group]; ok {
+			if offsets == nil {
+				offsets = make(map[string]int64)
+			}
+			offsets[topic] = offset
+		}
+	}
+	return offsets
+}
+
+func (g *groupOffsets) deleteTopic(topic string) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	delete(g.offsets, topic)
+}
+
+// snapshot returns a copy of the group offsets, keyed by topic then group
+func (g *groupOffsets) snapshot() map[string]map[string]int64 {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	snapshot := make(map[string]map[string]int64, len(g.offsets))
+	for topic, groups := range g.offsets {
+		snapshot[topic] = make(map[string]int64, len(groups))
+		for group, offset := range groups {
+			snapshot[topic][group] = offset
+		}
+	}
+	return snapshot
+}
+
+func (s *Server) monitorLag() {
+	ticker := time.NewTicker(s.lagInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.checkLag()
+		}
+	}
+}
+
+func (s *Server) checkLag() {
+	for topic, groups := range s.groupOffsets.snapshot() {
+		info, err := s.q.InspectTopic(topic)
+		if err != nil {
+			if errors.Cause(err) == headers.ErrTopicDoesNotExist {
+				s.groupOffsets.deleteTopic(topic)
+				continue
+			}
+			s.logger.Error("unable to inspect topic", "topic", topic, "err", err)
+			continue
+		}
+		for group, offset := range groups {
+			lag := info.MaxOffset + 1 - offset
+			if lag < 0 {
+				lag = 0
+			}
+			s.metrics.ConsumerLag(group, topic, lag)
+		}
+	}
+}
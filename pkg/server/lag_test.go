@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+type lagMetrics struct {
+	noOpMetrics
+	lag map[string]int64
+}
+
+func (m *lagMetrics) ConsumerLag(group, topic string, lag int64) {
+	m.lag[group+"/"+topic] = lag
+}
+
+func TestWithLagMonitor(t *testing.T) {
+	if err := WithLagMonitor(0)(&Server{}); err == nil {
+		t.Error("expected interval error")
+	}
+	s := &Server{}
+	if err := WithLagMonitor(time.Second)(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.lagInterval != time.Second {
+		t.Error(s.lagInterval)
+	}
+}
+
+func TestServer_checkLag(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Times(1).Return("")
+	q.EXPECT().Consume(gomock.Any(), "lag", int64(5), int64(-1), gomock.Any()).Return(3, nil).Times(1)
+	q.EXPECT().Consume(gomock.Any(), "other", int64(0), int64(-1), gomock.Any()).Return(2, nil).Times(1)
+	q.EXPECT().Consume(gomock.Any(), "lag", int64(-1), int64(-1), gomock.Any()).Return(1, nil).Times(1)
+	q.EXPECT().InspectTopic("lag").Return(&headers.TopicInfo{MinOffset: 0, MaxOffset: 19}, nil).Times(1)
+	q.EXPECT().InspectTopic("other").Return(nil, headers.ErrTopicDoesNotExist).Times(1)
+	q.EXPECT().InspectTopic("lag").Return(nil, errors.New("test inspect error")).Times(1)
+	q.EXPECT().Close().Return(nil).Times(1)
+
+	metrics := &lagMetrics{lag: map[string]int64{}}
+	s, err := NewServer(WithQueue(q), WithMetrics(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, url := range []string{"/topics/lag?id=5", "/topics/other?id=0", "/topics/lag?id=-1"} {
+		r, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set(headers.HeaderGroup, "group")
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	s.checkLag()
+	if len(metrics.lag) != 1 || metrics.lag["group/lag"] != 12 {
+		t.Fatal(metrics.lag)
+	}
+	offsets := s.groupOffsets.snapshot()
+	if _, ok := offsets["other"]; ok {
+		t.Fatal(offsets)
+	}
+
+	// errors are logged and skipped
+	s.checkLag()
+}
+
+func TestServer_monitorLag(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Times(1).Return("")
+	q.EXPECT().InspectTopic("lag").Return(&headers.TopicInfo{}, nil).MinTimes(1)
+	q.EXPECT().Close().Return(nil).Times(1)
+	s, err := NewServer(WithQueue(q), WithLagMonitor(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.groupOffsets.set("group", "lag", 0)
+	time.Sleep(5 * time.Millisecond)
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// Logger allows for custom structured loggers. Fields are given as alternating key value pairs
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// WithLogger sets the logger used to report topic lifecycle events, truncations and errors
+func WithLogger(logger Logger) Option {
+	return func(s *Server) error {
+		if logger == nil {
+			return errors.New("logger cannot be nil")
+		}
+		s.logger = logger
+		return nil
+	}
+}
+
+var _ Logger = noOpLogger{}
+
+type noOpLogger struct{}
+
+func (noOpLogger) Debug(string, ...interface{}) {}
+func (noOpLogger) Info(string, ...interface{})  {}
+func (noOpLogger) Warn(string, ...interface{})  {}
+func (noOpLogger) Error(string, ...interface{}) {}
+
+// LogLevel is the minimum level written by the logger returned from NewLogger
+type LogLevel int
+
+// Log levels in increasing order of severity
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelInfo:
+		return "info"
+	case LogLevelWarn:
+		return "warn"
+	case LogLevelError:
+		return "error"
+	}
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// ParseLogLevel returns the log level matching the name, one of debug, info, warn or error
+func ParseLogLevel(name string) (LogLevel, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LogLevelDebug, nil
+	case "info":
+		return LogLevelInfo, nil
+	case "warn", "warning":
+		return LogLevelWarn, nil
+	case "error":
+		return LogLevelError, nil
+	}
+	return 0, errors.Errorf("invalid log level %q", name)
+}
+
+// NewLogger returns a Logger which writes logfmt formatted lines to w for entries at or above the given level
+func NewLogger(w io.Writer, level LogLevel) Logger {
+	return &logfmtLogger{w: w, level: level}
+}
+
+type logfmtLogger struct {
+	mux   sync.Mutex
+	w     io.Writer
+	level LogLevel
+}
+
+func (l *logfmtLogger) Debug(msg string, keyvals ...interface{}) { l.log(LogLevelDebug, msg, keyvals) }
+func (l *logfmtLogger) Info(msg string, keyvals ...interface{})  { l.log(LogLevelInfo, msg, keyvals) }
+func (l *logfmtLogger) Warn(msg string, keyvals ...interface{})  { l.log(LogLevelWarn, msg, keyvals) }
+func (l *logfmtLogger) Error(msg string, keyvals ...interface{}) { l.log(LogLevelError, msg, keyvals) }
+
+func (l *logfmtLogger) log(level LogLevel, msg string, keyvals []interface{}) {
+	if level < l.level {
+		return
+	}
+	var buf bytes.Buffer
+	buf.WriteString("time=")
+	buf.WriteString(time.Now().UTC().Format(time.RFC3339Nano))
+	buf.WriteString(" level=")
+	buf.WriteString(level.String())
+	buf.WriteString(" msg=")
+	buf.WriteString(logfmtValue(msg))
+	for i := 0; i < len(keyvals); i += 2 {
+		buf.WriteByte(' ')
+		buf.WriteString(logfmtValue(fmt.Sprint(keyvals[i])))
+		buf.WriteByte('=')
+		if i+1 < len(keyvals) {
+			buf.WriteString(logfmtValue(keyvals[i+1]))
+		} else {
+			buf.WriteString(`"(MISSING)"`)
+		}
+	}
+	buf.WriteByte('\n')
+
+	l.mux.Lock()
+	_, _ = l.w.Write(buf.Bytes())
+	l.mux.Unlock()
+}
+
+func logfmtValue(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case time.Time:
+		s = v.UTC().Format(time.RFC3339Nano)
+	case error:
+		s = v.Error()
+	default:
+		s = fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// logError logs errors returned by the queue. Errors sent to clients with a 4xx status, and requests
+// abandoned by the client, are logged at the debug level, all others are logged as errors and counted
+// in the server Stats
+func (s *Server) logError(msg string, err error, keyvals ...interface{}) {
+	keyvals = append(keyvals, "err", err)
+	cause := errors.Cause(err)
+	if cause == context.Canceled || cause == context.DeadlineExceeded || headers.Code(cause).Status() < 500 {
+		s.logger.Debug(msg, keyvals...)
+		return
+	}
+	atomic.AddInt64(&s.counters.errors, 1)
+	s.logger.Error(msg, keyvals...)
+}
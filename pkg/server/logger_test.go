@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+type testLogger struct {
+	mux     sync.Mutex
+	entries []string
+}
+
+func (l *testLogger) log(level, msg string, keyvals []interface{}) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.entries = append(l.entries, level+": "+msg+" "+strings.TrimSpace(fmt.Sprintln(keyvals...)))
+}
+func (l *testLogger) Debug(msg string, keyvals ...interface{}) { l.log("debug", msg, keyvals) }
+func (l *testLogger) Info(msg string, keyvals ...interface{})  { l.log("info", msg, keyvals) }
+func (l *testLogger) Warn(msg string, keyvals ...interface{})  { l.log("warn", msg, keyvals) }
+func (l *testLogger) Error(msg string, keyvals ...interface{}) { l.log("error", msg, keyvals) }
+
+func TestNewLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewLogger(buf, LogLevelInfo)
+	logger.Debug("hidden")
+	logger.Info("topic created", "topic", "my topic", "count", 2)
+	logger.Warn("warning", "err", errors.New(`bad "thing"`), "empty", "")
+	logger.Error("missing", "key")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatal(lines)
+	}
+	if !strings.Contains(lines[0], ` level=info msg="topic created" topic="my topic" count=2`) {
+		t.Error(lines[0])
+	}
+	if !strings.Contains(lines[1], ` level=warn msg=warning err="bad \"thing\"" empty=""`) {
+		t.Error(lines[1])
+	}
+	if !strings.Contains(lines[2], ` level=error msg=missing key="(MISSING)"`) {
+		t.Error(lines[2])
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for name, level := range map[string]LogLevel{
+		"debug":   LogLevelDebug,
+		"INFO":    LogLevelInfo,
+		"warning": LogLevelWarn,
+		"warn":    LogLevelWarn,
+		"error":   LogLevelError,
+	} {
+		l, err := ParseLogLevel(name)
+		if err != nil || l != level {
+			t.Error(name, l, err)
+		}
+	}
+	if _, err := ParseLogLevel("invalid"); err == nil {
+		t.Error("expected invalid level error")
+	}
+	if LogLevel(9).String() != "level(9)" {
+		t.Error(LogLevel(9).String())
+	}
+}
+
+func TestServer_Logging(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	if err := WithLogger(nil)(&Server{}); err == nil {
+		t.Fatal("expected nil logger error")
+	}
+
+	q := NewMockQueue(ctrl)
+	gomock.InOrder(
+		q.EXPECT().RootDir().Times(1).Return(""),
+		q.EXPECT().CreateTopic("logged").Return(nil).Times(1),
+		q.EXPECT().CreateTopic("logged").Return(headers.ErrTopicAlreadyExists).Times(1),
+		q.EXPECT().DeleteTopic("logged").Return(errors.New("test delete error")).Times(1),
+		q.EXPECT().Consume(gomock.Any(), "logged", int64(0), int64(-1), gomock.Any()).Return(0, context.Canceled).Times(1),
+		q.EXPECT().Close().Return(nil).Times(1),
+	)
+	logger := &testLogger{}
+	s, err := NewServer(WithQueue(q), WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, method := range []string{http.MethodPut, http.MethodPut, http.MethodDelete} {
+		r, err := http.NewRequest(method, "/topics/logged", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	// a request abandoned by the client is not a server error
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/topics/logged?id=0", nil))
+	// errors are classified by the status sent to the client
+	s.logError("client error", errors.Wrap(headers.ErrTopicReadOnly, "logged"))
+	s.logError("client error", headers.ErrProduceQuota)
+	s.logError("server error", headers.ErrDiskFull)
+
+	expected := []string{
+		"info: topic created topic logged",
+		"debug: unable to create topic topic logged err topic already exists",
+		"error: unable to delete topic topic logged err test delete error",
+		"debug: unable to consume topic logged id 0 limit -1 err context canceled",
+		"debug: client error err logged: " + headers.ErrTopicReadOnly.Error(),
+		"debug: client error err " + headers.ErrProduceQuota.Error(),
+		"error: server error err " + headers.ErrDiskFull.Error(),
+	}
+	if len(logger.entries) != len(expected) {
+		t.Fatal(logger.entries)
+	}
+	for i := range expected {
+		if logger.entries[i] != expected[i] {
+			t.Error(logger.entries[i], expected[i])
+		}
+	}
+	if s.counters.errors != 2 {
+		t.Error(s.counters.errors)
+	}
+}
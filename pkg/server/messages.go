@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// CreateTopic creates the topic. Like the other message level methods it applies the same validation, logging,
+// metrics and hooks as requests made over http and is intended for protocol listeners embedding the server.
+// If the server authorizes requests, the principal set in the context with WithPrincipal must have the
+// permission the matching http request requires, calls without one are made as an anonymous client
+func (s *Server) CreateTopic(ctx context.Context, topic string) error {
+	topic, err := cleanTopic(topic)
+	if err != nil {
+		return err
+	}
+	if err = s.permit(ctx, topic, headers.PermissionAdmin); err != nil {
+		return err
+	}
+	return s.createTopic(ctx, topic)
+}
+
+// DeleteTopic deletes the topic and all of its messages
+func (s *Server) DeleteTopic(ctx context.Context, topic string) error {
+	topic, err := cleanTopic(topic)
+	if err != nil {
+		return err
+	}
+	if err = s.permit(ctx, topic, headers.PermissionAdmin); err != nil {
+		return err
+	}
+	return s.deleteTopic(ctx, topic)
+}
+
+// PurgeTopic removes every message of the topic. The topic and its offsets are kept, later messages are
+// produced with the offsets following the purged messages
+func (s *Server) PurgeTopic(ctx context.Context, topic string) (*headers.TopicInfo, error) {
+	topic, err := cleanTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.permit(ctx, topic, headers.PermissionAdmin); err != nil {
+		return nil, err
+	}
+	return s.modifyTopic(ctx, topic, headers.ModifyRequest{Purge: true})
+}
+
+// RedactMessages overwrites the payload of the topic's messages with offsets from through to with the
+// headers.Redacted tombstone, for removing secrets or personal data produced by mistake. The messages keep
+// their offsets, sizes, timestamps and ids
+func (s *Server) RedactMessages(ctx context.Context, topic string, from, to int64) (*headers.TopicInfo, error) {
+	topic, err := cleanTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.permit(ctx, topic, headers.PermissionAdmin); err != nil {
+		return nil, err
+	}
+	return s.modifyTopic(ctx, topic, headers.ModifyRequest{Redact: &headers.OffsetRange{From: from, To: to}})
+}
+
+// ModifyTopic truncates the topic by message offset or modification time, deletes or redacts a range of
+// messages, purges every message or updates the topic's configuration.
+// If the request truncates nothing the topic is left unchanged and nil info is returned
+func (s *Server) ModifyTopic(ctx context.Context, topic string, request headers.ModifyRequest) (*headers.TopicInfo, error) {
+	topic, err := cleanTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.permit(ctx, topic, headers.PermissionAdmin); err != nil {
+		return nil, err
+	}
+	if isEmptyModify(request) {
+		return nil, nil
+	}
+	return s.modifyTopic(ctx, topic, request)
+}
+
+// ListTopics returns the topics in the queue, filtered by prefix, suffix and/or a regex expression
+func (s *Server) ListTopics(ctx context.Context, prefix, suffix, regex string) ([]string, error) {
+	span := s.startSpan(ctx, "queue.ListTopics", "")
+	topics, err := s.q.ListTopics(ctx, prefix, suffix, regex)
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		s.logError("unable to list topics", err)
+		return nil, err
+	}
+	return topics, nil
+}
+
+// InspectTopic returns the first and last offsets of the topic
+func (s *Server) InspectTopic(ctx context.Context, topic string) (*headers.TopicInfo, error) {
+	topic, err := cleanTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.permit(ctx, topic, headers.PermissionConsume); err != nil {
+		return nil, err
+	}
+	span := s.startSpan(ctx, "queue.InspectTopic", topic)
+	info, err := s.q.InspectTopic(topic)
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		s.logError("unable to inspect topic", err, "topic", topic)
+		return nil, err
+	}
+	return info, nil
+}
+
+// ProduceMsgs adds the messages to the end of the topic
+func (s *Server) ProduceMsgs(ctx context.Context, topic string, msgs ...[]byte) error {
+	topic, err := cleanTopic(topic)
+	if err != nil {
+		return err
+	}
+	if err = s.permit(ctx, topic, headers.PermissionProduce); err != nil {
+		return err
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	sizes := make([]int64, len(msgs))
+	for i := range msgs {
+		sizes[i] = int64(len(msgs[i]))
+	}
+	_, err = s.produce(ctx, topic, sizes, bytes.NewReader(bytes.Join(msgs, nil)), s.autoCreateTopics)
+	return err
+}
+
+// ConsumeMsgs returns up to limit messages from the topic starting at id. If no messages are
+// available an empty slice is returned. If limit is less than 1, the default consume limit is used
+func (s *Server) ConsumeMsgs(ctx context.Context, topic string, id, limit int64) ([][]byte, error) {
+	return s.ConsumeGroupMsgs(ctx, topic, "", id, limit)
+}
+
+// ConsumeGroupMsgs is ConsumeMsgs on behalf of a consumer group, the group's offset is recorded for lag monitoring
+func (s *Server) ConsumeGroupMsgs(ctx context.Context, topic, group string, id, limit int64) ([][]byte, error) {
+	topic, err := cleanTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.permit(ctx, topic, headers.PermissionConsume); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = s.consumeLimit(topic)
+	}
+	w := &bufferWriter{header: make(http.Header)}
+	count, err := s.consume(ctx, topic, id, limit, w)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return [][]byte{}, nil
+	}
+	if w.status != 0 && w.status != http.StatusOK && w.status != http.StatusPartialContent {
+		return nil, errors.Errorf("unable to read messages, unexpected status %d", w.status)
+	}
+	sizes, err := headers.ReadSizes(w.header)
+	if err != nil {
+		return nil, err
+	}
+	body := w.buf.Bytes()
+	msgs := make([][]byte, len(sizes))
+	for i, size := range sizes {
+		if int64(len(body)) < size {
+			return nil, errors.New("unable to read messages, response body too short")
+		}
+		msgs[i], body = body[:size:size], body[size:]
+	}
+	s.commitGroup(group, topic, id, len(msgs))
+	return msgs, nil
+}
+
+// bufferWriter is an in memory http.ResponseWriter used to collect consumed messages
+type bufferWriter struct {
+	header http.Header
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *bufferWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.buf.Write(b)
+}
+
+func (w *bufferWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
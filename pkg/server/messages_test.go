@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestServer_Messages(t *testing.T) {
+	dir := ".haraqa-msgs"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	var produced, consumed int
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithHooks(Hooks{
+		OnProduce: func(topic string, sizes []int64) { produced += len(sizes) },
+		OnConsume: func(topic string, id int64, count int) { consumed += count },
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	if err = s.CreateTopic(ctx, ""); errors.Cause(err) != headers.ErrInvalidTopic {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(ctx, "Msgs", []byte("hello")); errors.Cause(err) != headers.ErrTopicDoesNotExist {
+		t.Fatal(err)
+	}
+	if err = s.CreateTopic(ctx, "Msgs"); err != nil {
+		t.Fatal(err)
+	}
+	topics, err := s.ListTopics(ctx, "", "", "")
+	if err != nil || !reflect.DeepEqual(topics, []string{"msgs"}) {
+		t.Fatal(topics, err)
+	}
+
+	msgs, err := s.ConsumeMsgs(ctx, "msgs", 0, 10)
+	if err != nil || len(msgs) != 0 {
+		t.Fatal(msgs, err)
+	}
+	if err = s.ProduceMsgs(ctx, "msgs", []byte("hello"), []byte(""), []byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err = s.ConsumeMsgs(ctx, "msgs", 0, 10)
+	if err != nil || !reflect.DeepEqual(msgs, [][]byte{[]byte("hello"), {}, []byte("world")}) {
+		t.Fatal(msgs, err)
+	}
+	msgs, err = s.ConsumeMsgs(ctx, "msgs", 2, -1)
+	if err != nil || !reflect.DeepEqual(msgs, [][]byte{[]byte("world")}) {
+		t.Fatal(msgs, err)
+	}
+
+	info, err := s.InspectTopic(ctx, "msgs")
+	if err != nil || info.MinOffset != 0 || info.MaxOffset != 2 || info.Size == 0 {
+		t.Fatal(info, err)
+	}
+	if _, err = s.InspectTopic(ctx, "missing"); errors.Cause(err) != headers.ErrTopicDoesNotExist {
+		t.Fatal(err)
+	}
+
+	msgs, err = s.ConsumeGroupMsgs(ctx, "msgs", "group", 2, 1)
+	if err != nil || len(msgs) != 1 {
+		t.Fatal(msgs, err)
+	}
+	if offsets := s.groupOffsets.snapshot(); !reflect.DeepEqual(offsets, map[string]map[string]int64{"msgs": {"group": 3}}) {
+		t.Fatal(offsets)
+	}
+	if produced != 3 || consumed != 5 {
+		t.Error(produced, consumed)
+	}
+
+	if info, err = s.ModifyTopic(ctx, "msgs", headers.ModifyRequest{}); err != nil || info != nil {
+		t.Fatal(info, err)
+	}
+	if _, err = s.ModifyTopic(ctx, "missing", headers.ModifyRequest{Truncate: 1}); err == nil {
+		t.Fatal(err)
+	}
+	if info, err = s.RedactMessages(ctx, "Msgs", 0, 0); err != nil || info.MaxOffset != 2 {
+		t.Fatal(info, err)
+	}
+	msgs, err = s.ConsumeMsgs(ctx, "msgs", 0, 2)
+	if err != nil || len(msgs) != 2 || !headers.IsRedacted(msgs[0]) || len(msgs[0]) != 5 {
+		t.Fatal(msgs, err)
+	}
+	if _, err = s.RedactMessages(ctx, "msgs", 2, 1); err != headers.ErrInvalidMessageID {
+		t.Fatal(err)
+	}
+	if err = s.DeleteTopic(ctx, "Msgs"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteTopic(ctx, "."); errors.Cause(err) != headers.ErrInvalidTopic {
+		t.Fatal(err)
+	}
+	if offsets := s.groupOffsets.snapshot(); len(offsets) != 0 {
+		t.Fatal(offsets)
+	}
+}
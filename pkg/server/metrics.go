@@ -1,14 +1,51 @@
 package server
 
-// Metrics allows for custom metric handlers for counting the number of messages and/or batch size
+import "time"
+
+// Metrics allows for custom metric handlers for counting the number of messages and/or batch size, the
+// latency and errors of queue operations and the state of the queue
 type Metrics interface {
 	ProduceMsgs(int)
 	ConsumeMsgs(int)
+	ProduceBytes(n int64)
+	ConsumeBytes(n int64)
+	TopicProduceBytes(topic string, n int64)
+	TopicConsumeBytes(topic string, n int64)
+	QueueLatency(op string, d time.Duration)
+	QueueError(op, code string)
+	TopicDepth(topic string, depth int64)
+	DiskUsage(dir string, total, free int64)
+	TopicDiskUsage(topic string, size int64)
+	ConsumerLag(group, topic string, lag int64)
+	SlowRequest(method string)
+	FileCache(cache string, hits, misses, evictions int64)
+	OpenFiles(n int64)
+	FileRejections(n int64)
+	ReadOnly(readOnly bool)
+	ShedRequest(op string)
+	JanitorTask(task string, removed int64, d time.Duration)
 }
 
 var _ Metrics = noOpMetrics{}
 
 type noOpMetrics struct{}
 
-func (noOpMetrics) ProduceMsgs(int) {}
-func (noOpMetrics) ConsumeMsgs(int) {}
+func (noOpMetrics) ProduceMsgs(int)                          {}
+func (noOpMetrics) ConsumeMsgs(int)                          {}
+func (noOpMetrics) ProduceBytes(int64)                       {}
+func (noOpMetrics) ConsumeBytes(int64)                       {}
+func (noOpMetrics) TopicProduceBytes(string, int64)          {}
+func (noOpMetrics) TopicConsumeBytes(string, int64)          {}
+func (noOpMetrics) QueueLatency(string, time.Duration)       {}
+func (noOpMetrics) QueueError(string, string)                {}
+func (noOpMetrics) TopicDepth(string, int64)                 {}
+func (noOpMetrics) DiskUsage(string, int64, int64)           {}
+func (noOpMetrics) TopicDiskUsage(string, int64)             {}
+func (noOpMetrics) ConsumerLag(string, string, int64)        {}
+func (noOpMetrics) SlowRequest(string)                       {}
+func (noOpMetrics) FileCache(string, int64, int64, int64)    {}
+func (noOpMetrics) OpenFiles(int64)                          {}
+func (noOpMetrics) FileRejections(int64)                     {}
+func (noOpMetrics) ReadOnly(bool)                            {}
+func (noOpMetrics) ShedRequest(string)                       {}
+func (noOpMetrics) JanitorTask(string, int64, time.Duration) {}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// WithNetworkACL rejects requests with ErrForbidden if their client address is in a denied network, or if
+// allowed networks are given and the address is in none of them. Networks are CIDRs or single addresses.
+// Requests from trusted proxies are made on behalf of the client in their X-Forwarded-For header, the
+// rightmost address which is not a trusted proxy, and their remote address is replaced with it so that
+// quotas and logs see the client as well
+func WithNetworkACL(allow, deny, trustedProxies []string) Option {
+	return func(s *Server) error {
+		n := &networkACL{}
+		var err error
+		if n.allow, err = parseNetworks(allow); err != nil {
+			return errors.Wrap(err, "invalid allowed network")
+		}
+		if n.deny, err = parseNetworks(deny); err != nil {
+			return errors.Wrap(err, "invalid denied network")
+		}
+		if n.proxies, err = parseNetworks(trustedProxies); err != nil {
+			return errors.Wrap(err, "invalid trusted proxy")
+		}
+		s.network = n
+		return nil
+	}
+}
+
+// parseNetworks parses CIDRs and single addresses, ignoring empty entries
+func parseNetworks(entries []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, errors.Errorf("%q is not an address or cidr", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// networkACL holds the allowed and denied networks and the trusted proxies
+type networkACL struct {
+	allow   []*net.IPNet
+	deny    []*net.IPNet
+	proxies []*net.IPNet
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client which sent the request, following the X-Forwarded-For header
+// through trusted proxies. It returns nil if the address cannot be parsed
+func (n *networkACL) clientIP(r *http.Request) net.IP {
+	ip := net.ParseIP(remoteIP(r))
+	if ip == nil || !containsIP(n.proxies, ip) {
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			// an address which cannot be parsed cannot be trusted to have come from a proxy
+			break
+		}
+		ip = hop
+		if !containsIP(n.proxies, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// permitted returns true if the address is not denied and is allowed
+func (n *networkACL) permitted(ip net.IP) bool {
+	if ip == nil || containsIP(n.deny, ip) {
+		return false
+	}
+	return len(n.allow) == 0 || containsIP(n.allow, ip)
+}
+
+// FilterNetwork wraps the handler so that it rejects clients like the server's endpoints when a network ACL
+// is set with WithNetworkACL. It protects pages served beside the server, such as the debug and admin pages
+func (s *Server) FilterNetwork(next http.Handler) http.Handler {
+	if s.network == nil {
+		return next
+	}
+	return s.filterNetwork(next)
+}
+
+// filterNetwork rejects requests from clients which the network ACL does not permit, replacing the remote
+// address of requests forwarded by trusted proxies with the address of their client
+func (s *Server) filterNetwork(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := s.network.clientIP(r)
+		if !s.network.permitted(ip) {
+			s.logger.Warn("request from forbidden address", "remote", r.RemoteAddr, "client", ip.String())
+			headers.SetError(w, headers.ErrForbidden)
+			return
+		}
+		if len(s.network.proxies) > 0 && ip.String() != remoteIP(r) {
+			r = r.WithContext(r.Context())
+			r.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestWithNetworkACL(t *testing.T) {
+	s := &Server{}
+	for _, networks := range [][]string{{"10.0.0.0/33"}, {"not an ip"}} {
+		if err := WithNetworkACL(networks, nil, nil)(s); err == nil {
+			t.Fatal("expected invalid allowed network", networks)
+		}
+		if err := WithNetworkACL(nil, networks, nil)(s); err == nil {
+			t.Fatal("expected invalid denied network", networks)
+		}
+		if err := WithNetworkACL(nil, nil, networks)(s); err == nil {
+			t.Fatal("expected invalid trusted proxy", networks)
+		}
+	}
+	if err := WithNetworkACL([]string{"10.0.0.0/8", " ", "::1"}, []string{"10.0.0.5"}, []string{"192.168.0.0/16"})(s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.network.allow) != 2 || len(s.network.deny) != 1 || len(s.network.proxies) != 1 {
+		t.Fatal(s.network)
+	}
+}
+
+func TestServer_FilterNetwork(t *testing.T) {
+	dir := ".haraqa-network"
+	defer os.RemoveAll(dir)
+	var remote string
+	record := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			remote = r.RemoteAddr
+			next.ServeHTTP(w, r)
+		})
+	}
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithMiddleware(record),
+		WithNetworkACL([]string{"10.0.0.0/8", "::1"}, []string{"10.0.0.5"}, []string{"192.168.0.0/16"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	tests := []struct {
+		remote    string
+		forwarded []string
+		code      int
+		client    string
+	}{
+		{remote: "10.1.2.3:5000", code: http.StatusOK, client: "10.1.2.3:5000"},
+		{remote: "[::1]:5000", code: http.StatusOK, client: "[::1]:5000"},
+		{remote: "10.0.0.5:5000", code: http.StatusForbidden},
+		{remote: "172.16.0.1:5000", code: http.StatusForbidden},
+		{remote: "bad", code: http.StatusForbidden},
+		// the forwarded client of a trusted proxy is checked
+		{remote: "192.168.1.1:5000", forwarded: []string{"10.1.1.1"}, code: http.StatusOK, client: "10.1.1.1:0"},
+		{remote: "192.168.1.1:5000", forwarded: []string{"172.16.0.1"}, code: http.StatusForbidden},
+		{remote: "192.168.1.1:5000", forwarded: []string{"10.0.0.5, 192.168.1.2"}, code: http.StatusForbidden},
+		{remote: "192.168.1.1:5000", forwarded: []string{"172.16.0.1, 10.1.1.1", "192.168.1.2"}, code: http.StatusOK, client: "10.1.1.1:0"},
+		{remote: "192.168.1.1:5000", forwarded: []string{"10.1.1.1, junk"}, code: http.StatusForbidden},
+		// the forwarded header of an untrusted client is ignored
+		{remote: "10.1.2.3:5000", forwarded: []string{"10.0.0.5"}, code: http.StatusOK, client: "10.1.2.3:5000"},
+		{remote: "172.16.0.1:5000", forwarded: []string{"10.1.1.1"}, code: http.StatusForbidden},
+	}
+	for i, test := range tests {
+		remote = ""
+		r := httptest.NewRequest(http.MethodGet, "/topics", nil)
+		r.RemoteAddr = test.remote
+		for _, f := range test.forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != test.code || remote != test.client {
+			t.Fatal(i, w.Code, remote)
+		}
+		if test.code == http.StatusForbidden && headers.ReadErrors(w.Header()) != headers.ErrForbidden {
+			t.Fatal(i, w.Header())
+		}
+	}
+
+	// pages served beside the server are filtered the same way
+	teapot := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	r := httptest.NewRequest(http.MethodGet, "/debug/queue", nil)
+	w := httptest.NewRecorder()
+	s.FilterNetwork(teapot).ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatal(w.Code)
+	}
+	w = httptest.NewRecorder()
+	(&Server{}).FilterNetwork(teapot).ServeHTTP(w, r)
+	if w.Code != http.StatusTeapot {
+		t.Fatal(w.Code)
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// OffsetsTopic is the internal topic the offsets and members of consumer groups are stored in
+const OffsetsTopic = "__offsets"
+
+// offsetsCompaction is the number of records written to the offsets topic between compactions
+var offsetsCompaction = 1000
+
+// WithOffsetsTopic stores the committed offsets and the members of consumer groups in the internal __offsets
+// topic, restoring them when the server starts. Because consumer state is kept in a topic, backups and
+// mirrors of the queue's topics carry it with the messages. Members restored from the topic keep their
+// topics for one session timeout, so that consumers which heartbeat after a restart are not rebalanced.
+// The topic is compacted to the latest state of each group every 1000 records, and produces to it are rejected
+func WithOffsetsTopic(enabled bool) Option {
+	return func(s *Server) error {
+		s.offsets = nil
+		if enabled {
+			s.offsets = &offsetsLog{}
+		}
+		return nil
+	}
+}
+
+// offsetsRecord is a message of the offsets topic, holding either offsets committed by a group or the
+// members of a group. A membership record without members removes the group
+type offsetsRecord struct {
+	Group      string                  `json:"group"`
+	Offsets    map[string]int64        `json:"offsets,omitempty"`
+	Membership bool                    `json:"membership,omitempty"`
+	Generation int64                   `json:"generation,omitempty"`
+	Members    map[string]memberRecord `json:"members,omitempty"`
+}
+
+// memberRecord is a member of a consumer group, with the topics it subscribes to and is assigned
+type memberRecord struct {
+	Topics   []string `json:"topics"`
+	Assigned []string `json:"assigned,omitempty"`
+}
+
+// offsetsLog counts the records written to the offsets topic, its lock serializes writes and compactions
+type offsetsLog struct {
+	mux     sync.Mutex
+	records int
+}
+
+// restoreOffsets creates the offsets topic if it does not exist, then applies each of its records
+func (s *Server) restoreOffsets(ctx context.Context) error {
+	err := s.q.CreateTopic(OffsetsTopic)
+	if err != nil && errors.Cause(err) != headers.ErrTopicAlreadyExists {
+		return errors.Wrap(err, "unable to create offsets topic")
+	}
+	info, err := s.q.InspectTopic(OffsetsTopic)
+	if err != nil {
+		return errors.Wrap(err, "unable to inspect offsets topic")
+	}
+	for id := info.MinOffset; id <= info.MaxOffset; {
+		msgs, err := s.ConsumeMsgs(ctx, OffsetsTopic, id, 0)
+		if err != nil {
+			return errors.Wrap(err, "unable to read offsets topic")
+		}
+		if len(msgs) == 0 {
+			break
+		}
+		for _, msg := range msgs {
+			var record offsetsRecord
+			if err = json.Unmarshal(msg, &record); err != nil {
+				return errors.Wrapf(err, "invalid offsets record %d", id)
+			}
+			s.applyOffsets(record)
+			id++
+		}
+		s.offsets.records += len(msgs)
+	}
+	return nil
+}
+
+// applyOffsets restores the state of the consumer group in the record
+func (s *Server) applyOffsets(record offsetsRecord) {
+	if record.Membership {
+		s.groups.restore(record)
+		return
+	}
+	for topic, offset := range record.Offsets {
+		s.groupOffsets.set(record.Group, topic, offset)
+	}
+}
+
+// writeOffsets appends the records to the offsets topic if it is enabled, compacting the topic once
+// enough records have been written since the last compaction
+func (s *Server) writeOffsets(records ...*offsetsRecord) error {
+	if s.offsets == nil || len(records) == 0 {
+		return nil
+	}
+	s.offsets.mux.Lock()
+	defer s.offsets.mux.Unlock()
+
+	if err := s.appendOffsets(records); err != nil {
+		return errors.Wrap(err, "unable to write offsets topic")
+	}
+	s.offsets.records += len(records)
+	if s.offsets.records < offsetsCompaction {
+		return nil
+	}
+	if err := s.compactOffsets(); err != nil {
+		s.logError("unable to compact offsets topic", err)
+	}
+	return nil
+}
+
+// appendOffsets produces the records to the offsets topic
+func (s *Server) appendOffsets(records []*offsetsRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	sizes := make([]int64, len(records))
+	buf := new(bytes.Buffer)
+	for i, record := range records {
+		b, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		sizes[i] = int64(len(b))
+		buf.Write(b)
+	}
+	return s.q.Produce(context.Background(), OffsetsTopic, sizes, uint64(time.Now().UnixNano()), buf)
+}
+
+// compactOffsets writes the current state of every consumer group to the offsets topic, then truncates the
+// file sets holding only earlier records
+func (s *Server) compactOffsets() error {
+	info, err := s.q.InspectTopic(OffsetsTopic)
+	if err != nil {
+		return err
+	}
+	records := s.groups.records()
+	offsets := make(map[string]*offsetsRecord)
+	for topic, groups := range s.groupOffsets.snapshot() {
+		for group, offset := range groups {
+			record, ok := offsets[group]
+			if !ok {
+				record = &offsetsRecord{Group: group, Offsets: make(map[string]int64)}
+				offsets[group] = record
+				records = append(records, record)
+			}
+			record.Offsets[topic] = offset
+		}
+	}
+	if err = s.appendOffsets(records); err != nil {
+		return err
+	}
+	s.offsets.records = 0
+	_, err = s.q.ModifyTopic(OffsetsTopic, headers.ModifyRequest{Truncate: info.MaxOffset + 1})
+	return err
+}
+
+// isOffsetsTopic returns true if the topic is the offsets topic and the offsets topic is enabled
+func (s *Server) isOffsetsTopic(topic string) bool {
+	return s.offsets != nil && topic == OffsetsTopic
+}
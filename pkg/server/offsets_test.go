@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestWithOffsetsTopic(t *testing.T) {
+	s := &Server{}
+	if err := WithOffsetsTopic(true)(s); err != nil || s.offsets == nil {
+		t.Fatal(s.offsets, err)
+	}
+	if !s.isOffsetsTopic(OffsetsTopic) || s.isOffsetsTopic("topic") {
+		t.Fatal("expected only the offsets topic")
+	}
+	if err := WithOffsetsTopic(false)(s); err != nil || s.offsets != nil || s.isOffsetsTopic(OffsetsTopic) {
+		t.Fatal(s.offsets, err)
+	}
+}
+
+func TestServer_OffsetsTopic(t *testing.T) {
+	dir := ".haraqa-offsets"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	s, err := NewServer(WithFileQueue([]string{dir}, false, 5000), WithOffsetsTopic(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.CreateTopic(ctx, "events"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(ctx, "events", []byte("a"), []byte("b"), []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	joined, err := s.JoinGroup("group", "member", []string{"events", "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.CommitOffsets("group", map[string]int64{"orders": 7}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.ConsumeGroupMsgs(ctx, "events", "group", 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.JoinGroup("left", "member", []string{"events"}); err != nil {
+		t.Fatal(err)
+	}
+	s.LeaveGroup("left", "member")
+
+	// clients cannot write to the offsets topic
+	if err = s.ProduceMsgs(ctx, OffsetsTopic, []byte("{}")); errors.Cause(err) != headers.ErrTopicReadOnly {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the offsets and members are restored when the server restarts
+	s, err = NewServer(WithFileQueue([]string{dir}, false, 5000), WithOffsetsTopic(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if offsets := s.groupOffsets.group("group"); !reflect.DeepEqual(offsets, map[string]int64{"events": 2, "orders": 7}) {
+		t.Fatal(offsets)
+	}
+	description := s.groups.describe("group")
+	if description.Generation != joined.Generation || len(description.Members) != 1 || !reflect.DeepEqual(description.Members[0].Topics, joined.Topics) {
+		t.Fatal(description)
+	}
+	if description = s.groups.describe("left"); len(description.Members) != 0 {
+		t.Fatal(description)
+	}
+	rejoined, err := s.JoinGroup("group", "member", []string{"events", "orders"})
+	if err != nil || rejoined.Generation != joined.Generation || rejoined.Offsets["events"] != 2 {
+		t.Fatal(rejoined, err)
+	}
+}
+
+func TestServer_CompactOffsets(t *testing.T) {
+	dir := ".haraqa-compact-offsets"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	defer func(compaction int) { offsetsCompaction = compaction }(offsetsCompaction)
+	offsetsCompaction = 10
+
+	s, err := NewServer(WithFileQueue([]string{dir}, false, 4), WithOffsetsTopic(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.JoinGroup("group", "member", []string{"events"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i <= 25; i++ {
+		if err = s.CommitOffsets("group", map[string]int64{"events": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := s.q.InspectTopic(OffsetsTopic)
+	if err != nil || info.MinOffset == 0 || info.MaxOffset != 29 {
+		t.Fatal(info, err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewServer(WithFileQueue([]string{dir}, false, 4), WithOffsetsTopic(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if offset, ok := s.groupOffsets.get("group", "events"); !ok || offset != 25 {
+		t.Fatal(offset, ok)
+	}
+	if description := s.groups.describe("group"); len(description.Members) != 1 {
+		t.Fatal(description)
+	}
+}
@@ -0,0 +1,414 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+const (
+	// oidcLeeway is the clock skew allowed when checking the expiry of tokens
+	oidcLeeway = time.Minute
+	// oidcRefresh is the minimum interval between fetches of the issuer's keys, so that tokens signed by
+	// unknown keys cannot make the server hammer the issuer
+	oidcRefresh = 30 * time.Second
+)
+
+// WithOIDC enables authentication of requests with OIDC access tokens, sent as bearer tokens or in the
+// X-Forwarded-Access-Token header by an authenticating proxy. Tokens must be signed by a key of the issuer,
+// found through its discovery document, and have the audience. Requests are made as the token's subject,
+// which ACL rules can grant permissions to. The scopes map token scopes to the permissions they grant, each
+// a permission or a permission:topic, where the topic defaults to every topic and may end in "*" like the
+// topics of ACL rules. If scopes are mapped, requests are authorized against the permissions granted by
+// their scopes as well as any ACL rules
+func WithOIDC(issuer, audience string, scopes map[string][]string) Option {
+	return func(s *Server) error {
+		if issuer == "" {
+			return errors.New("invalid oidc issuer: issuer cannot be empty")
+		}
+		o := &oidcVerifier{
+			issuer:   strings.TrimSuffix(issuer, "/"),
+			audience: audience,
+			scopes:   make(map[string][]headers.ACLRule, len(scopes)),
+			client:   &http.Client{Timeout: 10 * time.Second},
+		}
+		for scope, permissions := range scopes {
+			rules := make([]headers.ACLRule, 0, len(permissions))
+			for _, p := range permissions {
+				topic := "*"
+				if i := strings.IndexByte(p, ':'); i >= 0 {
+					p, topic = p[:i], p[i+1:]
+				}
+				rules = append(rules, headers.ACLRule{ID: scope + " " + p + ":" + topic, Principal: "*", Topic: topic, Permissions: []string{p}})
+			}
+			rules, err := cleanRules(rules)
+			if err != nil {
+				return errors.Wrapf(err, "invalid permissions of scope %q", scope)
+			}
+			o.scopes[scope] = rules
+		}
+		s.oidc = o
+		return nil
+	}
+}
+
+// oidcVerifier verifies tokens against the keys of the issuer, which are fetched on first use and again
+// when a token is signed by an unknown key
+type oidcVerifier struct {
+	issuer   string
+	audience string
+	scopes   map[string][]headers.ACLRule
+	client   *http.Client
+
+	mux     sync.RWMutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// oidcClaims are the claims of an access token used by the verifier
+type oidcClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Audience  json.RawMessage `json:"aud"`
+	Expires   *int64          `json:"exp"`
+	NotBefore *int64          `json:"nbf"`
+	Scope     string          `json:"scope"`
+	Scp       json.RawMessage `json:"scp"`
+	ClientID  string          `json:"client_id"`
+	AZP       string          `json:"azp"`
+}
+
+// verify checks the token and returns its principal and the permissions granted by its scopes
+func (o *oidcVerifier) verify(ctx context.Context, token string) (string, []headers.ACLRule, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", nil, errors.Wrap(err, "malformed token header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, errors.Wrap(err, "malformed token signature")
+	}
+	key, err := o.key(ctx, header.Kid)
+	if err != nil {
+		return "", nil, err
+	}
+	if err = verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return "", nil, err
+	}
+
+	var claims oidcClaims
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return "", nil, errors.Wrap(err, "malformed token claims")
+	}
+	now := time.Now()
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != o.issuer:
+		return "", nil, errors.Errorf("token issuer %q is not trusted", claims.Issuer)
+	case claims.Expires == nil || now.After(time.Unix(*claims.Expires, 0).Add(oidcLeeway)):
+		return "", nil, errors.New("token has expired")
+	case claims.NotBefore != nil && now.Add(oidcLeeway).Before(time.Unix(*claims.NotBefore, 0)):
+		return "", nil, errors.New("token is not valid yet")
+	case o.audience != "" && !containsString(stringOrList(claims.Audience), o.audience):
+		return "", nil, errors.Errorf("token is not for audience %q", o.audience)
+	}
+
+	principal := claims.Subject
+	if principal == "" {
+		principal = claims.ClientID
+	}
+	if principal == "" {
+		principal = claims.AZP
+	}
+	if principal == "" {
+		return "", nil, errors.New("token has no subject")
+	}
+
+	var grants []headers.ACLRule
+	for _, scope := range append(strings.Fields(claims.Scope), stringOrList(claims.Scp)...) {
+		grants = append(grants, o.scopes[scope]...)
+	}
+	return principal, grants, nil
+}
+
+// key returns the issuer's key with the id, fetching the keys if it is not known
+func (o *oidcVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	o.mux.RLock()
+	key, ok := findKey(o.keys, kid)
+	o.mux.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	if key, ok = findKey(o.keys, kid); ok {
+		return key, nil
+	}
+	if time.Since(o.fetched) < oidcRefresh {
+		return nil, errors.Errorf("unknown token key %q", kid)
+	}
+	keys, err := o.fetchKeys(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch oidc keys")
+	}
+	o.keys, o.fetched = keys, time.Now()
+	if key, ok = findKey(o.keys, kid); ok {
+		return key, nil
+	}
+	return nil, errors.Errorf("unknown token key %q", kid)
+}
+
+// findKey returns the key with the id, or the only key if the id is empty
+func findKey(keys map[string]crypto.PublicKey, kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	key, ok := keys[kid]
+	return key, ok
+}
+
+// fetchKeys finds the issuer's key set through its discovery document and fetches the keys
+func (o *oidcVerifier) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := o.getJSON(ctx, o.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != o.issuer {
+		return nil, errors.Errorf("discovery document is for issuer %q", discovery.Issuer)
+	}
+	if discovery.JWKSURI == "" {
+		return nil, errors.New("discovery document has no jwks_uri")
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := o.getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !curve.IsOnCurve(key.X, key.Y) {
+				continue
+			}
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+// getJSON decodes the json response of a GET request to the url
+func (o *oidcVerifier) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// verifySignature checks the signature of the signed part of a token with the algorithm and key
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return errors.Errorf("unsupported token algorithm %q", alg)
+	}
+	h := hash.New()
+	_, _ = h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' || rsa.VerifyPKCS1v15(key, hash, digest, sig) != nil {
+			return errors.New("invalid token signature")
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg[0] != 'E' || len(sig) != 2*size {
+			return errors.New("invalid token signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.New("invalid token signature")
+		}
+	default:
+		return errors.New("invalid token signature")
+	}
+	return nil
+}
+
+// decodeSegment decodes a base64url encoded json segment of a token
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// stringOrList decodes a claim which is either a string or a list of strings
+func stringOrList(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		return list
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return strings.Fields(s)
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type grantsKey struct{}
+
+// withGrants returns a copy of the context carrying the permissions granted to a request by its token
+func withGrants(ctx context.Context, grants []headers.ACLRule) context.Context {
+	return context.WithValue(ctx, grantsKey{}, grants)
+}
+
+// granted returns true if the permissions granted by the token of the request include the permission on
+// the topic
+func granted(ctx context.Context, topic, permission string) bool {
+	grants, _ := ctx.Value(grantsKey{}).([]headers.ACLRule)
+	for _, rule := range grants {
+		if !matchTopic(rule.Topic, topic) {
+			continue
+		}
+		for _, p := range rule.Permissions {
+			if p == permission || p == headers.PermissionAdmin {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bearerToken returns the access token of the request, sent as a bearer token or by an authenticating
+// proxy in the X-Forwarded-Access-Token header
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > len("bearer ") && strings.EqualFold(auth[:len("bearer ")], "bearer ") {
+		return strings.TrimSpace(auth[len("bearer "):])
+	}
+	return r.Header.Get("X-Forwarded-Access-Token")
+}
+
+// verifyTokens sets the principal and granted permissions of requests with a valid access token. Requests
+// with an invalid token are rejected with ErrUnauthorized, as are requests without one unless a middleware
+// has already authenticated them or they can authenticate with the user store. Preflight requests are not
+// authenticated
+func (s *Server) verifyTokens(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := bearerToken(r)
+		if token == "" {
+			if s.users != nil || Principal(r.Context()) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="haraqa"`)
+			headers.SetError(w, headers.ErrUnauthorized)
+			return
+		}
+		principal, grants, err := s.oidc.verify(r.Context(), token)
+		if err != nil {
+			s.logger.Warn("invalid access token", "error", err.Error())
+			w.Header().Set("WWW-Authenticate", `Bearer realm="haraqa", error="invalid_token"`)
+			headers.SetError(w, headers.ErrUnauthorized)
+			return
+		}
+		ctx := withGrants(WithPrincipal(r.Context(), principal), grants)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+// testIssuer serves the discovery document and keys of an OIDC issuer and signs tokens with them
+type testIssuer struct {
+	*httptest.Server
+	rsaKey  *rsa.PrivateKey
+	ecKey   *ecdsa.PrivateKey
+	fetches int32
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+	b64 := base64.RawURLEncoding.EncodeToString
+	iss.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"issuer": iss.URL, "jwks_uri": iss.URL + "/keys"})
+		case "/keys":
+			atomic.AddInt32(&iss.fetches, 1)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+				{"kid": "rsa", "kty": "RSA", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+				{"kid": "ec", "kty": "EC", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+			}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return iss
+}
+
+func (iss *testIssuer) token(t *testing.T, kid string, claims map[string]interface{}) string {
+	alg := "RS256"
+	if kid == "ec" {
+		alg = "ES256"
+	}
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	if kid == "ec" {
+		r, s, err := ecdsa.Sign(rand.Reader, iss.ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+	} else {
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, iss.rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCVerifier(t *testing.T) {
+	iss := newTestIssuer(t)
+	defer iss.Close()
+	s := &Server{}
+	err := WithOIDC(iss.URL+"/", "haraqa", map[string][]string{"read": {"consume"}, "orders": {"produce:orders*"}})(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	exp := time.Now().Add(time.Hour).Unix()
+
+	principal, grants, err := s.oidc.verify(ctx, iss.token(t, "rsa", map[string]interface{}{
+		"iss": iss.URL, "sub": "alice", "aud": []string{"other", "haraqa"}, "exp": exp, "scope": "openid read orders",
+	}))
+	if err != nil || principal != "alice" || len(grants) != 2 {
+		t.Fatal(principal, grants, err)
+	}
+	principal, grants, err = s.oidc.verify(ctx, iss.token(t, "ec", map[string]interface{}{
+		"iss": iss.URL, "client_id": "svc", "aud": "haraqa", "exp": exp, "scp": []string{"orders"},
+	}))
+	if err != nil || principal != "svc" || len(grants) != 1 || grants[0].Topic != "orders*" {
+		t.Fatal(principal, grants, err)
+	}
+
+	for name, claims := range map[string]map[string]interface{}{
+		"expired":  {"iss": iss.URL, "sub": "a", "aud": "haraqa", "exp": time.Now().Add(-time.Hour).Unix()},
+		"no exp":   {"iss": iss.URL, "sub": "a", "aud": "haraqa"},
+		"audience": {"iss": iss.URL, "sub": "a", "aud": "other", "exp": exp},
+		"issuer":   {"iss": "https://example.com", "sub": "a", "aud": "haraqa", "exp": exp},
+		"subject":  {"iss": iss.URL, "aud": "haraqa", "exp": exp},
+		"nbf":      {"iss": iss.URL, "sub": "a", "aud": "haraqa", "exp": exp, "nbf": time.Now().Add(time.Hour).Unix()},
+	} {
+		if _, _, err = s.oidc.verify(ctx, iss.token(t, "rsa", claims)); err == nil {
+			t.Fatal("expected error for", name)
+		}
+	}
+
+	// tampered and unknown tokens are rejected without fetching the keys again
+	token := iss.token(t, "rsa", map[string]interface{}{"iss": iss.URL, "sub": "a", "aud": "haraqa", "exp": exp})
+	parts := strings.Split(token, ".")
+	claims, _ := json.Marshal(map[string]interface{}{"iss": iss.URL, "sub": "root", "aud": "haraqa", "exp": exp})
+	if _, _, err = s.oidc.verify(ctx, parts[0]+"."+base64.RawURLEncoding.EncodeToString(claims)+"."+parts[2]); err == nil {
+		t.Fatal("expected invalid signature")
+	}
+	if _, _, err = s.oidc.verify(ctx, iss.token(t, "unknown", map[string]interface{}{"iss": iss.URL})); err == nil {
+		t.Fatal("expected unknown key")
+	}
+	if _, _, err = s.oidc.verify(ctx, "not.a-token"); err == nil {
+		t.Fatal("expected malformed token")
+	}
+	if n := atomic.LoadInt32(&iss.fetches); n != 1 {
+		t.Fatal(n)
+	}
+
+	if err = WithOIDC("", "", nil)(s); err == nil {
+		t.Fatal("expected invalid issuer")
+	}
+	if err = WithOIDC(iss.URL, "", map[string][]string{"x": {"write"}})(s); err == nil {
+		t.Fatal("expected invalid permission")
+	}
+}
+
+func TestServer_OIDC(t *testing.T) {
+	iss := newTestIssuer(t)
+	defer iss.Close()
+	dir := ".haraqa-oidc"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithOIDC(iss.URL, "haraqa", map[string][]string{
+		"haraqa.read":  {"consume"},
+		"haraqa.admin": {"admin"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	exp := time.Now().Add(time.Hour).Unix()
+	admin := iss.token(t, "rsa", map[string]interface{}{"iss": iss.URL, "sub": "ops", "aud": "haraqa", "exp": exp, "scope": "haraqa.admin"})
+	reader := iss.token(t, "ec", map[string]interface{}{"iss": iss.URL, "sub": "app", "aud": "haraqa", "exp": exp, "scope": "haraqa.read"})
+
+	request := func(token, method, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request("", http.MethodGet, "/topics")
+	if w.Code != http.StatusUnauthorized || headers.ReadErrors(w.Header()) != headers.ErrUnauthorized || !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Bearer") {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = request("invalid", http.MethodGet, "/topics"); w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), "invalid_token") {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = request(admin, http.MethodPut, "/topics/orders"); w.Code != http.StatusCreated {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = request(reader, http.MethodPut, "/topics/payments"); w.Code != http.StatusForbidden {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = request(reader, http.MethodPost, "/topics/orders"); w.Code != http.StatusForbidden {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = request(reader, http.MethodGet, "/topics/orders?id=0"); w.Code == http.StatusForbidden || w.Code == http.StatusUnauthorized {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	// tokens forwarded by a proxy are accepted
+	r := httptest.NewRequest(http.MethodGet, "/topics", nil)
+	r.Header.Set("X-Forwarded-Access-Token", reader)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	// admin pages require an admin token
+	page := s.RequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	for token, code := range map[string]int{"": http.StatusUnauthorized, reader: http.StatusForbidden, admin: http.StatusTeapot} {
+		r = httptest.NewRequest(http.MethodGet, "/debug/queue", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w = httptest.NewRecorder()
+		page.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Fatal(w.Code, code)
+		}
+	}
+
+	// handlers served beside the server, such as the grpc api, call the message methods as the token's subject
+	listener := s.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers.SetError(w, s.ProduceMsgs(r.Context(), "orders", []byte("order")))
+	}))
+	for token, code := range map[string]int{"": http.StatusUnauthorized, reader: http.StatusForbidden, admin: http.StatusOK} {
+		r = httptest.NewRequest(http.MethodPost, "/haraqa.v1.Haraqa/Produce", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w = httptest.NewRecorder()
+		listener.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Fatal(w.Code, code)
+		}
+	}
+}
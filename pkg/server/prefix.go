@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// prefixBatch is the messages consumed from one of the topics matching a prefix
+type prefixBatch struct {
+	topic      string
+	start      int64
+	sizes      []int64
+	timestamps []time.Time
+	ids        []string
+	types      []string
+	body       []byte
+	next       int
+}
+
+// consumePrefix merges the messages of every topic starting with prefix into one response, ordered by the
+// time they were produced. Each topic is read from id, or from the id given for it with a from=topic:id
+// query value, and the topic and offset of each message are sent in the X-Topics and X-Offsets headers
+func (s *Server) consumePrefix(w http.ResponseWriter, r *http.Request, prefix string, id, limit int64) {
+	starts, err := prefixStarts(r)
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+	topics, err := s.ListTopics(r.Context(), prefix, "", "")
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+
+	var batches []*prefixBatch
+	for _, topic := range topics {
+		start, ok := starts[topic]
+		if !ok {
+			start = id
+		}
+		batch, err := s.consumePrefixBatch(r, topic, start, limit)
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		if batch != nil {
+			batches = append(batches, batch)
+		}
+	}
+
+	// merge the batches, taking the earliest message from the front of each until the limit is reached
+	var sizes, offsets []int64
+	var timestamps []time.Time
+	var labels, ids, types []string
+	var hasIDs, hasTypes bool
+	body := new(bytes.Buffer)
+	for limit <= 0 || int64(len(sizes)) < limit {
+		var next *prefixBatch
+		for _, batch := range batches {
+			if batch.next < len(batch.sizes) && (next == nil || batch.timestamps[batch.next].Before(next.timestamps[next.next])) {
+				next = batch
+			}
+		}
+		if next == nil {
+			break
+		}
+		size := next.sizes[next.next]
+		body.Write(next.body[:size])
+		next.body = next.body[size:]
+		sizes = append(sizes, size)
+		offsets = append(offsets, next.start+int64(next.next))
+		timestamps = append(timestamps, next.timestamps[next.next])
+		labels = append(labels, next.topic)
+		if next.ids != nil {
+			ids, hasIDs = append(ids, next.ids[next.next]), true
+		} else {
+			ids = append(ids, headers.MessageID{}.String())
+		}
+		if next.types != nil {
+			types, hasTypes = append(types, next.types[next.next]), true
+		} else {
+			types = append(types, "")
+		}
+		next.next++
+	}
+	if len(sizes) == 0 {
+		headers.SetError(w, headers.ErrNoContent)
+		return
+	}
+
+	h := w.Header()
+	headers.SetSizes(sizes, h)
+	headers.SetTimestamps(timestamps, h)
+	h[headers.HeaderTopics] = labels
+	h[headers.HeaderOffsets] = make([]string, len(offsets))
+	for i := range offsets {
+		h[headers.HeaderOffsets][i] = strconv.FormatInt(offsets[i], 10)
+	}
+	if hasIDs {
+		h[headers.HeaderMessageIDs] = ids
+	}
+	if hasTypes {
+		h[headers.HeaderContentTypes] = types
+	}
+	h[headers.ContentType] = []string{"application/octet-stream"}
+	w.WriteHeader(http.StatusOK)
+	_, _ = body.WriteTo(w)
+
+	for _, batch := range batches {
+		s.commitGroup(r.Header.Get(headers.HeaderGroup), batch.topic, batch.start, batch.next)
+	}
+}
+
+// consumePrefixBatch reads up to limit messages of the topic from id, nil is returned if there are none
+func (s *Server) consumePrefixBatch(r *http.Request, topic string, id, limit int64) (*prefixBatch, error) {
+	// resolve the latest message so that the offsets of the messages are known
+	if id < 0 {
+		info, err := s.InspectTopic(r.Context(), topic)
+		if err != nil {
+			if errors.Cause(err) == headers.ErrTopicDoesNotExist {
+				return nil, nil
+			}
+			return nil, err
+		}
+		id = info.MaxOffset
+	}
+
+	body := new(bytes.Buffer)
+	sw := &streamWriter{w: body, header: make(http.Header)}
+	count, err := s.consume(r.Context(), topic, id, limit, sw)
+	switch {
+	case errors.Cause(err) == headers.ErrTopicDoesNotExist:
+		// the topic was deleted after it was listed
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case count == 0:
+		return nil, nil
+	}
+	sizes, err := sw.sizes(count)
+	if err != nil {
+		return nil, err
+	}
+	timestamps, err := headers.ReadTimestamps(sw.header)
+	if err != nil {
+		return nil, err
+	}
+	if timestamps == nil {
+		// queues which do not record timestamps are merged in topic order
+		timestamps = make([]time.Time, count)
+	}
+	if len(timestamps) != count {
+		return nil, errors.Errorf("unable to read messages, expected %d timestamps but got %d", count, len(timestamps))
+	}
+	batch := &prefixBatch{topic: topic, start: id, sizes: sizes, timestamps: timestamps, body: body.Bytes()}
+	if ids := sw.header[headers.HeaderMessageIDs]; len(ids) == count {
+		batch.ids = ids
+	}
+	if types := sw.header[headers.HeaderContentTypes]; len(types) == count {
+		batch.types = types
+	}
+	return batch, nil
+}
+
+// prefixStarts reads the from=topic:id query values of a prefix consume
+func prefixStarts(r *http.Request) (map[string]int64, error) {
+	values := r.URL.Query()["from"]
+	starts := make(map[string]int64, len(values))
+	for _, v := range values {
+		i := strings.LastIndexByte(v, ':')
+		if i < 0 {
+			return nil, headers.ErrInvalidMessageID
+		}
+		topic, err := cleanTopic(v[:i])
+		if err != nil {
+			return nil, err
+		}
+		starts[topic], err = strconv.ParseInt(v[i+1:], 10, 64)
+		if err != nil {
+			return nil, headers.ErrInvalidMessageID
+		}
+	}
+	return starts, nil
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestServer_ConsumePrefix(t *testing.T) {
+	dir := ".haraqa-prefix"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	for _, topic := range []string{"orders.eu", "orders.us", "other"} {
+		if err = s.CreateTopic(ctx, topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, msg := range []struct{ topic, body string }{
+		{"orders.eu", "a"}, {"orders.us", "b"}, {"other", "x"}, {"orders.eu", "c"}, {"orders.us", "d"},
+	} {
+		if err = s.ProduceMsgs(ctx, msg.topic, []byte(msg.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	consume := func(query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/topics/orders.*?"+query, nil)
+		r.Header.Set(headers.HeaderGroup, "audit")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	// messages are merged in the order they were produced
+	w := consume("id=0")
+	if w.Code != http.StatusOK || w.Body.String() != "abcd" {
+		t.Fatal(w.Code, w.Body.String(), w.Header())
+	}
+	if topics := w.Header()[headers.HeaderTopics]; !reflect.DeepEqual(topics, []string{"orders.eu", "orders.us", "orders.eu", "orders.us"}) {
+		t.Fatal(topics)
+	}
+	if offsets := w.Header()[headers.HeaderOffsets]; !reflect.DeepEqual(offsets, []string{"0", "0", "1", "1"}) {
+		t.Fatal(offsets)
+	}
+	if len(w.Header()[headers.HeaderTimestamps]) != 4 {
+		t.Fatal(w.Header())
+	}
+	if groups := s.groupOffsets.snapshot(); groups["orders.eu"]["audit"] != 2 || groups["orders.us"]["audit"] != 2 || groups["other"] != nil {
+		t.Fatal(groups)
+	}
+
+	// limits apply to the merged stream
+	w = consume("id=0&limit=3")
+	if w.Body.String() != "abc" {
+		t.Fatal(w.Body.String())
+	}
+
+	// topics can start from different offsets
+	w = consume("id=0&from=orders.eu:1")
+	if w.Body.String() != "bcd" || !reflect.DeepEqual(w.Header()[headers.HeaderOffsets], []string{"0", "1", "1"}) {
+		t.Fatal(w.Body.String(), w.Header())
+	}
+
+	// negative ids consume the latest message of each topic
+	w = consume("id=-1")
+	if w.Body.String() != "cd" || !reflect.DeepEqual(w.Header()[headers.HeaderOffsets], []string{"1", "1"}) {
+		t.Fatal(w.Body.String(), w.Header())
+	}
+
+	w = consume("id=2")
+	if w.Code != http.StatusNoContent {
+		t.Fatal(w.Code)
+	}
+	for _, query := range []string{"id=0&from=orders.eu", "id=0&from=orders.eu:x"} {
+		if w = consume(query); headers.ReadErrors(w.Header()) != headers.ErrInvalidMessageID {
+			t.Fatal(query, w.Code, w.Header())
+		}
+	}
+}
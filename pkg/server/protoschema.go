@@ -0,0 +1,266 @@
+package server
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/haraqa/haraqa/internal/protowire"
+	"github.com/pkg/errors"
+)
+
+// field types and labels of google.protobuf.FieldDescriptorProto
+const (
+	protoDouble   = 1
+	protoFloat    = 2
+	protoGroup    = 10
+	protoMessage  = 11
+	protoString   = 9
+	protoBytes    = 12
+	protoFixed64  = 6
+	protoFixed32  = 7
+	protoSfixed32 = 15
+	protoSfixed64 = 16
+
+	labelRequired = 2
+	labelRepeated = 3
+)
+
+type protoField struct {
+	name     string
+	number   int
+	label    int
+	typ      int
+	typeName string
+}
+
+// wireType returns the wire type of the field, packed repeated fields are checked separately
+func (f *protoField) wireType() int {
+	switch f.typ {
+	case protoDouble, protoFixed64, protoSfixed64:
+		return protowire.Fixed64
+	case protoFloat, protoFixed32, protoSfixed32:
+		return protowire.Fixed32
+	case protoString, protoBytes, protoMessage:
+		return protowire.Bytes
+	}
+	return protowire.Varint
+}
+
+type protoMessageType struct {
+	name   string
+	fields map[int]*protoField
+	proto3 bool
+}
+
+// protoSchema validates messages against a message type of a google.protobuf.FileDescriptorSet,
+// as written by protoc --descriptor_set_out. Fields must have the declared wire types, required fields
+// must be set, strings must be valid utf8 in proto3 files and embedded messages are checked recursively.
+// Unknown fields are allowed so that producers may use newer versions of the message
+type protoSchema struct {
+	messages map[string]*protoMessageType
+	root     *protoMessageType
+}
+
+// compileProtoSchema parses the descriptor set and finds the fully qualified message name
+func compileProtoSchema(descriptor []byte, message string) (*protoSchema, error) {
+	s := &protoSchema{messages: make(map[string]*protoMessageType)}
+	d := protowire.NewDecoder(descriptor)
+	for field, ok := d.Next(); ok; field, ok = d.Next() {
+		if field != 1 || d.Wire() != protowire.Bytes {
+			d.Skip()
+			continue
+		}
+		if file := d.Bytes(); d.Err() == nil {
+			if err := s.parseFile(file); err != nil {
+				return nil, errors.Wrap(err, "invalid descriptor set")
+			}
+		}
+	}
+	if err := d.Err(); err != nil {
+		return nil, errors.Wrap(err, "invalid descriptor set")
+	}
+	for _, m := range s.messages {
+		for _, f := range m.fields {
+			if f.typ == protoMessage {
+				if _, ok := s.messages[strings.TrimPrefix(f.typeName, ".")]; !ok {
+					return nil, errors.Errorf("message type %q of field %s.%s not found in descriptor set", f.typeName, m.name, f.name)
+				}
+			}
+		}
+	}
+	s.root = s.messages[strings.TrimPrefix(message, ".")]
+	if s.root == nil {
+		return nil, errors.Errorf("message type %q not found in descriptor set", message)
+	}
+	return s, nil
+}
+
+func (s *protoSchema) parseFile(b []byte) error {
+	var pkg string
+	var messages [][]byte
+	proto3 := false
+	d := protowire.NewDecoder(b)
+	for field, ok := d.Next(); ok; field, ok = d.Next() {
+		if d.Wire() != protowire.Bytes {
+			d.Skip()
+			continue
+		}
+		switch v := d.Bytes(); field {
+		case 2:
+			pkg = string(v)
+		case 4:
+			messages = append(messages, v)
+		case 12:
+			proto3 = string(v) == "proto3"
+		}
+	}
+	if err := d.Err(); err != nil {
+		return err
+	}
+	for _, m := range messages {
+		if err := s.parseMessage(m, pkg, proto3); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *protoSchema) parseMessage(b []byte, scope string, proto3 bool) error {
+	m := &protoMessageType{fields: make(map[int]*protoField), proto3: proto3}
+	var nested [][]byte
+	d := protowire.NewDecoder(b)
+	for field, ok := d.Next(); ok; field, ok = d.Next() {
+		if d.Wire() != protowire.Bytes {
+			d.Skip()
+			continue
+		}
+		v := d.Bytes()
+		if d.Err() != nil {
+			break
+		}
+		switch field {
+		case 1:
+			m.name = string(v)
+		case 2:
+			f, err := parseProtoField(v)
+			if err != nil {
+				return err
+			}
+			m.fields[f.number] = f
+		case 3:
+			nested = append(nested, v)
+		}
+	}
+	if err := d.Err(); err != nil {
+		return err
+	}
+	if m.name == "" {
+		return errors.New("message without a name")
+	}
+	if scope != "" {
+		m.name = scope + "." + m.name
+	}
+	s.messages[m.name] = m
+	for _, n := range nested {
+		if err := s.parseMessage(n, m.name, proto3); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseProtoField(b []byte) (*protoField, error) {
+	f := &protoField{}
+	d := protowire.NewDecoder(b)
+	for field, ok := d.Next(); ok; field, ok = d.Next() {
+		wire := d.Wire()
+		n, v := d.Value()
+		switch {
+		case field == 1 && wire == protowire.Bytes:
+			f.name = string(v)
+		case field == 3 && wire == protowire.Varint:
+			f.number = int(n)
+		case field == 4 && wire == protowire.Varint:
+			f.label = int(n)
+		case field == 5 && wire == protowire.Varint:
+			f.typ = int(n)
+		case field == 6 && wire == protowire.Bytes:
+			f.typeName = string(v)
+		}
+	}
+	if err := d.Err(); err != nil {
+		return nil, err
+	}
+	if f.number <= 0 || f.typ <= 0 {
+		return nil, errors.Errorf("invalid field %q", f.name)
+	}
+	return f, nil
+}
+
+// validate checks that the message is a valid encoding of the message type
+func (s *protoSchema) validate(b []byte) error {
+	return s.check(s.root, b, s.root.name, 0)
+}
+
+func (s *protoSchema) check(m *protoMessageType, b []byte, path string, depth int) error {
+	if depth > 100 {
+		return errors.Errorf("%s: message nested too deeply", path)
+	}
+	seen := make(map[int]bool)
+	d := protowire.NewDecoder(b)
+	for field, ok := d.Next(); ok; field, ok = d.Next() {
+		wire := d.Wire()
+		_, v := d.Value()
+		if d.Err() != nil {
+			break
+		}
+		f, ok := m.fields[field]
+		if !ok {
+			continue
+		}
+		seen[field] = true
+		fieldPath := path + "." + f.name
+		if f.typ == protoGroup {
+			return errors.Errorf("%s: groups are not supported", fieldPath)
+		}
+		expected := f.wireType()
+		if wire != expected {
+			// repeated scalars may be packed into a single length delimited field
+			if f.label == labelRepeated && wire == protowire.Bytes && expected != protowire.Bytes {
+				if err := checkPacked(v, expected); err != nil {
+					return errors.Wrap(err, fieldPath)
+				}
+				continue
+			}
+			return errors.Errorf("%s: field %d has wire type %d, expected %d", fieldPath, field, wire, expected)
+		}
+		switch f.typ {
+		case protoString:
+			if m.proto3 && !utf8.Valid(v) {
+				return errors.Errorf("%s: invalid utf8 string", fieldPath)
+			}
+		case protoMessage:
+			if err := s.check(s.messages[strings.TrimPrefix(f.typeName, ".")], v, fieldPath, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	if err := d.Err(); err != nil {
+		return errors.Wrap(err, path)
+	}
+	for number, f := range m.fields {
+		if f.label == labelRequired && !seen[number] {
+			return errors.Errorf("%s: missing required field %s", path, f.name)
+		}
+	}
+	return nil
+}
+
+// checkPacked checks that the packed values are all of the wire type
+func checkPacked(b []byte, wire int) error {
+	d := protowire.NewDecoder(b)
+	for d.Len() > 0 && d.Err() == nil {
+		d.SkipValue(wire)
+	}
+	return d.Err()
+}
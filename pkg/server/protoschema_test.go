@@ -0,0 +1,119 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/protowire"
+)
+
+// protobuf encoding helpers for building descriptors and messages
+func pbInt(field int, v uint64) []byte {
+	return protowire.AppendVarint(protowire.AppendTag(nil, field, protowire.Varint), v)
+}
+
+func pbBytes(field int, parts ...[]byte) []byte {
+	var v []byte
+	for _, p := range parts {
+		v = append(v, p...)
+	}
+	return protowire.AppendBytes(nil, field, v)
+}
+
+func pbFixed32(field int) []byte {
+	return append(protowire.AppendTag(nil, field, protowire.Fixed32), 0, 0, 0, 0)
+}
+
+func pbField(name string, number, label, typ int, typeName string) []byte {
+	f := [][]byte{pbBytes(1, []byte(name)), pbInt(3, uint64(number)), pbInt(4, uint64(label)), pbInt(5, uint64(typ))}
+	if typeName != "" {
+		f = append(f, pbBytes(6, []byte(typeName)))
+	}
+	return pbBytes(2, f...)
+}
+
+// testDescriptorSet describes
+//
+//	syntax = "proto3";
+//	package shop;
+//	message Order {
+//	  int64 id = 1;
+//	  string name = 2;
+//	  repeated int32 counts = 3;
+//	  Item item = 4;
+//	  float price = 5;
+//	  message Item { string sku = 1; }
+//	}
+//
+// with the required id of a proto2 Ping message in a second file
+func testDescriptorSet() []byte {
+	order := pbBytes(4,
+		pbBytes(1, []byte("Order")),
+		pbField("id", 1, 1, 3, ""),
+		pbField("name", 2, 1, 9, ""),
+		pbField("counts", 3, 3, 5, ""),
+		pbField("item", 4, 1, 11, ".shop.Order.Item"),
+		pbField("price", 5, 1, 2, ""),
+		pbBytes(3, pbBytes(1, []byte("Item")), pbField("sku", 1, 1, 9, "")),
+	)
+	file1 := pbBytes(1, pbBytes(1, []byte("order.proto")), pbBytes(2, []byte("shop")), order, pbBytes(12, []byte("proto3")))
+	ping := pbBytes(4, pbBytes(1, []byte("Ping")), pbField("id", 1, 2, 5, ""))
+	file2 := pbBytes(1, pbBytes(1, []byte("ping.proto")), ping)
+	return append(file1, file2...)
+}
+
+func TestProtoSchema(t *testing.T) {
+	set := testDescriptorSet()
+	s, err := compileProtoSchema(set, "shop.Order")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := [][]byte{
+		nil,
+		append(append(pbInt(1, 7), pbBytes(2, []byte("book"))...), pbFixed32(5)...),
+		append(append(pbInt(3, 1), pbInt(3, 2)...), pbBytes(3, protowire.AppendVarint(protowire.AppendVarint(nil, 3), 300))...),
+		append(pbBytes(4, pbBytes(1, []byte("sku-1"))), pbInt(99, 1)...),
+	}
+	for _, msg := range valid {
+		if err = s.validate(msg); err != nil {
+			t.Error(msg, err)
+		}
+	}
+
+	invalid := map[string][]byte{
+		"shop.Order.id: field 1 has wire type 2, expected 0": pbBytes(1, []byte("x")),
+		"shop.Order.name: invalid utf8 string":               pbBytes(2, []byte{0xff}),
+		"shop.Order.item.sku: field 1 has wire type 0":       pbBytes(4, pbInt(1, 1)),
+		"shop.Order.counts: malformed protobuf message":      pbBytes(3, []byte{0x80}),
+		"shop.Order.price: field 5 has wire type 0":          pbInt(5, 1),
+		"shop.Order: malformed protobuf message":             {0x08},
+	}
+	for expected, msg := range invalid {
+		if err = s.validate(msg); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q, got %v", expected, err)
+		}
+	}
+
+	ping, err := compileProtoSchema(set, ".Ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ping.validate(pbInt(1, 1)); err != nil {
+		t.Error(err)
+	}
+	if err = ping.validate(nil); err == nil || err.Error() != "Ping: missing required field id" {
+		t.Error(err)
+	}
+
+	if _, err = compileProtoSchema(set, "shop.Missing"); err == nil {
+		t.Error("expected missing message error")
+	}
+	if _, err = compileProtoSchema([]byte{0x0a, 0x05}, "shop.Order"); err == nil {
+		t.Error("expected invalid descriptor error")
+	}
+	broken := pbBytes(1, pbBytes(4, pbBytes(1, []byte("A")), pbField("b", 1, 1, 11, ".B")))
+	if _, err = compileProtoSchema(broken, "A"); err == nil || !strings.Contains(err.Error(), `".B" of field A.b not found`) {
+		t.Error(err)
+	}
+}
@@ -1,8 +1,10 @@
 package server
 
 import (
+	"context"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/haraqa/haraqa/internal/headers"
 
@@ -14,16 +16,27 @@ import (
 
 var _ Queue = &filequeue.FileQueue{}
 
-// Queue is the interface used by the server to produce and consume messages from different distinct categories called topics
+// Queue is the interface used by the server to produce and consume messages from different distinct categories called topics.
+// Produce, consume and list operations are given the context of the request so that they can stop early if the
+// client disconnects
 type Queue interface {
 	RootDir() string
 	Close() error
+	DiskUsage() (*headers.DiskUsage, error)
+	CacheStats() headers.CacheStats
+	DebugInfo() headers.QueueDebug
 
-	ListTopics(prefix, suffix, regex string) ([]string, error)
+	ListTopics(ctx context.Context, prefix, suffix, regex string) ([]string, error)
 	CreateTopic(topic string) error
 	DeleteTopic(topic string) error
+	RestoreTopic(topic string) error
+	PurgeTopics(before time.Time) ([]string, error)
+	InspectTopic(topic string) (*headers.TopicInfo, error)
 	ModifyTopic(topic string, request headers.ModifyRequest) (*headers.TopicInfo, error)
+	Scrub(topic string, repair bool) ([]headers.Corruption, error)
+	Preload(ctx context.Context, since time.Time) ([]string, error)
 
-	Produce(topic string, msgSizes []int64, timestamp uint64, r io.Reader) error
-	Consume(topic string, id int64, limit int64, w http.ResponseWriter) (int, error)
+	Produce(ctx context.Context, topic string, msgSizes []int64, timestamp uint64, r io.Reader) error
+	ProduceWithIDs(ctx context.Context, topic string, msgSizes []int64, ids []headers.MessageID, timestamp uint64, r io.Reader) error
+	Consume(ctx context.Context, topic string, id int64, limit int64, w http.ResponseWriter) (int, error)
 }
@@ -5,9 +5,11 @@
 package server
 
 import (
+	context "context"
 	io "io"
 	http "net/http"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	headers "github.com/haraqa/haraqa/internal/headers"
@@ -64,19 +66,62 @@ func (mr *MockQueueMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockQueue)(nil).Close))
 }
 
+// DiskUsage mocks base method
+func (m *MockQueue) DiskUsage() (*headers.DiskUsage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DiskUsage")
+	ret0, _ := ret[0].(*headers.DiskUsage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DiskUsage indicates an expected call of DiskUsage
+func (mr *MockQueueMockRecorder) DiskUsage() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DiskUsage", reflect.TypeOf((*MockQueue)(nil).DiskUsage))
+}
+
+// CacheStats mocks base method
+func (m *MockQueue) CacheStats() headers.CacheStats {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CacheStats")
+	ret0, _ := ret[0].(headers.CacheStats)
+	return ret0
+}
+
+// CacheStats indicates an expected call of CacheStats
+func (mr *MockQueueMockRecorder) CacheStats() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CacheStats", reflect.TypeOf((*MockQueue)(nil).CacheStats))
+}
+
+// DebugInfo mocks base method
+func (m *MockQueue) DebugInfo() headers.QueueDebug {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DebugInfo")
+	ret0, _ := ret[0].(headers.QueueDebug)
+	return ret0
+}
+
+// DebugInfo indicates an expected call of DebugInfo
+func (mr *MockQueueMockRecorder) DebugInfo() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DebugInfo", reflect.TypeOf((*MockQueue)(nil).DebugInfo))
+}
+
 // ListTopics mocks base method
-func (m *MockQueue) ListTopics(prefix, suffix, regex string) ([]string, error) {
+func (m *MockQueue) ListTopics(ctx context.Context, prefix, suffix, regex string) ([]string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ListTopics", prefix, suffix, regex)
+	ret := m.ctrl.Call(m, "ListTopics", ctx, prefix, suffix, regex)
 	ret0, _ := ret[0].([]string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // ListTopics indicates an expected call of ListTopics
-func (mr *MockQueueMockRecorder) ListTopics(prefix, suffix, regex interface{}) *gomock.Call {
+func (mr *MockQueueMockRecorder) ListTopics(ctx, prefix, suffix, regex interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTopics", reflect.TypeOf((*MockQueue)(nil).ListTopics), prefix, suffix, regex)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTopics", reflect.TypeOf((*MockQueue)(nil).ListTopics), ctx, prefix, suffix, regex)
 }
 
 // CreateTopic mocks base method
@@ -107,6 +152,80 @@ func (mr *MockQueueMockRecorder) DeleteTopic(topic interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteTopic", reflect.TypeOf((*MockQueue)(nil).DeleteTopic), topic)
 }
 
+// RestoreTopic mocks base method
+func (m *MockQueue) RestoreTopic(topic string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreTopic", topic)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RestoreTopic indicates an expected call of RestoreTopic
+func (mr *MockQueueMockRecorder) RestoreTopic(topic interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreTopic", reflect.TypeOf((*MockQueue)(nil).RestoreTopic), topic)
+}
+
+// PurgeTopics mocks base method
+func (m *MockQueue) PurgeTopics(before time.Time) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeTopics", before)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeTopics indicates an expected call of PurgeTopics
+func (mr *MockQueueMockRecorder) PurgeTopics(before interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeTopics", reflect.TypeOf((*MockQueue)(nil).PurgeTopics), before)
+}
+
+// Scrub mocks base method
+func (m *MockQueue) Scrub(topic string, repair bool) ([]headers.Corruption, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Scrub", topic, repair)
+	ret0, _ := ret[0].([]headers.Corruption)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Scrub indicates an expected call of Scrub
+func (mr *MockQueueMockRecorder) Scrub(topic, repair interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Scrub", reflect.TypeOf((*MockQueue)(nil).Scrub), topic, repair)
+}
+
+// Preload mocks base method
+func (m *MockQueue) Preload(ctx context.Context, since time.Time) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Preload", ctx, since)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Preload indicates an expected call of Preload
+func (mr *MockQueueMockRecorder) Preload(ctx, since interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Preload", reflect.TypeOf((*MockQueue)(nil).Preload), ctx, since)
+}
+
+// InspectTopic mocks base method
+func (m *MockQueue) InspectTopic(topic string) (*headers.TopicInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InspectTopic", topic)
+	ret0, _ := ret[0].(*headers.TopicInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InspectTopic indicates an expected call of InspectTopic
+func (mr *MockQueueMockRecorder) InspectTopic(topic interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InspectTopic", reflect.TypeOf((*MockQueue)(nil).InspectTopic), topic)
+}
+
 // ModifyTopic mocks base method
 func (m *MockQueue) ModifyTopic(topic string, request headers.ModifyRequest) (*headers.TopicInfo, error) {
 	m.ctrl.T.Helper()
@@ -123,30 +242,44 @@ func (mr *MockQueueMockRecorder) ModifyTopic(topic, request interface{}) *gomock
 }
 
 // Produce mocks base method
-func (m *MockQueue) Produce(topic string, msgSizes []int64, timestamp uint64, r io.Reader) error {
+func (m *MockQueue) Produce(ctx context.Context, topic string, msgSizes []int64, timestamp uint64, r io.Reader) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Produce", topic, msgSizes, timestamp, r)
+	ret := m.ctrl.Call(m, "Produce", ctx, topic, msgSizes, timestamp, r)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Produce indicates an expected call of Produce
-func (mr *MockQueueMockRecorder) Produce(topic, msgSizes, timestamp, r interface{}) *gomock.Call {
+func (mr *MockQueueMockRecorder) Produce(ctx, topic, msgSizes, timestamp, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Produce", reflect.TypeOf((*MockQueue)(nil).Produce), ctx, topic, msgSizes, timestamp, r)
+}
+
+// ProduceWithIDs mocks base method
+func (m *MockQueue) ProduceWithIDs(ctx context.Context, topic string, msgSizes []int64, ids []headers.MessageID, timestamp uint64, r io.Reader) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ProduceWithIDs", ctx, topic, msgSizes, ids, timestamp, r)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ProduceWithIDs indicates an expected call of ProduceWithIDs
+func (mr *MockQueueMockRecorder) ProduceWithIDs(ctx, topic, msgSizes, ids, timestamp, r interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Produce", reflect.TypeOf((*MockQueue)(nil).Produce), topic, msgSizes, timestamp, r)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ProduceWithIDs", reflect.TypeOf((*MockQueue)(nil).ProduceWithIDs), ctx, topic, msgSizes, ids, timestamp, r)
 }
 
 // Consume mocks base method
-func (m *MockQueue) Consume(topic string, id, limit int64, w http.ResponseWriter) (int, error) {
+func (m *MockQueue) Consume(ctx context.Context, topic string, id, limit int64, w http.ResponseWriter) (int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Consume", topic, id, limit, w)
+	ret := m.ctrl.Call(m, "Consume", ctx, topic, id, limit, w)
 	ret0, _ := ret[0].(int)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Consume indicates an expected call of Consume
-func (mr *MockQueueMockRecorder) Consume(topic, id, limit, w interface{}) *gomock.Call {
+func (mr *MockQueueMockRecorder) Consume(ctx, topic, id, limit, w interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Consume", reflect.TypeOf((*MockQueue)(nil).Consume), topic, id, limit, w)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Consume", reflect.TypeOf((*MockQueue)(nil).Consume), ctx, topic, id, limit, w)
 }
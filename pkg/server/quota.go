@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// WithMaxTopics limits the total number of topics, creating a topic past the limit returns
+// headers.ErrTopicLimitReached. A limit of 0 allows any number of topics
+func WithMaxTopics(n int64) Option {
+	return func(s *Server) error {
+		if n < 0 {
+			return errors.New("invalid max topics, value must not be negative")
+		}
+		s.topicQuota.max = n
+		return nil
+	}
+}
+
+// WithTopicCreationQuota limits the number of topics each client can create within the window, further creates
+// return headers.ErrTopicQuotaExceeded. Clients of the http api are identified by the key function, defaulting
+// to the remote ip address of the request, and clients of the protocol listeners by their principal
+func WithTopicCreationQuota(limit int, window time.Duration, key func(r *http.Request) string) Option {
+	return func(s *Server) error {
+		if limit <= 0 {
+			return errors.New("invalid quota limit, value must be greater than 0")
+		}
+		if window <= 0 {
+			return errors.New("invalid quota window, value must be greater than 0")
+		}
+		if key == nil {
+			key = remoteIP
+		}
+		s.topicQuota.limit = limit
+		s.topicQuota.window = window
+		s.topicQuota.key = key
+		s.topicQuota.created = make(map[string]*quotaWindow)
+		return nil
+	}
+}
+
+// remoteIP returns the ip address of the client which sent the request
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// topicQuota tracks the number of topics and the topics created by each client
+type topicQuota struct {
+	mux     sync.Mutex
+	max     int64
+	count   int64
+	counted bool
+	limit   int
+	window  time.Duration
+	key     func(r *http.Request) string
+	created map[string]*quotaWindow
+	pruned  time.Time
+}
+
+// quotaWindow is the number of topics created by a client since the start of the window
+type quotaWindow struct {
+	start time.Time
+	n     int
+}
+
+// reserveTopic reserves space for a new topic under the topic limit, the reservation must be
+// released if the topic is not created
+func (s *Server) reserveTopic() error {
+	quota := &s.topicQuota
+	if quota.max == 0 {
+		return nil
+	}
+	quota.mux.Lock()
+	defer quota.mux.Unlock()
+	if !quota.counted {
+		// the count is kept for later reservations, so it is not tied to the request
+		topics, err := s.q.ListTopics(context.Background(), "", "", "")
+		if err != nil {
+			return errors.Wrap(err, "unable to count topics")
+		}
+		quota.count, quota.counted = int64(len(topics)), true
+	}
+	if quota.count >= quota.max {
+		return headers.ErrTopicLimitReached
+	}
+	quota.count++
+	return nil
+}
+
+// releaseTopic returns space for a topic which was deleted or not created
+func (s *Server) releaseTopic() {
+	quota := &s.topicQuota
+	if quota.max == 0 {
+		return
+	}
+	quota.mux.Lock()
+	if quota.counted && quota.count > 0 {
+		quota.count--
+	}
+	quota.mux.Unlock()
+}
+
+type creationKey struct{}
+
+// keyCreations sets the key of the client of each request in its context, identifying it to the topic creation
+// quota
+func (s *Server) keyCreations(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), creationKey{}, s.topicQuota.key(r))))
+	})
+}
+
+// reserveCreation counts a topic creation against the quota of the context's client, identified by the key of an
+// http request or otherwise by its principal. Trusted calls are not counted. The reservation must be released if
+// the topic is not created
+func (s *Server) reserveCreation(ctx context.Context) (string, error) {
+	quota := &s.topicQuota
+	if quota.limit == 0 || trusted(ctx) {
+		return "", nil
+	}
+	key, ok := ctx.Value(creationKey{}).(string)
+	if !ok {
+		key = Principal(ctx)
+	}
+	now := time.Now()
+
+	quota.mux.Lock()
+	defer quota.mux.Unlock()
+
+	// forget clients whose windows have expired
+	if now.Sub(quota.pruned) >= quota.window {
+		for k, w := range quota.created {
+			if now.Sub(w.start) >= quota.window {
+				delete(quota.created, k)
+			}
+		}
+		quota.pruned = now
+	}
+
+	w, ok := quota.created[key]
+	if !ok || now.Sub(w.start) >= quota.window {
+		w = &quotaWindow{start: now}
+		quota.created[key] = w
+	}
+	if w.n >= quota.limit {
+		return key, headers.ErrTopicQuotaExceeded
+	}
+	w.n++
+	return key, nil
+}
+
+// releaseCreation returns a creation to the quota of the context's client
+func (s *Server) releaseCreation(ctx context.Context, key string) {
+	quota := &s.topicQuota
+	if quota.limit == 0 || trusted(ctx) {
+		return
+	}
+	quota.mux.Lock()
+	if w, ok := quota.created[key]; ok && w.n > 0 {
+		w.n--
+	}
+	quota.mux.Unlock()
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestServer_MaxTopics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	if err := WithMaxTopics(-1)(&Server{}); err == nil {
+		t.Fatal("expected invalid max topics error")
+	}
+
+	q := NewMockQueue(ctrl)
+	gomock.InOrder(
+		q.EXPECT().RootDir().Times(1).Return(""),
+		q.EXPECT().ListTopics(gomock.Any(), "", "", "").Return([]string{"existing"}, nil).Times(1),
+		q.EXPECT().CreateTopic("new").Return(headers.ErrTopicAlreadyExists).Times(1),
+		q.EXPECT().CreateTopic("new").Return(nil).Times(1),
+		q.EXPECT().DeleteTopic("new").Return(nil).Times(1),
+		q.EXPECT().RestoreTopic("new").Return(nil).Times(1),
+		q.EXPECT().Close().Return(nil).Times(1),
+	)
+	s, err := NewServer(WithQueue(q), WithMaxTopics(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i, tt := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodPut, "/topics/new", http.StatusPreconditionFailed},
+		{http.MethodPut, "/topics/new", http.StatusCreated},
+		{http.MethodPut, "/topics/other", http.StatusForbidden},
+		{http.MethodDelete, "/topics/new", http.StatusNoContent},
+		{http.MethodPut, "/topics/new?restore=true", http.StatusCreated},
+		{http.MethodPut, "/topics/other?restore=true", http.StatusForbidden},
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.status {
+			t.Fatal(i, w.Code)
+		}
+		if w.Code == http.StatusForbidden && headers.ReadErrors(w.Header()) != headers.ErrTopicLimitReached {
+			t.Fatal(i, w.Header())
+		}
+	}
+}
+
+func TestServer_MaxTopicsListError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Times(1).Return("")
+	q.EXPECT().ListTopics(gomock.Any(), "", "", "").Return(nil, errors.New("test list error")).Times(1)
+	q.EXPECT().Close().Return(nil).Times(1)
+	s, err := NewServer(WithQueue(q), WithMaxTopics(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.createTopic(context.Background(), "new"); err == nil || errors.Cause(err).Error() != "test list error" {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_TopicCreationQuota(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, tt := range []struct {
+		limit  int
+		window time.Duration
+	}{{0, time.Second}, {1, 0}} {
+		if err := WithTopicCreationQuota(tt.limit, tt.window, nil)(&Server{}); err == nil {
+			t.Fatal("expected invalid quota error", tt)
+		}
+	}
+
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Times(1).Return("")
+	q.EXPECT().CreateTopic("exists").Return(headers.ErrTopicAlreadyExists).Times(1)
+	q.EXPECT().CreateTopic(gomock.Any()).Return(nil).Times(6)
+	q.EXPECT().Close().Return(nil).Times(1)
+	s, err := NewServer(WithQueue(q), WithTopicCreationQuota(2, time.Hour, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	create := func(topic, addr string) int {
+		r := httptest.NewRequest(http.MethodPut, "/topics/"+topic, nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w.Code
+	}
+	for i, tt := range []struct {
+		topic, addr string
+		status      int
+	}{
+		{"exists", "10.0.0.1:1000", http.StatusPreconditionFailed},
+		{"a", "10.0.0.1:1000", http.StatusCreated},
+		{"b", "10.0.0.1:2000", http.StatusCreated},
+		{"c", "10.0.0.1:3000", http.StatusTooManyRequests},
+		{"c", "10.0.0.2:1000", http.StatusCreated},
+	} {
+		if status := create(tt.topic, tt.addr); status != tt.status {
+			t.Fatal(i, status)
+		}
+	}
+
+	// expired windows are reset
+	s.topicQuota.created["10.0.0.1"].start = time.Now().Add(-time.Hour)
+	s.topicQuota.pruned = time.Now().Add(-time.Hour)
+	if _, err = s.reserveCreation(context.WithValue(context.Background(), creationKey{}, "10.0.0.3")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.topicQuota.created["10.0.0.1"]; ok {
+		t.Fatal("expected expired window to be pruned")
+	}
+
+	// protocol listeners count creations against the quota of their principal, trusted calls are not counted
+	listener := WithPrincipal(context.Background(), "mqtt-client")
+	for _, topic := range []string{"e", "f"} {
+		if err = s.CreateTopic(listener, topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.CreateTopic(listener, "g"); err != headers.ErrTopicQuotaExceeded {
+		t.Fatal(err)
+	}
+	if err = s.CreateTopic(WithTrusted(listener), "g"); err != nil {
+		t.Fatal(err)
+	}
+}
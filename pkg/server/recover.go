@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// errPanic is returned to clients whose request caused a handler to panic
+var errPanic = errors.New("internal server error")
+
+// recoverPanics wraps the handler, converting panics into a 500 response and an error log entry.
+// http.ErrAbortHandler is re-panicked so the http server can abort the response as intended
+func (s *Server) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			s.logError("recovered from panic", errors.Errorf("panic: %v", v),
+				"method", r.Method,
+				"path", r.URL.Path,
+				"stack", string(debug.Stack()),
+			)
+			// headers can only be set if the handler has not started the response
+			if sw.status == 0 && sw.size == 0 {
+				headers.SetError(w, errPanic)
+			}
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestServer_RecoverPanics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Times(1).Return("")
+	q.EXPECT().Close().Return(nil).Times(1)
+
+	logger := &testLogger{}
+	s, err := NewServer(WithQueue(q), WithLogger(logger), WithMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/panic":
+				panic("test panic")
+			case "/written":
+				w.WriteHeader(http.StatusAccepted)
+				panic("test panic after write")
+			case "/abort":
+				panic(http.ErrAbortHandler)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// panic before the response is started
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatal(w.Code)
+	}
+	if code := w.Header().Get(headers.HeaderErrorCode); code != string(headers.CodeInternal) {
+		t.Fatal(code)
+	}
+	if len(logger.entries) != 1 || !strings.HasPrefix(logger.entries[0], "error: recovered from panic") ||
+		!strings.Contains(logger.entries[0], "test panic") {
+		t.Fatal(logger.entries)
+	}
+	if s.counters.errors != 1 {
+		t.Fatal(s.counters.errors)
+	}
+
+	// panic after the response is started keeps the original status
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/written", nil))
+	if w.Code != http.StatusAccepted || w.Header().Get(headers.HeaderErrorCode) != "" {
+		t.Fatal(w.Code, w.Header())
+	}
+	if len(logger.entries) != 2 {
+		t.Fatal(logger.entries)
+	}
+
+	// aborted handlers are passed through to the http server
+	func() {
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Fatal(v)
+			}
+		}()
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/abort", nil))
+	}()
+}
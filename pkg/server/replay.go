@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// ReplayTransform rewrites a message as it is replayed, returning nil drops the message
+type ReplayTransform func(topic string, msg []byte) ([]byte, error)
+
+// replayBatchSize is the number of messages replayed per batch if there is no default consume limit
+const replayBatchSize = 1000
+
+// WithReplayTransform registers a transform which replay requests can apply by name
+func WithReplayTransform(name string, transform ReplayTransform) Option {
+	return func(s *Server) error {
+		if name == "" {
+			return errors.New("invalid replay transform, name cannot be empty")
+		}
+		if transform == nil {
+			return errors.New("invalid replay transform, transform cannot be nil")
+		}
+		if s.replayTransforms == nil {
+			s.replayTransforms = make(map[string]ReplayTransform)
+		}
+		s.replayTransforms[name] = transform
+		return nil
+	}
+}
+
+// ReplayTopic copies the messages of the source topic with offsets from through to into the destination
+// topic, applying the transform to each message if it is not nil. A negative to replays up to the last
+// message. Messages are copied in batches, if an error is returned the messages of the batches before
+// it have already been copied. The number of messages produced to the destination is returned
+func (s *Server) ReplayTopic(ctx context.Context, src, dst string, from, to int64, transform ReplayTransform) (int64, error) {
+	src, err := cleanTopic(src)
+	if err != nil {
+		return 0, err
+	}
+	dst, err = cleanTopic(dst)
+	if err != nil {
+		return 0, err
+	}
+	return s.replayTopic(ctx, src, dst, from, to, transform)
+}
+
+// replayTopic copies the range of messages from src to dst, logging the result
+func (s *Server) replayTopic(ctx context.Context, src, dst string, from, to int64, transform ReplayTransform) (int64, error) {
+	if src == dst {
+		return 0, errors.Wrap(headers.ErrInvalidTopic, "cannot replay a topic into itself")
+	}
+	info, err := s.InspectTopic(ctx, src)
+	if err != nil {
+		return 0, err
+	}
+	if from < info.MinOffset {
+		from = info.MinOffset
+	}
+	if to < 0 || to > info.MaxOffset {
+		to = info.MaxOffset
+	}
+
+	batchSize := s.defaultConsumeLimit
+	if batchSize <= 0 {
+		batchSize = replayBatchSize
+	}
+	var count int64
+	for id := from; id <= to; {
+		limit := batchSize
+		if limit > to-id+1 {
+			limit = to - id + 1
+		}
+		msgs, err := s.ConsumeMsgs(ctx, src, id, limit)
+		if err != nil {
+			return count, err
+		}
+		if len(msgs) == 0 {
+			break
+		}
+		id += int64(len(msgs))
+
+		if transform != nil {
+			transformed := msgs[:0]
+			for _, msg := range msgs {
+				msg, err = transform(src, msg)
+				if err != nil {
+					s.logError("unable to replay topic", err, "topic", src, "destination", dst, "replayed", count)
+					return count, errors.Wrap(err, "unable to transform message")
+				}
+				if msg != nil {
+					transformed = append(transformed, msg)
+				}
+			}
+			msgs = transformed
+		}
+		if err = s.ProduceMsgs(ctx, dst, msgs...); err != nil {
+			return count, err
+		}
+		count += int64(len(msgs))
+	}
+	s.logger.Info("topic replayed", "topic", src, "destination", dst, "from", from, "to", to, "replayed", count)
+	return count, nil
+}
+
+// handleReplay handles produce requests with a replay query, copying a range of another topic's messages
+// into the topic. The range is given by the from and to queries and a registered transform can be applied
+// with the transform query
+func (s *Server) handleReplay(w http.ResponseWriter, r *http.Request, dst string) {
+	query := r.URL.Query()
+	src, err := cleanTopic(query.Get("replay"))
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+	from, to := int64(0), int64(-1)
+	if v := query.Get("from"); v != "" {
+		if from, err = strconv.ParseInt(v, 10, 64); err != nil {
+			headers.SetError(w, headers.ErrInvalidMessageID)
+			return
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if to, err = strconv.ParseInt(v, 10, 64); err != nil {
+			headers.SetError(w, headers.ErrInvalidMessageID)
+			return
+		}
+	}
+	var transform ReplayTransform
+	if name := query.Get("transform"); name != "" {
+		var ok bool
+		if transform, ok = s.replayTransforms[name]; !ok {
+			headers.SetError(w, headers.ErrUnknownTransform)
+			return
+		}
+	}
+
+	count, err := s.replayTopic(r.Context(), src, dst, from, to, transform)
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+	w.Header()[headers.ContentType] = []string{"application/json"}
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(&headers.ReplayResponse{Count: count})
+}
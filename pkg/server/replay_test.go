@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestWithReplayTransform(t *testing.T) {
+	upper := func(topic string, msg []byte) ([]byte, error) { return bytes.ToUpper(msg), nil }
+	if err := WithReplayTransform("", upper)(&Server{}); err == nil {
+		t.Error("expected name error")
+	}
+	if err := WithReplayTransform("upper", nil)(&Server{}); err == nil {
+		t.Error("expected transform error")
+	}
+	s := &Server{}
+	if err := WithReplayTransform("upper", upper)(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.replayTransforms["upper"] == nil {
+		t.Fatal(s.replayTransforms)
+	}
+}
+
+func TestServer_ReplayTopic(t *testing.T) {
+	dir := ".haraqa-replay"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithDefaultConsumeLimit(2),
+		WithReplayTransform("upper", func(topic string, msg []byte) ([]byte, error) {
+			if string(msg) == "drop" {
+				return nil, nil
+			}
+			return bytes.ToUpper(msg), nil
+		}),
+		WithReplayTransform("fail", func(topic string, msg []byte) ([]byte, error) {
+			return nil, errors.New("test transform error")
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	for _, topic := range []string{"src", "dst"} {
+		if err = s.CreateTopic(ctx, topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.ProduceMsgs(ctx, "src", []byte("a"), []byte("b"), []byte("drop"), []byte("c"), []byte("d")); err != nil {
+		t.Fatal(err)
+	}
+
+	replay := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/topics/dst?"+query, nil))
+		return w
+	}
+	consumeDst := func() string {
+		msgs, err := s.ConsumeMsgs(ctx, "dst", 0, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(bytes.Join(msgs, []byte(",")))
+	}
+
+	// a range spanning several batches
+	w := replay("replay=src&from=1&to=3")
+	var resp headers.ReplayResponse
+	if err = json.NewDecoder(w.Body).Decode(&resp); w.Code != http.StatusOK || err != nil || resp.Count != 3 {
+		t.Fatal(w.Code, resp, err)
+	}
+	if got := consumeDst(); got != "b,drop,c" {
+		t.Fatal(got)
+	}
+
+	// the whole topic with a transform
+	w = replay("replay=src&transform=upper")
+	if err = json.NewDecoder(w.Body).Decode(&resp); w.Code != http.StatusOK || err != nil || resp.Count != 4 {
+		t.Fatal(w.Code, resp, err)
+	}
+	if got := consumeDst(); got != "b,drop,c,A,B,C,D" {
+		t.Fatal(got)
+	}
+
+	// the go api
+	count, err := s.ReplayTopic(ctx, "src", "dst", 4, -1, nil)
+	if err != nil || count != 1 {
+		t.Fatal(count, err)
+	}
+	if _, err = s.ReplayTopic(ctx, "src", "dst", 0, -1, func(topic string, msg []byte) ([]byte, error) {
+		return nil, errors.New("test transform error")
+	}); err == nil {
+		t.Fatal("expected transform error")
+	}
+
+	for _, tt := range []struct {
+		query string
+		err   error
+	}{
+		{"replay=src&transform=missing", headers.ErrUnknownTransform},
+		{"replay=src&from=x", headers.ErrInvalidMessageID},
+		{"replay=src&to=x", headers.ErrInvalidMessageID},
+		{"replay=dst", headers.ErrInvalidTopic},
+		{"replay=missing", headers.ErrTopicDoesNotExist},
+	} {
+		if w = replay(tt.query); headers.ReadErrors(w.Header()) != tt.err {
+			t.Fatal(tt.query, w.Code, w.Header())
+		}
+	}
+	if w = replay("replay=src&transform=fail"); w.Code != http.StatusInternalServerError {
+		t.Fatal(w.Code, w.Header())
+	}
+}
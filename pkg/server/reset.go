@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// ResetOffsets moves the next offset of the consumer group for each topic to the position of its reset, the
+// operational lever to reprocess messages after a fixed consumer is deployed or to skip a backlog. Offsets
+// are kept within the messages of the topic, so a specific offset before the earliest message resets to the
+// earliest message. The offsets are committed unless dryRun is set, and are returned either way. Members of
+// the group receive the new offsets with their next heartbeat, consumers should be stopped while the offsets
+// are reset so that they do not commit over them
+func (s *Server) ResetOffsets(ctx context.Context, group string, resets map[string]headers.OffsetReset, dryRun bool) (map[string]int64, error) {
+	if len(resets) == 0 {
+		return nil, errors.Wrap(headers.ErrInvalidBodyJSON, "no topics to reset")
+	}
+	offsets := make(map[string]int64, len(resets))
+	for topic, reset := range resets {
+		topic, err := cleanTopic(topic)
+		if err != nil {
+			return nil, err
+		}
+		offset, err := s.resetOffset(ctx, topic, reset)
+		if err != nil {
+			return nil, err
+		}
+		offsets[topic] = offset
+	}
+
+	topics := make([]string, 0, len(offsets))
+	for topic := range offsets {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	if dryRun {
+		s.logger.Info("consumer group offsets would be reset", "group", group, "topics", strings.Join(topics, ","), "dryRun", true)
+		return offsets, nil
+	}
+	if err := s.CommitOffsets(group, offsets); err != nil {
+		return nil, err
+	}
+	s.logger.Info("consumer group offsets reset", "group", group, "topics", strings.Join(topics, ","))
+	return offsets, nil
+}
+
+// resetOffset returns the offset of the topic at the position of the reset
+func (s *Server) resetOffset(ctx context.Context, topic string, reset headers.OffsetReset) (int64, error) {
+	info, err := s.q.InspectTopic(topic)
+	if err != nil {
+		return 0, err
+	}
+	latest := info.MinOffset
+	if info.MaxOffset >= info.MinOffset {
+		latest = info.MaxOffset + 1
+	}
+
+	switch strings.ToLower(reset.To) {
+	case headers.ResetEarliest:
+		return info.MinOffset, nil
+	case headers.ResetLatest:
+		return latest, nil
+	case headers.ResetTimestamp:
+		if reset.Timestamp.IsZero() {
+			return 0, errors.Wrapf(headers.ErrInvalidBodyJSON, "missing timestamp to reset topic %q to", topic)
+		}
+		return s.offsetAt(ctx, topic, info, reset.Timestamp)
+	case headers.ResetOffset:
+		if reset.Offset < 0 {
+			return 0, errors.Wrap(headers.ErrInvalidBodyJSON, "offsets cannot be negative")
+		}
+		if reset.Offset < info.MinOffset {
+			return info.MinOffset, nil
+		}
+		if reset.Offset > latest {
+			return latest, nil
+		}
+		return reset.Offset, nil
+	}
+	return 0, errors.Wrapf(headers.ErrInvalidBodyJSON, "invalid reset position %q, expected one of earliest, latest, timestamp or offset", reset.To)
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestServer_ResetOffsets(t *testing.T) {
+	dir := ".haraqa-reset-offsets"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	for _, topic := range []string{"orders", "empty"} {
+		if err = s.CreateTopic(ctx, topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(-4 * time.Hour)
+	for i := 0; i < 4; i++ {
+		timestamp := uint64(start.Add(time.Duration(i) * time.Hour).UnixNano())
+		if err = s.q.Produce(ctx, "orders", []int64{5}, timestamp, bytes.NewBufferString("order")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.CommitOffsets("billing", map[string]int64{"orders": 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	for reset, expected := range map[headers.OffsetReset]int64{
+		{To: "earliest"}: 0,
+		{To: "LATEST"}:   4,
+		{To: "timestamp", Timestamp: start.Add(90 * time.Minute)}: 2,
+		{To: "timestamp", Timestamp: start.Add(-time.Hour)}:       0,
+		{To: "timestamp", Timestamp: time.Now()}:                  4,
+		{To: "offset", Offset: 1}:                                 1,
+		{To: "offset", Offset: 10}:                                4,
+	} {
+		offsets, err := s.ResetOffsets(ctx, "billing", map[string]headers.OffsetReset{"orders": reset}, true)
+		if err != nil || len(offsets) != 1 || offsets["orders"] != expected {
+			t.Fatal(reset, offsets, err)
+		}
+	}
+	// dry runs leave the committed offsets alone
+	if offset, _ := s.groupOffsets.get("billing", "orders"); offset != 3 {
+		t.Fatal(offset)
+	}
+
+	for _, resets := range []map[string]headers.OffsetReset{
+		nil,
+		{"orders": {To: "start"}},
+		{"orders": {To: "timestamp"}},
+		{"orders": {To: "offset", Offset: -1}},
+	} {
+		if _, err = s.ResetOffsets(ctx, "billing", resets, false); errors.Cause(err) != headers.ErrInvalidBodyJSON {
+			t.Fatal(resets, err)
+		}
+	}
+	if _, err = s.ResetOffsets(ctx, "billing", map[string]headers.OffsetReset{"missing": {To: "earliest"}}, false); errors.Cause(err) != headers.ErrTopicDoesNotExist {
+		t.Fatal(err)
+	}
+
+	offsets, err := s.ResetOffsets(ctx, "billing", map[string]headers.OffsetReset{"orders": {To: "earliest"}, "empty": {To: "latest"}}, false)
+	if err != nil || offsets["orders"] != 0 || offsets["empty"] != 0 {
+		t.Fatal(offsets, err)
+	}
+	if description := s.groupOffsets.group("billing"); description["orders"] != 0 || len(description) != 2 {
+		t.Fatal(description)
+	}
+}
+
+func TestServer_HandleResetOffsets(t *testing.T) {
+	dir := ".haraqa-handle-reset-offsets"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	if err = s.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(ctx, "orders", []byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+
+	reset := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/groups/billing", bytes.NewBufferString(body)))
+		return w
+	}
+	if w := reset(`{"topics":{"orders":{"to":"never"}}}`); w.Code != http.StatusBadRequest {
+		t.Fatal(w.Code)
+	}
+	if w := reset(`{"topics":`); w.Code != http.StatusBadRequest {
+		t.Fatal(w.Code)
+	}
+	w := reset(`{"topics":{"orders":{"to":"latest"}}}`)
+	var resp headers.ResetResponse
+	if err = json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK || resp.Offsets["orders"] != 2 || resp.DryRun {
+		t.Fatal(w.Code, w.Body.String(), err)
+	}
+	if offset, ok := s.groupOffsets.get("billing", "orders"); !ok || offset != 2 {
+		t.Fatal(offset, ok)
+	}
+
+	// resets require admin, describing the group does not
+	if _, permission, ok := requiredPermission(httptest.NewRequest(http.MethodPatch, "/groups/billing", nil)); !ok || permission != headers.PermissionAdmin {
+		t.Fatal(permission, ok)
+	}
+	if _, _, ok := requiredPermission(httptest.NewRequest(http.MethodGet, "/groups/billing", nil)); ok {
+		t.Fatal("expected describing a group to require no permission")
+	}
+}
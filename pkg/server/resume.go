@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+// parseRange reads the offset into the batch body of a Range header of the form bytes=N-, used by
+// clients to resume an interrupted consume of the same id and limit
+func parseRange(value string) (int64, error) {
+	if !strings.HasPrefix(value, "bytes=") || !strings.HasSuffix(value, "-") {
+		return 0, headers.ErrInvalidRange
+	}
+	skip, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(value, "bytes="), "-"), 10, 64)
+	if err != nil || skip < 0 {
+		return 0, headers.ErrInvalidRange
+	}
+	return skip, nil
+}
+
+// rangeWriter sends a consumed batch from skip bytes into its body. The message headers describe the
+// whole batch, and Content-Range gives the part of the batch body that is sent
+type rangeWriter struct {
+	http.ResponseWriter
+	skip        int64
+	wroteHeader bool
+	discard     bool
+}
+
+func (w *rangeWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code != http.StatusOK && code != http.StatusPartialContent {
+		w.skip = 0
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	h := w.Header()
+	sizes, _ := headers.ReadSizes(h)
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+	delete(h, "Content-Length")
+	if w.skip >= total {
+		// the rest of the body is dropped in favor of the error
+		w.discard = true
+		h["Content-Range"] = []string{"bytes */" + strconv.FormatInt(total, 10)}
+		headers.SetError(w.ResponseWriter, headers.ErrInvalidRange)
+		return
+	}
+	h["Content-Range"] = []string{"bytes " + strconv.FormatInt(w.skip, 10) + "-" + strconv.FormatInt(total-1, 10) + "/" + strconv.FormatInt(total, 10)}
+	h["Content-Length"] = []string{strconv.FormatInt(total-w.skip, 10)}
+	w.ResponseWriter.WriteHeader(http.StatusPartialContent)
+}
+
+func (w *rangeWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.discard {
+		return len(b), nil
+	}
+	if w.skip > 0 {
+		if int64(len(b)) <= w.skip {
+			w.skip -= int64(len(b))
+			return len(b), nil
+		}
+		skip := int(w.skip)
+		w.skip = 0
+		n, err := w.ResponseWriter.Write(b[skip:])
+		return skip + n, err
+	}
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestParseRange(t *testing.T) {
+	for value, expected := range map[string]int64{"bytes=0-": 0, "bytes=12-": 12} {
+		if skip, err := parseRange(value); err != nil || skip != expected {
+			t.Error(value, skip, err)
+		}
+	}
+	for _, value := range []string{"bytes=1-2", "bytes=-5", "items=1-", "bytes=a-", "bytes=0-1,4-"} {
+		if _, err := parseRange(value); err != headers.ErrInvalidRange {
+			t.Error(value, err)
+		}
+	}
+}
+
+func TestServer_ConsumeRange(t *testing.T) {
+	dir := ".haraqa-consume-range"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.CreateTopic(context.Background(), "range"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(context.Background(), "range", []byte("hello"), []byte("world")); err != nil {
+		t.Fatal(err)
+	}
+
+	consume := func(value string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/topics/range?id=0&limit=2", nil)
+		r.Header.Set("Range", value)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	// the batch is resumed part way, with the headers of the whole batch
+	w := consume("bytes=3-")
+	if w.Code != http.StatusPartialContent || w.Body.String() != "loworld" || w.Header().Get("Content-Range") != "bytes 3-9/10" {
+		t.Fatal(w.Code, w.Body.String(), w.Header())
+	}
+	if sizes, err := headers.ReadSizes(w.Header()); err != nil || len(sizes) != 2 {
+		t.Fatal(sizes, err)
+	}
+
+	// ranges past the end of the batch or of another form are rejected
+	for _, value := range []string{"bytes=10-", "bytes=0-4"} {
+		w = consume(value)
+		if w.Code != http.StatusRequestedRangeNotSatisfiable || w.Header().Get(headers.HeaderErrorCode) != string(headers.CodeInvalidRange) {
+			t.Fatal(value, w.Code, w.Header())
+		}
+	}
+}
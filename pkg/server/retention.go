@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// WithRetention removes messages older than maxAge, the janitor checks each topic every interval. Topics can
+// override maxAge with the retention field of their configuration. A maxAge of 0 keeps messages forever.
+// Messages are removed a file at a time, so messages older than maxAge are kept until the rest of their
+// file expires and the latest file of each topic is always kept
+func WithRetention(maxAge, interval time.Duration) Option {
+	return func(s *Server) error {
+		if maxAge < 0 {
+			return errors.New("invalid retention, value must not be negative")
+		}
+		if interval <= 0 {
+			return errors.New("invalid retention interval, value must be greater than 0")
+		}
+		s.retention, s.retentionInterval = maxAge, interval
+		return nil
+	}
+}
+
+// topicRetention returns the maximum age of the topic's messages, 0 if they are kept forever
+func (s *Server) topicRetention(topic string) time.Duration {
+	if retention := s.topicConfigs.get(topic).Retention; retention != nil {
+		return time.Duration(*retention)
+	}
+	return s.retention
+}
+
+// applyRetention truncates the expired messages of every topic with a retention, expiring up to the janitor's
+// concurrency topics at once. It returns the number of messages removed, or which would be in dry run mode
+func (s *Server) applyRetention(ctx context.Context) (int64, error) {
+	topics, err := s.ListTopics(ctx, "", "", "")
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	var expired int64
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < s.janitor.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for topic := range work {
+				n, err := s.expireTopic(ctx, topic, now.Add(-s.topicRetention(topic)))
+				if err != nil {
+					s.logError("unable to apply retention", err, "topic", topic)
+					continue
+				}
+				atomic.AddInt64(&expired, n)
+			}
+		}()
+	}
+	for _, topic := range topics {
+		if s.topicRetention(topic) <= 0 || s.isOffsetsTopic(topic) {
+			continue
+		}
+		work <- topic
+	}
+	close(work)
+	wg.Wait()
+	return expired, nil
+}
+
+// expireTopic truncates the messages of the topic produced before the cutoff, returning the number of messages
+// removed. The first message to keep is found by the produce timestamps of the messages, which increase with
+// their offsets. In dry run mode nothing is removed and the number of messages before the cutoff is returned
+func (s *Server) expireTopic(ctx context.Context, topic string, cutoff time.Time) (int64, error) {
+	info, err := s.q.InspectTopic(topic)
+	if err != nil {
+		if errors.Cause(err) == headers.ErrTopicDoesNotExist {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if info.MaxOffset < info.MinOffset {
+		return 0, nil
+	}
+
+	keep, err := s.offsetAt(ctx, topic, info, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	if keep == info.MinOffset {
+		return 0, nil
+	}
+	if s.janitor.dryRun {
+		s.logger.Info("messages would expire", "topic", topic, "from", info.MinOffset, "to", keep-1, "dryRun", true)
+		return keep - info.MinOffset, nil
+	}
+
+	// truncate removes the files which end before the truncate offset, exclusive of the offset itself
+	truncated, err := s.modifyTopic(ctx, topic, headers.ModifyRequest{Truncate: keep + 1})
+	if err != nil {
+		return 0, err
+	}
+	return truncated.MinOffset - info.MinOffset, nil
+}
+
+// offsetAt returns the offset of the first message of the topic produced at or after t, found by the produce
+// timestamps of the messages which increase with their offsets. The offset after the last message is returned
+// if every message was produced before t
+func (s *Server) offsetAt(ctx context.Context, topic string, info *headers.TopicInfo, t time.Time) (int64, error) {
+	if info.MaxOffset < info.MinOffset {
+		return info.MinOffset, nil
+	}
+	var searchErr error
+	n := sort.Search(int(info.MaxOffset-info.MinOffset+1), func(i int) bool {
+		if searchErr != nil {
+			return true
+		}
+		var produced time.Time
+		produced, searchErr = s.messageTime(ctx, topic, info.MinOffset+int64(i))
+		return !produced.Before(t)
+	})
+	if searchErr != nil {
+		return 0, searchErr
+	}
+	return info.MinOffset + int64(n), nil
+}
+
+// messageTime returns the time the message with the id was produced
+func (s *Server) messageTime(ctx context.Context, topic string, id int64) (time.Time, error) {
+	w := &bufferWriter{header: make(http.Header)}
+	count, err := s.q.Consume(ctx, topic, id, 1, w)
+	if err != nil {
+		return time.Time{}, err
+	}
+	timestamps, err := headers.ReadTimestamps(w.header)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if count != 1 || len(timestamps) != 1 {
+		return time.Time{}, errors.Errorf("unable to read the timestamp of message %d", id)
+	}
+	return timestamps[0], nil
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestWithRetention(t *testing.T) {
+	s := &Server{}
+	if err := WithRetention(-time.Second, time.Minute)(s); err == nil {
+		t.Error("expected negative retention error")
+	}
+	if err := WithRetention(time.Hour, 0)(s); err == nil {
+		t.Error("expected invalid interval error")
+	}
+	if err := WithRetention(time.Hour, time.Minute)(s); err != nil || s.retention != time.Hour || s.retentionInterval != time.Minute {
+		t.Fatal(err, s.retention, s.retentionInterval)
+	}
+}
+
+func TestServer_TopicRetention(t *testing.T) {
+	year, none := headers.Duration(365*24*time.Hour), headers.Duration(0)
+	s := &Server{retention: time.Hour}
+	s.topicConfigs.configs = map[string]headers.TopicConfig{
+		"audit":   {Retention: &year},
+		"forever": {Retention: &none},
+	}
+	if r := s.topicRetention("audit"); r != time.Duration(year) {
+		t.Error(r)
+	}
+	if r := s.topicRetention("forever"); r != 0 {
+		t.Error(r)
+	}
+	if r := s.topicRetention("other"); r != time.Hour {
+		t.Error(r)
+	}
+	if !s.topicConfigs.retains() {
+		t.Error("expected a topic with retention")
+	}
+}
+
+func TestServer_ExpireTopic(t *testing.T) {
+	dir := ".haraqa-retention"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	if err = s.CreateTopic(ctx, "expiring"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = s.ProduceMsgs(ctx, "expiring", []byte("old"), []byte("old")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	cutoff := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	if err = s.ProduceMsgs(ctx, "expiring", []byte("new"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing has expired yet
+	if n, err := s.expireTopic(ctx, "expiring", cutoff.Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatal(n, err)
+	}
+	if info, err := s.q.InspectTopic("expiring"); err != nil || info.MinOffset != 0 || info.MaxOffset != 5 {
+		t.Fatal(info, err)
+	}
+
+	// the files of the old messages are removed
+	if n, err := s.expireTopic(ctx, "expiring", cutoff); err != nil || n != 4 {
+		t.Fatal(n, err)
+	}
+	if info, err := s.q.InspectTopic("expiring"); err != nil || info.MinOffset != 4 || info.MaxOffset != 5 {
+		t.Fatal(info, err)
+	}
+
+	// the latest file is kept even once its messages expire
+	if n, err := s.expireTopic(ctx, "expiring", time.Now().Add(time.Hour)); err != nil || n != 0 {
+		t.Fatal(n, err)
+	}
+	if info, err := s.q.InspectTopic("expiring"); err != nil || info.MinOffset != 4 || info.MaxOffset != 5 {
+		t.Fatal(info, err)
+	}
+
+	// missing topics are ignored
+	if n, err := s.expireTopic(ctx, "missing", cutoff); err != nil || n != 0 {
+		t.Fatal(n, err)
+	}
+}
+
+func TestServer_ConfigureRetention(t *testing.T) {
+	dir := ".haraqa-configure-retention"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.CreateTopic(context.Background(), "audit"); err != nil {
+		t.Fatal(err)
+	}
+
+	configure := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/topics/audit", bytes.NewBufferString(body)))
+		return w
+	}
+	if w := configure(`{"config":{"retention":"-1h"}}`); w.Code != http.StatusBadRequest {
+		t.Fatal(w.Code)
+	}
+	if w := configure(`{"config":{"retention":"forever"}}`); w.Code != http.StatusBadRequest {
+		t.Fatal(w.Code)
+	}
+	if w := configure(`{"config":{"readOnly":true}}`); w.Code != http.StatusOK {
+		t.Fatal(w.Code)
+	}
+	if w := configure(`{"config":{"retention":"8760h"}}`); w.Code != http.StatusOK {
+		t.Fatal(w.Code)
+	}
+
+	// the retention is merged with the existing configuration
+	config := s.topicConfigs.get("audit")
+	if config.ReadOnly == nil || !*config.ReadOnly || config.Retention == nil || *config.Retention != headers.Duration(8760*time.Hour) {
+		t.Fatal(config)
+	}
+	if r := s.topicRetention("audit"); r != 8760*time.Hour {
+		t.Fatal(r)
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// WithRollInterval starts a new queue file for a topic once the first message of its current file is older
+// than the interval, even if the file has room for more entries. Retention and truncation remove whole files,
+// so rolling by time keeps old messages of slow topics from waiting on a file that takes long to fill
+func WithRollInterval(interval time.Duration) Option {
+	return func(s *Server) error {
+		if interval < 0 {
+			return errors.New("invalid roll interval")
+		}
+		s.rollInterval = interval
+		return nil
+	}
+}
+
+// setRollInterval sets the roll interval of the queue, if it supports rolling by time
+func (s *Server) setRollInterval() error {
+	q, ok := s.q.(interface{ SetRollInterval(interval time.Duration) })
+	if !ok {
+		return errors.New("roll intervals are not supported by the queue")
+	}
+	q.SetRollInterval(s.rollInterval)
+	return nil
+}
+
+// WithRollSize starts a new queue file for a topic once its messages in the current file hold at least size
+// bytes, even if the file has room for more entries. Topics of large messages then write files small enough
+// to cache and to remove one at a time
+func WithRollSize(size int64) Option {
+	return func(s *Server) error {
+		if size < 0 {
+			return errors.New("invalid roll size")
+		}
+		s.rollSize = size
+		return nil
+	}
+}
+
+// setRollSize sets the roll size of the queue, if it supports rolling by size
+func (s *Server) setRollSize() error {
+	q, ok := s.q.(interface{ SetRollSize(size int64) })
+	if !ok {
+		return errors.New("roll sizes are not supported by the queue")
+	}
+	q.SetRollSize(s.rollSize)
+	return nil
+}
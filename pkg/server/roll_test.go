@@ -0,0 +1,81 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+)
+
+func TestServer_RollInterval(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	q := NewMockQueue(ctrl)
+	if _, err := NewServer(WithQueue(q), WithRollInterval(time.Hour)); err == nil {
+		t.Error("expected unsupported queue error")
+	}
+	if err := WithRollInterval(-time.Hour)(&Server{}); err == nil {
+		t.Error("expected invalid roll interval error")
+	}
+
+	dir := ".haraqa-roll-interval"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithRollInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	if err = s.CreateTopic(ctx, "rolled"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, timestamp := range []time.Time{now.Add(-2 * time.Hour), now} {
+		if err = s.q.Produce(ctx, "rolled", []int64{5}, uint64(timestamp.UnixNano()), bytes.NewBufferString("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the old message is in a file of its own, so it expires without waiting on the new one
+	if n, err := s.expireTopic(ctx, "rolled", now.Add(-time.Hour)); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+}
+
+func TestServer_RollSize(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	q := NewMockQueue(ctrl)
+	if _, err := NewServer(WithQueue(q), WithRollSize(1<<20)); err == nil {
+		t.Error("expected unsupported queue error")
+	}
+	if err := WithRollSize(-1)(&Server{}); err == nil {
+		t.Error("expected invalid roll size error")
+	}
+
+	dir := ".haraqa-roll-size"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithRollSize(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	if err = s.CreateTopic(ctx, "rolled"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(ctx, "rolled", []byte("large")); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(ctx, "rolled", []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the large message filled its file, so it expires without waiting on the next one
+	if n, err := s.expireTopic(ctx, "rolled", time.Now()); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// maxScalingSamples is the number of lag samples kept for each consumer group
+const maxScalingSamples = 64
+
+// WithScalingHints sets the default target lag of each consumer, and the window the lag rate of a group is
+// measured and projected over, of the /scaling/{group} endpoint. The defaults are a target lag of 1000
+// messages and a window of 5 minutes
+func WithScalingHints(targetLag int64, window time.Duration) Option {
+	return func(s *Server) error {
+		if targetLag <= 0 {
+			return errors.New("invalid scaling target lag, value must be greater than 0")
+		}
+		if window <= 0 {
+			return errors.New("invalid scaling window, value must be greater than 0")
+		}
+		s.scaling.targetLag, s.scaling.window = targetLag, window
+		return nil
+	}
+}
+
+// scaling keeps recent lag samples of the consumer groups polled for scaling hints
+type scaling struct {
+	mux       sync.Mutex
+	targetLag int64
+	window    time.Duration
+	samples   map[string][]lagSample
+}
+
+type lagSample struct {
+	time time.Time
+	lag  int64
+}
+
+// observe records the lag of the group at now and returns the change of the lag per second since the oldest
+// sample in the window, or since the latest sample before it. The rate is 0 without a sample a second old
+func (sc *scaling) observe(group string, lag int64, now time.Time) float64 {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	if sc.samples == nil {
+		sc.samples = make(map[string][]lagSample)
+	}
+	samples := sc.samples[group]
+	for len(samples) > 1 && now.Sub(samples[1].time) >= sc.window {
+		samples = samples[1:]
+	}
+
+	var rate float64
+	if len(samples) > 0 {
+		if elapsed := now.Sub(samples[0].time); elapsed >= time.Second {
+			rate = float64(lag-samples[0].lag) / elapsed.Seconds()
+		}
+	}
+
+	// frequent polls only keep a sample every window/maxScalingSamples
+	if len(samples) == 0 || now.Sub(samples[len(samples)-1].time) >= sc.window/maxScalingSamples {
+		if len(samples) >= maxScalingSamples {
+			samples = samples[1:]
+		}
+		samples = append(samples[:len(samples):len(samples)], lagSample{time: now, lag: lag})
+	}
+	sc.samples[group] = samples
+	return rate
+}
+
+// ScalingHint summarizes the lag of the consumer group for autoscalers, with the number of consumers suggested
+// to keep each one's share of the lag within targetLag. The lag the group is projected to have at the end of
+// the scaling window, if it keeps growing at its current rate, is divided among the consumers. A group never
+// needs more consumers than it has topics, as each topic is consumed by one member, and needs none without lag
+func (s *Server) ScalingHint(ctx context.Context, group string, targetLag int64) (*headers.ScalingHint, error) {
+	if group == "" {
+		return nil, headers.ErrInvalidGroup
+	}
+	if targetLag <= 0 {
+		targetLag = s.scaling.targetLag
+	}
+	hint := &headers.ScalingHint{Group: group, TargetLag: targetLag}
+
+	topics := make(map[string]bool)
+	description := s.groups.describe(group)
+	hint.Members = len(description.Members)
+	for _, member := range description.Members {
+		for _, topic := range member.Topics {
+			topics[topic] = true
+		}
+	}
+	for topic, offset := range s.groupOffsets.group(group) {
+		info, err := s.q.InspectTopic(topic)
+		if err != nil {
+			if errors.Cause(err) == headers.ErrTopicDoesNotExist {
+				continue
+			}
+			return nil, err
+		}
+		lag := info.MaxOffset + 1 - offset
+		if lag < 0 {
+			lag = 0
+		}
+		if hint.TopicLag == nil {
+			hint.TopicLag = make(map[string]int64)
+		}
+		hint.TopicLag[topic] = lag
+		hint.Lag += lag
+		topics[topic] = true
+	}
+	hint.Topics = len(topics)
+
+	hint.LagRate = s.scaling.observe(group, hint.Lag, time.Now())
+	projected := float64(hint.Lag) + math.Max(hint.LagRate, 0)*s.scaling.window.Seconds()
+	hint.Consumers = int64(math.Ceil(projected / float64(targetLag)))
+	if hint.Consumers > int64(hint.Topics) {
+		hint.Consumers = int64(hint.Topics)
+	}
+	return hint, nil
+}
+
+// HandleScaling handles requests to the /scaling/{group} endpoint, returning the lag, lag rate and suggested
+// number of consumers of the group. The targetLag query parameter overrides the server's target lag per
+// consumer, so the endpoint can be polled by KEDA or HPA external scalers
+func (s *Server) HandleScaling(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	group := strings.Trim(strings.TrimPrefix(r.URL.Path, "/scaling"), "/")
+	var targetLag int64
+	if v := r.URL.Query().Get("targetLag"); v != "" {
+		var err error
+		if targetLag, err = strconv.ParseInt(v, 10, 64); err != nil || targetLag <= 0 {
+			headers.SetError(w, headers.ErrInvalidMessageLimit)
+			return
+		}
+	}
+	hint, err := s.ScalingHint(r.Context(), group, targetLag)
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+	writeSchemaJSON(w, http.StatusOK, hint)
+}
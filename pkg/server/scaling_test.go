@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestWithScalingHints(t *testing.T) {
+	for _, option := range []Option{WithScalingHints(0, time.Minute), WithScalingHints(10, 0)} {
+		if err := option(&Server{}); err == nil {
+			t.Error("expected invalid option error")
+		}
+	}
+	s := &Server{}
+	if err := WithScalingHints(10, time.Minute)(s); err != nil || s.scaling.targetLag != 10 || s.scaling.window != time.Minute {
+		t.Fatal(s.scaling.targetLag, s.scaling.window, err)
+	}
+}
+
+func TestScaling_Observe(t *testing.T) {
+	sc := &scaling{window: time.Minute}
+	start := time.Date(2021, time.January, 30, 10, 30, 0, 0, time.UTC)
+	if rate := sc.observe("g", 100, start); rate != 0 {
+		t.Fatal(rate)
+	}
+	if rate := sc.observe("g", 200, start.Add(100*time.Millisecond)); rate != 0 {
+		t.Fatal(rate)
+	}
+	if rate := sc.observe("g", 400, start.Add(30*time.Second)); rate != 10 {
+		t.Fatal(rate)
+	}
+	// the rate is measured from the latest sample a window old
+	if rate := sc.observe("g", 100, start.Add(90*time.Second)); rate != -5 {
+		t.Fatal(rate)
+	}
+	if rate := sc.observe("other", 100, start.Add(90*time.Second)); rate != 0 {
+		t.Fatal(rate)
+	}
+
+	// frequent polls keep a bounded number of samples
+	for i := 0; i < 1000; i++ {
+		sc.observe("g", 100, start.Add(2*time.Minute+time.Duration(i)*time.Millisecond*100))
+	}
+	if n := len(sc.samples["g"]); n > maxScalingSamples {
+		t.Fatal(n)
+	}
+}
+
+func TestServer_ScalingHint(t *testing.T) {
+	dir := ".haraqa-scaling"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithScalingHints(2, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	for _, topic := range []string{"orders", "payments", "refunds"} {
+		if err = s.CreateTopic(ctx, topic); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.ProduceMsgs(ctx, topic, []byte("a"), []byte("b"), []byte("c")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = s.JoinGroup("billing", "m1", []string{"orders", "payments", "refunds"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.CommitOffsets("billing", map[string]int64{"orders": 0, "payments": 2, "deleted": 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	hint, err := s.ScalingHint(ctx, "billing", 0)
+	if err != nil || hint.Lag != 4 || hint.TopicLag["orders"] != 3 || hint.TopicLag["payments"] != 1 || len(hint.TopicLag) != 2 ||
+		hint.Topics != 3 || hint.Members != 1 || hint.TargetLag != 2 || hint.Consumers != 2 || hint.LagRate != 0 {
+		t.Fatal(hint, err)
+	}
+	// a group never needs more consumers than topics
+	if hint, err = s.ScalingHint(ctx, "billing", 1); err != nil || hint.Consumers != 3 {
+		t.Fatal(hint, err)
+	}
+	// growing lag is projected over the window
+	s.scaling.mux.Lock()
+	s.scaling.samples["billing"] = []lagSample{{time: time.Now().Add(-30 * time.Second), lag: 4}}
+	s.scaling.mux.Unlock()
+	if err = s.ProduceMsgs(ctx, "orders", []byte("d")); err != nil {
+		t.Fatal(err)
+	}
+	if hint, err = s.ScalingHint(ctx, "billing", 10); err != nil || hint.LagRate <= 0 || hint.Consumers != 1 {
+		t.Fatal(hint, err)
+	}
+
+	// a group without lag needs no consumers
+	if hint, err = s.ScalingHint(ctx, "idle", 0); err != nil || hint.Lag != 0 || hint.Consumers != 0 || hint.Topics != 0 {
+		t.Fatal(hint, err)
+	}
+	if _, err = s.ScalingHint(ctx, "", 0); err != headers.ErrInvalidGroup {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_HandleScaling(t *testing.T) {
+	dir := ".haraqa-handle-scaling"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	if err = s.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(ctx, "orders", []byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.CommitOffsets("billing", map[string]int64{"orders": 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+	w := request(http.MethodGet, "/scaling/billing?targetLag=1")
+	var hint headers.ScalingHint
+	if err = json.Unmarshal(w.Body.Bytes(), &hint); err != nil || w.Code != http.StatusOK || hint.Group != "billing" || hint.Lag != 2 ||
+		hint.TargetLag != 1 || hint.Consumers != 1 {
+		t.Fatal(w.Code, w.Body.String(), err)
+	}
+	if w = request(http.MethodGet, "/scaling/billing?targetLag=none"); w.Code != http.StatusBadRequest {
+		t.Fatal(w.Code)
+	}
+	if w = request(http.MethodGet, "/scaling/"); w.Code != http.StatusBadRequest || headers.ReadErrors(w.Header()) != headers.ErrInvalidGroup {
+		t.Fatal(w.Code)
+	}
+	if w = request(http.MethodPost, "/scaling/billing"); w.Code != http.StatusMethodNotAllowed {
+		t.Fatal(w.Code)
+	}
+}
@@ -0,0 +1,420 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// WithSchedules enables scheduled requests, which admins manage at the /schedules endpoint. A schedule sends
+// a request to the server whenever its cron expression matches, so that recurring operations such as
+// truncating or creating topics do not need external cron jobs. Schedules are stored in the file so that they
+// are kept across restarts, or only in memory if it is empty. Runs missed while the server was stopped are
+// not made up
+func WithSchedules(file string) Option {
+	return func(s *Server) error {
+		s.scheduler.enabled, s.scheduler.file = true, file
+		s.scheduler.schedules = make(map[string]*scheduled)
+		return s.scheduler.load(time.Now())
+	}
+}
+
+// scheduler holds the schedules managed at the /schedules endpoint by name, and the jobs the server schedules
+// itself, such as the truncations of topics, by name
+type scheduler struct {
+	enabled   bool
+	file      string
+	mux       sync.Mutex
+	schedules map[string]*scheduled
+	jobs      map[string]*scheduled
+	once      sync.Once
+}
+
+// scheduled is a schedule with its parsed cron expression and the state of its runs. A job runs its function
+// instead of sending the schedule's request
+type scheduled struct {
+	schedule   headers.Schedule
+	job        func(ctx context.Context, now time.Time)
+	cron       *cronSchedule
+	location   *time.Location
+	next       time.Time
+	lastRun    time.Time
+	lastStatus int
+	running    bool
+}
+
+// newScheduled validates the schedule, returning it with its first run after now
+func newScheduled(schedule headers.Schedule, now time.Time) (*scheduled, error) {
+	if schedule.Name == "" || strings.ContainsAny(schedule.Name, "/?#") {
+		return nil, errors.Wrap(headers.ErrInvalidBodyJSON, "schedule names must not be empty or contain /, ? or #")
+	}
+	c, err := parseCron(schedule.Cron)
+	if err != nil {
+		return nil, errors.Wrap(headers.ErrInvalidBodyJSON, err.Error())
+	}
+	location, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return nil, errors.Wrap(headers.ErrInvalidBodyJSON, "invalid schedule timezone")
+	}
+	schedule.Method = strings.ToUpper(schedule.Method)
+	if schedule.Method == "" || strings.ContainsAny(schedule.Method, " \t\r\n") {
+		return nil, errors.Wrap(headers.ErrInvalidBodyJSON, "invalid schedule method")
+	}
+	if u, err := url.ParseRequestURI(schedule.Path); err != nil || u.IsAbs() || !strings.HasPrefix(schedule.Path, "/") {
+		return nil, errors.Wrap(headers.ErrInvalidBodyJSON, "schedule path must be an absolute path of the server")
+	}
+	next := c.next(now.In(location))
+	if next.IsZero() {
+		return nil, errors.Wrap(headers.ErrInvalidBodyJSON, "schedule cron expression never matches")
+	}
+	schedule.LastRun, schedule.LastStatus, schedule.NextRun = nil, 0, nil
+	return &scheduled{schedule: schedule, cron: c, location: location, next: next}, nil
+}
+
+// load reads the stored schedules
+func (sch *scheduler) load(now time.Time) error {
+	if sch.file == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(sch.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to read schedule file")
+	}
+	var schedules []headers.Schedule
+	if err = json.Unmarshal(b, &schedules); err != nil {
+		return errors.Wrap(err, "unable to parse schedule file")
+	}
+	for _, schedule := range schedules {
+		sc, err := newScheduled(schedule, now)
+		if err != nil {
+			return errors.Wrapf(err, "invalid schedule %q in schedule file", schedule.Name)
+		}
+		sch.schedules[schedule.Name] = sc
+	}
+	return nil
+}
+
+// save writes the schedules to the file, replacing it atomically
+func (sch *scheduler) save() error {
+	if sch.file == "" {
+		return nil
+	}
+	schedules := make([]headers.Schedule, 0, len(sch.schedules))
+	for _, sc := range sch.sorted() {
+		schedules = append(schedules, sc.schedule)
+	}
+	b, err := json.Marshal(schedules)
+	if err != nil {
+		return err
+	}
+	tmp := sch.file + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "unable to write schedule file")
+	}
+	if err = os.Rename(tmp, sch.file); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrap(err, "unable to replace schedule file")
+	}
+	return nil
+}
+
+// sorted returns the schedules sorted by name
+func (sch *scheduler) sorted() []*scheduled {
+	schedules := make([]*scheduled, 0, len(sch.schedules))
+	for _, sc := range sch.schedules {
+		schedules = append(schedules, sc)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].schedule.Name < schedules[j].schedule.Name
+	})
+	return schedules
+}
+
+// list returns every schedule with the state of its runs
+func (sch *scheduler) list() []headers.Schedule {
+	sch.mux.Lock()
+	defer sch.mux.Unlock()
+	schedules := make([]headers.Schedule, 0, len(sch.schedules))
+	for _, sc := range sch.sorted() {
+		schedule := sc.schedule
+		next := sc.next
+		schedule.NextRun = &next
+		if !sc.lastRun.IsZero() {
+			last := sc.lastRun
+			schedule.LastRun, schedule.LastStatus = &last, sc.lastStatus
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules
+}
+
+// put adds or replaces the schedule with the same name, returning true if it was added
+func (sch *scheduler) put(schedule headers.Schedule, now time.Time) (bool, error) {
+	sc, err := newScheduled(schedule, now)
+	if err != nil {
+		return false, err
+	}
+	sch.mux.Lock()
+	defer sch.mux.Unlock()
+	old, exists := sch.schedules[schedule.Name]
+	sch.schedules[schedule.Name] = sc
+	if err := sch.save(); err != nil {
+		if exists {
+			sch.schedules[schedule.Name] = old
+		} else {
+			delete(sch.schedules, schedule.Name)
+		}
+		return false, err
+	}
+	return !exists, nil
+}
+
+// delete removes the schedule
+func (sch *scheduler) delete(name string) error {
+	sch.mux.Lock()
+	defer sch.mux.Unlock()
+	old, ok := sch.schedules[name]
+	if !ok {
+		return headers.ErrScheduleDoesNotExist
+	}
+	delete(sch.schedules, name)
+	if err := sch.save(); err != nil {
+		sch.schedules[name] = old
+		return err
+	}
+	return nil
+}
+
+// setJob adds or replaces the job with the name, which runs the function whenever the cron expression matches
+// in UTC
+func (sch *scheduler) setJob(name, expr string, job func(ctx context.Context, now time.Time)) error {
+	c, err := parseCron(expr)
+	if err != nil {
+		return errors.Wrap(headers.ErrInvalidBodyJSON, err.Error())
+	}
+	next := c.next(time.Now().UTC())
+	if next.IsZero() {
+		return errors.Wrap(headers.ErrInvalidBodyJSON, "cron expression never matches")
+	}
+	sch.mux.Lock()
+	defer sch.mux.Unlock()
+	if sch.jobs == nil {
+		sch.jobs = make(map[string]*scheduled)
+	}
+	sch.jobs[name] = &scheduled{schedule: headers.Schedule{Name: name, Cron: expr}, job: job, cron: c, location: time.UTC, next: next}
+	return nil
+}
+
+// removeJob removes the job with the name if it exists, a run in progress is not interrupted
+func (sch *scheduler) removeJob(name string) {
+	sch.mux.Lock()
+	delete(sch.jobs, name)
+	sch.mux.Unlock()
+}
+
+// due marks the schedules and jobs whose next run is not after now as running and moves them to their
+// following run. A schedule whose previous run has not finished is skipped
+func (sch *scheduler) due(now time.Time) []*scheduled {
+	sch.mux.Lock()
+	defer sch.mux.Unlock()
+	var due []*scheduled
+	all := sch.sorted()
+	for _, sc := range sch.jobs {
+		all = append(all, sc)
+	}
+	for _, sc := range all {
+		if sc.next.After(now) {
+			continue
+		}
+		sc.next = sc.cron.next(now.In(sc.location))
+		if sc.running {
+			continue
+		}
+		sc.running, sc.lastRun = true, now
+		due = append(due, sc)
+	}
+	return due
+}
+
+// finish records the status of the schedule's run
+func (sch *scheduler) finish(sc *scheduled, status int) {
+	sch.mux.Lock()
+	defer sch.mux.Unlock()
+	sc.running, sc.lastStatus = false, status
+}
+
+// startScheduler starts the scheduler if it is not already running. It is started by the server if schedules
+// are enabled, or later by the first job, so that topics can be given truncation rules without restarting
+// the server
+func (s *Server) startScheduler() {
+	s.scheduler.once.Do(func() {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.runSchedules()
+		}()
+	})
+}
+
+// runSchedules runs the schedules and jobs as they become due, checking at the start of each minute
+func (s *Server) runSchedules() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for {
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		select {
+		case <-s.done:
+			timer.Stop()
+			return
+		case now = <-timer.C:
+			s.runDueSchedules(ctx, now)
+		}
+	}
+}
+
+// runDueSchedules starts the schedules and jobs which are due
+func (s *Server) runDueSchedules(ctx context.Context, now time.Time) {
+	for _, sc := range s.scheduler.due(now) {
+		s.wg.Add(1)
+		go func(sc *scheduled) {
+			defer s.wg.Done()
+			if sc.job != nil {
+				sc.job(ctx, now)
+				s.scheduler.finish(sc, 0)
+				return
+			}
+			s.scheduler.finish(sc, s.runSchedule(ctx, sc.schedule))
+		}(sc)
+	}
+}
+
+// runSchedule sends the request of the schedule to the server, returning its status code
+func (s *Server) runSchedule(ctx context.Context, schedule headers.Schedule) int {
+	start := time.Now()
+	r, err := http.NewRequest(schedule.Method, schedule.Path, strings.NewReader(schedule.Body))
+	if err != nil {
+		s.logError("unable to create scheduled request", err, "schedule", schedule.Name)
+		return http.StatusInternalServerError
+	}
+	r = r.WithContext(ctx)
+	r.RequestURI = schedule.Path
+	for k, v := range schedule.Headers {
+		r.Header.Set(k, v)
+	}
+	w := &bufferWriter{header: make(http.Header)}
+	s.recoverPanics(s.router).ServeHTTP(w, r)
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	elapsed := time.Since(start).String()
+	if w.status >= http.StatusBadRequest {
+		err = headers.ReadErrors(w.header)
+		if err == nil {
+			err = errors.New(http.StatusText(w.status))
+		}
+		// failures of requests sent by the server itself are logged as errors, unlike those of clients
+		s.logger.Error("scheduled request failed", "schedule", schedule.Name, "method", schedule.Method,
+			"path", schedule.Path, "status", w.status, "duration", elapsed, "err", err)
+		return w.status
+	}
+	s.logger.Info("scheduled request sent", "schedule", schedule.Name, "method", schedule.Method,
+		"path", schedule.Path, "status", w.status, "duration", elapsed)
+	return w.status
+}
+
+// Schedules returns every schedule with the times of its last and next run
+func (s *Server) Schedules(ctx context.Context) ([]headers.Schedule, error) {
+	if !s.scheduler.enabled {
+		return nil, errors.New("schedules are not enabled")
+	}
+	return s.scheduler.list(), nil
+}
+
+// PutSchedule adds the schedule, or replaces the schedule with the same name. It returns true if the schedule
+// was added
+func (s *Server) PutSchedule(ctx context.Context, schedule headers.Schedule) (bool, error) {
+	if !s.scheduler.enabled {
+		return false, errors.New("schedules are not enabled")
+	}
+	created, err := s.scheduler.put(schedule, time.Now())
+	if err != nil {
+		return false, err
+	}
+	s.logger.Info("schedule set", "schedule", schedule.Name, "cron", schedule.Cron, "method", schedule.Method, "path", schedule.Path)
+	return created, nil
+}
+
+// DeleteSchedule removes the schedule, a run in progress is not interrupted
+func (s *Server) DeleteSchedule(ctx context.Context, name string) error {
+	if !s.scheduler.enabled {
+		return errors.New("schedules are not enabled")
+	}
+	if err := s.scheduler.delete(name); err != nil {
+		return err
+	}
+	s.logger.Info("schedule removed", "schedule", name)
+	return nil
+}
+
+// HandleSchedules handles requests to the /schedules endpoints. GET /schedules lists the schedules, PUT
+// /schedules/{name} sets the schedule in the json body and DELETE /schedules/{name} removes it
+func (s *Server) HandleSchedules(w http.ResponseWriter, r *http.Request) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/schedules"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		schedules, err := s.Schedules(r.Context())
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		writeSchemaJSON(w, http.StatusOK, map[string][]headers.Schedule{"schedules": schedules})
+	case r.Method == http.MethodPut && name != "":
+		var schedule headers.Schedule
+		if r.Body == nil || json.NewDecoder(r.Body).Decode(&schedule) != nil {
+			headers.SetError(w, headers.ErrInvalidBodyJSON)
+			return
+		}
+		schedule.Name = name
+		created, err := s.PutSchedule(r.Context(), schedule)
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		if created {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && name != "":
+		if err := s.DeleteSchedule(r.Context(), name); err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
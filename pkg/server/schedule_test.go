@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestScheduler(t *testing.T) {
+	now := time.Date(2021, time.January, 30, 10, 30, 15, 0, time.UTC)
+	sch := &scheduler{schedules: make(map[string]*scheduled)}
+	for _, schedule := range []headers.Schedule{
+		{Cron: "@daily", Method: "GET", Path: "/topics"},
+		{Name: "a/b", Cron: "@daily", Method: "GET", Path: "/topics"},
+		{Name: "a", Cron: "* *", Method: "GET", Path: "/topics"},
+		{Name: "a", Cron: "@daily", Timezone: "Nowhere/Else", Method: "GET", Path: "/topics"},
+		{Name: "a", Cron: "@daily", Path: "/topics"},
+		{Name: "a", Cron: "@daily", Method: "GET", Path: "http://example.com/topics"},
+		{Name: "a", Cron: "0 0 30 2 *", Method: "GET", Path: "/topics"},
+	} {
+		if _, err := sch.put(schedule, now); errors.Cause(err) != headers.ErrInvalidBodyJSON {
+			t.Fatal(schedule, err)
+		}
+	}
+	if created, err := sch.put(headers.Schedule{Name: "hourly", Cron: "@hourly", Method: "patch", Path: "/topics/a"}, now); err != nil || !created {
+		t.Fatal(created, err)
+	}
+	if _, err := sch.put(headers.Schedule{Name: "daily", Cron: "30 10 * * *", Timezone: "America/New_York", Method: "PUT", Path: "/topics/b"}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if due := sch.due(now.Add(29 * time.Minute)); len(due) != 0 {
+		t.Fatal(due)
+	}
+	due := sch.due(now.Add(30 * time.Minute))
+	if len(due) != 1 || due[0].schedule.Name != "hourly" || due[0].schedule.Method != http.MethodPatch {
+		t.Fatal(due)
+	}
+	// a schedule is skipped while its previous run is still running
+	if due = sch.due(now.Add(90 * time.Minute)); len(due) != 0 {
+		t.Fatal(due)
+	}
+	sch.finish(sch.schedules["hourly"], http.StatusNoContent)
+	schedules := sch.list()
+	if len(schedules) != 2 || schedules[0].Name != "daily" || schedules[1].LastStatus != http.StatusNoContent ||
+		!schedules[1].NextRun.Equal(time.Date(2021, time.January, 30, 13, 0, 0, 0, time.UTC)) {
+		t.Fatal(schedules)
+	}
+	// the daily schedule runs at 10:30 in new york
+	if !schedules[0].NextRun.Equal(time.Date(2021, time.January, 30, 15, 30, 0, 0, time.UTC)) || schedules[0].LastRun != nil {
+		t.Fatal(schedules[0].NextRun)
+	}
+
+	if err := sch.delete("daily"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sch.delete("daily"); err != headers.ErrScheduleDoesNotExist {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_Schedules(t *testing.T) {
+	dir, file := ".haraqa-schedules", ".haraqa-schedules.json"
+	defer os.RemoveAll(dir)
+	defer os.Remove(file)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithSchedules(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	if created, err := s.PutSchedule(ctx, headers.Schedule{Name: "create", Cron: "* * * * *", Method: "PUT", Path: "/topics/scheduled"}); err != nil || !created {
+		t.Fatal(created, err)
+	}
+	if created, err := s.PutSchedule(ctx, headers.Schedule{Name: "produce", Cron: "* * * * *", Method: "POST", Path: "/topics/scheduled",
+		Headers: map[string]string{headers.HeaderSizes: "5"}, Body: "hello"}); err != nil || !created {
+		t.Fatal(created, err)
+	}
+
+	// the schedules are restored from the file
+	restored := &scheduler{file: file, schedules: make(map[string]*scheduled)}
+	if err = restored.load(time.Now()); err != nil || len(restored.schedules) != 2 || restored.schedules["produce"].schedule.Body != "hello" {
+		t.Fatal(restored.schedules, err)
+	}
+
+	// runs are sent to the server without authentication
+	s.runSchedule(ctx, s.scheduler.schedules["create"].schedule)
+	if status := s.runSchedule(ctx, s.scheduler.schedules["create"].schedule); status != http.StatusPreconditionFailed {
+		t.Fatal(status)
+	}
+	if status := s.runSchedule(ctx, s.scheduler.schedules["produce"].schedule); status != http.StatusNoContent {
+		t.Fatal(status)
+	}
+	msgs, err := s.ConsumeMsgs(ctx, "scheduled", 0, 1)
+	if err != nil || len(msgs) != 1 || string(msgs[0]) != "hello" {
+		t.Fatal(msgs, err)
+	}
+
+	if err = s.DeleteSchedule(ctx, "create"); err != nil {
+		t.Fatal(err)
+	}
+	if schedules, err := s.Schedules(ctx); err != nil || len(schedules) != 1 || schedules[0].Name != "produce" {
+		t.Fatal(schedules, err)
+	}
+	if _, err = (&Server{}).Schedules(ctx); err == nil {
+		t.Fatal("expected schedules are not enabled error")
+	}
+}
+
+func TestServer_HandleSchedules(t *testing.T) {
+	dir := ".haraqa-handle-schedules"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithSchedules(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	request := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		var b []byte
+		if body != nil {
+			b, _ = json.Marshal(body)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(b)))
+		return w
+	}
+	schedule := headers.Schedule{Cron: "0 3 * * *", Method: "DELETE", Path: "/topics/nightly"}
+	if w := request(http.MethodPut, "/schedules/nightly", schedule); w.Code != http.StatusCreated {
+		t.Fatal(w.Code)
+	}
+	if w := request(http.MethodPut, "/schedules/nightly", schedule); w.Code != http.StatusNoContent {
+		t.Fatal(w.Code)
+	}
+	if w := request(http.MethodPut, "/schedules/invalid", headers.Schedule{Cron: "never"}); w.Code != http.StatusBadRequest {
+		t.Fatal(w.Code)
+	}
+	if w := request(http.MethodPost, "/schedules", nil); w.Code != http.StatusMethodNotAllowed {
+		t.Fatal(w.Code)
+	}
+	w := request(http.MethodGet, "/schedules", nil)
+	var schedules struct {
+		Schedules []headers.Schedule `json:"schedules"`
+	}
+	if err = json.Unmarshal(w.Body.Bytes(), &schedules); err != nil || len(schedules.Schedules) != 1 || schedules.Schedules[0].NextRun == nil {
+		t.Fatal(w.Body.String(), err)
+	}
+	if w = request(http.MethodDelete, "/schedules/nightly", nil); w.Code != http.StatusNoContent {
+		t.Fatal(w.Code)
+	}
+	if w = request(http.MethodDelete, "/schedules/nightly", nil); w.Code != http.StatusNotFound || headers.ReadErrors(w.Header()) != headers.ErrScheduleDoesNotExist {
+		t.Fatal(w.Code)
+	}
+}
@@ -0,0 +1,356 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// Schema types
+const (
+	SchemaTypeJSON     = "json"
+	SchemaTypeProtobuf = "protobuf"
+)
+
+// Schema is a version of the schema of a topic. JSON schemas are given in Schema, protobuf schemas are
+// given as a FileDescriptorSet in Descriptor with the fully qualified name of the message type in Message
+type Schema struct {
+	Topic      string          `json:"topic"`
+	Version    int             `json:"version"`
+	Type       string          `json:"type"`
+	Schema     json.RawMessage `json:"schema,omitempty"`
+	Descriptor []byte          `json:"descriptor,omitempty"`
+	Message    string          `json:"message,omitempty"`
+	Created    time.Time       `json:"created"`
+}
+
+type validator interface {
+	validate(msg []byte) error
+}
+
+// compile returns the validator of the schema
+func (schema *Schema) compile() (validator, error) {
+	var v validator
+	var err error
+	switch schema.Type {
+	case SchemaTypeJSON:
+		if len(schema.Schema) == 0 {
+			return nil, errors.Wrap(headers.ErrInvalidSchema, "json schemas require a schema")
+		}
+		v, err = compileJSONSchema(schema.Schema)
+	case SchemaTypeProtobuf:
+		if len(schema.Descriptor) == 0 || schema.Message == "" {
+			return nil, errors.Wrap(headers.ErrInvalidSchema, "protobuf schemas require a descriptor and message")
+		}
+		v, err = compileProtoSchema(schema.Descriptor, schema.Message)
+	default:
+		return nil, errors.Wrapf(headers.ErrInvalidSchema, "unknown schema type %q", schema.Type)
+	}
+	if err != nil {
+		return nil, errors.Wrap(headers.ErrInvalidSchema, err.Error())
+	}
+	return v, nil
+}
+
+// WithSchemaRegistry enables registering a schema for each topic at the /schemas endpoint. Schemas are
+// stored in the file, or only in memory if it is empty. If validate is set, produced messages are rejected
+// unless they are valid against the latest schema of their topic, topics without a schema are not checked
+func WithSchemaRegistry(file string, validate bool) Option {
+	return func(s *Server) error {
+		r := &schemaRegistry{
+			file:       file,
+			validate:   validate,
+			schemas:    make(map[string][]*Schema),
+			validators: make(map[string]validator),
+		}
+		if err := r.load(); err != nil {
+			return err
+		}
+		s.schemas = r
+		return nil
+	}
+}
+
+type schemaRegistry struct {
+	file       string
+	validate   bool
+	mux        sync.RWMutex
+	schemas    map[string][]*Schema
+	validators map[string]validator
+}
+
+// load reads the stored schemas and compiles the latest version of each topic
+func (r *schemaRegistry) load() error {
+	if r.file == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(r.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to read schema file")
+	}
+	if err = json.Unmarshal(b, &r.schemas); err != nil {
+		return errors.Wrap(err, "unable to parse schema file")
+	}
+	for topic, versions := range r.schemas {
+		if len(versions) == 0 {
+			delete(r.schemas, topic)
+			continue
+		}
+		v, err := versions[len(versions)-1].compile()
+		if err != nil {
+			return errors.Wrapf(err, "invalid schema for topic %q", topic)
+		}
+		r.validators[topic] = v
+	}
+	return nil
+}
+
+// save writes the schemas to the file, replacing it atomically
+func (r *schemaRegistry) save() error {
+	if r.file == "" {
+		return nil
+	}
+	b, err := json.Marshal(r.schemas)
+	if err != nil {
+		return err
+	}
+	tmp := r.file + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "unable to write schema file")
+	}
+	if err = os.Rename(tmp, r.file); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrap(err, "unable to replace schema file")
+	}
+	return nil
+}
+
+func (r *schemaRegistry) validator(topic string) validator {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.validators[topic]
+}
+
+// RegisterSchema adds a new version of the schema of the topic, which is used to validate messages
+// produced from then on. The topic does not need to exist yet
+func (s *Server) RegisterSchema(ctx context.Context, topic string, schema Schema) (*Schema, error) {
+	if s.schemas == nil {
+		return nil, errors.New("schema registry is not enabled")
+	}
+	topic, err := cleanTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	v, err := schema.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	r := s.schemas
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	versions := r.schemas[topic]
+	schema.Topic, schema.Version, schema.Created = topic, 1, time.Now().UTC()
+	if len(versions) > 0 {
+		schema.Version = versions[len(versions)-1].Version + 1
+	}
+	r.schemas[topic] = append(versions, &schema)
+	if err = r.save(); err != nil {
+		r.schemas[topic] = versions
+		s.logError("unable to save schema", err, "topic", topic)
+		return nil, err
+	}
+	r.validators[topic] = v
+	s.logger.Info("schema registered", "topic", topic, "version", schema.Version, "type", schema.Type)
+	return &schema, nil
+}
+
+// GetSchema returns a version of the schema of the topic, or the latest version if version is less than 1
+func (s *Server) GetSchema(ctx context.Context, topic string, version int) (*Schema, error) {
+	if s.schemas == nil {
+		return nil, errors.New("schema registry is not enabled")
+	}
+	topic, err := cleanTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	r := s.schemas
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	versions := r.schemas[topic]
+	if len(versions) == 0 {
+		return nil, headers.ErrSchemaDoesNotExist
+	}
+	if version < 1 {
+		return versions[len(versions)-1], nil
+	}
+	for _, schema := range versions {
+		if schema.Version == version {
+			return schema, nil
+		}
+	}
+	return nil, headers.ErrSchemaDoesNotExist
+}
+
+// ListSchemas returns the topics which have a schema
+func (s *Server) ListSchemas(ctx context.Context) ([]string, error) {
+	if s.schemas == nil {
+		return nil, errors.New("schema registry is not enabled")
+	}
+	r := s.schemas
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	topics := make([]string, 0, len(r.schemas))
+	for topic := range r.schemas {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+// DeleteSchema removes all versions of the schema of the topic, messages are no longer validated
+func (s *Server) DeleteSchema(ctx context.Context, topic string) error {
+	if s.schemas == nil {
+		return errors.New("schema registry is not enabled")
+	}
+	topic, err := cleanTopic(topic)
+	if err != nil {
+		return err
+	}
+	r := s.schemas
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	versions, ok := r.schemas[topic]
+	if !ok {
+		return headers.ErrSchemaDoesNotExist
+	}
+	delete(r.schemas, topic)
+	if err = r.save(); err != nil {
+		r.schemas[topic] = versions
+		s.logError("unable to save schema", err, "topic", topic)
+		return err
+	}
+	delete(r.validators, topic)
+	s.logger.Info("schema deleted", "topic", topic)
+	return nil
+}
+
+// validateMsgs reads the messages of a produce request and validates them against the schema of the
+// topic, returning a reader of the validated messages. The body is only buffered if the topic has a schema
+func (s *Server) validateMsgs(topic string, sizes []int64, r io.Reader) (io.Reader, error) {
+	if s.schemas == nil || !s.schemas.validate {
+		return r, nil
+	}
+	v := s.schemas.validator(topic)
+	if v == nil {
+		return r, nil
+	}
+	var total int64
+	for _, size := range sizes {
+		if size < 0 {
+			return nil, headers.ErrInvalidHeaderSizes
+		}
+		total += size
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r, total))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) < total {
+		return nil, headers.ErrInvalidBodyMissing
+	}
+	msgs := body
+	for i, size := range sizes {
+		if err := v.validate(msgs[:size]); err != nil {
+			return nil, errors.Wrapf(headers.ErrInvalidMessage, "message %d: %s", i, err.Error())
+		}
+		msgs = msgs[size:]
+	}
+	return bytes.NewReader(body), nil
+}
+
+// HandleSchemas handles requests to the /schemas endpoints. GET /schemas lists the topics with a schema,
+// GET /schemas/{topic} returns the latest schema or the version given by the version query parameter,
+// PUT /schemas/{topic} registers a new version and DELETE /schemas/{topic} removes the schema
+func (s *Server) HandleSchemas(w http.ResponseWriter, r *http.Request) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+	topic := strings.Trim(strings.TrimPrefix(r.URL.Path, "/schemas"), "/")
+	if topic == "" {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		topics, err := s.ListSchemas(r.Context())
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		writeSchemaJSON(w, http.StatusOK, map[string][]string{"topics": topics})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		var version int
+		if v := r.URL.Query().Get("version"); v != "" {
+			var err error
+			if version, err = strconv.Atoi(v); err != nil || version < 1 {
+				headers.SetError(w, errors.Wrap(headers.ErrSchemaDoesNotExist, "invalid version"))
+				return
+			}
+		}
+		schema, err := s.GetSchema(r.Context(), topic, version)
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		writeSchemaJSON(w, http.StatusOK, schema)
+	case http.MethodPut:
+		if r.Body == nil {
+			headers.SetError(w, headers.ErrInvalidBodyMissing)
+			return
+		}
+		var schema Schema
+		if err := json.NewDecoder(r.Body).Decode(&schema); err != nil {
+			headers.SetError(w, headers.ErrInvalidBodyJSON)
+			return
+		}
+		registered, err := s.RegisterSchema(r.Context(), topic, schema)
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		writeSchemaJSON(w, http.StatusCreated, registered)
+	case http.MethodDelete:
+		if err := s.DeleteSchema(r.Context(), topic); err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func writeSchemaJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header()[headers.ContentType] = []string{"application/json"}
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
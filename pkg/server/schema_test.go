@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestServer_Schemas(t *testing.T) {
+	dir := ".haraqa-schemas"
+	_ = os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "schemas.json")
+
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithSchemaRegistry(file, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err = s.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path, body string) (int, string) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		b, _ := ioutil.ReadAll(w.Result().Body)
+		return w.Code, strings.TrimSpace(string(b))
+	}
+
+	// topics without a schema are not validated
+	if err = s.ProduceMsgs(ctx, "orders", []byte("not json")); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := do(http.MethodGet, "/schemas/orders", ""); code != http.StatusNotFound {
+		t.Fatal(code, body)
+	}
+	if code, body := do(http.MethodPut, "/schemas/orders", `{"type":"json","schema":{"type":"object","required":["id"]}}`); code != http.StatusCreated || !strings.Contains(body, `"topic":"orders","version":1`) {
+		t.Fatal(code, body)
+	}
+	if code, body := do(http.MethodPut, "/schemas/orders", `{"type":"json","schema":{"type":"object","required":["id","total"]}}`); code != http.StatusCreated || !strings.Contains(body, `"version":2`) {
+		t.Fatal(code, body)
+	}
+	for _, body := range []string{`{"type":"xml"}`, `{"type":"json"}`, `{"type":"json","schema":{"type":"float"}}`, `{"type":"protobuf","message":"A"}`} {
+		if code, resp := do(http.MethodPut, "/schemas/orders", body); code != http.StatusBadRequest {
+			t.Error(body, code, resp)
+		}
+	}
+	if code, body := do(http.MethodPut, "/schemas/orders", `{`); code != http.StatusBadRequest {
+		t.Fatal(code, body)
+	}
+
+	// messages are validated against the latest version
+	err = s.ProduceMsgs(ctx, "orders", []byte(`{"id":1,"total":2}`), []byte(`{"id":2}`))
+	if errors.Cause(err) != headers.ErrInvalidMessage || !strings.Contains(err.Error(), `message 1: $: missing required property "total"`) {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(ctx, "orders", []byte(`{"id":1,"total":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/topics/orders", strings.NewReader(`{}`))
+	r.Header[headers.HeaderSizes] = []string{"2"}
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest || w.Header().Get(headers.HeaderErrors) != "invalid message: schema validation failed" {
+		t.Fatal(w.Code, w.Header())
+	}
+	r = httptest.NewRequest(http.MethodPost, "/topics/orders", strings.NewReader(`{}`))
+	r.Header[headers.HeaderSizes] = []string{"3"}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatal(w.Code, w.Header())
+	}
+	if msgs, err := s.ConsumeMsgs(ctx, "orders", 0, 10); err != nil || len(msgs) != 2 {
+		t.Fatal(msgs, err)
+	}
+
+	// versions and listing
+	code, body := do(http.MethodGet, "/schemas/orders?version=1", "")
+	var schema Schema
+	if err = json.Unmarshal([]byte(body), &schema); code != http.StatusOK || err != nil || schema.Version != 1 || string(schema.Schema) != `{"type":"object","required":["id"]}` {
+		t.Fatal(code, body)
+	}
+	if code, body = do(http.MethodGet, "/schemas/orders?version=3", ""); code != http.StatusNotFound {
+		t.Fatal(code, body)
+	}
+	if code, body = do(http.MethodGet, "/schemas/orders?version=x", ""); code != http.StatusNotFound {
+		t.Fatal(code, body)
+	}
+	if code, body = do(http.MethodGet, "/schemas", ""); code != http.StatusOK || body != `{"topics":["orders"]}` {
+		t.Fatal(code, body)
+	}
+	if code, body = do(http.MethodPost, "/schemas", ""); code != http.StatusMethodNotAllowed {
+		t.Fatal(code, body)
+	}
+	if code, body = do(http.MethodPost, "/schemas/orders", ""); code != http.StatusMethodNotAllowed {
+		t.Fatal(code, body)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// schemas are reloaded from the file
+	s, err = NewServer(WithFileQueue([]string{dir}, true, 5000), WithSchemaRegistry(file, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if schema, err := s.GetSchema(ctx, "orders", 0); err != nil || schema.Version != 2 {
+		t.Fatal(schema, err)
+	}
+	if err = s.ProduceMsgs(ctx, "orders", []byte("not validated")); err != nil {
+		t.Fatal(err)
+	}
+	if code, body = do(http.MethodDelete, "/schemas/orders", ""); code != http.StatusNoContent {
+		t.Fatal(code, body)
+	}
+	if code, body = do(http.MethodDelete, "/schemas/orders", ""); code != http.StatusNotFound {
+		t.Fatal(code, body)
+	}
+	if topics, err := s.ListSchemas(ctx); err != nil || len(topics) != 0 {
+		t.Fatal(topics, err)
+	}
+
+	// invalid schema files are rejected
+	if err = ioutil.WriteFile(file, []byte(`{"orders":[{"type":"xml"}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewServer(WithFileQueue([]string{dir}, true, 5000), WithSchemaRegistry(file, true)); err == nil {
+		t.Fatal("expected invalid schema file error")
+	}
+
+	// the registry is disabled by default
+	s2, err := NewServer(WithQueue(s.q))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s2.GetSchema(ctx, "orders", 0); err == nil {
+		t.Fatal("expected disabled error")
+	}
+	w = httptest.NewRecorder()
+	s2.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/schemas", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatal(w.Code)
+	}
+}
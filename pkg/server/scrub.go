@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// WithScrubber periodically verifies the sealed files of every topic against their copies in the other
+// queue directories, logging any corruption. If repair is true corrupt copies are replaced with a good copy
+func WithScrubber(interval time.Duration, repair bool) Option {
+	return func(s *Server) error {
+		if interval <= 0 {
+			return errors.New("invalid scrub interval, value must be greater than 0")
+		}
+		s.scrubInterval, s.scrubRepair = interval, repair
+		return nil
+	}
+}
+
+func (s *Server) monitorScrub() {
+	ticker := time.NewTicker(s.scrubInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			_, _ = s.Scrub(context.Background(), s.scrubRepair)
+		}
+	}
+}
+
+// Scrub verifies the sealed files of every topic, returning the corrupt copies found. Topics are checked
+// one at a time, stopping early if the server is closed
+func (s *Server) Scrub(ctx context.Context, repair bool) ([]headers.Corruption, error) {
+	topics, err := s.ListTopics(ctx, "", "", "")
+	if err != nil {
+		return nil, err
+	}
+	var corruptions []headers.Corruption
+	for _, topic := range topics {
+		select {
+		case <-s.done:
+			return corruptions, nil
+		default:
+		}
+		found, err := s.q.Scrub(topic, repair)
+		for _, c := range found {
+			s.logger.Error("corrupt file", "topic", c.Topic, "file", c.File, "dir", c.Dir, "reason", c.Reason,
+				"repaired", c.Repaired)
+		}
+		corruptions = append(corruptions, found...)
+		if err != nil && errors.Cause(err) != headers.ErrTopicDoesNotExist {
+			s.logError("unable to scrub topic", err, "topic", topic)
+		}
+	}
+	return corruptions, nil
+}
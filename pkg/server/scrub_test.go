@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestWithScrubber(t *testing.T) {
+	s := &Server{}
+	if err := WithScrubber(0, true)(s); err == nil {
+		t.Error("expected invalid interval error")
+	}
+	if err := WithScrubber(time.Hour, true)(s); err != nil || s.scrubInterval != time.Hour || !s.scrubRepair {
+		t.Fatal(err, s.scrubInterval, s.scrubRepair)
+	}
+}
+
+func TestServer_Scrub(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	corrupt := headers.Corruption{Topic: "orders", File: "0000000000000000", Dir: "/vol1", Reason: "missing log file", Repaired: true}
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Times(1).Return("")
+	q.EXPECT().ListTopics(gomock.Any(), "", "", "").Return([]string{"orders", "deleted", "broken"}, nil).Times(1)
+	q.EXPECT().Scrub("orders", true).Return([]headers.Corruption{corrupt}, nil).Times(1)
+	q.EXPECT().Scrub("deleted", true).Return(nil, headers.ErrTopicDoesNotExist).Times(1)
+	q.EXPECT().Scrub("broken", true).Return(nil, errors.New("read error")).Times(1)
+	q.EXPECT().Close().Return(nil).Times(1)
+
+	s, err := NewServer(WithQueue(q))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// errors scrubbing one topic do not stop the others
+	corruptions, err := s.Scrub(context.Background(), true)
+	if err != nil || len(corruptions) != 1 || corruptions[0] != corrupt {
+		t.Fatal(corruptions, err)
+	}
+	if s.counters.errors != 1 {
+		t.Fatal(s.counters.errors)
+	}
+}
@@ -1,10 +1,15 @@
 package server
 
 import (
+	"context"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/haraqa/haraqa/internal/filequeue"
+	"github.com/haraqa/haraqa/pkg/tracing"
 	"github.com/pkg/errors"
 )
 
@@ -34,9 +39,12 @@ func WithFileQueue(dirs []string, cache bool, entries int64) Option {
 		if entries < 0 {
 			return errors.New("invalid entries, value must not be negative")
 		}
-		var err error
-		s.q, err = filequeue.New(cache, entries, dirs...)
-		return err
+		q, err := filequeue.New(cache, entries, dirs...)
+		if err != nil {
+			return err
+		}
+		s.q, s.ownsQueue = q, true
+		return nil
 	}
 }
 
@@ -75,37 +83,265 @@ type Server struct {
 	middlewares         []func(http.Handler) http.Handler
 	handler             http.Handler
 	metrics             Metrics
+	tracer              tracing.Tracer
+	logger              Logger
 	defaultConsumeLimit int64
+	adaptive            *adaptiveLimits
 	q                   Queue
+	ownsQueue           bool
 	isClosed            bool
+	diskInterval        time.Duration
+	diskHighWater       float64
+	degraded            int32
+	readOnlySince       int64
+	diskFullRetry       time.Duration
+	lagInterval         time.Duration
+	groupOffsets        groupOffsets
+	groups              consumerGroups
+	scaling             scaling
+	offsets             *offsetsLog
+	slowThreshold       time.Duration
+	accessLog           *accessLog
+	faults              *faultInjector
+	cacheInterval       time.Duration
+	preloadWindow       time.Duration
+	maxOpenFiles        int64
+	readAhead           int64
+	consumeGzip         *sync.Pool
+	syncWrites          bool
+	rollInterval        time.Duration
+	rollSize            int64
+	buffers             bufferPool
+	limits              map[string]*limiter
+	retryAfter          time.Duration
+	followHeartbeat     time.Duration
+	signals             topicSignals
+	hooks               []Hooks
+	webhooks            *webhooks
+	schemas             *schemaRegistry
+	acl                 *accessList
+	users               *userStore
+	oidc                *oidcVerifier
+	network             *networkACL
+	signing             *signatureVerifier
+	encryption          *keyStore
+	drainLimit          int64
+	deleteGrace         time.Duration
+	topicQuota          topicQuota
+	produceQuotas       *produceQuotas
+	scheduler           scheduler
+	truncations         truncations
+	messageIDs          bool
+	dedup               *dedup
+	autoCreateTopics    bool
+	replayTransforms    map[string]ReplayTransform
+	topicConfigs        topicConfigs
+	retention           time.Duration
+	retentionInterval   time.Duration
+	janitor             janitor
+	router              http.Handler
+	scrubInterval       time.Duration
+	scrubRepair         bool
+	inFlight            inFlight
+	counters            counters
+	topicCounters       topicCounters
+	started             time.Time
+	done                chan struct{}
+	wg                  sync.WaitGroup
 }
 
 // NewServer creates a new server with the given options
 func NewServer(options ...Option) (*Server, error) {
 	s := &Server{
 		metrics:             noOpMetrics{},
+		tracer:              tracing.NoopTracer{},
+		logger:              noOpLogger{},
 		defaultConsumeLimit: -1,
+		drainLimit:          4 << 20,
+		deleteGrace:         24 * time.Hour,
+		diskFullRetry:       30 * time.Second,
+		retentionInterval:   5 * time.Minute,
+		janitor:             janitor{interval: time.Minute, concurrency: 1},
+		followHeartbeat:     15 * time.Second,
+		retryAfter:          time.Second,
+		groups:              consumerGroups{timeout: 30 * time.Second, assignor: RoundRobinAssignor},
+		scaling:             scaling{targetLag: 1000, window: 5 * time.Minute},
+		started:             time.Now(),
+		done:                make(chan struct{}),
 	}
 	options = append(options, WithFileQueue([]string{".haraqa"}, true, 5000))
 
+	if err := s.configure(options); err != nil {
+		// release the directory locks of a queue created by the options
+		if s.ownsQueue {
+			_ = s.q.Close()
+		}
+		return nil, err
+	}
+
+	rawHandler := http.StripPrefix("/raw/", http.FileServer(http.Dir(s.q.RootDir())))
+	s.router = s.route(rawHandler)
+	s.handler = s.router
+	if s.topicQuota.limit > 0 {
+		s.handler = s.keyCreations(s.handler)
+	}
+
+	// faults are injected after authentication, so that only authorized requests fail on purpose
+	if s.faults != nil {
+		s.logger.Warn("fault injection is enabled, requests will fail on purpose", "faults", len(s.faults.faults))
+		s.handler = s.injectFaults(s.handler)
+	}
+
+	// authorize requests after the middlewares, tokens or user store have authenticated them
+	if s.authorizes() {
+		s.handler = s.authorize(s.handler)
+	}
+	if s.accessLog != nil {
+		s.handler = s.recordPrincipal(s.handler)
+	}
+	s.handler = s.authenticated(s.handler)
+
+	if len(s.limits) > 0 {
+		s.handler = s.shedLoad(s.handler)
+	}
+
+	if s.slowThreshold > 0 {
+		s.handler = s.logSlowRequests(s.handler)
+	}
+
+	// reject forbidden clients before any other work is done for them
+	if s.network != nil {
+		s.handler = s.filterNetwork(s.handler)
+	}
+
+	// trace requests before any other middleware
+	if _, ok := s.tracer.(tracing.NoopTracer); !ok {
+		s.handler = s.traceRequests(s.handler)
+	}
+
+	// a panic in a handler or middleware should fail the request, not the server
+	s.handler = s.recoverPanics(s.handler)
+
+	// log every request, including those rejected by any middleware
+	if s.accessLog != nil {
+		s.handler = s.logAccess(s.handler)
+	}
+
+	// drain request bodies after all other middleware has returned
+	if s.drainLimit > 0 {
+		s.handler = s.drainBodies(s.handler)
+	}
+
+	// start background monitors
+	if s.diskInterval > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.monitorDisk()
+		}()
+	}
+	if s.lagInterval > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.monitorLag()
+		}()
+	}
+	if s.preloadWindow > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.preload()
+		}()
+	}
+	if s.cacheInterval > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.monitorCache()
+		}()
+	}
+	if s.needsJanitor() {
+		s.startJanitor()
+	}
+	if s.scheduler.enabled {
+		s.startScheduler()
+	}
+	s.scheduleTruncations()
+	if s.scrubInterval > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.monitorScrub()
+		}()
+	}
+	if s.webhooks != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.sendWebhooks()
+		}()
+	}
+
+	return s, nil
+}
+
+// configure applies the options and the queue settings they set, then restores the offsets of the
+// consumer groups
+func (s *Server) configure(options []Option) error {
 	for _, option := range options {
 		if err := option(s); err != nil {
-			return nil, errors.Wrap(err, "invalid option")
+			return errors.Wrap(err, "invalid option")
+		}
+	}
+	for _, setting := range []struct {
+		enabled bool
+		apply   func() error
+	}{
+		{s.maxOpenFiles > 0, s.setMaxOpenFiles},
+		{s.readAhead > 0, s.setReadAhead},
+		{s.buffers.max > 0, s.setBufferPool},
+		{s.syncWrites, s.setSyncWrites},
+		{s.rollInterval > 0, s.setRollInterval},
+		{s.rollSize > 0, s.setRollSize},
+	} {
+		if !setting.enabled {
+			continue
 		}
+		if err := setting.apply(); err != nil {
+			return errors.Wrap(err, "invalid option")
+		}
+	}
+	if s.offsets != nil {
+		return s.restoreOffsets(WithTrusted(context.Background()))
 	}
+	return nil
+}
 
-	rawHandler := http.StripPrefix("/raw/", http.FileServer(http.Dir(s.q.RootDir())))
-	s.handler = s.route(rawHandler)
+// authenticated wraps the handler with the user store, the token and signature verification and the
+// middlewares, which are applied in the order they were given
+func (s *Server) authenticated(h http.Handler) http.Handler {
+	if s.users != nil {
+		h = s.authenticate(h)
+	}
+	if s.oidc != nil {
+		h = s.verifyTokens(h)
+	}
+	if s.signing != nil {
+		h = s.verifySignatures(h)
+	}
 
 	// iterate over middlewares in reverse order
 	for j := len(s.middlewares) - 1; j >= 0; j-- {
-		s.handler = s.middlewares[j](s.handler)
+		h = s.middlewares[j](h)
 	}
-
-	return s, nil
+	return h
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	counter := s.inFlight.counter(r)
+	atomic.AddInt64(counter, 1)
+	defer atomic.AddInt64(counter, -1)
 	s.handler.ServeHTTP(w, r)
 }
 
@@ -117,9 +353,19 @@ func (s *Server) route(raw http.Handler) http.HandlerFunc {
 				s.HandleGetAllTopics(w, r)
 				return
 			}
+			if isWatch(r) {
+				s.HandleWatch(w, r)
+				return
+			}
+			if isCount(r) {
+				s.HandleCount(w, r)
+				return
+			}
 			switch r.Method {
 			case http.MethodGet:
 				s.HandleConsume(w, r)
+			case http.MethodHead:
+				s.HandleInspectTopic(w, r)
 			case http.MethodPost:
 				s.HandleProduce(w, r)
 			case http.MethodOptions:
@@ -133,6 +379,24 @@ func (s *Server) route(raw http.Handler) http.HandlerFunc {
 			}
 		case strings.HasPrefix(r.URL.Path, "/raw"):
 			raw.ServeHTTP(w, r)
+		case strings.HasPrefix(r.URL.Path, "/groups/"):
+			s.HandleGroups(w, r)
+		case strings.HasPrefix(r.URL.Path, "/scaling/"):
+			s.HandleScaling(w, r)
+		case r.URL.Path == "/stats.json":
+			s.HandleStats(w, r)
+		case strings.HasPrefix(r.URL.Path, "/schemas") && s.schemas != nil:
+			s.HandleSchemas(w, r)
+		case (r.URL.Path == "/acl" || strings.HasPrefix(r.URL.Path, "/acl/")) && s.acl != nil:
+			s.HandleACL(w, r)
+		case (r.URL.Path == "/users" || strings.HasPrefix(r.URL.Path, "/users/")) && s.users != nil:
+			s.HandleUsers(w, r)
+		case (r.URL.Path == "/keys" || strings.HasPrefix(r.URL.Path, "/keys/")) && s.encryption != nil:
+			s.HandleKeys(w, r)
+		case (r.URL.Path == "/quotas" || strings.HasPrefix(r.URL.Path, "/quotas/")) && s.produceQuotas != nil:
+			s.HandleQuotas(w, r)
+		case (r.URL.Path == "/schedules" || strings.HasPrefix(r.URL.Path, "/schedules/")) && s.scheduler.enabled:
+			s.HandleSchedules(w, r)
 		default:
 			w.WriteHeader(http.StatusNotFound)
 			_, _ = w.Write([]byte("page not found"))
@@ -140,8 +404,36 @@ func (s *Server) route(raw http.Handler) http.HandlerFunc {
 	}
 }
 
+// statusWriter records the status code and number of bytes written to the underlying response writer
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 // Close closes the server and returns any associated errors
 func (s *Server) Close() error {
+	if s.done != nil && !s.isClosed {
+		close(s.done)
+	}
 	s.isClosed = true
+	s.wg.Wait()
 	return s.q.Close()
 }
@@ -5,9 +5,12 @@ import (
 	"net/http/httptest"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
 	"github.com/pkg/errors"
 )
 
@@ -91,6 +94,46 @@ func TestServer_route(t *testing.T) {
 	}
 }
 
+func TestServer_Mount(t *testing.T) {
+	dir := ".haraqa-mount"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// the server is mounted under a prefix of a standard library mux
+	mux := http.NewServeMux()
+	mux.Handle("/queue/", http.StripPrefix("/queue", s))
+	mux.HandleFunc("/stats", s.HandleStats)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			r.Header.Set(headers.HeaderSizes, strconv.Itoa(len(body)))
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do(http.MethodPut, "/queue/topics/mounted", ""); w.Code != http.StatusCreated {
+		t.Fatal(w.Code)
+	}
+	if w := do(http.MethodPost, "/queue/topics/mounted", "hello"); w.Code != http.StatusNoContent {
+		t.Fatal(w.Code)
+	}
+	if w := do(http.MethodGet, "/queue/topics/mounted?id=0", ""); w.Code != http.StatusPartialContent || w.Body.String() != "hello" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if w := do(http.MethodGet, "/stats", ""); w.Code != http.StatusOK {
+		t.Fatal(w.Code)
+	}
+	if w := do(http.MethodGet, "/topics/mounted?id=0", ""); w.Code != http.StatusNotFound {
+		t.Fatal(w.Code)
+	}
+}
+
 /*
 	// verify routes
 	{
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// Operation types limited by WithConcurrencyLimit
+const (
+	OpProduce = "produce"
+	OpConsume = "consume"
+	OpOther   = "other"
+)
+
+// WithConcurrencyLimit limits the number of requests of the operation type, one of produce, consume or
+// other, handled at once. Up to queue requests wait for one of the running requests to finish, further
+// requests are rejected with a 429 and a Retry-After header rather than slowing every request down.
+// Follow consumes and watches stay open and are not limited
+func WithConcurrencyLimit(op string, limit, queue int) Option {
+	return func(s *Server) error {
+		if op != OpProduce && op != OpConsume && op != OpOther {
+			return errors.Errorf("invalid operation %q, must be one of produce, consume or other", op)
+		}
+		if limit <= 0 {
+			return errors.New("invalid concurrency limit, value must be greater than 0")
+		}
+		if queue < 0 {
+			return errors.New("invalid queue depth, value cannot be negative")
+		}
+		if s.limits == nil {
+			s.limits = make(map[string]*limiter)
+		}
+		s.limits[op] = &limiter{slots: make(chan struct{}, limit), queue: int64(queue)}
+		return nil
+	}
+}
+
+// WithRetryAfter sets the duration clients rejected by a concurrency limit are told to wait before retrying,
+// the default is 1s
+func WithRetryAfter(d time.Duration) Option {
+	return func(s *Server) error {
+		if d < time.Second {
+			return errors.New("invalid retry after, value must be at least 1s")
+		}
+		s.retryAfter = d
+		return nil
+	}
+}
+
+// limiter bounds the requests of an operation type which run or wait at once
+type limiter struct {
+	slots   chan struct{}
+	queue   int64
+	waiting int64
+}
+
+// acquire waits for a slot, returning false if the queue is full or the request is abandoned
+func (l *limiter) acquire(ctx context.Context) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if atomic.AddInt64(&l.waiting, 1) > l.queue {
+		atomic.AddInt64(&l.waiting, -1)
+		return false
+	}
+	defer atomic.AddInt64(&l.waiting, -1)
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release frees the slot of a finished request
+func (l *limiter) release() {
+	<-l.slots
+}
+
+// shedLoad wraps the handler, rejecting requests beyond the concurrency limit and queue of their operation
+func (s *Server) shedLoad(next http.Handler) http.Handler {
+	retryAfter := []string{strconv.Itoa(int(s.retryAfter / time.Second))}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op := operation(r)
+		l := s.limits[op]
+		if l == nil || r.URL.Query().Get("follow") == "true" || isWatch(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !l.acquire(r.Context()) {
+			if r.Context().Err() != nil {
+				return
+			}
+			s.metrics.ShedRequest(op)
+			w.Header()["Retry-After"] = retryAfter
+			headers.SetError(w, headers.ErrOverloaded)
+			return
+		}
+		defer l.release()
+		next.ServeHTTP(w, r)
+	})
+}
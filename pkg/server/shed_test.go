@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+type shedMetrics struct {
+	noOpMetrics
+	mux  sync.Mutex
+	shed []string
+}
+
+func (m *shedMetrics) ShedRequest(op string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.shed = append(m.shed, op)
+}
+
+func TestWithConcurrencyLimit(t *testing.T) {
+	for _, opt := range []Option{
+		WithConcurrencyLimit("delete", 1, 0),
+		WithConcurrencyLimit(OpProduce, 0, 0),
+		WithConcurrencyLimit(OpProduce, 1, -1),
+		WithRetryAfter(time.Millisecond),
+	} {
+		if err := opt(&Server{}); err == nil {
+			t.Error("expected invalid option error")
+		}
+	}
+	s := &Server{}
+	if err := WithConcurrencyLimit(OpConsume, 2, 3)(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := WithRetryAfter(time.Minute)(s); err != nil {
+		t.Fatal(err)
+	}
+	if l := s.limits[OpConsume]; l == nil || cap(l.slots) != 2 || l.queue != 3 || s.retryAfter != time.Minute {
+		t.Fatal(s.limits, s.retryAfter)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := &limiter{slots: make(chan struct{}, 1), queue: 1}
+	if !l.acquire(context.Background()) {
+		t.Fatal("expected a free slot")
+	}
+
+	// the second request waits in the queue, the third is rejected
+	acquired := make(chan bool)
+	go func() {
+		acquired <- l.acquire(context.Background())
+	}()
+	for start := time.Now(); atomic.LoadInt64(&l.waiting) != 1; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("timed out")
+		}
+	}
+	if l.acquire(context.Background()) {
+		t.Fatal("expected a full queue")
+	}
+	l.release()
+	if !<-acquired {
+		t.Fatal("expected the queued request to run")
+	}
+
+	// abandoned requests leave the queue
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if l.acquire(ctx) || atomic.LoadInt64(&l.waiting) != 0 {
+		t.Fatal("expected the request to be abandoned")
+	}
+}
+
+func TestServer_shedLoad(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Times(1).Return("")
+	q.EXPECT().Consume(gomock.Any(), "shed", int64(0), int64(-1), gomock.Any()).Return(1, nil).Times(1)
+	q.EXPECT().Close().Return(nil).Times(1)
+
+	metrics := &shedMetrics{}
+	s, err := NewServer(WithQueue(q), WithMetrics(metrics), WithConcurrencyLimit(OpConsume, 1, 0), WithRetryAfter(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// occupy the only consume slot
+	l := s.limits[OpConsume]
+	if !l.acquire(context.Background()) {
+		t.Fatal("expected a free slot")
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/topics/shed?id=0", nil))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" || headers.ReadErrors(w.Header()) != headers.ErrOverloaded {
+		t.Fatal(w.Code, w.Header())
+	}
+	if len(metrics.shed) != 1 || metrics.shed[0] != OpConsume {
+		t.Fatal(metrics.shed)
+	}
+
+	// other operations are not limited
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodOptions, "/topics/shed", nil))
+	if w.Code == http.StatusTooManyRequests {
+		t.Fatal(w.Code)
+	}
+
+	l.release()
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/topics/shed?id=0", nil))
+	if w.Code == http.StatusTooManyRequests || len(l.slots) != 0 {
+		t.Fatal(w.Code, len(l.slots))
+	}
+}
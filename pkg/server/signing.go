@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// maxSignedBody is the largest body of a signed request, signed bodies are read into memory to be verified
+// before the request is handled
+const maxSignedBody = 16 << 20
+
+// WithRequestSigning enables authentication of requests signed with HMAC-SHA256 by a shared key, for clients
+// such as edge devices which cannot use TLS client certificates or tokens. The keys map key ids to their
+// secrets, requests are made as the id of the key which signed them. Signed requests send the key id, the
+// unix timestamp, a unique nonce and the hex encoded sha256 hash of the body in the X-Signature-Key,
+// X-Signature-Time, X-Signature-Nonce and X-Content-Sha256 headers, and the signature from
+// headers.SignRequest in the X-Signature header. Requests signed more than skew away from the server's
+// time, or repeating a signature, are rejected. The default skew is 5m
+func WithRequestSigning(keys map[string]string, skew time.Duration) Option {
+	return func(s *Server) error {
+		if len(keys) == 0 {
+			return errors.New("at least one signing key must be given")
+		}
+		if skew < 0 {
+			return errors.New("invalid signature skew, value cannot be negative")
+		}
+		if skew == 0 {
+			skew = 5 * time.Minute
+		}
+		v := &signatureVerifier{keys: make(map[string][]byte, len(keys)), skew: skew, seen: make(map[string]time.Time)}
+		for id, secret := range keys {
+			if id == "" || secret == "" {
+				return errors.New("invalid signing key, id and secret cannot be empty")
+			}
+			v.keys[id] = []byte(secret)
+		}
+		s.signing = v
+		return nil
+	}
+}
+
+// signatureVerifier verifies signed requests, remembering the signatures of recent requests until they
+// expire so that they cannot be replayed
+type signatureVerifier struct {
+	keys   map[string][]byte
+	skew   time.Duration
+	mux    sync.Mutex
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+// verify checks the signature of the request and returns the id of the key which signed it and its body
+func (v *signatureVerifier) verify(r *http.Request, now time.Time) (string, []byte, error) {
+	id := r.Header.Get(headers.HeaderSignatureKey)
+	secret, ok := v.keys[id]
+	if !ok {
+		return "", nil, errors.Errorf("unknown signing key %q", id)
+	}
+	timestamp, err := strconv.ParseInt(r.Header.Get(headers.HeaderSignatureTime), 10, 64)
+	if err != nil {
+		return "", nil, errors.New("invalid signature time")
+	}
+	signed := time.Unix(timestamp, 0)
+	if signed.Before(now.Add(-v.skew)) || signed.After(now.Add(v.skew)) {
+		return "", nil, errors.New("signature time is outside the allowed skew")
+	}
+
+	// the signature covers the hash sent in the header, so the body is only read for valid signatures
+	hash := r.Header.Get(headers.HeaderContentSHA256)
+	nonce := r.Header.Get(headers.HeaderSignatureNonce)
+	if nonce == "" {
+		return "", nil, errors.New("missing signature nonce")
+	}
+	signature := r.Header.Get(headers.HeaderSignature)
+	if !hmac.Equal([]byte(headers.SignRequest(secret, r.Method, r.URL.RequestURI(), timestamp, nonce, hash)), []byte(signature)) {
+		return "", nil, errors.New("invalid signature")
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+		if err != nil {
+			return "", nil, errors.Wrap(err, "unable to read signed body")
+		}
+		if len(body) > maxSignedBody {
+			return "", nil, errors.New("signed body is too large")
+		}
+	}
+	if !strings.EqualFold(headers.ContentSHA256(body), hash) {
+		return "", nil, errors.New("body does not match its hash")
+	}
+	if v.replayed(signature, signed.Add(v.skew), now) {
+		return "", nil, errors.New("replayed signature")
+	}
+	return id, body, nil
+}
+
+// replayed returns true if the signature has been seen before it expires, and otherwise remembers it
+func (v *signatureVerifier) replayed(signature string, expires, now time.Time) bool {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	if now.Sub(v.pruned) > v.skew {
+		for sig, exp := range v.seen {
+			if now.After(exp) {
+				delete(v.seen, sig)
+			}
+		}
+		v.pruned = now
+	}
+	if _, ok := v.seen[signature]; ok {
+		return true
+	}
+	v.seen[signature] = expires
+	return false
+}
+
+// verifySignatures sets the principal of signed requests to the id of the key which signed them. Requests
+// with an invalid signature are rejected with ErrUnauthorized, as are requests without one unless a
+// middleware has already authenticated them or they can authenticate with a token or the user store.
+// Preflight requests are not authenticated
+func (s *Server) verifySignatures(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Header.Get(headers.HeaderSignature) == "" {
+			if s.users != nil || s.oidc != nil || Principal(r.Context()) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Signature realm="haraqa"`)
+			headers.SetError(w, headers.ErrUnauthorized)
+			return
+		}
+		id, body, err := s.signing.verify(r, time.Now())
+		if err != nil {
+			s.logger.Warn("invalid request signature", "key", r.Header.Get(headers.HeaderSignatureKey), "error", err.Error())
+			w.Header().Set("WWW-Authenticate", `Signature realm="haraqa"`)
+			headers.SetError(w, headers.ErrUnauthorized)
+			return
+		}
+		r = r.WithContext(WithPrincipal(r.Context(), id))
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
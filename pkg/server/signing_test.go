@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func signedRequest(method, target, key, secret, nonce string, timestamp int64, body []byte) *http.Request {
+	r := httptest.NewRequest(method, target, bytes.NewReader(body))
+	hash := headers.ContentSHA256(body)
+	r.Header.Set(headers.HeaderSignatureKey, key)
+	r.Header.Set(headers.HeaderSignatureTime, strconv.FormatInt(timestamp, 10))
+	r.Header.Set(headers.HeaderSignatureNonce, nonce)
+	r.Header.Set(headers.HeaderContentSHA256, hash)
+	r.Header.Set(headers.HeaderSignature, headers.SignRequest([]byte(secret), method, r.URL.RequestURI(), timestamp, nonce, hash))
+	return r
+}
+
+func TestWithRequestSigning(t *testing.T) {
+	s := &Server{}
+	if err := WithRequestSigning(nil, 0)(s); err == nil {
+		t.Fatal("expected missing keys error")
+	}
+	if err := WithRequestSigning(map[string]string{"a": "secret"}, -time.Second)(s); err == nil {
+		t.Fatal("expected invalid skew error")
+	}
+	if err := WithRequestSigning(map[string]string{"a": ""}, 0)(s); err == nil {
+		t.Fatal("expected invalid key error")
+	}
+	if err := WithRequestSigning(map[string]string{"a": "secret"}, 0)(s); err != nil || s.signing.skew != 5*time.Minute {
+		t.Fatal(s.signing, err)
+	}
+}
+
+func TestSignatureVerifier(t *testing.T) {
+	s := &Server{}
+	if err := WithRequestSigning(map[string]string{"device": "secret"}, time.Minute)(s); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	body := []byte("message")
+
+	id, b, err := s.signing.verify(signedRequest(http.MethodPost, "/topics/readings?x=1", "device", "secret", "1", now.Unix(), body), now)
+	if err != nil || id != "device" || !bytes.Equal(b, body) {
+		t.Fatal(id, b, err)
+	}
+	if _, _, err = s.signing.verify(signedRequest(http.MethodPost, "/topics/readings?x=1", "device", "secret", "1", now.Unix(), body), now); err == nil {
+		t.Fatal("expected replayed signature")
+	}
+
+	invalid := map[string]*http.Request{
+		"unknown key":  signedRequest(http.MethodPost, "/topics/readings", "other", "secret", "2", now.Unix(), body),
+		"wrong secret": signedRequest(http.MethodPost, "/topics/readings", "device", "wrong", "2", now.Unix(), body),
+		"too old":      signedRequest(http.MethodPost, "/topics/readings", "device", "secret", "2", now.Add(-2*time.Minute).Unix(), body),
+		"too new":      signedRequest(http.MethodPost, "/topics/readings", "device", "secret", "2", now.Add(2*time.Minute).Unix(), body),
+		"no nonce":     signedRequest(http.MethodPost, "/topics/readings", "device", "secret", "", now.Unix(), body),
+	}
+	tampered := signedRequest(http.MethodPost, "/topics/readings", "device", "secret", "3", now.Unix(), body)
+	tampered.Body = ioutil.NopCloser(bytes.NewReader([]byte("changed")))
+	invalid["tampered body"] = tampered
+	moved := signedRequest(http.MethodPost, "/topics/readings", "device", "secret", "4", now.Unix(), body)
+	moved.URL.Path = "/topics/other"
+	invalid["tampered path"] = moved
+	badTime := signedRequest(http.MethodPost, "/topics/readings", "device", "secret", "5", now.Unix(), body)
+	badTime.Header.Set(headers.HeaderSignatureTime, "soon")
+	invalid["invalid time"] = badTime
+	for name, r := range invalid {
+		if _, _, err = s.signing.verify(r, now); err == nil {
+			t.Fatal("expected error for", name)
+		}
+	}
+
+	// expired signatures are forgotten
+	s.signing.replayed("old", now.Add(-time.Second), now.Add(-2*time.Minute))
+	s.signing.pruned = now.Add(-2 * time.Minute)
+	s.signing.replayed("new", now.Add(time.Minute), now)
+	if _, ok := s.signing.seen["old"]; ok || len(s.signing.seen) != 2 {
+		t.Fatal(s.signing.seen)
+	}
+}
+
+func TestServer_RequestSigning(t *testing.T) {
+	dir := ".haraqa-signing"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithRequestSigning(map[string]string{"device": "secret"}, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	now := time.Now().Unix()
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/topics", nil))
+	if w.Code != http.StatusUnauthorized || headers.ReadErrors(w.Header()) != headers.ErrUnauthorized {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, signedRequest(http.MethodPut, "/topics/readings", "device", "secret", "1", now, nil))
+	if w.Code != http.StatusCreated {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	r := signedRequest(http.MethodPost, "/topics/readings", "device", "secret", "2", now, []byte("hello"))
+	r.Header.Set(headers.HeaderSizes, "5")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, signedRequest(http.MethodGet, "/topics/readings?id=0", "device", "wrong", "3", now, nil))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatal(w.Code, w.Header())
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, signedRequest(http.MethodGet, "/topics/readings?id=0", "device", "secret", "3", now, nil))
+	if w.Code != http.StatusPartialContent || w.Body.String() != "hello" {
+		t.Fatal(w.Code, w.Header(), w.Body.String())
+	}
+
+	// handlers served beside the server, such as the grpc api, only accept signed requests
+	listener := s.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if Principal(r.Context()) != "device" {
+			t.Error(Principal(r.Context()))
+		}
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	w = httptest.NewRecorder()
+	listener.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/haraqa.v1.Haraqa/Produce", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatal(w.Code)
+	}
+	w = httptest.NewRecorder()
+	listener.ServeHTTP(w, signedRequest(http.MethodPost, "/haraqa.v1.Haraqa/Produce", "device", "secret", "4", now, []byte("call")))
+	if w.Code != http.StatusTeapot {
+		t.Fatal(w.Code)
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// WithSlowRequestThreshold logs and counts requests which take longer than the threshold to complete,
+// along with the topic, request/response sizes and offsets of the request
+func WithSlowRequestThreshold(threshold time.Duration) Option {
+	return func(s *Server) error {
+		if threshold <= 0 {
+			return errors.New("invalid threshold, value must be greater than 0")
+		}
+		s.slowThreshold = threshold
+		return nil
+	}
+}
+
+// logSlowRequests wraps the handler, reporting any requests exceeding the slow request threshold. Follow
+// consumes and watches are expected to stay open and are not reported
+func (s *Server) logSlowRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("follow") == "true" || isWatch(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		duration := time.Since(start)
+		if duration < s.slowThreshold {
+			return
+		}
+
+		s.metrics.SlowRequest(r.Method)
+		topic, _ := getTopic(r)
+		query := r.URL.Query()
+		s.logger.Warn("slow request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"topic", topic,
+			"duration", duration,
+			"status", sw.status,
+			"id", query.Get("id"),
+			"limit", query.Get("limit"),
+			"count", len(r.Header[headers.HeaderSizes])+len(w.Header()[headers.HeaderSizes]),
+			"requestSize", r.ContentLength,
+			"responseSize", sw.size,
+		)
+	})
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+)
+
+type slowMetrics struct {
+	noOpMetrics
+	slow []string
+}
+
+func (m *slowMetrics) SlowRequest(method string) {
+	m.slow = append(m.slow, method)
+}
+
+func TestWithSlowRequestThreshold(t *testing.T) {
+	if err := WithSlowRequestThreshold(0)(&Server{}); err == nil {
+		t.Error("expected threshold error")
+	}
+	s := &Server{}
+	if err := WithSlowRequestThreshold(time.Second)(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.slowThreshold != time.Second {
+		t.Error(s.slowThreshold)
+	}
+}
+
+func TestServer_logSlowRequests(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Times(1).Return("")
+	q.EXPECT().Consume(gomock.Any(), "slow", int64(10), int64(5), gomock.Any()).DoAndReturn(
+		func(_ context.Context, topic string, id, limit int64, w http.ResponseWriter) (int, error) {
+			time.Sleep(10 * time.Millisecond)
+			_, _ = w.Write([]byte("slow"))
+			return 1, nil
+		}).Times(1)
+	q.EXPECT().Consume(gomock.Any(), "fast", int64(10), int64(5), gomock.Any()).Return(1, nil).Times(1)
+	q.EXPECT().Close().Return(nil).Times(1)
+
+	logger := &testLogger{}
+	metrics := &slowMetrics{}
+	s, err := NewServer(WithQueue(q), WithLogger(logger), WithMetrics(metrics), WithSlowRequestThreshold(5*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, topic := range []string{"slow", "fast"} {
+		r, err := http.NewRequest(http.MethodGet, "/topics/"+topic+"?id=10&limit=5", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	if len(metrics.slow) != 1 || metrics.slow[0] != http.MethodGet {
+		t.Fatal(metrics.slow)
+	}
+	if len(logger.entries) != 1 {
+		t.Fatal(logger.entries)
+	}
+	for _, field := range []string{"warn: slow request", "topic slow", "id 10", "limit 5", "responseSize 4"} {
+		if !strings.Contains(logger.entries[0], field) {
+			t.Error(logger.entries[0], field)
+		}
+	}
+}
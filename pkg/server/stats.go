@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+// counters are the cumulative totals reported by Stats, all fields are updated atomically
+type counters struct {
+	producedMsgs  int64
+	producedBytes int64
+	consumedMsgs  int64
+	consumedBytes int64
+	errors        int64
+}
+
+// topicCounters holds the cumulative bytes produced to and consumed from each topic
+type topicCounters struct {
+	mux    sync.RWMutex
+	topics map[string]*TopicBytes
+}
+
+// add adds the produced and consumed bytes to the topic's totals
+func (c *topicCounters) add(topic string, produced, consumed int64) {
+	c.mux.RLock()
+	t, ok := c.topics[topic]
+	c.mux.RUnlock()
+	if !ok {
+		c.mux.Lock()
+		if c.topics == nil {
+			c.topics = make(map[string]*TopicBytes)
+		}
+		if t, ok = c.topics[topic]; !ok {
+			t = &TopicBytes{}
+			c.topics[topic] = t
+		}
+		c.mux.Unlock()
+	}
+	atomic.AddInt64(&t.Produced, produced)
+	atomic.AddInt64(&t.Consumed, consumed)
+}
+
+// snapshot returns the totals of every topic
+func (c *topicCounters) snapshot() map[string]TopicBytes {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	topics := make(map[string]TopicBytes, len(c.topics))
+	for topic, t := range c.topics {
+		topics[topic] = TopicBytes{Produced: atomic.LoadInt64(&t.Produced), Consumed: atomic.LoadInt64(&t.Consumed)}
+	}
+	return topics
+}
+
+// deleteTopic forgets the totals of a deleted topic
+func (c *topicCounters) deleteTopic(topic string) {
+	c.mux.Lock()
+	delete(c.topics, topic)
+	c.mux.Unlock()
+}
+
+// Stats is a snapshot of the server's core counters, intended for monitoring scripts which do not
+// parse the prometheus format
+type Stats struct {
+	UptimeSeconds int64                 `json:"uptimeSeconds"`
+	Topics        int                   `json:"topics"`
+	ProducedMsgs  int64                 `json:"producedMsgs"`
+	ProducedBytes int64                 `json:"producedBytes"`
+	ConsumedMsgs  int64                 `json:"consumedMsgs"`
+	ConsumedBytes int64                 `json:"consumedBytes"`
+	Errors        int64                 `json:"errors"`
+	InFlight      map[string]int64      `json:"inFlight"`
+	TopicBytes    map[string]TopicBytes `json:"topicBytes"`
+	Truncations   []Truncation          `json:"truncations"`
+}
+
+// TopicBytes are the bytes of the messages produced to and consumed from a topic since the server started,
+// or since the topic was created if it was created later
+type TopicBytes struct {
+	Produced int64 `json:"produced"`
+	Consumed int64 `json:"consumed"`
+}
+
+// Stats returns the number of topics, the messages and bytes produced and consumed, the bytes produced and
+// consumed of each topic and the number of queue operations which failed for reasons other than an invalid
+// request since the server started, along with the most recent truncations of topics by their rules
+func (s *Server) Stats(ctx context.Context) (*Stats, error) {
+	topics, err := s.ListTopics(ctx, "", "", "")
+	if err != nil {
+		return nil, err
+	}
+	return &Stats{
+		UptimeSeconds: int64(time.Since(s.started) / time.Second),
+		Topics:        len(topics),
+		ProducedMsgs:  atomic.LoadInt64(&s.counters.producedMsgs),
+		ProducedBytes: atomic.LoadInt64(&s.counters.producedBytes),
+		ConsumedMsgs:  atomic.LoadInt64(&s.counters.consumedMsgs),
+		ConsumedBytes: atomic.LoadInt64(&s.counters.consumedBytes),
+		Errors:        atomic.LoadInt64(&s.counters.errors),
+		InFlight: map[string]int64{
+			"produce": atomic.LoadInt64(&s.inFlight.produce),
+			"consume": atomic.LoadInt64(&s.inFlight.consume),
+			"other":   atomic.LoadInt64(&s.inFlight.other),
+		},
+		TopicBytes:  s.topicCounters.snapshot(),
+		Truncations: s.truncations.list(),
+	}, nil
+}
+
+// HandleStats handles requests to the /stats.json endpoint, returning the server's Stats as json
+func (s *Server) HandleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	stats, err := s.Stats(r.Context())
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+	w.Header()[headers.ContentType] = []string{"application/json"}
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(stats)
+}
+
+// StatsVar returns the server's Stats as a variable which can be published with expvar.Publish
+func (s *Server) StatsVar() StatsVar {
+	return StatsVar{s: s}
+}
+
+// StatsVar implements expvar.Var, the package is not imported here as it registers /debug/vars on the
+// default http mux
+type StatsVar struct {
+	s *Server
+}
+
+// String returns the stats as json
+func (v StatsVar) String() string {
+	stats, err := v.s.Stats(context.Background())
+	if err != nil {
+		b, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return string(b)
+	}
+	b, _ := json.Marshal(stats)
+	return string(b)
+}
+
+// countProduced adds a produced batch to the counters and the bytes metrics
+func (s *Server) countProduced(topic string, sizes []int64) {
+	var n int64
+	for _, size := range sizes {
+		n += size
+	}
+	atomic.AddInt64(&s.counters.producedMsgs, int64(len(sizes)))
+	atomic.AddInt64(&s.counters.producedBytes, n)
+	s.topicCounters.add(topic, n, 0)
+	s.metrics.ProduceBytes(n)
+	s.metrics.TopicProduceBytes(topic, n)
+}
+
+// countConsumed adds a consumed batch to the counters and the bytes metrics, using the sizes set in the
+// response header
+func (s *Server) countConsumed(topic string, count int, h http.Header) int64 {
+	var n int64
+	if sizes, err := headers.ReadSizes(h); err == nil {
+		for _, size := range sizes {
+			n += size
+		}
+	}
+	atomic.AddInt64(&s.counters.consumedMsgs, int64(count))
+	atomic.AddInt64(&s.counters.consumedBytes, n)
+	s.topicCounters.add(topic, 0, n)
+	s.metrics.ConsumeBytes(n)
+	s.metrics.TopicConsumeBytes(topic, n)
+	return n
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestServer_HandleStats(t *testing.T) {
+	dir := ".haraqa-stats"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	for _, topic := range []string{"a", "b"} {
+		if err = s.CreateTopic(ctx, topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.ProduceMsgs(ctx, "a", []byte("hello"), []byte("world!")); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/topics/a?id=1", nil))
+	if w.Code != http.StatusOK && w.Code != http.StatusPartialContent {
+		t.Fatal(w.Code)
+	}
+	// client errors are not counted
+	if err = s.ProduceMsgs(ctx, "missing", []byte("x")); err == nil {
+		t.Fatal("expected missing topic error")
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats.json", nil))
+	if w.Code != http.StatusOK || w.Header().Get(headers.ContentType) != "application/json" {
+		t.Fatal(w.Code, w.Header())
+	}
+	var stats Stats
+	if err = json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Topics != 2 || stats.ProducedMsgs != 2 || stats.ProducedBytes != 11 || stats.ConsumedMsgs != 1 ||
+		stats.ConsumedBytes != 6 || stats.Errors != 0 || stats.InFlight["other"] != 1 || stats.UptimeSeconds < 0 {
+		t.Fatalf("%+v", stats)
+	}
+	if len(stats.TopicBytes) != 1 || stats.TopicBytes["a"] != (TopicBytes{Produced: 11, Consumed: 6}) {
+		t.Fatalf("%+v", stats.TopicBytes)
+	}
+
+	if v := s.StatsVar().String(); !strings.HasPrefix(v, `{"uptimeSeconds":`) || !strings.Contains(v, `"producedMsgs":2,`) {
+		t.Fatal(v)
+	}
+
+	// deleted topics are forgotten
+	if err = s.DeleteTopic(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err := s.Stats(ctx); err != nil || len(stats.TopicBytes) != 0 {
+		t.Fatal(stats, err)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/stats.json", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatal(w.Code)
+	}
+}
+
+func TestServer_StatsErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	errMock := errors.New("disk failure")
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Return("").Times(1)
+	q.EXPECT().CreateTopic("a").Return(errMock).Times(1)
+	q.EXPECT().ListTopics(gomock.Any(), "", "", "").Return(nil, errMock).Times(2)
+	q.EXPECT().ListTopics(gomock.Any(), "", "", "").Return([]string{"a"}, nil).Times(1)
+
+	s, err := NewServer(WithQueue(q))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.CreateTopic(context.Background(), "a"); err != errMock {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.HandleStats(w, httptest.NewRequest(http.MethodGet, "/stats.json", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatal(w.Code)
+	}
+	if v := s.StatsVar().String(); v != `{"error":"disk failure"}` {
+		t.Fatal(v)
+	}
+	stats, err := s.Stats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the failed create and the two failed listings
+	if stats.Errors != 3 || stats.Topics != 1 {
+		t.Fatalf("%+v", stats)
+	}
+}
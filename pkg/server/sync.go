@@ -0,0 +1,25 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+)
+
+// WithSyncWrites syncs produced messages to disk before responding to the producer, so that acknowledged
+// messages survive a crash of the host. Concurrent produces to a topic are written and synced together
+// as a group commit, keeping the throughput of many producers close to that of unsynced writes
+func WithSyncWrites(enabled bool) Option {
+	return func(s *Server) error {
+		s.syncWrites = enabled
+		return nil
+	}
+}
+
+// setSyncWrites enables synced writes on the queue, if it supports syncing
+func (s *Server) setSyncWrites() error {
+	q, ok := s.q.(interface{ SetSync(enabled bool) })
+	if !ok {
+		return errors.New("synced writes are not supported by the queue")
+	}
+	q.SetSync(s.syncWrites)
+	return nil
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+)
+
+func TestServer_SyncWrites(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	q := NewMockQueue(ctrl)
+	if _, err := NewServer(WithQueue(q), WithSyncWrites(true)); err == nil {
+		t.Error("expected unsupported queue error")
+	}
+	disabled := &Server{syncWrites: true}
+	if err := WithSyncWrites(false)(disabled); err != nil || disabled.syncWrites {
+		t.Fatal(err)
+	}
+
+	dir := ".haraqa-sync-writes"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithSyncWrites(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.CreateTopic(context.Background(), "synced"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(context.Background(), "synced", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if stats := s.q.CacheStats(); stats.Syncs != 1 {
+		t.Fatal(stats)
+	}
+}
@@ -0,0 +1,425 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// WithProduceQuotas enables produce quotas of topics and principals, which admins manage at the /quotas
+// endpoint. Quotas are stored in the file so that they are kept across restarts, or only in memory if it is
+// empty. Produces beyond a quota are rejected with headers.ErrProduceQuota and a Retry-After header
+func WithProduceQuotas(file string) Option {
+	return func(s *Server) error {
+		q := &produceQuotas{file: file, quotas: make(map[quotaKey]headers.ProduceQuota), buckets: make(map[quotaKey]*tokenBucket)}
+		if err := q.load(); err != nil {
+			return err
+		}
+		s.produceQuotas = q
+		return nil
+	}
+}
+
+// quotaKey identifies the quota of a topic or of a principal
+type quotaKey struct {
+	topic     string
+	principal string
+}
+
+// produceQuotas holds the produce quotas and the token bucket of each topic and principal producing under one
+type produceQuotas struct {
+	file    string
+	mux     sync.Mutex
+	quotas  map[quotaKey]headers.ProduceQuota
+	buckets map[quotaKey]*tokenBucket
+	pruned  time.Time
+}
+
+// tokenBucket holds the messages and bytes left of a quota, which refill at the quota's rates up to one
+// second of each rate. A batch larger than the burst overdraws a full bucket, so that it is throttled rather
+// than always rejected
+type tokenBucket struct {
+	quota    headers.ProduceQuota
+	messages float64
+	bytes    float64
+	last     time.Time
+}
+
+// refill adds the tokens earned since the last refill
+func (b *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+	b.messages = refillTokens(b.messages, b.quota.MessagesPerSecond, elapsed)
+	b.bytes = refillTokens(b.bytes, b.quota.BytesPerSecond, elapsed)
+}
+
+func refillTokens(tokens, rate, elapsed float64) float64 {
+	if rate <= 0 {
+		return 0
+	}
+	return math.Min(rate, tokens+elapsed*rate)
+}
+
+// delay returns how long until the bucket holds the tokens of a batch of count messages of n bytes, ok is true
+// if it holds them now. A batch larger than the burst needs a full bucket
+func (b *tokenBucket) delay(count int, n int64) (wait time.Duration, ok bool) {
+	ok = true
+	for _, t := range [...]struct{ tokens, rate, need float64 }{
+		{b.messages, b.quota.MessagesPerSecond, float64(count)},
+		{b.bytes, b.quota.BytesPerSecond, float64(n)},
+	} {
+		need := math.Min(t.need, t.rate)
+		if t.rate > 0 && t.tokens < need {
+			ok = false
+			if d := time.Duration((need - t.tokens) / t.rate * float64(time.Second)); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait, ok
+}
+
+// take removes the tokens of a batch of count messages of n bytes
+func (b *tokenBucket) take(count int, n int64) {
+	if b.quota.MessagesPerSecond > 0 {
+		b.messages -= float64(count)
+	}
+	if b.quota.BytesPerSecond > 0 {
+		b.bytes -= float64(n)
+	}
+}
+
+// full returns true if the bucket has refilled, so that it is the same as a new bucket
+func (b *tokenBucket) full() bool {
+	return b.messages >= b.quota.MessagesPerSecond && b.bytes >= b.quota.BytesPerSecond
+}
+
+// load reads the stored quotas
+func (q *produceQuotas) load() error {
+	if q.file == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(q.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to read produce quota file")
+	}
+	var quotas []headers.ProduceQuota
+	if err = json.Unmarshal(b, &quotas); err != nil {
+		return errors.Wrap(err, "unable to parse produce quota file")
+	}
+	for _, quota := range quotas {
+		q.quotas[quotaKey{topic: quota.Topic, principal: quota.Principal}] = quota
+	}
+	return nil
+}
+
+// save writes the quotas to the file, replacing it atomically
+func (q *produceQuotas) save() error {
+	if q.file == "" {
+		return nil
+	}
+	b, err := json.Marshal(q.sorted())
+	if err != nil {
+		return err
+	}
+	tmp := q.file + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "unable to write produce quota file")
+	}
+	if err = os.Rename(tmp, q.file); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrap(err, "unable to replace produce quota file")
+	}
+	return nil
+}
+
+// sorted returns the quotas of topics sorted by topic followed by the quotas of principals sorted by principal
+func (q *produceQuotas) sorted() []headers.ProduceQuota {
+	quotas := make([]headers.ProduceQuota, 0, len(q.quotas))
+	for _, quota := range q.quotas {
+		quotas = append(quotas, quota)
+	}
+	sort.Slice(quotas, func(i, j int) bool {
+		if quotas[i].Principal != quotas[j].Principal {
+			return quotas[i].Principal < quotas[j].Principal
+		}
+		return quotas[i].Topic < quotas[j].Topic
+	})
+	return quotas
+}
+
+// list returns every quota
+func (q *produceQuotas) list() []headers.ProduceQuota {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return q.sorted()
+}
+
+// put adds or replaces the quota of its topic or principal
+func (q *produceQuotas) put(quota headers.ProduceQuota) (bool, error) {
+	if (quota.Topic == "") == (quota.Principal == "") {
+		return false, errors.Wrap(headers.ErrInvalidBodyJSON, "quota must have either a topic or a principal")
+	}
+	for _, rate := range []float64{quota.MessagesPerSecond, quota.BytesPerSecond} {
+		if rate < 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+			return false, errors.Wrap(headers.ErrInvalidBodyJSON, "quota rates must be positive numbers")
+		}
+	}
+	if quota.MessagesPerSecond == 0 && quota.BytesPerSecond == 0 {
+		return false, errors.Wrap(headers.ErrInvalidBodyJSON, "quota must limit messages or bytes per second")
+	}
+	key := quotaKey{topic: quota.Topic, principal: quota.Principal}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	old, exists := q.quotas[key]
+	q.quotas[key] = quota
+	if err := q.save(); err != nil {
+		if exists {
+			q.quotas[key] = old
+		} else {
+			delete(q.quotas, key)
+		}
+		return false, err
+	}
+	return !exists, nil
+}
+
+// delete removes the quota of the topic or principal
+func (q *produceQuotas) delete(key quotaKey) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	old, ok := q.quotas[key]
+	if !ok {
+		return headers.ErrQuotaDoesNotExist
+	}
+	delete(q.quotas, key)
+	if err := q.save(); err != nil {
+		q.quotas[key] = old
+		return err
+	}
+	delete(q.buckets, key)
+	return nil
+}
+
+// allow takes a batch of count messages of n bytes from the buckets of the topic and the principal. If
+// either bucket is short nothing is taken and ok is false, along with how long until both hold the batch
+func (q *produceQuotas) allow(topic, principal string, count int, n int64) (wait time.Duration, ok bool) {
+	now := time.Now()
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.prune(now)
+
+	var buckets [2]*tokenBucket
+	quota, exists := q.quotas[quotaKey{topic: topic}]
+	buckets[0] = q.bucket(quotaKey{topic: topic}, quota, exists, now)
+	if principal != "" {
+		quota, exists = q.quotas[quotaKey{principal: principal}]
+		if !exists {
+			quota, exists = q.quotas[quotaKey{principal: "*"}]
+		}
+		buckets[1] = q.bucket(quotaKey{principal: principal}, quota, exists, now)
+	}
+
+	ok = true
+	for _, b := range buckets {
+		if b == nil {
+			continue
+		}
+		if d, allowed := b.delay(count, n); !allowed {
+			ok = false
+			if d > wait {
+				wait = d
+			}
+		}
+	}
+	if !ok {
+		return wait, false
+	}
+	for _, b := range buckets {
+		if b != nil {
+			b.take(count, n)
+		}
+	}
+	return 0, true
+}
+
+// bucket returns the refilled token bucket of the key under the quota, or nil if the key has no quota
+func (q *produceQuotas) bucket(key quotaKey, quota headers.ProduceQuota, exists bool, now time.Time) *tokenBucket {
+	if !exists {
+		delete(q.buckets, key)
+		return nil
+	}
+	b, ok := q.buckets[key]
+	if !ok || b.quota != quota {
+		// the bucket starts again full when the quota is changed
+		b = &tokenBucket{quota: quota, messages: quota.MessagesPerSecond, bytes: quota.BytesPerSecond, last: now}
+		q.buckets[key] = b
+	}
+	b.refill(now)
+	return b
+}
+
+// prune forgets buckets which have refilled, so that principals which stopped producing are not kept
+func (q *produceQuotas) prune(now time.Time) {
+	if now.Sub(q.pruned) < time.Minute {
+		return
+	}
+	q.pruned = now
+	for key, b := range q.buckets {
+		b.refill(now)
+		if b.full() {
+			delete(q.buckets, key)
+		}
+	}
+}
+
+// quotaError is headers.ErrProduceQuota along with how long to wait before retrying the produce
+type quotaError struct {
+	wait time.Duration
+}
+
+func (e *quotaError) Error() string {
+	return headers.ErrProduceQuota.Error()
+}
+
+// Cause returns headers.ErrProduceQuota, so that the error is reported with its code
+func (e *quotaError) Cause() error {
+	return headers.ErrProduceQuota
+}
+
+// throttleProduce counts a batch against the produce quotas of the topic and the context's principal, returning
+// a *quotaError with how long to wait before retrying if either quota is used up
+func (s *Server) throttleProduce(ctx context.Context, topic string, sizes []int64) error {
+	if s.produceQuotas == nil {
+		return nil
+	}
+	var n int64
+	for _, size := range sizes {
+		n += size
+	}
+	principal := Principal(ctx)
+	wait, ok := s.produceQuotas.allow(topic, principal, len(sizes), n)
+	if !ok {
+		s.logger.Debug("produce throttled", "topic", topic, "principal", principal, "wait", wait.String())
+		return &quotaError{wait: wait}
+	}
+	return nil
+}
+
+// ProduceQuotas returns the produce quotas of every topic and principal
+func (s *Server) ProduceQuotas(ctx context.Context) ([]headers.ProduceQuota, error) {
+	if s.produceQuotas == nil {
+		return nil, errors.New("produce quotas are not enabled")
+	}
+	return s.produceQuotas.list(), nil
+}
+
+// PutProduceQuota sets the produce quota of a topic, or of a principal if its principal is set, replacing any
+// existing quota. It returns true if the quota was added
+func (s *Server) PutProduceQuota(ctx context.Context, quota headers.ProduceQuota) (bool, error) {
+	if s.produceQuotas == nil {
+		return false, errors.New("produce quotas are not enabled")
+	}
+	if quota.Topic != "" {
+		var err error
+		if quota.Topic, err = cleanTopic(quota.Topic); err != nil {
+			return false, err
+		}
+	}
+	created, err := s.produceQuotas.put(quota)
+	if err != nil {
+		return false, err
+	}
+	s.logger.Info("produce quota set", "topic", quota.Topic, "principal", quota.Principal,
+		"messagesPerSecond", quota.MessagesPerSecond, "bytesPerSecond", quota.BytesPerSecond)
+	return created, nil
+}
+
+// DeleteProduceQuota removes the produce quota of the topic, or of the principal if principal is set
+func (s *Server) DeleteProduceQuota(ctx context.Context, topic, principal string) error {
+	if s.produceQuotas == nil {
+		return errors.New("produce quotas are not enabled")
+	}
+	if principal == "" {
+		var err error
+		if topic, err = cleanTopic(topic); err != nil {
+			return err
+		}
+	}
+	if err := s.produceQuotas.delete(quotaKey{topic: topic, principal: principal}); err != nil {
+		return err
+	}
+	s.logger.Info("produce quota removed", "topic", topic, "principal", principal)
+	return nil
+}
+
+// HandleQuotas handles requests to the /quotas endpoints. GET /quotas lists the produce quotas of every topic
+// and principal. PUT /quotas/{topic} sets the quota of a topic to the rates in the json body and DELETE
+// /quotas/{topic} removes it. The quota of a principal is set and removed with PUT and DELETE
+// /quotas?principal={principal}
+func (s *Server) HandleQuotas(w http.ResponseWriter, r *http.Request) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+	topic := strings.Trim(strings.TrimPrefix(r.URL.Path, "/quotas"), "/")
+	principal := r.URL.Query().Get("principal")
+
+	switch {
+	case topic != "" && principal != "":
+		w.WriteHeader(http.StatusBadRequest)
+	case r.Method == http.MethodGet && topic == "" && principal == "":
+		quotas, err := s.ProduceQuotas(r.Context())
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		writeSchemaJSON(w, http.StatusOK, map[string][]headers.ProduceQuota{"quotas": quotas})
+	case r.Method == http.MethodPut && (topic != "" || principal != ""):
+		var quota headers.ProduceQuota
+		if r.Body == nil || json.NewDecoder(r.Body).Decode(&quota) != nil {
+			headers.SetError(w, headers.ErrInvalidBodyJSON)
+			return
+		}
+		quota.Topic, quota.Principal = topic, principal
+		created, err := s.PutProduceQuota(r.Context(), quota)
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		if created {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && (topic != "" || principal != ""):
+		if err := s.DeleteProduceQuota(r.Context(), topic, principal); err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// retryAfterSeconds rounds the wait up to the whole seconds sent in a Retry-After header, at least 1
+func retryAfterSeconds(wait time.Duration) int {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
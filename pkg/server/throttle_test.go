@@ -0,0 +1,261 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := &tokenBucket{quota: headers.ProduceQuota{MessagesPerSecond: 10}, messages: 10, last: now}
+	if _, ok := b.delay(10, 0); !ok {
+		t.Fatal("expected tokens left")
+	}
+	// batches larger than the burst overdraw a full bucket
+	if _, ok := b.delay(15, 0); !ok {
+		t.Fatal("expected tokens left")
+	}
+	b.take(15, 1000)
+	if wait, ok := b.delay(1, 0); ok || wait != 600*time.Millisecond {
+		t.Fatal(wait, ok)
+	}
+	b.refill(now.Add(time.Second))
+	if _, ok := b.delay(5, 1000); !ok || b.messages != 5 || b.bytes != 0 {
+		t.Fatal(b.messages, b.bytes)
+	}
+	b.refill(now.Add(time.Minute))
+	if !b.full() || b.messages != 10 {
+		t.Fatal(b.messages)
+	}
+
+	if retryAfterSeconds(0) != 1 || retryAfterSeconds(1500*time.Millisecond) != 2 || retryAfterSeconds(2*time.Second) != 2 {
+		t.Fatal("unexpected retry after")
+	}
+}
+
+func TestProduceQuotas(t *testing.T) {
+	q := &produceQuotas{quotas: make(map[quotaKey]headers.ProduceQuota), buckets: make(map[quotaKey]*tokenBucket)}
+	for _, quota := range []headers.ProduceQuota{
+		{MessagesPerSecond: 1},
+		{Topic: "t", Principal: "p", MessagesPerSecond: 1},
+		{Topic: "t"},
+		{Topic: "t", BytesPerSecond: -1},
+	} {
+		if _, err := q.put(quota); errors.Cause(err) != headers.ErrInvalidBodyJSON {
+			t.Fatal(quota, err)
+		}
+	}
+	if _, err := q.put(headers.ProduceQuota{Topic: "t", MessagesPerSecond: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.put(headers.ProduceQuota{Principal: "*", BytesPerSecond: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the topic quota is shared by every principal
+	if _, ok := q.allow("t", "", 2, 10); !ok {
+		t.Fatal("expected allowed")
+	}
+	if wait, ok := q.allow("t", "a", 1, 10); ok || wait <= 0 {
+		t.Fatal(wait, ok)
+	}
+	// the default principal quota is separate for each principal
+	if _, ok := q.allow("other", "a", 1, 100); !ok {
+		t.Fatal("expected allowed")
+	}
+	if _, ok := q.allow("other", "a", 1, 100); ok {
+		t.Fatal("expected throttled")
+	}
+	if _, ok := q.allow("other", "b", 1, 100); !ok {
+		t.Fatal("expected allowed")
+	}
+	// principals without a name are only limited by topic quotas
+	if _, ok := q.allow("other", "", 1, 1000); !ok {
+		t.Fatal("expected allowed")
+	}
+
+	// a principal's own quota replaces the default
+	if _, err := q.put(headers.ProduceQuota{Principal: "a", MessagesPerSecond: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := q.allow("other", "a", 1, 1000); !ok {
+		t.Fatal("expected allowed")
+	}
+	if err := q.delete(quotaKey{principal: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.delete(quotaKey{principal: "a"}); err != headers.ErrQuotaDoesNotExist {
+		t.Fatal(err)
+	}
+
+	// buckets which have refilled are forgotten
+	q.prune(time.Now().Add(time.Hour))
+	if len(q.buckets) != 0 {
+		t.Fatal(q.buckets)
+	}
+	if quotas := q.list(); len(quotas) != 2 || quotas[0].Topic != "t" || quotas[1].Principal != "*" {
+		t.Fatal(quotas)
+	}
+}
+
+func TestServer_ProduceQuotas(t *testing.T) {
+	dir, file := ".haraqa-quotas", ".haraqa-quotas.json"
+	defer os.RemoveAll(dir)
+	defer os.Remove(file)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithProduceQuotas(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	if created, err := s.PutProduceQuota(ctx, headers.ProduceQuota{Topic: "Quota", MessagesPerSecond: 1}); err != nil || !created {
+		t.Fatal(created, err)
+	}
+	if created, err := s.PutProduceQuota(ctx, headers.ProduceQuota{Topic: "quota", MessagesPerSecond: 2}); err != nil || created {
+		t.Fatal(created, err)
+	}
+	if _, err = s.PutProduceQuota(ctx, headers.ProduceQuota{Topic: ".", MessagesPerSecond: 2}); err != headers.ErrInvalidTopic {
+		t.Fatal(err)
+	}
+
+	// the quotas are restored from the file
+	restored := &produceQuotas{file: file, quotas: make(map[quotaKey]headers.ProduceQuota)}
+	if err = restored.load(); err != nil || restored.quotas[quotaKey{topic: "quota"}].MessagesPerSecond != 2 {
+		t.Fatal(restored.quotas, err)
+	}
+
+	// produces made by protocol listeners are throttled like http produces
+	if err = s.CreateTopic(ctx, "quota"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.PutProduceQuota(ctx, headers.ProduceQuota{Principal: "tenant", MessagesPerSecond: 1}); err != nil {
+		t.Fatal(err)
+	}
+	throttled := func(ctx context.Context, topic string) bool {
+		for i := 0; i < 10; i++ {
+			if err := s.ProduceMsgs(ctx, topic, []byte("a")); err != nil {
+				return errors.Cause(err) == headers.ErrProduceQuota
+			}
+		}
+		return false
+	}
+	if !throttled(ctx, "quota") {
+		t.Fatal("expected the topic quota to throttle produces")
+	}
+	if err = s.CreateTopic(ctx, "other"); err != nil {
+		t.Fatal(err)
+	}
+	if !throttled(WithPrincipal(ctx, "tenant"), "other") {
+		t.Fatal("expected the principal quota to throttle produces")
+	}
+	if err = s.DeleteProduceQuota(ctx, "", "tenant"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.DeleteProduceQuota(ctx, "QUOTA", ""); err != nil {
+		t.Fatal(err)
+	}
+	if quotas, err := s.ProduceQuotas(ctx); err != nil || len(quotas) != 0 {
+		t.Fatal(quotas, err)
+	}
+	if _, err = (&Server{}).ProduceQuotas(ctx); err == nil {
+		t.Fatal("expected produce quotas are not enabled error")
+	}
+}
+
+func TestServer_HandleQuotas(t *testing.T) {
+	dir := ".haraqa-handle-quotas"
+	defer os.RemoveAll(dir)
+	authenticate := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), r.Header.Get("X-User"))))
+		})
+	}
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithProduceQuotas(""), WithMiddleware(authenticate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.CreateTopic(context.Background(), "throttled"); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		var b []byte
+		if body != nil {
+			b, _ = json.Marshal(body)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(b)))
+		return w
+	}
+	if w := request(http.MethodPut, "/quotas/throttled", headers.ProduceQuota{MessagesPerSecond: 2}); w.Code != http.StatusCreated {
+		t.Fatal(w.Code)
+	}
+	if w := request(http.MethodPut, "/quotas?principal=noisy", headers.ProduceQuota{BytesPerSecond: 10}); w.Code != http.StatusCreated {
+		t.Fatal(w.Code)
+	}
+	if w := request(http.MethodPut, "/quotas/throttled", headers.ProduceQuota{}); w.Code != http.StatusBadRequest {
+		t.Fatal(w.Code)
+	}
+	if w := request(http.MethodPut, "/quotas/throttled?principal=noisy", headers.ProduceQuota{MessagesPerSecond: 1}); w.Code != http.StatusBadRequest {
+		t.Fatal(w.Code)
+	}
+	if w := request(http.MethodPost, "/quotas", nil); w.Code != http.StatusMethodNotAllowed {
+		t.Fatal(w.Code)
+	}
+	w := request(http.MethodGet, "/quotas", nil)
+	var quotas struct {
+		Quotas []headers.ProduceQuota `json:"quotas"`
+	}
+	if err = json.Unmarshal(w.Body.Bytes(), &quotas); err != nil || len(quotas.Quotas) != 2 {
+		t.Fatal(w.Body.String(), err)
+	}
+
+	produce := func(user string, msgs ...[]byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/topics/throttled", bytes.NewReader(bytes.Join(msgs, nil)))
+		sizes := make([]int64, len(msgs))
+		for i := range msgs {
+			sizes[i] = int64(len(msgs[i]))
+		}
+		headers.SetSizes(sizes, r.Header)
+		r.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+	// the noisy principal is throttled by its byte quota before the topic quota
+	if w = produce("noisy", []byte("0123456789")); w.Code != http.StatusNoContent {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = produce("noisy", []byte("a")); w.Code != http.StatusTooManyRequests || headers.ReadErrors(w.Header()) != headers.ErrProduceQuota || w.Header().Get("Retry-After") != "1" {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = produce("quiet", []byte("a")); w.Code != http.StatusNoContent {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = produce("quiet", []byte("a")); w.Code != http.StatusTooManyRequests {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	// removing the quotas stops throttling
+	if w = request(http.MethodDelete, "/quotas/throttled", nil); w.Code != http.StatusNoContent {
+		t.Fatal(w.Code)
+	}
+	if w = request(http.MethodDelete, "/quotas/throttled", nil); w.Code != http.StatusNotFound || headers.ReadErrors(w.Header()) != headers.ErrQuotaDoesNotExist {
+		t.Fatal(w.Code)
+	}
+	if w = produce("quiet", []byte("a")); w.Code != http.StatusNoContent {
+		t.Fatal(w.Code, w.Header())
+	}
+}
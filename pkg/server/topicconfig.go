@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// WithTopicConfigFile stores the configuration of each topic in the file so that it is kept across
+// restarts, by default topic configuration is only kept in memory
+func WithTopicConfigFile(file string) Option {
+	return func(s *Server) error {
+		if file == "" {
+			return errors.New("invalid topic config file, file cannot be empty")
+		}
+		s.topicConfigs.file = file
+		return s.topicConfigs.load()
+	}
+}
+
+// topicConfigs holds the configuration of each topic which has been configured
+type topicConfigs struct {
+	file    string
+	mux     sync.RWMutex
+	configs map[string]headers.TopicConfig
+}
+
+// load reads the stored topic configuration
+func (c *topicConfigs) load() error {
+	b, err := ioutil.ReadFile(c.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to read topic config file")
+	}
+	if err = json.Unmarshal(b, &c.configs); err != nil {
+		return errors.Wrap(err, "unable to parse topic config file")
+	}
+	for topic, config := range c.configs {
+		if config.Truncate == nil {
+			continue
+		}
+		if err = checkTruncateRule(*config.Truncate); err != nil {
+			return errors.Wrapf(err, "invalid truncate rule of topic %q in topic config file", topic)
+		}
+	}
+	return nil
+}
+
+// save writes the topic configuration to the file, replacing it atomically
+func (c *topicConfigs) save() error {
+	if c.file == "" {
+		return nil
+	}
+	b, err := json.Marshal(c.configs)
+	if err != nil {
+		return err
+	}
+	tmp := c.file + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "unable to write topic config file")
+	}
+	if err = os.Rename(tmp, c.file); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrap(err, "unable to replace topic config file")
+	}
+	return nil
+}
+
+// get returns the configuration of the topic
+func (c *topicConfigs) get(topic string) headers.TopicConfig {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.configs[topic]
+}
+
+// update sets the fields of the topic's configuration which are set in update
+func (c *topicConfigs) update(topic string, update headers.TopicConfig) (headers.TopicConfig, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.configs == nil {
+		c.configs = make(map[string]headers.TopicConfig)
+	}
+	old, ok := c.configs[topic]
+	config := old
+	if update.ReadOnly != nil {
+		config.ReadOnly = update.ReadOnly
+	}
+	if update.Retention != nil {
+		config.Retention = update.Retention
+	}
+	if update.Truncate != nil {
+		config.Truncate = update.Truncate
+		if update.Truncate.Cron == "" {
+			config.Truncate = nil
+		}
+	}
+	c.configs[topic] = config
+	if err := c.save(); err != nil {
+		if ok {
+			c.configs[topic] = old
+		} else {
+			delete(c.configs, topic)
+		}
+		return old, err
+	}
+	return config, nil
+}
+
+// delete removes the configuration of the topic
+func (c *topicConfigs) delete(topic string) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	old, ok := c.configs[topic]
+	if !ok {
+		return nil
+	}
+	delete(c.configs, topic)
+	if err := c.save(); err != nil {
+		c.configs[topic] = old
+		return err
+	}
+	return nil
+}
+
+// retains returns true if any topic has a retention
+func (c *topicConfigs) retains() bool {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	for _, config := range c.configs {
+		if config.Retention != nil && *config.Retention > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// truncateRules returns the truncation rule of each topic which has one
+func (c *topicConfigs) truncateRules() map[string]headers.TruncateRule {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	rules := make(map[string]headers.TruncateRule)
+	for topic, config := range c.configs {
+		if config.Truncate != nil {
+			rules[topic] = *config.Truncate
+		}
+	}
+	return rules
+}
+
+// readOnly returns true if produces to the topic are rejected
+func (c *topicConfigs) readOnly(topic string) bool {
+	config := c.get(topic)
+	return config.ReadOnly != nil && *config.ReadOnly
+}
+
+// TopicConfig returns the configuration of the topic
+func (s *Server) TopicConfig(ctx context.Context, topic string) (*headers.TopicConfig, error) {
+	topic, err := cleanTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = s.InspectTopic(ctx, topic); err != nil {
+		return nil, err
+	}
+	config := s.topicConfigs.get(topic)
+	return &config, nil
+}
+
+// configureTopic updates the configuration of the topic, logging the result
+func (s *Server) configureTopic(ctx context.Context, topic string, update headers.TopicConfig) (*headers.TopicConfig, error) {
+	if update.Retention != nil && *update.Retention < 0 {
+		return nil, errors.Wrap(headers.ErrInvalidBodyJSON, "retention cannot be negative")
+	}
+	if update.Truncate != nil && update.Truncate.Cron != "" {
+		if err := checkTruncateRule(*update.Truncate); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := s.InspectTopic(ctx, topic); err != nil {
+		return nil, err
+	}
+	config, err := s.topicConfigs.update(topic, update)
+	if err != nil {
+		s.logError("unable to save topic config", err, "topic", topic)
+		return nil, err
+	}
+	s.logger.Info("topic configured", "topic", topic, "readOnly", config.ReadOnly != nil && *config.ReadOnly,
+		"retention", s.topicRetention(topic))
+	if update.Retention != nil && *update.Retention > 0 {
+		s.startJanitor()
+	}
+	if update.Truncate != nil {
+		if err = s.scheduleTruncation(topic, config.Truncate); err != nil {
+			s.logError("unable to schedule topic truncation", err, "topic", topic)
+			return nil, err
+		}
+	}
+	return &config, nil
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestWithTopicConfigFile(t *testing.T) {
+	file := ".haraqa-topic-config.json"
+	defer os.Remove(file)
+	if err := WithTopicConfigFile("")(&Server{}); err == nil {
+		t.Error("expected empty file error")
+	}
+	if err := ioutil.WriteFile(file, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WithTopicConfigFile(file)(&Server{}); err == nil {
+		t.Error("expected parse error")
+	}
+	if err := ioutil.WriteFile(file, []byte(`{"frozen":{"readOnly":true}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{}
+	if err := WithTopicConfigFile(file)(s); err != nil {
+		t.Fatal(err)
+	}
+	if !s.topicConfigs.readOnly("frozen") || s.topicConfigs.readOnly("other") {
+		t.Fatal(s.topicConfigs.configs)
+	}
+}
+
+func TestServer_TopicReadOnly(t *testing.T) {
+	dir, file := ".haraqa-readonly", ".haraqa-readonly.json"
+	defer os.RemoveAll(dir)
+	defer os.Remove(file)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithTopicConfigFile(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	if err = s.CreateTopic(ctx, "frozen"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(ctx, "frozen", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	configure := func(topic, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/topics/"+topic, bytes.NewBufferString(body)))
+		return w
+	}
+	produce := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/topics/frozen", bytes.NewBufferString("b"))
+		r.Header.Set(headers.HeaderSizes, "1")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	w := configure("frozen", `{"config":{"readOnly":true}}`)
+	var info headers.TopicInfo
+	if err = json.NewDecoder(w.Body).Decode(&info); w.Code != http.StatusOK || err != nil || info.MaxOffset != 0 ||
+		info.Config == nil || info.Config.ReadOnly == nil || !*info.Config.ReadOnly {
+		t.Fatal(w.Code, info, err)
+	}
+
+	// produces are rejected while consumes continue
+	if w = produce(); w.Code != http.StatusForbidden || headers.ReadErrors(w.Header()) != headers.ErrTopicReadOnly {
+		t.Fatal(w.Code, w.Header())
+	}
+	if err = s.ProduceMsgs(ctx, "frozen", []byte("b")); err != headers.ErrTopicReadOnly {
+		t.Fatal(err)
+	}
+	if msgs, err := s.ConsumeMsgs(ctx, "frozen", 0, 10); err != nil || len(msgs) != 1 {
+		t.Fatal(msgs, err)
+	}
+
+	// the config is stored and can be read back
+	b, err := ioutil.ReadFile(file)
+	if err != nil || string(b) != `{"frozen":{"readOnly":true}}` {
+		t.Fatal(string(b), err)
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/topics/frozen?config=true", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "{\"readOnly\":true}\n" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	// unfreezing allows produces again
+	if w = configure("frozen", `{"config":{"readOnly":false}}`); w.Code != http.StatusOK {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = produce(); w.Code != http.StatusNoContent {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	// missing topics cannot be configured
+	if w = configure("missing", `{"config":{"readOnly":true}}`); headers.ReadErrors(w.Header()) != headers.ErrTopicDoesNotExist {
+		t.Fatal(w.Code, w.Header())
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/topics/missing?config=true", nil))
+	if headers.ReadErrors(w.Header()) != headers.ErrTopicDoesNotExist {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	// deleting the topic removes its config
+	if err = s.DeleteTopic(ctx, "frozen"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = ioutil.ReadFile(file); err != nil || string(b) != `{}` {
+		t.Fatal(string(b), err)
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/tracing"
+	"github.com/pkg/errors"
+)
+
+// WithTracer sets the tracer used to create spans for each request and the queue operations it performs.
+// Incoming W3C traceparent headers are used as the parent of the request span
+func WithTracer(tracer tracing.Tracer) Option {
+	return func(s *Server) error {
+		if tracer == nil {
+			return errors.New("tracer cannot be nil")
+		}
+		s.tracer = tracer
+		return nil
+	}
+}
+
+// traceRequests wraps the handler in a span per request, continuing any trace given by the client
+func (s *Server) traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if tp, err := tracing.ParseTraceParent(r.Header.Get(tracing.HeaderTraceParent)); err == nil {
+			ctx = tracing.ContextWithTraceParent(ctx, tp)
+		}
+		ctx, span := s.tracer.Start(ctx, "HTTP "+r.Method, tracing.SpanKindServer)
+		defer span.End()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.Path)
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		span.SetAttribute("http.status_code", sw.status)
+		if errs := w.Header()[headers.HeaderErrors]; len(errs) > 0 {
+			span.RecordError(errors.New(errs[0]))
+		}
+	})
+}
+
+// startSpan starts a span for a queue operation as a child of the request span. The latency of the
+// operation and any error recorded are reported to the metrics handler when the span ends
+func (s *Server) startSpan(ctx context.Context, name, topic string) tracing.Span {
+	_, span := s.tracer.Start(ctx, name, tracing.SpanKindInternal)
+	if topic != "" {
+		span.SetAttribute("messaging.destination", topic)
+	}
+	return &queueSpan{Span: span, metrics: s.metrics, op: strings.TrimPrefix(name, "queue."), start: time.Now()}
+}
+
+// queueSpan is the span of a queue operation
+type queueSpan struct {
+	tracing.Span
+	metrics Metrics
+	op      string
+	start   time.Time
+	err     error
+}
+
+func (q *queueSpan) RecordError(err error) {
+	if err != nil {
+		q.err = err
+	}
+	q.Span.RecordError(err)
+}
+
+func (q *queueSpan) End() {
+	q.metrics.QueueLatency(q.op, time.Since(q.start))
+	if q.err != nil {
+		q.metrics.QueueError(q.op, string(headers.Code(q.err)))
+	}
+	q.Span.End()
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/tracing"
+)
+
+type testSpan struct {
+	name   string
+	kind   tracing.SpanKind
+	parent tracing.TraceParent
+	tp     tracing.TraceParent
+	attrs  map[string]interface{}
+	err    error
+	ended  bool
+}
+
+func (s *testSpan) TraceParent() tracing.TraceParent           { return s.tp }
+func (s *testSpan) SetAttribute(key string, value interface{}) { s.attrs[key] = value }
+func (s *testSpan) RecordError(err error) {
+	if err != nil {
+		s.err = err
+	}
+}
+func (s *testSpan) End() { s.ended = true }
+
+type testTracer struct {
+	mux   sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string, kind tracing.SpanKind) (context.Context, tracing.Span) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	parent, _ := tracing.FromContext(ctx)
+	span := &testSpan{
+		name:   name,
+		kind:   kind,
+		parent: parent,
+		tp:     tracing.TraceParent{TraceID: parent.TraceID, SpanID: tracing.NewSpanID(), Flags: 1},
+		attrs:  make(map[string]interface{}),
+	}
+	if !parent.IsValid() {
+		span.tp.TraceID = tracing.NewTraceID()
+	}
+	t.spans = append(t.spans, span)
+	return tracing.ContextWithTraceParent(ctx, span.tp), span
+}
+
+func TestServer_Tracing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	topic := "traced_topic"
+	q := NewMockQueue(ctrl)
+	gomock.InOrder(
+		q.EXPECT().RootDir().Times(1).Return(""),
+		q.EXPECT().Produce(gomock.Any(), topic, []int64{5, 6}, gomock.Any(), gomock.Any()).Return(headers.ErrTopicDoesNotExist).Times(1),
+		q.EXPECT().Close().Return(nil).Times(1),
+	)
+
+	if err := WithTracer(nil)(&Server{}); err == nil {
+		t.Fatal("expected nil tracer error")
+	}
+
+	tracer := &testTracer{}
+	s, err := NewServer(WithQueue(q), WithTracer(tracer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	parent := tracing.TraceParent{TraceID: tracing.NewTraceID(), SpanID: tracing.NewSpanID(), Flags: 1}
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest(http.MethodPost, "/topics/"+topic, bytes.NewBuffer([]byte("Hello World")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set(tracing.HeaderTraceParent, parent.String())
+	r.Header.Add(headers.HeaderSizes, "5")
+	r.Header.Add(headers.HeaderSizes, "6")
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatal(w.Code)
+	}
+
+	if len(tracer.spans) != 2 {
+		t.Fatal(tracer.spans)
+	}
+	req, produce := tracer.spans[0], tracer.spans[1]
+	if req.name != "HTTP POST" || req.kind != tracing.SpanKindServer || req.parent != parent || !req.ended {
+		t.Error(req)
+	}
+	if req.attrs["http.status_code"] != http.StatusPreconditionFailed || req.err == nil {
+		t.Error(req.attrs, req.err)
+	}
+	if produce.name != "queue.Produce" || produce.kind != tracing.SpanKindInternal || produce.parent != req.tp || !produce.ended {
+		t.Error(produce)
+	}
+	if produce.attrs["messaging.destination"] != topic || produce.err != headers.ErrTopicDoesNotExist {
+		t.Error(produce.attrs, produce.err)
+	}
+}
+
+type opMetrics struct {
+	noOpMetrics
+	mux                          sync.Mutex
+	latencies                    map[string]int
+	errors                       map[string]string
+	producedBytes, consumedBytes int64
+}
+
+func (m *opMetrics) QueueLatency(op string, d time.Duration) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.latencies[op]++
+}
+
+func (m *opMetrics) QueueError(op, code string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.errors[op] = code
+}
+
+func (m *opMetrics) ProduceBytes(n int64) { m.producedBytes += n }
+func (m *opMetrics) ConsumeBytes(n int64) { m.consumedBytes += n }
+
+func TestServer_QueueMetrics(t *testing.T) {
+	dir := ".haraqa-queue-metrics"
+	defer os.RemoveAll(dir)
+	metrics := &opMetrics{latencies: map[string]int{}, errors: map[string]string{}}
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithMetrics(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	if err = s.CreateTopic(ctx, "ops"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(ctx, "ops", []byte("hello"), []byte("world!")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.ConsumeMsgs(ctx, "ops", 0, -1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.ConsumeMsgs(ctx, "missing", 0, -1); err == nil {
+		t.Fatal("expected missing topic error")
+	}
+
+	if metrics.latencies["CreateTopic"] != 1 || metrics.latencies["Produce"] != 1 || metrics.latencies["Consume"] != 2 {
+		t.Error(metrics.latencies)
+	}
+	if len(metrics.errors) != 1 || metrics.errors["Consume"] != string(headers.CodeTopicDoesNotExist) {
+		t.Error(metrics.errors)
+	}
+	if metrics.producedBytes != 11 || metrics.consumedBytes != 11 {
+		t.Error(metrics.producedBytes, metrics.consumedBytes)
+	}
+}
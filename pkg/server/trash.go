@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// WithDeleteGracePeriod sets how long deleted topics are kept in the queue's trash, during which they can
+// be restored, before their space is reclaimed. A period of 0 removes topics immediately, defaults to 24h
+func WithDeleteGracePeriod(period time.Duration) Option {
+	return func(s *Server) error {
+		if period < 0 {
+			return errors.New("invalid grace period, value must not be negative")
+		}
+		s.deleteGrace = period
+		return nil
+	}
+}
+
+// RestoreTopic restores a deleted topic which is still within the delete grace period
+func (s *Server) RestoreTopic(ctx context.Context, topic string) error {
+	topic, err := cleanTopic(topic)
+	if err != nil {
+		return err
+	}
+	return s.restoreTopic(ctx, topic)
+}
+
+// restoreTopic restores the topic, logging the result and calling any create hooks
+func (s *Server) restoreTopic(ctx context.Context, topic string) error {
+	key, err := s.reserveCreation(ctx)
+	if err != nil {
+		s.logger.Warn("topic creation quota exceeded", "topic", topic, "key", key)
+		return err
+	}
+	if err = s.reserveTopic(); err != nil {
+		s.releaseCreation(ctx, key)
+		s.logger.Warn("unable to restore topic", "topic", topic, "err", err)
+		return err
+	}
+	span := s.startSpan(ctx, "queue.RestoreTopic", topic)
+	err = s.q.RestoreTopic(topic)
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		s.releaseCreation(ctx, key)
+		s.releaseTopic()
+		s.logError("unable to restore topic", err, "topic", topic)
+		return err
+	}
+	s.logger.Info("topic restored", "topic", topic)
+	s.onTopicCreate(topic)
+	return nil
+}
+
+// purgeTopics reclaims the space of topics deleted longer than the grace period ago, returning the number
+// of topics purged
+func (s *Server) purgeTopics() (int64, error) {
+	purged, err := s.q.PurgeTopics(time.Now().Add(-s.deleteGrace))
+	for _, topic := range purged {
+		s.logger.Info("topic purged", "topic", topic)
+	}
+	return int64(len(purged)), err
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestServer_DeleteGracePeriod(t *testing.T) {
+	if err := WithDeleteGracePeriod(-1)(&Server{}); err == nil {
+		t.Error("expected invalid grace period error")
+	}
+
+	dir := ".haraqa-trash"
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	do := func(s *Server, method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	// deleted topics can be restored during the grace period
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(ctx, "orders", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if w := do(s, http.MethodDelete, "/topics/orders"); w.Code != http.StatusNoContent {
+		t.Fatal(w.Code)
+	}
+	if _, err = s.ConsumeMsgs(ctx, "orders", 0, 1); err != headers.ErrTopicDoesNotExist {
+		t.Fatal(err)
+	}
+	if w := do(s, http.MethodPut, "/topics/orders?restore=true"); w.Code != http.StatusCreated {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	msgs, err := s.ConsumeMsgs(ctx, "orders", 0, 1)
+	if err != nil || len(msgs) != 1 || string(msgs[0]) != "hello" {
+		t.Fatal(msgs, err)
+	}
+	if w := do(s, http.MethodPut, "/topics/orders?restore=true"); w.Code != http.StatusPreconditionFailed || w.Header().Get(headers.HeaderErrorCode) != string(headers.CodeTopicAlreadyExists) {
+		t.Fatal(w.Code, w.Header())
+	}
+	if err = s.DeleteTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Close()
+
+	// deleted topics are purged once the grace period has passed
+	s, err = NewServer(WithFileQueue([]string{dir}, true, 5000), WithDeleteGracePeriod(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		err = s.RestoreTopic(ctx, "orders")
+		if err == headers.ErrTopicDoesNotExist {
+			break
+		}
+		if err != nil || time.Now().After(deadline) {
+			t.Fatal("expected deleted topic to be purged", err)
+		}
+		if err = s.DeleteTopic(ctx, "orders"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = s.Close()
+
+	// a grace period of 0 deletes immediately
+	s, err = NewServer(WithFileQueue([]string{dir}, true, 5000), WithDeleteGracePeriod(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if w := do(s, http.MethodPut, "/topics/orders?restore=true"); w.Code != http.StatusPreconditionFailed || w.Header().Get(headers.HeaderErrorCode) != string(headers.CodeTopicDoesNotExist) {
+		t.Fatal(w.Code, w.Header())
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// ListTopicTree lists one level of the topic hierarchy below the prefix, like ListTopics with a delimiter.
+// Topics with the prefix which contain the delimiter after it are rolled up into the prefix up to and
+// including the delimiter, the remaining topics are returned as they are. A topic and its nested topics
+// are returned as both a topic and a prefix
+func (s *Server) ListTopicTree(ctx context.Context, prefix, delimiter string) ([]string, []string, error) {
+	topics, err := s.ListTopics(ctx, prefix, "", "")
+	if err != nil {
+		return nil, nil, err
+	}
+	topics, prefixes := splitTopics(topics, prefix, delimiter)
+	return topics, prefixes, nil
+}
+
+// splitTopics splits the sorted topics into the topics without the delimiter after the prefix and the
+// distinct prefixes of the others, up to and including the first delimiter after the prefix
+func splitTopics(topics []string, prefix, delimiter string) ([]string, []string) {
+	leaves, prefixes := []string{}, []string{}
+	if delimiter == "" {
+		return append(leaves, topics...), prefixes
+	}
+	seen := make(map[string]bool)
+	for _, topic := range topics {
+		if !strings.HasPrefix(topic, prefix) {
+			continue
+		}
+		i := strings.Index(topic[len(prefix):], delimiter)
+		if i < 0 {
+			leaves = append(leaves, topic)
+			continue
+		}
+		p := topic[:len(prefix)+i+len(delimiter)]
+		if !seen[p] {
+			seen[p] = true
+			prefixes = append(prefixes, p)
+		}
+	}
+	sort.Strings(prefixes)
+	return leaves, prefixes
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestServer_ListTopicTree(t *testing.T) {
+	dir := ".haraqa-tree"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	for _, topic := range []string{"orders", "orders/eu/fr", "orders/eu/de", "orders/us", "payments/eu", "users"} {
+		if err = s.CreateTopic(ctx, topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the top level rolls nested topics up into their prefixes, parent directories are topics as well
+	topics, prefixes, err := s.ListTopicTree(ctx, "", "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(topics, []string{"orders", "payments", "users"}) || !reflect.DeepEqual(prefixes, []string{"orders/", "payments/"}) {
+		t.Fatal(topics, prefixes)
+	}
+
+	// one level below a prefix
+	topics, prefixes, err = s.ListTopicTree(ctx, "orders/", "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(topics, []string{"orders/eu", "orders/us"}) || !reflect.DeepEqual(prefixes, []string{"orders/eu/"}) {
+		t.Fatal(topics, prefixes)
+	}
+
+	// without a delimiter every topic is a leaf
+	topics, prefixes, err = s.ListTopicTree(ctx, "orders/eu/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(topics, []string{"orders/eu/de", "orders/eu/fr"}) || len(prefixes) != 0 {
+		t.Fatal(topics, prefixes)
+	}
+
+	list := func(query, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/topics?"+query, nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	// json lists the topics and prefixes separately
+	w := list("delimiter=/&prefix=orders/", "application/json")
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Code, w.Header())
+	}
+	var listing map[string][]string
+	if err = json.NewDecoder(w.Body).Decode(&listing); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(listing, map[string][]string{"topics": {"orders/eu", "orders/us"}, "prefixes": {"orders/eu/"}}) {
+		t.Fatal(listing)
+	}
+
+	// csv lists the topics followed by the prefixes
+	w = list("delimiter=/", "")
+	if w.Code != http.StatusOK || w.Body.String() != "orders,payments,users,orders/,payments/" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+}
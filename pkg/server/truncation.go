@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// truncationHistory is the number of recent truncations returned by Stats
+var truncationHistory = 100
+
+// Truncation is a run of a topic's truncation rule
+type Truncation struct {
+	Topic   string           `json:"topic"`
+	Time    time.Time        `json:"time"`
+	Keep    headers.Duration `json:"keep"`
+	Removed int64            `json:"removed"`
+	DryRun  bool             `json:"dryRun,omitempty"`
+	Error   string           `json:"error,omitempty"`
+}
+
+// truncations holds the most recent truncations, oldest first
+type truncations struct {
+	mux     sync.Mutex
+	history []Truncation
+}
+
+// add records the truncation, forgetting the oldest once the history is full
+func (t *truncations) add(truncation Truncation) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if len(t.history) >= truncationHistory {
+		t.history = append(t.history[:0], t.history[len(t.history)-truncationHistory+1:]...)
+	}
+	t.history = append(t.history, truncation)
+}
+
+// list returns a copy of the history
+func (t *truncations) list() []Truncation {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return append([]Truncation{}, t.history...)
+}
+
+// checkTruncateRule returns headers.ErrInvalidBodyJSON if the rule's cron expression is invalid or never
+// matches, or if the rule keeps no messages
+func checkTruncateRule(rule headers.TruncateRule) error {
+	c, err := parseCron(rule.Cron)
+	if err != nil {
+		return errors.Wrap(headers.ErrInvalidBodyJSON, err.Error())
+	}
+	if c.next(time.Now().UTC()).IsZero() {
+		return errors.Wrap(headers.ErrInvalidBodyJSON, "truncate cron expression never matches")
+	}
+	if rule.Keep <= 0 {
+		return errors.Wrap(headers.ErrInvalidBodyJSON, "truncate keep must be greater than 0")
+	}
+	return nil
+}
+
+// scheduleTruncation schedules the topic's truncation rule, or removes its job if the rule is nil
+func (s *Server) scheduleTruncation(topic string, rule *headers.TruncateRule) error {
+	if rule == nil {
+		s.scheduler.removeJob(topic)
+		return nil
+	}
+	keep := time.Duration(rule.Keep)
+	err := s.scheduler.setJob(topic, rule.Cron, func(ctx context.Context, now time.Time) {
+		s.truncateTopic(ctx, topic, keep, now)
+	})
+	if err != nil {
+		return err
+	}
+	s.startScheduler()
+	return nil
+}
+
+// scheduleTruncations schedules the truncation rules of every configured topic
+func (s *Server) scheduleTruncations() {
+	for topic, rule := range s.topicConfigs.truncateRules() {
+		rule := rule
+		if err := s.scheduleTruncation(topic, &rule); err != nil {
+			s.logError("unable to schedule topic truncation", err, "topic", topic)
+		}
+	}
+}
+
+// truncateTopic removes the messages of the topic produced longer than keep before now, recording the
+// truncation in the history
+func (s *Server) truncateTopic(ctx context.Context, topic string, keep time.Duration, now time.Time) {
+	start := time.Now()
+	n, err := s.expireTopic(ctx, topic, now.Add(-keep))
+	truncation := Truncation{Topic: topic, Time: now, Keep: headers.Duration(keep), Removed: n, DryRun: s.janitor.dryRun}
+	if err != nil {
+		truncation.Error = err.Error()
+		s.logError("unable to truncate topic", err, "topic", topic)
+	} else {
+		s.logger.Info("truncation rule applied", "topic", topic, "keep", keep.String(), "removed", n,
+			"dryRun", s.janitor.dryRun, "duration", time.Since(start).String())
+	}
+	s.truncations.add(truncation)
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestTruncations(t *testing.T) {
+	defer func(n int) { truncationHistory = n }(truncationHistory)
+	truncationHistory = 2
+	var history truncations
+	for _, topic := range []string{"a", "b", "c"} {
+		history.add(Truncation{Topic: topic})
+	}
+	if list := history.list(); len(list) != 2 || list[0].Topic != "b" || list[1].Topic != "c" {
+		t.Fatal(list)
+	}
+
+	for _, rule := range []headers.TruncateRule{
+		{Cron: "@often", Keep: headers.Duration(time.Hour)},
+		{Cron: "0 0 30 2 *", Keep: headers.Duration(time.Hour)},
+		{Cron: "@hourly"},
+	} {
+		if err := checkTruncateRule(rule); errors.Cause(err) != headers.ErrInvalidBodyJSON {
+			t.Fatal(rule, err)
+		}
+	}
+}
+
+func TestServer_TruncateRule(t *testing.T) {
+	dir, file := ".haraqa-truncate", ".haraqa-truncate.json"
+	defer os.RemoveAll(dir)
+	defer os.Remove(file)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 2), WithTopicConfigFile(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	for _, topic := range []string{"rolling", "other"} {
+		if err = s.CreateTopic(ctx, topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err = s.ProduceMsgs(ctx, "rolling", []byte("old"), []byte("old")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	configure := func(topic, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/topics/"+topic, bytes.NewBufferString(body)))
+		return w
+	}
+	if w := configure("rolling", `{"config":{"truncate":{"cron":"@hourly","keep":"0s"}}}`); w.Code != http.StatusBadRequest {
+		t.Fatal(w.Code)
+	}
+	if w := configure("rolling", `{"config":{"truncate":{"cron":"@hourly","keep":"24h"}}}`); w.Code != http.StatusOK {
+		t.Fatal(w.Code)
+	}
+	if w := configure("other", `{"config":{"truncate":{"cron":"*/5 * * * *","keep":"1h"}}}`); w.Code != http.StatusOK {
+		t.Fatal(w.Code)
+	}
+	s.scheduler.mux.Lock()
+	job, ok := s.scheduler.jobs["rolling"]
+	s.scheduler.mux.Unlock()
+	if !ok || job.next.Minute() != 0 {
+		t.Fatal(job)
+	}
+
+	// a run removes the files of messages older than the rule keeps, except for the latest file
+	job.job(ctx, time.Now().Add(48*time.Hour))
+	if info, err := s.q.InspectTopic("rolling"); err != nil || info.MinOffset != 4 {
+		t.Fatal(info, err)
+	}
+	stats, err := s.Stats(ctx)
+	if err != nil || len(stats.Truncations) != 1 || stats.Truncations[0].Topic != "rolling" || stats.Truncations[0].Removed != 4 ||
+		stats.Truncations[0].Keep != headers.Duration(24*time.Hour) {
+		t.Fatal(stats, err)
+	}
+
+	// the rules are scheduled again when the server restarts
+	restored, err := NewServer(WithQueue(s.q), WithTopicConfigFile(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.scheduler.jobs) != 2 {
+		t.Fatal(restored.scheduler.jobs)
+	}
+	close(restored.done)
+	restored.wg.Wait()
+
+	// an empty cron removes the rule, as does deleting the topic
+	if w := configure("rolling", `{"config":{"truncate":{"cron":""}}}`); w.Code != http.StatusOK {
+		t.Fatal(w.Code)
+	}
+	if config, err := s.TopicConfig(ctx, "rolling"); err != nil || config.Truncate != nil {
+		t.Fatal(config, err)
+	}
+	if err = s.DeleteTopic(ctx, "other"); err != nil {
+		t.Fatal(err)
+	}
+	s.scheduler.mux.Lock()
+	defer s.scheduler.mux.Unlock()
+	if len(s.scheduler.jobs) != 0 {
+		t.Fatal(s.scheduler.jobs)
+	}
+}
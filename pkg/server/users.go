@@ -0,0 +1,340 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// WithUserStore requires requests to authenticate with the basic auth credentials of a user in the store,
+// and enables managing the users at the /users endpoint. Users are stored in the file with bcrypt hashes of
+// their passwords, or only in memory if it is empty. If admin is set and is not a user it is added as an
+// admin user with the password, so that the first users can be added. Authenticated requests are made as
+// the user's name, which ACL rules can grant permissions to
+func WithUserStore(file, admin, password string) Option {
+	return func(s *Server) error {
+		u := &userStore{file: file, users: make(map[string]*userRecord), verified: make(map[string][sha256.Size]byte)}
+		if err := u.load(); err != nil {
+			return err
+		}
+		if _, ok := u.users[admin]; admin != "" && !ok {
+			if _, err := u.put(headers.User{Name: admin, Password: password, Admin: true}); err != nil {
+				return errors.Wrap(err, "unable to add admin user")
+			}
+		}
+		s.users = u
+		return nil
+	}
+}
+
+// userRecord is a user as stored in the user file
+type userRecord struct {
+	Hash    []byte    `json:"hash"`
+	Admin   bool      `json:"admin"`
+	Created time.Time `json:"created"`
+}
+
+// userStore holds the users and caches the passwords which have been verified, so that only the first
+// request of each user pays for the bcrypt comparison
+type userStore struct {
+	file     string
+	mux      sync.RWMutex
+	users    map[string]*userRecord
+	verified map[string][sha256.Size]byte
+}
+
+// load reads the stored users
+func (u *userStore) load() error {
+	if u.file == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(u.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to read user file")
+	}
+	if err = json.Unmarshal(b, &u.users); err != nil {
+		return errors.Wrap(err, "unable to parse user file")
+	}
+	return nil
+}
+
+// save writes the users to the file, replacing it atomically
+func (u *userStore) save() error {
+	if u.file == "" {
+		return nil
+	}
+	b, err := json.Marshal(u.users)
+	if err != nil {
+		return err
+	}
+	tmp := u.file + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "unable to write user file")
+	}
+	if err = os.Rename(tmp, u.file); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrap(err, "unable to replace user file")
+	}
+	return nil
+}
+
+// verify returns the user if the password is the user's password
+func (u *userStore) verify(name, password string) (*userRecord, bool) {
+	sum := sha256.Sum256([]byte(password))
+	u.mux.RLock()
+	user, ok := u.users[name]
+	cached, isCached := u.verified[name]
+	u.mux.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	if isCached && subtle.ConstantTimeCompare(cached[:], sum[:]) == 1 {
+		return user, true
+	}
+	if bcrypt.CompareHashAndPassword(user.Hash, []byte(password)) != nil {
+		return nil, false
+	}
+	u.mux.Lock()
+	// the user may have been changed while the password was compared
+	if u.users[name] == user {
+		u.verified[name] = sum
+	}
+	u.mux.Unlock()
+	return user, true
+}
+
+// put adds or replaces the user, keeping the password of an existing user if the password is empty
+func (u *userStore) put(user headers.User) (bool, error) {
+	if user.Name == "" || strings.ContainsAny(user.Name, ":/") {
+		return false, errors.Wrap(headers.ErrInvalidBodyJSON, "invalid user name")
+	}
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	old, exists := u.users[user.Name]
+	record := &userRecord{Admin: user.Admin, Created: time.Now().UTC()}
+	if exists {
+		record.Hash, record.Created = old.Hash, old.Created
+	}
+	if user.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return false, errors.Wrap(headers.ErrInvalidBodyJSON, err.Error())
+		}
+		record.Hash = hash
+	} else if !exists {
+		return false, errors.Wrap(headers.ErrInvalidBodyJSON, "password cannot be empty")
+	}
+	u.users[user.Name] = record
+	if err := u.save(); err != nil {
+		if exists {
+			u.users[user.Name] = old
+		} else {
+			delete(u.users, user.Name)
+		}
+		return false, err
+	}
+	delete(u.verified, user.Name)
+	return !exists, nil
+}
+
+// delete removes the user
+func (u *userStore) delete(name string) error {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	old, ok := u.users[name]
+	if !ok {
+		return headers.ErrUserDoesNotExist
+	}
+	delete(u.users, name)
+	if err := u.save(); err != nil {
+		u.users[name] = old
+		return err
+	}
+	delete(u.verified, name)
+	return nil
+}
+
+// list returns the users sorted by name, without their passwords
+func (u *userStore) list() []headers.User {
+	u.mux.RLock()
+	defer u.mux.RUnlock()
+	users := make([]headers.User, 0, len(u.users))
+	for name, record := range u.users {
+		users = append(users, headers.User{Name: name, Admin: record.Admin, Created: record.Created})
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+	return users
+}
+
+// authenticate sets the principal of requests with the basic auth credentials of a user, and rejects other
+// requests with ErrUnauthorized unless a middleware has already authenticated them. Preflight requests are
+// not authenticated
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		name, password, ok := r.BasicAuth()
+		if ok {
+			if _, valid := s.users.verify(name, password); valid {
+				next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), name)))
+				return
+			}
+			s.logger.Warn("invalid user credentials", "user", name)
+		} else if Principal(r.Context()) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="haraqa"`)
+		headers.SetError(w, headers.ErrUnauthorized)
+	})
+}
+
+// isAdmin returns true if the request's principal is an admin user, or is granted admin on every topic by
+// the ACL rules or the scopes of its access token
+func (s *Server) isAdmin(r *http.Request) bool {
+	if s.users != nil {
+		s.users.mux.RLock()
+		user, ok := s.users.users[Principal(r.Context())]
+		s.users.mux.RUnlock()
+		if ok && user.Admin {
+			return true
+		}
+	}
+	return s.allowed(r.Context(), "*", headers.PermissionAdmin)
+}
+
+// RequireAdmin wraps the handler so that it only serves admins, authenticating requests the same way as the
+// server's endpoints. It protects pages served beside the server, such as the debug and admin pages
+func (s *Server) RequireAdmin(next http.Handler) http.Handler {
+	return s.authenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(r) {
+			headers.SetError(w, headers.ErrForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// Protect wraps a handler served beside the server, such as the push subscriptions, so that it is only
+// served to the networks the server permits and, if the server authenticates or authorizes requests, to admins
+func (s *Server) Protect(next http.Handler) http.Handler {
+	if s.users != nil || s.oidc != nil || s.signing != nil || s.authorizes() {
+		next = s.RequireAdmin(next)
+	}
+	return s.FilterNetwork(next)
+}
+
+// Authenticate wraps a handler served beside the server, such as the grpc api, so that it is only served to the
+// networks the server permits and its requests are authenticated the same way as the server's endpoints. The
+// principal of a request is set in its context, so the message methods check the ACL rules against it
+func (s *Server) Authenticate(next http.Handler) http.Handler {
+	return s.FilterNetwork(s.authenticated(next))
+}
+
+// Users returns the users of the user store, without their passwords
+func (s *Server) Users(ctx context.Context) ([]headers.User, error) {
+	if s.users == nil {
+		return nil, errors.New("user store is not enabled")
+	}
+	return s.users.list(), nil
+}
+
+// PutUser adds the user to the user store or replaces the user with the same name. The password of an
+// existing user is kept if the password is empty. It returns true if the user was added
+func (s *Server) PutUser(ctx context.Context, user headers.User) (bool, error) {
+	if s.users == nil {
+		return false, errors.New("user store is not enabled")
+	}
+	created, err := s.users.put(user)
+	if err != nil {
+		return false, err
+	}
+	s.logger.Info("user updated", "user", user.Name, "admin", user.Admin, "created", created)
+	return created, nil
+}
+
+// DeleteUser removes the user from the user store
+func (s *Server) DeleteUser(ctx context.Context, name string) error {
+	if s.users == nil {
+		return errors.New("user store is not enabled")
+	}
+	if err := s.users.delete(name); err != nil {
+		return err
+	}
+	s.logger.Info("user deleted", "user", name)
+	return nil
+}
+
+// HandleUsers handles requests to the /users endpoints. GET /users lists the users, PUT /users/{name} adds
+// or replaces a user and DELETE /users/{name} removes a user. Only admins can manage users, other users can
+// only change their own password
+func (s *Server) HandleUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/users"), "/")
+	admin := s.isAdmin(r)
+	self := name != "" && name == Principal(r.Context()) && r.Method == http.MethodPut
+	if !admin && !self {
+		headers.SetError(w, headers.ErrForbidden)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		users, err := s.Users(r.Context())
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		writeSchemaJSON(w, http.StatusOK, map[string][]headers.User{"users": users})
+	case r.Method == http.MethodPut && name != "":
+		var user headers.User
+		if r.Body == nil || json.NewDecoder(r.Body).Decode(&user) != nil {
+			headers.SetError(w, headers.ErrInvalidBodyJSON)
+			return
+		}
+		user.Name = name
+		if !admin {
+			// users changing their own password keep their role
+			user.Admin = false
+		}
+		created, err := s.PutUser(r.Context(), user)
+		if err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		if created {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && name != "":
+		if err := s.DeleteUser(r.Context(), name); err != nil {
+			headers.SetError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
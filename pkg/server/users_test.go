@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+func TestServer_Users(t *testing.T) {
+	dir, file := ".haraqa-users", ".haraqa-users.json"
+	defer os.RemoveAll(dir)
+	defer os.Remove(file)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithUserStore(file, "root", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	users, err := s.Users(ctx)
+	if err != nil || len(users) != 1 || users[0].Name != "root" || !users[0].Admin || users[0].Password != "" {
+		t.Fatal(users, err)
+	}
+	if created, err := s.PutUser(ctx, headers.User{Name: "app", Password: "pass"}); err != nil || !created {
+		t.Fatal(created, err)
+	}
+	if _, err = s.PutUser(ctx, headers.User{Name: "new"}); errors.Cause(err) != headers.ErrInvalidBodyJSON {
+		t.Fatal(err)
+	}
+	if _, err = s.PutUser(ctx, headers.User{Name: "a:b", Password: "pass"}); errors.Cause(err) != headers.ErrInvalidBodyJSON {
+		t.Fatal(err)
+	}
+	if _, ok := s.users.verify("app", "pass"); !ok {
+		t.Fatal("expected valid password")
+	}
+	if _, ok := s.users.verify("app", "wrong"); ok {
+		t.Fatal("expected invalid password")
+	}
+
+	// an empty password keeps the current password
+	if created, err := s.PutUser(ctx, headers.User{Name: "app", Admin: true}); err != nil || created {
+		t.Fatal(created, err)
+	}
+	if user, ok := s.users.verify("app", "pass"); !ok || !user.Admin {
+		t.Fatal(user, ok)
+	}
+
+	// the users are restored from the file
+	restored := &userStore{file: file, users: make(map[string]*userRecord)}
+	if err = restored.load(); err != nil || len(restored.users) != 2 || !restored.users["app"].Admin {
+		t.Fatal(restored.users, err)
+	}
+
+	if err = s.DeleteUser(ctx, "app"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteUser(ctx, "app"); err != headers.ErrUserDoesNotExist {
+		t.Fatal(err)
+	}
+	if _, ok := s.users.verify("app", "pass"); ok {
+		t.Fatal("expected deleted user")
+	}
+
+	if _, err = (&Server{}).Users(ctx); err == nil {
+		t.Fatal("expected user store is not enabled error")
+	}
+}
+
+func TestServer_HandleUsers(t *testing.T) {
+	dir := ".haraqa-handle-users"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithUserStore("", "root", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	request := func(user, password, method, path string, body interface{}) *httptest.ResponseRecorder {
+		var b []byte
+		if body != nil {
+			b, _ = json.Marshal(body)
+		}
+		r := httptest.NewRequest(method, path, bytes.NewReader(b))
+		if user != "" {
+			r.SetBasicAuth(user, password)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	// requests must authenticate
+	w := request("", "", http.MethodGet, "/topics", nil)
+	if w.Code != http.StatusUnauthorized || headers.ReadErrors(w.Header()) != headers.ErrUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = request("root", "wrong", http.MethodGet, "/topics", nil); w.Code != http.StatusUnauthorized {
+		t.Fatal(w.Code)
+	}
+	if w = request("root", "secret", http.MethodGet, "/topics", nil); w.Code != http.StatusOK {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	// admins manage the users
+	if w = request("root", "secret", http.MethodPut, "/users/app", headers.User{Password: "pass"}); w.Code != http.StatusCreated {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = request("root", "secret", http.MethodPut, "/users/app", headers.User{Password: "pass"}); w.Code != http.StatusNoContent {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = request("app", "pass", http.MethodGet, "/users", nil); w.Code != http.StatusForbidden {
+		t.Fatal(w.Code)
+	}
+	if w = request("app", "pass", http.MethodDelete, "/users/root", nil); w.Code != http.StatusForbidden {
+		t.Fatal(w.Code)
+	}
+
+	// users can change their own password but not their role
+	if w = request("app", "pass", http.MethodPut, "/users/app", headers.User{Password: "changed", Admin: true}); w.Code != http.StatusNoContent {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w = request("app", "changed", http.MethodGet, "/users", nil); w.Code != http.StatusForbidden {
+		t.Fatal(w.Code)
+	}
+
+	w = request("root", "secret", http.MethodGet, "/users", nil)
+	var users struct {
+		Users []headers.User `json:"users"`
+	}
+	if err = json.NewDecoder(w.Body).Decode(&users); err != nil || len(users.Users) != 2 || users.Users[0].Name != "app" || users.Users[0].Admin {
+		t.Fatal(users, err)
+	}
+	if w = request("root", "secret", http.MethodPut, "/users/app", nil); w.Code != http.StatusBadRequest {
+		t.Fatal(w.Code)
+	}
+	if w = request("root", "secret", http.MethodDelete, "/users/app", nil); w.Code != http.StatusNoContent {
+		t.Fatal(w.Code)
+	}
+	if w = request("root", "secret", http.MethodDelete, "/users/app", nil); w.Code != http.StatusNotFound {
+		t.Fatal(w.Code)
+	}
+	if w = request("root", "secret", http.MethodPost, "/users", nil); w.Code != http.StatusMethodNotAllowed {
+		t.Fatal(w.Code)
+	}
+}
+
+func TestServer_AuthenticateMiddleware(t *testing.T) {
+	dir := ".haraqa-authenticate"
+	defer os.RemoveAll(dir)
+	token := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Token") == "valid" {
+				r = r.WithContext(WithPrincipal(r.Context(), "service"))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithUserStore("", "", ""), WithMiddleware(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// requests authenticated by a middleware do not need credentials
+	r := httptest.NewRequest(http.MethodGet, "/topics", nil)
+	r.Header.Set("X-Token", "valid")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/topics", nil)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatal(w.Code)
+	}
+}
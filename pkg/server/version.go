@@ -0,0 +1,25 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+// negotiateBatch agrees the batch format of a versioned request, setting the version and features used
+// in the response. Producers must only use supported features, consumers are sent the features they
+// understand. Unversioned requests use the format which predates versioning and are left unchanged
+func negotiateBatch(w http.ResponseWriter, r *http.Request, produce bool) error {
+	version, features, err := headers.ReadBatchVersion(r.Header)
+	if err != nil || version == 0 {
+		return err
+	}
+	version, features, unsupported := headers.NegotiateBatch(version, features)
+	if produce && len(unsupported) > 0 {
+		// tell the producer which features it can use instead
+		headers.SetBatchVersion(version, headers.BatchFeatures, w.Header())
+		return headers.ErrUnsupportedFeature
+	}
+	headers.SetBatchVersion(version, features, w.Header())
+	return nil
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestServer_BatchVersion(t *testing.T) {
+	dir := ".haraqa-batch-version"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.CreateTopic(context.Background(), "versions"); err != nil {
+		t.Fatal(err)
+	}
+
+	produce := func(version string, features ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/topics/versions", bytes.NewBufferString("hello"))
+		r.Header[headers.HeaderSizes] = []string{"5"}
+		if version != "" {
+			r.Header[headers.HeaderBatchVersion] = []string{version}
+			r.Header[headers.HeaderBatchFeatures] = features
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	// unversioned producers are unchanged
+	w := produce("")
+	if w.Code != http.StatusNoContent || len(w.Header()[headers.HeaderBatchVersion]) != 0 {
+		t.Fatal(w.Code, w.Header())
+	}
+	// newer producers are answered with the latest version supported
+	w = produce("5")
+	if w.Code != http.StatusNoContent || w.Header().Get(headers.HeaderBatchVersion) != "1" {
+		t.Fatal(w.Code, w.Header())
+	}
+	// batches using unsupported features are rejected
+	w = produce("2", "keys")
+	if w.Code != http.StatusBadRequest || headers.ReadErrors(w.Header()) != headers.ErrUnsupportedFeature || w.Header().Get(headers.HeaderBatchFeatures) != "timestamps,ids,content-types" {
+		t.Fatal(w.Code, w.Header())
+	}
+	w = produce("latest")
+	if w.Code != http.StatusBadRequest || headers.ReadErrors(w.Header()) != headers.ErrInvalidBatchVersion {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	// consumers are told which of the features they understand are supported
+	r := httptest.NewRequest(http.MethodGet, "/topics/versions?id=0", nil)
+	headers.SetBatchVersion(1, []string{headers.FeatureTimestamps, "keys"}, r.Header)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "hellohello" || w.Header().Get(headers.HeaderBatchVersion) != "1" || w.Header().Get(headers.HeaderBatchFeatures) != headers.FeatureTimestamps {
+		t.Fatal(w.Code, w.Header(), w.Body.String())
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// watchSuffix ends the path of a watch request, /topics/{topic}/watch
+const watchSuffix = "/watch"
+
+// WatchEvent is the data of an offset event sent to watchers of a topic
+type WatchEvent struct {
+	Topic     string `json:"topic"`
+	MaxOffset int64  `json:"maxOffset"`
+}
+
+// isWatch returns true for GET requests to /topics/{topic}/watch which accept server-sent events. Other
+// requests for the path consume from a topic ending in /watch
+func isWatch(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, watchSuffix) &&
+		strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// HandleWatch handles GET requests to /topics/{topic}/watch. It sends a server-sent offset event with the
+// topic's latest offset when the request is made and each time the offset advances, so that consumers can
+// wait for messages instead of polling. Event ids are the latest offset, a reconnecting client's
+// Last-Event-ID skips offsets it has already seen. Comments are sent as heartbeats while the topic is idle
+func (s *Server) HandleWatch(w http.ResponseWriter, r *http.Request) {
+	topic, err := cleanTopic(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/topics/"), watchSuffix))
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		headers.SetError(w, errors.New("streaming is not supported by the response writer"))
+		return
+	}
+	// wait on the topic before inspecting it so that messages produced in between are not missed
+	produced := s.signals.wait(topic)
+	info, err := s.InspectTopic(r.Context(), topic)
+	if err != nil {
+		headers.SetError(w, err)
+		return
+	}
+	last := info.MinOffset - 2
+	if id, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+		last = id
+	}
+
+	h := w.Header()
+	h[headers.ContentType] = []string{"text/event-stream"}
+	h["Cache-Control"] = []string{"no-cache"}
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(s.followHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		if info.MaxOffset > last {
+			b, _ := json.Marshal(WatchEvent{Topic: topic, MaxOffset: info.MaxOffset})
+			if _, err = w.Write([]byte("id: " + strconv.FormatInt(info.MaxOffset, 10) + "\nevent: offset\ndata: " + string(b) + "\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+			last = info.MaxOffset
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		case <-heartbeat.C:
+			if _, err = w.Write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-produced:
+		}
+
+		produced = s.signals.wait(topic)
+		if info, err = s.InspectTopic(r.Context(), topic); err != nil {
+			if r.Context().Err() == nil {
+				b, _ := json.Marshal(headers.ErrorBody{Code: headers.Code(err), Error: err.Error()})
+				_, _ = w.Write([]byte("event: error\ndata: " + string(b) + "\n\n"))
+			}
+			return
+		}
+	}
+}
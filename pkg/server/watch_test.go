@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestServer_HandleWatch(t *testing.T) {
+	dir := ".haraqa-watch"
+	defer os.RemoveAll(dir)
+	s, err := NewServer(WithFileQueue([]string{dir}, true, 5000), WithFollowHeartbeat(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	ctx := context.Background()
+	if err = s.CreateTopic(ctx, "watched"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(ctx, "watched", []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+
+	watch := func(topic, lastID string) (*http.Response, *bufio.Reader) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/topics/"+topic+"/watch", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, bufio.NewReader(resp.Body)
+	}
+	// readEvent reads the next event, skipping heartbeats
+	readEvent := func(r *bufio.Reader) string {
+		t.Helper()
+		var event []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(event, err)
+			}
+			switch {
+			case line == "\n" && len(event) > 0:
+				return strings.Join(event, "")
+			case line == "\n", strings.HasPrefix(line, ":"):
+			default:
+				event = append(event, line)
+			}
+		}
+	}
+
+	// the current offset is sent on connect, then each time it advances
+	resp, r := watch("watched", "")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(headers.ContentType) != "text/event-stream" {
+		t.Fatal(resp.StatusCode, resp.Header)
+	}
+	if event := readEvent(r); event != "id: 0\nevent: offset\ndata: {\"topic\":\"watched\",\"maxOffset\":0}\n" {
+		t.Fatal(event)
+	}
+	if err = s.ProduceMsgs(ctx, "watched", []byte("two"), []byte("three")); err != nil {
+		t.Fatal(err)
+	}
+	if event := readEvent(r); event != "id: 2\nevent: offset\ndata: {\"topic\":\"watched\",\"maxOffset\":2}\n" {
+		t.Fatal(event)
+	}
+
+	// a deleted topic ends the stream with an error event
+	if err = s.DeleteTopic(ctx, "watched"); err != nil {
+		t.Fatal(err)
+	}
+	if event := readEvent(r); !strings.HasPrefix(event, "event: error\ndata: {\"code\":\"topic_does_not_exist\"") {
+		t.Fatal(event)
+	}
+
+	// reconnecting clients skip offsets they have seen
+	if err = s.CreateTopic(ctx, "watched"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ProduceMsgs(ctx, "watched", []byte("one"), []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	resp2, r2 := watch("watched", "1")
+	defer resp2.Body.Close()
+	if err = s.ProduceMsgs(ctx, "watched", []byte("three")); err != nil {
+		t.Fatal(err)
+	}
+	if event := readEvent(r2); !strings.HasPrefix(event, "id: 2\n") {
+		t.Fatal(event)
+	}
+
+	// missing topics are rejected before the stream starts
+	resp3, _ := watch("missing", "")
+	defer resp3.Body.Close()
+	if resp3.StatusCode != http.StatusPreconditionFailed {
+		t.Fatal(resp3.StatusCode)
+	}
+
+	// without the event stream accept header the path consumes a topic ending in /watch
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/topics/watched/watch?id=0", nil))
+	if w.Code != http.StatusPreconditionFailed || headers.ReadErrors(w.Header()) != headers.ErrTopicDoesNotExist {
+		t.Fatal(w.Code, w.Header())
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+// Webhook headers sent with each event
+const (
+	HeaderWebhookEvent     = "X-Haraqa-Event"
+	HeaderWebhookSignature = "X-Haraqa-Signature"
+)
+
+// Webhook event types
+const (
+	WebhookTopicCreated   = "topic.created"
+	WebhookTopicDeleted   = "topic.deleted"
+	WebhookTopicTruncated = "topic.truncated"
+)
+
+// WebhookEvent is the json body posted to webhook urls
+type WebhookEvent struct {
+	Type      string    `json:"type"`
+	Topic     string    `json:"topic"`
+	Time      time.Time `json:"time"`
+	MinOffset *int64    `json:"minOffset,omitempty"`
+	MaxOffset *int64    `json:"maxOffset,omitempty"`
+}
+
+// WithWebhooks posts topic creation, deletion and truncation events to each of the urls.
+// Failed deliveries are retried with exponential backoff. If a secret is given the body is signed
+// using HMAC-SHA256 and the hex encoded signature sent in the X-Haraqa-Signature header as sha256=<signature>
+func WithWebhooks(urls []string, secret string) Option {
+	return func(s *Server) error {
+		if len(urls) == 0 {
+			return errors.New("at least one webhook url must be given")
+		}
+		for _, u := range urls {
+			parsed, err := url.Parse(u)
+			if err != nil {
+				return errors.Wrapf(err, "invalid webhook url %q", u)
+			}
+			if parsed.Scheme != "http" && parsed.Scheme != "https" {
+				return errors.Errorf("invalid webhook url %q, scheme must be http or https", u)
+			}
+		}
+		s.webhooks = &webhooks{
+			urls:    urls,
+			secret:  []byte(secret),
+			client:  &http.Client{Timeout: 10 * time.Second},
+			events:  make(chan WebhookEvent, 1024),
+			retries: 5,
+			backoff: time.Second,
+		}
+		return WithHooks(Hooks{
+			OnTopicCreate: func(topic string) {
+				s.queueWebhook(WebhookEvent{Type: WebhookTopicCreated, Topic: topic})
+			},
+			OnTopicDelete: func(topic string) {
+				s.queueWebhook(WebhookEvent{Type: WebhookTopicDeleted, Topic: topic})
+			},
+			OnTopicTruncate: func(topic string, _ headers.ModifyRequest, info *headers.TopicInfo) {
+				event := WebhookEvent{Type: WebhookTopicTruncated, Topic: topic}
+				if info != nil {
+					event.MinOffset, event.MaxOffset = &info.MinOffset, &info.MaxOffset
+				}
+				s.queueWebhook(event)
+			},
+		})(s)
+	}
+}
+
+// SignWebhook returns the value of the X-Haraqa-Signature header for the body signed with the secret
+func SignWebhook(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook returns true if the signature is valid for the body and secret. It can be used by
+// webhook receivers to authenticate events
+func VerifyWebhook(secret, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhook(secret, body)), []byte(signature))
+}
+
+type webhooks struct {
+	urls    []string
+	secret  []byte
+	client  *http.Client
+	events  chan WebhookEvent
+	retries int
+	backoff time.Duration
+}
+
+// queueWebhook adds the event to the delivery queue, dropping it if the queue is full
+func (s *Server) queueWebhook(event WebhookEvent) {
+	event.Time = time.Now().UTC()
+	select {
+	case s.webhooks.events <- event:
+	default:
+		s.logger.Warn("webhook queue full, dropping event", "type", event.Type, "topic", event.Topic)
+	}
+}
+
+// sendWebhooks delivers queued events in order until the server is closed
+func (s *Server) sendWebhooks() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case event := <-s.webhooks.events:
+			body, err := json.Marshal(event)
+			if err != nil {
+				s.logger.Error("unable to encode webhook event", "type", event.Type, "topic", event.Topic, "err", err)
+				continue
+			}
+			for _, u := range s.webhooks.urls {
+				if err := s.deliverWebhook(u, event.Type, body); err != nil {
+					s.logger.Error("unable to deliver webhook", "url", u, "type", event.Type, "topic", event.Topic, "err", err)
+				}
+			}
+		}
+	}
+}
+
+// deliverWebhook posts the body to the url, retrying until a 2xx response is received
+func (s *Server) deliverWebhook(u, eventType string, body []byte) error {
+	var err error
+	backoff := s.webhooks.backoff
+	for attempt := 0; attempt <= s.webhooks.retries; attempt++ {
+		if attempt > 0 {
+			s.logger.Warn("retrying webhook", "url", u, "type", eventType, "attempt", attempt, "err", err)
+			select {
+			case <-s.done:
+				return errors.Wrap(err, "server closed")
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		if err = s.postWebhook(u, eventType, body); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (s *Server) postWebhook(u, eventType string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(headers.ContentType, "application/json")
+	req.Header.Set(HeaderWebhookEvent, eventType)
+	if len(s.webhooks.secret) > 0 {
+		req.Header.Set(HeaderWebhookSignature, SignWebhook(s.webhooks.secret, body))
+	}
+	resp, err := s.webhooks.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
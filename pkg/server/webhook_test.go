@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/haraqa/haraqa/internal/headers"
+)
+
+func TestWithWebhooks(t *testing.T) {
+	for _, urls := range [][]string{nil, {"://bad"}, {"ftp://127.0.0.1"}} {
+		if err := WithWebhooks(urls, "")(&Server{}); err == nil {
+			t.Error("expected invalid webhook error", urls)
+		}
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	secret, body := []byte("secret"), []byte(`{"type":"topic.created"}`)
+	signature := SignWebhook(secret, body)
+	if !VerifyWebhook(secret, body, signature) {
+		t.Error(signature)
+	}
+	if VerifyWebhook([]byte("other"), body, signature) || VerifyWebhook(secret, body, "sha256=00") {
+		t.Error("expected invalid signature")
+	}
+}
+
+func TestServer_Webhooks(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	type received struct {
+		event     WebhookEvent
+		eventType string
+		valid     bool
+	}
+	var attempts int32
+	events := make(chan received, 4)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// fail the first attempt to exercise retries
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		var event WebhookEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Error(err)
+		}
+		events <- received{
+			event:     event,
+			eventType: r.Header.Get(HeaderWebhookEvent),
+			valid:     VerifyWebhook([]byte("secret"), body, r.Header.Get(HeaderWebhookSignature)),
+		}
+	}))
+	defer hook.Close()
+
+	q := NewMockQueue(ctrl)
+	q.EXPECT().RootDir().Times(1).Return("")
+	q.EXPECT().CreateTopic("hooked").Return(nil).Times(1)
+	q.EXPECT().ModifyTopic("hooked", gomock.Any()).Return(&headers.TopicInfo{MinOffset: 2, MaxOffset: 4}, nil).Times(1)
+	q.EXPECT().DeleteTopic("hooked").Return(nil).Times(1)
+	q.EXPECT().Close().Return(nil).Times(1)
+
+	s, err := NewServer(WithQueue(q), WithWebhooks([]string{hook.URL}, "secret"), func(s *Server) error {
+		s.webhooks.backoff = time.Millisecond
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, method := range []string{http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		r, err := http.NewRequest(method, "/topics/hooked", strings.NewReader(`{"truncate":2}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	for _, expected := range []string{WebhookTopicCreated, WebhookTopicTruncated, WebhookTopicDeleted} {
+		select {
+		case got := <-events:
+			if got.event.Type != expected || got.eventType != expected || got.event.Topic != "hooked" || !got.valid {
+				t.Error(got)
+			}
+			if got.event.Time.IsZero() {
+				t.Error("missing event time")
+			}
+			if expected == WebhookTopicTruncated && (got.event.MinOffset == nil || *got.event.MinOffset != 2 || *got.event.MaxOffset != 4) {
+				t.Error(got.event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for", expected)
+		}
+	}
+	if atomic.LoadInt32(&attempts) != 4 {
+		t.Error(attempts)
+	}
+}
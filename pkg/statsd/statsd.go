@@ -0,0 +1,395 @@
+// Package statsd implements the haraqa server Metrics interface by emitting metrics to a StatsD or
+// DogStatsD agent over udp, for deployments which do not scrape prometheus.
+//
+// Metrics are buffered and sent in packets of newline separated lines, either once a packet is full or
+// on each flush interval. Plain StatsD has no tags, so tag values are appended to the metric name instead,
+// e.g. haraqa.topic.size_bytes.orders, while DogStatsD receives them as tags.
+package statsd
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+var _ server.Metrics = &Client{}
+
+// Option represents a optional function argument to NewClient
+type Option func(*Client) error
+
+// WithPrefix sets the prefix of all metric names, defaults to "haraqa."
+func WithPrefix(prefix string) Option {
+	return func(c *Client) error {
+		c.prefix = prefix
+		return nil
+	}
+}
+
+// WithDogStatsD sends tags in the DogStatsD format instead of appending their values to metric names
+func WithDogStatsD(enabled bool) Option {
+	return func(c *Client) error {
+		c.dogstatsd = enabled
+		return nil
+	}
+}
+
+// WithTags adds tags in the key:value format to every metric, they are only sent to DogStatsD
+func WithTags(tags ...string) Option {
+	return func(c *Client) error {
+		for _, tag := range tags {
+			if tag == "" || strings.ContainsAny(tag, "|,#\n") {
+				return errors.Errorf("invalid tag %q", tag)
+			}
+		}
+		c.tags = append(c.tags, tags...)
+		return nil
+	}
+}
+
+// WithFlushInterval sets how often buffered metrics are sent, defaults to 1s
+func WithFlushInterval(interval time.Duration) Option {
+	return func(c *Client) error {
+		if interval <= 0 {
+			return errors.New("invalid interval, value must be greater than 0")
+		}
+		c.flushInterval = interval
+		return nil
+	}
+}
+
+// WithMaxPacketSize sets the largest udp packet sent, defaults to 1432 bytes to fit an ethernet frame
+func WithMaxPacketSize(n int) Option {
+	return func(c *Client) error {
+		if n < 64 {
+			return errors.New("invalid packet size, value must be at least 64")
+		}
+		c.maxPacketSize = n
+		return nil
+	}
+}
+
+// Client sends haraqa metrics to a StatsD agent
+type Client struct {
+	conn          net.Conn
+	prefix        string
+	dogstatsd     bool
+	tags          []string
+	flushInterval time.Duration
+	maxPacketSize int
+
+	mux      sync.Mutex
+	buf      []byte
+	done     chan struct{}
+	isClosed bool
+	wg       sync.WaitGroup
+}
+
+// NewClient creates a client sending metrics to the agent at the udp address, e.g. 127.0.0.1:8125
+func NewClient(addr string, opts ...Option) (*Client, error) {
+	c := &Client{
+		prefix:        "haraqa.",
+		flushInterval: time.Second,
+		maxPacketSize: 1432,
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, errors.Wrap(err, "invalid option")
+		}
+	}
+	var err error
+	c.conn, err = net.Dial("udp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to connect to statsd")
+	}
+	c.buf = make([]byte, 0, c.maxPacketSize)
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(c.flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.Flush()
+			case <-c.done:
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
+// Flush sends any buffered metrics
+func (c *Client) Flush() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.flush()
+}
+
+func (c *Client) flush() {
+	if len(c.buf) == 0 {
+		return
+	}
+	// metrics are best effort, errors such as an unreachable agent are ignored
+	_, _ = c.conn.Write(c.buf)
+	c.buf = c.buf[:0]
+}
+
+// Close sends any buffered metrics and closes the connection
+func (c *Client) Close() error {
+	c.mux.Lock()
+	if c.isClosed {
+		c.mux.Unlock()
+		return nil
+	}
+	c.isClosed = true
+	close(c.done)
+	c.mux.Unlock()
+	c.wg.Wait()
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.flush()
+	return c.conn.Close()
+}
+
+// tag is a tag of a single metric
+type tag struct {
+	key, value string
+}
+
+// send buffers a metric line, flushing first if the line does not fit in the current packet
+func (c *Client) send(name, value, typ string, tags ...tag) {
+	line := make([]byte, 0, 64)
+	line = append(line, c.prefix...)
+	line = append(line, name...)
+	if !c.dogstatsd {
+		for _, t := range tags {
+			line = append(line, '.')
+			line = appendSanitized(line, t.value)
+		}
+	}
+	line = append(line, ':')
+	line = append(line, value...)
+	line = append(line, '|')
+	line = append(line, typ...)
+	if c.dogstatsd && len(tags)+len(c.tags) > 0 {
+		line = append(line, "|#"...)
+		for i, t := range c.tags {
+			if i > 0 {
+				line = append(line, ',')
+			}
+			line = append(line, t...)
+		}
+		for i, t := range tags {
+			if i > 0 || len(c.tags) > 0 {
+				line = append(line, ',')
+			}
+			line = append(line, t.key...)
+			line = append(line, ':')
+			line = appendTagValue(line, t.value)
+		}
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.isClosed {
+		return
+	}
+	if len(c.buf) > 0 && len(c.buf)+1+len(line) > c.maxPacketSize {
+		c.flush()
+	}
+	if len(c.buf) > 0 {
+		c.buf = append(c.buf, '\n')
+	}
+	c.buf = append(c.buf, line...)
+	if len(c.buf) >= c.maxPacketSize {
+		c.flush()
+	}
+}
+
+// appendSanitized appends the value, replacing characters which are not safe in names and tags
+func appendSanitized(b []byte, value string) []byte {
+	if value == "" {
+		return append(b, "none"...)
+	}
+	for i := 0; i < len(value); i++ {
+		switch ch := value[i]; {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9', ch == '_', ch == '-':
+			b = append(b, ch)
+		default:
+			b = append(b, '_')
+		}
+	}
+	return b
+}
+
+// appendTagValue appends a DogStatsD tag value, replacing the characters which delimit tags
+func appendTagValue(b []byte, value string) []byte {
+	if value == "" {
+		return append(b, "none"...)
+	}
+	for i := 0; i < len(value); i++ {
+		switch ch := value[i]; ch {
+		case '|', ',', '#', ' ', '\n':
+			b = append(b, '_')
+		default:
+			b = append(b, ch)
+		}
+	}
+	return b
+}
+
+func (c *Client) count(name string, n int64, tags ...tag) {
+	c.send(name, strconv.FormatInt(n, 10), "c", tags...)
+}
+
+// gauge sets the gauge, negative values are sent as a reset followed by a decrement as a leading
+// sign would otherwise change the gauge by the value
+func (c *Client) gauge(name string, v int64, tags ...tag) {
+	if v < 0 {
+		c.send(name, "0", "g", tags...)
+	}
+	c.send(name, strconv.FormatInt(v, 10), "g", tags...)
+}
+
+// ProduceMsgs counts the produced messages and records the batch size
+func (c *Client) ProduceMsgs(n int) {
+	c.count("messages.produced", int64(n))
+	c.send("produce.batch_size", strconv.Itoa(n), "h")
+}
+
+// ConsumeMsgs counts the consumed messages and records the batch size
+func (c *Client) ConsumeMsgs(n int) {
+	c.count("messages.consumed", int64(n))
+	c.send("consume.batch_size", strconv.Itoa(n), "h")
+}
+
+// ProduceBytes counts the produced bytes
+func (c *Client) ProduceBytes(n int64) {
+	c.count("bytes.produced", n)
+}
+
+// ConsumeBytes counts the consumed bytes
+func (c *Client) ConsumeBytes(n int64) {
+	c.count("bytes.consumed", n)
+}
+
+// TopicProduceBytes counts the bytes produced to the topic
+func (c *Client) TopicProduceBytes(topic string, n int64) {
+	c.count("topic.bytes.produced", n, tag{"topic", topic})
+}
+
+// TopicConsumeBytes counts the bytes consumed from the topic
+func (c *Client) TopicConsumeBytes(topic string, n int64) {
+	c.count("topic.bytes.consumed", n, tag{"topic", topic})
+}
+
+// QueueLatency times the queue operation
+func (c *Client) QueueLatency(op string, d time.Duration) {
+	c.send("queue.duration", strconv.FormatFloat(d.Seconds()*1000, 'f', 3, 64), "ms", tag{"op", op})
+}
+
+// QueueError counts a failed queue operation by its error code
+func (c *Client) QueueError(op, code string) {
+	c.count("queue.errors", 1, tag{"op", op}, tag{"code", code})
+}
+
+// TopicDepth sets the gauge of the number of messages in the topic
+func (c *Client) TopicDepth(topic string, depth int64) {
+	c.gauge("topic.depth", depth, tag{"topic", topic})
+}
+
+// DiskUsage sets the disk gauges of the queue directory
+func (c *Client) DiskUsage(dir string, total, free int64) {
+	c.gauge("disk.total_bytes", total, tag{"dir", dir})
+	c.gauge("disk.free_bytes", free, tag{"dir", dir})
+}
+
+// TopicDiskUsage sets the topic size gauge
+func (c *Client) TopicDiskUsage(topic string, size int64) {
+	c.gauge("topic.size_bytes", size, tag{"topic", topic})
+}
+
+// ConsumerLag sets the lag gauge of the consumer group
+func (c *Client) ConsumerLag(group, topic string, lag int64) {
+	c.gauge("consumer_group.lag", lag, tag{"group", group}, tag{"topic", topic})
+}
+
+// SlowRequest counts a slow request
+func (c *Client) SlowRequest(method string) {
+	c.count("requests.slow", 1, tag{"method", method})
+}
+
+// FileCache counts the file cache results
+func (c *Client) FileCache(cache string, hits, misses, evictions int64) {
+	c.count("file_cache", hits, tag{"cache", cache}, tag{"result", "hit"})
+	c.count("file_cache", misses, tag{"cache", cache}, tag{"result", "miss"})
+	c.count("file_cache", evictions, tag{"cache", cache}, tag{"result", "eviction"})
+}
+
+// OpenFiles sets the open files gauge
+func (c *Client) OpenFiles(n int64) {
+	c.gauge("open_files", n)
+}
+
+// FileRejections counts the queue file opens rejected by the open file limit
+func (c *Client) FileRejections(n int64) {
+	c.count("open_files.rejected", n)
+}
+
+// ShedRequest counts a request rejected by the concurrency limit of its operation
+func (c *Client) ShedRequest(op string) {
+	c.count("requests.shed", 1, tag{"op", op})
+}
+
+// JanitorTask counts the items removed by the janitor task and times it
+func (c *Client) JanitorTask(task string, removed int64, d time.Duration) {
+	c.count("janitor.removed", removed, tag{"task", task})
+	c.send("janitor.duration", strconv.FormatFloat(d.Seconds()*1000, 'f', 3, 64), "ms", tag{"task", task})
+}
+
+// ReadOnly sets the read only gauge to 1 while writes are disabled because the disk is full
+func (c *Client) ReadOnly(readOnly bool) {
+	var v int64
+	if readOnly {
+		v = 1
+	}
+	c.gauge("read_only", v)
+}
+
+// Middleware counts requests and times their duration by method and status code
+func (c *Client) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		tags := []tag{{"method", strings.ToLower(r.Method)}, {"code", strconv.Itoa(sw.status)}}
+		c.count("requests", 1, tags...)
+		c.send("request.duration", strconv.FormatFloat(time.Since(start).Seconds()*1000, 'f', 3, 64), "ms", tags...)
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
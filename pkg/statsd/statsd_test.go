@@ -0,0 +1,168 @@
+package statsd
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listen returns a udp connection receiving metrics and a function reading the next packet
+func listen(t *testing.T) (string, func() string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn.LocalAddr().String(), func() string {
+		buf := make([]byte, 65536)
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	for _, opt := range []Option{WithTags("a|b"), WithTags(""), WithFlushInterval(0), WithMaxPacketSize(10)} {
+		if _, err := NewClient("127.0.0.1:8125", opt); err == nil {
+			t.Error("expected invalid option error")
+		}
+	}
+	if _, err := NewClient("invalid address"); err == nil {
+		t.Error("expected invalid address error")
+	}
+}
+
+func TestClient_StatsD(t *testing.T) {
+	addr, read := listen(t)
+	c, err := NewClient(addr, WithPrefix("hq."), WithFlushInterval(time.Hour), WithTags("env:test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ProduceMsgs(3)
+	c.ConsumeMsgs(2)
+	c.ProduceBytes(30)
+	c.ConsumeBytes(20)
+	c.TopicProduceBytes("orders/eu", 30)
+	c.TopicConsumeBytes("orders/eu", 20)
+	c.QueueLatency("Produce", 1500*time.Microsecond)
+	c.QueueError("Consume", "topic_does_not_exist")
+	c.TopicDepth("orders", 7)
+	c.DiskUsage("/data/vol1", 100, 40)
+	c.TopicDiskUsage("orders/eu", 10)
+	c.ConsumerLag("", "orders", -2)
+	c.SlowRequest("GET")
+	c.FileCache("consume", 1, 2, 0)
+	c.OpenFiles(5)
+	c.FileRejections(1)
+	c.ShedRequest("produce")
+	c.JanitorTask("retention", 12, 2*time.Millisecond)
+	c.ReadOnly(true)
+	c.ReadOnly(false)
+	c.Flush()
+
+	expected := strings.Join([]string{
+		"hq.messages.produced:3|c",
+		"hq.produce.batch_size:3|h",
+		"hq.messages.consumed:2|c",
+		"hq.consume.batch_size:2|h",
+		"hq.bytes.produced:30|c",
+		"hq.bytes.consumed:20|c",
+		"hq.topic.bytes.produced.orders_eu:30|c",
+		"hq.topic.bytes.consumed.orders_eu:20|c",
+		"hq.queue.duration.Produce:1.500|ms",
+		"hq.queue.errors.Consume.topic_does_not_exist:1|c",
+		"hq.topic.depth.orders:7|g",
+		"hq.disk.total_bytes._data_vol1:100|g",
+		"hq.disk.free_bytes._data_vol1:40|g",
+		"hq.topic.size_bytes.orders_eu:10|g",
+		"hq.consumer_group.lag.none.orders:0|g",
+		"hq.consumer_group.lag.none.orders:-2|g",
+		"hq.requests.slow.GET:1|c",
+		"hq.file_cache.consume.hit:1|c",
+		"hq.file_cache.consume.miss:2|c",
+		"hq.file_cache.consume.eviction:0|c",
+		"hq.open_files:5|g",
+		"hq.open_files.rejected:1|c",
+		"hq.requests.shed.produce:1|c",
+		"hq.janitor.removed.retention:12|c",
+		"hq.janitor.duration.retention:2.000|ms",
+		"hq.read_only:1|g",
+		"hq.read_only:0|g",
+	}, "\n")
+	if packet := read(); packet != expected {
+		t.Fatalf("\n%s\n%s", packet, expected)
+	}
+
+	// buffered metrics are sent on close, later metrics are dropped
+	c.OpenFiles(1)
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c.OpenFiles(2)
+	if packet := read(); packet != "hq.open_files:1|g" {
+		t.Fatal(packet)
+	}
+}
+
+func TestClient_DogStatsD(t *testing.T) {
+	addr, read := listen(t)
+	c, err := NewClient(addr, WithDogStatsD(true), WithTags("env:test", "region:eu"), WithMaxPacketSize(100), WithFlushInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.ConsumerLag("billing", "orders/eu", 7)
+	if packet := read(); packet != "haraqa.consumer_group.lag:7|g|#env:test,region:eu,group:billing,topic:orders/eu" {
+		t.Fatal(packet)
+	}
+
+	// packets are split before exceeding the max size
+	c.OpenFiles(1)
+	c.OpenFiles(2)
+	c.OpenFiles(3)
+	if packet := read(); packet != "haraqa.open_files:1|g|#env:test,region:eu\nharaqa.open_files:2|g|#env:test,region:eu" {
+		t.Fatal(packet)
+	}
+	if packet := read(); packet != "haraqa.open_files:3|g|#env:test,region:eu" {
+		t.Fatal(packet)
+	}
+}
+
+func TestClient_Middleware(t *testing.T) {
+	addr, read := listen(t)
+	c, err := NewClient(addr, WithDogStatsD(true), WithFlushInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/topics/a", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/topics/a", nil))
+	c.Flush()
+
+	expected := regexp.MustCompile(`^haraqa.requests:1\|c\|#method:get,code:200
+haraqa.request.duration:[0-9.]+\|ms\|#method:get,code:200
+haraqa.requests:1\|c\|#method:post,code:204
+haraqa.request.duration:[0-9.]+\|ms\|#method:post,code:204$`)
+	if packet := read(); !expected.MatchString(packet) {
+		t.Fatal(packet)
+	}
+}
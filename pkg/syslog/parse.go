@@ -0,0 +1,210 @@
+package syslog
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Facility names, indexed by facility code
+var facilities = [...]string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// Severity names, indexed by severity code
+var severities = [...]string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// Message is a parsed syslog message. Fields which are not present in the message are left empty
+type Message struct {
+	Facility       int       `json:"facility"`
+	Severity       int       `json:"severity"`
+	Version        int       `json:"version,omitempty"`
+	Timestamp      time.Time `json:"timestamp"`
+	Hostname       string    `json:"hostname,omitempty"`
+	AppName        string    `json:"appName,omitempty"`
+	ProcID         string    `json:"procId,omitempty"`
+	MsgID          string    `json:"msgId,omitempty"`
+	StructuredData string    `json:"structuredData,omitempty"`
+	Message        string    `json:"message"`
+}
+
+// FacilityName returns the keyword of the facility of the message, e.g. local0
+func (m *Message) FacilityName() string {
+	if m.Facility < 0 || m.Facility >= len(facilities) {
+		return strconv.Itoa(m.Facility)
+	}
+	return facilities[m.Facility]
+}
+
+// SeverityName returns the keyword of the severity of the message, e.g. warning
+func (m *Message) SeverityName() string {
+	if m.Severity < 0 || m.Severity >= len(severities) {
+		return strconv.Itoa(m.Severity)
+	}
+	return severities[m.Severity]
+}
+
+// Parse parses an RFC 5424 message, falling back to the BSD format of RFC 3164. Parsing is lenient,
+// anything which cannot be parsed is kept in the message text and a message without a valid priority
+// is given the user facility and notice severity
+func Parse(b []byte) *Message {
+	m := &Message{Facility: 1, Severity: 5}
+	pri, rest, ok := parsePriority(b)
+	if !ok {
+		m.Message = toString(b)
+		return m
+	}
+	m.Facility, m.Severity = pri/8, pri%8
+	if len(rest) > 2 && rest[0] == '1' && rest[1] == ' ' && parseRFC5424(m, rest[2:]) {
+		m.Version = 1
+		return m
+	}
+	parseRFC3164(m, rest)
+	return m
+}
+
+// parsePriority reads the <PRI> prefix of a message
+func parsePriority(b []byte) (int, []byte, bool) {
+	if len(b) < 3 || b[0] != '<' {
+		return 0, b, false
+	}
+	end := bytes.IndexByte(b, '>')
+	if end < 2 || end > 4 {
+		return 0, b, false
+	}
+	pri, err := strconv.Atoi(string(b[1:end]))
+	if err != nil || pri < 0 || pri > 191 {
+		return 0, b, false
+	}
+	return pri, b[end+1:], true
+}
+
+// parseRFC5424 parses the header fields following the version, returning false if they are malformed
+func parseRFC5424(m *Message, b []byte) bool {
+	var fields [5]string
+	for i := range fields {
+		end := bytes.IndexByte(b, ' ')
+		if end < 1 {
+			return false
+		}
+		if field := string(b[:end]); field != "-" {
+			fields[i] = field
+		}
+		b = b[end+1:]
+	}
+	var ts time.Time
+	if fields[0] != "" {
+		var err error
+		if ts, err = time.Parse(time.RFC3339Nano, fields[0]); err != nil {
+			return false
+		}
+	}
+	sd, rest, ok := parseStructuredData(b)
+	if !ok {
+		return false
+	}
+	m.Timestamp, m.StructuredData = ts, sd
+	m.Hostname, m.AppName, m.ProcID, m.MsgID = fields[1], fields[2], fields[3], fields[4]
+	if len(rest) > 0 && rest[0] == ' ' {
+		rest = rest[1:]
+	}
+	m.Message = toString(bytes.TrimPrefix(rest, []byte("\xef\xbb\xbf")))
+	return true
+}
+
+// parseStructuredData reads the structured data elements, taking escaped characters within quoted
+// parameter values into account
+func parseStructuredData(b []byte) (string, []byte, bool) {
+	if len(b) > 0 && b[0] == '-' {
+		return "", b[1:], true
+	}
+	i := 0
+	for i < len(b) && b[i] == '[' {
+		end := elementEnd(b[i:])
+		if end < 0 {
+			return "", b, false
+		}
+		i += end + 1
+	}
+	if i == 0 {
+		return "", b, false
+	}
+	return string(b[:i]), b[i:], true
+}
+
+// elementEnd returns the index of the ']' closing the structured data element at the start of b
+func elementEnd(b []byte) int {
+	quoted := false
+	for i := 1; i < len(b); i++ {
+		switch {
+		case quoted && b[i] == '\\':
+			i++
+		case b[i] == '"':
+			quoted = !quoted
+		case !quoted && b[i] == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+// parseRFC3164 parses "Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG", keeping anything it does not recognize
+// in the message text. The year is assumed to be the current one
+func parseRFC3164(m *Message, b []byte) {
+	m.Message = toString(b)
+	if len(b) < len(time.Stamp)+1 || b[len(time.Stamp)] != ' ' {
+		return
+	}
+	ts, err := time.ParseInLocation(time.Stamp, string(b[:len(time.Stamp)]), time.Local)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	m.Timestamp = ts.AddDate(now.Year(), 0, 0)
+	if m.Timestamp.After(now.Add(24 * time.Hour)) {
+		// messages from the end of last year
+		m.Timestamp = m.Timestamp.AddDate(-1, 0, 0)
+	}
+	b = b[len(time.Stamp)+1:]
+	m.Message = toString(b)
+
+	end := bytes.IndexByte(b, ' ')
+	if end < 1 {
+		return
+	}
+	m.Hostname = string(b[:end])
+	b = b[end+1:]
+	m.Message = toString(b)
+
+	// the tag is alphanumeric and ends at the first ':', '[' or space
+	end = bytes.IndexAny(b, ":[ ")
+	if end < 1 || b[end] == ' ' {
+		return
+	}
+	tag, rest := string(b[:end]), b[end:]
+	if rest[0] == '[' {
+		end = bytes.IndexByte(rest, ']')
+		if end < 0 {
+			return
+		}
+		m.ProcID = string(rest[1:end])
+		rest = rest[end+1:]
+	}
+	if len(rest) == 0 || rest[0] != ':' {
+		m.ProcID = ""
+		return
+	}
+	m.AppName = tag
+	m.Message = toString(bytes.TrimPrefix(rest[1:], []byte(" ")))
+}
+
+// toString converts the text to a string, replacing invalid utf8 so the message can be encoded as json
+func toString(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	return string(bytes.ToValidUTF8(b, []byte("�")))
+}
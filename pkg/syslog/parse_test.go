@@ -0,0 +1,66 @@
+package syslog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Now()
+	stamp := time.Date(now.Year(), time.October, 11, 22, 14, 15, 0, time.Local)
+	if stamp.After(now.Add(24 * time.Hour)) {
+		stamp = stamp.AddDate(-1, 0, 0)
+	}
+
+	tests := map[string]Message{
+		`<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - BOM'su root' failed`: {
+			Facility: 4, Severity: 2, Version: 1, Timestamp: time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC),
+			Hostname: "mymachine.example.com", AppName: "su", MsgID: "ID47", Message: "BOM'su root' failed",
+		},
+		"<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - \xef\xbb\xbf%% It's time": {
+			Facility: 20, Severity: 5, Version: 1, Timestamp: time.Date(2003, 8, 24, 5, 14, 15, 3000, time.FixedZone("", -7*3600)),
+			Hostname: "192.0.2.1", AppName: "myproc", ProcID: "8710", Message: "%% It's time",
+		},
+		`<165>1 2003-10-11T22:14:15.003Z host evntslog - ID47 [exampleSDID@32473 iut="3" eventID="1011"][examplePriority@32473 class="hi\]gh"] message`: {
+			Facility: 20, Severity: 5, Version: 1, Timestamp: time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC),
+			Hostname: "host", AppName: "evntslog", MsgID: "ID47",
+			StructuredData: `[exampleSDID@32473 iut="3" eventID="1011"][examplePriority@32473 class="hi\]gh"]`, Message: "message",
+		},
+		`<13>1 - - - - - -`: {Facility: 1, Severity: 5, Version: 1},
+		`<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed`: {
+			Facility: 4, Severity: 2, Timestamp: stamp, Hostname: "mymachine", AppName: "su", ProcID: "123", Message: "'su root' failed",
+		},
+		`<34>Oct 11 22:14:15 mymachine su: failed`: {
+			Facility: 4, Severity: 2, Timestamp: stamp, Hostname: "mymachine", AppName: "su", Message: "failed",
+		},
+		`<34>Oct 11 22:14:15 mymachine just a message`: {
+			Facility: 4, Severity: 2, Timestamp: stamp, Hostname: "mymachine", Message: "just a message",
+		},
+		`<34>1 not-a-time host app - - - message`:               {Facility: 4, Severity: 2, Message: "1 not-a-time host app - - - message"},
+		`<34>1 2003-10-11T22:14:15Z host app - - [unterminated`: {Facility: 4, Severity: 2, Message: "1 2003-10-11T22:14:15Z host app - - [unterminated"},
+		`<192>message`:       {Facility: 1, Severity: 5, Message: "<192>message"},
+		`no priority`:        {Facility: 1, Severity: 5, Message: "no priority"},
+		"<7>invalid \xff":    {Facility: 0, Severity: 7, Message: "invalid \ufffd"},
+		`<0>Oct 11 22:14:15`: {Facility: 0, Severity: 0, Message: "Oct 11 22:14:15"},
+	}
+	for line, expected := range tests {
+		m := Parse([]byte(line))
+		if !m.Timestamp.Equal(expected.Timestamp) {
+			t.Errorf("%q: timestamp %v != %v", line, m.Timestamp, expected.Timestamp)
+		}
+		m.Timestamp, expected.Timestamp = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(*m, expected) {
+			t.Errorf("%q:\n%+v\n%+v", line, *m, expected)
+		}
+	}
+
+	m := &Message{Facility: 23, Severity: 4}
+	if m.FacilityName() != "local7" || m.SeverityName() != "warning" {
+		t.Error(m.FacilityName(), m.SeverityName())
+	}
+	m = &Message{Facility: 24, Severity: -1}
+	if m.FacilityName() != "24" || m.SeverityName() != "-1" {
+		t.Error(m.FacilityName(), m.SeverityName())
+	}
+}
@@ -0,0 +1,427 @@
+// Package syslog implements a syslog listener on top of a haraqa server, buffering log lines from udp and
+// tcp senders in haraqa topics until they are consumed by analysis tools.
+//
+// Messages in the RFC 5424 and RFC 3164 formats are accepted, udp datagrams hold a single message and tcp
+// connections may use either octet counting or newline delimited framing as described in RFC 6587.
+// Messages are written to topics in batches, either as received or encoded as json.
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/haraqa/haraqa/pkg/server"
+	"github.com/pkg/errors"
+)
+
+// maxMessageSize is the largest message accepted over tcp
+const maxMessageSize = 64 * 1024
+
+// Queue is the subset of the haraqa server used to store syslog messages, it is implemented by *server.Server
+type Queue interface {
+	CreateTopic(ctx context.Context, topic string) error
+	ProduceMsgs(ctx context.Context, topic string, msgs ...[]byte) error
+}
+
+var _ Queue = &server.Server{}
+
+// Option represents a optional function argument to NewListener
+type Option func(*Listener) error
+
+// WithTopic sets the topic messages are written to, defaults to syslog. The topic may contain the
+// placeholders {facility}, {severity}, {hostname} and {app} to route messages by their fields, e.g. logs/{app}
+func WithTopic(topic string) Option {
+	return func(l *Listener) error {
+		if topic == "" {
+			return errors.New("topic cannot be empty")
+		}
+		l.topic = topic
+		return nil
+	}
+}
+
+// WithJSON writes messages as json encoded Message values instead of the raw lines
+func WithJSON(enabled bool) Option {
+	return func(l *Listener) error {
+		l.json = enabled
+		return nil
+	}
+}
+
+// WithAutoCreateTopics creates topics which do not exist when messages are first written to them
+func WithAutoCreateTopics(autoCreate bool) Option {
+	return func(l *Listener) error {
+		l.autoCreate = autoCreate
+		return nil
+	}
+}
+
+// WithBatchSize sets the number of buffered messages after which they are written, defaults to 500
+func WithBatchSize(n int) Option {
+	return func(l *Listener) error {
+		if n <= 0 {
+			return errors.New("invalid batch size, value must be greater than 0")
+		}
+		l.batchSize = n
+		return nil
+	}
+}
+
+// WithFlushInterval sets how often buffered messages are written, defaults to 100ms
+func WithFlushInterval(interval time.Duration) Option {
+	return func(l *Listener) error {
+		if interval <= 0 {
+			return errors.New("invalid interval, value must be greater than 0")
+		}
+		l.flushInterval = interval
+		return nil
+	}
+}
+
+// WithLogger sets the logger used to report connection and write errors
+func WithLogger(logger server.Logger) Option {
+	return func(l *Listener) error {
+		if logger == nil {
+			return errors.New("logger cannot be nil")
+		}
+		l.logger = logger
+		return nil
+	}
+}
+
+type record struct {
+	topic string
+	msg   []byte
+}
+
+// Listener receives syslog messages and writes them to a haraqa queue
+type Listener struct {
+	q             Queue
+	logger        server.Logger
+	topic         string
+	json          bool
+	autoCreate    bool
+	batchSize     int
+	flushInterval time.Duration
+	records       chan record
+
+	mux       sync.Mutex
+	listeners map[io.Closer]struct{}
+	conns     map[net.Conn]struct{}
+	done      chan struct{}
+	stop      chan struct{}
+	isClosed  bool
+	wg        sync.WaitGroup
+	writer    sync.WaitGroup
+}
+
+// NewListener creates a new syslog listener on top of the queue
+func NewListener(q Queue, opts ...Option) (*Listener, error) {
+	if q == nil {
+		return nil, errors.New("queue cannot be nil")
+	}
+	l := &Listener{
+		q:             q,
+		logger:        noOpLogger{},
+		topic:         "syslog",
+		batchSize:     500,
+		flushInterval: 100 * time.Millisecond,
+		listeners:     make(map[io.Closer]struct{}),
+		conns:         make(map[net.Conn]struct{}),
+		done:          make(chan struct{}),
+		stop:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		if err := opt(l); err != nil {
+			return nil, errors.Wrap(err, "invalid option")
+		}
+	}
+	l.records = make(chan record, l.batchSize)
+	l.writer.Add(1)
+	go func() {
+		defer l.writer.Done()
+		l.write()
+	}()
+	return l, nil
+}
+
+// ListenAndServeUDP listens on the udp address and receives messages until the listener is closed
+func (l *Listener) ListenAndServeUDP(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return l.ServeUDP(conn)
+}
+
+// ServeUDP receives a message per datagram from conn until the listener is closed
+func (l *Listener) ServeUDP(conn net.PacketConn) error {
+	if !l.register(conn) {
+		return errors.New("listener closed")
+	}
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-l.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		l.receive(buf[:n])
+	}
+}
+
+// ListenAndServeTCP listens on the tcp address and receives messages until the listener is closed
+func (l *Listener) ListenAndServeTCP(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return l.ServeTCP(ln)
+}
+
+// ServeTCP accepts connections on ln and receives their messages until the listener is closed
+func (l *Listener) ServeTCP(ln net.Listener) error {
+	if !l.register(ln) {
+		return errors.New("listener closed")
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-l.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		l.mux.Lock()
+		if l.isClosed {
+			l.mux.Unlock()
+			_ = conn.Close()
+			return nil
+		}
+		l.conns[conn] = struct{}{}
+		l.wg.Add(1)
+		l.mux.Unlock()
+		go func() {
+			defer l.wg.Done()
+			l.serveConn(conn)
+		}()
+	}
+}
+
+// register tracks the listener so that it is closed with l, returning false if l is already closed
+func (l *Listener) register(ln io.Closer) bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.isClosed {
+		_ = ln.Close()
+		return false
+	}
+	l.listeners[ln] = struct{}{}
+	return true
+}
+
+// Close stops all listeners, closes any open connections and writes the buffered messages
+func (l *Listener) Close() error {
+	l.mux.Lock()
+	closing := !l.isClosed
+	if closing {
+		close(l.done)
+	}
+	l.isClosed = true
+	var err error
+	for ln := range l.listeners {
+		if e := ln.Close(); e != nil {
+			err = e
+		}
+	}
+	for conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.mux.Unlock()
+	l.wg.Wait()
+	if closing {
+		close(l.stop)
+	}
+	l.writer.Wait()
+	return err
+}
+
+func (l *Listener) serveConn(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+		l.mux.Lock()
+		delete(l.conns, conn)
+		l.mux.Unlock()
+	}()
+
+	r := bufio.NewReaderSize(conn, maxMessageSize)
+	for {
+		msg, err := readFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				l.logger.Debug("syslog connection closed", "remote", conn.RemoteAddr().String(), "err", err)
+			}
+			return
+		}
+		l.receive(msg)
+	}
+}
+
+// readFrame reads a message using octet counting if it starts with a digit, or up to the next newline
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] < '0' || b[0] > '9' {
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			return nil, errors.New("message too long")
+		}
+		if err == io.EOF && len(line) > 0 {
+			err = nil
+		}
+		return line, err
+	}
+
+	length, err := r.ReadString(' ')
+	if err != nil {
+		return nil, errors.New("invalid octet count")
+	}
+	n, err := strconv.Atoi(length[:len(length)-1])
+	if err != nil || n <= 0 || n > maxMessageSize {
+		return nil, errors.Errorf("invalid octet count %q", length)
+	}
+	msg := make([]byte, n)
+	_, err = io.ReadFull(r, msg)
+	return msg, err
+}
+
+// receive parses the message and queues it to be written to its topic
+func (l *Listener) receive(b []byte) {
+	b = bytes.TrimRight(b, "\r\n\x00")
+	if len(b) == 0 {
+		return
+	}
+	m := Parse(b)
+	if m.Timestamp.IsZero() {
+		m.Timestamp = time.Now().UTC()
+	}
+
+	var msg []byte
+	if l.json {
+		var err error
+		if msg, err = json.Marshal(m); err != nil {
+			l.logger.Warn("unable to encode syslog message", "err", err)
+			return
+		}
+	} else {
+		msg = append([]byte(nil), b...)
+	}
+
+	select {
+	case l.records <- record{topic: l.topicOf(m), msg: msg}:
+	case <-l.done:
+	}
+}
+
+// topicOf fills in the placeholders of the topic with the fields of the message
+func (l *Listener) topicOf(m *Message) string {
+	if !strings.Contains(l.topic, "{") {
+		return l.topic
+	}
+	return strings.NewReplacer(
+		"{facility}", m.FacilityName(),
+		"{severity}", m.SeverityName(),
+		"{hostname}", topicPart(m.Hostname),
+		"{app}", topicPart(m.AppName),
+	).Replace(l.topic)
+}
+
+// topicPart makes a message field safe to use as part of a topic name
+func topicPart(s string) string {
+	if s == "" || s == "." || s == ".." {
+		return "unknown"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// write batches queued messages by topic until the listener is closed
+func (l *Listener) write() {
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
+	batches := make(map[string][][]byte)
+	n := 0
+	for {
+		select {
+		case rec := <-l.records:
+			batches[rec.topic] = append(batches[rec.topic], rec.msg)
+			if n++; n >= l.batchSize {
+				l.flush(batches)
+				n = 0
+			}
+		case <-ticker.C:
+			l.flush(batches)
+			n = 0
+		case <-l.stop:
+			for {
+				select {
+				case rec := <-l.records:
+					batches[rec.topic] = append(batches[rec.topic], rec.msg)
+				default:
+					l.flush(batches)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush writes the batches to their topics. Messages which cannot be written are dropped so that a
+// failing topic does not hold back the senders
+func (l *Listener) flush(batches map[string][][]byte) {
+	ctx := context.Background()
+	for topic, msgs := range batches {
+		delete(batches, topic)
+		err := l.q.ProduceMsgs(ctx, topic, msgs...)
+		if errors.Cause(err) == headers.ErrTopicDoesNotExist && l.autoCreate {
+			err = l.q.CreateTopic(ctx, topic)
+			if err == nil || errors.Cause(err) == headers.ErrTopicAlreadyExists {
+				err = l.q.ProduceMsgs(ctx, topic, msgs...)
+			}
+		}
+		if err != nil {
+			l.logger.Warn("unable to write syslog messages", "topic", topic, "count", len(msgs), "err", err)
+		}
+	}
+}
+
+var _ server.Logger = noOpLogger{}
+
+type noOpLogger struct{}
+
+func (noOpLogger) Debug(string, ...interface{}) {}
+func (noOpLogger) Info(string, ...interface{})  {}
+func (noOpLogger) Warn(string, ...interface{})  {}
+func (noOpLogger) Error(string, ...interface{}) {}
@@ -0,0 +1,238 @@
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haraqa/haraqa/internal/headers"
+	"github.com/pkg/errors"
+)
+
+type testQueue struct {
+	mux    sync.Mutex
+	topics map[string][][]byte
+	calls  int
+}
+
+func (q *testQueue) CreateTopic(ctx context.Context, topic string) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if _, ok := q.topics[topic]; ok {
+		return headers.ErrTopicAlreadyExists
+	}
+	q.topics[topic] = [][]byte{}
+	return nil
+}
+
+func (q *testQueue) ProduceMsgs(ctx context.Context, topic string, msgs ...[]byte) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.calls++
+	if _, ok := q.topics[topic]; !ok {
+		return headers.ErrTopicDoesNotExist
+	}
+	q.topics[topic] = append(q.topics[topic], msgs...)
+	return nil
+}
+
+func (q *testQueue) messages(topic string) []string {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	msgs := make([]string, len(q.topics[topic]))
+	for i := range q.topics[topic] {
+		msgs[i] = string(q.topics[topic][i])
+	}
+	return msgs
+}
+
+func (q *testQueue) waitFor(t *testing.T, topic string, n int) []string {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if msgs := q.messages(topic); len(msgs) >= n {
+			return msgs
+		}
+	}
+	t.Fatalf("timed out waiting for %d messages in %q, got %q", n, topic, q.messages(topic))
+	return nil
+}
+
+func TestNewListener(t *testing.T) {
+	if _, err := NewListener(nil); err == nil {
+		t.Error("expected nil queue error")
+	}
+	q := &testQueue{topics: map[string][][]byte{}}
+	for _, opt := range []Option{WithTopic(""), WithBatchSize(0), WithFlushInterval(0), WithLogger(nil)} {
+		if _, err := NewListener(q, opt); err == nil {
+			t.Error("expected invalid option error")
+		}
+	}
+	l, err := NewListener(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.ServeTCP(ln); err == nil {
+		t.Error("expected closed error")
+	}
+}
+
+func TestListener_UDP(t *testing.T) {
+	q := &testQueue{topics: map[string][][]byte{"syslog": {}}}
+	l, err := NewListener(q, WithFlushInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- l.ServeUDP(conn)
+	}()
+
+	c, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, msg := range []string{"<34>1 - host app - - - first\n", "", "<13>second"} {
+		if _, err = c.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs := q.waitFor(t, "syslog", 2)
+	if len(msgs) != 2 || msgs[0] != "<34>1 - host app - - - first" || msgs[1] != "<13>second" {
+		t.Fatalf("%q", msgs)
+	}
+
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListener_TCP(t *testing.T) {
+	q := &testQueue{topics: map[string][][]byte{}}
+	l, err := NewListener(q, WithTopic("logs/{app}/{severity}"), WithJSON(true), WithAutoCreateTopics(true),
+		WithBatchSize(3), WithFlushInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- l.ServeTCP(ln)
+	}()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// newline delimited and octet counted framing
+	frames := "<11>1 2020-01-02T03:04:05Z host web - - - request failed\n" +
+		"34 <14>1 - host web - - - hello\nworld" +
+		"<14>Jan  2 03:04:05 host ../etc: traversal\r\n"
+	if _, err = c.Write([]byte(frames)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the batch is written once full
+	errMsgs := q.waitFor(t, "logs/web/err", 1)
+	var m Message
+	if err = json.Unmarshal([]byte(errMsgs[0]), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.AppName != "web" || m.Message != "request failed" || !m.Timestamp.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Fatalf("%+v", m)
+	}
+	infoMsgs := q.messages("logs/web/info")
+	if len(infoMsgs) != 1 || !strings.Contains(infoMsgs[0], `"message":"hello\nworld"`) || strings.Contains(infoMsgs[0], `"timestamp":"0001`) {
+		t.Fatalf("%q", infoMsgs)
+	}
+	if msgs := q.messages("logs/.._etc/info"); len(msgs) != 1 {
+		t.Fatalf("%q", msgs)
+	}
+
+	// buffered messages are written on close
+	if _, err = c.Write([]byte("<14>1 - host - - - - no app\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if msgs := q.messages("logs/unknown/info"); len(msgs) != 1 {
+		t.Fatalf("%q", msgs)
+	}
+	if _, err = bufio.NewReader(c).ReadByte(); err == nil {
+		t.Fatal("expected connection to be closed")
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	for input, expected := range map[string]string{
+		"5 hello":      "hello",
+		"message\nend": "message\n",
+		"last":         "last",
+		"0 x":          "invalid octet count",
+		"70000 x":      "invalid octet count",
+		"5x hello":     "invalid octet count",
+		"9":            "invalid octet count",
+		"5 hel":        "unexpected EOF",
+		"":             "EOF",
+	} {
+		msg, err := readFrame(bufio.NewReader(strings.NewReader(input)))
+		if err != nil {
+			msg = []byte(errors.Cause(err).Error())
+		}
+		if !strings.HasPrefix(string(msg), expected) {
+			t.Errorf("%q: expected %q, got %q", input, expected, msg)
+		}
+	}
+
+	long := strings.Repeat("a", maxMessageSize+1)
+	if _, err := readFrame(bufio.NewReaderSize(strings.NewReader(long), maxMessageSize)); err == nil || err.Error() != "message too long" {
+		t.Error(err)
+	}
+}
+
+func TestListener_WriteErrors(t *testing.T) {
+	q := &testQueue{topics: map[string][][]byte{}}
+	l, err := NewListener(q, WithFlushInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.receive([]byte("<13>dropped"))
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if q.calls != 1 || len(q.topics) != 0 {
+		t.Fatal(q.calls, q.topics)
+	}
+}
@@ -0,0 +1,273 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxBufferedSpans is the number of finished spans kept in memory between exports, spans ended
+// while the buffer is full are dropped
+const maxBufferedSpans = 2048
+
+// OTLPTracer is a Tracer which exports finished spans in batches to an OpenTelemetry collector
+// using the OTLP/HTTP json encoding
+type OTLPTracer struct {
+	endpoint string
+	service  string
+	client   *http.Client
+
+	mux   sync.Mutex
+	spans []*otlpSpan
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewOTLPTracer creates a tracer which exports spans to the given collector endpoint
+// (e.g. http://127.0.0.1:4318/v1/traces) every interval. Call Close to stop the exporter
+// and flush any remaining spans
+func NewOTLPTracer(endpoint, service string, interval time.Duration) (*OTLPTracer, error) {
+	if endpoint == "" {
+		return nil, errors.New("invalid endpoint: endpoint cannot be empty")
+	}
+	if interval <= 0 {
+		return nil, errors.New("invalid interval, value must be greater than 0")
+	}
+	t := &OTLPTracer{
+		endpoint: endpoint,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		done:     make(chan struct{}),
+	}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.done:
+				return
+			case <-ticker.C:
+				_ = t.Flush()
+			}
+		}
+	}()
+	return t, nil
+}
+
+// Start begins a new span. If the context carries an unsampled trace parent the span is
+// propagated but not recorded
+func (t *OTLPTracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	s := &otlpSpan{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+	}
+	if parent, ok := FromContext(ctx); ok {
+		s.tp.TraceID = parent.TraceID
+		s.tp.Flags = parent.Flags
+		s.parentID = parent.SpanID
+	} else {
+		s.tp.TraceID = NewTraceID()
+		s.tp.Flags = 0x01
+	}
+	s.tp.SpanID = NewSpanID()
+	return ContextWithTraceParent(ctx, s.tp), s
+}
+
+// Flush exports all spans which have ended
+func (t *OTLPTracer) Flush() error {
+	t.mux.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mux.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(t.encode(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "unable to export spans")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("unable to export spans: unexpected status %q", resp.Status)
+	}
+	return nil
+}
+
+// Close stops the background exporter and flushes any remaining spans
+func (t *OTLPTracer) Close() error {
+	select {
+	case <-t.done:
+	default:
+		close(t.done)
+	}
+	t.wg.Wait()
+	return t.Flush()
+}
+
+func (t *OTLPTracer) record(s *otlpSpan) {
+	t.mux.Lock()
+	if len(t.spans) < maxBufferedSpans {
+		t.spans = append(t.spans, s)
+	}
+	t.mux.Unlock()
+}
+
+type otlpSpan struct {
+	tracer   *OTLPTracer
+	tp       TraceParent
+	parentID [8]byte
+	name     string
+	kind     SpanKind
+	start    time.Time
+
+	mux   sync.Mutex
+	end   time.Time
+	attrs []otlpKeyValue
+	err   error
+}
+
+func (s *otlpSpan) TraceParent() TraceParent {
+	return s.tp
+}
+
+func (s *otlpSpan) SetAttribute(key string, value interface{}) {
+	s.mux.Lock()
+	s.attrs = append(s.attrs, otlpKeyValue{Key: key, Value: otlpValue(value)})
+	s.mux.Unlock()
+}
+
+func (s *otlpSpan) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.mux.Lock()
+	s.err = err
+	s.mux.Unlock()
+}
+
+func (s *otlpSpan) End() {
+	s.mux.Lock()
+	if !s.end.IsZero() {
+		s.mux.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mux.Unlock()
+	if s.tp.Sampled() {
+		s.tracer.record(s)
+	}
+}
+
+// json encoding of the OTLP ExportTraceServiceRequest, limited to the fields used here
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope      `json:"scope"`
+		Spans []otlpSpanJSON `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpanJSON struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            *otlpStatus    `json:"status,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	}
+)
+
+func (t *OTLPTracer) encode(spans []*otlpSpan) otlpRequest {
+	encoded := make([]otlpSpanJSON, 0, len(spans))
+	for _, s := range spans {
+		s.mux.Lock()
+		span := otlpSpanJSON{
+			TraceID:           hex.EncodeToString(s.tp.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.tp.SpanID[:]),
+			Name:              s.name,
+			Kind:              int(s.kind),
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        s.attrs,
+		}
+		if s.parentID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != nil {
+			span.Status = &otlpStatus{Code: 2, Message: s.err.Error()}
+		}
+		s.mux.Unlock()
+		encoded = append(encoded, span)
+	}
+
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpKeyValue{{Key: "service.name", Value: otlpValue(t.service)}},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/haraqa/haraqa"},
+				Spans: encoded,
+			}},
+		}},
+	}
+}
+
+func otlpValue(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": v}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case int:
+		return map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": v}
+	default:
+		return map[string]interface{}{"stringValue": fmt.Sprint(v)}
+	}
+}
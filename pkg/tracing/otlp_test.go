@@ -0,0 +1,123 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestNewOTLPTracer(t *testing.T) {
+	if _, err := NewOTLPTracer("", "haraqa", time.Second); err == nil {
+		t.Error("expected endpoint error")
+	}
+	if _, err := NewOTLPTracer("http://127.0.0.1", "haraqa", 0); err == nil {
+		t.Error("expected interval error")
+	}
+}
+
+func TestOTLPTracer(t *testing.T) {
+	requests := make(chan otlpRequest, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		requests <- req
+	}))
+	defer ts.Close()
+
+	tracer, err := NewOTLPTracer(ts.URL, "haraqa-test", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing to flush
+	if err = tracer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// parent span + child span
+	ctx, parent := tracer.Start(context.Background(), "parent", SpanKindServer)
+	_, child := tracer.Start(ctx, "child", SpanKindInternal)
+	child.SetAttribute("topic", "traced")
+	child.SetAttribute("count", 2)
+	child.RecordError(errors.New("test error"))
+	child.End()
+	child.End()
+	parent.End()
+
+	// unsampled parent is not recorded
+	unsampled := ContextWithTraceParent(context.Background(), TraceParent{TraceID: NewTraceID(), SpanID: NewSpanID()})
+	_, span := tracer.Start(unsampled, "unsampled", SpanKindInternal)
+	span.End()
+
+	if err = tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-requests
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatal(req)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatal(spans)
+	}
+	if spans[0].Name != "child" || spans[1].Name != "parent" {
+		t.Fatal(spans)
+	}
+	if spans[0].Kind != int(SpanKindInternal) || spans[1].Kind != int(SpanKindServer) {
+		t.Error(spans[0].Kind, spans[1].Kind)
+	}
+	if spans[0].TraceID != spans[1].TraceID {
+		t.Error(spans[0].TraceID, spans[1].TraceID)
+	}
+	pid := parent.TraceParent().SpanID
+	if spans[0].ParentSpanID != hex.EncodeToString(pid[:]) {
+		t.Error(spans[0].ParentSpanID)
+	}
+	if spans[0].Status == nil || spans[0].Status.Message != "test error" {
+		t.Error(spans[0].Status)
+	}
+	if len(spans[0].Attributes) != 2 || spans[0].Attributes[1].Value["intValue"] != "2" {
+		t.Error(spans[0].Attributes)
+	}
+}
+
+func TestOTLPTracer_FlushError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	tracer, err := NewOTLPTracer(ts.URL, "haraqa-test", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := tracer.Start(nil, "failed", SpanKindClient) //nolint:staticcheck // testing nil context handling
+	span.End()
+	if err = tracer.Close(); err == nil {
+		t.Error("expected export error")
+	}
+}
+
+func TestOTLPValue(t *testing.T) {
+	for v, expected := range map[interface{}]string{
+		"s":          "stringValue",
+		true:         "boolValue",
+		1:            "intValue",
+		int64(1):     "intValue",
+		float64(1.5): "doubleValue",
+		uint8(1):     "stringValue",
+	} {
+		if _, ok := otlpValue(v)[expected]; !ok {
+			t.Error(v, expected)
+		}
+	}
+}
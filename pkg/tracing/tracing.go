@@ -0,0 +1,152 @@
+// Package tracing provides a minimal, dependency free tracing api used by the haraqa client and server.
+// Trace context is propagated using the W3C traceparent header and spans can be exported to any
+// OpenTelemetry collector with the OTLPTracer.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+// HeaderTraceParent is the W3C trace context header, in canonical MIME form
+const HeaderTraceParent = "Traceparent"
+
+// ErrInvalidTraceParent is returned when a traceparent header cannot be parsed
+var ErrInvalidTraceParent = errors.New("invalid traceparent")
+
+// TraceParent is a W3C trace context identifying a span within a trace
+type TraceParent struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+// ParseTraceParent parses a version 00 W3C traceparent value, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceParent(s string) (TraceParent, error) {
+	var tp TraceParent
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' || s[:2] == "ff" {
+		return tp, ErrInvalidTraceParent
+	}
+	if s[:2] == "00" && len(s) != 55 {
+		return tp, ErrInvalidTraceParent
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(tp.TraceID[:], []byte(s[3:35])); err != nil {
+		return tp, ErrInvalidTraceParent
+	}
+	if _, err := hex.Decode(tp.SpanID[:], []byte(s[36:52])); err != nil {
+		return tp, ErrInvalidTraceParent
+	}
+	if _, err := hex.Decode(flags[:], []byte(s[53:55])); err != nil {
+		return tp, ErrInvalidTraceParent
+	}
+	tp.Flags = flags[0]
+	if !tp.IsValid() {
+		return tp, ErrInvalidTraceParent
+	}
+	return tp, nil
+}
+
+// String formats the trace parent as a version 00 traceparent header value
+func (tp TraceParent) String() string {
+	var b [55]byte
+	b[0], b[1], b[2] = '0', '0', '-'
+	hex.Encode(b[3:35], tp.TraceID[:])
+	b[35] = '-'
+	hex.Encode(b[36:52], tp.SpanID[:])
+	b[52] = '-'
+	hex.Encode(b[53:55], []byte{tp.Flags})
+	return string(b[:])
+}
+
+// IsValid returns true if neither the trace id nor the span id are all zeros
+func (tp TraceParent) IsValid() bool {
+	return tp.TraceID != [16]byte{} && tp.SpanID != [8]byte{}
+}
+
+// Sampled returns true if the sampled flag is set
+func (tp TraceParent) Sampled() bool {
+	return tp.Flags&0x01 == 0x01
+}
+
+// NewTraceID returns a random trace id
+func NewTraceID() [16]byte {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+// NewSpanID returns a random span id
+func NewSpanID() [8]byte {
+	var id [8]byte
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+type traceParentKey struct{}
+
+// ContextWithTraceParent returns a copy of ctx carrying the given trace parent. Tracers use
+// the trace parent in the context as the parent of any new span
+func ContextWithTraceParent(ctx context.Context, tp TraceParent) context.Context {
+	return context.WithValue(ctx, traceParentKey{}, tp)
+}
+
+// FromContext returns the trace parent carried by the context, if any
+func FromContext(ctx context.Context) (TraceParent, bool) {
+	if ctx == nil {
+		return TraceParent{}, false
+	}
+	tp, ok := ctx.Value(traceParentKey{}).(TraceParent)
+	return tp, ok && tp.IsValid()
+}
+
+// SpanKind describes the relationship of a span to its parent and children, numbered as in OTLP
+type SpanKind int
+
+// Span kinds used by the haraqa client and server
+const (
+	// SpanKindInternal is an operation within the process, such as a queue operation
+	SpanKindInternal SpanKind = 1
+	// SpanKindServer is the handling of an incoming request
+	SpanKindServer SpanKind = 2
+	// SpanKindClient is an outgoing request
+	SpanKindClient SpanKind = 3
+)
+
+// Tracer starts spans. It mirrors the shape of the OpenTelemetry tracer so an existing
+// otel tracer can be adapted with a small wrapper
+type Tracer interface {
+	// Start begins a new span of the given kind as a child of the trace parent in ctx (if any) and
+	// returns a context carrying the new span's trace parent
+	Start(ctx context.Context, name string, kind SpanKind) (context.Context, Span)
+}
+
+// Span is a single timed operation within a trace
+type Span interface {
+	TraceParent() TraceParent
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// NoopTracer is a Tracer which records nothing. It still propagates any incoming trace parent
+type NoopTracer struct{}
+
+// Start returns the context unchanged and a span which does nothing
+func (NoopTracer) Start(ctx context.Context, _ string, _ SpanKind) (context.Context, Span) {
+	tp, _ := FromContext(ctx)
+	return ctx, noopSpan{tp: tp}
+}
+
+type noopSpan struct {
+	tp TraceParent
+}
+
+func (s noopSpan) TraceParent() TraceParent       { return s.tp }
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) RecordError(error)                {}
+func (noopSpan) End()                             {}
@@ -0,0 +1,69 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tp, err := ParseTraceParent(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tp.IsValid() || !tp.Sampled() {
+		t.Fatal(tp)
+	}
+	if tp.String() != valid {
+		t.Fatal(tp.String())
+	}
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-zzf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-zzf067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz",
+	} {
+		if _, err := ParseTraceParent(invalid); err != ErrInvalidTraceParent {
+			t.Error(invalid, err)
+		}
+	}
+
+	// future versions may append fields
+	if _, err := ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestContext(t *testing.T) {
+	if _, ok := FromContext(nil); ok { //nolint:staticcheck // testing nil context handling
+		t.Error("expected no trace parent")
+	}
+	if _, ok := FromContext(context.Background()); ok {
+		t.Error("expected no trace parent")
+	}
+
+	tp := TraceParent{TraceID: NewTraceID(), SpanID: NewSpanID(), Flags: 1}
+	ctx := ContextWithTraceParent(context.Background(), tp)
+	got, ok := FromContext(ctx)
+	if !ok || got != tp {
+		t.Error(got, tp)
+	}
+
+	// noop tracer propagates the parent
+	ctx, span := NoopTracer{}.Start(ctx, "noop", SpanKindInternal)
+	span.SetAttribute("key", "value")
+	span.RecordError(ErrInvalidTraceParent)
+	span.End()
+	if span.TraceParent() != tp {
+		t.Error(span.TraceParent(), tp)
+	}
+	if got, _ = FromContext(ctx); got != tp {
+		t.Error(got, tp)
+	}
+}